@@ -9,9 +9,32 @@ type Voter struct {
 
 type Candidate struct {
 	CandidateName string
+	ID            string // unique ID from the candidates file. empty for generated candidates
+	Race          string // race the candidate runs in. empty in an election with a single race
+	MaxVotes      uint8  // number of candidates each voter can vote for in Race. 1 if 0
+	AllowWriteIns bool   // whether voters can write in unlisted candidates in Race
+	Method        string // tally method of Race, see blockchain.MethodIRV
+}
+
+// FullName is the candidate name qualified by its race, e.g. "President/Alice"
+func (c Candidate) FullName() string {
+	if c.Race == "" {
+		return c.CandidateName
+	}
+	return c.Race + "/" + c.CandidateName
 }
 
 func CreateVoter(name string, id string) (*Wallets, error) {
+	return CreateVoterIn("", name, id)
+}
+
+// CreateVoterIn is CreateVoter for the given election. A voter has separate wallets in every election.
+func CreateVoterIn(election string, name string, id string) (*Wallets, error) {
+	return CreateVoterInDir("", election, name, id)
+}
+
+// CreateVoterInDir is CreateVoterIn keeping the wallet file under dir instead of ./tmp
+func CreateVoterInDir(dir string, election string, name string, id string) (*Wallets, error) {
 	wallets := Wallets{
 		Wallets:  make(map[string]*Wallet),
 		UserType: VoterType,
@@ -19,27 +42,43 @@ func CreateVoter(name string, id string) (*Wallets, error) {
 			VoterName: name,
 			VoterId:   id,
 		},
+		Election: election,
+		dir:      dir,
 	}
 
 	err := wallets.LoadFile()
 	if os.IsNotExist(err) {
-		wallets.SaveFile()
-		err = nil
+		err = wallets.SaveFile()
 	}
 	return &wallets, err
 }
 
 func CreateCandidate(name string) (*Wallets, error) {
+	return CreateCandidateIn("", name)
+}
+
+// CreateCandidateIn is CreateCandidate for the given election
+func CreateCandidateIn(election string, name string) (*Wallets, error) {
 	wallets := Wallets{
 		Wallets:       make(map[string]*Wallet),
 		UserType:      CandidateType,
 		CandidateData: Candidate{CandidateName: name},
+		Election:      election,
 	}
 
 	err := wallets.LoadFile()
 	if os.IsNotExist(err) {
-		wallets.SaveFile()
-		err = nil
+		err = wallets.SaveFile()
 	}
 	return &wallets, err
 }
+
+// CandidateWithAddress creates candidate wallets holding only a known address, for a candidate whose keys
+// are kept elsewhere
+func CandidateWithAddress(name string, address string) *Wallets {
+	return &Wallets{
+		Wallets:       map[string]*Wallet{address: {}},
+		UserType:      CandidateType,
+		CandidateData: Candidate{CandidateName: name},
+	}
+}
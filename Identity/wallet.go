@@ -1,13 +1,16 @@
 package Identity
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/gob"
 	"github.com/mr-tron/base58"
 	"golang.org/x/crypto/ripemd160"
 	"log"
+	"math/big"
 )
 
 type Wallet struct {
@@ -40,6 +43,48 @@ func NewWallet() *Wallet {
 	}
 }
 
+// walletGob is how a wallet is gob encoded: the curve of an ecdsa key has no exported fields, so only the
+// scalar of the key is kept and the rest is derived from it on P256
+type walletGob struct {
+	D         []byte
+	PublicKey []byte
+}
+
+func (w Wallet) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	var d []byte
+	if w.PrivateKey.D != nil {
+		d = w.PrivateKey.D.Bytes()
+	}
+	err := gob.NewEncoder(&buf).Encode(walletGob{D: d, PublicKey: w.PublicKey})
+	return buf.Bytes(), err
+}
+
+func (w *Wallet) GobDecode(data []byte) error {
+	var g walletGob
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&g); err != nil {
+		return err
+	}
+	w.PublicKey = g.PublicKey
+	w.PrivateKey = ecdsa.PrivateKey{}
+	if len(g.D) > 0 {
+		curve := elliptic.P256()
+		w.PrivateKey.Curve = curve
+		w.PrivateKey.D = new(big.Int).SetBytes(g.D)
+		w.PrivateKey.X, w.PrivateKey.Y = curve.ScalarBaseMult(g.D)
+	}
+	return nil
+}
+
+// Sign signs digest with the private key in the format blockchain.Transaction.Verify expects: R then S
+func (w Wallet) Sign(digest []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, &w.PrivateKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	return append(r.Bytes(), s.Bytes()...), nil
+}
+
 func PublicKeyHash(pubKey []byte) []byte {
 
 	// Sha256, ripemd160 for the key
@@ -72,15 +117,16 @@ func Checksum(payload []byte) []byte {
 	return secondHash[:checksumLength]
 }
 
-//func ValidateAddress(address string) bool {
-//	pubKeyHash := Base58Decode([]byte(address))
-//	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-//	version := pubKeyHash[0]
-//	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
-//	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
-//
-//	return bytes.Compare(actualChecksum, targetChecksum) == 0
-//}
+// ValidateAddress checks the version and checksum of a wallet address
+func ValidateAddress(address string) bool {
+	decoded, err := base58.Decode(address)
+	if err != nil || len(decoded) <= 1+checksumLength {
+		return false
+	}
+	actualChecksum := decoded[len(decoded)-checksumLength:]
+	versionedHash := decoded[:len(decoded)-checksumLength]
+	return versionedHash[0] == version && bytes.Compare(actualChecksum, Checksum(versionedHash)) == 0
+}
 
 func Base58Encode(input []byte) []byte {
 	return []byte(base58.Encode(input))
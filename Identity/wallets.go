@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 type Wallets struct {
@@ -15,12 +16,22 @@ type Wallets struct {
 	Wallets       map[string]*Wallet // temporally set as a slice for scalability
 	VoterData     Voter
 	CandidateData Candidate
+	Election      string // election the wallets belong to. their file is kept apart from other elections'
+
+	dir string // directory of the wallet file. walletDir if empty
+}
+
+// the curve of wallet keys is registered once up front rather than on every encode and decode, which may
+// run on many connections at once
+func init() {
+	gob.Register(elliptic.P256())
 }
 
 const (
 	VoterType     = "Vot"
 	CandidateType = "Can"
-	walletFile    = "./tmp/ws_%s.data"
+	walletDir     = "./tmp"
+	walletFile    = "ws_%s.data"
 )
 
 func (ws Wallets) SerializeDependOnType() []byte {
@@ -70,14 +81,27 @@ func (ws Wallets) GetWallet(address string) Wallet {
 	return *ws.Wallets[address]
 }
 
-func (ws *Wallets) LoadFile() error {
-
-	walletFile := fmt.Sprintf(walletFile, ws.UserType)
+// file is where the wallets are saved, in a directory of their own for an election other than the default one
+func (ws *Wallets) file() string {
+	dir := ws.dir
+	if dir == "" {
+		dir = walletDir
+	}
+	file := filepath.Join(dir, fmt.Sprintf(walletFile, ws.UserType))
 	if ws.UserType == VoterType {
-		walletFile = fmt.Sprintf(walletFile, ws.VoterData.VoterName, ws.VoterData.VoterId)
+		file = fmt.Sprintf(file, ws.VoterData.VoterName, ws.VoterData.VoterId)
 	} else if ws.UserType == CandidateType {
-		walletFile = fmt.Sprintf(walletFile, ws.CandidateData.CandidateName)
+		file = fmt.Sprintf(file, ws.CandidateData.CandidateName)
+	}
+	if ws.Election != "" {
+		file = filepath.Join(filepath.Dir(file), ws.Election, filepath.Base(file))
 	}
+	return file
+}
+
+func (ws *Wallets) LoadFile() error {
+
+	walletFile := ws.file()
 	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
 		return err
 	}
@@ -89,7 +113,6 @@ func (ws *Wallets) LoadFile() error {
 		return err
 	}
 
-	gob.Register(elliptic.P256())
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 
 	if err = decoder.Decode(&wallets); err != nil {
@@ -108,33 +131,26 @@ func (ws *Wallets) LoadFile() error {
 	return nil
 }
 
-func (ws *Wallets) SaveFile() {
+func (ws *Wallets) SaveFile() error {
 
-	walletFile := fmt.Sprintf(walletFile, ws.UserType)
-	if ws.UserType == VoterType {
-		walletFile = fmt.Sprintf(walletFile, ws.VoterData.VoterName, ws.VoterData.VoterId)
-	} else if ws.UserType == CandidateType {
-		walletFile = fmt.Sprintf(walletFile, ws.CandidateData.CandidateName)
-	}
+	walletFile := ws.file()
 
-	gob.Register(elliptic.P256())
 	var content bytes.Buffer
 	encoder := gob.NewEncoder(&content)
 
 	if err := encoder.Encode(ws); err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	if err := ioutil.WriteFile(walletFile, content.Bytes(), 0644); err != nil {
-		log.Panic(err)
+	if err := os.MkdirAll(filepath.Dir(walletFile), 0755); err != nil {
+		return err
 	}
-
+	return ioutil.WriteFile(walletFile, content.Bytes(), 0644)
 }
 
 // Encode encodes wallets to byte array
 func (ws *Wallets) Encode() []byte {
 	var buf bytes.Buffer
-	gob.Register(elliptic.P256())
 	err := gob.NewEncoder(&buf).Encode(ws)
 	if err != nil {
 		log.Println("[WARN] wallets encode error")
@@ -145,7 +161,6 @@ func (ws *Wallets) Encode() []byte {
 // DecodeToWallets decodes byte array to wallets
 func DecodeToWallets(data []byte) *Wallets {
 	wallets := Wallets{}
-	gob.Register(elliptic.P256())
 	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&wallets)
 	if err != nil {
 		log.Println("[ERROR] wallets decode error")
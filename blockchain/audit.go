@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// AuditBallot is one line of an audit export: a ballot on the longest chain. Voters appear only as a
+// commitment to their public key, so the export can be published without names or student IDs
+type AuditBallot struct {
+	Kind      string // "ballot"
+	Height    uint32
+	Block     string // hex hash of the block
+	Timestamp string // of the block, RFC 3339 in UTC
+	TxID      string
+	Race      string
+	Type      string
+	Candidate string   // candidate the ballot counts for in a plurality tally. empty for abstentions
+	Ranking   []string `json:",omitempty"`
+	Voter     string   // hex public key hash of the voter
+	Counted   bool     // false if the block is not confirmed yet or the ballot is a copy counted elsewhere
+}
+
+// AuditTally is the vote count of one candidate in the summary line of an audit export
+type AuditTally struct {
+	Race      string
+	Candidate string
+	Votes     uint
+}
+
+// AuditSummary is the last line of an audit export
+type AuditSummary struct {
+	Kind    string // "summary"
+	Height  uint32
+	Tip     string // hex hash of the last block
+	Ballots int    // ballot lines in the export
+	Counted int    // ballots counted in Tallies
+	Tallies []AuditTally
+}
+
+// WriteAudit writes the longest chain as JSON lines, one AuditBallot per ballot from genesis to the tip
+// in block order, followed by an AuditSummary with the same tallies as VotingStatus
+func (bc *BlockChain) WriteAudit(w io.Writer) error {
+	bc.mu.Lock()
+	lastHash := bc.LastHash
+	bc.mu.Unlock()
+
+	// walk from the tip like countedTxns, so the same copy of a ballot is the counted one
+	var lines []AuditBallot
+	seen := make(map[string]bool)
+	skip := bc.RequiredConfirmations()
+	iter := bc.NewIterator(lastHash)
+	for block, end := iter.Next(); !end; block, end = iter.Next() {
+		var blockLines []AuditBallot
+		for _, txn := range block.Txns {
+			counted := skip == 0 && !seen[string(txn.ID)]
+			if skip == 0 {
+				seen[string(txn.ID)] = true
+			}
+			blockLines = append(blockLines, AuditBallot{
+				Kind:      "ballot",
+				Height:    block.BlockNum,
+				Block:     hex.EncodeToString(block.Hash),
+				Timestamp: time.Unix(block.Timestamp, 0).UTC().Format(time.RFC3339),
+				TxID:      hex.EncodeToString(txn.ID),
+				Race:      txn.Data.Race,
+				Type:      txn.Data.Type,
+				Candidate: txn.Data.FirstChoice(),
+				Ranking:   txn.Data.Ranking,
+				Voter:     hex.EncodeToString(Identity.PublicKeyHash(txn.PublicKey)),
+				Counted:   counted,
+			})
+		}
+		lines = append(blockLines, lines...)
+		if skip > 0 {
+			skip--
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	summary := AuditSummary{Kind: "summary", Tip: hex.EncodeToString(lastHash), Ballots: len(lines)}
+	for _, line := range lines {
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+		if line.Counted {
+			summary.Counted++
+		}
+	}
+	if tip := bc.Get(lastHash); tip != nil {
+		summary.Height = tip.BlockNum
+	}
+	votes, _ := bc.VotingStatusAt(lastHash, bc.RequiredConfirmations())
+	for i, cand := range bc.Candidates {
+		summary.Tallies = append(summary.Tallies, AuditTally{
+			Race:      cand.CandidateData.Race,
+			Candidate: cand.CandidateData.CandidateName,
+			Votes:     votes[i],
+		})
+	}
+	return enc.Encode(summary)
+}
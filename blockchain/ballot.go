@@ -2,14 +2,67 @@ package blockchain
 
 import (
 	"log"
+	"strings"
+)
+
+// Ballot types
+const (
+	BallotCandidate = ""         // a vote for a listed candidate
+	BallotAbstain   = "abstain"  // an explicit abstention. VoterCandidate is empty
+	BallotWriteIn   = "write-in" // a vote for the unlisted candidate in VoterCandidate
+	BallotRanked    = "ranked"   // candidates in order of preference in Ranking. VoterCandidate is empty
+	BallotSealed    = "sealed"   // any of the above, encrypted in Sealed. see SealBallot
+	BallotUnseal    = "unseal"   // releases the private sealing key in Sealed after the deadline. not a vote
 )
 
 type Ballot struct {
 	VoterName      string
 	VoterStudentID string
 	VoterCandidate string
+	Race           string // race the ballot is cast in. empty in an election with a single race
+	Type           string // one of the ballot types above
+	Ranking        []string
+	Sealed         []byte // encrypted choice of a sealed ballot, or the released key of an unseal txn
+}
+
+// ExtraVotes counts the ballots of a race that are not for a listed candidate
+type ExtraVotes struct {
+	Abstain  uint
+	WriteIns map[string]uint // by written-in name, see WriteInKey
+}
+
+// WriteInKey is the name a write-in is counted under: in lower case, with single spaces between words. Names
+// written differently only in case or spacing count for the same person
+func WriteInKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// FirstChoice returns the listed candidate a ballot counts for in a plurality tally:
+// the candidate of a regular ballot or the top choice of a ranked ballot
+func (ballot *Ballot) FirstChoice() string {
+	switch ballot.Type {
+	case BallotCandidate:
+		return ballot.VoterCandidate
+	case BallotRanked:
+		if len(ballot.Ranking) > 0 {
+			return ballot.Ranking[0]
+		}
+	}
+	return ""
 }
 
 func PrintBallot(ballot *Ballot) {
+	if ballot.Type == BallotAbstain {
+		log.Printf("%s (%s) abstains %s\n", ballot.VoterName, ballot.VoterStudentID, ballot.Race)
+		return
+	}
+	if ballot.Type == BallotRanked {
+		log.Printf("%s (%s) -> %s %v\n", ballot.VoterName, ballot.VoterStudentID, ballot.Race, ballot.Ranking)
+		return
+	}
+	if ballot.Race != "" {
+		log.Printf("%s (%s) -> %s/%s\n", ballot.VoterName, ballot.VoterStudentID, ballot.Race, ballot.VoterCandidate)
+		return
+	}
 	log.Printf("%s (%s) -> %s\n", ballot.VoterName, ballot.VoterStudentID, ballot.VoterCandidate)
 }
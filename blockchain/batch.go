@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// ValidationWorkers is the number of goroutines checking blocks in PutBatch
+var ValidationWorkers = runtime.NumCPU()
+
+// PutBatch adds blocks received from peers, e.g. a chain segment during sync. The proof of work and txn
+// signatures of a block do not depend on the chain, so they are checked by a pool of workers first.
+// Blocks are then put one by one like Put, so parents must come before their children.
+func (bc *BlockChain) PutBatch(blocks []Block) []PutResult {
+	prechecked := make([]*precheckError, len(blocks))
+	zeros := bc.BlockDifficulty()
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < ValidationWorkers && w < len(blocks); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				prechecked[i] = precheck(&blocks[i], zeros)
+			}
+		}()
+	}
+	for i := range blocks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	results := make([]PutResult, len(blocks))
+	for i, block := range blocks {
+		if prechecked[i] == nil {
+			results[i] = bc.put(block, false, true)
+		} else {
+			// a block with bad txn signatures passed its proof of work, so its miner signature tells who sent it
+			signed := prechecked[i].status != PutBadPoW && bc.signedByMiner(&blocks[i])
+			results[i] = rejectSignedBlock(&blocks[i], prechecked[i].status, prechecked[i].err, signed)
+			results[i].LastHash = bc.LastHash
+		}
+	}
+	return results
+}
+
+// precheckError is why a block failed precheck
+type precheckError struct {
+	status PutStatus
+	err    error
+}
+
+// precheck checks the parts of a block that do not depend on the chain: its proof of work at the given difficulty
+// and txn signatures
+func precheck(block *Block, zeros uint8) *precheckError {
+	if !block.validProof(zeros) {
+		return &precheckError{PutBadPoW, errBadPoW}
+	}
+	for _, txn := range block.Txns {
+		if !txn.Verify() {
+			return &precheckError{PutInvalidTxn, fmt.Errorf("txn %x has an invalid signature", shortHash(txn.ID))}
+		}
+	}
+	return nil
+}
@@ -2,52 +2,191 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"fmt"
 	"log"
+	"math/big"
+	"time"
 )
 
 type Block struct {
-	PrevHash []byte
-	BlockNum uint8
-	Nonce    uint32
-	Txns     []*Transaction
-	MinerID  string
-	Hash     []byte
+	PrevHash  []byte
+	BlockNum  uint32
+	Nonce     uint32
+	Timestamp int64 // unix seconds when mining started. never before the previous block
+	Txns      []*Transaction
+	MinerID   string
+	HashAlgo  string // hash algorithm of the header, the same as the genesis block's. see HasherFor
+	Hash      []byte
+
+	MinerSignature []byte // signature of Hash by the key MinerID registered with, see SignMiner
+}
+
+// BlockHeader is a block without its transactions. Light clients keep only headers and check
+// transactions against MerkleRoot with Merkle proofs.
+type BlockHeader struct {
+	PrevHash   []byte
+	BlockNum   uint32
+	Nonce      uint32
+	MerkleRoot []byte
+	Timestamp  int64
+	MinerID    string
+	HashAlgo   string
+	Hash       []byte
+
+	MinerSignature []byte
+}
+
+// BlockBody is the transactions of a block. Headers and bodies are stored and can be fetched separately.
+type BlockBody struct {
+	Txns []*Transaction
 }
 
 // ----- Block APIs -----
 
-// Genesis makes current block a genesis block
-func (b *Block) Genesis() {
-	b.PrevHash = []byte{}
-	b.BlockNum = 0
-	b.Txns = []*Transaction{}
-	b.MinerID = "Coord"
-	// get nonce and hash from POW
-	pow := NewProof(b)
-	pow.Run()
+// Header returns the header of the block. The header of a legacy block has no MerkleRoot, see legacy
+func (b *Block) Header() BlockHeader {
+	header := BlockHeader{
+		PrevHash:  b.PrevHash,
+		BlockNum:  b.BlockNum,
+		Nonce:     b.Nonce,
+		Timestamp: b.Timestamp,
+		MinerID:   b.MinerID,
+		HashAlgo:  b.HashAlgo,
+		Hash:      b.Hash,
+
+		MinerSignature: b.MinerSignature,
+	}
+	if !b.legacy() {
+		header.MerkleRoot = MerkleRoot(b.Txns)
+	}
+	return header
+}
+
+// Body returns the body of the block
+func (b *Block) Body() BlockBody {
+	return BlockBody{Txns: b.Txns}
+}
+
+// AssembleBlock puts a header and its body back together
+func AssembleBlock(header *BlockHeader, body *BlockBody) *Block {
+	return &Block{
+		PrevHash:  header.PrevHash,
+		BlockNum:  header.BlockNum,
+		Nonce:     header.Nonce,
+		Timestamp: header.Timestamp,
+		Txns:      body.Txns,
+		MinerID:   header.MinerID,
+		HashAlgo:  header.HashAlgo,
+		Hash:      header.Hash,
+
+		MinerSignature: header.MinerSignature,
+	}
+}
+
+// SignMiner sets MinerSignature with the key of the miner. The hash covers the whole header, so the miner vouches
+// for the block and cannot deny mining it
+func (b *Block) SignMiner(key *ecdsa.PrivateKey) error {
+	signature, err := ecdsa.SignASN1(rand.Reader, key, b.Hash)
+	if err != nil {
+		return err
+	}
+	b.MinerSignature = signature
+	return nil
+}
+
+// VerifyMinerSignature checks the signature of a block hash against the public key of its miner, an
+// uncompressed P-256 point
+func VerifyMinerSignature(pubKey []byte, hash []byte, signature []byte) bool {
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubKey)
+	if x == nil {
+		return false
+	}
+	return ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, hash, signature)
 }
 
 // Encode encodes current block instance into bytes
 func (b *Block) Encode() []byte {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(b)
+	data, err := encode(b)
 	if err != nil {
 		log.Println("[WARN] block encode error")
 	}
-	return buf.Bytes()
+	return data
 }
 
-// DecodeToBlock decodes bytes to a new block instance
-func DecodeToBlock(data []byte) *Block {
+// DecodeBlock decodes bytes to a new block instance, e.g. a block received from peers. The block passes CheckShape
+func DecodeBlock(data []byte) (*Block, error) {
 	block := Block{}
-	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&block)
+	if err := decode(data, &block); err != nil {
+		return nil, err
+	}
+	if err := block.CheckShape(); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Encode encodes the header into bytes
+func (h *BlockHeader) Encode() []byte {
+	data, err := encode(h)
+	if err != nil {
+		log.Println("[WARN] block header encode error")
+	}
+	return data
+}
+
+// DecodeBlockHeader decodes bytes to a new block header instance. Stored headers of any format version are accepted
+func DecodeBlockHeader(data []byte) (*BlockHeader, error) {
+	header := BlockHeader{}
+	_, encoded := splitStored(data)
+	if err := decode(encoded, &header); err != nil {
+		return nil, fmt.Errorf("block header decode error: %v", err)
+	}
+	return &header, nil
+}
+
+// Encode encodes the body into bytes
+func (body *BlockBody) Encode() []byte {
+	data, err := encode(body)
+	if err != nil {
+		log.Println("[WARN] block body encode error")
+	}
+	return data
+}
+
+// DecodeBlockBody decodes bytes to a new block body instance. Stored bodies of any format version are accepted
+func DecodeBlockBody(data []byte) (*BlockBody, error) {
+	body := BlockBody{}
+	_, encoded := splitStored(data)
+	if err := decode(encoded, &body); err != nil {
+		return nil, fmt.Errorf("block body decode error: %v", err)
+	}
+	return &body, nil
+}
+
+// Matches checks that body holds the transactions the header commits to
+func (h *BlockHeader) Matches(body *BlockBody) bool {
+	return bytes.Equal(MerkleRoot(body.Txns), h.MerkleRoot)
+}
+
+// Validate checks that the header hashes to Hash and that Hash meets the proof of work target of NumZeros
+func (h *BlockHeader) Validate() bool {
+	return h.ValidateWithDifficulty(NumZeros)
+}
+
+// ValidateWithDifficulty is Validate with a target of the given number of leading zero bits, e.g. of
+// BlockChain.BlockDifficulty
+func (h *BlockHeader) ValidateWithDifficulty(zeros uint8) bool {
+	hasher, err := HasherFor(h.HashAlgo)
 	if err != nil {
-		log.Println("[ERROR] block decode error")
-		log.Fatal(err)
+		return false
 	}
-	return &block
+	var intHash big.Int
+	hash := hasher.Sum256(headerToBytes(h.PrevHash, h.BlockNum, h.Nonce, h.Timestamp, h.MerkleRoot, h.MinerID, h.HashAlgo))
+	intHash.SetBytes(hash[:])
+	return bytes.Equal(hash[:], h.Hash) && intHash.Cmp(targetFor(zeros)) == -1
 }
 
 // ----- Utility Functions -----
@@ -57,6 +196,7 @@ func PrintBlock(block *Block) {
 	str += fmt.Sprintf("Block #%d (%x)\n", block.BlockNum, block.Hash[:5])
 	str += fmt.Sprintf("\tPrevHash:\t %x\n", block.PrevHash[:5])
 	str += fmt.Sprintf("\tNonce:\t\t %d\n", block.Nonce)
+	str += fmt.Sprintf("\tTime:\t\t %s\n", time.Unix(block.Timestamp, 0).Format(time.RFC3339))
 	str += fmt.Sprintf("\tMinerID:\t %s\n", block.MinerID)
 	str += fmt.Sprintf("\tTxns:\t\t %d\n", len(block.Txns))
 	for _, txn := range block.Txns {
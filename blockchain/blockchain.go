@@ -7,20 +7,49 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 var LastHashKey = []byte("LastHash")
+var DeadlineKey = []byte("Deadline")
+var FinalityDepthKey = []byte("FinalityDepth")
+var DifficultyKey = []byte("Difficulty")
+var SealingKeyKey = []byte("SealingKey")
 
-const BlockKeyPrefix = "block-"
+// blocks are stored as a header and a body under separate keys
+const HeaderKeyPrefix = "header-"
+const BodyKeyPrefix = "body-"
+
+// LegacyBlockKeyPrefix keys whole blocks in databases written before headers and bodies were split. They are
+// still read until MigrateStorage splits them
+const LegacyBlockKeyPrefix = "block-"
 const NumConfirmed = 4
 
+// MaxClockDrift is how far ahead of the local clock a received block can be timestamped
+const MaxClockDrift = 2 * time.Minute
+
+// MedianTimeBlocks is how many blocks the chain time is the median timestamp of, see ChainTime
+const MedianTimeBlocks = 5
+
 type BlockChain struct {
-	mu         sync.Mutex
-	LastHash   []byte // should not be accessed without locking (unsafe). should not be accessed directly from outside
-	DB         *util.Database
-	Candidates []*Identity.Wallets
+	mu            sync.Mutex
+	LastHash      []byte // should not be accessed without locking (unsafe). should not be accessed directly from outside
+	DB            *util.Database
+	Candidates    []*Identity.Wallets
+	Deadline      time.Time                           // end of the election. no block on a chain whose ChainTime is after it can carry ballots. none if zero
+	FinalityDepth int                                 // confirmations a ballot needs to be final and counted. NumConfirmed if 0
+	Difficulty    uint8                               // leading zero bits of a block hash, the proof of work of the chain. NumZeros if 0
+	MinerKey      func(minerID string) ([]byte, bool) // key a miner registered with, false if unknown. any block if nil
+	SealingKey    []byte                              // public key ballots are sealed to, see SealBallot. not sealed if empty
+	Registration  Registration                        // who may vote, see Registration. anyone if empty
+	Strict        bool                                // re-check the invariants of the chain after every Put and panic on a violation, see CheckInvariants
+	LegacyGenesis bool                                // accept a genesis block that commits to nothing, see ErrLegacyGenesis
+	KeyPrefixes   []string                            // prefixes of the records others keep per block (prefix + hash), pruned with the block. see PruneForks
+	cache         *BlockCache
 }
 
 type ChainIterator struct {
@@ -33,35 +62,39 @@ type ChainIterator struct {
 // ----- BlockChain APIs -----
 
 func NewBlockChain(DB *util.Database, candidates []*Identity.Wallets) *BlockChain {
-	return &BlockChain{DB: DB, Candidates: candidates}
+	return &BlockChain{DB: DB, Candidates: candidates, cache: NewBlockCache(BlockCacheSize)}
 }
 
-// Init initializes the blockchain with genesis block. For coord use only.
-func (bc *BlockChain) Init() error {
+// Init initializes the blockchain with the genesis block derived from config. For coord use only.
+func (bc *BlockChain) Init(config GenesisConfig) error {
 	// check key
 	if bc.DB.KeyExist(LastHashKey) {
 		return errors.New("blockchain has already been initialized")
 	}
 
 	// generate genesis block
-	genesis := Block{}
-	genesis.Genesis()
+	genesis := *config.Block()
 
 	// store genesis block
-	err := bc.DB.PutMulti(
-		[][]byte{DBKeyForBlock(genesis.Hash), LastHashKey},
-		[][]byte{genesis.Encode(), genesis.Hash})
+	keys, values := blockKeys(&genesis)
+	keys = append(keys, LastHashKey, StorageFormatKey, HeightIndexedKey)
+	values = append(values, genesis.Hash, []byte(strconv.Itoa(BlockFormatVersion)), []byte{1})
+	err := bc.DB.PutMulti(keys, values)
 	if err != nil {
 		return err
 	}
 
 	// update last hash
 	bc.LastHash = genesis.Hash
+	bc.syncTallyIndex(genesis.Hash)
 	return nil
 }
 
-// ResumeFromDB resumes a blockchain from database. For coord use only.
+// ResumeFromDB resumes a blockchain from database without writing to it. Nodes call IndexTallies next
 func (bc *BlockChain) ResumeFromDB() error {
+	if err := bc.checkStorageFormat(); err != nil {
+		return err
+	}
 	lastHash, err := bc.DB.Get(LastHashKey)
 	if err != nil {
 		return err
@@ -69,20 +102,152 @@ func (bc *BlockChain) ResumeFromDB() error {
 
 	// update last hash
 	bc.LastHash = lastHash
+
+	// load deadline
+	if bc.DB.KeyExist(DeadlineKey) {
+		data, err := bc.DB.Get(DeadlineKey)
+		if err != nil {
+			return err
+		}
+		if err = bc.Deadline.UnmarshalBinary(data); err != nil {
+			return err
+		}
+	}
+
+	// load finality depth
+	if bc.DB.KeyExist(FinalityDepthKey) {
+		data, err := bc.DB.Get(FinalityDepthKey)
+		if err != nil {
+			return err
+		}
+		if bc.FinalityDepth, err = strconv.Atoi(string(data)); err != nil {
+			return err
+		}
+	}
+
+	// load difficulty
+	if bc.DB.KeyExist(DifficultyKey) {
+		data, err := bc.DB.Get(DifficultyKey)
+		if err != nil {
+			return err
+		}
+		difficulty, err := strconv.ParseUint(string(data), 10, 8)
+		if err != nil {
+			return err
+		}
+		bc.Difficulty = uint8(difficulty)
+	}
+
+	// load sealing key
+	if bc.DB.KeyExist(SealingKeyKey) {
+		if bc.SealingKey, err = bc.DB.Get(SealingKeyKey); err != nil {
+			return err
+		}
+	}
+
+	// load registration
+	if err = bc.loadRegistration(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// IndexTallies brings the tally index in line with the longest chain, e.g. of a database stored before the
+// index existed. Nodes call it once when they resume from their database; read-only tools do not, and count
+// tallies without the index instead
+func (bc *BlockChain) IndexTallies() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.syncTallyIndex(bc.LastHash)
+}
+
+// SetDeadline sets and stores the end of the election. A zero deadline never closes the election.
+func (bc *BlockChain) SetDeadline(deadline time.Time) error {
+	data, err := deadline.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := bc.DB.Put(DeadlineKey, data); err != nil {
+		return err
+	}
+	bc.Deadline = deadline
+	return nil
+}
+
+// SetFinalityDepth sets and stores the confirmations a ballot needs to be final. NumConfirmed if 0
+func (bc *BlockChain) SetFinalityDepth(depth int) error {
+	if err := bc.DB.Put(FinalityDepthKey, []byte(strconv.Itoa(depth))); err != nil {
+		return err
+	}
+	bc.FinalityDepth = depth
+	return nil
+}
+
+// RequiredConfirmations returns the confirmations a ballot needs to be final and counted
+func (bc *BlockChain) RequiredConfirmations() int {
+	if bc.FinalityDepth == 0 {
+		return NumConfirmed
+	}
+	return bc.FinalityDepth
+}
+
+// SetDifficulty sets and stores the leading zero bits of a block hash. NumZeros if 0
+func (bc *BlockChain) SetDifficulty(zeros uint8) error {
+	if err := bc.DB.Put(DifficultyKey, []byte(strconv.Itoa(int(zeros)))); err != nil {
+		return err
+	}
+	bc.Difficulty = zeros
+	return nil
+}
+
+// BlockDifficulty returns the leading zero bits a block hash needs, see NewProofWithDifficulty
+func (bc *BlockChain) BlockDifficulty() uint8 {
+	if bc.Difficulty == 0 {
+		return NumZeros
+	}
+	return bc.Difficulty
+}
+
+// Closed checks whether timestamp (unix seconds) is after the deadline
+func (bc *BlockChain) Closed(timestamp int64) bool {
+	return !bc.Deadline.IsZero() && time.Unix(timestamp, 0).After(bc.Deadline)
+}
+
+// ChainTime returns the median timestamp of the block with the given hash and its ancestors, MedianTimeBlocks
+// blocks at most. The election is closed for the blocks on top of a block whose chain time is after the
+// deadline: a miner picks the timestamp of its own block, up to MaxClockDrift ahead, but not the median of the
+// blocks before it, so no single miner can close the election early or keep it open
+func (bc *BlockChain) ChainTime(hash []byte) int64 {
+	var timestamps []int64
+	iter := bc.NewIterator(hash)
+	for len(timestamps) < MedianTimeBlocks {
+		header, end := iter.NextHeader()
+		timestamps = append(timestamps, header.Timestamp)
+		if end {
+			break
+		}
+	}
+	return medianTime(timestamps)
+}
+
 // ResumeFromEncodedData resumes a blockchain from byte data. For miner use only.
 func (bc *BlockChain) ResumeFromEncodedData(blocks [][]byte, lastHash []byte) error {
 	// save last hash & every block to DB
 	// (all blocks are assumed valid)
 	var keys [][]byte
+	var values [][]byte
 	for _, blockBytes := range blocks {
-		block := DecodeToBlock(blockBytes)
-		keys = append(keys, DBKeyForBlock(block.Hash))
+		block, err := DecodeBlock(blockBytes)
+		if err != nil {
+			return err
+		}
+		newKeys, newValues := blockKeys(block)
+		keys = append(keys, newKeys...)
+		values = append(values, newValues...)
 	}
-	keys = append(keys, LastHashKey)
-	values := append(blocks, lastHash)
+	keys = append(keys, LastHashKey, StorageFormatKey, HeightIndexedKey)
+	values = append(values, lastHash, []byte(strconv.Itoa(BlockFormatVersion)), []byte{1})
 	err := bc.DB.PutMulti(keys, values)
 	if err != nil {
 		return err
@@ -90,6 +255,7 @@ func (bc *BlockChain) ResumeFromEncodedData(blocks [][]byte, lastHash []byte) er
 
 	// update last hash
 	bc.LastHash = lastHash
+	bc.syncTallyIndex(lastHash)
 	return nil
 }
 
@@ -101,166 +267,406 @@ func (bc *BlockChain) GetLastHash() []byte {
 }
 
 // Encode encodes all the blocks in the blockchain into a 2D byte array.
-func (bc *BlockChain) Encode() ([][]byte, []byte) {
+func (bc *BlockChain) Encode() ([][]byte, []byte, error) {
 	// lock to ensure block data and last hash consistency
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
 
-	blocks, err := bc.DB.GetAllWithPrefix(BlockKeyPrefix)
+	var blocks [][]byte
+	err := bc.export(nil, func(hash []byte, data []byte) error {
+		blocks = append(blocks, data)
+		return nil
+	})
 	if err != nil {
-		log.Println("[ERROR] Unable to fetch all block data from database:")
-		log.Fatal(err)
+		return nil, nil, fmt.Errorf("unable to fetch all block data from database: %v", err)
+	}
+	return blocks, bc.LastHash[:], nil
+}
+
+// EncodeRange encodes the blocks with block numbers in [fromHeight, toHeight], forks included, except the
+// ones whose hashes are known by the caller. Used to transfer the chain in chunks, each read from the height
+// index once the database is indexed.
+func (bc *BlockChain) EncodeRange(fromHeight, toHeight uint32, knownHashes [][]byte) ([][]byte, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	known := make(map[string]bool)
+	for _, hash := range knownHashes {
+		known[string(hash)] = true
+	}
+	var blocks [][]byte
+	if bc.DB.KeyExist(HeightIndexedKey) {
+		for height := int(fromHeight); height <= int(toHeight); height++ {
+			for _, hash := range bc.hashesAt(uint32(height)) {
+				if known[string(hash)] {
+					continue
+				}
+				block := bc.Get(hash)
+				if block == nil {
+					return nil, fmt.Errorf("unable to fetch block data from database: block %x is indexed but not stored", hash)
+				}
+				blocks = append(blocks, block.Encode())
+			}
+		}
+		return blocks, nil
+	}
+	err := bc.exportHeaders(known, func(hash []byte, header *BlockHeader) error {
+		if header.BlockNum < fromHeight || header.BlockNum > toHeight {
+			return nil
+		}
+		body := bc.GetBody(hash)
+		if body == nil {
+			return fmt.Errorf("block %x has no body", hash)
+		}
+		blocks = append(blocks, AssembleBlock(header, body).Encode())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch block data from database: %v", err)
+	}
+	return blocks, nil
+}
+
+// Hashes returns the hashes of every stored block, forks included
+func (bc *BlockChain) Hashes() ([][]byte, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	var hashes [][]byte
+	err := bc.exportHeaders(nil, func(hash []byte, header *BlockHeader) error {
+		hashes = append(hashes, header.Hash)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch block headers from database: %v", err)
+	}
+	return hashes, nil
+}
+
+// Export streams every stored block (hash and encoded data) to fn without loading the whole chain into memory.
+// Returns the last hash at the time of export.
+func (bc *BlockChain) Export(fn func(hash []byte, data []byte) error) ([]byte, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.LastHash[:], bc.export(nil, fn)
+}
+
+// VerifyStored checks that every stored block is keyed by its own hash, has a body matching its header,
+// and carries a valid proof of work, as of the version that mined it
+func (bc *BlockChain) VerifyStored() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.exportHeaders(nil, func(hash []byte, header *BlockHeader) error {
+		if bytes.Compare(hash, header.Hash) != 0 {
+			return fmt.Errorf("block %x is stored under a mismatched key %x", header.Hash, hash)
+		}
+		body := bc.GetBody(hash)
+		if body == nil {
+			return fmt.Errorf("block #%d (%x) has no body", header.BlockNum, header.Hash)
+		}
+		matches := header.Matches(body)
+		if len(header.MerkleRoot) == 0 {
+			// legacy. its proof of work commits to the txns, and its metadata has their root once migrated
+			meta, err := bc.storedMeta(hash)
+			if err != nil {
+				return err
+			}
+			matches = validLegacyProof(header, body) &&
+				(meta.TxnRoot == nil || bytes.Equal(meta.TxnRoot, MerkleRoot(body.Txns)))
+		}
+		if !matches {
+			return fmt.Errorf("block #%d (%x) has a body that does not match its header", header.BlockNum, header.Hash)
+		}
+		if header.BlockNum == 0 {
+			return nil // genesis is created locally
+		}
+		if !header.ValidateWithDifficulty(bc.BlockDifficulty()) && !validLegacyProof(header, body) {
+			return fmt.Errorf("block #%d (%x) has invalid proof of work", header.BlockNum, header.Hash)
+		}
+		return nil
+	})
+}
+
+// INTERNAL USE ONLY
+func (bc *BlockChain) export(skip map[string]bool, fn func(hash []byte, data []byte) error) error {
+	return bc.exportHeaders(skip, func(hash []byte, header *BlockHeader) error {
+		body := bc.GetBody(hash)
+		if body == nil {
+			return fmt.Errorf("block %x has no body", hash)
+		}
+		return fn(hash, AssembleBlock(header, body).Encode())
+	})
+}
+
+// INTERNAL USE ONLY
+func (bc *BlockChain) exportHeaders(skip map[string]bool, fn func(hash []byte, header *BlockHeader) error) error {
+	iter := bc.DB.NewIterator(HeaderKeyPrefix)
+	defer iter.Close()
+	for iter.Next() {
+		hash := iter.Key()[len(HeaderKeyPrefix):]
+		if skip[string(hash)] {
+			continue
+		}
+		data, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		header, err := DecodeBlockHeader(data)
+		if err != nil {
+			return fmt.Errorf("block %x: %v", hash, err)
+		}
+		if err = fn(hash, header); err != nil {
+			return err
+		}
+	}
+	return bc.exportLegacyHeaders(skip, fn)
+}
+
+// exportLegacyHeaders is exportHeaders for the blocks stored whole under LegacyBlockKeyPrefix
+func (bc *BlockChain) exportLegacyHeaders(skip map[string]bool, fn func(hash []byte, header *BlockHeader) error) error {
+	iter := bc.DB.NewIterator(LegacyBlockKeyPrefix)
+	defer iter.Close()
+	for iter.Next() {
+		hash := iter.Key()[len(LegacyBlockKeyPrefix):]
+		// split already by an interrupted migration
+		if skip[string(hash)] || bc.DB.KeyExist(DBKeyForHeader(hash)) {
+			continue
+		}
+		data, err := iter.Value()
+		if err != nil {
+			return err
+		}
+		block := Block{}
+		if err = decode(data, &block); err != nil {
+			return fmt.Errorf("legacy block %x: %v", hash, err)
+		}
+		header := block.Header()
+		if err = fn(hash, &header); err != nil {
+			return err
+		}
 	}
-	return blocks, bc.LastHash[:]
+	return nil
 }
 
 // Exist returns if a block exists in the blockchain
 func (bc *BlockChain) Exist(hash []byte) bool {
-	key := DBKeyForBlock(hash)
-	return bc.DB.KeyExist(key)
+	return bc.DB.KeyExist(DBKeyForHeader(hash)) || bc.DB.KeyExist(DBKeyForLegacyBlock(hash))
 }
 
-// Get gets a block by hash
+// Get gets a block by hash. The returned block may be shared with the cache and should not be modified. It is
+// nil if the block is unknown or cannot be read
 func (bc *BlockChain) Get(hash []byte) *Block {
-	data, err := bc.DB.Get(DBKeyForBlock(hash))
+	if block, ok := bc.cache.Get(hash); ok {
+		return block
+	}
+	data, err := bc.DB.GetMulti([][]byte{DBKeyForHeader(hash), DBKeyForBody(hash)})
+	if err != nil {
+		if block := bc.legacyBlock(hash); block != nil {
+			bc.cache.Add(block)
+			return block
+		}
+		bc.readFailed(hash, err)
+		return nil
+	}
+	header, err := DecodeBlockHeader(data[0])
 	if err != nil {
-		log.Println("[ERROR] Unable to fetch the block from DB:")
-		log.Fatal(err)
+		bc.readFailed(hash, err)
+		return nil
 	}
-	block := DecodeToBlock(data)
+	body, err := DecodeBlockBody(data[1])
+	if err != nil {
+		bc.readFailed(hash, err)
+		return nil
+	}
+	block := AssembleBlock(header, body)
+	bc.cache.Add(block)
 	return block
 }
 
-// Put adds a new block to the blockchain
-func (bc *BlockChain) Put(block Block, owned bool) (success bool, newTxns []*Transaction, oldTxns []*Transaction) {
+// GetHeader gets the header of a block by hash without decoding its transactions. It is nil if the block is
+// unknown or cannot be read
+func (bc *BlockChain) GetHeader(hash []byte) *BlockHeader {
+	data, err := bc.DB.Get(DBKeyForHeader(hash))
+	if err != nil {
+		if block := bc.legacyBlock(hash); block != nil {
+			header := block.Header()
+			return &header
+		}
+		bc.readFailed(hash, err)
+		return nil
+	}
+	header, err := DecodeBlockHeader(data)
+	if err != nil {
+		bc.readFailed(hash, err)
+		return nil
+	}
+	return header
+}
+
+// readFailed logs why a stored block could not be read. Unknown blocks are not logged, callers may ask for any
+func (bc *BlockChain) readFailed(hash []byte, err error) {
+	if bc.Exist(hash) {
+		log.Printf("[ERROR] Unable to read block %x from DB: %v\n", shortHash(hash), err)
+	}
+}
+
+// GetBody gets the body of a block by hash. It is nil if the block is unknown or cannot be read
+func (bc *BlockChain) GetBody(hash []byte) *BlockBody {
+	if block, ok := bc.cache.Get(hash); ok {
+		body := block.Body()
+		return &body
+	}
+	data, err := bc.DB.Get(DBKeyForBody(hash))
+	if err != nil {
+		if block := bc.legacyBlock(hash); block != nil {
+			body := block.Body()
+			return &body
+		}
+		return nil
+	}
+	body, err := DecodeBlockBody(data)
+	if err != nil {
+		bc.readFailed(hash, err)
+		return nil
+	}
+	return body
+}
+
+// legacyBlock reads a block stored whole under LegacyBlockKeyPrefix. nil if there is none or it cannot be read
+func (bc *BlockChain) legacyBlock(hash []byte) *Block {
+	data, err := bc.DB.Get(DBKeyForLegacyBlock(hash))
+	if err != nil {
+		return nil
+	}
+	block := Block{}
+	if err = decode(data, &block); err != nil {
+		log.Printf("[ERROR] Unable to decode legacy block %x: %v\n", shortHash(hash), err)
+		return nil
+	}
+	return &block
+}
+
+// CacheStats returns the hit and miss counters of the block cache
+func (bc *BlockChain) CacheStats() (hits uint64, misses uint64) {
+	return bc.cache.Stats()
+}
+
+// Put adds a new block to the blockchain. the result tells whether the block was added and, if not, why
+func (bc *BlockChain) Put(block Block, owned bool) PutResult {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
+	return bc.put(block, owned, false)
+}
 
+// INTERNAL USE ONLY. the proof of work and txn signatures of a prechecked block are not checked again
+func (bc *BlockChain) put(block Block, owned bool, prechecked bool) PutResult {
 	// sanity check
 	if len(block.PrevHash) == 0 || block.BlockNum == 0 || len(block.Hash) == 0 || len(block.MinerID) == 0 {
-		log.Println("[WARN] Block has missing values and will not be added to the chain.")
-		success = false
-		return
-	}
-	if !bc.Exist(block.PrevHash) {
-		log.Printf("[WARN] Previous block (%x) does not exist and "+
-			"the block (%x) will not be added to the chain.\n", block.PrevHash[:5], block.Hash[:5])
-		success = false
-		return
+		return rejectBlock(&block, PutInvalid, errMissingValues)
 	}
 	if bc.Exist(block.Hash) {
-		log.Println("[WARN] Block already exists and will not be added to the chain.")
-		success = false
-		return
+		return rejectBlock(&block, PutDuplicate, errors.New("block already exists"))
 	}
 
-	// validate
+	// validate what needs no parent first, so only blocks of a registered miner are kept as orphans
+	var minerKey []byte
 	if !owned {
+		// validate miner. its ID is covered by the proof of work
+		if bc.MinerKey != nil {
+			var known bool
+			if minerKey, known = bc.MinerKey(block.MinerID); !known || len(minerKey) == 0 {
+				return rejectBlock(&block, PutInvalid, fmt.Errorf("mined by unregistered miner %q", block.MinerID))
+			}
+		}
 		// validate pow
-		pow := NewProof(&block)
-		if !pow.Validate() {
-			log.Println("invalid pow")
-			success = false
-			return
+		if !prechecked && !block.validProof(bc.BlockDifficulty()) {
+			return rejectBlock(&block, PutBadPoW, errBadPoW)
+		}
+		// validate the miner's signature
+		if bc.MinerKey != nil && !VerifyMinerSignature(minerKey, block.Hash, block.MinerSignature) {
+			return rejectBlock(&block, PutInvalid, fmt.Errorf("not signed by miner %q", block.MinerID))
+		}
+	}
+	if !bc.Exist(block.PrevHash) {
+		return rejectBlock(&block, PutOrphan, fmt.Errorf("previous block (%x) does not exist", shortHash(block.PrevHash)))
+	}
+
+	// validate
+	if !owned {
+		// from here on the block is the miner's doing if its signature was checked
+		signed := minerKey != nil
+		// validate timestamp
+		if time.Unix(block.Timestamp, 0).After(time.Now().Add(MaxClockDrift)) {
+			return rejectSignedBlock(&block, PutInvalid, errors.New("timestamped in the future"), signed)
+		}
+		parent := bc.GetHeader(block.PrevHash)
+		if err := bc.checkTimestamp(&block, parent.Timestamp, bc.ChainTime(block.PrevHash)); err != nil {
+			return rejectSignedBlock(&block, PutInvalid, err, signed)
+		}
+		// validate hash algorithm. it is set by the genesis block for the whole chain
+		if block.HashAlgo != parent.HashAlgo {
+			return rejectSignedBlock(&block, PutInvalid, fmt.Errorf("hashed with %q, not %q as its chain", block.HashAlgo, parent.HashAlgo), signed)
 		}
 		// validate txns (use the chain that the block is on, not necessarily the longest)
-		for _, valid := range bc._ValidateTxns(block.Txns, false, block.PrevHash) {
+		for i, valid := range bc._ValidateTxns(block.Txns, false, block.PrevHash, prechecked) {
 			if !valid {
-				log.Println("invalid txns")
-				success = false
-				return
+				return rejectSignedBlock(&block, PutInvalidTxn, fmt.Errorf("txn %x is invalid", shortHash(block.Txns[i].ID)), signed)
 			}
 		}
-		//for _, txn := range block.Txns {
-		//	if !bc._ValidateTxn(txn, false) {
-		//		success = false
-		//		return
-		//	}
-		//}
+	}
+
+	// a block that makes its fork the longest chain needs every block of the fork down to the fork point
+	var path *forkPath
+	if !bytes.Equal(block.PrevHash, bc.LastHash) && block.BlockNum > bc.GetHeader(bc.LastHash).BlockNum {
+		var err error
+		if path, err = bc.forkPath(bc.LastHash, block.PrevHash); err != nil {
+			return rejectBlock(&block, PutOrphan, err)
+		}
 	}
 
 	// save to db
-	err := bc.DB.Put(DBKeyForBlock(block.Hash), block.Encode())
+	err := bc.DB.PutMulti(blockKeys(&block))
 	if err != nil {
-		log.Println("[ERROR] Unable to save the block:")
-		log.Fatal(err)
+		return rejectBlock(&block, PutFailed, fmt.Errorf("unable to save the block: %v", err))
 	}
 
 	// check chain
+	result := PutResult{Status: PutExtended}
 	if bytes.Compare(block.PrevHash, bc.LastHash) == 0 {
 		err = bc.DB.Put(LastHashKey, block.Hash)
 		if err != nil {
-			log.Println("[ERROR] Unable to save last hash:")
-			log.Fatal(err)
+			return rejectBlock(&block, PutFailed, fmt.Errorf("unable to save last hash: %v", err))
 		}
 		bc.LastHash = block.Hash
-	} else {
-		// possible new fork, check length
-		if block.BlockNum > bc.Get(bc.LastHash).BlockNum {
-			// switch fork (newTxns and oldTxns won't be nil when switching to a new fork, but the length may be zero)
-			newTxns, oldTxns = bc.CheckoutFork(block.Hash)
-		}
-	}
-	success = true
-	return
-}
-
-// CheckoutFork checks out a different fork and returns any difference between two forks. internal use only
-func (bc *BlockChain) CheckoutFork(lastHashNew []byte) (newTxns []*Transaction, oldTxns []*Transaction) {
-	// NOTE: this function will not acquire lock and therefore can only be called internally.
-	//bc.mu.Lock()
-	//defer bc.mu.Unlock()
-
-	if bytes.Compare(lastHashNew, bc.LastHash) == 0 {
-		log.Println("[WARN] Attempting to checkout the same fork")
-		return
-	}
-
-	iterNew, iterOld := bc.NewIterator(lastHashNew), bc.NewIterator(bc.LastHash)
-	var blockHashesNew [][]byte
-	var blockHashesOld [][]byte
-
-	// collect all block hashes
-	for block, end := iterNew.Next(); !end; block, end = iterNew.Next() {
-		blockHashesNew = append([][]byte{block.Hash}, blockHashesNew...)
-	}
-	for block, end := iterOld.Next(); !end; block, end = iterOld.Next() {
-		blockHashesOld = append([][]byte{block.Hash}, blockHashesOld...)
-	}
-
-	// find first different
-	i := 0
-	for ; i < int(math.Min(float64(len(blockHashesNew)), float64(len(blockHashesOld)))); i++ {
-		if bytes.Compare(blockHashesNew[i], blockHashesOld[i]) != 0 {
-			break
-		}
-	}
-
-	// collect txns
-	newTxns = []*Transaction{}
-	for _, hash := range blockHashesNew[i:] {
-		block := bc.Get(hash)
-		for _, txn := range block.Txns {
-			newTxns = append(newTxns, txn)
+		bc.syncTallyIndex(block.Hash)
+	} else if block.BlockNum > bc.GetHeader(bc.LastHash).BlockNum {
+		// switch fork (newTxns and oldTxns won't be nil when switching to a new fork, but the length may be zero)
+		result.Status = PutSwitchedFork
+		result.NewTxns, result.OldTxns = []*Transaction{}, []*Transaction{}
+		var detached [][]*Transaction
+		path.attached = append([][]byte{block.Hash}, path.attached...)
+		result.TallyCorrections, err = bc.switchFork(block.Hash, path, func(b *Block, attached bool) {
+			if attached {
+				result.NewTxns = append(result.NewTxns, b.Txns...)
+			} else {
+				detached = append(detached, b.Txns)
+			}
+		})
+		if err != nil {
+			return rejectBlock(&block, PutFailed, err)
 		}
-	}
-	oldTxns = []*Transaction{}
-	for _, hash := range blockHashesOld[i:] {
-		block := bc.Get(hash)
-		for _, txn := range block.Txns {
-			oldTxns = append(oldTxns, txn)
+		// old txns in chain order, as new ones
+		for i := len(detached) - 1; i >= 0; i-- {
+			result.OldTxns = append(result.OldTxns, detached[i]...)
 		}
+	} else {
+		result.Status = PutStaleFork
+		log.Printf("[INFO] Block (%x) is added to a fork that is not the longest chain.\n", shortHash(block.Hash))
 	}
-
-	// set last hash
-	err := bc.DB.Put(LastHashKey, lastHashNew)
-	if err != nil {
-		log.Println("[ERROR] Unable to save last hash:")
-		log.Fatal(err)
+	if bc.Strict {
+		bc.assertInvariants(&block)
 	}
-	bc.LastHash = lastHashNew
-
-	return newTxns, oldTxns
+	result.LastHash = bc.LastHash
+	return result
 }
 
 // NewIterator returns a chain iterator
@@ -273,35 +679,84 @@ func (bc *BlockChain) NewIterator(hash []byte) *ChainIterator {
 	}
 }
 
+// TxnCheck is the outcome of validating a txn against a chain, check by check
+type TxnCheck struct {
+	ValidSignature bool  // signed by the key in the txn
+	Candidate      bool  // signed by a candidate, who cannot vote
+	ValidBallot    bool  // for a race and candidates of the election, or an allowed abstention or write-in
+	Duplicate      bool  // already on the chain or pending
+	Voted          bool  // the voter has cast all the ballots the race allows
+	OtherElection  bool  // signed for a chain with a different genesis block
+	Unregistered   bool  // without a valid credential in an election with registration, see Registration
+	Err            error // the first failed check. nil if the txn is valid
+}
+
+// fail records the first failed check
+func (check *TxnCheck) fail(err error) {
+	if check.Err == nil {
+		check.Err = err
+	}
+}
+
+// CheckTxn validates a txn on the longest chain like ValidateTxn, with pending txns counted as if they were
+// on it, and reports the result of every check instead of stopping at the first failure
+func (bc *BlockChain) CheckTxn(txn *Transaction, pending []*Transaction) TxnCheck {
+	return bc.checkTxn(txn, true, nil, pending, false)
+}
+
 // INTERNAL USE ONLY
-func (bc *BlockChain) _ValidateTxn(txn *Transaction, lock bool, fork []byte) bool {
-	// when fork is nil, default to validate on the longest chain
-	// 1. verify signature
-	if !txn.Verify() {
-		log.Println("txn has invalid signature")
+func (bc *BlockChain) _ValidateTxn(txn *Transaction, lock bool, fork []byte, pending []*Transaction, verified bool) bool {
+	check := bc.checkTxn(txn, lock, fork, pending, verified)
+	if check.Err != nil {
+		log.Println(check.Err)
 		log.Println(txn.Data, fmt.Sprintf("%x, %x", txn.Signature, txn.PublicKey))
-		return false
+	}
+	return check.Err == nil
+}
+
+func (bc *BlockChain) checkTxn(txn *Transaction, lock bool, fork []byte, pending []*Transaction, verified bool) (check TxnCheck) {
+	// when fork is nil, default to validate on the longest chain
+	// pending are txns accepted before txn that are not on the chain yet
+	// 0. check size and encoding. nothing else can be checked without it
+	if err := txn.CheckShape(); err != nil {
+		check.fail(err)
+		return
+	}
+	// 1. verify signature (unless already verified)
+	check.ValidSignature = verified || txn.Verify()
+	if !check.ValidSignature {
+		check.fail(errors.New("txn has invalid signature"))
 	}
 	// 2. validate data
-	validCand := false
 	for _, cand := range bc.Candidates {
 		// 2.1 candidates cannot vote
-		if bytes.Compare(txn.PublicKey, cand.Wallets[cand.GetAddress()].PublicKey) == 0 {
-			log.Println("candidates cannot vote")
-			log.Println(txn.Data)
-			return false
-		}
-		// 2.2 voter can only vote for candidates
-		if txn.Data.VoterCandidate == cand.CandidateData.CandidateName {
-			validCand = true
+		address := cand.GetAddress()
+		candKey := cand.Wallets[address].PublicKey
+		if (len(candKey) > 0 && bytes.Compare(txn.PublicKey, candKey) == 0) ||
+			(len(candKey) == 0 && string((Identity.Wallet{PublicKey: txn.PublicKey}).Address()) == address) {
+			check.Candidate = true
+			check.fail(errors.New("candidates cannot vote"))
 		}
 	}
-	if !validCand {
-		log.Println("voter can only vote for candidates")
-		log.Println(txn.Data)
-		return false
+	// 2.2 voter can only vote for candidates of the race, abstain, or write in when the race allows it. an
+	// unseal txn must release the sealing key of the chain
+	var race *Identity.Candidate
+	var err error
+	if txn.Unseals() {
+		_, err = bc.unsealKey(txn)
+	} else {
+		race, err = bc.checkBallot(txn.Data)
+	}
+	check.ValidBallot = err == nil
+	if err != nil {
+		check.fail(err)
+	}
+	// 2.2.1 in an election with registration, only registered voters vote
+	if err = bc.checkCredential(txn); err != nil {
+		check.Unregistered = true
+		check.fail(err)
 	}
-	// 2.3: voter can only vote once
+	// 2.3: voter can only vote as many times as the race allows
 	var iter *ChainIterator
 	if lock && fork == nil {
 		bc.mu.Lock()
@@ -315,37 +770,50 @@ func (bc *BlockChain) _ValidateTxn(txn *Transaction, lock bool, fork []byte) boo
 		}
 	}
 
-	for block, end := iter.Next(); !end; block, end = iter.Next() {
+	earlier := pending
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		if end {
+			// 2.5: ballots are signed for one chain and cannot be replayed into another
+			if !bytes.Equal(txn.Genesis, block.Hash) {
+				check.OtherElection = true
+				check.fail(fmt.Errorf("txn is for genesis block %x, not %x", txn.Genesis, block.Hash))
+			}
+			break
+		}
 		for _, pastTxn := range block.Txns {
 			if bytes.Compare(pastTxn.PublicKey, txn.PublicKey) == 0 {
-				log.Println("voter has voted")
-				log.Println(txn.Data)
-				return false
+				earlier = append(earlier, pastTxn)
 			}
 		}
 	}
-	return true
+	// 2.4: a txn can only be mined once, even if the client resubmitted it
+	for _, pastTxn := range earlier {
+		if bytes.Compare(pastTxn.ID, txn.ID) == 0 {
+			check.Duplicate = true
+			check.fail(fmt.Errorf("txn %x is already on the chain", txn.ID))
+			return
+		}
+	}
+	if race != nil && conflicts(txn, race.MaxVotes, earlier) {
+		check.Voted = true
+		check.fail(errors.New("voter has voted"))
+	}
+	return
 }
 
 // INTERNAL USE ONLY
-func (bc *BlockChain) _ValidateTxns(txns []*Transaction, lock bool, fork []byte) (res []bool) {
+func (bc *BlockChain) _ValidateTxns(txns []*Transaction, lock bool, fork []byte, verified bool) (res []bool) {
 	// check conflicting txns (first received wins)
 	// NOTE: txns should be sorted by when they were received. earlier txns should appear in front
 	// when fork is nil, default to validate on the longest chain
 	if lock {
 		bc.mu.Lock()
 	}
-	voterMap := make(map[string]bool)
+	var accepted []*Transaction
 	for _, txn := range txns {
-		if voterMap[fmt.Sprintf("%x", txn.PublicKey)] {
-			res = append(res, false)
-			log.Println("voter has voted in the same block")
-			log.Println(txn.Data)
-		} else {
-			res = append(res, bc._ValidateTxn(txn, false, fork))
-			if res[len(res)-1] {
-				voterMap[fmt.Sprintf("%x", txn.PublicKey)] = true
-			}
+		res = append(res, bc._ValidateTxn(txn, false, fork, accepted, verified))
+		if res[len(res)-1] {
+			accepted = append(accepted, txn)
 		}
 	}
 	if lock {
@@ -355,12 +823,12 @@ func (bc *BlockChain) _ValidateTxns(txns []*Transaction, lock bool, fork []byte)
 }
 
 func (bc *BlockChain) ValidateTxn(txn *Transaction) bool {
-	return bc._ValidateTxn(txn, true, nil)
+	return bc._ValidateTxn(txn, true, nil, nil, false)
 }
 
 // ValidateTxns validates a set of transactions and deal with conflicting transactions among them
 func (bc *BlockChain) ValidateTxns(txns []*Transaction) (res []bool) {
-	res = bc._ValidateTxns(txns, true, nil)
+	res = bc._ValidateTxns(txns, true, nil, false)
 	return
 }
 
@@ -386,41 +854,276 @@ func (bc *BlockChain) TxnStatus(txid []byte) int {
 	return res
 }
 
-func (bc *BlockChain) VotingStatus() (votes []uint, txns []Transaction) {
-	for i := 0; i < len(bc.Candidates); i++ {
-		votes = append(votes, 0)
+// GetByHeight returns the block with the given block number on the longest chain, or nil if the chain is shorter
+func (bc *BlockChain) GetByHeight(height uint32) *Block {
+	bc.mu.Lock()
+	iter := bc.NewIterator(bc.LastHash)
+	bc.mu.Unlock()
+	for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+		if header.BlockNum == height {
+			return bc.Get(header.Hash)
+		}
+		if end || header.BlockNum < height {
+			return nil
+		}
 	}
+}
+
+// FindTxn looks up a transaction on the longest chain. numConfirmed is -1 when the txn is not found
+func (bc *BlockChain) FindTxn(txid []byte) (txn *Transaction, block *Block, numConfirmed int) {
 	bc.mu.Lock()
 	iter := bc.NewIterator(bc.LastHash)
 	bc.mu.Unlock()
-	skip := NumConfirmed // last NUM_CONFIRMED blocks do not count
+	for block, end := iter.Next(); !end; block, end = iter.Next() {
+		for _, txn := range block.Txns {
+			if bytes.Compare(txn.ID, txid) == 0 {
+				return txn, block, iter.Index
+			}
+		}
+	}
+	return nil, nil, -1
+}
+
+// Headers returns the headers of the blocks on the longest chain with a block number of at least fromHeight, genesis first
+func (bc *BlockChain) Headers(fromHeight uint32) []BlockHeader {
+	bc.mu.Lock()
+	iter := bc.NewIterator(bc.LastHash)
+	bc.mu.Unlock()
+	var headers []BlockHeader
+	for header, end := iter.NextHeader(); header.BlockNum >= fromHeight; header, end = iter.NextHeader() {
+		headers = append([]BlockHeader{*header}, headers...)
+		if end {
+			break
+		}
+	}
+	return headers
+}
+
+// TxnProof looks up a transaction on the longest chain and returns it with the hash of its block and
+// its Merkle proof. txn is nil when the txn is not found
+func (bc *BlockChain) TxnProof(txid []byte) (txn *Transaction, blockHash []byte, proof MerkleProof) {
+	txn, block, _ := bc.FindTxn(txid)
+	if txn == nil {
+		return nil, nil, proof
+	}
+	for idx, tx := range block.Txns {
+		if tx == txn {
+			proof = NewMerkleProof(block.Txns, idx)
+		}
+	}
+	return txn, block.Hash, proof
+}
+
+// FindTxnsByVoter returns the transactions on the longest chain signed by the voter with the given
+// public key hash, with their blocks and the number of blocks that confirm them, newest first
+func (bc *BlockChain) FindTxnsByVoter(pubKeyHash []byte) (txns []*Transaction, blocks []*Block, numConfirmed []int) {
+	bc.mu.Lock()
+	iter := bc.NewIterator(bc.LastHash)
+	bc.mu.Unlock()
+	for block, end := iter.Next(); !end; block, end = iter.Next() {
+		for _, txn := range block.Txns {
+			if bytes.Compare(Identity.PublicKeyHash(txn.PublicKey), pubKeyHash) == 0 {
+				txns = append(txns, txn)
+				blocks = append(blocks, block)
+				numConfirmed = append(numConfirmed, iter.Index)
+			}
+		}
+	}
+	return
+}
+
+// VerifyChain checks every block on the longest chain: links, block numbers, proof of work and transactions
+func (bc *BlockChain) VerifyChain() error {
+	bc.mu.Lock()
+	iter := bc.NewIterator(bc.LastHash)
+	bc.mu.Unlock()
+
+	// collect the chain from genesis to tip
+	var blocks []*Block
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		if block == nil {
+			return fmt.Errorf("block %x on the longest chain cannot be read", shortHash(iter.CurrentHash))
+		}
+		blocks = append([]*Block{block}, blocks...)
+		if end {
+			break
+		}
+	}
+
+	voters := make(map[string][]*Transaction) // all txns of each voter
+	for i, block := range blocks {
+		if i == 0 {
+			continue // genesis
+		}
+		prev := blocks[i-1]
+		if bytes.Compare(block.PrevHash, prev.Hash) != 0 {
+			return fmt.Errorf("block #%d (%x) does not link to its predecessor", block.BlockNum, block.Hash)
+		}
+		if block.BlockNum != prev.BlockNum+1 {
+			return fmt.Errorf("block #%d (%x) follows block #%d", block.BlockNum, block.Hash, prev.BlockNum)
+		}
+		if block.HashAlgo != prev.HashAlgo {
+			return fmt.Errorf("block #%d (%x) is hashed with %q, not %q", block.BlockNum, block.Hash, block.HashAlgo, prev.HashAlgo)
+		}
+		if !block.validProof(bc.BlockDifficulty()) {
+			return fmt.Errorf("block #%d (%x) has invalid proof of work", block.BlockNum, block.Hash)
+		}
+		var timestamps []int64
+		for j := i - 1; j >= 0 && j >= i-MedianTimeBlocks; j-- {
+			timestamps = append(timestamps, blocks[j].Timestamp)
+		}
+		if err := bc.checkTimestamp(block, prev.Timestamp, medianTime(timestamps)); err != nil {
+			return fmt.Errorf("block #%d (%x): %v", block.BlockNum, block.Hash, err)
+		}
+		for _, txn := range block.Txns {
+			if err := txn.CheckShape(); err != nil {
+				return fmt.Errorf("txn %x in block #%d: %v", txn.ID, block.BlockNum, err)
+			}
+			if !txn.Verify() {
+				return fmt.Errorf("txn %x in block #%d has invalid signature", txn.ID, block.BlockNum)
+			}
+			if !bytes.Equal(txn.Genesis, blocks[0].Hash) {
+				return fmt.Errorf("txn %x in block #%d is for another election", txn.ID, block.BlockNum)
+			}
+			if err := bc.checkCredential(txn); err != nil {
+				return fmt.Errorf("txn %x in block #%d: %v", txn.ID, block.BlockNum, err)
+			}
+			// the unseal txn releases the sealing key and is not a ballot
+			if txn.Unseals() {
+				if _, err := bc.unsealKey(txn); err != nil {
+					return fmt.Errorf("txn %x in block #%d: %v", txn.ID, block.BlockNum, err)
+				}
+				continue
+			}
+			race, err := bc.checkBallot(txn.Data)
+			if err != nil {
+				return fmt.Errorf("txn %x in block #%d: %v", txn.ID, block.BlockNum, err)
+			}
+			if conflicts(txn, race.MaxVotes, voters[string(txn.PublicKey)]) {
+				return fmt.Errorf("txn %x in block #%d is an extra vote by the same voter", txn.ID, block.BlockNum)
+			}
+			voters[string(txn.PublicKey)] = append(voters[string(txn.PublicKey)], txn)
+		}
+	}
+	return nil
+}
+
+func (bc *BlockChain) VotingStatus() (votes []uint, txns []Transaction) {
+	bc.mu.Lock()
+	lastHash := bc.LastHash
+	bc.mu.Unlock()
+	return bc.VotingStatusAt(lastHash, bc.RequiredConfirmations())
+}
+
+// VotingStatusAt is VotingStatus of the chain ending at the block with the given hash, counting ballots with
+// at least depth confirmations. A depth of 0 counts every ballot on the chain
+func (bc *BlockChain) VotingStatusAt(lastHash []byte, depth int) (votes []uint, txns []Transaction) {
+	for i := 0; i < len(bc.Candidates); i++ {
+		votes = append(votes, 0)
+	}
+	for _, txn := range bc.countedTxns(lastHash, depth) {
+		txns = append(txns, *txn)
+		if idx := bc.candidateIndex(txn); idx >= 0 {
+			votes[idx]++
+		}
+	}
+	return
+}
+
+// countedTxns returns the txns that count towards the tally of the chain ending at the block with the given
+// hash: the last depth blocks do not count, and each txn counts once even if copies of it were mined
+func (bc *BlockChain) countedTxns(lastHash []byte, depth int) (txns []*Transaction) {
+	seen := make(map[string]bool)
+	confirmations := make(map[string]int) // of the unseal txns
+	iter := bc.NewIterator(lastHash)
+	skip := depth
 	for block, end := iter.Next(); !end; block, end = iter.Next() {
 		if skip > 0 {
 			skip--
 			continue
 		}
 		for _, txn := range block.Txns {
-			txns = append(txns, *txn)
-			for idx, cand := range bc.Candidates {
-				if txn.Data.VoterCandidate == cand.CandidateData.CandidateName {
-					votes[idx]++
-					break
+			if !seen[string(txn.ID)] {
+				seen[string(txn.ID)] = true
+				txns = append(txns, txn)
+				if txn.Unseals() {
+					confirmations[string(txn.ID)] = iter.Index
 				}
 			}
 		}
 	}
+	if len(bc.SealingKey) > 0 {
+		txns = bc.openTxns(txns, confirmations)
+	}
 	return
 }
 
+// CountedTxnsAt returns the txns that count towards the tally of the chain ending at the block with the given
+// hash, newest first, with sealed ballots opened. Like VotingStatusAt, the last depth blocks do not count
+func (bc *BlockChain) CountedTxnsAt(lastHash []byte, depth int) []*Transaction {
+	return bc.countedTxns(lastHash, depth)
+}
+
+// RunoffAt runs an instant-runoff tally for every IRV race on the chain ending at the block with the given
+// hash. Like VotingStatusAt, the last depth blocks do not count
+func (bc *BlockChain) RunoffAt(lastHash []byte, depth int) map[string]RunoffResult {
+	candidates := make(map[string][]string) // of IRV races
+	for _, cand := range bc.Candidates {
+		if cand.CandidateData.Method == MethodIRV {
+			candidates[cand.CandidateData.Race] = append(candidates[cand.CandidateData.Race], cand.CandidateData.CandidateName)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	rankings := make(map[string][][]string)
+	for _, txn := range bc.countedTxns(lastHash, depth) {
+		if txn.Data.Type == BallotRanked {
+			rankings[txn.Data.Race] = append(rankings[txn.Data.Race], txn.Data.Ranking)
+		}
+	}
+	results := make(map[string]RunoffResult)
+	for race, names := range candidates {
+		results[race] = InstantRunoff(names, rankings[race])
+	}
+	return results
+}
+
+// ExtraVotesAt counts abstentions and write-in votes of each race on the chain ending at the block
+// with the given hash. Like VotingStatusAt, the last depth blocks do not count
+func (bc *BlockChain) ExtraVotesAt(lastHash []byte, depth int) map[string]*ExtraVotes {
+	extras := make(map[string]*ExtraVotes)
+	for _, txn := range bc.countedTxns(lastHash, depth) {
+		addExtraVote(extras, txn)
+	}
+	return extras
+}
+
 // ----- ChainIterator APIs -----
 
+// Next returns the current block and moves to its parent. end is set at the genesis block, and with a nil
+// block if the chain has a block that cannot be read
 func (iter *ChainIterator) Next() (block *Block, end bool) {
 	block = iter.BlockChain.Get(iter.CurrentHash)
+	if block == nil {
+		return nil, true
+	}
 	iter.CurrentHash = block.PrevHash
 	iter.Index++
 	return block, block.BlockNum == 0
 }
 
+// NextHeader is Next without decoding the transactions of the block
+func (iter *ChainIterator) NextHeader() (header *BlockHeader, end bool) {
+	header = iter.BlockChain.GetHeader(iter.CurrentHash)
+	if header == nil {
+		return nil, true
+	}
+	iter.CurrentHash = header.PrevHash
+	iter.Index++
+	return header, header.BlockNum == 0
+}
+
 func (iter *ChainIterator) Reset() {
 	iter.CurrentHash = iter.LastHash
 	iter.Index = -1
@@ -428,7 +1131,161 @@ func (iter *ChainIterator) Reset() {
 
 // ----- Utility functions -----
 
-// DBKeyForBlock returns the database key for a given block hash by concatenating prefix and hash.
-func DBKeyForBlock(blockHash []byte) []byte {
-	return bytes.Join([][]byte{[]byte(BlockKeyPrefix), blockHash}, []byte{})
+// checkBallot checks a ballot against the candidates of its race and returns the rules of the race. Only the
+// race of a sealed ballot can be checked, and every ballot must be sealed if the chain has a sealing key
+func (bc *BlockChain) checkBallot(ballot *Ballot) (*Identity.Candidate, error) {
+	if (len(bc.SealingKey) > 0) != (ballot.Type == BallotSealed) {
+		if ballot.Type == BallotSealed {
+			return nil, ErrNotSealed
+		}
+		return nil, errors.New("ballots of the election must be sealed")
+	}
+	if ballot.Type == BallotSealed {
+		for _, cand := range bc.Candidates {
+			if cand.CandidateData.Race == ballot.Race {
+				if ballot.VoterCandidate != "" || len(ballot.Ranking) > 0 {
+					return nil, errors.New("sealed ballot names a candidate")
+				}
+				return &cand.CandidateData, nil
+			}
+		}
+		return nil, errors.New("no such race: " + ballot.Race)
+	}
+	return bc.checkChoice(ballot)
+}
+
+// checkChoice is checkBallot of a ballot that is not sealed, or an opened one
+func (bc *BlockChain) checkChoice(ballot *Ballot) (*Identity.Candidate, error) {
+	var race *Identity.Candidate
+	listed := make(map[string]bool)
+	for _, cand := range bc.Candidates {
+		if cand.CandidateData.Race == ballot.Race {
+			race = &cand.CandidateData
+			listed[cand.CandidateData.CandidateName] = true
+		}
+	}
+	if race == nil {
+		return nil, errors.New("no such race: " + ballot.Race)
+	}
+	if race.Method == MethodIRV && ballot.Type != BallotRanked && ballot.Type != BallotAbstain {
+		return nil, errors.New("race only takes ranked ballots")
+	}
+	if ballot.Type != BallotRanked && len(ballot.Ranking) > 0 {
+		return nil, errors.New("only ranked ballots have a ranking")
+	}
+	switch ballot.Type {
+	case BallotCandidate:
+		if !listed[ballot.VoterCandidate] {
+			return nil, errors.New("voter can only vote for candidates")
+		}
+	case BallotAbstain:
+		if ballot.VoterCandidate != "" {
+			return nil, errors.New("abstention names a candidate")
+		}
+	case BallotWriteIn:
+		if !race.AllowWriteIns {
+			return nil, errors.New("write-ins are disabled")
+		}
+		if strings.TrimSpace(ballot.VoterCandidate) == "" {
+			return nil, errors.New("write-in without a name")
+		}
+		for name := range listed {
+			if WriteInKey(ballot.VoterCandidate) == WriteInKey(name) {
+				return nil, errors.New("write-in of a listed candidate")
+			}
+		}
+	case BallotRanked:
+		if race.Method != MethodIRV {
+			return nil, errors.New("race does not take ranked ballots")
+		}
+		if ballot.VoterCandidate != "" || len(ballot.Ranking) == 0 {
+			return nil, errors.New("ranked ballot without a ranking")
+		}
+		ranked := make(map[string]bool)
+		for _, name := range ballot.Ranking {
+			if !listed[name] || ranked[name] {
+				return nil, errors.New("ranking has an unknown or repeated candidate")
+			}
+			ranked[name] = true
+		}
+	default:
+		return nil, errors.New("unknown ballot type " + ballot.Type)
+	}
+	return race, nil
+}
+
+// checkTimestamp checks that a block is not timestamped before its predecessor, that it carries no
+// ballots if the chain time of its predecessor (see ChainTime) is after the deadline, and no unseal txn before
+func (bc *BlockChain) checkTimestamp(block *Block, prevTimestamp int64, chainTime int64) error {
+	if block.Timestamp < prevTimestamp {
+		return errors.New("timestamped before the previous block")
+	}
+	closed := bc.Closed(chainTime)
+	for _, txn := range block.Txns {
+		if closed && !txn.Unseals() {
+			return errors.New("carries ballots after the election deadline")
+		}
+		if !closed && txn.Unseals() {
+			return errors.New("releases the sealing key before the election deadline")
+		}
+	}
+	return nil
+}
+
+// medianTime returns the median of timestamps, the later one of the two in the middle of an even number
+func medianTime(timestamps []int64) int64 {
+	sorted := append([]int64(nil), timestamps...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// conflicts checks txn against earlier txns of the same voter. In each race, a voter can either
+// abstain or vote for maxVotes (1 if 0) different candidates, write-ins included
+func conflicts(txn *Transaction, maxVotes uint8, earlier []*Transaction) bool {
+	if maxVotes == 0 {
+		maxVotes = 1
+	}
+	count := 0
+	for _, past := range earlier {
+		if bytes.Compare(past.PublicKey, txn.PublicKey) != 0 || past.Data.Race != txn.Data.Race {
+			continue
+		}
+		if past.Data.Type == BallotAbstain || txn.Data.Type == BallotAbstain {
+			return true
+		}
+		// sealed ballots hide their candidate. conflicts between them are found when they are opened
+		if txn.Data.Type != BallotSealed && past.Data.Type == txn.Data.Type && past.Data.VoterCandidate == txn.Data.VoterCandidate {
+			return true
+		}
+		count++
+	}
+	return count >= int(maxVotes)
+}
+
+// DBKeyForHeader returns the database key for the header of a given block by concatenating prefix and hash.
+func DBKeyForHeader(blockHash []byte) []byte {
+	return bytes.Join([][]byte{[]byte(HeaderKeyPrefix), blockHash}, []byte{})
+}
+
+// DBKeyForLegacyBlock returns the database key a whole block was stored under before headers and bodies were split
+func DBKeyForLegacyBlock(blockHash []byte) []byte {
+	return bytes.Join([][]byte{[]byte(LegacyBlockKeyPrefix), blockHash}, []byte{})
+}
+
+// DBKeyForBody returns the database key for the body of a given block by concatenating prefix and hash.
+func DBKeyForBody(blockHash []byte) []byte {
+	return bytes.Join([][]byte{[]byte(BodyKeyPrefix), blockHash}, []byte{})
+}
+
+// blockKeys returns the database keys and values that store a new block: its header, body, metadata and entry
+// in the height index
+func blockKeys(block *Block) (keys [][]byte, values [][]byte) {
+	header, body, meta := block.Header(), block.Body(), NewBlockMeta()
+	if len(header.MerkleRoot) == 0 {
+		meta.TxnRoot = MerkleRoot(block.Txns)
+	}
+	keys = [][]byte{DBKeyForHeader(block.Hash), DBKeyForBody(block.Hash), DBKeyForBlockMeta(block.Hash),
+		DBKeyForHeight(block.BlockNum, block.Hash)}
+	values = [][]byte{storedValue(header.Encode()), storedValue(body.Encode()), meta.Encode(), block.Hash}
+	return
 }
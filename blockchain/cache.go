@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const BlockCacheSize = 256
+
+// BlockCache is an LRU cache of decoded blocks keyed by block hash.
+// Cached blocks are shared and must not be modified by callers.
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is the most recently used
+	entries  map[string]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+func NewBlockCache(capacity int) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached block with the given hash
+func (c *BlockCache) Get(hash []byte) (*Block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.MoveToFront(elem)
+		atomic.AddUint64(&c.hits, 1)
+		return elem.Value.(*Block), true
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return nil, false
+}
+
+// Add puts a block into the cache and evicts the least recently used one if the cache is full
+func (c *BlockCache) Add(block *Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := string(block.Hash)
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(block)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, string(oldest.Value.(*Block).Hash))
+	}
+}
+
+// Remove drops a block from the cache
+func (c *BlockCache) Remove(hash []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[string(hash)]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, string(hash))
+	}
+}
+
+// Stats returns the number of cache hits and misses so far
+func (c *BlockCache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Txns are identified and committed to by a canonical encoding instead of their gob encoding, whose bytes
+// depend on gob's type registration and on nil and empty values encoding differently. Fields are written
+// in a fixed order, each prefixed with its length as a uvarint:
+//
+//	version | VoterName | VoterStudentID | VoterCandidate | Race | Type | len(Ranking) | Ranking... | PublicKey | Genesis
+//
+// and Sealed at the end in version 3, which only sealed ballots and unseal txns use, so other txns keep their IDs.
+// Version 4, for txns with a Credential, ends with Sealed (empty if the ballot is not sealed) and Credential. All
+// are followed by ID and Signature in the full encoding. The ID of a txn is the SHA-256 of the encoding
+// without them. Genesis binds the signature to one chain, so a ballot cannot be replayed into another
+// election or test run with the same candidates.
+
+const TxnEncodingVersion = 2
+
+// SealedTxnEncodingVersion is the encoding version of txns with a Sealed field
+const SealedTxnEncodingVersion = 3
+
+// CredentialTxnEncodingVersion is the encoding version of txns with a Credential
+const CredentialTxnEncodingVersion = 4
+
+// limits on the fields of a txn. strings are limited in bytes
+const (
+	MaxVoterNameLen = 64
+	MaxStudentIDLen = 16
+	MaxCandidateLen = 64 // of VoterCandidate, Race and each Ranking entry
+	MaxRankingLen   = 32 // candidates in a ranking
+	MaxPublicKeyLen = 64 // X and Y of a P-256 key
+	MaxSignatureLen = 64 // R and S of a P-256 signature
+	GenesisHashLen  = 32 // SHA-256
+	MaxTxnSize      = 1024
+)
+
+// limits on the fields of a block received from peers
+const (
+	MaxBlockTxns   = 255 // MaxTxn is a uint8
+	BlockHashLen   = 32  // SHA-256 or BLAKE2b-256
+	MaxMinerIDLen  = 64
+	MaxHashAlgoLen = 16
+	MaxMinerSigLen = 72 // ASN.1 P-256 signature
+)
+
+var (
+	ErrTxnTooLarge     = errors.New("txn is too large")
+	ErrNonCanonicalTxn = errors.New("txn is not canonical")
+)
+
+// CanonicalEncoding returns the canonical encoding of the txn, ID and signature included
+func (tx *Transaction) CanonicalEncoding() []byte {
+	buf := tx.appendUnsigned(nil)
+	buf = appendField(buf, tx.ID)
+	return appendField(buf, tx.Signature)
+}
+
+// appendUnsigned appends the canonical encoding of the txn without ID and signature
+func (tx *Transaction) appendUnsigned(buf []byte) []byte {
+	ballot := tx.Data
+	if ballot == nil {
+		ballot = &Ballot{}
+	}
+	if len(tx.Credential) > 0 {
+		buf = append(buf, CredentialTxnEncodingVersion)
+	} else if len(ballot.Sealed) > 0 {
+		buf = append(buf, SealedTxnEncodingVersion)
+	} else {
+		buf = append(buf, TxnEncodingVersion)
+	}
+	for _, field := range []string{ballot.VoterName, ballot.VoterStudentID, ballot.VoterCandidate, ballot.Race, ballot.Type} {
+		buf = appendField(buf, []byte(field))
+	}
+	buf = appendUvarint(buf, uint64(len(ballot.Ranking)))
+	for _, name := range ballot.Ranking {
+		buf = appendField(buf, []byte(name))
+	}
+	buf = appendField(buf, tx.PublicKey)
+	buf = appendField(buf, tx.Genesis)
+	if len(tx.Credential) > 0 {
+		buf = appendField(buf, ballot.Sealed)
+		buf = appendField(buf, tx.Credential)
+	} else if len(ballot.Sealed) > 0 {
+		buf = appendField(buf, ballot.Sealed)
+	}
+	return buf
+}
+
+func appendField(buf []byte, field []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(field)))
+	return append(buf, field...)
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+// CheckShape checks the size and form of a txn before anything else about it: field lengths, well-formed
+// text and an ID matching its content. Txns failing it are rejected from the memory pool and from blocks.
+func (tx *Transaction) CheckShape() error {
+	if tx.Data == nil {
+		return fmt.Errorf("%w: no ballot", ErrNonCanonicalTxn)
+	}
+	if err := tx.Data.CheckFields(); err != nil {
+		return err
+	}
+	if len(tx.PublicKey) == 0 || len(tx.PublicKey) > MaxPublicKeyLen {
+		return fmt.Errorf("%w: public key of %d bytes", ErrNonCanonicalTxn, len(tx.PublicKey))
+	}
+	if len(tx.Signature) == 0 || len(tx.Signature) > MaxSignatureLen {
+		return fmt.Errorf("%w: signature of %d bytes", ErrNonCanonicalTxn, len(tx.Signature))
+	}
+	if len(tx.Genesis) != GenesisHashLen {
+		return fmt.Errorf("%w: genesis hash of %d bytes", ErrNonCanonicalTxn, len(tx.Genesis))
+	}
+	if len(tx.Credential) > MaxCredentialLen {
+		return fmt.Errorf("%w: credential of %d bytes", ErrNonCanonicalTxn, len(tx.Credential))
+	}
+	if size := len(tx.CanonicalEncoding()); size > MaxTxnSize {
+		return fmt.Errorf("%w: %d bytes", ErrTxnTooLarge, size)
+	}
+	if !bytes.Equal(tx.ID, tx.ComputeID()) {
+		return fmt.Errorf("%w: ID does not match its content", ErrNonCanonicalTxn)
+	}
+	return nil
+}
+
+// CheckFields checks the length and form of the fields of a ballot. It is the part of CheckShape that clients run
+// before signing, so that a ballot they sign is never rejected for its shape
+func (b *Ballot) CheckFields() error {
+	err := checkText("voter name", b.VoterName, MaxVoterNameLen)
+	if err == nil {
+		err = checkText("student ID", b.VoterStudentID, MaxStudentIDLen)
+	}
+	if err == nil {
+		err = checkText("candidate", b.VoterCandidate, MaxCandidateLen)
+	}
+	if err == nil {
+		err = checkText("race", b.Race, MaxCandidateLen)
+	}
+	if err == nil {
+		err = checkText("ballot type", b.Type, MaxCandidateLen)
+	}
+	for i := 0; err == nil && i < len(b.Ranking); i++ {
+		err = checkText("ranked candidate", b.Ranking[i], MaxCandidateLen)
+	}
+	if err != nil {
+		return err
+	}
+	if len(b.Sealed) > MaxSealedLen {
+		return fmt.Errorf("%w: sealed field of %d bytes", ErrTxnTooLarge, len(b.Sealed))
+	}
+	if (len(b.Sealed) > 0) != (b.Type == BallotSealed || b.Type == BallotUnseal) {
+		return fmt.Errorf("%w: sealed field on a ballot of type %q", ErrNonCanonicalTxn, b.Type)
+	}
+	if len(b.Ranking) > MaxRankingLen {
+		return fmt.Errorf("%w: more than %d ranked candidates", ErrTxnTooLarge, MaxRankingLen)
+	}
+	return nil
+}
+
+// checkText checks that a field is at most maxLen bytes of valid UTF-8 without control characters
+func checkText(name string, value string, maxLen int) error {
+	if len(value) > maxLen {
+		return fmt.Errorf("%w: %s longer than %d bytes", ErrTxnTooLarge, name, maxLen)
+	}
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("%w: %s is not valid UTF-8", ErrNonCanonicalTxn, name)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: %s has control characters", ErrNonCanonicalTxn, name)
+		}
+	}
+	return nil
+}
+
+// CheckShape checks the size and form of a decoded block before anything else about it, so that a crafted block
+// cannot make its receiver dereference a nil txn or hold huge fields. Txns are checked further when the block is put
+func (b *Block) CheckShape() error {
+	if len(b.Txns) > MaxBlockTxns {
+		return fmt.Errorf("block has %d txns, at most %d are allowed", len(b.Txns), MaxBlockTxns)
+	}
+	for i, txn := range b.Txns {
+		if txn == nil || txn.Data == nil {
+			return fmt.Errorf("txn %d of the block has no ballot", i)
+		}
+	}
+	if len(b.Hash) != BlockHashLen || len(b.PrevHash) > BlockHashLen || (b.BlockNum > 0 && len(b.PrevHash) != BlockHashLen) {
+		return errors.New("block has a hash or previous hash of the wrong length")
+	}
+	if len(b.MinerID) > MaxMinerIDLen || len(b.HashAlgo) > MaxHashAlgoLen || len(b.MinerSignature) > MaxMinerSigLen {
+		return errors.New("block has an oversized miner ID, hash algorithm or miner signature")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"sync"
+)
+
+// pooled buffers and readers for gob encoding blocks. Encoding a full chain or syncing one decodes
+// and encodes every block, and allocating a fresh buffer each time dominated profiles.
+var (
+	bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	readerPool = sync.Pool{New: func() interface{} { return new(bytes.Reader) }}
+)
+
+// register the gob types up front so that their encoders are compiled once at startup rather than
+// by whichever goroutine first encodes a block
+func init() {
+	gob.Register(&Block{})
+	gob.Register(&BlockHeader{})
+	gob.Register(&BlockBody{})
+	gob.Register(&Transaction{})
+	gob.Register(&Ballot{})
+}
+
+// encode gob-encodes v with a pooled buffer and returns a copy of the encoded bytes
+func encode(v interface{}) ([]byte, error) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufferPool.Put(buf)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data, nil
+}
+
+// decode gob-decodes data into v with a pooled reader
+func decode(data []byte, v interface{}) error {
+	r := readerPool.Get().(*bytes.Reader)
+	r.Reset(data)
+	defer func() {
+		r.Reset(nil)
+		readerPool.Put(r)
+	}()
+	return gob.NewDecoder(r).Decode(v)
+}
+
+// EncodeTo writes the gob encoding of the block to w without an intermediate copy
+func (b *Block) EncodeTo(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(b)
+}
+
+// EncodeTo writes the gob encoding of the header to w without an intermediate copy
+func (h *BlockHeader) EncodeTo(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(h)
+}
+
+// EncodeTo writes the gob encoding of the body to w without an intermediate copy
+func (body *BlockBody) EncodeTo(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(body)
+}
+
+// DecodeBlockFrom reads a gob-encoded block from r
+func DecodeBlockFrom(r io.Reader) (*Block, error) {
+	block := Block{}
+	if err := gob.NewDecoder(r).Decode(&block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
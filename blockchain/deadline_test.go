@@ -0,0 +1,42 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlineByChainTime(t *testing.T) {
+	if got := medianTime([]int64{50, 10, 40, 20, 30}); got != 30 {
+		t.Errorf("median of 5 timestamps: %d, want 30", got)
+	}
+	if got := medianTime([]int64{10, 20}); got != 20 {
+		t.Errorf("median of 2 timestamps: %d, want 20", got)
+	}
+
+	deadline := time.Unix(1000, 0)
+	bc := &BlockChain{Deadline: deadline}
+	ballot := &Transaction{Data: &Ballot{VoterName: "voter", VoterStudentID: "12345678"}}
+	unseal := &Transaction{Data: &Ballot{Type: BallotUnseal}}
+	for _, tc := range []struct {
+		name      string
+		timestamp int64
+		chainTime int64
+		txn       *Transaction
+		valid     bool
+	}{
+		{"ballot before the deadline", 900, 800, ballot, true},
+		// the miner's clock runs ahead: it cannot close the election on its own
+		{"ballot timestamped after the deadline on an open chain", 1100, 990, ballot, true},
+		{"ballot on a closed chain", 1001, 1001, ballot, false},
+		{"unseal on an open chain", 1100, 990, unseal, false},
+		{"unseal on a closed chain", 1100, 1050, unseal, true},
+	} {
+		block := &Block{Timestamp: tc.timestamp, Txns: []*Transaction{tc.txn}}
+		if err := bc.checkTimestamp(block, 0, tc.chainTime); (err == nil) != tc.valid {
+			t.Errorf("%s: %v, want valid %v", tc.name, err, tc.valid)
+		}
+	}
+	if err := bc.checkTimestamp(&Block{Timestamp: 900}, 901, 800); err == nil {
+		t.Error("block timestamped before its parent is valid")
+	}
+}
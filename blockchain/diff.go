@@ -0,0 +1,115 @@
+package blockchain
+
+import (
+	"sort"
+)
+
+// ChainDiff is how the longest chains of two copies of an election, A and B, differ
+type ChainDiff struct {
+	Common     *BlockHeader  // last block both longest chains share. nil if they do not share a genesis block
+	OnlyA      []BlockHeader // blocks of A's longest chain after Common, oldest first
+	OnlyB      []BlockHeader // blocks of B's longest chain after Common, oldest first
+	MissingInB [][]byte      // IDs of txns on A's longest chain and not on B's
+	MissingInA [][]byte      // IDs of txns on B's longest chain and not on A's
+	TallyDiffs []TallyDiff   // candidates whose votes differ, sorted by race and candidate
+	TipA, TipB BlockHeader
+}
+
+// TallyDiff is the votes of a candidate on both chains. Every ballot on the longest chain counts
+type TallyDiff struct {
+	Race      string
+	Candidate string // first choice of the ballots. empty for abstentions
+	VotesA    uint
+	VotesB    uint
+}
+
+// Same checks whether the longest chains are the same
+func (d *ChainDiff) Same() bool {
+	return d.Common != nil && len(d.OnlyA) == 0 && len(d.OnlyB) == 0
+}
+
+// DiffChains compares the longest chains of a and b block by block, txn by txn and by tally. Tallies are counted
+// from the ballots themselves, so a copy without a candidate list (e.g. a miner's database) can be compared too
+func DiffChains(a *BlockChain, b *BlockChain) *ChainDiff {
+	headersA := a.longestHeaders()
+	headersB := b.longestHeaders()
+	diff := &ChainDiff{TipA: headersA[len(headersA)-1], TipB: headersB[len(headersB)-1]}
+
+	onA := make(map[string]bool)
+	for _, header := range headersA {
+		onA[string(header.Hash)] = true
+	}
+	common := -1
+	for i := len(headersB) - 1; i >= 0; i-- {
+		if onA[string(headersB[i].Hash)] {
+			common = i
+			break
+		}
+	}
+	if common >= 0 {
+		diff.Common = &headersB[common]
+		diff.OnlyA = headersA[diff.Common.BlockNum+1:]
+		diff.OnlyB = headersB[common+1:]
+	} else {
+		diff.OnlyA, diff.OnlyB = headersA, headersB
+	}
+
+	txnsA := a.countedTxns(diff.TipA.Hash, 0)
+	txnsB := b.countedTxns(diff.TipB.Hash, 0)
+	diff.MissingInB = missingTxns(txnsA, txnsB)
+	diff.MissingInA = missingTxns(txnsB, txnsA)
+
+	tallyA, tallyB := tallyByName(txnsA), tallyByName(txnsB)
+	for key := range tallyB {
+		if _, ok := tallyA[key]; !ok {
+			tallyA[key] = 0
+		}
+	}
+	for key, votes := range tallyA {
+		if votes != tallyB[key] {
+			diff.TallyDiffs = append(diff.TallyDiffs, TallyDiff{Race: key[0], Candidate: key[1], VotesA: votes, VotesB: tallyB[key]})
+		}
+	}
+	sort.Slice(diff.TallyDiffs, func(i, j int) bool {
+		if diff.TallyDiffs[i].Race != diff.TallyDiffs[j].Race {
+			return diff.TallyDiffs[i].Race < diff.TallyDiffs[j].Race
+		}
+		return diff.TallyDiffs[i].Candidate < diff.TallyDiffs[j].Candidate
+	})
+	return diff
+}
+
+// longestHeaders returns the headers of the longest chain, indexed by block number
+func (bc *BlockChain) longestHeaders() []BlockHeader {
+	var headers []BlockHeader
+	iter := bc.NewIterator(bc.GetLastHash())
+	for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+		headers = append([]BlockHeader{*header}, headers...)
+		if end {
+			return headers
+		}
+	}
+}
+
+// missingTxns returns the IDs of the txns of from that are not in to
+func missingTxns(from []*Transaction, to []*Transaction) (missing [][]byte) {
+	known := make(map[string]bool)
+	for _, txn := range to {
+		known[string(txn.ID)] = true
+	}
+	for _, txn := range from {
+		if !known[string(txn.ID)] {
+			missing = append(missing, txn.ID)
+		}
+	}
+	return
+}
+
+// tallyByName counts the first choices of txns by race and candidate name
+func tallyByName(txns []*Transaction) map[[2]string]uint {
+	tally := make(map[[2]string]uint)
+	for _, txn := range txns {
+		tally[[2]string{txn.Data.Race, txn.Data.FirstChoice()}]++
+	}
+	return tally
+}
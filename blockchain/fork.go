@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// A fork switch walks back from the old and the new tip to their common ancestor, header by header and the
+// higher one first, so it reads as many blocks as the reorg is deep instead of both chains down to genesis.
+// The blocks it detaches and attaches are handed to a ForkVisitor one at a time, which Put collects the txns of
+// for PutResult.NewTxns and OldTxns, and every block of the new fork is checked to be stored before anything
+// changes: a fork with a block missing is not switched to, and the block that would have switched to it is an
+// orphan, so miners fetch the missing blocks from coord
+
+// ForkVisitor is called with every block a fork switch detaches from the longest chain, from the old tip down,
+// then with every block it attaches, from the fork point up
+type ForkVisitor func(block *Block, attached bool)
+
+// MissingBlockError is why a fork cannot be switched to: one of its blocks is not stored
+type MissingBlockError struct {
+	Hash   []byte
+	Height uint32 // of the missing block. 0 if it is the tip asked for
+}
+
+func (e *MissingBlockError) Error() string {
+	return fmt.Sprintf("block #%d (%x) of the fork is not stored", e.Height, shortHash(e.Hash))
+}
+
+// forkPath is the way from one tip to another through their common ancestor
+type forkPath struct {
+	ancestor []byte
+	detached [][]byte // blocks after the ancestor on the way to the old tip, old tip first
+	attached [][]byte // blocks after the ancestor on the way to the new tip, new tip first
+}
+
+// forkPath walks back from both tips to their common ancestor. Called with bc.mu held
+func (bc *BlockChain) forkPath(oldTip, newTip []byte) (*forkPath, error) {
+	path := &forkPath{}
+	oldHeader, err := bc.storedHeader(oldTip, 0)
+	if err != nil {
+		return nil, err
+	}
+	newHeader, err := bc.storedHeader(newTip, 0)
+	if err != nil {
+		return nil, err
+	}
+	if bc.GetBody(newTip) == nil {
+		return nil, &MissingBlockError{Hash: newTip, Height: newHeader.BlockNum}
+	}
+	for !bytes.Equal(oldHeader.Hash, newHeader.Hash) {
+		if oldHeader.BlockNum == 0 && newHeader.BlockNum == 0 {
+			return nil, errors.New("the forks do not share a genesis block")
+		}
+		if oldHeader.BlockNum >= newHeader.BlockNum {
+			path.detached = append(path.detached, oldHeader.Hash)
+			if oldHeader, err = bc.storedHeader(oldHeader.PrevHash, oldHeader.BlockNum-1); err != nil {
+				return nil, err
+			}
+		} else {
+			path.attached = append(path.attached, newHeader.Hash)
+			if newHeader, err = bc.storedHeader(newHeader.PrevHash, newHeader.BlockNum-1); err != nil {
+				return nil, err
+			}
+			if bc.GetBody(newHeader.Hash) == nil {
+				return nil, &MissingBlockError{Hash: newHeader.Hash, Height: newHeader.BlockNum}
+			}
+		}
+	}
+	path.ancestor = oldHeader.Hash
+	return path, nil
+}
+
+// switchFork visits the blocks of path, sets the last hash to lastHashNew and returns how the tally index
+// changed. The last hash is left as it was if it cannot be saved. Called with bc.mu held
+func (bc *BlockChain) switchFork(lastHashNew []byte, path *forkPath, visit ForkVisitor) ([]TallyCorrection, error) {
+	if visit != nil {
+		for _, hash := range path.detached {
+			visit(bc.Get(hash), false)
+		}
+		for i := len(path.attached) - 1; i >= 0; i-- {
+			visit(bc.Get(path.attached[i]), true)
+		}
+	}
+
+	// set last hash
+	err := bc.DB.Put(LastHashKey, lastHashNew)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save last hash: %v", err)
+	}
+	bc.LastHash = lastHashNew
+	return bc.syncTallyIndex(lastHashNew), nil
+}
+
+// storedHeader returns the header of a block at height, or a *MissingBlockError if it is not stored
+func (bc *BlockChain) storedHeader(hash []byte, height uint32) (*BlockHeader, error) {
+	if !bc.Exist(hash) {
+		return nil, &MissingBlockError{Hash: hash, Height: height}
+	}
+	return bc.GetHeader(hash), nil
+}
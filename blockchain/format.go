@@ -0,0 +1,276 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+)
+
+// StorageFormatKey holds the format version the stored blocks of the database are written in
+var StorageFormatKey = []byte("StorageFormat")
+
+// BlockFormatVersion is the version stored headers and bodies are written in. Bump it and add a step to
+// formatMigrations whenever a change to Block, BlockHeader or Transaction needs stored blocks rewritten.
+const BlockFormatVersion = 1
+
+// formatMagic starts every versioned header and body, followed by the version byte. Unversioned (version 0)
+// values are plain gob streams, which never start with it: gob starts with a length below 0x80 or above 0xf7.
+const formatMagic = 0xb7
+
+// ErrNewerFormat is returned when opening a database written by a newer version of the node
+var ErrNewerFormat = errors.New("database is written in a newer block format")
+
+// formatMigrations[v] upgrades a stored block from version v to v+1
+var formatMigrations = []func(header *BlockHeader, body *BlockBody, meta *BlockMeta){
+	// 0 -> 1: the version prefix, and a Merkle root for headers stored before it existed. It goes to the
+	// metadata, not the header: the header was hashed without it. Blocks stored whole are split into a header
+	// and a body before, see splitLegacyBlocks
+	func(header *BlockHeader, body *BlockBody, meta *BlockMeta) {
+		if len(header.MerkleRoot) == 0 {
+			meta.TxnRoot = MerkleRoot(body.Txns)
+		}
+	},
+}
+
+// storedValue prefixes an encoded header or body with the current format version
+func storedValue(data []byte) []byte {
+	return append([]byte{formatMagic, BlockFormatVersion}, data...)
+}
+
+// splitStored returns the format version and the encoding of a stored header or body. Values received over
+// the network and stored by older versions have no prefix and are version 0
+func splitStored(data []byte) (version int, encoded []byte) {
+	if len(data) >= 2 && data[0] == formatMagic {
+		return int(data[1]), data[2:]
+	}
+	return 0, data
+}
+
+// StorageFormat returns the format version of the blocks in db: 0 if it has blocks but no version
+func (bc *BlockChain) StorageFormat() (int, error) {
+	if !bc.DB.KeyExist(StorageFormatKey) {
+		return 0, nil
+	}
+	data, err := bc.DB.Get(StorageFormatKey)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+// checkStorageFormat refuses a database of a newer version, and tells to migrate one of an older version,
+// which can still be read
+func (bc *BlockChain) checkStorageFormat() error {
+	version, err := bc.StorageFormat()
+	if err != nil {
+		return err
+	}
+	if version > BlockFormatVersion {
+		return fmt.Errorf("%w: version %d, this node reads up to %d", ErrNewerFormat, version, BlockFormatVersion)
+	}
+	if version < BlockFormatVersion {
+		log.Printf("[WARN] Stored blocks are in format %d, run cmd/migrate to upgrade them to %d\n", version, BlockFormatVersion)
+	}
+	return nil
+}
+
+// MigrateStorage rewrites every stored block in the current format, applying the migration steps from the
+// version of the database on. Blocks stored whole under LegacyBlockKeyPrefix are split into a header, a body and
+// metadata first, and a database written before value checksums has every value rewritten with one last
+// (util.Database.Reseal). It returns the version migrated from and the number of blocks rewritten, and is a
+// no-op on an up to date database. Blocks are rewritten in batches, and the version is written last, so an
+// interrupted migration is picked up again by the next run.
+func (bc *BlockChain) MigrateStorage() (from int, migrated int, err error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if from, err = bc.StorageFormat(); err != nil {
+		return 0, 0, err
+	}
+	if from > BlockFormatVersion {
+		return from, 0, fmt.Errorf("%w: version %d", ErrNewerFormat, from)
+	}
+	if from < BlockFormatVersion {
+		if migrated, err = bc.migrateBlocks(); err != nil {
+			return from, migrated, err
+		}
+	}
+	if bc.DB.Legacy() {
+		if _, err = bc.DB.Reseal(); err != nil {
+			return from, migrated, fmt.Errorf("adding value checksums: %v", err)
+		}
+	}
+	return from, migrated, nil
+}
+
+// migrateBlocks splits the legacy blocks and rewrites every block in the current format
+func (bc *BlockChain) migrateBlocks() (migrated int, err error) {
+	split, err := bc.splitLegacyBlocks()
+	if err != nil {
+		return 0, err
+	}
+
+	var hashes [][]byte
+	iter := bc.DB.NewIterator(HeaderKeyPrefix)
+	for iter.Next() {
+		hashes = append(hashes, append([]byte{}, iter.Key()[len(HeaderKeyPrefix):]...))
+	}
+	iter.Close()
+
+	const batchSize = 64
+	var keys, values [][]byte
+	for _, hash := range hashes {
+		data, err := bc.DB.GetMulti([][]byte{DBKeyForHeader(hash), DBKeyForBody(hash)})
+		if err != nil {
+			return migrated, err
+		}
+		headerVersion, headerData := splitStored(data[0])
+		bodyVersion, bodyData := splitStored(data[1])
+		var header BlockHeader
+		var body BlockBody
+		if err = decode(headerData, &header); err != nil {
+			return migrated, fmt.Errorf("header of %x: %v", hash, err)
+		}
+		if err = decode(bodyData, &body); err != nil {
+			return migrated, fmt.Errorf("body of %x: %v", hash, err)
+		}
+		meta, err := bc.storedMeta(hash)
+		if err != nil {
+			return migrated, fmt.Errorf("metadata of %x: %v", hash, err)
+		}
+		// blocks written after an interrupted run are already newer than the database
+		version := headerVersion
+		if bodyVersion < version {
+			version = bodyVersion
+		}
+		for ; version < BlockFormatVersion; version++ {
+			formatMigrations[version](&header, &body, &meta)
+		}
+		keys = append(keys, DBKeyForHeader(hash), DBKeyForBody(hash), DBKeyForBlockMeta(hash))
+		values = append(values, storedValue(header.Encode()), storedValue(body.Encode()), meta.Encode())
+		migrated++
+		if len(keys) >= 3*batchSize {
+			if err = bc.DB.PutMulti(keys, values); err != nil {
+				return migrated, err
+			}
+			keys, values = nil, nil
+		}
+	}
+	keys = append(keys, StorageFormatKey)
+	values = append(values, []byte(strconv.Itoa(BlockFormatVersion)))
+	if err = bc.DB.PutMulti(keys, values); err != nil {
+		return migrated, err
+	}
+	if split > migrated {
+		migrated = split
+	}
+	return migrated, nil
+}
+
+// splitLegacyBlocks stores every block stored whole under LegacyBlockKeyPrefix as a header, a body and metadata
+// and removes the whole block. It returns the number of blocks split
+func (bc *BlockChain) splitLegacyBlocks() (split int, err error) {
+	var hashes [][]byte
+	iter := bc.DB.NewIterator(LegacyBlockKeyPrefix)
+	for iter.Next() {
+		hashes = append(hashes, append([]byte{}, iter.Key()[len(LegacyBlockKeyPrefix):]...))
+	}
+	iter.Close()
+
+	const batchSize = 64
+	var keys, values, removed [][]byte
+	flush := func() error {
+		// the whole blocks are removed only once their parts are stored
+		if err := bc.DB.PutMulti(keys, values); err != nil {
+			return err
+		}
+		if err := bc.DB.RemoveMulti(removed); err != nil {
+			return err
+		}
+		split += len(removed)
+		keys, values, removed = nil, nil, nil
+		return nil
+	}
+	for _, hash := range hashes {
+		block := bc.legacyBlock(hash)
+		if block == nil {
+			return split, fmt.Errorf("legacy block %x cannot be read", hash)
+		}
+		if !bytes.Equal(block.Hash, hash) {
+			return split, fmt.Errorf("legacy block %x is stored under a mismatched key %x", block.Hash, hash)
+		}
+		blockKeys, blockValues := blockKeys(block)
+		keys = append(keys, blockKeys...)
+		values = append(values, blockValues...)
+		removed = append(removed, DBKeyForLegacyBlock(hash))
+		bc.cache.Remove(hash)
+		if len(removed) >= batchSize {
+			if err = flush(); err != nil {
+				return split, err
+			}
+		}
+	}
+	return split, flush()
+}
+
+// storedMeta returns the metadata of a stored block. Blocks stored before metadata existed get new metadata
+func (bc *BlockChain) storedMeta(hash []byte) (BlockMeta, error) {
+	if !bc.DB.KeyExist(DBKeyForBlockMeta(hash)) {
+		return NewBlockMeta(), nil
+	}
+	data, err := bc.DB.Get(DBKeyForBlockMeta(hash))
+	if err != nil {
+		return BlockMeta{}, err
+	}
+	return DecodeToBlockMeta(data)
+}
+
+// legacy checks whether the block was mined before headers had a timestamp and a Merkle root. Its header has
+// no MerkleRoot, so that it still hashes as it was mined, and the root of its txns is kept in its metadata
+func (b *Block) legacy() bool {
+	if b.Timestamp != 0 || b.HashAlgo != "" {
+		return false
+	}
+	header := BlockHeader{PrevHash: b.PrevHash, BlockNum: b.BlockNum, Nonce: b.Nonce, MinerID: b.MinerID, Hash: b.Hash}
+	body := b.Body()
+	return validLegacyProof(&header, &body)
+}
+
+// validProof checks the proof of work of the block at the given difficulty, as of the version that mined it. Legacy
+// blocks were all mined at NumZeros
+func (b *Block) validProof(zeros uint8) bool {
+	return NewProofWithDifficulty(b, zeros).Validate() || b.legacy()
+}
+
+// validLegacyProof checks the proof of work of a block mined before headers had a timestamp and a Merkle root
+func validLegacyProof(header *BlockHeader, body *BlockBody) bool {
+	if header.Timestamp != 0 || header.HashAlgo != "" {
+		return false
+	}
+	hash := legacyProofHash(header, body)
+	return hash != nil && bytes.Equal(hash, header.Hash) && new(big.Int).SetBytes(hash).Cmp(targetFor(NumZeros)) < 0
+}
+
+// legacyProofHash is the hash of a block mined before headers had a timestamp and a Merkle root: the SHA-256 of
+// PrevHash | BlockNum | Nonce | the SHA-256 of its txns | MinerID, where each txn is
+// VoterCandidate VoterName VoterStudentID | Signature | ID | PublicKey. nil if a txn has no ballot
+func legacyProofHash(header *BlockHeader, body *BlockBody) []byte {
+	var txns [][]byte
+	for _, txn := range body.Txns {
+		if txn.Data == nil {
+			return nil
+		}
+		ballot := txn.Data.VoterCandidate + txn.Data.VoterName + txn.Data.VoterStudentID
+		txns = append(txns, []byte(ballot), txn.Signature, txn.ID, txn.PublicKey)
+	}
+	txnHash := sha256.Sum256(bytes.Join(txns, nil))
+	num, nonce := make([]byte, 4), make([]byte, 4)
+	binary.BigEndian.PutUint32(num, uint32(header.BlockNum))
+	binary.BigEndian.PutUint32(nonce, header.Nonce)
+	hash := sha256.Sum256(bytes.Join([][]byte{header.PrevHash, num, nonce, txnHash[:], []byte(header.MinerID)}, nil))
+	return hash[:]
+}
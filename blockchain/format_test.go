@@ -0,0 +1,215 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"strconv"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+// the block as the first versions stored it whole under LegacyBlockKeyPrefix
+type baselineBallot struct {
+	VoterName      string
+	VoterStudentID string
+	VoterCandidate string
+}
+
+type baselineTxn struct {
+	Data      *baselineBallot
+	ID        []byte
+	Signature []byte
+	PublicKey []byte
+}
+
+type baselineBlock struct {
+	PrevHash []byte
+	BlockNum uint8
+	Nonce    uint32
+	Txns     []*baselineTxn
+	MinerID  string
+	Hash     []byte
+}
+
+// mineBaseline finds the nonce and hash of a block with the proof of work of the first versions
+func mineBaseline(t *testing.T, b *baselineBlock) {
+	t.Helper()
+	body := &BlockBody{}
+	for _, txn := range b.Txns {
+		body.Txns = append(body.Txns, &Transaction{
+			Data: &Ballot{VoterName: txn.Data.VoterName, VoterStudentID: txn.Data.VoterStudentID,
+				VoterCandidate: txn.Data.VoterCandidate},
+			ID: txn.ID, Signature: txn.Signature, PublicKey: txn.PublicKey,
+		})
+	}
+	for b.Nonce = 0; b.Nonce < 1<<20; b.Nonce++ {
+		header := &BlockHeader{PrevHash: b.PrevHash, BlockNum: uint32(b.BlockNum), Nonce: b.Nonce, MinerID: b.MinerID}
+		header.Hash = legacyProofHash(header, body)
+		if validLegacyProof(header, body) {
+			b.Hash = header.Hash
+			return
+		}
+	}
+	t.Fatal("no nonce found")
+}
+
+func putBaseline(t *testing.T, db *util.Database, b *baselineBlock) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(DBKeyForLegacyBlock(b.Hash), buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateLegacyBlocks(t *testing.T) {
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	genesis := &baselineBlock{PrevHash: []byte{}, MinerID: "Coord"}
+	mineBaseline(t, genesis)
+	block := &baselineBlock{PrevHash: genesis.Hash, BlockNum: 1, MinerID: "miner1", Txns: []*baselineTxn{{
+		Data: &baselineBallot{VoterName: "voter", VoterStudentID: "12345678", VoterCandidate: "Alice"},
+		ID:   []byte("txid"), Signature: []byte("signature"), PublicKey: []byte("key"),
+	}}}
+	mineBaseline(t, block)
+	putBaseline(t, db, genesis)
+	putBaseline(t, db, block)
+	if err := db.Put(LastHashKey, block.Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	bc := NewBlockChain(db, nil)
+	// read before the migration
+	if !bc.Exist(block.Hash) {
+		t.Fatal("legacy block does not exist")
+	}
+	if got := bc.Get(block.Hash); got.BlockNum != 1 || len(got.Txns) != 1 || got.Txns[0].Data.VoterCandidate != "Alice" {
+		t.Fatalf("legacy block read as %+v", got)
+	}
+	if header := bc.GetHeader(block.Hash); !bytes.Equal(header.PrevHash, genesis.Hash) {
+		t.Fatal("legacy header does not link to genesis")
+	}
+	if hashes, err := bc.Hashes(); err != nil || len(hashes) != 2 {
+		t.Fatalf("%d blocks exported (%v), want 2", len(hashes), err)
+	}
+
+	from, migrated, err := bc.MigrateStorage()
+	if err != nil {
+		t.Fatalf("MigrateStorage: %v", err)
+	}
+	if from != 0 || migrated != 2 {
+		t.Fatalf("migrated %d blocks from format %d, want 2 from 0", migrated, from)
+	}
+	for _, hash := range [][]byte{genesis.Hash, block.Hash} {
+		if db.KeyExist(DBKeyForLegacyBlock(hash)) {
+			t.Fatalf("legacy block %x is still stored whole", hash)
+		}
+		if !db.KeyExist(DBKeyForHeader(hash)) || !db.KeyExist(DBKeyForBody(hash)) || !db.KeyExist(DBKeyForBlockMeta(hash)) {
+			t.Fatalf("block %x is not split", hash)
+		}
+	}
+	if version, _ := bc.StorageFormat(); version != BlockFormatVersion {
+		t.Fatalf("format %d after the migration, want %d", version, BlockFormatVersion)
+	}
+	if err = bc.VerifyStored(); err != nil {
+		t.Fatalf("VerifyStored: %v", err)
+	}
+	if got := bc.Get(block.Hash); got.Txns[0].Data.VoterName != "voter" {
+		t.Fatal("migrated block lost its ballot")
+	}
+
+	// a second run has nothing to do
+	if _, migrated, err = bc.MigrateStorage(); err != nil || migrated != 0 {
+		t.Fatalf("second run migrated %d blocks: %v", migrated, err)
+	}
+	if data, err := db.Get(StorageFormatKey); err != nil || string(data) != strconv.Itoa(BlockFormatVersion) {
+		t.Fatalf("format key %q: %v", data, err)
+	}
+}
+
+func TestMigratedLegacyChainValidates(t *testing.T) {
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	genesis := &baselineBlock{PrevHash: []byte{}, MinerID: "Coord"}
+	mineBaseline(t, genesis)
+	putBaseline(t, db, genesis)
+	chain := []*baselineBlock{genesis}
+	for num := uint8(1); num <= 2; num++ {
+		block := &baselineBlock{PrevHash: chain[len(chain)-1].Hash, BlockNum: num, MinerID: "miner1"}
+		mineBaseline(t, block)
+		putBaseline(t, db, block)
+		chain = append(chain, block)
+	}
+	tip := chain[len(chain)-1].Hash
+	if err := db.Put(LastHashKey, tip); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := NewBlockChain(db, nil).MigrateStorage(); err != nil {
+		t.Fatalf("MigrateStorage: %v", err)
+	}
+
+	// the node restarts on the migrated database
+	bc := NewBlockChain(db, nil)
+	if err := bc.ResumeFromDB(); err != nil {
+		t.Fatalf("ResumeFromDB: %v", err)
+	}
+	// its genesis block commits to nothing, so only a node that opted in runs it
+	if err := bc.CheckGenesis(GenesisConfig{ElectionID: "test"}); !errors.Is(err, ErrLegacyGenesis) {
+		t.Fatalf("CheckGenesis without LegacyGenesis: %v, want ErrLegacyGenesis", err)
+	}
+	if err := bc.CheckCandidates("test"); !errors.Is(err, ErrLegacyGenesis) {
+		t.Fatalf("CheckCandidates without LegacyGenesis: %v, want ErrLegacyGenesis", err)
+	}
+	bc.LegacyGenesis = true
+	if err := bc.CheckGenesis(GenesisConfig{ElectionID: "test"}); err != nil {
+		t.Fatalf("CheckGenesis: %v", err)
+	}
+	if err := bc.CheckCandidates("test"); err != nil {
+		t.Fatalf("CheckCandidates: %v", err)
+	}
+	for _, block := range chain {
+		header := bc.GetHeader(block.Hash)
+		if !bytes.Equal(header.Hash, block.Hash) || len(header.MerkleRoot) != 0 {
+			t.Fatalf("migrated header of #%d is %+v", block.BlockNum, header)
+		}
+		if meta, err := bc.storedMeta(block.Hash); err != nil || !bytes.Equal(meta.TxnRoot, MerkleRoot(nil)) {
+			t.Fatalf("metadata of #%d has root %x: %v", block.BlockNum, meta.TxnRoot, err)
+		}
+	}
+	if err := bc.VerifyStored(); err != nil {
+		t.Fatalf("VerifyStored: %v", err)
+	}
+
+	// a peer starting from the same genesis validates the rest of the chain
+	peerDB := &util.Database{}
+	if err := peerDB.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer peerDB.Close()
+	peer := NewBlockChain(peerDB, nil)
+	if err := peer.ResumeFromEncodedData([][]byte{bc.Get(genesis.Hash).Encode()}, genesis.Hash); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range chain[1:] {
+		if result := peer.Put(*bc.Get(block.Hash), false); result.Status != PutExtended {
+			t.Fatalf("peer put #%d: %v", block.BlockNum, result.Status)
+		}
+	}
+	if !bytes.Equal(peer.GetLastHash(), tip) {
+		t.Fatal("peer is not on the migrated chain")
+	}
+	if err := peer.VerifyChain(); err != nil {
+		t.Fatalf("peer VerifyChain: %v", err)
+	}
+}
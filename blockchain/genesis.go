@@ -0,0 +1,169 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// GenesisMinerID is the miner ID of every genesis block
+const GenesisMinerID = "Coord"
+
+// ErrCandidatesNotCommitted is returned by CheckCandidates for candidates the genesis block does not commit to
+var ErrCandidatesNotCommitted = errors.New("candidates are not the ones committed to in the genesis block")
+
+// ErrLegacyGenesis is returned by CheckCandidates and CheckGenesis for a genesis block with no PrevHash, from
+// before genesis configs, which commits to nothing and thus cannot be checked. Only chains with LegacyGenesis
+// set accept one
+var ErrLegacyGenesis = errors.New("genesis block predates genesis configs and commits to nothing; set LegacyGenesis to run such a chain unchecked")
+
+// GenesisConfig derives the genesis block. Nodes initialized independently with the same config agree on
+// the genesis block, so their chains can merge.
+type GenesisConfig struct {
+	ElectionID    string       // name of the election, any string
+	CandidateHash []byte       // hash of the candidates, see CandidateSetHash
+	Timestamp     int64        // unix seconds
+	Difficulty    uint8        // leading zero bits of the genesis hash, at least NumZeros. NumZeros if 0
+	HashAlgo      string       // hash algorithm of the blocks of the chain, see HasherFor
+	SealingKey    []byte       // public key ballots are sealed to, see SealBallot. ballots are not sealed if empty
+	Registration  Registration // who may vote, see Registration. anyone if empty
+}
+
+// Block returns the genesis block of the config. It has no parent: its PrevHash commits to ElectionID,
+// CandidateHash, and SealingKey and Registration, if any, instead.
+func (g GenesisConfig) Block() *Block {
+	genesis := &Block{
+		PrevHash:  GenesisCommitment(g.ElectionID, g.CandidateHash, g.SealingKey, g.Registration),
+		BlockNum:  0,
+		Timestamp: g.Timestamp,
+		Txns:      []*Transaction{},
+		MinerID:   GenesisMinerID,
+		HashAlgo:  g.HashAlgo,
+	}
+	difficulty := g.Difficulty
+	if difficulty < NumZeros {
+		difficulty = NumZeros
+	}
+	NewProofWithDifficulty(genesis, difficulty).Run()
+	return genesis
+}
+
+// CheckHeader checks that header is a genesis block of the config: it has the fields Block gives it and hashes
+// to its Hash with the proof of work of Difficulty. Unlike comparing with Block().Hash, no nonce is searched, so
+// it is cheap at any difficulty; any nonce meeting the target is taken, not only the first one
+func (g GenesisConfig) CheckHeader(header *BlockHeader) error {
+	switch {
+	case !bytes.Equal(header.PrevHash, GenesisCommitment(g.ElectionID, g.CandidateHash, g.SealingKey, g.Registration)):
+		return errors.New("genesis block commits to another election, candidates, sealing key or registration")
+	case header.BlockNum != 0 || header.Timestamp != g.Timestamp || header.MinerID != GenesisMinerID:
+		return fmt.Errorf("genesis block is #%d by %q at %d, the config gives #0 by %q at %d",
+			header.BlockNum, header.MinerID, header.Timestamp, GenesisMinerID, g.Timestamp)
+	case header.HashAlgo != g.HashAlgo:
+		return fmt.Errorf("genesis block is hashed with %q, the config gives %q", header.HashAlgo, g.HashAlgo)
+	case !bytes.Equal(header.MerkleRoot, MerkleRoot([]*Transaction{})):
+		return errors.New("genesis block has txns")
+	}
+	difficulty := g.Difficulty
+	if difficulty < NumZeros {
+		difficulty = NumZeros
+	}
+	if !header.ValidateWithDifficulty(difficulty) {
+		return fmt.Errorf("genesis block does not hash to %x with %d leading zero bits", header.Hash, difficulty)
+	}
+	return nil
+}
+
+// GenesisCommitment is the PrevHash of the genesis block of an election with the given candidates (see
+// CandidateSetHash), sealing key and registration
+func GenesisCommitment(electionID string, candidateHash []byte, sealingKey []byte, registration Registration) []byte {
+	commitment := appendField(nil, []byte(electionID))
+	commitment = appendField(commitment, candidateHash)
+	if len(sealingKey) > 0 {
+		commitment = appendField(commitment, sealingKey)
+	}
+	commitment = registration.appendCommitment(commitment)
+	hash := sha256.Sum256(commitment)
+	return hash[:]
+}
+
+// CandidateSetHash hashes the candidates in order: their data and the public keys of their wallets
+func CandidateSetHash(candidates []*Identity.Wallets) []byte {
+	var buf []byte
+	for _, cand := range candidates {
+		data := cand.CandidateData
+		buf = appendField(buf, []byte(data.CandidateName))
+		buf = appendField(buf, []byte(data.ID))
+		buf = appendField(buf, []byte(data.Race))
+		buf = appendUvarint(buf, uint64(data.MaxVotes))
+		if data.AllowWriteIns {
+			buf = appendUvarint(buf, 1)
+		} else {
+			buf = appendUvarint(buf, 0)
+		}
+		buf = appendField(buf, []byte(data.Method))
+		var addrs []string
+		for addr := range cand.Wallets {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		buf = appendUvarint(buf, uint64(len(addrs)))
+		for _, addr := range addrs {
+			buf = appendField(buf, cand.Wallets[addr].PublicKey)
+		}
+	}
+	hash := sha256.Sum256(buf)
+	return hash[:]
+}
+
+// GenesisHash returns the hash of the first block of the longest chain
+func (bc *BlockChain) GenesisHash() []byte {
+	bc.mu.Lock()
+	iter := bc.NewIterator(bc.LastHash)
+	bc.mu.Unlock()
+	for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+		if end {
+			return header.Hash
+		}
+	}
+}
+
+// CheckCandidates checks that Candidates, which every ballot is validated against, SealingKey and Registration
+// are the ones the genesis block of the chain commits to, so that no node can add, remove or alter a candidate once the
+// chain started. A genesis block older than the commitment is refused, see ErrLegacyGenesis
+func (bc *BlockChain) CheckCandidates(electionID string) error {
+	genesis := bc.GetHeader(bc.GenesisHash())
+	if len(genesis.PrevHash) == 0 {
+		return bc.legacyGenesis("the candidates are not checked")
+	}
+	expected := GenesisCommitment(electionID, CandidateSetHash(bc.Candidates), bc.SealingKey, bc.Registration)
+	if !bytes.Equal(genesis.PrevHash, expected) {
+		return ErrCandidatesNotCommitted
+	}
+	return nil
+}
+
+// CheckGenesis checks that the chain starts with a genesis block of g, see CheckHeader. A genesis block older
+// than genesis configs, with no PrevHash, is refused, see ErrLegacyGenesis
+func (bc *BlockChain) CheckGenesis(g GenesisConfig) error {
+	genesis := bc.GetHeader(bc.GenesisHash())
+	if len(genesis.PrevHash) == 0 {
+		return bc.legacyGenesis("it is not checked against the genesis config")
+	}
+	if err := g.CheckHeader(genesis); err != nil {
+		return fmt.Errorf("chain starts with genesis %x: %v", genesis.Hash, err)
+	}
+	return nil
+}
+
+// legacyGenesis refuses a genesis block older than genesis configs, unless LegacyGenesis is set
+func (bc *BlockChain) legacyGenesis(unchecked string) error {
+	if !bc.LegacyGenesis {
+		return ErrLegacyGenesis
+	}
+	log.Printf("[WARN] Genesis block predates genesis configs, %s\n", unchecked)
+	return nil
+}
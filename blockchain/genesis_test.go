@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func TestGenesisCheckHeader(t *testing.T) {
+	g := GenesisConfig{ElectionID: "test", CandidateHash: CandidateSetHash(nil), Timestamp: 1650000000}
+	block := g.Block()
+	header := block.Header()
+	if err := g.CheckHeader(&header); err != nil {
+		t.Fatalf("genesis block of the config: %v", err)
+	}
+
+	for name, change := range map[string]func(*GenesisConfig){
+		"another election":       func(g *GenesisConfig) { g.ElectionID = "other" },
+		"other candidates":       func(g *GenesisConfig) { g.CandidateHash = []byte("other") },
+		"a sealing key":          func(g *GenesisConfig) { g.SealingKey = []byte("key") },
+		"another timestamp":      func(g *GenesisConfig) { g.Timestamp++ },
+		"another hash algorithm": func(g *GenesisConfig) { g.HashAlgo = "blake2b" },
+		"a higher difficulty":    func(g *GenesisConfig) { g.Difficulty = 255 },
+	} {
+		other := g
+		change(&other)
+		if err := other.CheckHeader(&header); err == nil {
+			t.Errorf("genesis block passes for a config with %s", name)
+		}
+	}
+
+	forged := header
+	forged.Nonce++
+	if err := g.CheckHeader(&forged); err == nil {
+		t.Error("genesis block with another nonce than its hash passes")
+	}
+}
+
+func TestCheckGenesis(t *testing.T) {
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	g := GenesisConfig{ElectionID: "test", CandidateHash: CandidateSetHash(nil)}
+	bc := NewBlockChain(db, nil)
+	if err := bc.Init(g); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.CheckGenesis(g); err != nil {
+		t.Fatalf("chain of the config: %v", err)
+	}
+	other := g
+	other.ElectionID = "other"
+	if err := bc.CheckGenesis(other); err == nil {
+		t.Fatal("chain passes for the config of another election")
+	}
+}
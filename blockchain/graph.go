@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// graph formats of WriteGraph
+const (
+	GraphDOT     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// graphNode is a stored block as drawn by WriteGraph
+type graphNode struct {
+	header    *BlockHeader
+	numTxns   int
+	canonical bool // on the longest chain
+}
+
+// WriteGraph draws every stored block, abandoned forks included, as a Graphviz DOT or Mermaid graph. Each
+// block is labeled with its height, miner and number of txns. Blocks off the longest chain are dashed.
+func (bc *BlockChain) WriteGraph(w io.Writer, format string) error {
+	if format != GraphDOT && format != GraphMermaid {
+		return fmt.Errorf("unknown graph format %q", format)
+	}
+	nodes, err := bc.graphNodes()
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	if format == GraphDOT {
+		sb.WriteString("digraph blockchain {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	} else {
+		sb.WriteString("graph LR\n\tclassDef stale stroke-dasharray: 5 5,color:#888\n")
+	}
+	for _, node := range nodes {
+		id := graphID(node.header.Hash)
+		label := fmt.Sprintf("#%d (%x)\n%s\n%d txns", node.header.BlockNum, node.header.Hash[:4], node.header.MinerID, node.numTxns)
+		if node.header.BlockNum == 0 {
+			label = fmt.Sprintf("genesis (%x)", node.header.Hash[:4])
+		}
+		if format == GraphDOT {
+			style := "solid"
+			if !node.canonical {
+				style = "dashed"
+			}
+			fmt.Fprintf(&sb, "\t%s [label=%s, style=%s];\n", id, strconv.Quote(label), style)
+			if node.header.BlockNum > 0 {
+				fmt.Fprintf(&sb, "\t%s -> %s;\n", graphID(node.header.PrevHash), id)
+			}
+		} else {
+			label = strings.ReplaceAll(strings.ReplaceAll(label, `"`, "#quot;"), "\n", "<br/>")
+			fmt.Fprintf(&sb, "\t%s[\"%s\"]\n", id, label)
+			if node.header.BlockNum > 0 {
+				fmt.Fprintf(&sb, "\t%s --> %s\n", graphID(node.header.PrevHash), id)
+			}
+			if !node.canonical {
+				fmt.Fprintf(&sb, "\tclass %s stale\n", id)
+			}
+		}
+	}
+	if format == GraphDOT {
+		sb.WriteString("}\n")
+	}
+	_, err = io.WriteString(w, sb.String())
+	return err
+}
+
+// graphNodes returns every stored block ordered by height, then hash
+func (bc *BlockChain) graphNodes() ([]graphNode, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	canonical := make(map[string]bool)
+	iter := bc.NewIterator(bc.LastHash)
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		canonical[string(block.Hash)] = true
+		if end {
+			break
+		}
+	}
+	var nodes []graphNode
+	err := bc.exportHeaders(nil, func(hash []byte, header *BlockHeader) error {
+		node := graphNode{header: header, canonical: canonical[string(hash)]}
+		if body := bc.GetBody(hash); body != nil {
+			node.numTxns = len(body.Txns)
+		}
+		nodes = append(nodes, node)
+		return nil
+	})
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].header.BlockNum != nodes[j].header.BlockNum {
+			return nodes[i].header.BlockNum < nodes[j].header.BlockNum
+		}
+		return bytes.Compare(nodes[i].header.Hash, nodes[j].header.Hash) < 0
+	})
+	return nodes, err
+}
+
+// graphID names a block in a graph
+func graphID(hash []byte) string {
+	return fmt.Sprintf("b%x", hash)
+}
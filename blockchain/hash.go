@@ -0,0 +1,46 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"golang.org/x/crypto/blake2b"
+)
+
+// hash algorithms of block headers, see HasherFor. Every block of a chain uses the algorithm of its genesis block.
+// Txn IDs and Merkle trees always use SHA-256.
+const (
+	HashSHA256  = "" // the algorithm of chains that predate the choice
+	HashBLAKE2b = "blake2b"
+)
+
+// Hasher hashes block headers for the proof of work and block hashes
+type Hasher interface {
+	Name() string
+	Sum256(data []byte) [32]byte
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Name() string { return "sha256" }
+
+func (sha256Hasher) Sum256(data []byte) [32]byte { return sha256.Sum256(data) }
+
+type blake2bHasher struct{}
+
+func (blake2bHasher) Name() string { return "blake2b-256" }
+
+func (blake2bHasher) Sum256(data []byte) [32]byte { return blake2b.Sum256(data) }
+
+var hashers = map[string]Hasher{
+	HashSHA256:  sha256Hasher{},
+	HashBLAKE2b: blake2bHasher{},
+}
+
+// HasherFor returns the hasher of a hash algorithm
+func HasherFor(algo string) (Hasher, error) {
+	hasher, ok := hashers[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", algo)
+	}
+	return hasher, nil
+}
@@ -0,0 +1,66 @@
+package blockchain
+
+import (
+	"encoding/binary"
+)
+
+// The height index lists the blocks stored at every height, forks included, so that a range of heights is read
+// without decoding every stored header. Every block is indexed when it is stored, and its entry is pruned with
+// it. A database stored before the index existed is indexed by IndexHeights; until then the headers are scanned
+
+// HeightIndexKeyPrefix prefixes the entries of the height index, by height and hash
+const HeightIndexKeyPrefix = "height-"
+
+// HeightIndexedKey is set once every stored block is in the height index
+var HeightIndexedKey = []byte("HeightIndexed")
+
+// heightPrefix returns the prefix of the entries of the height index at height
+func heightPrefix(height uint32) []byte {
+	key := make([]byte, len(HeightIndexKeyPrefix)+8)
+	copy(key, HeightIndexKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(HeightIndexKeyPrefix):], uint64(height))
+	return key
+}
+
+// DBKeyForHeight returns the database key of the entry of a block in the height index
+func DBKeyForHeight(height uint32, blockHash []byte) []byte {
+	return append(heightPrefix(height), blockHash...)
+}
+
+// IndexHeights adds every stored block to the height index, e.g. of a database stored before the index existed.
+// Nodes call it once when they resume from their database, next to IndexTallies
+func (bc *BlockChain) IndexHeights() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.DB.KeyExist(HeightIndexedKey) {
+		return nil
+	}
+	const batchSize = 256
+	var keys, values [][]byte
+	err := bc.exportHeaders(nil, func(hash []byte, header *BlockHeader) error {
+		keys = append(keys, DBKeyForHeight(header.BlockNum, hash))
+		values = append(values, hash)
+		if len(keys) < batchSize {
+			return nil
+		}
+		err := bc.DB.PutMulti(keys, values)
+		keys, values = nil, nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bc.DB.PutMulti(append(keys, HeightIndexedKey), append(values, []byte{1}))
+}
+
+// hashesAt returns the hashes of the blocks stored at height, from the height index
+func (bc *BlockChain) hashesAt(height uint32) [][]byte {
+	prefix := heightPrefix(height)
+	var hashes [][]byte
+	iter := bc.DB.NewIterator(string(prefix))
+	defer iter.Close()
+	for iter.Next() {
+		hashes = append(hashes, iter.Key()[len(prefix):])
+	}
+	return hashes
+}
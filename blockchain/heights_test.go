@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func TestHeightIndex(t *testing.T) {
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	bc := NewBlockChain(db, nil)
+	if err := bc.Init(GenesisConfig{ElectionID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(-time.Hour)
+	mine := func(parent *Block) *Block {
+		at = at.Add(time.Minute)
+		block := &Block{PrevHash: parent.Hash, BlockNum: parent.BlockNum + 1, Timestamp: at.Unix(),
+			Txns: []*Transaction{}, MinerID: "miner"}
+		NewProof(block).Run()
+		if result := bc.Put(*block, false); result.Status.Invalid() {
+			t.Fatalf("block #%d: %v", block.BlockNum, result.Status)
+		}
+		return block
+	}
+	// a chain of 4 blocks with a fork of 2 from the first one
+	genesis := bc.Get(bc.GenesisHash())
+	a1 := mine(genesis)
+	tip := a1
+	for i := 0; i < 3; i++ {
+		tip = mine(tip)
+	}
+	mine(mine(a1))
+	// encoded returns the blocks EncodeRange encodes, sorted
+	encoded := func(from, to uint32, known [][]byte) []string {
+		t.Helper()
+		blocks, err := bc.EncodeRange(from, to, known)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sorted []string
+		for _, block := range blocks {
+			sorted = append(sorted, string(block))
+		}
+		sort.Strings(sorted)
+		return sorted
+	}
+	check := func(what string, from, to uint32, known [][]byte, want int) {
+		t.Helper()
+		indexed := encoded(from, to, known)
+		if len(indexed) != want {
+			t.Fatalf("%s: %d blocks, want %d", what, len(indexed), want)
+		}
+		// the same blocks as a scan of every header
+		if err := db.Remove(HeightIndexedKey); err != nil {
+			t.Fatal(err)
+		}
+		scanned := encoded(from, to, known)
+		if err := bc.IndexHeights(); err != nil {
+			t.Fatal(err)
+		}
+		if len(scanned) != len(indexed) {
+			t.Fatalf("%s: %d blocks from the height index, %d from a scan", what, len(indexed), len(scanned))
+		}
+		for i := range scanned {
+			if scanned[i] != indexed[i] {
+				t.Fatalf("%s: the height index and a scan encode different blocks", what)
+			}
+		}
+	}
+	check("every height", 0, 4, nil, 7)
+	check("forks included", 2, 3, nil, 4)
+	check("known blocks skipped", 1, 1, [][]byte{a1.Hash}, 0)
+
+	// the fork goes and so do its entries
+	if removed, err := bc.PruneForks(0, 1); err != nil || removed != 2 {
+		t.Fatalf("pruned %d blocks, %v, want 2", removed, err)
+	}
+	check("pruned forks", 0, 4, nil, 5)
+}
+
+func TestHeightsPastUint8(t *testing.T) {
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	bc := NewBlockChain(db, nil)
+	if err := bc.Init(GenesisConfig{ElectionID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(-time.Hour)
+	tip := bc.Get(bc.GenesisHash())
+	for i := 0; i < 260; i++ {
+		at = at.Add(time.Second)
+		block := &Block{PrevHash: tip.Hash, BlockNum: tip.BlockNum + 1, Timestamp: at.Unix(),
+			Txns: []*Transaction{}, MinerID: "miner"}
+		NewProof(block).Run()
+		if result := bc.Put(*block, false); result.Status.Invalid() {
+			t.Fatalf("block #%d: %v", block.BlockNum, result.Status)
+		}
+		tip = block
+	}
+	if !bytes.Equal(bc.GetLastHash(), tip.Hash) {
+		t.Fatalf("tip is not block #%d", tip.BlockNum)
+	}
+	if block := bc.GetByHeight(257); block == nil || block.BlockNum != 257 {
+		t.Fatalf("block at height 257: %v", block)
+	}
+	blocks, err := bc.EncodeRange(256, 260, nil)
+	if err != nil || len(blocks) != 5 {
+		t.Fatalf("encoded %d blocks from height 256, %v, want 5", len(blocks), err)
+	}
+}
@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// In strict mode (BlockChain.Strict) every Put re-checks what must always hold of the stored chain and panics
+// with a dump of the chain on the first violation. It walks the whole longest chain on every block, so it is
+// only meant for development and tests.
+
+// invariantDumpBlocks is the number of blocks at the tip of the longest chain shown in a violation dump
+const invariantDumpBlocks = 16
+
+// CheckInvariants checks the longest chain: the stored last hash is LastHash, every block links to the previous
+// one with the next block number down to the genesis block at 0, and no txn is on it twice
+func (bc *BlockChain) CheckInvariants() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.checkInvariants()
+}
+
+// NOTE: mu should be held by the caller
+func (bc *BlockChain) checkInvariants() error {
+	stored, err := bc.DB.Get(LastHashKey)
+	if err != nil {
+		return fmt.Errorf("unable to read the stored last hash: %v", err)
+	}
+	if !bytes.Equal(stored, bc.LastHash) {
+		return fmt.Errorf("stored last hash %x is not the last hash %x", shortHash(stored), shortHash(bc.LastHash))
+	}
+
+	txids := make(map[string]uint32)
+	hash := bc.LastHash
+	for {
+		if !bc.Exist(hash) {
+			return fmt.Errorf("block %x on the longest chain is not stored", shortHash(hash))
+		}
+		block := bc.Get(hash)
+		for _, txn := range block.Txns {
+			if num, seen := txids[string(txn.ID)]; seen {
+				return fmt.Errorf("txn %x is in block #%d and block #%d", shortHash(txn.ID), block.BlockNum, num)
+			}
+			txids[string(txn.ID)] = block.BlockNum
+		}
+		if block.BlockNum == 0 {
+			return nil
+		}
+		if !bc.Exist(block.PrevHash) {
+			return fmt.Errorf("parent of block #%d (%x) is not stored", block.BlockNum, shortHash(block.Hash))
+		}
+		parent := bc.GetHeader(block.PrevHash)
+		if parent.BlockNum+1 != block.BlockNum {
+			return fmt.Errorf("block #%d (%x) follows block #%d", block.BlockNum, shortHash(block.Hash), parent.BlockNum)
+		}
+		hash = block.PrevHash
+	}
+}
+
+// assertInvariants panics if the chain breaks an invariant after block was added. Besides CheckInvariants, an
+// added block must not be higher than the longest chain, and the tally index must have the counted tally of the
+// tip. NOTE: mu should be held by the caller
+func (bc *BlockChain) assertInvariants(block *Block) {
+	err := bc.checkInvariants()
+	if err == nil && block.BlockNum > bc.GetHeader(bc.LastHash).BlockNum {
+		err = fmt.Errorf("block #%d (%x) is higher than the longest chain", block.BlockNum, shortHash(block.Hash))
+	}
+	if err == nil {
+		err = bc.checkTallyIndex()
+	}
+	if err != nil {
+		panic(fmt.Sprintf("chain invariant violated after putting block #%d (%x): %v\n%s",
+			block.BlockNum, shortHash(block.Hash), err, bc.dumpTip(invariantDumpBlocks)))
+	}
+}
+
+// checkTallyIndex checks the record of the tip in the tally index against a count of the chain
+func (bc *BlockChain) checkTallyIndex() error {
+	indexed, ok := bc.TallyAt(bc.LastHash, 0)
+	if !ok {
+		return fmt.Errorf("the tally index has no record of the last hash %x", shortHash(bc.LastHash))
+	}
+	votes, _ := bc.VotingStatusAt(bc.LastHash, 0)
+	for i := range votes {
+		if indexed.Votes[i] != votes[i] {
+			return fmt.Errorf("indexed tally %v of the tip is not the counted tally %v", indexed.Votes, votes)
+		}
+	}
+	return nil
+}
+
+// dumpTip describes the last n blocks of the longest chain, newest first. NOTE: mu should be held by the caller
+func (bc *BlockChain) dumpTip(n int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "longest chain ending at %x:\n", bc.LastHash)
+	hash := bc.LastHash
+	for i := 0; i < n; i++ {
+		if !bc.Exist(hash) {
+			fmt.Fprintf(&b, "  %x: not stored\n", hash)
+			break
+		}
+		block := bc.Get(hash)
+		fmt.Fprintf(&b, "  #%d %x prev %x by %q at %d, %d txns\n", block.BlockNum, block.Hash, block.PrevHash,
+			block.MinerID, block.Timestamp, len(block.Txns))
+		if block.BlockNum == 0 {
+			break
+		}
+		hash = block.PrevHash
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package blockchain
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/gob"
+	"log"
+	"time"
+)
+
+const BlockMetaKeyPrefix = "blockmeta-"
+
+// BlockMeta is the bookkeeping stored alongside every block
+type BlockMeta struct {
+	StoredAt int64  // unix time when the block was stored locally
+	TxnRoot  []byte // Merkle root of the txns of a legacy block, whose header has none. see Block.legacy
+}
+
+func NewBlockMeta() BlockMeta {
+	return BlockMeta{StoredAt: time.Now().Unix()}
+}
+
+func (meta BlockMeta) Encode() []byte {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(meta)
+	if err != nil {
+		log.Println("[WARN] block meta encode error")
+	}
+	return buf.Bytes()
+}
+
+func DecodeToBlockMeta(data []byte) (BlockMeta, error) {
+	meta := BlockMeta{}
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&meta)
+	return meta, err
+}
+
+// RunForkJanitor periodically removes abandoned fork blocks that have been stored for longer than retention
+func (bc *BlockChain) RunForkJanitor(retention time.Duration) {
+	interval := retention / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	for {
+		time.Sleep(interval)
+		if !bc.DB.Opened() {
+			return
+		}
+		removed, err := bc.PruneForks(retention, bc.RequiredConfirmations())
+		if err != nil {
+			log.Println("[WARN] Unable to prune abandoned forks:", err)
+		} else if removed > 0 {
+			log.Printf("[INFO] Pruned %d blocks from abandoned forks\n", removed)
+		}
+	}
+}
+
+// PruneForks deletes blocks that are not on the longest chain, branch off deeper than depth, the confirmations
+// after which a block is final so that forks below can never become the longest chain again,
+// and have been stored for longer than retention. Ancestors of any remaining block are always kept. The records
+// under KeyPrefixes of the removed blocks go with them.
+func (bc *BlockChain) PruneForks(retention time.Duration, depth int) (removed int, err error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	// blocks on the longest chain
+	canonical := make(map[string]bool)
+	iter := bc.NewIterator(bc.LastHash)
+	tip := bc.GetHeader(bc.LastHash)
+	for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+		canonical[string(header.Hash)] = true
+		if end {
+			break
+		}
+	}
+
+	// every block that is not on the longest chain
+	offChain := make(map[string]*BlockHeader)
+	err = bc.exportHeaders(canonical, func(hash []byte, header *BlockHeader) error {
+		offChain[string(hash)] = header
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// decide which off-chain blocks are still alive
+	now := time.Now()
+	keep := make(map[string]bool)
+	var newMeta [][]byte
+	var newMetaKeys [][]byte
+	for hash, block := range offChain {
+		expired := false
+		data, err := bc.DB.Get(DBKeyForBlockMeta(block.Hash))
+		if err != nil {
+			// no metadata yet (e.g. stored by an older version). start the clock now
+			newMetaKeys = append(newMetaKeys, DBKeyForBlockMeta(block.Hash))
+			newMeta = append(newMeta, NewBlockMeta().Encode())
+		} else if meta, err := DecodeToBlockMeta(data); err == nil {
+			expired = now.Sub(time.Unix(meta.StoredAt, 0)) > retention
+		}
+		deep := int(block.BlockNum)+depth <= int(tip.BlockNum)
+		if !expired || !deep {
+			keep[hash] = true
+		}
+	}
+	// a kept block keeps its off-chain ancestors
+	for hash := range keep {
+		for block := offChain[hash]; block != nil; block = offChain[string(block.PrevHash)] {
+			keep[string(block.Hash)] = true
+		}
+	}
+
+	var toRemove [][]byte
+	for hash, block := range offChain {
+		if !keep[hash] {
+			toRemove = append(toRemove, DBKeyForHeader(block.Hash), DBKeyForBody(block.Hash), DBKeyForBlockMeta(block.Hash),
+				DBKeyForLegacyBlock(block.Hash), DBKeyForHeight(block.BlockNum, block.Hash))
+			for _, prefix := range bc.KeyPrefixes {
+				toRemove = append(toRemove, util.DBKeyWithPrefix(prefix, block.Hash))
+			}
+			bc.cache.Remove(block.Hash)
+			removed++
+		}
+	}
+	if len(newMetaKeys) > 0 {
+		if err = bc.DB.PutMulti(newMetaKeys, newMeta); err != nil {
+			return 0, err
+		}
+	}
+	if len(toRemove) > 0 {
+		if err = bc.DB.RemoveMulti(toRemove); err != nil {
+			return 0, err
+		}
+	}
+	return removed, nil
+}
+
+// DBKeyForBlockMeta returns the database key for the metadata of a given block
+func DBKeyForBlockMeta(blockHash []byte) []byte {
+	return bytes.Join([][]byte{[]byte(BlockMetaKeyPrefix), blockHash}, []byte{})
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// MerkleProof proves that a transaction is part of a block, see VerifyMerkleProof
+type MerkleProof struct {
+	Index    int      // position of the txn in its block
+	Siblings [][]byte // sibling hashes from the leaf level up to (not including) the root
+}
+
+// TxnLeaf returns the Merkle tree leaf of a transaction
+func TxnLeaf(tx *Transaction) []byte {
+	hash := sha256.Sum256(append([]byte{0}, EncodeTxn(tx)...))
+	return hash[:]
+}
+
+// MerkleRoot returns the root of the Merkle tree over the given transactions.
+// The last node of a level with an odd number of nodes is paired with itself.
+func MerkleRoot(txns []*Transaction) []byte {
+	if len(txns) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	}
+	level := merkleLeaves(txns)
+	for len(level) > 1 {
+		level = merkleLevelUp(level)
+	}
+	return level[0]
+}
+
+// NewMerkleProof returns the proof of the transaction at index in txns
+func NewMerkleProof(txns []*Transaction, index int) MerkleProof {
+	proof := MerkleProof{Index: index}
+	level := merkleLeaves(txns)
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			sibling = index
+		}
+		proof.Siblings = append(proof.Siblings, level[sibling])
+		level = merkleLevelUp(level)
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyMerkleProof checks that leaf is part of the Merkle tree with the given root
+func VerifyMerkleProof(root []byte, leaf []byte, proof MerkleProof) bool {
+	hash := leaf
+	index := proof.Index
+	for _, sibling := range proof.Siblings {
+		if index%2 == 0 {
+			hash = merkleNode(hash, sibling)
+		} else {
+			hash = merkleNode(sibling, hash)
+		}
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(hash, root)
+}
+
+// ----- Utility functions -----
+
+func merkleLeaves(txns []*Transaction) [][]byte {
+	var leaves [][]byte
+	for _, txn := range txns {
+		leaves = append(leaves, TxnLeaf(txn))
+	}
+	return leaves
+}
+
+func merkleLevelUp(level [][]byte) [][]byte {
+	var next [][]byte
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, merkleNode(level[i], level[i+1]))
+		} else {
+			next = append(next, merkleNode(level[i], level[i]))
+		}
+	}
+	return next
+}
+
+func merkleNode(left []byte, right []byte) []byte {
+	hash := sha256.Sum256(bytes.Join([][]byte{{1}, left, right}, []byte{}))
+	return hash[:]
+}
@@ -2,35 +2,53 @@ package blockchain
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
 	"encoding/binary"
 	"log"
 	"math"
 	"math/big"
+	"sync"
 	"time"
 )
 
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
+	Hasher Hasher     // of the block's HashAlgo. nil if the algorithm is unknown, and no nonce is valid
+	Clock  util.Clock // paces delayed mining
 }
 
 const NumZeros = 8
 
+// MiningDelay is the pause after each delayed nonce attempt
+const MiningDelay = 50 * time.Millisecond
+
 // NewProof creates a new ProofOfWork structure
 func NewProof(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-NumZeros))
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{Block: b, Target: targetFor(NumZeros), Clock: util.RealClock}
+	if b != nil {
+		pow.Hasher, _ = HasherFor(b.HashAlgo)
+	}
 	return pow
 }
 
+// NewProofWithDifficulty is NewProof with a target of the given number of leading zero bits
+func NewProofWithDifficulty(b *Block, zeros uint8) *ProofOfWork {
+	pow := NewProof(b)
+	pow.Target = targetFor(zeros)
+	return pow
+}
+
+// targetFor returns the target a hash must be below to have the given number of leading zero bits
+func targetFor(zeros uint8) *big.Int {
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-int(zeros)))
+	return target
+}
+
 // Run executes proof of work to find the nonce that makes block hash has NumZeros leading zeros
 func (pow *ProofOfWork) Run() {
-	for pow.Block.Nonce < math.MaxUint32 {
-		if pow.Next(false) {
-			break
-		}
+	for !pow.Next(false) {
 	}
 }
 
@@ -39,7 +57,7 @@ func (pow *ProofOfWork) Next(delayed bool) (success bool) {
 	var intHash big.Int
 
 	data := pow.BlockToBytes(pow.Block.Nonce)
-	hash = sha256.Sum256(data)
+	hash = pow.Hasher.Sum256(data)
 	intHash.SetBytes(hash[:])
 
 	if intHash.Cmp(pow.Target) == -1 { // find the nonce
@@ -48,21 +66,88 @@ func (pow *ProofOfWork) Next(delayed bool) (success bool) {
 	} else {
 		success = false
 		pow.Block.Hash = hash[:]
-		pow.Block.Nonce++
+		if pow.Block.Nonce == math.MaxUint32 {
+			pow.restart()
+		} else {
+			pow.Block.Nonce++
+		}
 	}
 
 	if delayed {
-		time.Sleep(50 * time.Millisecond)
+		pow.Clock.Sleep(MiningDelay)
 	}
 	return
 }
 
+// Search tries the next attempts nonces of the block with workers goroutines, each hashing its share of them. It
+// returns whether one solves the block, which then has the lowest solving nonce and its hash. Otherwise the block
+// moves on to the nonce after the attempts. Unlike Next, Search never pauses.
+func (pow *ProofOfWork) Search(workers int, attempts uint32) bool {
+	base := pow.Block.Nonce
+	if workers < 2 || attempts < 2 || uint64(base)+uint64(attempts)+uint64(workers) > math.MaxUint32 {
+		// one at a time, Next starts over the nonce space once it is exhausted
+		for i := uint32(0); i < attempts; i++ {
+			if pow.Next(false) {
+				return true
+			}
+		}
+		return false
+	}
+	txnRoot := pow.HashTxns()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	solved := false
+	var nonce uint32
+	var hash [32]byte
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(first uint32) {
+			defer wg.Done()
+			var intHash big.Int
+			for n := first; n < base+attempts; n += uint32(workers) {
+				sum := pow.Hasher.Sum256(headerToBytes(pow.Block.PrevHash, pow.Block.BlockNum, n, pow.Block.Timestamp, txnRoot, pow.Block.MinerID, pow.Block.HashAlgo))
+				if intHash.SetBytes(sum[:]).Cmp(pow.Target) == -1 {
+					mu.Lock()
+					if !solved || n < nonce {
+						solved, nonce, hash = true, n, sum
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(base + uint32(w))
+	}
+	wg.Wait()
+	if !solved {
+		pow.Block.Nonce = base + attempts
+		return false
+	}
+	pow.Block.Nonce = nonce
+	pow.Block.Hash = hash[:]
+	return true
+}
+
+// restart starts over the nonce space once every nonce failed, with a later timestamp so that the header
+// hashes differently: the current time, or one second later if the clock has not moved past the timestamp
+func (pow *ProofOfWork) restart() {
+	timestamp := pow.Clock.Now().Unix()
+	if timestamp <= pow.Block.Timestamp {
+		timestamp = pow.Block.Timestamp + 1
+	}
+	log.Printf("[INFO] Nonces of block #%d exhausted, moving its timestamp to %d\n", pow.Block.BlockNum, timestamp)
+	pow.Block.Timestamp = timestamp
+	pow.Block.Nonce = 0
+}
+
 // Validate checks whether the nonce is correct
 func (pow *ProofOfWork) Validate() bool {
 	var intHash big.Int
+	if pow.Hasher == nil {
+		return false
+	}
 
 	data := pow.BlockToBytes(pow.Block.Nonce)
-	hash := sha256.Sum256(data)
+	hash := pow.Hasher.Sum256(data)
 	intHash.SetBytes(hash[:])
 
 	return intHash.Cmp(pow.Target) == -1
@@ -71,19 +156,36 @@ func (pow *ProofOfWork) Validate() bool {
 // ---------------------------
 
 func (pow *ProofOfWork) BlockToBytes(nonce uint32) []byte {
+	return headerToBytes(pow.Block.PrevHash, pow.Block.BlockNum, nonce, pow.Block.Timestamp, pow.HashTxns(), pow.Block.MinerID, pow.Block.HashAlgo)
+}
+
+// headerToBytes returns the bytes hashed for a header. hashAlgo is empty for SHA-256, which keeps the hashes of
+// older chains
+func headerToBytes(prevHash []byte, blockNum uint32, nonce uint32, timestamp int64, txnRoot []byte, minerID string, hashAlgo string) []byte {
 	data := bytes.Join(
 		[][]byte{
-			pow.Block.PrevHash,
-			NumToBytes(uint32(pow.Block.BlockNum)),
+			prevHash,
+			NumToBytes(uint32(blockNum)),
 			NumToBytes(nonce),
-			pow.HashTxns(),
-			[]byte(pow.Block.MinerID),
+			NumToBytes(uint32(uint64(timestamp) >> 32)),
+			NumToBytes(uint32(timestamp)),
+			txnRoot,
+			[]byte(minerID),
+			[]byte(hashAlgo),
 		},
 		[]byte{},
 	)
 	return data
 }
 
+// SplitAtNonce returns the bytes hashed for a block header around its nonce, so that external solvers can
+// grind hash(prefix || nonce || suffix), with the hash algorithm of HashAlgo and the nonce as 4 big endian bytes
+func (h *BlockHeader) SplitAtNonce() (prefix []byte, suffix []byte) {
+	data := headerToBytes(h.PrevHash, h.BlockNum, 0, h.Timestamp, h.MerkleRoot, h.MinerID, h.HashAlgo)
+	nonceAt := len(h.PrevHash) + 4
+	return data[:nonceAt], data[nonceAt+4:]
+}
+
 func NumToBytes(num uint32) []byte {
 	buff := new(bytes.Buffer)
 	err := binary.Write(buff, binary.BigEndian, num)
@@ -93,27 +195,12 @@ func NumToBytes(num uint32) []byte {
 	return buff.Bytes()
 }
 
+// HashTxns returns the Merkle root of the block's transactions
 func (pow *ProofOfWork) HashTxns() []byte {
-	var txBytes [][]byte
-	var txHash [32]byte
-
-	for _, tx := range pow.Block.Txns {
-		txBytes = append(txBytes, EncodeTxn(tx))
-	}
-
-	txHash = sha256.Sum256(bytes.Join(txBytes, []byte{}))
-	return txHash[:]
+	return MerkleRoot(pow.Block.Txns)
 }
 
+// EncodeTxn returns the bytes a block commits to for a txn, see Transaction.CanonicalEncoding
 func EncodeTxn(tx *Transaction) []byte {
-	str := tx.Data.VoterCandidate + tx.Data.VoterName + tx.Data.VoterStudentID
-	data := bytes.Join(
-		[][]byte{
-			[]byte(str),
-			tx.Signature,
-			tx.ID,
-			tx.PublicKey,
-		}, []byte{},
-	)
-	return data
+	return tx.CanonicalEncoding()
 }
@@ -0,0 +1,123 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PutStatus tells what Put did with a block
+type PutStatus int
+
+const (
+	// PutExtended means the block was added on top of the longest chain
+	PutExtended PutStatus = iota
+	// PutSwitchedFork means the block was added and made its fork the longest chain
+	PutSwitchedFork
+	// PutStaleFork means the block was added to a fork that is not the longest chain
+	PutStaleFork
+	// PutDuplicate means the block is already stored
+	PutDuplicate
+	// PutOrphan means the parent of the block, or a block of the fork it would switch to, is not known, e.g. it
+	// arrived out of order
+	PutOrphan
+	// PutBadPoW means the proof of work of the block is invalid
+	PutBadPoW
+	// PutInvalidTxn means a txn in the block is invalid on the chain the block extends
+	PutInvalidTxn
+	// PutInvalid means the block is malformed or its miner, signature, timestamp or hash algorithm is wrong
+	PutInvalid
+	// PutFailed means the block could not be stored, e.g. the database failed. The block itself may be valid
+	PutFailed
+)
+
+var putStatusNames = [...]string{
+	PutExtended:     "extended",
+	PutSwitchedFork: "switched fork",
+	PutStaleFork:    "stale fork",
+	PutDuplicate:    "duplicate",
+	PutOrphan:       "orphan",
+	PutBadPoW:       "bad pow",
+	PutInvalidTxn:   "invalid txn",
+	PutInvalid:      "invalid",
+	PutFailed:       "failed",
+}
+
+func (s PutStatus) String() string {
+	if s < 0 || int(s) >= len(putStatusNames) {
+		return fmt.Sprintf("PutStatus(%d)", int(s))
+	}
+	return putStatusNames[s]
+}
+
+// Added reports whether the block was stored, whether or not it is on the longest chain
+func (s PutStatus) Added() bool {
+	return s == PutExtended || s == PutSwitchedFork || s == PutStaleFork
+}
+
+// Invalid reports whether the block was rejected for its content. duplicates and orphans are not
+// invalid, as honest blocks arriving twice or out of order end up so
+func (s PutStatus) Invalid() bool {
+	return s == PutBadPoW || s == PutInvalidTxn || s == PutInvalid
+}
+
+// PutResult is the outcome of putting a block, see Put and PutBatch
+type PutResult struct {
+	Status   PutStatus
+	Err      error // why the block was rejected, nil if it was added
+	NewTxns  []*Transaction
+	OldTxns  []*Transaction
+	LastHash []byte // last hash of the longest chain right after the block was put
+	// whether an invalid block passed its proof of work and is signed by the key of its MinerID, so its miner
+	// did send it. the MinerID of any other rejected block may have been copied by whoever made it up
+	Attributed bool
+	// changes of the tally at the heights a fork switch replaced the blocks of, see TallyCorrection
+	TallyCorrections []TallyCorrection
+}
+
+// Added reports whether the block was stored
+func (r PutResult) Added() bool {
+	return r.Status.Added()
+}
+
+// rejectBlock logs why a block is not added at a level matching the reason and returns the result
+func rejectBlock(block *Block, status PutStatus, err error) PutResult {
+	level := "[WARN]"
+	if status == PutFailed {
+		level = "[ERROR]"
+	} else if !status.Invalid() {
+		level = "[INFO]"
+	}
+	log.Printf("%s Block (%x) will not be added to the chain (%v): %v\n", level, shortHash(block.Hash), status, err)
+	return PutResult{Status: status, Err: err}
+}
+
+// rejectSignedBlock is rejectBlock for a block whose proof of work and miner signature are checked already.
+// signed is whether the signature was verified, see PutResult.Attributed
+func rejectSignedBlock(block *Block, status PutStatus, err error, signed bool) PutResult {
+	result := rejectBlock(block, status, err)
+	result.Attributed = signed && status.Invalid()
+	return result
+}
+
+// signedByMiner reports whether block is signed by the key its MinerID registered with
+func (bc *BlockChain) signedByMiner(block *Block) bool {
+	if bc.MinerKey == nil {
+		return false
+	}
+	key, known := bc.MinerKey(block.MinerID)
+	return known && key != nil && VerifyMinerSignature(key, block.Hash, block.MinerSignature)
+}
+
+// shortHash returns a prefix of a hash for logging. it tolerates malformed blocks with short hashes
+func shortHash(hash []byte) []byte {
+	if len(hash) > 5 {
+		return hash[:5]
+	}
+	return hash
+}
+
+var (
+	errMissingValues = errors.New("block has missing values")
+	errBadPoW        = errors.New("invalid proof of work")
+)
@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+// newTestChain is an in-memory chain from a fresh genesis block, knowing only the miners in keys
+func newTestChain(t *testing.T, keys map[string][]byte) *BlockChain {
+	t.Helper()
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	bc := NewBlockChain(db, nil)
+	if err := bc.Init(GenesisConfig{ElectionID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	bc.MinerKey = func(minerID string) ([]byte, bool) {
+		key, ok := keys[minerID]
+		return key, ok
+	}
+	return bc
+}
+
+// mineOn mines an empty block by minerID on top of the chain, timestamped at, and signs it with key
+func mineOn(t *testing.T, bc *BlockChain, minerID string, at time.Time, key *ecdsa.PrivateKey) Block {
+	t.Helper()
+	block := Block{
+		PrevHash:  bc.GetLastHash(),
+		BlockNum:  1,
+		Timestamp: at.Unix(),
+		Txns:      []*Transaction{},
+		MinerID:   minerID,
+	}
+	NewProof(&block).Run()
+	if err := block.SignMiner(key); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestAttributedRejections(t *testing.T) {
+	honest, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc := newTestChain(t, map[string][]byte{"honest": elliptic.Marshal(elliptic.P256(), honest.X, honest.Y), "keyless": nil})
+	future := time.Now().Add(2 * MaxClockDrift)
+
+	signed := mineOn(t, bc, "honest", future, honest)
+	forged := mineOn(t, bc, "honest", future, forger)
+	badPoW := signed
+	badPoW.Nonce++
+	unknown := mineOn(t, bc, "unknown", future, forger)
+	keyless := mineOn(t, bc, "keyless", future, forger)
+
+	for _, tc := range []struct {
+		name       string
+		block      Block
+		status     PutStatus
+		attributed bool
+	}{
+		{"signed by the miner", signed, PutInvalid, true},
+		{"signed by another key", forged, PutInvalid, false},
+		{"bad proof of work", badPoW, PutBadPoW, false},
+		{"unknown miner", unknown, PutInvalid, false},
+		{"miner without a key", keyless, PutInvalid, false},
+	} {
+		result := bc.Put(tc.block, false)
+		if result.Status != tc.status || result.Attributed != tc.attributed {
+			t.Errorf("%s: %v, attributed %v, want %v, attributed %v", tc.name, result.Status, result.Attributed,
+				tc.status, tc.attributed)
+		}
+		batched := bc.PutBatch([]Block{tc.block})[0]
+		if batched.Status != tc.status || batched.Attributed != tc.attributed {
+			t.Errorf("%s in a batch: %v, attributed %v, want %v, attributed %v", tc.name, batched.Status,
+				batched.Attributed, tc.status, tc.attributed)
+		}
+	}
+}
+
+func TestOrphanChecks(t *testing.T) {
+	honest, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc := newTestChain(t, map[string][]byte{"honest": elliptic.Marshal(elliptic.P256(), honest.X, honest.Y)})
+	orphan := func(minerID string, key *ecdsa.PrivateKey) Block {
+		block := mineOn(t, bc, minerID, time.Now(), key)
+		block.PrevHash = []byte("parent nobody has")
+		block.BlockNum = 2
+		NewProof(&block).Run()
+		if err := block.SignMiner(key); err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+	badPoW := orphan("honest", honest)
+	badPoW.Nonce++
+
+	// an orphan is checked as far as it can be without its parent
+	for _, tc := range []struct {
+		name   string
+		block  Block
+		status PutStatus
+	}{
+		{"signed by the miner", orphan("honest", honest), PutOrphan},
+		{"signed by another key", orphan("honest", forger), PutInvalid},
+		{"bad proof of work", badPoW, PutBadPoW},
+		{"unknown miner", orphan("unknown", forger), PutInvalid},
+	} {
+		if result := bc.Put(tc.block, false); result.Status != tc.status {
+			t.Errorf("%s: %v, want %v", tc.name, result.Status, tc.status)
+		}
+	}
+}
+
+func TestDifficulty(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bc := newTestChain(t, map[string][]byte{"miner": elliptic.Marshal(elliptic.P256(), key.X, key.Y)})
+	if err := bc.SetDifficulty(NumZeros + 4); err != nil {
+		t.Fatal(err)
+	}
+	// a block that only meets NumZeros
+	at := time.Now()
+	easy := mineOn(t, bc, "miner", at, key)
+	for header := easy.Header(); header.ValidateWithDifficulty(NumZeros + 4); header = easy.Header() {
+		at = at.Add(-time.Second)
+		easy = mineOn(t, bc, "miner", at, key)
+	}
+	hard := Block{PrevHash: bc.GetLastHash(), BlockNum: 1, Timestamp: at.Unix(), Txns: []*Transaction{}, MinerID: "miner"}
+	NewProofWithDifficulty(&hard, bc.BlockDifficulty()).Run()
+	if err := hard.SignMiner(key); err != nil {
+		t.Fatal(err)
+	}
+	if result := bc.Put(easy, false); result.Status != PutBadPoW {
+		t.Errorf("block below the difficulty: %v, want %v", result.Status, PutBadPoW)
+	}
+	if result := bc.PutBatch([]Block{easy})[0]; result.Status != PutBadPoW {
+		t.Errorf("block below the difficulty in a batch: %v, want %v", result.Status, PutBadPoW)
+	}
+	if result := bc.Put(hard, false); !result.Status.Added() {
+		t.Errorf("block at the difficulty: %v", result.Status)
+	}
+
+	// the node restarts with the difficulty of the chain
+	resumed := NewBlockChain(bc.DB, nil)
+	if err := resumed.ResumeFromDB(); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.BlockDifficulty() != NumZeros+4 {
+		t.Errorf("difficulty after a restart %d, want %d", resumed.BlockDifficulty(), NumZeros+4)
+	}
+	if err := resumed.VerifyStored(); err != nil {
+		t.Errorf("VerifyStored: %v", err)
+	}
+}
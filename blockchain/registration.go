@@ -0,0 +1,163 @@
+package blockchain
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// An election with a registrar only counts ballots of registered voters. The genesis block commits to the
+// registrar's public key, and a ballot is valid only with a credential: the registrar's signature of the voter's
+// public key for the chain (see CredentialDigest), carried in Transaction.Credential. Coord is the registrar, and
+// issues credentials to the voters its RegistrationVerifier accepts. Elections without a registrar accept
+// ballots from any key and their txns carry no credential. A dev chain also lists student IDs that vote without
+// a credential, so tests and demos vote right away. Nodes only run such a chain in dev mode.
+
+// RegistrationKey holds the Registration of the chain
+var RegistrationKey = []byte("Registration")
+
+// MaxCredentialLen is the length limit of Transaction.Credential, an ASN.1 P-256 signature
+const MaxCredentialLen = 72
+
+var (
+	ErrNotRegistered        = errors.New("voter has no valid credential for the election")
+	ErrBadRegistrarKey      = errors.New("registrar key is not a P-256 public key")
+	ErrUnexpectedCredential = errors.New("txn carries a credential in an election without registration")
+)
+
+// Registration is who may vote in an election, committed to by its genesis block. Anyone may if it is empty
+type Registration struct {
+	RegistrarKey []byte   // public key (X || Y on P-256) signing voter credentials, see IssueCredential
+	DevVoters    []string // student IDs whose ballots need no credential. dev chains only, see Dev
+}
+
+// Required checks whether ballots need a credential
+func (r Registration) Required() bool {
+	return len(r.RegistrarKey) > 0
+}
+
+// Dev checks whether the registration is of a dev chain, which production nodes refuse
+func (r Registration) Dev() bool {
+	return len(r.DevVoters) > 0
+}
+
+// devVoter checks whether studentID is one of DevVoters
+func (r Registration) devVoter(studentID string) bool {
+	for _, id := range r.DevVoters {
+		if id == studentID {
+			return true
+		}
+	}
+	return false
+}
+
+// appendCommitment appends the registration to a genesis commitment. Nothing for an empty registration, so the
+// genesis blocks of elections without one keep their hash
+func (r Registration) appendCommitment(commitment []byte) []byte {
+	if !r.Required() {
+		return commitment
+	}
+	commitment = appendField(commitment, []byte("registration"))
+	commitment = appendField(commitment, r.RegistrarKey)
+	if r.Dev() {
+		commitment = appendField(commitment, []byte("dev-voters"))
+		commitment = appendUvarint(commitment, uint64(len(r.DevVoters)))
+		for _, id := range r.DevVoters {
+			commitment = appendField(commitment, []byte(id))
+		}
+	}
+	return commitment
+}
+
+// RegistrarPublicKey returns the public key of a registrar key, as committed to in genesis
+func RegistrarPublicKey(key *ecdsa.PrivateKey) []byte {
+	return SealingPublicKey(key)
+}
+
+// CredentialDigest is what a credential signs: the voter's public key on the chain with the given genesis block
+func CredentialDigest(genesis []byte, publicKey []byte) []byte {
+	digest := appendField(nil, []byte("credential"))
+	digest = appendField(digest, genesis)
+	digest = appendField(digest, publicKey)
+	hash := sha256.Sum256(digest)
+	return hash[:]
+}
+
+// IssueCredential signs the credential of the voter with publicKey on the chain with the given genesis block
+func IssueCredential(registrar *ecdsa.PrivateKey, genesis []byte, publicKey []byte) ([]byte, error) {
+	return ecdsa.SignASN1(rand.Reader, registrar, CredentialDigest(genesis, publicKey))
+}
+
+// VerifyCredential checks that credential is the registrar's signature of publicKey on the chain with the given
+// genesis block
+func VerifyCredential(registrarKey []byte, genesis []byte, publicKey []byte, credential []byte) bool {
+	pub, err := registrarPublicKey(registrarKey)
+	if err != nil {
+		return false
+	}
+	return ecdsa.VerifyASN1(pub, CredentialDigest(genesis, publicKey), credential)
+}
+
+func registrarPublicKey(registrarKey []byte) (*ecdsa.PublicKey, error) {
+	if len(registrarKey) != 64 {
+		return nil, ErrBadRegistrarKey
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(registrarKey[:32]),
+		Y:     new(big.Int).SetBytes(registrarKey[32:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, ErrBadRegistrarKey
+	}
+	return pub, nil
+}
+
+// SetRegistration sets and stores who may vote on the chain
+func (bc *BlockChain) SetRegistration(registration Registration) error {
+	data, err := encode(registration)
+	if err != nil {
+		return err
+	}
+	if err = bc.DB.Put(RegistrationKey, data); err != nil {
+		return err
+	}
+	bc.Registration = registration
+	return nil
+}
+
+// loadRegistration reads the registration stored by SetRegistration. None if it was never stored
+func (bc *BlockChain) loadRegistration() error {
+	if !bc.DB.KeyExist(RegistrationKey) {
+		return nil
+	}
+	data, err := bc.DB.Get(RegistrationKey)
+	if err != nil {
+		return err
+	}
+	return decode(data, &bc.Registration)
+}
+
+// checkCredential checks the credential of a ballot. The unseal txn is coord's, and ballots of dev voters need
+// none
+func (bc *BlockChain) checkCredential(txn *Transaction) error {
+	if !bc.Registration.Required() {
+		if len(txn.Credential) > 0 {
+			return ErrUnexpectedCredential
+		}
+		return nil
+	}
+	if txn.Unseals() {
+		return nil
+	}
+	if txn.Data != nil && bc.Registration.devVoter(txn.Data.VoterStudentID) {
+		return nil
+	}
+	if !VerifyCredential(bc.Registration.RegistrarKey, txn.Genesis, txn.PublicKey, txn.Credential) {
+		return ErrNotRegistered
+	}
+	return nil
+}
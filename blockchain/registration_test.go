@@ -0,0 +1,101 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func TestCredentials(t *testing.T) {
+	registrar, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forger, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	registration := Registration{RegistrarKey: RegistrarPublicKey(registrar)}
+
+	db := &util.Database{}
+	if err = db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	bc := NewBlockChain(db, nil)
+	if err = bc.Init(GenesisConfig{ElectionID: "test", CandidateHash: CandidateSetHash(nil), Registration: registration}); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.SetRegistration(registration); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.CheckCandidates("test"); err != nil {
+		t.Fatalf("CheckCandidates: %v", err)
+	}
+	genesis := bc.GenesisHash()
+
+	// vote is a ballot of a new voter with the credential issue gives its key
+	vote := func(issue func(publicKey []byte) []byte) *Transaction {
+		voter := Identity.NewWallet()
+		txn := &Transaction{Data: &Ballot{VoterName: "voter", VoterStudentID: "12345678"},
+			PublicKey: voter.PublicKey, Genesis: genesis}
+		txn.Credential = issue(voter.PublicKey)
+		txn.Sign(voter.PrivateKey)
+		return txn
+	}
+	issuer := func(key *ecdsa.PrivateKey, chain []byte) func([]byte) []byte {
+		return func(publicKey []byte) []byte {
+			credential, err := IssueCredential(key, chain, publicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return credential
+		}
+	}
+	registered := vote(issuer(registrar, genesis))
+	if err = bc.checkCredential(registered); err != nil {
+		t.Fatalf("registered voter: %v", err)
+	}
+	decoded, err := DecodeTransaction(registered.Serialize())
+	if err != nil || !bytes.Equal(decoded.Credential, registered.Credential) || !decoded.Verify() {
+		t.Fatalf("credential does not survive encoding: %v", err)
+	}
+
+	for name, issue := range map[string]func([]byte) []byte{
+		"no credential":                   func([]byte) []byte { return nil },
+		"credential of another key":       func([]byte) []byte { return registered.Credential },
+		"credential of another registrar": issuer(forger, genesis),
+		"credential of another chain":     issuer(registrar, []byte("another genesis block")),
+	} {
+		if err = bc.checkCredential(vote(issue)); !errors.Is(err, ErrNotRegistered) {
+			t.Errorf("%s: %v, want ErrNotRegistered", name, err)
+		}
+	}
+
+	// ballots of dev voters need none, and the genesis block of a dev chain differs
+	dev := registration
+	dev.DevVoters = []string{"12345678"}
+	bc.Registration = dev
+	if err = bc.checkCredential(vote(func([]byte) []byte { return nil })); err != nil {
+		t.Fatalf("dev voter: %v", err)
+	}
+	bc.Registration = registration
+	devGenesis := GenesisConfig{ElectionID: "test", CandidateHash: CandidateSetHash(nil), Registration: dev}.Block()
+	if bytes.Equal(devGenesis.Hash, genesis) {
+		t.Fatal("genesis block does not commit to the dev voters")
+	}
+
+	// the genesis block commits to the registration
+	if err = bc.SetRegistration(Registration{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.CheckCandidates("test"); err == nil {
+		t.Fatal("chain accepts a registration its genesis block does not commit to")
+	}
+	if err = bc.checkCredential(registered); !errors.Is(err, ErrUnexpectedCredential) {
+		t.Fatalf("credential without registration: %v, want ErrUnexpectedCredential", err)
+	}
+}
@@ -0,0 +1,92 @@
+package blockchain
+
+// Tally methods of a race
+const (
+	MethodPlurality = ""    // one vote per candidate choice, most votes wins
+	MethodIRV       = "irv" // instant-runoff over ranked ballots
+)
+
+// RunoffRound is one round of an instant-runoff tally
+type RunoffRound struct {
+	Counts     map[string]uint // votes of each remaining candidate
+	Exhausted  uint            // ballots with no remaining candidate ranked
+	Eliminated string          // candidate eliminated after this round. empty in the last round
+}
+
+// RunoffResult is the outcome of an instant-runoff tally
+type RunoffResult struct {
+	Rounds []RunoffRound
+	Winner string // empty if there are no ballots
+}
+
+// InstantRunoff tallies ranked ballots. In every round, each ballot counts for its highest ranked
+// remaining candidate. A candidate with a majority of those votes wins, otherwise the candidate
+// with the fewest votes is eliminated. Ties are broken by the fewest votes in the earliest
+// round in which the tied candidates differ, then by the latest position in candidates.
+func InstantRunoff(candidates []string, rankings [][]string) RunoffResult {
+	var result RunoffResult
+	remaining := make(map[string]bool)
+	for _, cand := range candidates {
+		remaining[cand] = true
+	}
+	for len(remaining) > 0 {
+		round := RunoffRound{Counts: make(map[string]uint)}
+		for cand := range remaining {
+			round.Counts[cand] = 0
+		}
+		var total uint
+		for _, ranking := range rankings {
+			counted := false
+			for _, cand := range ranking {
+				if remaining[cand] {
+					round.Counts[cand]++
+					total++
+					counted = true
+					break
+				}
+			}
+			if !counted {
+				round.Exhausted++
+			}
+		}
+		if total == 0 {
+			result.Rounds = append(result.Rounds, round)
+			return result
+		}
+		for _, cand := range candidates {
+			if remaining[cand] && (round.Counts[cand]*2 > total || len(remaining) == 1) {
+				result.Rounds = append(result.Rounds, round)
+				result.Winner = cand
+				return result
+			}
+		}
+		round.Eliminated = lastPlace(candidates, remaining, round, result.Rounds)
+		delete(remaining, round.Eliminated)
+		result.Rounds = append(result.Rounds, round)
+	}
+	return result
+}
+
+// lastPlace returns the remaining candidate to eliminate after round, see InstantRunoff for tie breaking
+func lastPlace(candidates []string, remaining map[string]bool, round RunoffRound, previous []RunoffRound) string {
+	var loser string
+	for _, cand := range candidates {
+		if remaining[cand] && (loser == "" || eliminatedBefore(cand, loser, round, previous)) {
+			loser = cand
+		}
+	}
+	return loser
+}
+
+// eliminatedBefore reports whether a is eliminated before b, where a is listed after b
+func eliminatedBefore(a string, b string, round RunoffRound, previous []RunoffRound) bool {
+	if round.Counts[a] != round.Counts[b] {
+		return round.Counts[a] < round.Counts[b]
+	}
+	for _, prev := range previous {
+		if prev.Counts[a] != prev.Counts[b] {
+			return prev.Counts[a] < prev.Counts[b]
+		}
+	}
+	return true
+}
@@ -0,0 +1,298 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"sort"
+)
+
+// In an election with sealed ballots, the choice of every ballot (type, candidate and ranking) is encrypted to
+// the sealing key of the election, committed to by the genesis block. Voter and race stay in the clear, so
+// miners still check who votes and how often, but nobody can count the ballots while the election is open.
+// After the deadline, coord releases the private key in an unseal txn, and once that txn is final the tally
+// opens the ballots of the chain that carries it, listed in an order shuffled by the key.
+//
+// A sealed choice is ephemeral P-256 key (65 bytes) | AES-GCM nonce (12 bytes) | AES-256-GCM ciphertext, keyed
+// with the SHA-256 of the ECDH secret and the ephemeral key. The plaintext is the canonical encoding of
+// Type | VoterCandidate | len(Ranking) | Ranking..., zero padded to a multiple of sealPadding bytes.
+
+const (
+	MaxSealedLen = 512 // of Ballot.Sealed
+	sealPadding  = 64
+	sealKeyLen   = 65 // uncompressed P-256 point
+	sealNonceLen = 12
+)
+
+var (
+	ErrNotSealed     = errors.New("ballots of the election are not sealed")
+	ErrBadSealingKey = errors.New("not the sealing key of the election")
+)
+
+// SealBallot returns the ballot with its choice encrypted to sealingKey (X || Y on P-256)
+func SealBallot(sealingKey []byte, ballot *Ballot) (*Ballot, error) {
+	pub, err := sealingPublicKey(sealingKey)
+	if err != nil {
+		return nil, err
+	}
+	plain := appendField(nil, []byte(ballot.Type))
+	plain = appendField(plain, []byte(ballot.VoterCandidate))
+	plain = appendUvarint(plain, uint64(len(ballot.Ranking)))
+	for _, name := range ballot.Ranking {
+		plain = appendField(plain, []byte(name))
+	}
+	if rem := len(plain) % sealPadding; rem > 0 {
+		plain = append(plain, make([]byte, sealPadding-rem)...)
+	}
+
+	ephemeral, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey := elliptic.Marshal(elliptic.P256(), ephemeral.X, ephemeral.Y)
+	sx, _ := elliptic.P256().ScalarMult(pub.X, pub.Y, ephemeral.D.Bytes())
+	aead, err := sealCipher(sx, ephemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, sealNonceLen)
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append(ephemeralKey, nonce...), aead.Seal(nil, nonce, plain, nil)...)
+	if len(sealed) > MaxSealedLen {
+		return nil, fmt.Errorf("%w: sealed choice of %d bytes", ErrTxnTooLarge, len(sealed))
+	}
+	return &Ballot{
+		VoterName:      ballot.VoterName,
+		VoterStudentID: ballot.VoterStudentID,
+		Race:           ballot.Race,
+		Type:           BallotSealed,
+		Sealed:         sealed,
+	}, nil
+}
+
+// OpenBallot decrypts a sealed ballot with the private sealing key and returns the ballot as cast
+func OpenBallot(key *ecdsa.PrivateKey, ballot *Ballot) (*Ballot, error) {
+	if ballot.Type != BallotSealed {
+		return nil, errors.New("ballot is not sealed")
+	}
+	if len(ballot.Sealed) < sealKeyLen+sealNonceLen {
+		return nil, errors.New("sealed choice is too short")
+	}
+	ephemeralKey := ballot.Sealed[:sealKeyLen]
+	nonce := ballot.Sealed[sealKeyLen : sealKeyLen+sealNonceLen]
+	x, y := elliptic.Unmarshal(elliptic.P256(), ephemeralKey)
+	if x == nil {
+		return nil, errors.New("sealed choice has an invalid key")
+	}
+	sx, _ := elliptic.P256().ScalarMult(x, y, key.D.Bytes())
+	aead, err := sealCipher(sx, ephemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, nonce, ballot.Sealed[sealKeyLen+sealNonceLen:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(plain)
+	opened := &Ballot{VoterName: ballot.VoterName, VoterStudentID: ballot.VoterStudentID, Race: ballot.Race}
+	var typ, candidate []byte
+	if typ, err = readField(r); err == nil {
+		candidate, err = readField(r)
+	}
+	var n uint64
+	if err == nil {
+		n, err = binary.ReadUvarint(r)
+	}
+	if err == nil && n > MaxRankingLen {
+		err = errors.New("ranking is too long")
+	}
+	for i := uint64(0); err == nil && i < n; i++ {
+		var name []byte
+		if name, err = readField(r); err == nil {
+			opened.Ranking = append(opened.Ranking, string(name))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed sealed choice: %v", err)
+	}
+	opened.Type = string(typ)
+	opened.VoterCandidate = string(candidate)
+	if opened.Type == BallotSealed || opened.Type == BallotUnseal {
+		return nil, errors.New("sealed choice of type " + opened.Type)
+	}
+	return opened, nil
+}
+
+// NewUnsealTxn returns the txn releasing the private sealing key of the chain with the given genesis block,
+// signed by that key
+func NewUnsealTxn(key *ecdsa.PrivateKey, genesis []byte) Transaction {
+	txn := Transaction{
+		Data:      &Ballot{Type: BallotUnseal, Sealed: key.D.FillBytes(make([]byte, 32))},
+		PublicKey: SealingPublicKey(key),
+		Genesis:   genesis,
+	}
+	txn.Sign(*key)
+	return txn
+}
+
+// SealingPublicKey returns the public key of a sealing key as found on the chain, X || Y on P-256
+func SealingPublicKey(key *ecdsa.PrivateKey) []byte {
+	pubKey := make([]byte, 64)
+	key.X.FillBytes(pubKey[:32])
+	key.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+// SetSealingKey sets and stores the public key ballots are sealed to. Ballots are not sealed if it is empty
+func (bc *BlockChain) SetSealingKey(sealingKey []byte) error {
+	if err := bc.DB.Put(SealingKeyKey, sealingKey); err != nil {
+		return err
+	}
+	bc.SealingKey = sealingKey
+	return nil
+}
+
+// unsealKey checks an unseal txn against the sealing key of the chain and returns the private key it releases
+func (bc *BlockChain) unsealKey(txn *Transaction) (*ecdsa.PrivateKey, error) {
+	if len(bc.SealingKey) == 0 {
+		return nil, ErrNotSealed
+	}
+	if !bytes.Equal(txn.PublicKey, bc.SealingKey) {
+		return nil, ErrBadSealingKey
+	}
+	pub, err := sealingPublicKey(bc.SealingKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &ecdsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(txn.Data.Sealed)}
+	x, y := elliptic.P256().ScalarBaseMult(txn.Data.Sealed)
+	if x.Cmp(pub.X) != 0 || y.Cmp(pub.Y) != 0 {
+		return nil, ErrBadSealingKey
+	}
+	return key, nil
+}
+
+// openTxns replaces the sealed ballots of txns (newest first, as from countedTxns) by the ballots as cast, if
+// the key was released by one of txns with RequiredConfirmations, as given by confirmations (txn ID -> blocks
+// confirming it). Sealed ballots are left out while the key is not released and final, so a fork dropping the
+// unseal txn cannot take back opened ballots, and so are opened ballots that are invalid or conflict with
+// earlier ballots of the voter
+func (bc *BlockChain) openTxns(txns []*Transaction, confirmations map[string]int) []*Transaction {
+	var key *ecdsa.PrivateKey
+	for _, txn := range txns {
+		if txn.Unseals() && confirmations[string(txn.ID)] >= bc.RequiredConfirmations() {
+			var err error
+			if key, err = bc.unsealKey(txn); err == nil {
+				break
+			}
+		}
+	}
+	var opened []*Transaction
+	var slots []int // of the opened ballots in opened
+	for i := len(txns) - 1; i >= 0; i-- {
+		txn := txns[i]
+		if txn.Data.Type != BallotSealed {
+			opened = append(opened, txn)
+			continue
+		}
+		if key == nil {
+			continue
+		}
+		ballot, err := OpenBallot(key, txn.Data)
+		if err == nil {
+			var race *Identity.Candidate
+			if race, err = bc.checkChoice(ballot); err == nil && conflicts(&Transaction{Data: ballot, PublicKey: txn.PublicKey}, race.MaxVotes, opened) {
+				err = errors.New("conflicts with an earlier ballot of the voter")
+			}
+		}
+		if err != nil {
+			log.Printf("[INFO] Sealed ballot %x does not count: %v\n", txn.ID, err)
+			continue
+		}
+		openedTxn := *txn
+		openedTxn.Data = ballot
+		opened = append(opened, &openedTxn)
+		slots = append(slots, len(opened)-1)
+	}
+	shuffleOpened(key, opened, slots)
+	for i, j := 0, len(opened)-1; i < j; i, j = i+1, j-1 {
+		opened[i], opened[j] = opened[j], opened[i]
+	}
+	return opened
+}
+
+// shuffleOpened reorders the opened ballots at the given slots of txns by the hash of the released key and
+// their txn ID, so the opened ballots are listed in an order anyone can reproduce from the chain but not in the
+// order they were cast
+func shuffleOpened(key *ecdsa.PrivateKey, txns []*Transaction, slots []int) {
+	if key == nil || len(slots) < 2 {
+		return
+	}
+	seed := key.D.FillBytes(make([]byte, 32))
+	order := func(txn *Transaction) []byte {
+		hash := sha256.Sum256(append(append([]byte{}, seed...), txn.ID...))
+		return hash[:]
+	}
+	ballots := make([]*Transaction, len(slots))
+	for i, slot := range slots {
+		ballots[i] = txns[slot]
+	}
+	sort.Slice(ballots, func(i, j int) bool {
+		return bytes.Compare(order(ballots[i]), order(ballots[j])) < 0
+	})
+	for i, slot := range slots {
+		txns[slot] = ballots[i]
+	}
+}
+
+func sealingPublicKey(sealingKey []byte) (*ecdsa.PublicKey, error) {
+	if len(sealingKey) != 64 {
+		return nil, ErrBadSealingKey
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(sealingKey[:32]),
+		Y:     new(big.Int).SetBytes(sealingKey[32:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, ErrBadSealingKey
+	}
+	return pub, nil
+}
+
+func sealCipher(sharedX *big.Int, ephemeralKey []byte) (cipher.AEAD, error) {
+	secret := sha256.Sum256(append(sharedX.FillBytes(make([]byte, 32)), ephemeralKey...))
+	block, err := aes.NewCipher(secret[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readField reads a field written by appendField
+func readField(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	field := make([]byte, n)
+	_, err = io.ReadFull(r, field)
+	return field, err
+}
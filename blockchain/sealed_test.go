@@ -0,0 +1,106 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+)
+
+// sealedVote is a txn of a new voter casting a sealed ballot for candidate on the chain with the given genesis
+// block
+func sealedVote(t *testing.T, sealingKey []byte, genesis []byte, candidate string) *Transaction {
+	t.Helper()
+	voter := Identity.NewWallet()
+	sealed, err := SealBallot(sealingKey, &Ballot{VoterName: "voter", VoterStudentID: "12345678",
+		Type: BallotCandidate, VoterCandidate: candidate})
+	if err != nil {
+		t.Fatalf("SealBallot: %v", err)
+	}
+	txn := &Transaction{Data: sealed, PublicKey: voter.PublicKey, Genesis: genesis}
+	txn.Sign(voter.PrivateKey)
+	return txn
+}
+
+func TestSealUnsealTally(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &BlockChain{
+		Candidates: []*Identity.Wallets{
+			Identity.CandidateWithAddress("Alice", "alice"),
+			Identity.CandidateWithAddress("Bob", "bob"),
+		},
+		SealingKey: SealingPublicKey(key),
+	}
+	genesis := []byte("genesis")
+	// newest first, as countedTxns returns them
+	ballots := []*Transaction{
+		sealedVote(t, bc.SealingKey, genesis, "Bob"),
+		sealedVote(t, bc.SealingKey, genesis, "Alice"),
+		sealedVote(t, bc.SealingKey, genesis, "Alice"),
+	}
+
+	// a sealed ballot hides its choice
+	opened, err := OpenBallot(key, ballots[0].Data)
+	if err != nil {
+		t.Fatalf("OpenBallot: %v", err)
+	}
+	if opened.Type != BallotCandidate || opened.VoterCandidate != "Bob" || opened.Race != "" {
+		t.Fatalf("opened %+v, want a ballot for Bob", opened)
+	}
+	if len(ballots[0].Data.VoterCandidate) > 0 {
+		t.Fatal("sealed ballot names its candidate")
+	}
+
+	// nothing counts while the key is not released
+	if counted := bc.openTxns(ballots, nil); len(counted) != 0 {
+		t.Fatalf("%d ballots counted before the key was released", len(counted))
+	}
+
+	unseal := NewUnsealTxn(key, genesis)
+	if _, err = bc.unsealKey(&unseal); err != nil {
+		t.Fatalf("unsealKey: %v", err)
+	}
+	withUnseal := append([]*Transaction{&unseal}, ballots...)
+	// nor while the unseal txn is not final
+	if counted := bc.openTxns(withUnseal, map[string]int{string(unseal.ID): bc.RequiredConfirmations() - 1}); len(counted) != 1 {
+		t.Fatalf("%d txns counted before the unseal txn was final, want only the unseal txn", len(counted))
+	}
+	counted := bc.openTxns(withUnseal, map[string]int{string(unseal.ID): bc.RequiredConfirmations()})
+	votes := make([]uint, len(bc.Candidates))
+	for _, txn := range counted {
+		if txn.Unseals() {
+			continue
+		}
+		if idx := bc.candidateIndex(txn); idx >= 0 {
+			votes[idx]++
+		}
+	}
+	if votes[0] != 2 || votes[1] != 1 {
+		t.Fatalf("tally %v, want [2 1]", votes)
+	}
+
+	// opened ballots are listed in an order set by the key, not in the order they were cast
+	reversed := []*Transaction{&unseal, ballots[2], ballots[1], ballots[0]}
+	shuffled := bc.openTxns(reversed, map[string]int{string(unseal.ID): bc.RequiredConfirmations()})
+	for i := range counted {
+		if !bytes.Equal(counted[i].ID, shuffled[i].ID) {
+			t.Fatalf("opened ballots follow the order they were cast in")
+		}
+	}
+
+	// a key that is not the chain's does not open anything
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	forged := NewUnsealTxn(other, genesis)
+	if _, err = bc.unsealKey(&forged); err != ErrBadSealingKey {
+		t.Fatalf("unsealKey of another key: %v, want %v", err, ErrBadSealingKey)
+	}
+	if counted := bc.openTxns(append([]*Transaction{&forged}, ballots...), map[string]int{string(forged.ID): bc.RequiredConfirmations()}); len(counted) != 1 {
+		t.Fatalf("%d txns counted with a forged key, want only the forged unseal txn", len(counted))
+	}
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+)
+
+const SigCacheSize = 4096
+
+// SigCache is an LRU set of transactions whose signatures have been verified, keyed by the txn ID and
+// a hash of its signature and public key. Txns are verified again and again during fork switches,
+// block validation and audits, and a hit skips the elliptic curve math.
+type SigCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is the most recently used. values are keys
+	entries  map[string]*list.Element
+
+	hits   uint64
+	misses uint64
+}
+
+// sigCache is shared by all chains in the process, see Transaction.Verify
+var sigCache = NewSigCache(SigCacheSize)
+
+func NewSigCache(capacity int) *SigCache {
+	return &SigCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Contains checks whether the signature of tx has been verified
+func (c *SigCache) Contains(tx *Transaction) bool {
+	key := sigCacheKey(tx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		atomic.AddUint64(&c.hits, 1)
+		return true
+	}
+	atomic.AddUint64(&c.misses, 1)
+	return false
+}
+
+// Add remembers that the signature of tx is valid and evicts the least recently used entry if the cache is full
+func (c *SigCache) Add(tx *Transaction) {
+	key := sigCacheKey(tx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(key)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(string))
+	}
+}
+
+// Stats returns the number of cache hits and misses so far
+func (c *SigCache) Stats() (hits uint64, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// SigCacheStats returns the hit and miss counters of the signature cache used by Transaction.Verify
+func SigCacheStats() (hits uint64, misses uint64) {
+	return sigCache.Stats()
+}
+
+func sigCacheKey(tx *Transaction) string {
+	hash := sha256.Sum256(append(append([]byte{}, tx.Signature...), tx.PublicKey...))
+	return string(tx.ID) + string(hash[:])
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"time"
+)
+
+// ChainStats summarizes the longest chain and the fork blocks stored next to it. Genesis is not counted.
+type ChainStats struct {
+	Height           uint32         // block number of the last block on the longest chain
+	Blocks           int            // blocks on the longest chain
+	Txns             int            // txns on the longest chain
+	AvgTxnsPerBlock  float64        // Txns / Blocks
+	AvgBlockInterval time.Duration  // mean time between two blocks on the longest chain, by their timestamps
+	Forks            int            // forks branching off the longest chain that are still stored
+	StaleBlocks      int            // stored blocks not on the longest chain
+	MinerBlocks      map[string]int // blocks on the longest chain by miner ID
+}
+
+// Stats computes statistics of the longest chain, e.g. to tune the difficulty
+func (bc *BlockChain) Stats() ChainStats {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	stats := ChainStats{MinerBlocks: make(map[string]int)}
+	canonical := make(map[string]bool)
+	var first, last int64
+	iter := bc.NewIterator(bc.LastHash)
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		canonical[string(block.Hash)] = true
+		if end {
+			break
+		}
+		if stats.Blocks == 0 {
+			stats.Height = block.BlockNum
+			last = block.Timestamp
+		}
+		first = block.Timestamp
+		stats.Blocks++
+		stats.Txns += len(block.Txns)
+		stats.MinerBlocks[block.MinerID]++
+	}
+	if stats.Blocks > 0 {
+		stats.AvgTxnsPerBlock = float64(stats.Txns) / float64(stats.Blocks)
+	}
+	if stats.Blocks > 1 {
+		stats.AvgBlockInterval = time.Duration(last-first) * time.Second / time.Duration(stats.Blocks-1)
+	}
+
+	// a fork starts at a stored block whose parent is on the longest chain but which is not
+	err := bc.exportHeaders(canonical, func(hash []byte, header *BlockHeader) error {
+		stats.StaleBlocks++
+		if canonical[string(header.PrevHash)] {
+			stats.Forks++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("[WARN] Unable to count fork blocks:", err)
+	}
+	return stats
+}
+
+// ForkTip is a stored block no other stored block builds on
+type ForkTip struct {
+	Hash           []byte
+	BlockNum       uint32
+	MinerID        string
+	Timestamp      int64
+	OnLongestChain bool // the tip of the longest chain
+}
+
+// ForkTips returns the tips of the longest chain and of every fork still stored, highest first
+func (bc *BlockChain) ForkTips() ([]ForkTip, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	headers := make(map[string]*BlockHeader)
+	parents := make(map[string]bool)
+	err := bc.exportHeaders(nil, func(hash []byte, header *BlockHeader) error {
+		headers[string(hash)] = header
+		parents[string(header.PrevHash)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tips []ForkTip
+	for hash, header := range headers {
+		if parents[hash] {
+			continue
+		}
+		tips = append(tips, ForkTip{
+			Hash:           header.Hash,
+			BlockNum:       header.BlockNum,
+			MinerID:        header.MinerID,
+			Timestamp:      header.Timestamp,
+			OnLongestChain: bytes.Equal(header.Hash, bc.LastHash),
+		})
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].BlockNum != tips[j].BlockNum {
+			return tips[i].BlockNum > tips[j].BlockNum
+		}
+		return bytes.Compare(tips[i].Hash, tips[j].Hash) < 0
+	})
+	return tips, nil
+}
@@ -0,0 +1,247 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"strconv"
+)
+
+// The tally index holds the cumulative tally of the longest chain at every height: the votes of each candidate,
+// abstentions and write-ins counting every ballot from genesis up to the block at that height, as
+// VotingStatusAt(hash, 0) and ExtraVotesAt(hash, 0) count them.
+// Put adds the record of a block that extends the chain from the record of its parent, and a fork switch
+// rewrites the records above the fork point and returns the corrections, so a tally as of any block of the
+// longest chain is one read instead of a replay of the chain. On a sealed chain ballots only count once the
+// unseal txn is final, so the record of that block, and of any block with sealed ballots above it, is counted
+// from the whole chain. Records name the candidates they count for, and records of other candidates are counted
+// again
+
+// TallyIndexKeyPrefix prefixes the records of the tally index, by height
+const TallyIndexKeyPrefix = "heighttally-"
+
+// HeightTally is the record of the tally index at one height
+type HeightTally struct {
+	Hash       []byte                 // block at the height on the longest chain
+	Votes      []uint                 // votes of each candidate up to the block, in the order of Candidates
+	Extras     map[string]*ExtraVotes // abstentions and write-ins of each race up to the block
+	Candidates []byte                 // CandidateSetHash of the candidates counted for
+	Unseal     []byte                 // block with the first unseal txn up to the block. sealed chains only
+}
+
+// TallyCorrection is the change of the tally at a height whose block a fork switch replaced
+type TallyCorrection struct {
+	Height  uint32
+	OldHash []byte
+	NewHash []byte
+	Old     []uint
+	New     []uint
+}
+
+// TallyAt returns the tally of the chain ending at the block with the given hash from the tally index, counting
+// ballots with at least depth confirmations like VotingStatusAt. That is the record of the block depth blocks
+// down, or an empty tally if there is none. false if the block is not on the longest chain or has no record
+func (bc *BlockChain) TallyAt(hash []byte, depth int) (HeightTally, bool) {
+	header := bc.GetHeader(hash)
+	if header == nil {
+		return HeightTally{}, false
+	}
+	record, ok := bc.heightTally(header.BlockNum)
+	if !ok || !bytes.Equal(record.Hash, hash) {
+		return HeightTally{}, false
+	}
+	if depth <= 0 {
+		return record, true
+	}
+	if depth > int(header.BlockNum) {
+		return HeightTally{Votes: make([]uint, len(bc.Candidates)), Extras: make(map[string]*ExtraVotes)}, true
+	}
+	return bc.heightTally(header.BlockNum - uint32(depth))
+}
+
+// TallyAtHeight returns the record of the tally index at a height of the longest chain
+func (bc *BlockChain) TallyAtHeight(height uint32) (HeightTally, bool) {
+	return bc.heightTally(height)
+}
+
+// syncTallyIndex brings the tally index in line with the chain ending at tip: heights whose record is of
+// another block, from the first one below tip whose record is right, are counted again and stored. It returns
+// the corrections of the heights that had a record of another block. Called with bc.mu held
+func (bc *BlockChain) syncTallyIndex(tip []byte) []TallyCorrection {
+	// walk back to the last block whose record is right
+	var stale []*BlockHeader
+	var base *HeightTally
+	iter := bc.NewIterator(tip)
+	for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+		if record, ok := bc.heightTally(header.BlockNum); ok && bytes.Equal(record.Hash, header.Hash) {
+			base = &record
+			break
+		}
+		stale = append(stale, header)
+		if end {
+			break
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if base == nil {
+		base = &HeightTally{Votes: make([]uint, len(bc.Candidates)), Extras: make(map[string]*ExtraVotes)}
+	}
+
+	var keys, values [][]byte
+	var corrections []TallyCorrection
+	opened := bc.keyFinal(base, base.Hash)
+	for i := len(stale) - 1; i >= 0; i-- {
+		header := stale[i]
+		body := bc.GetBody(header.Hash)
+		if body == nil {
+			log.Printf("[WARN] Unable to index the tally of block %x: its body is missing\n", header.Hash[:5])
+			return nil
+		}
+		record := HeightTally{Hash: header.Hash, Candidates: CandidateSetHash(bc.Candidates), Unseal: base.Unseal}
+		sealed := false
+		for _, txn := range body.Txns {
+			if record.Unseal == nil && txn.Unseals() {
+				if _, err := bc.unsealKey(txn); err == nil {
+					record.Unseal = header.Hash
+				}
+			}
+			sealed = sealed || txn.Data.Type == BallotSealed
+		}
+		// sealed ballots count from the block where the unseal txn is final, so the tally is counted from the
+		// whole chain there and at blocks with sealed ballots after it. Everywhere else a block adds its txns
+		final := bc.keyFinal(&record, header.Hash)
+		if final && (!opened || sealed) {
+			record.Votes, _ = bc.VotingStatusAt(header.Hash, 0)
+			record.Extras = bc.ExtraVotesAt(header.Hash, 0)
+		} else {
+			record.Votes = append([]uint(nil), base.Votes...)
+			record.Extras = copyExtras(base.Extras)
+			bc.CountVotes(record.Votes, body.Txns)
+			for _, txn := range body.Txns {
+				addExtraVote(record.Extras, txn)
+			}
+		}
+		opened = final
+		if old, ok := bc.heightTally(header.BlockNum); ok {
+			corrections = append(corrections, TallyCorrection{
+				Height:  header.BlockNum,
+				OldHash: old.Hash,
+				NewHash: header.Hash,
+				Old:     old.Votes,
+				New:     record.Votes,
+			})
+		}
+		keys = append(keys, dbKeyForHeightTally(header.BlockNum))
+		values = append(values, record.encode())
+		base = &record
+	}
+	if err := bc.DB.PutMulti(keys, values); err != nil {
+		log.Println("[WARN] Unable to save the tally index:", err)
+		return nil
+	}
+	return corrections
+}
+
+// keyFinal checks whether the unseal txn of a record has the confirmations to open sealed ballots on the chain
+// ending at the block with the given hash, see openTxns
+func (bc *BlockChain) keyFinal(record *HeightTally, hash []byte) bool {
+	if record.Unseal == nil || hash == nil {
+		return false
+	}
+	unseal, header := bc.GetHeader(record.Unseal), bc.GetHeader(hash)
+	if unseal == nil || header == nil {
+		return false
+	}
+	return int(header.BlockNum)-int(unseal.BlockNum) >= bc.RequiredConfirmations()
+}
+
+// candidateIndex returns the index in Candidates of the candidate a txn counts for in a plurality tally, -1 if
+// none
+func (bc *BlockChain) candidateIndex(txn *Transaction) int {
+	for idx, cand := range bc.Candidates {
+		if txn.Data.Race == cand.CandidateData.Race && txn.Data.FirstChoice() == cand.CandidateData.CandidateName {
+			return idx
+		}
+	}
+	return -1
+}
+
+// CountVotes adds the votes of txns to votes, the votes of each candidate in the order of Candidates
+func (bc *BlockChain) CountVotes(votes []uint, txns []*Transaction) {
+	for _, txn := range txns {
+		if idx := bc.candidateIndex(txn); idx >= 0 {
+			votes[idx]++
+		}
+	}
+}
+
+// addExtraVote counts txn in extras if it is an abstention or a write-in
+func addExtraVote(extras map[string]*ExtraVotes, txn *Transaction) {
+	if txn.Data.Type != BallotAbstain && txn.Data.Type != BallotWriteIn {
+		return
+	}
+	extra, ok := extras[txn.Data.Race]
+	if !ok {
+		extra = &ExtraVotes{WriteIns: make(map[string]uint)}
+		extras[txn.Data.Race] = extra
+	}
+	if txn.Data.Type == BallotAbstain {
+		extra.Abstain++
+	} else {
+		extra.WriteIns[WriteInKey(txn.Data.VoterCandidate)]++
+	}
+}
+
+func copyExtras(extras map[string]*ExtraVotes) map[string]*ExtraVotes {
+	copied := make(map[string]*ExtraVotes, len(extras))
+	for race, extra := range extras {
+		c := &ExtraVotes{Abstain: extra.Abstain, WriteIns: make(map[string]uint, len(extra.WriteIns))}
+		for name, n := range extra.WriteIns {
+			c.WriteIns[name] = n
+		}
+		copied[race] = c
+	}
+	return copied
+}
+
+// heightTally reads the record of a height. Records counted for other candidates, even if only renamed, do not
+// count
+func (bc *BlockChain) heightTally(height uint32) (HeightTally, bool) {
+	var record HeightTally
+	key := dbKeyForHeightTally(height)
+	if !bc.DB.KeyExist(key) {
+		return record, false
+	}
+	data, err := bc.DB.Get(key)
+	if err != nil {
+		return record, false
+	}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil || len(record.Votes) != len(bc.Candidates) ||
+		!bytes.Equal(record.Candidates, CandidateSetHash(bc.Candidates)) {
+		return record, false
+	}
+	// gob leaves empty maps out
+	if record.Extras == nil {
+		record.Extras = make(map[string]*ExtraVotes)
+	}
+	for _, extra := range record.Extras {
+		if extra.WriteIns == nil {
+			extra.WriteIns = make(map[string]uint)
+		}
+	}
+	return record, true
+}
+
+func (record HeightTally) encode() []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		log.Println("[WARN] height tally encode error")
+	}
+	return buf.Bytes()
+}
+
+func dbKeyForHeightTally(height uint32) []byte {
+	return []byte(TallyIndexKeyPrefix + strconv.Itoa(int(height)))
+}
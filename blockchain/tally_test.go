@@ -0,0 +1,183 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func TestTallyIndex(t *testing.T) {
+	db := &util.Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	candidates := []*Identity.Wallets{
+		Identity.CandidateWithAddress("Alice", "alice"),
+		Identity.CandidateWithAddress("Bob", "bob"),
+	}
+	bc := NewBlockChain(db, candidates)
+	if err := bc.Init(GenesisConfig{ElectionID: "test", CandidateHash: CandidateSetHash(candidates)}); err != nil {
+		t.Fatal(err)
+	}
+	genesis := bc.GenesisHash()
+	vote := func(candidate string) *Transaction {
+		voter := Identity.NewWallet()
+		txn := &Transaction{Data: &Ballot{VoterName: "voter", VoterStudentID: "12345678", VoterCandidate: candidate},
+			PublicKey: voter.PublicKey, Genesis: genesis}
+		txn.Sign(voter.PrivateKey)
+		return txn
+	}
+	at := time.Now().Add(-time.Hour)
+	mine := func(parent *Block, txns ...*Transaction) *Block {
+		at = at.Add(time.Minute)
+		block := &Block{PrevHash: parent.Hash, BlockNum: parent.BlockNum + 1, Timestamp: at.Unix(),
+			Txns: append([]*Transaction{}, txns...), MinerID: "miner"}
+		NewProof(block).Run()
+		return block
+	}
+	put := func(block *Block) PutResult {
+		t.Helper()
+		result := bc.Put(*block, false)
+		if result.Status.Invalid() {
+			t.Fatalf("block #%d: %v", block.BlockNum, result.Status)
+		}
+		return result
+	}
+	// checkIndex checks the tally index against a count of the chain, for every block of the longest chain
+	checkIndex := func() {
+		t.Helper()
+		iter := bc.NewIterator(bc.GetLastHash())
+		for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+			for depth := 0; depth <= int(header.BlockNum)+1; depth++ {
+				indexed, ok := bc.TallyAt(header.Hash, depth)
+				counted, _ := bc.VotingStatusAt(header.Hash, depth)
+				if !ok || !reflect.DeepEqual(indexed.Votes, counted) {
+					t.Fatalf("tally index at #%d, depth %d: %v, %v, want %v", header.BlockNum, depth, indexed.Votes, ok, counted)
+				}
+			}
+			if end {
+				break
+			}
+		}
+	}
+
+	// chain a: Alice, Bob
+	a1 := mine(bc.Get(genesis), vote("Alice"))
+	a2 := mine(a1, vote("Bob"))
+	put(a1)
+	put(a2)
+	checkIndex()
+
+	// chain b replaces both blocks and outgrows it: Bob, Bob, then an empty block
+	b1 := mine(bc.Get(genesis), vote("Bob"))
+	b2 := mine(b1, vote("Bob"))
+	b3 := mine(b2)
+	put(b1)
+	put(b2)
+	result := put(b3)
+	if result.Status != PutSwitchedFork {
+		t.Fatalf("chain b: %v, want %v", result.Status, PutSwitchedFork)
+	}
+	want := []TallyCorrection{
+		{Height: 1, OldHash: a1.Hash, NewHash: b1.Hash, Old: []uint{1, 0}, New: []uint{0, 1}},
+		{Height: 2, OldHash: a2.Hash, NewHash: b2.Hash, Old: []uint{1, 1}, New: []uint{0, 2}},
+	}
+	if !reflect.DeepEqual(result.TallyCorrections, want) {
+		t.Fatalf("corrections %+v, want %+v", result.TallyCorrections, want)
+	}
+	if _, ok := bc.TallyAt(a2.Hash, 0); ok {
+		t.Fatal("tally index has a record of an abandoned block")
+	}
+	checkIndex()
+
+	// renaming a candidate invalidates the records, and the index is counted again
+	bc.Candidates = []*Identity.Wallets{
+		Identity.CandidateWithAddress("Alice", "alice"),
+		Identity.CandidateWithAddress("Robert", "bob"),
+	}
+	if _, ok := bc.TallyAt(b3.Hash, 0); ok {
+		t.Fatal("tally index has a record counted for the old candidates")
+	}
+	bc.IndexTallies()
+	checkIndex()
+	if indexed, _ := bc.TallyAt(b3.Hash, 0); !bytes.Equal(indexed.Hash, b3.Hash) || indexed.Votes[1] != 0 {
+		t.Fatalf("tally of the renamed candidates %v, want no votes for Robert", indexed.Votes)
+	}
+}
+
+func TestSealedTallyIndex(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &util.Database{}
+	if err = db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	candidates := []*Identity.Wallets{
+		Identity.CandidateWithAddress("Alice", "alice"),
+		Identity.CandidateWithAddress("Bob", "bob"),
+	}
+	bc := NewBlockChain(db, candidates)
+	err = bc.Init(GenesisConfig{ElectionID: "test", CandidateHash: CandidateSetHash(candidates), SealingKey: SealingPublicKey(key)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bc.SetSealingKey(SealingPublicKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	genesis := bc.GenesisHash()
+	at := time.Now().Add(-time.Hour)
+	bc.Deadline = at.Add(3 * time.Minute)
+	tip := bc.Get(genesis)
+	mine := func(txns ...*Transaction) {
+		t.Helper()
+		at = at.Add(time.Minute)
+		block := Block{PrevHash: tip.Hash, BlockNum: tip.BlockNum + 1, Timestamp: at.Unix(),
+			Txns: append([]*Transaction{}, txns...), MinerID: "miner"}
+		NewProof(&block).Run()
+		if result := bc.Put(block, false); result.Status.Invalid() {
+			t.Fatalf("block #%d: %v", block.BlockNum, result.Status)
+		}
+		tip = &block
+		indexed, ok := bc.TallyAt(block.Hash, 0)
+		counted, _ := bc.VotingStatusAt(block.Hash, 0)
+		if !ok || !reflect.DeepEqual(indexed.Votes, counted) {
+			t.Fatalf("tally index at #%d: %v, %v, want %v", block.BlockNum, indexed.Votes, ok, counted)
+		}
+	}
+	mine(sealedVote(t, bc.SealingKey, genesis, "Alice"))
+	mine(sealedVote(t, bc.SealingKey, genesis, "Bob"))
+	// until the chain time is past the deadline
+	for i := 0; i < 4; i++ {
+		mine()
+	}
+	unseal := NewUnsealTxn(key, genesis)
+	mine(&unseal)
+	for i := 0; i < bc.RequiredConfirmations(); i++ {
+		mine()
+	}
+	if indexed, _ := bc.TallyAt(tip.Hash, 0); !reflect.DeepEqual(indexed.Votes, []uint{1, 1}) {
+		t.Fatalf("tally once the unseal txn is final %v, want [1 1]", indexed.Votes)
+	}
+}
+
+func TestWriteInCount(t *testing.T) {
+	extras := make(map[string]*ExtraVotes)
+	for _, name := range []string{"Jane Doe", "jane  doe", " JANE DOE", "John Doe"} {
+		addExtraVote(extras, &Transaction{Data: &Ballot{Type: BallotWriteIn, VoterCandidate: name}})
+	}
+	want := map[string]uint{"jane doe": 3, "john doe": 1}
+	if !reflect.DeepEqual(extras[""].WriteIns, want) {
+		t.Fatalf("write-ins %v, want %v", extras[""].WriteIns, want)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"log"
 	"math/big"
 )
@@ -16,84 +15,78 @@ type Transaction struct {
 	ID        []byte
 	Signature []byte
 	PublicKey []byte
+	Genesis   []byte // hash of the genesis block of the election the ballot is cast in. signed with the ballot
+
+	Credential []byte // registrar's signature of PublicKey, see IssueCredential. none without registration
 }
 
 // ----- Transaction APIs -----
 
-func (tx *Transaction) Hash() []byte {
-	var hash [32]byte
-
-	txCopy := *tx
-	txCopy.ID = []byte{}
-
-	hash = sha256.Sum256(txCopy.Serialize())
-
+// ComputeID returns the ID the txn must have: the SHA-256 of its canonical encoding without ID and signature,
+// see CanonicalEncoding. Clients set it before signing, and CheckShape rejects txns whose ID differs from it
+func (tx *Transaction) ComputeID() []byte {
+	hash := sha256.Sum256(tx.appendUnsigned(nil))
 	return hash[:]
 }
 
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
-
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
+	encoded, err := encode(tx)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return encoded.Bytes()
+	return encoded
 }
 
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	if err := decoder.Decode(&transaction); err != nil {
+	transaction, err := DecodeTransaction(data)
+	if err != nil {
 		log.Panic(err)
 	}
 	return transaction
 }
 
-func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
+// DecodeTransaction is DeserializeTransaction returning the error, for txns received from peers
+func DecodeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+	err := decode(data, &transaction)
+	return transaction, err
+}
 
-	if err != nil {
-		log.Panic(err)
-	}
+// Unseals checks whether the txn releases the sealing key instead of casting a ballot
+func (tx *Transaction) Unseals() bool {
+	return tx.Data != nil && tx.Data.Type == BallotUnseal
+}
 
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+// SetID sets the ID of the txn to ComputeID
+func (tx *Transaction) SetID() {
+	tx.ID = tx.ComputeID()
 }
 
-// Sign client
+// Sign sets the ID of the txn and signs it with the voter's private key
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey) {
-	txcopy := Transaction{
-		Data:      tx.Data,
-		ID:        tx.ID,
-		Signature: nil,
-		PublicKey: tx.PublicKey,
-	}
-	//tx.Signature = nil
-
-	txcopy.ID = txcopy.Hash()
-	//tx.PublicKey = nil
-
-	r, s, err := ecdsa.Sign(rand.Reader, &privKey, txcopy.ID)
+	tx.SetID()
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, tx.ID)
 	if err != nil {
 		log.Panic(err)
 	}
-	signature := append(r.Bytes(), s.Bytes()...)
-
-	tx.Signature = signature
-
+	tx.Signature = append(r.Bytes(), s.Bytes()...)
 }
 
-// Verify blockchain
+// Verify blockchain. Valid signatures are remembered, see SigCache
 func (tx *Transaction) Verify() bool {
-	//tx.ID = tx.Hash()
+	if sigCache.Contains(tx) {
+		return true
+	}
+	if !tx.verify() {
+		return false
+	}
+	sigCache.Add(tx)
+	return true
+}
+
+func (tx *Transaction) verify() bool {
+	//tx.ID = tx.ComputeID()
 
 	curve := elliptic.P256()
 
@@ -103,7 +96,7 @@ func (tx *Transaction) Verify() bool {
 	//	Signature: nil,
 	//	PublicKey: tx.PublicKey,
 	//}
-	//txcopy.ID = txcopy.Hash()
+	//txcopy.ID = txcopy.ComputeID()
 
 	r := big.Int{}
 	s := big.Int{}
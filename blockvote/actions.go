@@ -0,0 +1,90 @@
+package blockvote
+
+import "github.com/DistributedClocks/tracing"
+
+// ----- tracing actions -----
+
+// WalletCreated is recorded by a client when a wallet is created for a new voter
+type WalletCreated struct {
+	VoterName string
+	VoterID   string
+	Address   string
+}
+
+// TxnSigned is recorded by a client after signing a ballot transaction
+type TxnSigned struct {
+	TxID      []byte
+	VoterName string
+	Candidate string
+	ClientID  uint
+}
+
+// TxnSubmitted is recorded by a client right before sending a transaction to a miner
+type TxnSubmitted struct {
+	TxID      []byte
+	MinerAddr string
+}
+
+// TxnAcceptedByMiner is recorded by a miner when it accepts a transaction from a client
+type TxnAcceptedByMiner struct {
+	TxID     []byte
+	MinerID  string
+	ClientID uint // as the client told. 0 if it did not
+}
+
+// BlockMined is recorded by a miner when it finds the proof of work for a new block
+type BlockMined struct {
+	Hash     []byte
+	BlockNum uint32
+	MinerID  string
+	NumTxns  int
+}
+
+// TxnConfirmed is recorded by a client when coord reports its transaction on the longest chain
+type TxnConfirmed struct {
+	TxID         []byte
+	NumConfirmed int
+}
+
+// ForkSwitch is recorded by a miner or coord when the longest chain changes to a different fork
+type ForkSwitch struct {
+	Node        string
+	OldLastHash []byte
+	NewLastHash []byte
+	NumNewTxns  int
+	NumOldTxns  int
+}
+
+// RecordAction records an action on the trace if tracing is enabled
+func RecordAction(trace *tracing.Trace, action interface{}) {
+	if trace != nil {
+		trace.RecordAction(action)
+	}
+}
+
+// CreateTrace creates a new trace if tracing is enabled
+func CreateTrace(tracer *tracing.Tracer) *tracing.Trace {
+	if tracer == nil {
+		return nil
+	}
+	return tracer.CreateTrace()
+}
+
+// GenerateToken generates a token to continue the trace on another node if tracing is enabled
+func GenerateToken(trace *tracing.Trace) tracing.TracingToken {
+	if trace == nil {
+		return nil
+	}
+	return trace.GenerateToken()
+}
+
+// ReceiveToken continues a trace from a token if tracing is enabled
+func ReceiveToken(tracer *tracing.Tracer, token tracing.TracingToken) *tracing.Trace {
+	if tracer == nil {
+		return nil
+	}
+	if token == nil {
+		return tracer.CreateTrace()
+	}
+	return tracer.ReceiveToken(token)
+}
@@ -0,0 +1,24 @@
+package blockvote
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// The admin APIs of coord and miners are only served to callers that open their connection with the admin
+// token, see util.ListenRPCWithToken. Coord sends its own token to the admin APIs of the miners it recovers its
+// database from, so the nodes of a deployment share one token
+
+// LoadAdminToken reads the admin token from the file at path. Surrounding whitespace is not part of it
+func LoadAdminToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("no admin token in " + path)
+	}
+	return token, nil
+}
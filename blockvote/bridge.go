@@ -0,0 +1,39 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"net"
+	"net/http"
+)
+
+// serveBridge serves an HTTP CONNECT bridge to the client API of coord and of the registered miners, for clients
+// that cannot reach their ports directly
+func (c *Coord) serveBridge(listenAddr string) error {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	c.listeners = append(c.listeners, listener)
+	go http.Serve(listener, util.BridgeHandler(c.bridgeRoute, &c.bridgeSources))
+	return nil
+}
+
+// bridgeRoute returns where the bridge connects for target: coord's own client API when target has its port, the
+// client API of a registered miner, and nothing else, so the bridge is not an open proxy
+func (c *Coord) bridgeRoute(target string) (string, bool) {
+	_, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", false
+	}
+	if _, clientPort, err := net.SplitHostPort(c.ClientAPIAddr); err == nil && port == clientPort {
+		return c.ClientAPIAddr, true
+	}
+	c.nlMu.Lock()
+	defer c.nlMu.Unlock()
+	for _, info := range c.NodeList {
+		if info.Property.ClientListenAddr == target {
+			return target, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,58 @@
+package blockvote
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SyncRetryAfter = 5 * time.Second // suggested to clients while a miner is starting or catching up
+	PoolRetryAfter = 2 * time.Second // suggested to clients while a miner's pool is full
+)
+
+const busyPrefix = "miner is busy: "
+
+// BusyError is returned by SubmitTxn when the miner cannot take txns for now. The txn is fine: clients should
+// try another miner or come back after RetryAfter. RPC errors only keep their message, see ParseBusyError
+type BusyError struct {
+	Reason     string
+	RetryAfter time.Duration
+	Syncing    bool // the miner is starting or catching up, see CodeChainSyncing
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s%s, retry after %v", busyPrefix, e.Reason, e.RetryAfter)
+}
+
+// ParseBusyError recovers a BusyError from the error of an RPC call
+func ParseBusyError(err error) (*BusyError, bool) {
+	code := CodeOf(err)
+	if code != CodeBusy && code != CodeChainSyncing || !strings.HasPrefix(err.Error(), busyPrefix) {
+		return nil, false
+	}
+	msg := strings.TrimPrefix(err.Error(), busyPrefix)
+	idx := strings.LastIndex(msg, ", retry after ")
+	if idx < 0 {
+		return nil, false
+	}
+	retryAfter, perr := time.ParseDuration(msg[idx+len(", retry after "):])
+	if perr != nil {
+		return nil, false
+	}
+	return &BusyError{Reason: msg[:idx], RetryAfter: retryAfter, Syncing: code == CodeChainSyncing}, true
+}
+
+// syncing returns a BusyError while the miner is starting or catching up with coord, nil otherwise
+func (m *Miner) syncing() *BusyError {
+	select {
+	case <-m.ready:
+	default:
+		return &BusyError{Reason: "starting", RetryAfter: SyncRetryAfter, Syncing: true}
+	}
+	if atomic.LoadInt32(&m.catchingUp) == 1 {
+		return &BusyError{Reason: "catching up with coord", RetryAfter: SyncRetryAfter, Syncing: true}
+	}
+	return nil
+}
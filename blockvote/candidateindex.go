@@ -0,0 +1,179 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+var ErrUnknownCandidate = errors.New("no such candidate in the race")
+
+// candidateIndex maps each listed candidate to the txns of the ballots counted toward it on the longest
+// chain: regular ballots for it and ranked ballots with it as first choice, in the order they were mined.
+// Sealed ballots are indexed by their opened choice once the sealing key is on the chain. Like the voter
+// index it is stored in coord's database and kept up to date with every block and fork switch.
+type candidateIndex struct {
+	mu    sync.Mutex
+	db    *util.Database
+	chain *blockchain.BlockChain
+}
+
+// openCandidateIndex loads the candidate index of db, rebuilding it from chain if it does not match the chain's tip
+func openCandidateIndex(db *util.Database, chain *blockchain.BlockChain) (*candidateIndex, error) {
+	idx := &candidateIndex{db: db, chain: chain}
+	var tip []byte
+	if db.KeyExist([]byte(CandidateIndexTipKey)) {
+		var err error
+		if tip, err = db.Get([]byte(CandidateIndexTipKey)); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(tip, chain.GetLastHash()) {
+		return idx, idx.rebuild(chain.GetLastHash())
+	}
+	return idx, nil
+}
+
+// key returns the database key of a candidate of a race
+func (idx *candidateIndex) key(race string, candidate string) []byte {
+	return []byte(CandidateTxnsKeyPrefix + hex.EncodeToString([]byte(race)) + "-" + hex.EncodeToString([]byte(candidate)))
+}
+
+// Lookup returns the IDs of the txns counted toward a candidate, oldest first
+func (idx *candidateIndex) Lookup(race string, candidate string) ([][]byte, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.get(idx.key(race, candidate))
+}
+
+func (idx *candidateIndex) get(key []byte) ([][]byte, error) {
+	if !idx.db.KeyExist(key) {
+		return nil, nil
+	}
+	data, err := idx.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var txids [][]byte
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&txids)
+	return txids, err
+}
+
+// apply removes the txns of an abandoned fork and adds the txns new on the longest chain, whose tip is now tip.
+// Sealed ballots only count once the key is released, so the index is rebuilt when the key comes or goes.
+func (idx *candidateIndex) apply(added []*blockchain.Transaction, removed []*blockchain.Transaction, tip []byte) error {
+	for _, txns := range [][]*blockchain.Transaction{added, removed} {
+		for _, txn := range txns {
+			if txn.Unseals() {
+				return idx.rebuild(tip)
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	changed := make(map[string][][]byte)
+	load := func(txn *blockchain.Transaction) (string, error) {
+		key := string(idx.key(txn.Data.Race, txn.Data.FirstChoice()))
+		if _, ok := changed[key]; !ok {
+			txids, err := idx.get([]byte(key))
+			if err != nil {
+				return "", err
+			}
+			changed[key] = txids
+		}
+		return key, nil
+	}
+	for _, txn := range removed {
+		if txn.Data.FirstChoice() == "" {
+			continue
+		}
+		key, err := load(txn)
+		if err != nil {
+			return err
+		}
+		txids := changed[key][:0]
+		for _, txid := range changed[key] {
+			if !bytes.Equal(txid, txn.ID) {
+				txids = append(txids, txid)
+			}
+		}
+		changed[key] = txids
+	}
+	// added is newest first
+	for i := len(added) - 1; i >= 0; i-- {
+		txn := added[i]
+		if txn.Data.FirstChoice() == "" {
+			continue // abstention or write-in
+		}
+		key, err := load(txn)
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, txid := range changed[key] {
+			known = known || bytes.Equal(txid, txn.ID)
+		}
+		if !known {
+			changed[key] = append(changed[key], txn.ID)
+		}
+	}
+	return idx.write(changed, tip)
+}
+
+// rebuild drops the index and builds it again from the txns counted on the chain ending at tip
+func (idx *candidateIndex) rebuild(tip []byte) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	var stale [][]byte
+	iter := idx.db.NewIterator(CandidateTxnsKeyPrefix)
+	for iter.Next() {
+		stale = append(stale, append([]byte{}, iter.Key()...))
+	}
+	iter.Close()
+	if len(stale) > 0 {
+		if err := idx.db.RemoveMulti(stale); err != nil {
+			return err
+		}
+	}
+
+	changed := make(map[string][][]byte)
+	txns := idx.chain.CountedTxnsAt(tip, 0)
+	for i := len(txns) - 1; i >= 0; i-- {
+		if choice := txns[i].Data.FirstChoice(); choice != "" {
+			key := string(idx.key(txns[i].Data.Race, choice))
+			changed[key] = append(changed[key], txns[i].ID)
+		}
+	}
+	return idx.write(changed, tip)
+}
+
+// NOTE: mu should be held by the caller
+func (idx *candidateIndex) write(changed map[string][][]byte, tip []byte) error {
+	var keys, values, emptied [][]byte
+	for key, txids := range changed {
+		if len(txids) == 0 {
+			emptied = append(emptied, []byte(key))
+			continue
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(txids); err != nil {
+			return err
+		}
+		keys = append(keys, []byte(key))
+		values = append(values, buf.Bytes())
+	}
+	if len(emptied) > 0 {
+		if err := idx.db.RemoveMulti(emptied); err != nil {
+			return err
+		}
+	}
+	// the tip is written last. an index interrupted before it is rebuilt on restart
+	keys = append(keys, []byte(CandidateIndexTipKey))
+	values = append(values, tip)
+	return idx.db.PutMulti(keys, values)
+}
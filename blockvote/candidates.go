@@ -0,0 +1,231 @@
+package blockvote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// candidateWalletsFile is where the wallets generated for a candidates file are saved, so that a
+// coord starting over with a new database gives the candidates the same keys
+func candidateWalletsFile(candidatesFile string) string {
+	return candidatesFile + ".wallets"
+}
+
+// loadCandidateWallets reads the wallets saved for a candidates file by candidate ID
+func loadCandidateWallets(path string) (map[string]*Identity.Wallets, error) {
+	wallets := make(map[string]*Identity.Wallets)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return wallets, nil
+	} else if err != nil {
+		return nil, err
+	}
+	encoded := make(map[string][]byte)
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&encoded); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for id, data := range encoded {
+		wallets[id] = Identity.DecodeToWallets(data)
+	}
+	return wallets, nil
+}
+
+// saveCandidateWallets writes the wallets of candidates by candidate ID. The file holds private keys.
+func saveCandidateWallets(path string, wallets map[string]*Identity.Wallets) error {
+	encoded := make(map[string][]byte)
+	for id, ws := range wallets {
+		encoded[id] = ws.Encode()
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(encoded); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// fileCandidate creates the wallets of a candidate from the candidates file: a known address, the wallet
+// saved on an earlier run, or a new wallet which is added to saved
+func fileCandidate(election string, entry CandidateEntry, saved map[string]*Identity.Wallets) (*Identity.Wallets, error) {
+	if entry.Address != "" {
+		return Identity.CandidateWithAddress(entry.Name, entry.Address), nil
+	}
+	if ws, ok := saved[entry.ID]; ok {
+		ws.CandidateData.CandidateName = entry.Name
+		return ws, nil
+	}
+	ws, err := Identity.CreateCandidateIn(election, entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	ws.AddWallet()
+	saved[entry.ID] = ws
+	return ws, nil
+}
+
+// raceConfig returns the configured race named name, or the single race of the election
+func (c *Coord) raceConfig(name string) RaceConfig {
+	for _, race := range c.Races {
+		if race.Name == name {
+			return race
+		}
+	}
+	return RaceConfig{AllowWriteIns: c.AllowWriteIns, Method: c.Method}
+}
+
+// checkStoredCandidates checks that the candidates stored by an earlier run are the ones of the candidates file
+func (c *Coord) checkStoredCandidates() error {
+	if len(c.CandidateEntries) == 0 {
+		return nil
+	}
+	stored := make(map[string]string)
+	for _, cand := range c.Candidates {
+		stored[cand.CandidateData.ID] = cand.GetAddress()
+	}
+	for _, entry := range c.CandidateEntries {
+		address, ok := stored[entry.ID]
+		if !ok {
+			return fmt.Errorf("candidate %s is not among the stored candidates", entry.ID)
+		}
+		if entry.Address != "" && entry.Address != address {
+			return fmt.Errorf("candidate %s is stored with wallet address %s", entry.ID, address)
+		}
+	}
+	return nil
+}
+
+// CandidateInfo describes a candidate to voters
+type CandidateInfo struct {
+	Name      string
+	ID        string // unique ID from the candidates file. empty for generated candidates
+	Race      string // empty in an election with a single race
+	Address   string // wallet address of the candidate
+	Statement string // URL of the candidate's statement. empty if there is none
+	Position  int    // place of the candidate on the ballot of its race, from 1
+}
+
+// candidateInfo describes the candidates in order. Statements are taken from the candidates file, so they can
+// change between runs
+func (c *Coord) candidateInfo() []CandidateInfo {
+	statements := make(map[string]string)
+	c.candMu.RLock()
+	for _, entry := range c.CandidateEntries {
+		statements[entryID(entry.ID, Identity.Candidate{CandidateName: entry.Name, Race: entry.Race})] = entry.Statement
+	}
+	c.candMu.RUnlock()
+	positions := make(map[string]int)
+	var infos []CandidateInfo
+	for _, cand := range c.Candidates {
+		data := cand.CandidateData
+		positions[data.Race]++
+		infos = append(infos, CandidateInfo{
+			Name:      data.CandidateName,
+			ID:        data.ID,
+			Race:      data.Race,
+			Address:   cand.GetAddress(),
+			Statement: statements[entryID(data.ID, data)],
+			Position:  positions[data.Race],
+		})
+	}
+	return infos
+}
+
+// entryID is the ID of cand in the candidates file, which is Race/Name when the file gives none. Candidates
+// generated or stored before they had IDs have none either
+func entryID(id string, cand Identity.Candidate) string {
+	if id == "" {
+		return cand.FullName()
+	}
+	return id
+}
+
+// candidateListHash digests what GetCandidates tells clients about the candidates
+func (c *Coord) candidateListHash() []byte {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(struct {
+		Info        []CandidateInfo
+		ElectionEnd time.Time
+	}{c.candidateInfo(), c.ElectionEnd.UTC()})
+	util.CheckErr(err, "[ERROR] error when hashing the candidate list")
+	hash := sha256.Sum256(buf.Bytes())
+	return hash[:]
+}
+
+// updateCandidateListVersion moves the version of the candidate list on if it changed since the version was
+// last saved, so that clients refreshing it see the change. Versions only grow over runs with the same database.
+func (c *Coord) updateCandidateListVersion() error {
+	hash := c.candidateListHash()
+	c.candMu.Lock()
+	defer c.candMu.Unlock()
+	var version uint64
+	if c.Storage.KeyExist([]byte(CandidateListVersionKey)) {
+		data, err := c.Storage.Get([]byte(CandidateListVersionKey))
+		if err != nil {
+			return err
+		}
+		if len(data) != 8+sha256.Size {
+			return errors.New("malformed candidate list version")
+		}
+		version = binary.BigEndian.Uint64(data)
+		if bytes.Equal(data[8:], hash) {
+			c.candVersion = version
+			return nil
+		}
+	}
+	data := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(data, version+1)
+	if err := c.Storage.Put([]byte(CandidateListVersionKey), append(data, hash...)); err != nil {
+		return err
+	}
+	c.candVersion = version + 1
+	log.Println("[INFO] Candidate list is at version", c.candVersion)
+	return nil
+}
+
+// candidateListVersion is the version of what GetCandidates returns
+func (c *Coord) candidateListVersion() uint64 {
+	c.candMu.RLock()
+	defer c.candMu.RUnlock()
+	return c.candVersion
+}
+
+// ReloadCandidates reads the candidates file again and hands the new statements to clients. The candidates
+// themselves are part of the genesis block, so the file must list the same candidates in the same order.
+func (c *Coord) ReloadCandidates() (uint64, error) {
+	if c.CandidatesFile == "" {
+		return 0, errors.New("coord has no candidates file")
+	}
+	cfg := CoordConfig{CandidatesFile: c.CandidatesFile, Races: c.Races}
+	list, err := cfg.LoadCandidates()
+	if err != nil {
+		return 0, err
+	}
+	c.candMu.RLock()
+	current := c.CandidateEntries
+	c.candMu.RUnlock()
+	if len(list.Candidates) != len(current) {
+		return 0, fmt.Errorf("%s lists %d candidates, the election has %d", c.CandidatesFile, len(list.Candidates), len(current))
+	}
+	for i, entry := range list.Candidates {
+		if entry.ID != current[i].ID || entry.Name != current[i].Name || entry.Race != current[i].Race ||
+			entry.Address != current[i].Address {
+			return 0, fmt.Errorf("%s: candidate %s does not match candidate %s of the election", c.CandidatesFile, entry.ID, current[i].ID)
+		}
+	}
+	c.candMu.Lock()
+	c.CandidateEntries = list.Candidates
+	c.candMu.Unlock()
+	if err = c.updateCandidateListVersion(); err != nil {
+		return 0, err
+	}
+	return c.candidateListVersion(), nil
+}
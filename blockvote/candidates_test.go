@@ -0,0 +1,26 @@
+package blockvote
+
+import (
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+)
+
+func TestCandidateInfoStatements(t *testing.T) {
+	c := NewCoord()
+	alice := Identity.CandidateWithAddress("Alice", "alice")
+	alice.CandidateData.ID = "alice"
+	// candidates without IDs are matched by Race/Name, not by their empty ID
+	c.Candidates = []*Identity.Wallets{alice, Identity.CandidateWithAddress("Bob", "bob"), Identity.CandidateWithAddress("Carol", "carol")}
+	c.CandidateEntries = []CandidateEntry{
+		{Name: "Alice", ID: "alice", Statement: "https://example.com/alice"},
+		{Name: "Bob", Statement: "https://example.com/bob"},
+		{Name: "Carol"},
+	}
+	want := []string{"https://example.com/alice", "https://example.com/bob", ""}
+	for i, info := range c.candidateInfo() {
+		if info.Statement != want[i] {
+			t.Errorf("statement of %s %q, want %q", info.Name, info.Statement, want[i])
+		}
+	}
+}
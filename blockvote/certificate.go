@@ -0,0 +1,243 @@
+package blockvote
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// ResultCertificate is the final tally of an election signed by coord's authority key.
+// Votes[i] is the number of confirmed votes of Candidates[i] on the chain ending at TipHash.
+type ResultCertificate struct {
+	Candidates   []string // qualified by race, see Identity.Candidate.FullName
+	Votes        []uint
+	Abstain      map[string]uint            `json:",omitempty"` // abstentions by race
+	WriteIns     map[string]map[string]uint `json:",omitempty"` // write-in votes by race and name
+	TipHash      string                     // hex
+	Height       uint32
+	IssuedAt     time.Time
+	AuthorityKey string // hex encoded public key (X || Y on P-256)
+	Signature    string // hex encoded (r || s) over the SHA-256 of the certificate without its signature
+}
+
+// LoadAuthorityKey reads a PEM encoded EC private key from path, generating and saving a new key
+// if the file does not exist. An unsaved key is generated if path is empty.
+func LoadAuthorityKey(path string) (*ecdsa.PrivateKey, error) {
+	if path == "" {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM data in " + path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// AuthorityPublicKey returns the hex encoded public key of an authority key, as found in certificates
+func AuthorityPublicKey(key *ecdsa.PrivateKey) string {
+	pubKey := make([]byte, 64)
+	key.X.FillBytes(pubKey[:32])
+	key.Y.FillBytes(pubKey[32:])
+	return hex.EncodeToString(pubKey)
+}
+
+// ResultCertificate issues a certificate of the current tally on the longest chain
+func (c *Coord) ResultCertificate() (*ResultCertificate, error) {
+	return c.ResultCertificateAt(nil)
+}
+
+// ResultCertificateAt issues a certificate of the tally at the block tip of the longest chain, so that it
+// references the same chain point observers queried with QueryResults. The tip of the longest chain if nil
+func (c *Coord) ResultCertificateAt(tip []byte) (*ResultCertificate, error) {
+	if c.authorityKey == nil {
+		return nil, errors.New("coord has not started")
+	}
+	if tip == nil {
+		tip = c.Blockchain.GetLastHash()
+	} else if !c.Blockchain.Exist(tip) {
+		return nil, ErrUnknownBlock
+	} else if !onLongestChain(c.Blockchain, tip) {
+		return nil, ErrNotOnLongestChain
+	}
+	depth := c.Blockchain.RequiredConfirmations()
+	votes, _ := c.Blockchain.VotingStatusAt(tip, depth)
+	abstain, writeIns := extraVoteMaps(c.Blockchain.ExtraVotesAt(tip, depth))
+	cert := &ResultCertificate{
+		Votes:        votes,
+		Abstain:      abstain,
+		WriteIns:     writeIns,
+		TipHash:      hex.EncodeToString(tip),
+		Height:       c.Blockchain.GetHeader(tip).BlockNum,
+		IssuedAt:     time.Now().UTC(),
+		AuthorityKey: AuthorityPublicKey(c.authorityKey),
+	}
+	for _, cand := range c.Candidates {
+		cert.Candidates = append(cert.Candidates, cand.CandidateData.FullName())
+	}
+	return cert, cert.sign(c.authorityKey)
+}
+
+// ReadResultCertificate reads a certificate written by WriteFile
+func ReadResultCertificate(path string) (*ResultCertificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cert ResultCertificate
+	if err = json.Unmarshal(data, &cert); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// WriteFile writes the certificate as JSON to path
+func (cert *ResultCertificate) WriteFile(path string) error {
+	data, err := json.MarshalIndent(cert, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// VerifySignature checks that the certificate is signed by its authority key, and that the key is
+// authorityKey (hex, as returned by AuthorityPublicKey). A certificate signed by any key proves nothing, so
+// authorityKey is required
+func (cert *ResultCertificate) VerifySignature(authorityKey string) error {
+	if authorityKey == "" {
+		return errors.New("no authority key to verify the certificate against")
+	}
+	if authorityKey != cert.AuthorityKey {
+		return errors.New("certificate is not signed by the expected authority")
+	}
+	pubKey, err := hex.DecodeString(cert.AuthorityKey)
+	if err != nil || len(pubKey) == 0 {
+		return errors.New("malformed authority key")
+	}
+	sig, err := hex.DecodeString(cert.Signature)
+	if err != nil || len(sig) == 0 {
+		return errors.New("malformed signature")
+	}
+	x, y := new(big.Int), new(big.Int)
+	x.SetBytes(pubKey[:len(pubKey)/2])
+	y.SetBytes(pubKey[len(pubKey)/2:])
+	r, s := new(big.Int), new(big.Int)
+	r.SetBytes(sig[:len(sig)/2])
+	s.SetBytes(sig[len(sig)/2:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, cert.digest(), r, s) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// Verify checks the signature of the certificate (see VerifySignature), and that its tip is on the
+// longest chain of chain (e.g. opened from an exported snapshot) with the same candidates and tally
+func (cert *ResultCertificate) Verify(chain *blockchain.BlockChain, authorityKey string) error {
+	if err := cert.VerifySignature(authorityKey); err != nil {
+		return err
+	}
+	tip, err := hex.DecodeString(cert.TipHash)
+	if err != nil {
+		return errors.New("malformed tip hash")
+	}
+	block := chain.GetByHeight(cert.Height)
+	if block == nil || !bytes.Equal(block.Hash, tip) {
+		return fmt.Errorf("block #%d (%s) is not on the longest chain", cert.Height, cert.TipHash)
+	}
+	if len(chain.Candidates) != len(cert.Candidates) {
+		return fmt.Errorf("expect %d candidates, chain has %d", len(cert.Candidates), len(chain.Candidates))
+	}
+	for idx, cand := range chain.Candidates {
+		if cand.CandidateData.FullName() != cert.Candidates[idx] {
+			return fmt.Errorf("candidate #%d is %s on the chain, not %s", idx, cand.CandidateData.FullName(), cert.Candidates[idx])
+		}
+	}
+	votes, _ := chain.VotingStatusAt(tip, chain.RequiredConfirmations())
+	if len(votes) != len(cert.Votes) {
+		return fmt.Errorf("expect %d vote counts, got %d", len(votes), len(cert.Votes))
+	}
+	for idx := range votes {
+		if votes[idx] != cert.Votes[idx] {
+			return fmt.Errorf("tally of %s does not match the chain", cert.Candidates[idx])
+		}
+	}
+	abstain, writeIns := extraVoteMaps(chain.ExtraVotesAt(tip, chain.RequiredConfirmations()))
+	if !reflect.DeepEqual(abstain, cert.Abstain) || !reflect.DeepEqual(writeIns, cert.WriteIns) {
+		return errors.New("abstentions or write-ins do not match the chain")
+	}
+	return nil
+}
+
+// extraVoteMaps flattens extra votes for certificates. Maps are nil when there are no such votes
+func extraVoteMaps(extras map[string]*blockchain.ExtraVotes) (abstain map[string]uint, writeIns map[string]map[string]uint) {
+	for race, extra := range extras {
+		if extra.Abstain > 0 {
+			if abstain == nil {
+				abstain = make(map[string]uint)
+			}
+			abstain[race] = extra.Abstain
+		}
+		if len(extra.WriteIns) > 0 {
+			if writeIns == nil {
+				writeIns = make(map[string]map[string]uint)
+			}
+			writeIns[race] = extra.WriteIns
+		}
+	}
+	return
+}
+
+func (cert *ResultCertificate) sign(key *ecdsa.PrivateKey) error {
+	r, s, err := ecdsa.Sign(rand.Reader, key, cert.digest())
+	if err != nil {
+		return err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	cert.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+func (cert *ResultCertificate) digest() []byte {
+	unsigned := *cert
+	unsigned.Signature = ""
+	data, _ := json.Marshal(unsigned)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
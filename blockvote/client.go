@@ -1,10 +1,5 @@
 package blockvote
 
-type ClientConfig struct {
-	ClientID          uint
-	CoordIPPort       string
-	TracingServerAddr string
-	N_Receives        int
-	Secret            []byte
-	TracingIdentity   string
-}
+import "cs.ubc.ca/cpsc416/BlockVote/config"
+
+type ClientConfig = config.Client
@@ -0,0 +1,83 @@
+package blockvote
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Block timestamps are checked against the local clock (see blockchain.MaxClockDrift) and ballots against
+// ElectionEnd, so a miner whose clock is far off coord's mines blocks others reject and takes ballots coord
+// refuses. Coord measures the offset of a miner's clock when it registers, from the time the miner sent the
+// request, and afterwards from the time in every heartbeat ack. Miners off by more than MaxClockSkew cannot
+// register; registered miners drifting beyond it are logged
+
+// ClockSkewCheckInterval is the time between two checks of the clock offsets measured by heartbeats
+const ClockSkewCheckInterval = 30 * time.Second
+
+// ErrClockSkew is returned by Register when the clock of the miner is too far off coord's
+var ErrClockSkew = errors.New("miner clock is too far off coord's")
+
+// checkClockSkew rejects a registration sent at args.SentAt by the miner's clock and received at now. Miners that
+// do not send their time are let through
+func (c *Coord) checkClockSkew(args RegisterArgs, now time.Time) error {
+	if c.MaxClockSkew <= 0 || args.SentAt.IsZero() {
+		return nil
+	}
+	// the request took some time to arrive, so a miner in sync looks slightly behind
+	offset := args.SentAt.Sub(now)
+	if abs(offset) > c.MaxClockSkew {
+		return fmt.Errorf("%w: %v off, at most %v allowed", ErrClockSkew, offset.Round(time.Millisecond), c.MaxClockSkew)
+	}
+	if abs(offset) > c.MaxClockSkew/2 {
+		log.Printf("[WARN] Clock of miner %s is %v off coord's\n", args.Info.MinerId, offset.Round(time.Millisecond))
+	}
+	return nil
+}
+
+// watchClockSkew logs the registered miners whose clock drifted beyond MaxClockSkew, once until they are back
+func (c *Coord) watchClockSkew() {
+	ticker := time.NewTicker(ClockSkewCheckInterval)
+	defer ticker.Stop()
+	skewed := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return
+		}
+		c.nlMu.Lock()
+		for _, info := range c.NodeList {
+			offset := c.fcheck.ClockOffset(info.Property.AckAddr)
+			id := info.Property.MinerId
+			if abs(offset) > c.MaxClockSkew && !skewed[id] {
+				log.Printf("[WARN] Clock of miner %s drifted %v off coord's, its blocks may be rejected\n", id, offset.Round(time.Millisecond))
+				skewed[id] = true
+			} else if abs(offset) <= c.MaxClockSkew && skewed[id] {
+				log.Printf("[INFO] Clock of miner %s is back within %v of coord's\n", id, c.MaxClockSkew)
+				delete(skewed, id)
+			}
+		}
+		c.nlMu.Unlock()
+	}
+}
+
+// logClockSkew logs how far the miner's clock is off coord's, from the round trip of its registration sent at
+// sent and answered at received
+func logClockSkew(reply RegisterReply, sent, received time.Time) {
+	if reply.CoordTime.IsZero() {
+		return
+	}
+	offset := sent.Add(received.Sub(sent) / 2).Sub(reply.CoordTime)
+	if abs(offset) > time.Second {
+		log.Printf("[WARN] Clock is %v off coord's, blocks and ballots may be rejected\n", offset.Round(time.Millisecond))
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
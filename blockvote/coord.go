@@ -2,10 +2,14 @@ package blockvote
 
 import (
 	"bytes"
+	"crypto/ecdsa"
 	"cs.ubc.ca/cpsc416/BlockVote/Identity"
 	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
+	"cs.ubc.ca/cpsc416/BlockVote/events"
 	fchecker "cs.ubc.ca/cpsc416/BlockVote/fcheck"
 	"cs.ubc.ca/cpsc416/BlockVote/gossip"
+	"cs.ubc.ca/cpsc416/BlockVote/metrics"
 	"cs.ubc.ca/cpsc416/BlockVote/util"
 	"encoding/gob"
 	"errors"
@@ -13,8 +17,10 @@ import (
 	"github.com/DistributedClocks/tracing"
 	"log"
 	"math/rand"
+	"net"
 	"net/rpc"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,21 +28,51 @@ import (
 )
 
 const (
-	NCandidatesKey      = "NCandidates"
-	CandidateKeyPrefix  = "cand-"
-	NodeKeyPrefix       = "node-"
-	BlockIDPrefix       = "block-"
-	TransactionIDPrefix = "txn-"
+	NCandidatesKey       = "NCandidates"
+	CandidateKeyPrefix   = "cand-"
+	NodeKeyPrefix        = "node-"
+	MinerKeyPrefix       = "minerkey-" // public key each miner ID registered with. kept after the miner fails
+	MinerPinPrefix       = "minerpin-" // miner IDs bound to their key for good, see RegisterArgs.Persistent
+	BlockIDPrefix        = "block-"
+	TransactionIDPrefix  = "txn-"
+	VoterKeyPrefix       = "voter-"            // salted hash of a student ID -> its ballots on the longest chain
+	VoterSaltKey         = "VoterSalt"         // salt of the voter index
+	VoterIndexTipKey     = "VoterIndexTip"     // tip of the longest chain the voter index is up to date with
+	VoterIndexVersionKey = "VoterIndexVersion" // format of the voter index entries, see voterIndexVersion
+	TallyKeyPrefix       = "tally-"            // block hash -> votes of each candidate on the chain ending at the block
+	RegisteredKeyPrefix  = "registered-"       // salted hash of a student ID -> the public key it registered, see RegisterVoter
+
+	CandidateTxnsKeyPrefix = "candtxns-"         // race and candidate -> txns counted toward it on the longest chain
+	CandidateIndexTipKey   = "CandidateIndexTip" // tip of the longest chain the candidate index is up to date with
+
+	CandidateListVersionKey = "CandidateListVersion" // version of the candidate list and the hash it was last saved with
 )
 
-type CoordConfig struct {
-	ClientAPIListenAddr string
-	MinerAPIListenAddr  string
-	TracingServerAddr   string
-	NCandidates         uint8
-	Secret              []byte
-	TracingIdentity     string
-}
+const StorageMaintenanceInterval = 10 * time.Minute
+
+const (
+	AssignRoundRobin   = "round-robin"   // GetMinerList assigns miners to clients in turn
+	RecentBlocksWindow = 20              // longest chain blocks counted in MinerLoad.RecentBlocks
+	LoadQueryTimeout   = 1 * time.Second // how long GetMinerList waits for a miner's load
+	MaxQueryTxns       = 1000            // TxIDs a QueryTxns call may ask about
+)
+
+// ErrTooManyTxns is returned by QueryTxns when asked about more than MaxQueryTxns TxIDs
+var ErrTooManyTxns = fmt.Errorf("at most %d TxIDs can be queried at once", MaxQueryTxns)
+
+// ErrFinalityDepthChanged is why coord refuses to resume a chain with another FinalityDepth than its config
+var ErrFinalityDepthChanged = errors.New("finality depth cannot change once the chain started")
+
+// ErrDifficultyChanged is why coord refuses to resume a chain with another Difficulty than its config
+var ErrDifficultyChanged = errors.New("difficulty cannot change once the chain started")
+
+// ErrDeadlineChanged is why coord refuses to resume a chain with another ElectionEnd than its config
+var ErrDeadlineChanged = errors.New("election end cannot change once the chain started")
+
+type CoordConfig = config.Coord
+
+type RaceConfig = config.Race
+type CandidateEntry = config.CandidateEntry
 
 type NodeInfo struct {
 	Property MinerInfo
@@ -45,100 +81,700 @@ type NodeInfo struct {
 // messages
 
 type (
-	DownloadArgs struct {
-	}
+	DownloadArgs  struct{}
 	DownloadReply struct {
-		BlockChain   [][]byte
-		LastHash     []byte
-		Candidates   [][]byte
-		PeerAddrList []string // not including the miner itself
+		RPCStatus
+		LastHash      []byte
+		Height        uint32 // block number of LastHash. blocks are fetched with GetBlocks up to this height
+		Candidates    [][]byte
+		PeerAddrList  []string                // not including the miner itself
+		ElectionEnd   time.Time               // zero if the election never closes
+		Genesis       []byte                  // hash of the genesis block
+		GenesisHeader *blockchain.BlockHeader // header of the genesis block, checked against the genesis config of miners
+		FinalityDepth int                     // confirmations a ballot needs to be final and counted
+		Difficulty    uint8                   // leading zero bits of a block hash. blockchain.NumZeros if 0
+		MinerKeys     map[string][]byte       // key of every miner that ever registered. blocks of other miners are rejected
+		SealingKey    []byte                  // public key ballots are sealed to. not sealed if empty
+		Registration  blockchain.Registration // who may vote. anyone if empty
+	}
+
+	GetBlocksArgs struct {
+		FromHeight  uint32
+		ToHeight    uint32
+		KnownHashes [][]byte // blocks the caller already has and does not need to download
+	}
+	GetBlocksReply struct {
+		RPCStatus
+		Blocks   [][]byte
+		Checksum []byte // ChunkChecksum of Blocks
+		ToHeight uint32 // last height covered, may be lower than requested
 	}
 
 	RegisterArgs struct {
-		Info MinerInfo
+		Info       MinerInfo
+		PubKey     []byte    // see MinerIdentity. a miner registering without a key cannot reclaim its ID
+		Signature  []byte    // of Info by PubKey
+		Persistent bool      // whether the miner saved PubKey. only a saved key binds the miner ID for good
+		SentAt     time.Time // miner's clock when it sent the request, to measure its offset. not checked if zero
 	}
 
 	RegisterReply struct {
-		PeerAddrList       []string // will include the miner itself!
-		PeerGossipAddrList []string // the first address is coord!
+		RPCStatus
+		PeerAddrList       []string          // will include the miner itself!
+		PeerGossipAddrList []string          // the first address is coord!
+		Returning          bool              // whether coord recognized the miner from a previous run
+		LastHash           []byte            // current tip, for the miner to fetch blocks it missed since Download
+		Height             uint32            // block number of LastHash
+		MinerKeys          map[string][]byte // key of every miner that ever registered, including the miner itself
+		CoordTime          time.Time         // coord's clock when it answered, for the miner to measure its offset
 	}
 
 	GetCandidatesArgs struct {
+		KnownVersion uint64 // only Version is returned if the list is still at this version. the full list if 0
 	}
 
 	GetCandidatesReply struct {
-		Candidates [][]byte
+		RPCStatus
+		Candidates    [][]byte
+		ElectionEnd   time.Time               // zero if the election never closes
+		Genesis       []byte                  // hash of the genesis block, signed into every txn of the election
+		FinalityDepth int                     // confirmations a ballot needs to be final and counted
+		Difficulty    uint8                   // leading zero bits of a block hash, for light clients. blockchain.NumZeros if 0
+		SealingKey    []byte                  // public key ballots are sealed to, see blockchain.SealBallot. not sealed if empty
+		Registration  blockchain.Registration // who may vote, see RegisterVoter. anyone if empty
+		Info          []CandidateInfo         // each candidate of Candidates, as shown to voters
+		Version       uint64                  // grows whenever any of the above changes
+		Unchanged     bool                    // the list is still at KnownVersion. only Version is set
 	}
 
 	GetMinerListArgs struct {
+		rpcCaller
+		ClientID  uint   // see ClientID in evlib. 0 if the client did not tell
+		WithLoad  bool   // also report the load of each miner
+		Detailed  bool   // also report each miner in Miners
+		MinHeight uint32 // only miners whose longest chain reaches this height. none are left out if 0
+		Label     string // only miners with this label. any miner if empty
+		Offset    int    // skip this many of the matching miners
+		Limit     int    // at most this many miners. no limit if 0
 	}
 
 	GetMinerListReply struct {
+		RPCStatus
 		MinerAddrList []string
+		Loads         []MinerLoad   // load of each miner in MinerAddrList, if requested
+		Miners        []MinerRecord // each miner in MinerAddrList, if Detailed
+		Total         int           // matching miners before Offset and Limit
+		Assigned      string        // miner the client should use. empty if coord does not assign miners
+	}
+
+	CheckVoterStatusArgs struct {
+		StudentID string
+		PublicKey []byte    // key of the voter
+		SentAt    time.Time // when the voter signed the request, by its clock
+		Signature []byte    // of VoterStatusDigest by the voter key
+	}
+
+	CheckVoterStatusReply struct {
+		RPCStatus
+		Ballots []VoterBallot // ballots of the student ID signed with PublicKey on the longest chain, in all races
+	}
+
+	AuditVotersArgs struct {
+	}
+
+	AuditVotersReply struct {
+		RPCStatus
+		Voters    int      // distinct student IDs with ballots on the longest chain
+		Ballots   int      // ballots on the longest chain
+		OverLimit []string // salted hashes of student IDs with more ballots in a race than it allows
+	}
+
+	GetChainStatsArgs struct {
+	}
+
+	GetChainStatsReply struct {
+		RPCStatus
+		Stats     blockchain.ChainStats
+		Inclusion InclusionStats // how long txns took to get into a block
+	}
+
+	DrainArgs struct {
+		Standby  string        // client API address of the coord clients should move to. none if empty
+		Snapshot string        // where the database snapshot is written. a file in BackupDir if empty
+		Grace    time.Duration // time clients have to ask for the standby. DefaultDrainGrace if 0
+	}
+
+	DrainReply struct {
+		RPCStatus
+		Snapshot string
+	}
+
+	GetStandbyArgs struct {
+	}
+
+	GetStandbyReply struct {
+		RPCStatus
+		Draining bool
+		Standby  string // client API address to use instead. none if empty
+	}
+
+	GetQuarantineArgs struct {
+	}
+
+	GetQuarantineReply struct {
+		RPCStatus
+		Peers []PeerScore // penalized miners, quarantined or not
+	}
+
+	ReloadCandidatesArgs struct {
+	}
+
+	ReloadCandidatesReply struct {
+		RPCStatus
+		Version uint64 // version of the candidate list after the reload
+	}
+
+	ClearQuarantineArgs struct {
+		MinerID string // clears every miner if empty
+	}
+
+	ClearQuarantineReply struct {
+		RPCStatus
+		Cleared int
 	}
 
 	QueryTxnArgs struct {
-		TxID []byte
+		rpcCaller
+		ClientID uint // see ClientID in evlib. 0 if the client did not tell
+		TxID     []byte
 	}
 
 	QueryTxnReply struct {
+		RPCStatus
 		NumConfirmed int
+		Finalized    bool // NumConfirmed reached the finality depth of the chain
+	}
+
+	QueryTxnsArgs struct {
+		rpcCaller
+		ClientID uint     // see ClientID in evlib. 0 if the client did not tell
+		TxIDs    [][]byte // at most MaxQueryTxns
+	}
+
+	QueryTxnsReply struct {
+		RPCStatus
+		Statuses []TxnStatus // status of each txn of TxIDs, in order
 	}
 
 	QueryResultsArgs struct {
+		rpcCaller
+		ClientID uint   // see ClientID in evlib. 0 if the client did not tell
+		Strict   bool   // only count finalized ballots. every ballot on the longest chain counts if false
+		At       []byte // count on the chain ending at this block. the tip of the longest chain if nil
 	}
 
 	QueryResultsReply struct {
-		Votes []uint
+		RPCStatus
+		Votes    []uint      // votes (first choices of ranked ballots) of each candidate, in the order of GetCandidates
+		Races    []RaceTally // votes grouped by race
+		Height   uint32      // block number of LastHash
+		LastHash []byte      // tip of the chain the votes were counted at, args.At if set
+		Strict   bool        // only finalized ballots were counted
+
+		OnLongestChain bool   // LastHash is on the longest chain. a pinned block may have been left on a fork
+		TipHeight      uint32 // block number of the tip of the longest chain
+	}
+
+	QueryTurnoutArgs struct {
+		rpcCaller
+		ClientID uint // see ClientID in evlib. 0 if the client did not tell
+	}
+
+	QueryTurnoutReply struct {
+		RPCStatus
+		Height   uint32 // block number of the tip the turnout was counted at
+		LastHash []byte // that tip
+		Current  Turnout
+		Final    Turnout // ballots with FinalityDepth confirmations only
+	}
+
+	QueryTxnsByVoterArgs struct {
+		PubKeyHash []byte
+	}
+
+	QueryTxnsByVoterReply struct {
+		RPCStatus
+		Txns []VoterTxn // newest first
+	}
+
+	QueryTxnsByCandidateArgs struct {
+		Race      string // empty in an election with a single race
+		Candidate string
+		Offset    int // skip this many of the ballots
+		Limit     int // at most this many ballots. no limit if 0
+	}
+
+	QueryTxnsByCandidateReply struct {
+		RPCStatus
+		Ballots []CandidateBallot // oldest first
+		Total   int               // ballots counted toward the candidate before Offset and Limit
+	}
+
+	GetHeadersArgs struct {
+		FromHeight uint32
+	}
+
+	GetHeadersReply struct {
+		RPCStatus
+		Headers []blockchain.BlockHeader
+	}
+
+	GetBlockBodyArgs struct {
+		Hash []byte
+	}
+
+	GetBlockBodyReply struct {
+		RPCStatus
+		Found bool
+		Body  blockchain.BlockBody // check it against the block's header with BlockHeader.Matches
+	}
+
+	GetTxnProofArgs struct {
+		TxID []byte
+	}
+
+	GetTxnProofReply struct {
+		RPCStatus
+		Found     bool
+		Txn       blockchain.Transaction
+		BlockHash []byte
+		Proof     blockchain.MerkleProof
+	}
+
+	WaitReorgArgs struct {
+		Since uint64   // Seq of the last WaitReorgReply. 0 on the first call
+		TxIDs [][]byte // txns the client wants to hear about
+	}
+
+	WaitReorgReply struct {
+		RPCStatus
+		Seq         uint64   // sequence number of the last fork switch on coord
+		Invalidated [][]byte // TxIDs dropped from the longest chain since args.Since
+		Missed      bool     // coord no longer knows every fork switch since args.Since, check all txns
+	}
+
+	GetResultCertificateArgs struct {
+		At []byte // certify the tally at this block of the longest chain. the tip if nil
+	}
+
+	GetResultCertificateReply struct {
+		RPCStatus
+		Certificate ResultCertificate
 	}
 )
 
+// RaceTally is the vote count of each candidate of a race
+type RaceTally struct {
+	Race       string
+	Candidates []string
+	Votes      []uint
+	Abstain    uint                     // number of abstentions
+	WriteIns   map[string]uint          // votes of each written-in name
+	Method     string                   // tally method, see blockchain.MethodIRV
+	Runoff     *blockchain.RunoffResult // round by round results of an instant-runoff race
+}
+
+// MinerLoad is a load balancing hint for clients choosing a miner
+type MinerLoad struct {
+	MinerID      string
+	PoolSize     int             // pending txns. -1 if the miner did not answer in time
+	RecentBlocks int             // blocks mined among the last RecentBlocksWindow blocks of the longest chain
+	Height       int             // block number of the tip of the miner's longest chain. -1 if the miner did not answer in time
+	Throttle     *MiningThrottle // how much CPU the miner allows itself. nil if the miner did not answer in time
+}
+
+// MinerRecord describes a miner in GetMinerList
+type MinerRecord struct {
+	MinerID       string
+	Addr          string // client API address
+	Label         string
+	Height        int           // block number of the tip of the miner's longest chain. -1 if unknown
+	LastHeartbeat time.Time     // when the miner last acked coord's heartbeat. zero if it never did
+	ClockOffset   time.Duration // how far the miner's clock is ahead of coord's, measured by heartbeats
+}
+
+// TxnStatus is the status of a txn in QueryTxns
+type TxnStatus struct {
+	NumConfirmed int  // -1 if the txn is not on the longest chain
+	Finalized    bool // NumConfirmed reached the finality depth of the chain
+}
+
+// VoterBallot is a ballot of a voter, as kept in coord's voter index
+type VoterBallot struct {
+	TxID  []byte
+	Race  string
+	Type  string // see blockchain.BallotCandidate
+	Voter []byte // public key hash of the voter key
+}
+
+// CandidateBallot is a ballot counted toward a candidate and the height of the block it is in
+type CandidateBallot struct {
+	TxID     []byte
+	BlockNum uint32
+}
+
+// VoterTxn is a transaction on the longest chain with the block containing it
+type VoterTxn struct {
+	Txn          blockchain.Transaction
+	BlockHash    []byte
+	BlockNum     uint32
+	NumConfirmed int
+}
+
 type Coord struct {
 	// Coord state may go here
 	Storage    *util.Database
 	Blockchain *blockchain.BlockChain
 
-	Candidates []*Identity.Wallets
+	Candidates    []*Identity.Wallets
+	Races         []RaceConfig // races of the election. a single race of generated candidates if empty
+	AllowWriteIns bool         // whether write-ins are allowed when there is a single race
+	Method        string       // tally method when there is a single race
+
+	CandidateEntries []CandidateEntry // candidates from the candidates file. generated or taken from Races if empty. guarded by candMu
+	CandidatesFile   string           // the candidates file. generated wallets are saved next to it
+	candMu           sync.RWMutex
+	candVersion      uint64 // version of the candidate list, see GetCandidatesReply. guarded by candMu
 
 	nlMu       sync.Mutex // lock NodeList & MinerConns
 	NodeList   []NodeInfo
 	MinerConns []*rpc.Client
 
 	GossipAddr string
+
+	tracer *tracing.Tracer
+	trace  *tracing.Trace
+
+	Events *events.Bus
+
+	Metrics           *metrics.Registry
+	MetricsListenAddr string // where /metrics is served. not served if empty
+	metrics           coordMetrics
+
+	FeedListenAddr string // where the live results feed (/feed) is served. not served if empty
+
+	HealthListenAddr string // where /healthz and /readyz are served. not served if empty
+
+	BridgeListenAddr string             // where the HTTP CONNECT bridge for firewalled clients is served. not served if empty
+	bridgeSources    util.BridgeSources // the clients the bridge's tunnels are for
+
+	AdminListenAddr string // where admin API requests are served. not served if empty
+	AdminToken      string // token admin API callers must send, also sent to miners when recovering. see LoadAdminToken
+	Peers           *PeerScores
+
+	MaxConcurrentRPCs int // RPC requests handled at once, the others wait. no limit if 0
+	rpcGuard          *util.RPCGuard
+	clientLimiter     *util.RateLimiter // GetMinerList, QueryTxn(s) and QueryResults calls per client host. no limit if nil
+	clientIDLimiter   *util.RateLimiter // the same calls per client ID. no limit if nil
+
+	AuthorityKeyFile string // key signing result certificates. a new key is used every run if empty
+	authorityKey     *ecdsa.PrivateKey
+
+	ElectionEnd time.Time // no ballots are accepted after it. the election never closes if zero
+
+	RegisteredVoters int // voters registered to vote, the base of the turnout in QueryTurnout. unknown if 0
+
+	SealingKeyFile string // key ballots are sealed to until ElectionEnd. ballots are not sealed if empty
+	sealingKey     *ecdsa.PrivateKey
+
+	RegistrarKeyFile string // key signing voter credentials, see RegisterVoter. anyone may vote if empty
+	registrarKey     *ecdsa.PrivateKey
+	Verifier         RegistrationVerifier // decides who may register. required with a RegistrarKeyFile
+	registerMu       sync.Mutex           // serializes registrations, so a student ID is bound to one key
+	Dev              bool                 // run a chain with dev voters, see blockchain.Registration. refused otherwise
+
+	FinalityDepth int   // confirmations a ballot needs to be final and counted. blockchain.NumConfirmed if 0
+	Difficulty    uint8 // leading zero bits of a block hash, see cmd/minerbench. blockchain.NumZeros if 0
+
+	ElectionID string                   // RPC services are registered under it, see Scoped. the default election if empty
+	Genesis    blockchain.GenesisConfig // derives the genesis block. CandidateHash is filled in from the candidates
+
+	reorgs    *reorgLog         // recent fork switches reported by WaitReorg
+	inclusion *inclusionTracker // how long txns take to get into a block, see watchInclusion
+	voters    *voterIndex       // ballots of each student ID on the longest chain
+	candTxns  *candidateIndex   // ballots counted toward each candidate on the longest chain
+
+	feedMu    sync.Mutex
+	feedTally TallyUpdate // tally of the tip last counted for the live feed, shared by its clients. guarded by feedMu
+
+	drainMu  sync.Mutex
+	draining chan struct{} // closed once Drain is called
+	standby  string        // coord handed to clients while draining. guarded by drainMu
+
+	AssignMode string // AssignRoundRobin to assign miners to clients in turn. clients pick miners if empty
+	nextMiner  int    // next miner to assign in round-robin mode. guarded by nlMu
+
+	BackupDir      string        // where scheduled backups are written to. no backup if empty
+	BackupInterval time.Duration // time between two scheduled backups
+	RestoreFrom    string        // backup file to restore the database from before starting
+	RecoverFrom    []string      // admin API addresses of miners to rebuild a missing database from, see recoverFromMiners
+	ForkRetention  time.Duration // how long abandoned fork blocks are kept. never pruned if 0
+	StorageKeyFile string        // node key file for encrypting the database at rest. not encrypted if empty
+	LostMsgThresh  uint8         // missed heartbeats before a miner is considered failed
+	MaxClockSkew   time.Duration // how far a miner's clock may be off coord's, see checkClockSkew. not checked if 0
+	InclusionSLA   time.Duration // how long txns may take to get into a block, see watchInclusion. not checked if 0
+	StorageDir     string        // database directory. in-memory database if empty
+
+	ReplicaOf           string        // miner API address of the primary coord. coord is a read replica of it if set
+	ReplicaSyncInterval time.Duration // time between two polls of the primary by a replica
+
+	StrictInvariants bool // re-check the chain and the indexes after every block and panic on a violation. for development
+	LegacyGenesis    bool // run a chain whose genesis block predates genesis configs, see blockchain.ErrLegacyGenesis
+
+	ClientAPIAddr string // where clients' API requests are served, once started
+	MinerAPIAddr  string // where miners' API requests are served, once started
+
+	gossip    *gossip.Client
+	fcheck    *fchecker.Checker
+	listeners []net.Listener
+	ready     chan struct{}
+	quit      chan struct{}
+	stopOnce  sync.Once
 }
 
 func NewCoord() *Coord {
-	return &Coord{
-		Storage: &util.Database{},
+	c := &Coord{
+		Storage:       &util.Database{},
+		Events:        events.NewBus(),
+		Metrics:       metrics.NewRegistry(),
+		Peers:         NewPeerScores(),
+		LostMsgThresh: 6,
+		MaxClockSkew:  blockchain.MaxClockDrift,
+		reorgs:        newReorgLog(),
+		draining:      make(chan struct{}),
+		rpcGuard:      util.NewRPCGuard(0),
+		StorageDir:    "./storage/coord",
+		gossip:        gossip.NewClient(),
+		fcheck:        fchecker.New(),
+		ready:         make(chan struct{}),
+		quit:          make(chan struct{}),
+	}
+	c.initMetrics()
+	c.inclusion = newInclusionTracker(c.Metrics)
+	c.watchReorgs()
+	return c
+}
+
+// Ready is closed once coord serves miners' and clients' API requests
+func (c *Coord) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Stop stops all services of coord and makes Start return. Coord cannot be started again.
+func (c *Coord) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		for _, listener := range c.listeners {
+			listener.Close()
+		}
+		c.gossip.Stop()
+		c.fcheck.Stop()
+	})
+}
+
+// SetPeerFilter restricts which gossip peers coord talks to. nil allows all peers.
+func (c *Coord) SetPeerFilter(filter func(peer string) bool) {
+	c.gossip.SetPeerFilter(filter)
+}
+
+// StartWithConfig applies the optional settings in cfg and starts coord
+func (c *Coord) StartWithConfig(cfg *CoordConfig, ctrace *tracing.Tracer) error {
+	c.BackupDir = cfg.BackupDir
+	c.ElectionID = cfg.ElectionID
+	if c.ElectionID != "" {
+		// elections hosted by one process keep their databases and backups apart
+		c.StorageDir = filepath.Join(c.StorageDir, c.ElectionID)
+		if c.BackupDir != "" {
+			c.BackupDir = filepath.Join(c.BackupDir, c.ElectionID)
+		}
+	}
+	c.BackupInterval = time.Duration(cfg.BackupInterval) * time.Second
+	c.RecoverFrom = cfg.RecoverFrom
+	c.ForkRetention = time.Duration(cfg.ForkRetention) * time.Second
+	c.StorageKeyFile = cfg.StorageKeyFile
+	c.MetricsListenAddr = cfg.MetricsListenAddr
+	c.FeedListenAddr = cfg.FeedListenAddr
+	c.HealthListenAddr = cfg.HealthListenAddr
+	c.BridgeListenAddr = cfg.BridgeListenAddr
+	c.AdminListenAddr = cfg.AdminListenAddr
+	if cfg.AdminTokenFile != "" {
+		token, err := LoadAdminToken(cfg.AdminTokenFile)
+		if err != nil {
+			return err
+		}
+		c.AdminToken = token
+	}
+	c.AuthorityKeyFile = cfg.AuthorityKeyFile
+	c.SealingKeyFile = cfg.SealingKeyFile
+	c.RegistrarKeyFile = cfg.RegistrarKeyFile
+	c.Genesis.Registration.DevVoters = cfg.DevVoters
+	if cfg.VoterRollFile != "" && c.Verifier == nil {
+		roll, err := LoadVoterRoll(cfg.VoterRollFile)
+		if err != nil {
+			return err
+		}
+		c.Verifier = roll
 	}
+	c.Races = cfg.Races
+	c.AllowWriteIns = cfg.AllowWriteIns
+	c.Method = cfg.Method
+	c.AssignMode = cfg.AssignMode
+	c.RegisteredVoters = int(cfg.RegisteredVoters)
+	c.StrictInvariants = cfg.StrictInvariants
+	c.LegacyGenesis = cfg.LegacyGenesis
+	c.MaxConcurrentRPCs = int(cfg.MaxConcurrentRPCs)
+	c.rpcGuard = util.NewRPCGuard(c.MaxConcurrentRPCs)
+	c.clientLimiter = util.NewRateLimiter(cfg.ClientRateLimit, int(cfg.ClientRateBurst))
+	c.clientIDLimiter = util.NewRateLimiter(cfg.ClientIDRateLimit, int(cfg.ClientIDRateBurst))
+	if cfg.CandidatesFile != "" {
+		list, err := cfg.LoadCandidates()
+		if err != nil {
+			return err
+		}
+		c.CandidatesFile = cfg.CandidatesFile
+		c.CandidateEntries = list.Candidates
+	}
+	if cfg.ReplicaOf != "" {
+		c.ReplicaOf = cfg.ReplicaOf
+		c.ReplicaSyncInterval = time.Duration(cfg.ReplicaSyncInterval) * time.Second
+		c.StorageDir = ""
+		// everything else comes from the primary
+		return c.StartReplica(cfg.ClientAPIListenAddr, ctrace)
+	}
+	electionEnd, err := cfg.ElectionEndTime()
+	if err != nil {
+		return err
+	}
+	c.ElectionEnd = electionEnd
+	c.FinalityDepth = int(cfg.FinalityDepth)
+	c.Difficulty = cfg.Difficulty
+	genesisTime, err := cfg.GenesisTimestamp()
+	if err != nil {
+		return err
+	}
+	c.Genesis = blockchain.GenesisConfig{
+		ElectionID: c.ElectionID,
+		Timestamp:  genesisTime,
+		Difficulty: cfg.GenesisDifficulty,
+		HashAlgo:   cfg.HashAlgo,
+	}
+	if cfg.LostMsgThresh > 0 {
+		c.LostMsgThresh = cfg.LostMsgThresh
+	}
+	if cfg.MaxClockSkew > 0 {
+		c.MaxClockSkew = time.Duration(cfg.MaxClockSkew) * time.Second
+	}
+	c.InclusionSLA = time.Duration(cfg.InclusionSLA) * time.Second
+	nCandidates := cfg.NCandidates
+	if len(c.CandidateEntries) > 0 {
+		nCandidates = uint8(len(c.CandidateEntries))
+	} else if len(cfg.Races) > 0 {
+		nCandidates = 0
+		for _, race := range cfg.Races {
+			nCandidates += uint8(len(race.Candidates))
+		}
+	}
+	return c.Start(cfg.ClientAPIListenAddr, cfg.MinerAPIListenAddr, nCandidates, ctrace)
 }
 
 func (c *Coord) Start(clientAPIListenAddr string, minerAPIListenAddr string, nCandidates uint8, ctrace *tracing.Tracer) error {
+	c.tracer = ctrace
+	c.trace = CreateTrace(ctrace)
+	// 0. Sealing key, part of the genesis block
+	if c.SealingKeyFile != "" {
+		sealingKey, err := LoadAuthorityKey(c.SealingKeyFile)
+		if err != nil {
+			return errors.New("cannot load sealing key")
+		}
+		c.sealingKey = sealingKey
+		c.Genesis.SealingKey = blockchain.SealingPublicKey(sealingKey)
+	}
+	// 0.1 Registrar key and dev voters, part of the genesis block too
+	if c.Genesis.Registration.Dev() && !c.Dev {
+		return ErrDevChain
+	}
+	if c.RegistrarKeyFile != "" {
+		if c.Verifier == nil {
+			return errors.New("a registrar key needs a RegistrationVerifier")
+		}
+		registrarKey, err := LoadAuthorityKey(c.RegistrarKeyFile)
+		if err != nil {
+			return errors.New("cannot load registrar key")
+		}
+		c.registrarKey = registrarKey
+		c.Genesis.Registration.RegistrarKey = blockchain.RegistrarPublicKey(registrarKey)
+	}
 	// 1. Initialization
 	// 1.1 Storage(DB)
 	resume := c.InitStorage()
+	if !resume && len(c.RecoverFrom) > 0 {
+		log.Println("[INFO] Recovering database from miners...")
+		if err := c.recoverFromMiners(); err != nil {
+			return fmt.Errorf("cannot recover from miners: %v", err)
+		}
+		resume = true
+	}
 	if resume {
 		log.Println("[INFO] Restarting...")
 	}
 	defer c.Storage.Close()
+	go c.Storage.Maintain(StorageMaintenanceInterval)
+	if c.BackupDir != "" && c.BackupInterval > 0 {
+		go c.Storage.ScheduleBackup(c.BackupDir, c.BackupInterval)
+	}
 	// 1.2 Candidates
 	c.InitCandidates(nCandidates, resume)
+	if err := c.updateCandidateListVersion(); err != nil {
+		return fmt.Errorf("cannot version the candidate list: %v", err)
+	}
 	// 1.3 Blockchain
 	c.InitBlockchain(resume)
+	c.Blockchain.MinerKey = c.minerKey
+	voters, err := openVoterIndex(c.Storage, c.Blockchain)
+	if err != nil {
+		return errors.New("cannot open voter index")
+	}
+	c.voters = voters
+	if c.candTxns, err = openCandidateIndex(c.Storage, c.Blockchain); err != nil {
+		return errors.New("cannot open candidate index")
+	}
+	if c.ForkRetention > 0 {
+		go c.Blockchain.RunForkJanitor(c.ForkRetention)
+	}
+	// 1.4 Authority key
+	authorityKey, err := LoadAuthorityKey(c.AuthorityKeyFile)
+	if err != nil {
+		return errors.New("cannot load authority key")
+	}
+	c.authorityKey = authorityKey
+	log.Println("[INFO] Result certificates are signed by authority key", AuthorityPublicKey(authorityKey))
 	// print chain to file if restart
 	//if resume {
 	//	c.PrintChain()
 	//}
 
 	// 2. Starting API services
-	coordIp := minerAPIListenAddr[0:strings.Index(minerAPIListenAddr, ":")]
+	coordIp := util.HostOf(minerAPIListenAddr)
 	// gossip
 	var existingUpdates []gossip.Update
-	blockchainData, _ := c.Blockchain.Encode()
-	for _, data := range blockchainData {
-		existingUpdates = append(existingUpdates, gossip.NewUpdate(BlockIDPrefix, blockchain.DecodeToBlock(data).Hash, data))
+	_, err = c.Blockchain.Export(func(hash []byte, data []byte) error {
+		existingUpdates = append(existingUpdates, gossip.NewUpdate(BlockIDPrefix, hash, data))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	queryChan, _, gossipAddr, err := gossip.Start(2,
+	queryChan, _, gossipAddr, err := c.gossip.Start(2,
 		"Pull",
 		coordIp,
 		//[]string{},
@@ -149,7 +785,7 @@ func (c *Coord) Start(clientAPIListenAddr string, minerAPIListenAddr string, nCa
 		return err
 	}
 	c.GossipAddr = gossipAddr
-	// 1.4 NodeList
+	// 1.5 NodeList
 	c.InitNodeList(resume)
 
 	// fcheck
@@ -158,64 +794,116 @@ func (c *Coord) Start(clientAPIListenAddr string, minerAPIListenAddr string, nCa
 	for _, node := range c.NodeList {
 		remoteAckIPPortList = append(remoteAckIPPortList, node.Property.AckAddr)
 	}
-	_, notifyCh, err := fchecker.Start(fchecker.StartStruct{
+	_, notifyCh, err := c.fcheck.Start(fchecker.StartStruct{
 		LocalIP:                          coordIp,
 		EpochNonce:                       rand.New(rand.NewSource(time.Now().UnixNano())).Uint64(),
 		HBeatRemoteIPHBeatRemotePortList: remoteAckIPPortList,
-		LostMsgThresh:                    6,
+		LostMsgThresh:                    c.LostMsgThresh,
 	})
 	if err != nil {
 		return err
 	}
 	go c.Tracker(notifyCh)
+	if c.MaxClockSkew > 0 {
+		go c.watchClockSkew()
+	}
+	go c.watchInclusion()
+	if c.sealingKey != nil {
+		go c.releaseSealingKey()
+	}
 
 	// >> miner
 	coordAPIMiner := new(CoordAPIMiner)
 	coordAPIMiner.c = c
-	err = util.NewRPCServerWithIpPort(coordAPIMiner, minerAPIListenAddr)
+	c.MinerAPIAddr, err = c.listen("CoordAPIMiner", coordAPIMiner, minerAPIListenAddr)
 	if err != nil {
 		return errors.New("cannot start API service for miner")
 	}
-	log.Println("[INFO] Listen to miners' API requests at", minerAPIListenAddr)
+	log.Println("[INFO] Listen to miners' API requests at", c.MinerAPIAddr)
 
 	// >> client
 	coordAPIClient := new(CoordAPIClient)
 	coordAPIClient.c = c
-	err = util.NewRPCServerWithIpPort(coordAPIClient, clientAPIListenAddr)
+	c.ClientAPIAddr, err = c.listen("CoordAPIClient", coordAPIClient, clientAPIListenAddr)
 	if err != nil {
 		return errors.New("cannot start API service for client")
 	}
-	log.Println("[INFO] Listen to clients' API requests at", clientAPIListenAddr)
+	log.Println("[INFO] Listen to clients' API requests at", c.ClientAPIAddr)
+
+	// >> admin
+	if c.AdminListenAddr != "" {
+		coordAPIAdmin := new(CoordAPIAdmin)
+		coordAPIAdmin.c = c
+		if c.AdminToken == "" {
+			return errors.New("the admin API needs an admin token")
+		}
+		adminAddr, err := c.listenWithToken("CoordAPIAdmin", coordAPIAdmin, c.AdminListenAddr, c.AdminToken)
+		if err != nil {
+			return errors.New("cannot start admin API service")
+		}
+		log.Println("[INFO] Listen to admin API requests at", adminAddr)
+	}
+
+	// >> metrics
+	if c.MetricsListenAddr != "" {
+		err = c.Metrics.Serve(c.MetricsListenAddr)
+		if err != nil {
+			return errors.New("cannot start metrics service")
+		}
+		log.Println("[INFO] Serving metrics at", c.MetricsListenAddr)
+	}
+
+	// >> live results feed
+	if c.FeedListenAddr != "" {
+		err = c.serveFeed(c.FeedListenAddr)
+		if err != nil {
+			return errors.New("cannot start live results feed")
+		}
+		log.Println("[INFO] Serving live results feed at", c.FeedListenAddr)
+	}
+
+	// >> health probes
+	if c.HealthListenAddr != "" {
+		listener, err := serveHealth(c.HealthListenAddr, c.health)
+		if err != nil {
+			return errors.New("cannot start health probes")
+		}
+		c.listeners = append(c.listeners, listener)
+		log.Println("[INFO] Serving health probes at", c.HealthListenAddr)
+	}
+
+	// >> bridge for firewalled clients
+	if c.BridgeListenAddr != "" {
+		err = c.serveBridge(c.BridgeListenAddr)
+		if err != nil {
+			return errors.New("cannot start client bridge")
+		}
+		log.Println("[INFO] Serving client bridge at", c.BridgeListenAddr)
+	}
+
+	close(c.ready)
 
 	// 3. receive blocks from miners
 	for {
-		data := <-queryChan
+		var data gossip.Update
+		select {
+		case data = <-queryChan:
+		case <-c.quit:
+			return nil
+		}
 		// check if it is a block
 		if strings.HasPrefix(data.ID, BlockIDPrefix) {
-			block := blockchain.DecodeToBlock(data.Data)
-			// check if it is an unseen block
-			if !c.Blockchain.Exist(block.Hash) {
-				// try to put it to the blockchain
-				prevLastHash := c.Blockchain.GetLastHash()
-				success, switched, _ := c.Blockchain.Put(*block, false)
-				curLastHash := c.Blockchain.GetLastHash()
-				if success {
-					log.Printf("[INFO] Received valid block #%d (%x) by %s\n", block.BlockNum, block.Hash[:5], block.MinerID)
-					blockchain.PrintBlock(block)
-					if switched == nil {
-						if bytes.Compare(prevLastHash, curLastHash) != 0 {
-							log.Println("[INFO] Added new block to the current chain")
-						} else {
-							log.Println("[INFO] Added new block to an alternative chain")
-						}
-					} else {
-						log.Println("[INFO] Added new block to an alternative chain")
-						log.Println("[INFO] Switching to a new chain")
-					}
-
-				} else {
-					log.Printf("[WARN] Rejected invalid block #%d (%x) by %s\n", block.BlockNum, block.Hash[:5], block.MinerID)
-				}
+			block, err := blockchain.DecodeBlock(data.Data)
+			if err != nil {
+				log.Printf("[WARN] Dropped malformed block from %s: %v\n", data.ID, err)
+				continue
+			}
+			c.ingestBlock(block)
+		} else if strings.HasPrefix(data.ID, TransactionIDPrefix) {
+			// a miner accepted it. only its first sighting is timed, see watchInclusion
+			txn, err := blockchain.DecodeTransaction(data.Data)
+			if err == nil {
+				c.Events.Publish(events.Event{Topic: events.NewTxn, Txn: &txn})
 			}
 		}
 	}
@@ -227,6 +915,98 @@ func (c *Coord) Start(clientAPIListenAddr string, minerAPIListenAddr string, nCa
 	//return nil
 }
 
+// ingestBlock puts an unseen block received from miners (or from the primary, for a replica) to the blockchain
+func (c *Coord) ingestBlock(block *blockchain.Block) {
+	if c.Blockchain.Exist(block.Hash) {
+		return
+	}
+	// try to put it to the blockchain
+	prevLastHash := c.Blockchain.GetLastHash()
+	result := c.Blockchain.Put(*block, false)
+	switched, oldTxns := result.NewTxns, result.OldTxns
+	curLastHash := result.LastHash
+	if result.Added() {
+		log.Printf("[INFO] Received valid block #%d (%x) by %s\n", block.BlockNum, block.Hash[:5], block.MinerID)
+		var err error
+		if switched != nil {
+			err = c.voters.apply(switched, oldTxns, curLastHash)
+		} else if bytes.Equal(curLastHash, block.Hash) {
+			err = c.voters.apply(block.Txns, nil, curLastHash)
+		}
+		if err != nil {
+			log.Println("[ERROR] Unable to update the voter index:", err)
+		}
+		if switched != nil {
+			err = c.candTxns.apply(switched, oldTxns, curLastHash)
+		} else if bytes.Equal(curLastHash, block.Hash) {
+			err = c.candTxns.apply(block.Txns, nil, curLastHash)
+		}
+		if err != nil {
+			log.Println("[ERROR] Unable to update the candidate index:", err)
+		}
+		c.recordTally(block)
+		if c.StrictInvariants {
+			c.assertIndexInvariants(block)
+		}
+		blockchain.PrintBlock(block)
+		c.Events.Publish(events.Event{
+			Topic:          events.NewBlock,
+			Block:          block,
+			OnLongestChain: bytes.Compare(curLastHash, block.Hash) == 0,
+		})
+		if switched == nil {
+			if bytes.Compare(prevLastHash, curLastHash) != 0 {
+				log.Println("[INFO] Added new block to the current chain")
+			} else {
+				log.Println("[INFO] Added new block to an alternative chain")
+			}
+		} else {
+			log.Println("[INFO] Added new block to an alternative chain")
+			log.Println("[INFO] Switching to a new chain")
+			for _, correction := range result.TallyCorrections {
+				log.Printf("[INFO] Tally at height %d corrected from %v (%x) to %v (%x)\n", correction.Height,
+					correction.Old, correction.OldHash[:5], correction.New, correction.NewHash[:5])
+			}
+			c.Events.Publish(events.Event{
+				Topic:       events.ForkSwitch,
+				OldLastHash: prevLastHash,
+				NewLastHash: curLastHash,
+				NewTxns:     switched,
+				OldTxns:     oldTxns,
+			})
+			RecordAction(c.trace, ForkSwitch{
+				Node:        "coord",
+				OldLastHash: prevLastHash,
+				NewLastHash: curLastHash,
+				NumNewTxns:  len(switched),
+				NumOldTxns:  len(oldTxns),
+			})
+		}
+
+	} else if result.Status.Invalid() {
+		log.Printf("[WARN] Rejected invalid block #%d (%x) by %s: %v\n", block.BlockNum, block.Hash[:5], block.MinerID, result.Err)
+		// anyone can put an honest miner's ID on a made up block. only one the miner signed counts against it
+		if result.Attributed {
+			c.Peers.Penalize(block.MinerID, InvalidBlockPenalty, fmt.Sprintf("invalid block #%d (%x)", block.BlockNum, block.Hash[:5]))
+		}
+	}
+}
+
+// listen serves handler as the given service of coord's election at listenAddr and keeps the listener for Stop
+func (c *Coord) listen(service string, handler interface{}, listenAddr string) (string, error) {
+	return c.listenWithToken(service, handler, listenAddr, "")
+}
+
+// listenWithToken is listen serving only callers that send token, see util.ListenRPCWithToken
+func (c *Coord) listenWithToken(service string, handler interface{}, listenAddr string, token string) (string, error) {
+	listener, err := util.ListenRPCAsWithToken(Scoped(c.ElectionID, service), handler, listenAddr, token)
+	if err != nil {
+		return "", err
+	}
+	c.listeners = append(c.listeners, listener)
+	return util.ListenerAddr(util.HostOf(listenAddr), listener), nil
+}
+
 func (c *Coord) Tracker(notifyCh <-chan fchecker.FailureDetected) {
 	for {
 		select {
@@ -236,6 +1016,11 @@ func (c *Coord) Tracker(notifyCh <-chan fchecker.FailureDetected) {
 				for idx, node := range c.NodeList {
 					if node.Property.AckAddr == failure.UDPIpPort {
 						log.Printf("[INFO] Detected a miner failure: %s (%d remains)\n", node.Property.MinerId, len(c.NodeList)-1)
+						c.Events.Publish(events.Event{
+							Topic:     events.MinerLost,
+							MinerID:   node.Property.MinerId,
+							MinerAddr: node.Property.ClientListenAddr,
+						})
 						// remove from disk first
 						c.Storage.Remove(util.DBKeyWithPrefix(NodeKeyPrefix, []byte(node.Property.MinerId)))
 						// remove from node list
@@ -253,22 +1038,60 @@ func (c *Coord) Tracker(notifyCh <-chan fchecker.FailureDetected) {
 				for _, info := range c.NodeList {
 					peerGossipAddrList = append(peerGossipAddrList, info.Property.GossipAddr)
 				}
-				gossip.SetPeers(peerGossipAddrList)
+				c.gossip.SetPeers(peerGossipAddrList)
 				c.nlMu.Unlock()
 			}
-		default:
-			continue
+		case <-c.quit:
+			return
 		}
 	}
 }
 
 func (c *Coord) InitStorage() (resume bool) {
-	if _, err := os.Stat("./storage/coord"); err == nil {
-		err := c.Storage.Load("./storage/coord")
+	if c.StorageKeyFile != "" {
+		key, err := util.LoadKeyFile(c.StorageKeyFile)
+		util.CheckErr(err, "[ERROR] error when loading storage key")
+		err = c.Storage.EnableEncryption(key)
+		util.CheckErr(err, "[ERROR] error when enabling storage encryption")
+	}
+	if c.StorageDir == "" {
+		err := c.Storage.New("", true)
+		util.CheckErr(err, "[ERROR] error when creating database")
+		if c.RestoreFrom != "" {
+			err = c.Storage.RestoreFromFile(c.RestoreFrom)
+			util.CheckErr(err, "[ERROR] error when restoring database from %s", c.RestoreFrom)
+			return true
+		}
+		return false
+	}
+	if c.RestoreFrom != "" {
+		// replace whatever is on disk with the backup
+		err := os.RemoveAll(c.StorageDir)
+		util.CheckErr(err, "[ERROR] error when removing old database")
+		err = c.Storage.New(c.StorageDir, false)
+		util.CheckErr(err, "[ERROR] error when creating database")
+		err = c.Storage.RestoreFromFile(c.RestoreFrom)
+		util.CheckErr(err, "[ERROR] error when restoring database from %s", c.RestoreFrom)
+		log.Println("[INFO] Restored database from", c.RestoreFrom)
+		return true
+	}
+	if _, err := os.Stat(c.StorageDir); err == nil {
+		err := c.Storage.Load(c.StorageDir)
 		util.CheckErr(err, "[ERROR] error when reloading database")
+		if c.Storage.Legacy() {
+			log.Println("[WARN] Database was written before value checksums, run cmd/migrate to add them")
+		}
+		corrupted, err := c.Storage.CheckIntegrity()
+		util.CheckErr(err, "[ERROR] error when checking database integrity")
+		if len(corrupted) > 0 {
+			for _, key := range corrupted {
+				log.Printf("[ERROR] Corrupted value under key %q\n", key)
+			}
+			log.Fatalf("[ERROR] Database has %d corrupted values\n", len(corrupted))
+		}
 		resume = true
 	} else if os.IsNotExist(err) {
-		err := c.Storage.New("./storage/coord", false)
+		err := c.Storage.New(c.StorageDir, false)
 		util.CheckErr(err, "[ERROR] error when creating database")
 		resume = false
 	} else {
@@ -277,15 +1100,65 @@ func (c *Coord) InitStorage() (resume bool) {
 	return resume
 }
 
+// blockDifficulty returns the leading zero bits of a block hash of the config
+func (c *Coord) blockDifficulty() uint8 {
+	if c.Difficulty == 0 {
+		return blockchain.NumZeros
+	}
+	return c.Difficulty
+}
+
 func (c *Coord) InitBlockchain(resume bool) {
 	c.Blockchain = blockchain.NewBlockChain(c.Storage, c.Candidates)
+	c.Blockchain.Strict = c.StrictInvariants
+	c.Blockchain.LegacyGenesis = c.LegacyGenesis
+	c.Blockchain.KeyPrefixes = []string{TallyKeyPrefix}
+	c.Genesis.CandidateHash = blockchain.CandidateSetHash(c.Candidates)
 	if !resume {
-		err := c.Blockchain.Init()
+		err := c.Blockchain.Init(c.Genesis)
 		util.CheckErr(err, "[ERROR] error when initializing blockchain")
 	} else {
 		err := c.Blockchain.ResumeFromDB()
 		util.CheckErr(err, "[ERROR] error when reloading blockchain")
+		err = c.Blockchain.VerifyStored()
+		util.CheckErr(err, "[ERROR] stored blockchain is corrupted")
+		err = c.Blockchain.CheckGenesis(c.Genesis)
+		util.CheckErr(err, "[ERROR] stored blockchain belongs to another genesis config: %v\n", err)
+		c.Blockchain.IndexTallies()
+		err = c.Blockchain.IndexHeights()
+		util.CheckErr(err, "[ERROR] error when indexing block heights")
+	}
+	log.Printf("[INFO] Genesis block is %x\n", c.Blockchain.GenesisHash())
+	// the deadline and the depth are fixed when the chain starts: ballots must not be let in or shut out after
+	// the fact, and ballots already reported final must stay final. chains stored before they were chain
+	// parameters take the ones of the config
+	var err error
+	if !resume || !c.Storage.KeyExist(blockchain.DeadlineKey) {
+		err = c.Blockchain.SetDeadline(c.ElectionEnd)
+		util.CheckErr(err, "[ERROR] error when saving election deadline")
+	} else if !c.Blockchain.Deadline.Equal(c.ElectionEnd) {
+		util.CheckErr(ErrDeadlineChanged, "[ERROR] stored chain ends at %v, the config at %v",
+			c.Blockchain.Deadline, c.ElectionEnd)
 	}
+	if !resume || !c.Storage.KeyExist(blockchain.FinalityDepthKey) {
+		err = c.Blockchain.SetFinalityDepth(c.FinalityDepth)
+		util.CheckErr(err, "[ERROR] error when saving finality depth")
+	} else if c.Blockchain.FinalityDepth != c.FinalityDepth {
+		util.CheckErr(ErrFinalityDepthChanged, "[ERROR] stored chain has FinalityDepth %d, the config %d",
+			c.Blockchain.FinalityDepth, c.FinalityDepth)
+	}
+	// so is the difficulty. chains stored before it was a chain parameter were mined at blockchain.NumZeros
+	if !resume {
+		err = c.Blockchain.SetDifficulty(c.blockDifficulty())
+		util.CheckErr(err, "[ERROR] error when saving difficulty")
+	} else if c.Blockchain.BlockDifficulty() != c.blockDifficulty() {
+		util.CheckErr(ErrDifficultyChanged, "[ERROR] stored chain has Difficulty %d, the config %d",
+			c.Blockchain.BlockDifficulty(), c.blockDifficulty())
+	}
+	err = c.Blockchain.SetSealingKey(c.Genesis.SealingKey)
+	util.CheckErr(err, "[ERROR] error when saving sealing key")
+	err = c.Blockchain.SetRegistration(c.Genesis.Registration)
+	util.CheckErr(err, "[ERROR] error when saving registration")
 }
 
 func (c *Coord) InitCandidates(nCandidates uint8, resume bool) {
@@ -293,16 +1166,45 @@ func (c *Coord) InitCandidates(nCandidates uint8, resume bool) {
 		var keys = [][]byte{util.DBKeyWithPrefix(NCandidatesKey, []byte{})}
 		var values = [][]byte{[]byte(strconv.Itoa(int(nCandidates)))}
 
+		var saved map[string]*Identity.Wallets
+		if len(c.CandidateEntries) > 0 {
+			var err error
+			saved, err = loadCandidateWallets(candidateWalletsFile(c.CandidatesFile))
+			util.CheckErr(err, "[ERROR] error when loading candidate wallets")
+		}
 		for i := 0; i < int(nCandidates); i++ {
-			can, err := Identity.CreateCandidate("CANDIDATE" + strconv.Itoa(i))
-			if err != nil {
+			var can *Identity.Wallets
+			var race RaceConfig
+			var err error
+			if len(c.CandidateEntries) > 0 {
+				entry := c.CandidateEntries[i]
+				race = c.raceConfig(entry.Race)
+				can, err = fileCandidate(c.ElectionID, entry, saved)
+				util.CheckErr(err, "[ERROR] error when initializing candidates")
+				can.CandidateData.ID = entry.ID
+			} else {
+				name := "CANDIDATE" + strconv.Itoa(i)
+				race = RaceConfig{AllowWriteIns: c.AllowWriteIns, Method: c.Method}
+				if len(c.Races) > 0 {
+					name, race, err = c.raceCandidate(i)
+					util.CheckErr(err, "[ERROR] error when initializing candidates")
+				}
+				can, err = Identity.CreateCandidateIn(c.ElectionID, name)
 				util.CheckErr(err, "[ERROR] error when initializing candidates")
+				can.AddWallet()
 			}
-			can.AddWallet()
+			can.CandidateData.Race = race.Name
+			can.CandidateData.MaxVotes = race.MaxVotes
+			can.CandidateData.AllowWriteIns = race.AllowWriteIns
+			can.CandidateData.Method = race.Method
 			keys = append(keys, util.DBKeyWithPrefix(CandidateKeyPrefix, []byte(strconv.Itoa(i))))
 			values = append(values, can.Encode())
 			c.Candidates = append(c.Candidates, can)
 		}
+		if len(c.CandidateEntries) > 0 {
+			err := saveCandidateWallets(candidateWalletsFile(c.CandidatesFile), saved)
+			util.CheckErr(err, "[ERROR] error when saving candidate wallets")
+		}
 		err := c.Storage.PutMulti(keys, values)
 		util.CheckErr(err, "[ERROR] error when saving candidates")
 	} else {
@@ -315,9 +1217,68 @@ func (c *Coord) InitCandidates(nCandidates uint8, resume bool) {
 		if int(nCandidates) != len(c.Candidates) {
 			panic("[ERROR] error reloading candidates: expect " + strconv.Itoa(int(nCandidates)) + ", got " + strconv.Itoa(len(c.Candidates)))
 		}
+		err = c.checkStoredCandidates()
+		util.CheckErr(err, "[ERROR] candidates file does not match the stored candidates")
 	}
 }
 
+// raceCandidate returns the name and race of the i-th candidate over all races
+func (c *Coord) raceCandidate(i int) (string, RaceConfig, error) {
+	idx := i
+	for _, race := range c.Races {
+		if idx < len(race.Candidates) {
+			return race.Candidates[idx], race, nil
+		}
+		idx -= len(race.Candidates)
+	}
+	return "", RaceConfig{}, fmt.Errorf("the races have no candidate #%d", i)
+}
+
+// results tallies the chain ending at lastHash. votes are in the order of c.Candidates and
+// tallies group them by race, in the order races first appear in the candidate list. Only finalized ballots
+// are counted if strict
+func (c *Coord) results(lastHash []byte, strict bool) (votes []uint, tallies []RaceTally) {
+	return tallyResults(c.Blockchain, lastHash, strict)
+}
+
+// tallyResults is Coord.results for any copy of the chain, e.g. a miner's. Only finalized ballots are
+// counted if strict
+func tallyResults(chain *blockchain.BlockChain, lastHash []byte, strict bool) (votes []uint, tallies []RaceTally) {
+	depth := 0
+	if strict {
+		depth = chain.RequiredConfirmations()
+	}
+	// blocks of the longest chain have their tally in the chain's tally index. others are counted
+	indexed, ok := chain.TallyAt(lastHash, depth)
+	votes, extras := indexed.Votes, indexed.Extras
+	if !ok {
+		votes, _ = chain.VotingStatusAt(lastHash, depth)
+		extras = chain.ExtraVotesAt(lastHash, depth)
+	}
+	runoffs := chain.RunoffAt(lastHash, depth)
+	index := make(map[string]int)
+	for idx, cand := range chain.Candidates {
+		race := cand.CandidateData.Race
+		if _, ok := index[race]; !ok {
+			index[race] = len(tallies)
+			tallies = append(tallies, RaceTally{Race: race, Method: cand.CandidateData.Method})
+		}
+		tally := &tallies[index[race]]
+		tally.Candidates = append(tally.Candidates, cand.CandidateData.CandidateName)
+		tally.Votes = append(tally.Votes, votes[idx])
+	}
+	for idx := range tallies {
+		if extra, ok := extras[tallies[idx].Race]; ok {
+			tallies[idx].Abstain = extra.Abstain
+			tallies[idx].WriteIns = extra.WriteIns
+		}
+		if runoff, ok := runoffs[tallies[idx].Race]; ok {
+			tallies[idx].Runoff = &runoff
+		}
+	}
+	return
+}
+
 func (c *Coord) InitNodeList(resume bool) {
 	if resume {
 		values, err := c.Storage.GetAllWithPrefix(NodeKeyPrefix)
@@ -332,9 +1293,9 @@ func (c *Coord) InitNodeList(resume bool) {
 			// reconstruct node list
 			c.NodeList = append(c.NodeList, node)
 			// re-add gossip peer
-			gossip.AddPeer(node.Property.GossipAddr)
+			c.gossip.AddPeer(node.Property.GossipAddr)
 			// reconnect
-			minerConn, err := rpc.Dial("tcp", node.Property.CoordListenAddr)
+			minerConn, err := util.DialRPC(node.Property.CoordListenAddr)
 			if err != nil {
 				// silently digest error
 				log.Println("[WARN] cannot connect to miner at", node.Property.CoordListenAddr)
@@ -359,9 +1320,10 @@ func (c *Coord) NotifyMiners() {
 			args := NotifyPeerListArgs{
 				PeerAddrList:       peerAddrList,
 				PeerGossipAddrList: peerGossipAddrList,
+				MinerKeys:          c.registeredMiners(),
 			}
 			reply := NotifyPeerListReply{}
-			err := minerConn.Call("MinerAPICoord.NotifyPeerList", args, &reply)
+			err := Call(minerConn, "MinerAPICoord.NotifyPeerList", args, &reply)
 			if err != nil {
 				log.Println("[WARN] Unable to notify a miner")
 			}
@@ -369,13 +1331,68 @@ func (c *Coord) NotifyMiners() {
 	}
 }
 
+// minerLoads asks every miner for its pool size and counts its recent blocks on the longest chain
+func (c *Coord) minerLoads(nodeList []NodeInfo, minerConns []*rpc.Client) []MinerLoad {
+	recent := make(map[string]int)
+	iter := c.Blockchain.NewIterator(c.Blockchain.GetLastHash())
+	for i := 0; i < RecentBlocksWindow; i++ {
+		header, end := iter.NextHeader()
+		if end {
+			break
+		}
+		recent[header.MinerID]++
+	}
+
+	loads := make([]MinerLoad, len(nodeList))
+	var wg sync.WaitGroup
+	for i, info := range nodeList {
+		loads[i] = MinerLoad{MinerID: info.Property.MinerId, PoolSize: -1, RecentBlocks: recent[info.Property.MinerId], Height: -1}
+		if minerConns[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, conn *rpc.Client) {
+			defer wg.Done()
+			reply := GetLoadReply{}
+			call := conn.Go("MinerAPICoord.GetLoad", GetLoadArgs{}, &reply, make(chan *rpc.Call, 1))
+			select {
+			case <-call.Done:
+				if call.Error == nil && reply.Err() == nil {
+					loads[i].PoolSize = reply.PoolSize
+					loads[i].Height = int(reply.Height)
+					loads[i].Throttle = &reply.Throttle
+				}
+			case <-time.After(LoadQueryTimeout):
+			}
+		}(i, minerConns[i])
+	}
+	wg.Wait()
+	return loads
+}
+
+func txnProofReply(chain *blockchain.BlockChain, txid []byte) GetTxnProofReply {
+	txn, blockHash, proof := chain.TxnProof(txid)
+	if txn == nil {
+		return GetTxnProofReply{Found: false}
+	}
+	return GetTxnProofReply{Found: true, Txn: *txn, BlockHash: blockHash, Proof: proof}
+}
+
+func blockBodyReply(chain *blockchain.BlockChain, hash []byte) GetBlockBodyReply {
+	body := chain.GetBody(hash)
+	if body == nil {
+		return GetBlockBodyReply{Found: false}
+	}
+	return GetBlockBodyReply{Found: true, Body: *body}
+}
+
 func (c *Coord) PrintChain() {
 	votes, txns := c.Blockchain.VotingStatus()
 	fv, err := os.Create("./votes.txt")
 	util.CheckErr(err, "Unable to create votes.txt")
 	defer fv.Close()
 	for idx, _ := range votes {
-		fv.WriteString(fmt.Sprintf("%s,%d\n", c.Candidates[idx].CandidateData.CandidateName, votes[idx]))
+		fv.WriteString(fmt.Sprintf("%s,%d\n", c.Candidates[idx].CandidateData.FullName(), votes[idx]))
 	}
 	fv.Sync()
 
@@ -395,9 +1412,12 @@ type CoordAPIMiner struct {
 }
 
 // Download provides necessary data about the system for new node. should be called before Register
-func (api *CoordAPIMiner) Download(args DownloadArgs, reply *DownloadReply) error {
+func (api *CoordAPIMiner) Download(args DownloadArgs, reply *DownloadReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIMiner.Download", &err)()
 	// prepare reply data
-	encodedBlockchain, lastHash := api.c.Blockchain.Encode()
+	lastHash := api.c.Blockchain.GetLastHash()
+	height := api.c.Blockchain.Get(lastHash).BlockNum
 	var peerAddrList []string
 	api.c.nlMu.Lock()
 	nodeList := api.c.NodeList[:]
@@ -410,26 +1430,75 @@ func (api *CoordAPIMiner) Download(args DownloadArgs, reply *DownloadReply) erro
 		candidates = append(candidates, cand.Encode())
 	}
 
+	genesis := api.c.Blockchain.GenesisHash()
 	*reply = DownloadReply{
-		BlockChain:   encodedBlockchain,
-		LastHash:     lastHash,
-		Candidates:   candidates,
-		PeerAddrList: peerAddrList,
+		LastHash:      lastHash,
+		Height:        height,
+		Candidates:    candidates,
+		PeerAddrList:  peerAddrList,
+		ElectionEnd:   api.c.ElectionEnd,
+		Genesis:       genesis,
+		GenesisHeader: api.c.Blockchain.GetHeader(genesis),
+		FinalityDepth: api.c.Blockchain.RequiredConfirmations(),
+		Difficulty:    api.c.Blockchain.BlockDifficulty(),
+		MinerKeys:     api.c.registeredMiners(),
+		SealingKey:    api.c.Blockchain.SealingKey,
+		Registration:  api.c.Blockchain.Registration,
 	}
 	return nil
 }
 
+// GetBlocks returns one chunk of the chain: the blocks with block numbers in [FromHeight, ToHeight], capped
+// at ChainChunkHeights heights per call
+func (api *CoordAPIMiner) GetBlocks(args GetBlocksArgs, reply *GetBlocksReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIMiner.GetBlocks", &err)()
+	*reply, err = blocksReply(api.c.Blockchain, args)
+	return err
+}
+
 // Register registers a new miner in the system. should be called after Download
-func (api *CoordAPIMiner) Register(args RegisterArgs, reply *RegisterReply) error {
+func (api *CoordAPIMiner) Register(args RegisterArgs, reply *RegisterReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIMiner.Register", &err)()
+	if err = api.c.checkClockSkew(args, time.Now()); err != nil {
+		log.Printf("[WARN] Rejected registration of %s: %v\n", args.Info.MinerId, err)
+		return err
+	}
+
 	api.c.nlMu.Lock()
 	defer api.c.nlMu.Unlock()
+	returning, err := api.c.checkIdentity(args)
+	if err != nil {
+		log.Printf("[WARN] Rejected registration of %s: %v\n", args.Info.MinerId, err)
+		return err
+	}
 
-	// add new miner to list
+	// add new miner to list, replacing the stale entry of a returning miner
 	newNodeInfo := NodeInfo{Property: args.Info}
+	idx := -1
+	for i, info := range api.c.NodeList {
+		if info.Property.MinerId == args.Info.MinerId {
+			idx = i
+			break
+		}
+	}
+	if idx >= 0 {
+		returning = true
+		stale := api.c.NodeList[idx].Property
+		if stale.GossipAddr != args.Info.GossipAddr {
+			api.c.gossip.RemovePeer(stale.GossipAddr)
+		}
+		if api.c.MinerConns[idx] != nil {
+			api.c.MinerConns[idx].Close()
+		}
+		api.c.NodeList = append(api.c.NodeList[:idx], api.c.NodeList[idx+1:]...)
+		api.c.MinerConns = append(api.c.MinerConns[:idx], api.c.MinerConns[idx+1:]...)
+	}
 	api.c.NodeList = append(api.c.NodeList, newNodeInfo)
 	// write to disk first
 	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(newNodeInfo)
+	err = gob.NewEncoder(&buf).Encode(newNodeInfo)
 	if err != nil {
 		log.Println("[WARN] node info encode error")
 	}
@@ -439,20 +1508,29 @@ func (api *CoordAPIMiner) Register(args RegisterArgs, reply *RegisterReply) erro
 	api.c.NotifyMiners() // this will not notify current miner as conn not established
 
 	// add rpc connection
-	minerConn, err := rpc.Dial("tcp", newNodeInfo.Property.CoordListenAddr)
+	minerConn, err := util.DialRPC(newNodeInfo.Property.CoordListenAddr)
 	if err != nil {
 		// silently digest error
 		log.Println("[WARN] cannot connect to miner at", newNodeInfo.Property.CoordListenAddr)
 	}
 	api.c.MinerConns = append(api.c.MinerConns, minerConn)
 
-	gossip.AddPeer(newNodeInfo.Property.GossipAddr)
-	err = fchecker.NewRemote(newNodeInfo.Property.AckAddr)
+	api.c.gossip.AddPeer(newNodeInfo.Property.GossipAddr)
+	err = api.c.fcheck.NewRemote(newNodeInfo.Property.AckAddr)
 	if err != nil {
 		log.Println("[WARN] fcheck is unable to connect to miner at", newNodeInfo.Property.AckAddr)
 	}
-	log.Printf("[INFO] New miner joined: %s (g: %s, co: %s, m: %s, cl:%s) (%d total)", args.Info.MinerId,
+	joined := "New miner joined"
+	if returning {
+		joined = "Miner rejoined"
+	}
+	log.Printf("[INFO] %s: %s (g: %s, co: %s, m: %s, cl:%s) (%d total)", joined, args.Info.MinerId,
 		args.Info.GossipAddr, args.Info.CoordListenAddr, args.Info.MinerMinerAddr, args.Info.ClientListenAddr, len(api.c.NodeList))
+	api.c.Events.Publish(events.Event{
+		Topic:     events.MinerJoined,
+		MinerID:   args.Info.MinerId,
+		MinerAddr: args.Info.ClientListenAddr,
+	})
 
 	// prepare reply data
 	var peerAddrList []string
@@ -461,50 +1539,467 @@ func (api *CoordAPIMiner) Register(args RegisterArgs, reply *RegisterReply) erro
 		peerAddrList = append(peerAddrList, info.Property.MinerMinerAddr)
 		peerGossipAddrList = append(peerGossipAddrList, info.Property.GossipAddr)
 	}
+	lastHash := api.c.Blockchain.GetLastHash()
 	*reply = RegisterReply{
 		PeerAddrList:       peerAddrList,
 		PeerGossipAddrList: peerGossipAddrList,
+		Returning:          returning,
+		LastHash:           lastHash,
+		Height:             api.c.Blockchain.Get(lastHash).BlockNum,
+		MinerKeys:          api.c.registeredMiners(),
+		CoordTime:          time.Now(),
 	}
 
 	return nil
 }
 
+// checkIdentity verifies the key a miner registers with and remembers it for the miner's ID. A miner ID
+// registered with a saved key can only be reclaimed with the same key, while a miner with a new key every run
+// replaces its key when it comes back. returning is whether the key is known. Called with nlMu held
+func (c *Coord) checkIdentity(args RegisterArgs) (returning bool, err error) {
+	dbKey := util.DBKeyWithPrefix(MinerKeyPrefix, []byte(args.Info.MinerId))
+	pinKey := util.DBKeyWithPrefix(MinerPinPrefix, []byte(args.Info.MinerId))
+	var knownKey []byte
+	if c.Storage.KeyExist(dbKey) {
+		if knownKey, err = c.Storage.Get(dbKey); err != nil {
+			return false, err
+		}
+	}
+	pinned := c.Storage.KeyExist(pinKey)
+	if len(args.PubKey) == 0 {
+		if knownKey != nil {
+			return false, ErrIdentityMismatch
+		}
+		return false, nil
+	}
+	if !verifyRegistration(args.PubKey, args.Info, args.Signature) {
+		return false, ErrInvalidRegistration
+	}
+	returning = bytes.Equal(knownKey, args.PubKey)
+	if knownKey != nil && !returning {
+		if pinned {
+			return false, ErrIdentityMismatch
+		}
+		log.Printf("[INFO] Miner %s registered with a new key\n", args.Info.MinerId)
+	}
+	if !returning {
+		if err = c.Storage.Put(dbKey, args.PubKey); err != nil {
+			return false, err
+		}
+	}
+	if args.Persistent && !pinned {
+		err = c.Storage.Put(pinKey, []byte{1})
+	}
+	return returning, err
+}
+
+// minerKey returns the key a miner ID registered with, false if it never registered. Coord rejects blocks of
+// other miners and blocks not signed with the key
+func (c *Coord) minerKey(minerID string) ([]byte, bool) {
+	key, err := c.Storage.Get(util.DBKeyWithPrefix(MinerKeyPrefix, []byte(minerID)))
+	return key, err == nil
+}
+
+// registeredMiners returns the key of every miner that ever registered
+func (c *Coord) registeredMiners() map[string][]byte {
+	keys := make(map[string][]byte)
+	iter := c.Storage.NewIterator(MinerKeyPrefix)
+	for iter.Next() {
+		if key, err := iter.Value(); err == nil {
+			keys[strings.TrimPrefix(string(iter.Key()), MinerKeyPrefix)] = key
+		}
+	}
+	iter.Close()
+	return keys
+}
+
 // ----- APIs for client -----
 
 type CoordAPIClient struct {
 	c *Coord
 }
 
-func (api *CoordAPIClient) GetCandidates(args GetCandidatesArgs, reply *GetCandidatesReply) error {
+func (api *CoordAPIClient) GetCandidates(args GetCandidatesArgs, reply *GetCandidatesReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetCandidates", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	version := api.c.candidateListVersion()
+	if args.KnownVersion != 0 && args.KnownVersion == version {
+		*reply = GetCandidatesReply{Version: version, Unchanged: true}
+		return nil
+	}
 	var candidates [][]byte
 	for _, cand := range api.c.Candidates {
 		candidates = append(candidates, cand.Encode())
 	}
-	*reply = GetCandidatesReply{Candidates: candidates}
+	*reply = GetCandidatesReply{
+		Version:       version,
+		Candidates:    candidates,
+		ElectionEnd:   api.c.ElectionEnd,
+		Genesis:       api.c.Blockchain.GenesisHash(),
+		FinalityDepth: api.c.Blockchain.RequiredConfirmations(),
+		Difficulty:    api.c.Blockchain.BlockDifficulty(),
+		SealingKey:    api.c.Blockchain.SealingKey,
+		Registration:  api.c.Blockchain.Registration,
+		Info:          api.c.candidateInfo(),
+	}
 	return nil
 }
 
-func (api *CoordAPIClient) GetMinerList(args GetMinerListArgs, reply *GetMinerListReply) error {
+// GetMinerList returns the client addresses of the registered miners that are not quarantined and match the
+// filters in args, a page of them if asked, optionally with their load and details, and the miner assigned to
+// the client in round-robin mode
+func (api *CoordAPIClient) GetMinerList(args GetMinerListArgs, reply *GetMinerListReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetMinerList", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if err := api.c.limitClient(args.rpcCaller, args.ClientID); err != nil {
+		return err
+	}
+	if api.c.ReplicaOf != "" {
+		return ErrReadReplica
+	}
 	api.c.nlMu.Lock()
-	defer api.c.nlMu.Unlock()
+	var nodeList []NodeInfo
+	var minerConns []*rpc.Client
+	for i, info := range api.c.NodeList {
+		if api.c.Peers.Quarantined(info.Property.MinerId) {
+			continue
+		}
+		if args.Label != "" && info.Property.Label != args.Label {
+			continue
+		}
+		nodeList = append(nodeList, info)
+		minerConns = append(minerConns, api.c.MinerConns[i])
+	}
+	api.c.nlMu.Unlock()
 
-	var minerAddrList []string
-	for _, info := range api.c.NodeList {
-		minerAddrList = append(minerAddrList, info.Property.ClientListenAddr)
+	// heights are only known by asking the miners
+	var loads []MinerLoad
+	if args.WithLoad || args.Detailed || args.MinHeight > 0 {
+		loads = api.c.minerLoads(nodeList, minerConns)
+	}
+	var matched []int
+	for i := range nodeList {
+		if args.MinHeight == 0 || loads[i].Height >= int(args.MinHeight) {
+			matched = append(matched, i)
+		}
+	}
+	*reply = GetMinerListReply{Total: len(matched)}
+	if args.Offset > 0 {
+		if args.Offset > len(matched) {
+			args.Offset = len(matched)
+		}
+		matched = matched[args.Offset:]
+	}
+	if args.Limit > 0 && len(matched) > args.Limit {
+		matched = matched[:args.Limit]
 	}
 
-	*reply = GetMinerListReply{MinerAddrList: minerAddrList}
+	for _, i := range matched {
+		info := nodeList[i].Property
+		reply.MinerAddrList = append(reply.MinerAddrList, info.ClientListenAddr)
+		if args.WithLoad {
+			reply.Loads = append(reply.Loads, loads[i])
+		}
+		if args.Detailed {
+			reply.Miners = append(reply.Miners, MinerRecord{
+				MinerID:       info.MinerId,
+				Addr:          info.ClientListenAddr,
+				Label:         info.Label,
+				Height:        loads[i].Height,
+				LastHeartbeat: api.c.fcheck.LastAck(info.AckAddr),
+				ClockOffset:   api.c.fcheck.ClockOffset(info.AckAddr),
+			})
+		}
+	}
+	if api.c.AssignMode == AssignRoundRobin && len(reply.MinerAddrList) > 0 {
+		api.c.nlMu.Lock()
+		reply.Assigned = reply.MinerAddrList[api.c.nextMiner%len(reply.MinerAddrList)]
+		api.c.nextMiner = (api.c.nextMiner + 1) % len(reply.MinerAddrList)
+		api.c.nlMu.Unlock()
+	}
 	return nil
 }
 
 // QueryTxn queries a transaction in the system and returns the number of blocks that confirm it.
-func (api *CoordAPIClient) QueryTxn(args QueryTxnArgs, reply *QueryTxnReply) error {
-	*reply = QueryTxnReply{NumConfirmed: api.c.Blockchain.TxnStatus(args.TxID)}
+func (api *CoordAPIClient) QueryTxn(args QueryTxnArgs, reply *QueryTxnReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryTxn", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if err := api.c.limitClient(args.rpcCaller, args.ClientID); err != nil {
+		return err
+	}
+	defer api.c.metrics.queryTxnLatency.ObserveSince(time.Now())
+	numConfirmed := api.c.Blockchain.TxnStatus(args.TxID)
+	*reply = QueryTxnReply{NumConfirmed: numConfirmed, Finalized: numConfirmed >= api.c.Blockchain.RequiredConfirmations()}
+	return nil
+}
+
+// QueryTxns is QueryTxn for many transactions at once, for clients tracking many ballots. It counts as a
+// single call toward the rate limit of the client.
+func (api *CoordAPIClient) QueryTxns(args QueryTxnsArgs, reply *QueryTxnsReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryTxns", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if len(args.TxIDs) > MaxQueryTxns {
+		return ErrTooManyTxns
+	}
+	if err := api.c.limitClient(args.rpcCaller, args.ClientID); err != nil {
+		return err
+	}
+	defer api.c.metrics.queryTxnsLatency.ObserveSince(time.Now())
+	required := api.c.Blockchain.RequiredConfirmations()
+	statuses := make([]TxnStatus, 0, len(args.TxIDs))
+	for _, txid := range args.TxIDs {
+		numConfirmed := api.c.Blockchain.TxnStatus(txid)
+		statuses = append(statuses, TxnStatus{NumConfirmed: numConfirmed, Finalized: numConfirmed >= required})
+	}
+	*reply = QueryTxnsReply{Statuses: statuses}
+	return nil
+}
+
+func (api *CoordAPIClient) QueryResults(args QueryResultsArgs, reply *QueryResultsReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryResults", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if err := api.c.limitClient(args.rpcCaller, args.ClientID); err != nil {
+		return err
+	}
+	defer api.c.metrics.queryResultsLatency.ObserveSince(time.Now())
+	*reply, err = resultsReply(api.c.Blockchain, args.At, args.Strict)
+	return err
+}
+
+// QueryTurnout counts the ballots and voters on the longest chain, as of its tip and as of finalization
+func (api *CoordAPIClient) QueryTurnout(args QueryTurnoutArgs, reply *QueryTurnoutReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryTurnout", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if err := api.c.limitClient(args.rpcCaller, args.ClientID); err != nil {
+		return err
+	}
+	*reply = turnoutReply(api.c.Blockchain, api.c.RegisteredVoters)
+	return nil
+}
+
+// QueryTxnsByVoter returns all transactions on the longest chain signed by the voter with the given public key hash
+func (api *CoordAPIClient) QueryTxnsByVoter(args QueryTxnsByVoterArgs, reply *QueryTxnsByVoterReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryTxnsByVoter", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	txns, blocks, numConfirmed := api.c.Blockchain.FindTxnsByVoter(args.PubKeyHash)
+	var voterTxns []VoterTxn
+	for idx, txn := range txns {
+		voterTxns = append(voterTxns, VoterTxn{
+			Txn:          *txn,
+			BlockHash:    blocks[idx].Hash,
+			BlockNum:     blocks[idx].BlockNum,
+			NumConfirmed: numConfirmed[idx],
+		})
+	}
+	*reply = QueryTxnsByVoterReply{Txns: voterTxns}
 	return nil
 }
 
-func (api *CoordAPIClient) QueryResults(_ QueryResultsArgs, reply *QueryResultsReply) error {
-	votes, _ := api.c.Blockchain.VotingStatus()
-	*reply = QueryResultsReply{Votes: votes}
+// QueryTxnsByCandidate returns a page of the ballots counted toward a candidate on the longest chain from the
+// candidate index, for recounts. Ballots mined in the last blocks are included, see QueryTxn for their confirmations
+func (api *CoordAPIClient) QueryTxnsByCandidate(args QueryTxnsByCandidateArgs, reply *QueryTxnsByCandidateReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryTxnsByCandidate", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	listed := false
+	for _, cand := range api.c.Candidates {
+		listed = listed || (cand.CandidateData.Race == args.Race && cand.CandidateData.CandidateName == args.Candidate)
+	}
+	if !listed {
+		return ErrUnknownCandidate
+	}
+	txids, err := api.c.candTxns.Lookup(args.Race, args.Candidate)
+	if err != nil {
+		return err
+	}
+	total := len(txids)
+	if args.Offset > 0 {
+		if args.Offset > len(txids) {
+			args.Offset = len(txids)
+		}
+		txids = txids[args.Offset:]
+	}
+	if args.Limit > 0 && args.Limit < len(txids) {
+		txids = txids[:args.Limit]
+	}
+
+	heights := make(map[string]uint32)
+	for _, txid := range txids {
+		heights[string(txid)] = 0
+	}
+	iter := api.c.Blockchain.NewIterator(api.c.Blockchain.GetLastHash())
+	for block, end := iter.Next(); !end; block, end = iter.Next() {
+		for _, txn := range block.Txns {
+			if _, ok := heights[string(txn.ID)]; ok {
+				heights[string(txn.ID)] = block.BlockNum
+			}
+		}
+	}
+	ballots := make([]CandidateBallot, len(txids))
+	for i, txid := range txids {
+		ballots[i] = CandidateBallot{TxID: txid, BlockNum: heights[string(txid)]}
+	}
+	*reply = QueryTxnsByCandidateReply{Ballots: ballots, Total: total}
+	return nil
+}
+
+// CheckVoterStatus returns the ballots of a student ID on the longest chain from the voter index. Only the
+// holder of a voter key may ask, and only learns about the ballots signed with that key, so the request cannot
+// link a student ID to ballots on the chain
+func (api *CoordAPIClient) CheckVoterStatus(args CheckVoterStatusArgs, reply *CheckVoterStatusReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.CheckVoterStatus", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if err = checkVoterStatusArgs(args, api.c.Blockchain.GenesisHash(), time.Now()); err != nil {
+		return err
+	}
+	ballots, err := api.c.voters.Lookup(args.StudentID)
+	if err != nil {
+		return err
+	}
+	voter := Identity.PublicKeyHash(args.PublicKey)
+	var own []VoterBallot
+	for _, ballot := range ballots {
+		if bytes.Equal(ballot.Voter, voter) {
+			own = append(own, ballot)
+		}
+	}
+	*reply = CheckVoterStatusReply{Ballots: own}
+	return nil
+}
+
+// GetHeaders returns the headers of the longest chain starting at FromHeight, for light clients
+func (api *CoordAPIClient) GetHeaders(args GetHeadersArgs, reply *GetHeadersReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetHeaders", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	*reply = GetHeadersReply{Headers: api.c.Blockchain.Headers(args.FromHeight)}
+	return nil
+}
+
+// GetBlockBody returns the transactions of a block, e.g. after syncing its header with GetHeaders
+func (api *CoordAPIClient) GetBlockBody(args GetBlockBodyArgs, reply *GetBlockBodyReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetBlockBody", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	*reply = blockBodyReply(api.c.Blockchain, args.Hash)
+	return nil
+}
+
+// GetTxnProof returns a transaction on the longest chain with the Merkle proof of its inclusion in its block
+func (api *CoordAPIClient) GetTxnProof(args GetTxnProofArgs, reply *GetTxnProofReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetTxnProof", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	*reply = txnProofReply(api.c.Blockchain, args.TxID)
+	return nil
+}
+
+// GetChainStats returns statistics of the longest chain: blocks, txns, block interval, forks and blocks by miner,
+// and the inclusion latency of txns
+func (api *CoordAPIClient) GetChainStats(_ GetChainStatsArgs, reply *GetChainStatsReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetChainStats", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	reply.Stats = api.c.Blockchain.Stats()
+	reply.Inclusion = api.c.inclusion.stats(time.Now())
+	return nil
+}
+
+// GetResultCertificate returns a signed certificate of the current tally, or of the tally at args.At
+func (api *CoordAPIClient) GetResultCertificate(args GetResultCertificateArgs, reply *GetResultCertificateReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetResultCertificate", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if api.c.ReplicaOf != "" {
+		return ErrReadReplica
+	}
+	cert, err := api.c.ResultCertificateAt(args.At)
+	if err != nil {
+		return err
+	}
+	*reply = GetResultCertificateReply{Certificate: *cert}
+	return nil
+}
+
+// ----- APIs for admin -----
+
+type CoordAPIAdmin struct {
+	c *Coord
+}
+
+// GetQuarantine returns the misbehavior scores of miners
+func (api *CoordAPIAdmin) GetQuarantine(args GetQuarantineArgs, reply *GetQuarantineReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIAdmin.GetQuarantine", &err)()
+	reply.Peers = api.c.Peers.List()
+	return nil
+}
+
+// ClearQuarantine forgets the score of a miner, letting it back into GetMinerList
+func (api *CoordAPIAdmin) ClearQuarantine(args ClearQuarantineArgs, reply *ClearQuarantineReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIAdmin.ClearQuarantine", &err)()
+	reply.Cleared = api.c.Peers.Clear(args.MinerID)
+	log.Printf("[INFO] Cleared the scores of %d miners\n", reply.Cleared)
+	return nil
+}
+
+// ReloadCandidates reads the candidates file again, handing changed statements to clients without a restart
+func (api *CoordAPIAdmin) ReloadCandidates(args ReloadCandidatesArgs, reply *ReloadCandidatesReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIAdmin.ReloadCandidates", &err)()
+	reply.Version, err = api.c.ReloadCandidates()
+	return err
+}
+
+// AuditVoters checks the voter index for voters with more ballots than their race allows
+func (api *CoordAPIAdmin) AuditVoters(args AuditVotersArgs, reply *AuditVotersReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIAdmin.AuditVoters", &err)()
+	maxVotes := make(map[string]uint8)
+	for _, cand := range api.c.Candidates {
+		maxVotes[cand.CandidateData.Race] = cand.CandidateData.MaxVotes
+	}
+	voters, ballots, overLimit, err := api.c.voters.audit(maxVotes)
+	if err != nil {
+		return err
+	}
+	*reply = AuditVotersReply{Voters: voters, Ballots: ballots, OverLimit: overLimit}
 	return nil
 }
@@ -0,0 +1,84 @@
+package blockvote
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrDraining is returned by the client APIs while coord is draining. GetStandby tells clients where to go next.
+var ErrDraining = errors.New("coord is draining for maintenance")
+
+const (
+	DefaultDrainGrace = 5 * time.Second  // time clients have to ask for the standby before coord stops
+	DrainTimeout      = 30 * time.Second // longest wait for in-flight requests while draining
+)
+
+// isDraining checks whether Drain was called
+func (c *Coord) isDraining() bool {
+	select {
+	case <-c.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drain takes coord out of service for maintenance: client requests are refused with ErrDraining and
+// GetStandby hands out standby, if any. After grace, coord waits for the requests in flight, writes a snapshot
+// of the database to snapshot and stops, which makes Start return. It returns where the snapshot is written.
+func (c *Coord) Drain(standby string, snapshot string, grace time.Duration) (string, error) {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if c.isDraining() {
+		return "", errors.New("coord is already draining")
+	}
+	if snapshot == "" {
+		snapshot = filepath.Join(c.BackupDir, "drain-"+time.Now().Format("20060102-150405")+".bak")
+	}
+	if dir := filepath.Dir(snapshot); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+	if grace <= 0 {
+		grace = DefaultDrainGrace
+	}
+	c.standby = standby
+	close(c.draining)
+	log.Printf("[INFO] Draining, standby coord: %q\n", standby)
+
+	go func() {
+		time.Sleep(grace)
+		if !c.rpcGuard.Idle(DrainTimeout) {
+			log.Println("[WARN] Requests still in flight after", DrainTimeout)
+		}
+		if err := c.Storage.BackupToFile(snapshot); err != nil {
+			log.Println("[ERROR] Unable to snapshot the database while draining:", err)
+		} else {
+			log.Println("[INFO] Database snapshot written to", snapshot)
+		}
+		c.Stop()
+	}()
+	return snapshot, nil
+}
+
+// GetStandby tells clients whether coord is draining and which coord to use instead
+func (api *CoordAPIClient) GetStandby(_ GetStandbyArgs, reply *GetStandbyReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.GetStandby", &err)()
+	api.c.drainMu.Lock()
+	defer api.c.drainMu.Unlock()
+	*reply = GetStandbyReply{Draining: api.c.isDraining(), Standby: api.c.standby}
+	return nil
+}
+
+// Drain puts coord into draining mode, see Coord.Drain
+func (api *CoordAPIAdmin) Drain(args DrainArgs, reply *DrainReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIAdmin.Drain", &err)()
+	reply.Snapshot, err = api.c.Drain(args.Standby, args.Snapshot, args.Grace)
+	return err
+}
@@ -0,0 +1,169 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/json"
+	"time"
+)
+
+type (
+	DumpArgs struct {
+	}
+
+	DumpReply struct {
+		RPCStatus
+		JSON []byte // a CoordDump or a MinerDump, indented
+	}
+)
+
+// CoordDump is a snapshot of coord's internal state, for diagnosing a live deployment
+type CoordDump struct {
+	Health               HealthReply
+	Time                 time.Time
+	Chain                blockchain.ChainStats
+	ForkTips             []blockchain.ForkTip
+	Miners               []MinerRecord // registered miners, quarantined ones included. Height is not asked for
+	Quarantine           []PeerScore
+	ReorgSeq             uint64 // seq of the last fork switch, see WaitReorg
+	ReorgsKept           int    // fork switches WaitReorg still reports
+	VoterIndexSize       int    // student IDs in the voter index
+	CandidateIndexSize   int    // candidates with ballots in the candidate index
+	CandidateListVersion uint64
+	Standby              string // coord handed to clients while draining. empty if not draining
+	NextMiner            int    // next miner to assign in round-robin mode
+}
+
+// MinerDump is a snapshot of a miner's internal state, for diagnosing a live deployment
+type MinerDump struct {
+	Health       HealthReply
+	Time         time.Time
+	Chain        blockchain.ChainStats
+	ForkTips     []blockchain.ForkTip
+	Pool         []PooledTxn // pending txns in arrival order
+	ReceivedTxns int         // txns the miner has seen, pending or not
+	Orphans      int         // blocks waiting for their parent
+	Templates    int         // block templates handed to external solvers and still kept
+	KnownMiners  int         // miners coord sent keys of. -1 if any miner is accepted
+	Quarantine   []PeerScore
+	Throttle     MiningThrottle
+}
+
+// PooledTxn is a pending txn in a MinerDump. Voters are only shown by the hash of their public key
+type PooledTxn struct {
+	TxID       []byte
+	PubKeyHash []byte
+	Race       string
+	Type       string
+	Sealed     bool
+}
+
+// Dump returns a snapshot of coord's internal state as JSON
+func (api *CoordAPIAdmin) Dump(_ DumpArgs, reply *DumpReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIAdmin.Dump", &err)()
+	reply.JSON, err = json.MarshalIndent(api.c.dump(), "", "  ")
+	return err
+}
+
+// Dump returns a snapshot of the miner's internal state as JSON
+func (api *MinerAPIAdmin) Dump(_ DumpArgs, reply *DumpReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.Dump", &err)()
+	dump, err := api.m.dump()
+	if err != nil {
+		return err
+	}
+	reply.JSON, err = json.MarshalIndent(dump, "", "  ")
+	return err
+}
+
+func (c *Coord) dump() *CoordDump {
+	dump := &CoordDump{
+		Health:               c.health(),
+		Time:                 time.Now(),
+		Quarantine:           c.Peers.List(),
+		CandidateListVersion: c.candidateListVersion(),
+	}
+	dump.ReorgSeq, dump.ReorgsKept = c.reorgs.last()
+	c.drainMu.Lock()
+	if c.isDraining() {
+		dump.Standby = c.standby
+	}
+	c.drainMu.Unlock()
+	c.nlMu.Lock()
+	for _, info := range c.NodeList {
+		dump.Miners = append(dump.Miners, MinerRecord{
+			MinerID:       info.Property.MinerId,
+			Addr:          info.Property.ClientListenAddr,
+			Label:         info.Property.Label,
+			Height:        -1,
+			LastHeartbeat: c.fcheck.LastAck(info.Property.AckAddr),
+			ClockOffset:   c.fcheck.ClockOffset(info.Property.AckAddr),
+		})
+	}
+	dump.NextMiner = c.nextMiner
+	c.nlMu.Unlock()
+	if !dump.Health.Ready && dump.Health.Reason == "starting" {
+		return dump
+	}
+	dump.Chain = c.Blockchain.Stats()
+	dump.ForkTips = dumpForkTips(c.Blockchain)
+	if c.voters != nil {
+		dump.VoterIndexSize = countKeys(c.Storage, VoterKeyPrefix)
+	}
+	if c.candTxns != nil {
+		dump.CandidateIndexSize = countKeys(c.Storage, CandidateTxnsKeyPrefix)
+	}
+	return dump
+}
+
+func (m *Miner) dump() (*MinerDump, error) {
+	dump := &MinerDump{
+		Health:      m.health(),
+		Time:        time.Now(),
+		Quarantine:  m.Peers.List(),
+		Throttle:    m.throttle(),
+		KnownMiners: m.knownMiners.count(),
+	}
+	if m.Blockchain != nil {
+		dump.Chain = m.Blockchain.Stats()
+		dump.ForkTips = dumpForkTips(m.Blockchain)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, txn := range m.MemoryPool.PendingTxns {
+		pooled := PooledTxn{TxID: txn.ID, PubKeyHash: Identity.PublicKeyHash(txn.PublicKey)}
+		if txn.Data != nil {
+			pooled.Race, pooled.Type, pooled.Sealed = txn.Data.Race, txn.Data.Type, len(txn.Data.Sealed) > 0
+		}
+		dump.Pool = append(dump.Pool, pooled)
+	}
+	dump.ReceivedTxns = len(m.ReceivedTxns)
+	if m.orphans != nil {
+		dump.Orphans = m.orphans.len()
+	}
+	dump.Templates = len(m.templates.ids)
+	return dump, nil
+}
+
+// dumpForkTips lists the fork tips of chain, or none if they cannot be read
+func dumpForkTips(chain *blockchain.BlockChain) []blockchain.ForkTip {
+	tips, err := chain.ForkTips()
+	if err != nil {
+		return nil
+	}
+	return tips
+}
+
+// countKeys counts the keys of db with the given prefix
+func countKeys(db *util.Database, prefix string) int {
+	n := 0
+	iter := db.NewIterator(prefix)
+	defer iter.Close()
+	for iter.Next() {
+		n++
+	}
+	return n
+}
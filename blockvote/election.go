@@ -0,0 +1,11 @@
+package blockvote
+
+// Scoped returns the RPC service, or service method, name of an election. The services of every election a
+// process hosts are registered as "<electionID>.<Service>", so several elections can share coord's listen
+// addresses. The default election (empty ID) keeps the plain names.
+func Scoped(electionID string, name string) string {
+	if electionID == "" {
+		return name
+	}
+	return electionID + "." + name
+}
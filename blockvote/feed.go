@@ -0,0 +1,133 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/events"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// FeedHeartbeatInterval is how often an idle feed sends a comment to keep the connection open
+const FeedHeartbeatInterval = 15 * time.Second
+
+// BlockHeader is a block without its transactions, as sent on the live feed
+type BlockHeader struct {
+	BlockNum  uint32
+	Hash      string
+	PrevHash  string
+	Timestamp int64
+	MinerID   string
+	NumTxns   int
+}
+
+// TallyUpdate is the vote count of each candidate on the longest chain, as sent on the live feed
+type TallyUpdate struct {
+	Votes    []uint
+	Races    []RaceTally
+	Height   uint32
+	LastHash string
+}
+
+// serveFeed serves a Server-Sent Events stream at /feed. Each client first gets the current
+// tally, then a "block" event for every new block on the longest chain and a "tally"
+// event whenever the longest chain changes. The tally of a tip is counted once for all clients.
+func (c *Coord) serveFeed(listenAddr string) error {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	c.listeners = append(c.listeners, listener)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", c.handleFeed)
+	go http.Serve(listener, mux)
+	return nil
+}
+
+func (c *Coord) handleFeed(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sub := c.Events.Subscribe(64, events.NewBlock, events.ForkSwitch)
+	defer c.Events.Unsubscribe(sub)
+
+	tally := c.tally()
+	if writeFeedEvent(w, "tally", tally) != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(FeedHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case event := <-sub:
+			if event.Topic == events.NewBlock {
+				if !event.OnLongestChain {
+					continue
+				}
+				err = writeFeedEvent(w, "block", BlockHeader{
+					BlockNum:  event.Block.BlockNum,
+					Hash:      fmt.Sprintf("%x", event.Block.Hash),
+					PrevHash:  fmt.Sprintf("%x", event.Block.PrevHash),
+					Timestamp: event.Block.Timestamp,
+					MinerID:   event.Block.MinerID,
+					NumTxns:   len(event.Block.Txns),
+				})
+			}
+			if err == nil && len(sub) == 0 {
+				// a burst of events is followed by one tally, and only if the tip moved
+				if latest := c.tally(); latest.LastHash != tally.LastHash {
+					tally = latest
+					err = writeFeedEvent(w, "tally", tally)
+				}
+			}
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		case <-c.quit:
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// tally returns the tally of the longest chain, counted once per tip
+func (c *Coord) tally() TallyUpdate {
+	lastHash := c.Blockchain.GetLastHash()
+	c.feedMu.Lock()
+	defer c.feedMu.Unlock()
+	if c.feedTally.LastHash == fmt.Sprintf("%x", lastHash) {
+		return c.feedTally
+	}
+	votes, races := c.results(lastHash, true)
+	c.feedTally = TallyUpdate{
+		Votes:    votes,
+		Races:    races,
+		Height:   c.Blockchain.GetHeader(lastHash).BlockNum,
+		LastHash: fmt.Sprintf("%x", lastHash),
+	}
+	return c.feedTally
+}
+
+func writeFeedEvent(w http.ResponseWriter, name string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
@@ -0,0 +1,131 @@
+package blockvote
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+)
+
+// Version of the node, reported by Health. Set at build time with
+// -ldflags "-X cs.ubc.ca/cpsc416/BlockVote/blockvote.Version=v1.2.0"
+var Version = "dev"
+
+// roles reported by Ping and Health
+const (
+	RoleCoord   = "coord"
+	RoleReplica = "replica"
+	RoleMiner   = "miner"
+)
+
+type (
+	PingArgs struct {
+	}
+
+	PingReply struct {
+		RPCStatus
+		Role string
+	}
+
+	HealthArgs struct {
+	}
+
+	HealthReply struct {
+		RPCStatus
+		Role       string
+		Version    string
+		ID         string // miner ID. empty for coord
+		ElectionID string
+		Height     uint32 // block number of the tip of the longest chain. 0 before the chain is loaded
+		LastHash   []byte // nil before the chain is loaded
+		Ready      bool   // the node takes requests: started, caught up and not draining
+		Reason     string // why the node is not ready. empty if Ready
+	}
+)
+
+// Ping answers without taking any lock, to tell that the node is up
+func (api *CoordAPIClient) Ping(_ PingArgs, reply *PingReply) error {
+	reply.Role = api.c.role()
+	return nil
+}
+
+// Health reports the role, version, chain height and readiness of coord
+func (api *CoordAPIClient) Health(_ HealthArgs, reply *HealthReply) error {
+	*reply = api.c.health()
+	return nil
+}
+
+// Ping answers without taking any lock, to tell that the node is up
+func (api *MinerAPIClient) Ping(_ PingArgs, reply *PingReply) error {
+	reply.Role = RoleMiner
+	return nil
+}
+
+// Health reports the role, version, chain height and readiness of the miner
+func (api *MinerAPIClient) Health(_ HealthArgs, reply *HealthReply) error {
+	*reply = api.m.health()
+	return nil
+}
+
+func (c *Coord) role() string {
+	if c.ReplicaOf != "" {
+		return RoleReplica
+	}
+	return RoleCoord
+}
+
+func (c *Coord) health() HealthReply {
+	health := HealthReply{Role: c.role(), Version: Version, ElectionID: c.ElectionID}
+	select {
+	case <-c.ready:
+		health.LastHash = c.Blockchain.GetLastHash()
+		health.Height = c.Blockchain.GetHeader(health.LastHash).BlockNum
+		if c.isDraining() {
+			health.Reason = "draining"
+		}
+	default:
+		health.Reason = "starting"
+	}
+	health.Ready = health.Reason == ""
+	return health
+}
+
+func (m *Miner) health() HealthReply {
+	health := HealthReply{Role: RoleMiner, Version: Version, ID: m.Info.MinerId, ElectionID: m.ElectionID}
+	if busy := m.syncing(); busy != nil {
+		health.Reason = busy.Reason
+	} else {
+		health.LastHash = m.Blockchain.GetLastHash()
+		health.Height = m.Blockchain.GetHeader(health.LastHash).BlockNum
+	}
+	health.Ready = health.Reason == ""
+	return health
+}
+
+// serveHealth serves probes for orchestration in the background: /healthz answers 200 as long as the node can
+// report its health, and /readyz answers 503 until the node is ready. Both write the health as JSON. A stuck
+// node fails /healthz by not answering in time. The node closes the listener it returns when it stops.
+func serveHealth(listenAddr string, health func() HealthReply) (net.Listener, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, errors.New("cannot listen at " + listenAddr)
+	}
+	probe := func(needReady bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			reply := health()
+			w.Header().Set("Content-Type", "application/json")
+			if needReady && !reply.Ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			if err := json.NewEncoder(w).Encode(reply); err != nil {
+				log.Println("[WARN] Unable to write health:", err)
+			}
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", probe(false))
+	mux.HandleFunc("/readyz", probe(true))
+	go http.Serve(listener, mux)
+	return listener, nil
+}
@@ -0,0 +1,170 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// The tally of a block on the longest chain is read from the chain's tally index (see blockchain.HeightTally).
+// Coord also keeps a tally snapshot of the blocks it stores off the longest chain: the votes of each candidate on
+// the chain ending at the block, counted from the tally of its parent. Snapshots are keyed by block hash, so
+// blocks of abandoned forks keep theirs and a fork switch needs no update, and are pruned with the blocks of
+// abandoned forks. Blocks that had a fork switch move them off the longest chain get theirs when first queried.
+
+type (
+	QueryResultsHistoryArgs struct {
+		FromHeight uint32
+		ToHeight   uint32 // the tip of the longest chain if 0
+	}
+
+	QueryResultsHistoryReply struct {
+		RPCStatus
+		Points []TallyPoint // one per block on the longest chain, oldest first
+	}
+)
+
+// TallyPoint is the tally of the longest chain as of one block
+type TallyPoint struct {
+	Height    uint32
+	Hash      []byte
+	Timestamp int64  // of the block, in unix seconds
+	Votes     []uint // votes of each candidate, in the order of GetCandidates. every ballot up to the block counts
+}
+
+// ErrInvalidRange is returned by QueryResultsHistory when FromHeight is above ToHeight
+var ErrInvalidRange = errors.New("FromHeight is above ToHeight")
+
+// recordTally stores the tally snapshot of a block new to coord's chain, unless it is in the tally index
+func (c *Coord) recordTally(block *blockchain.Block) {
+	if _, ok := c.Blockchain.TallyAt(block.Hash, 0); ok {
+		return
+	}
+	if _, err := c.tallyAt(block.Hash); err != nil {
+		log.Printf("[WARN] Unable to record the tally at block #%d (%x): %v\n", block.BlockNum, block.Hash[:5], err)
+	}
+}
+
+// tallyAt returns the votes of each candidate on the chain ending at a stored block: from the tally index if the
+// block is on the longest chain, else from its tally snapshot. A missing snapshot is counted from the nearest
+// ancestor with a tally, adding the votes of the blocks in between, and stored with those of the blocks in
+// between. On a sealed chain ballots only count once the key is released, so it is counted from the whole chain
+func (c *Coord) tallyAt(hash []byte) ([]uint, error) {
+	var blocks []*blockchain.Block // without a tally, newest first
+	var votes []uint
+	for cur := hash; votes == nil; {
+		stored, err := c.storedTally(cur)
+		if err != nil {
+			return nil, err
+		}
+		if stored != nil {
+			votes = stored
+			break
+		}
+		if len(c.Blockchain.SealingKey) > 0 {
+			votes, _ = c.Blockchain.VotingStatusAt(hash, 0)
+			return votes, c.putTally(hash, votes)
+		}
+		block := c.Blockchain.Get(cur)
+		if block == nil {
+			return nil, fmt.Errorf("block %x is not stored", cur)
+		}
+		blocks = append(blocks, block)
+		if block.BlockNum == 0 {
+			votes = make([]uint, len(c.Blockchain.Candidates))
+		}
+		cur = block.PrevHash
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		votes = append([]uint(nil), votes...)
+		c.Blockchain.CountVotes(votes, blocks[i].Txns)
+		if err := c.putTally(blocks[i].Hash, votes); err != nil {
+			return nil, err
+		}
+	}
+	return votes, nil
+}
+
+// storedTally returns the tally of a block from the tally index or its snapshot, nil if it has neither.
+// Snapshots counted for another list of candidates do not count
+func (c *Coord) storedTally(hash []byte) ([]uint, error) {
+	if indexed, ok := c.Blockchain.TallyAt(hash, 0); ok {
+		return indexed.Votes, nil
+	}
+	key := util.DBKeyWithPrefix(TallyKeyPrefix, hash)
+	if !c.Storage.KeyExist(key) {
+		return nil, nil
+	}
+	data, err := c.Storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var votes []uint
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&votes); err != nil {
+		return nil, err
+	}
+	if len(votes) != len(c.Blockchain.Candidates) {
+		return nil, nil
+	}
+	return votes, nil
+}
+
+// putTally stores the tally snapshot of a block
+func (c *Coord) putTally(hash []byte, votes []uint) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(votes); err != nil {
+		return err
+	}
+	return c.Storage.Put(util.DBKeyWithPrefix(TallyKeyPrefix, hash), buf.Bytes())
+}
+
+// resultsHistory returns the tally points of the longest chain between two heights, oldest first
+func (c *Coord) resultsHistory(fromHeight uint32, toHeight uint32) ([]TallyPoint, error) {
+	tip := c.Blockchain.GetHeader(c.Blockchain.GetLastHash())
+	if toHeight == 0 || toHeight > tip.BlockNum {
+		toHeight = tip.BlockNum
+	}
+	if fromHeight > toHeight {
+		return nil, ErrInvalidRange
+	}
+	points := make([]TallyPoint, int(toHeight-fromHeight)+1)
+	iter := c.Blockchain.NewIterator(tip.Hash)
+	for header, end := iter.NextHeader(); header.BlockNum >= fromHeight; header, end = iter.NextHeader() {
+		if header.BlockNum <= toHeight {
+			votes, err := c.tallyAt(header.Hash)
+			if err != nil {
+				return nil, err
+			}
+			points[header.BlockNum-fromHeight] = TallyPoint{
+				Height:    header.BlockNum,
+				Hash:      header.Hash,
+				Timestamp: header.Timestamp,
+				Votes:     votes,
+			}
+		}
+		if end {
+			break
+		}
+	}
+	return points, nil
+}
+
+// QueryResultsHistory returns the tally of the longest chain at every block between FromHeight and ToHeight,
+// so dashboards can plot how votes accumulated without replaying the chain
+func (api *CoordAPIClient) QueryResultsHistory(args QueryResultsHistoryArgs, reply *QueryResultsHistoryReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.QueryResultsHistory", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	points, err := api.c.resultsHistory(args.FromHeight, args.ToHeight)
+	if err != nil {
+		return err
+	}
+	*reply = QueryResultsHistoryReply{Points: points}
+	return nil
+}
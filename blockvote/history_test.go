@@ -0,0 +1,59 @@
+package blockvote
+
+import (
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func TestTallySnapshots(t *testing.T) {
+	c := NewCoord()
+	if err := c.Storage.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Storage.Close()
+	c.Blockchain = blockchain.NewBlockChain(c.Storage, nil)
+	c.Blockchain.KeyPrefixes = []string{TallyKeyPrefix}
+	if err := c.Blockchain.Init(blockchain.GenesisConfig{ElectionID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(-time.Hour)
+	mine := func(parent []byte, height uint32) blockchain.Block {
+		at = at.Add(time.Minute)
+		block := blockchain.Block{PrevHash: parent, BlockNum: height, Timestamp: at.Unix(), Txns: []*blockchain.Transaction{}, MinerID: "miner"}
+		blockchain.NewProof(&block).Run()
+		if result := c.Blockchain.Put(block, false); result.Status.Invalid() {
+			t.Fatalf("block #%d: %v", height, result.Status)
+		}
+		return block
+	}
+
+	// a fork of two blocks next to a longer chain
+	genesis := c.Blockchain.GenesisHash()
+	fork := mine(mine(genesis, 1).Hash, 2)
+	tip := genesis
+	for height := uint32(1); height <= uint32(blockchain.NumConfirmed)+3; height++ {
+		tip = mine(tip, height).Hash
+	}
+	if _, ok := c.Blockchain.TallyAt(fork.Hash, 0); ok {
+		t.Fatal("block of an abandoned fork is in the tally index")
+	}
+	votes, err := c.tallyAt(fork.Hash)
+	if err != nil || len(votes) != len(c.Blockchain.Candidates) {
+		t.Fatalf("tally of the fork: %v, %v", votes, err)
+	}
+	snapshot := util.DBKeyWithPrefix(TallyKeyPrefix, fork.Hash)
+	if !c.Storage.KeyExist(snapshot) || !c.Storage.KeyExist(util.DBKeyWithPrefix(TallyKeyPrefix, fork.PrevHash)) {
+		t.Fatal("tally of the fork is not stored for it and the blocks below")
+	}
+
+	// the snapshots go with the fork
+	if _, err = c.Blockchain.PruneForks(0, blockchain.NumConfirmed); err != nil {
+		t.Fatal(err)
+	}
+	if c.Storage.KeyExist(snapshot) {
+		t.Fatal("tally snapshot of a pruned block is kept")
+	}
+}
@@ -0,0 +1,144 @@
+package blockvote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MinerIdentity is the ID of a miner with the key that proves it to coord across restarts
+type MinerIdentity struct {
+	MinerID string
+	Key     *ecdsa.PrivateKey
+	Saved   bool // whether the key is kept in a file, so it survives a restart and can bind the ID for good
+}
+
+// ErrIdentityMismatch is returned by Register when a miner ID is claimed with another miner's key
+var ErrIdentityMismatch = errors.New("miner ID is registered with a different key")
+
+// ErrInvalidRegistration is returned by Register when the miner's info is not signed by the key it registers with
+var ErrInvalidRegistration = errors.New("invalid registration signature")
+
+// LoadMinerIdentity reads the identity saved at path, creating and saving one for minerId if the file
+// does not exist. An unsaved identity is created if path is empty. The saved ID wins over minerId.
+func LoadMinerIdentity(path string, minerId string) (*MinerIdentity, error) {
+	if path == "" {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		return &MinerIdentity{MinerID: minerId, Key: key}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Headers["MinerID"] == "" {
+			return nil, errors.New("no miner identity in " + path)
+		}
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		return &MinerIdentity{MinerID: block.Headers["MinerID"], Key: key, Saved: true}, err
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Headers: map[string]string{"MinerID": minerId}, Bytes: der})
+	if err = ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return &MinerIdentity{MinerID: minerId, Key: key, Saved: true}, nil
+}
+
+// PublicKey returns the public key sent to coord when registering
+func (id *MinerIdentity) PublicKey() []byte {
+	return elliptic.Marshal(elliptic.P256(), id.Key.X, id.Key.Y)
+}
+
+// Sign signs the miner's current addresses for RegisterArgs
+func (id *MinerIdentity) Sign(info MinerInfo) ([]byte, error) {
+	digest := registrationDigest(info)
+	return ecdsa.SignASN1(rand.Reader, id.Key, digest[:])
+}
+
+// verifyRegistration checks that info is signed by the key pubKey
+func verifyRegistration(pubKey []byte, info MinerInfo, signature []byte) bool {
+	x, y := elliptic.Unmarshal(elliptic.P256(), pubKey)
+	if x == nil {
+		return false
+	}
+	digest := registrationDigest(info)
+	return ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], signature)
+}
+
+func registrationDigest(info MinerInfo) [32]byte {
+	var data []byte
+	for _, field := range []string{info.MinerId, info.CoordListenAddr, info.MinerMinerAddr,
+		info.ClientListenAddr, info.GossipAddr, info.AckAddr} {
+		data = append(data, field...)
+		data = append(data, 0)
+	}
+	return sha256.Sum256(data)
+}
+
+// minerKeys is the key of every miner registered with coord, see blockchain.BlockChain.MinerKey
+type minerKeys struct {
+	mu   sync.RWMutex
+	keys map[string][]byte // no miner is known if nil, e.g. before coord sent the keys
+}
+
+// set replaces the keys. nil from a coord that does not send them is ignored
+func (k *minerKeys) set(keys map[string][]byte) {
+	if keys == nil {
+		return
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+}
+
+// get returns the key of a miner. no miner is known until coord sent the keys, so that no block goes without a
+// signature check
+func (k *minerKeys) get(minerID string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[minerID]
+	return key, ok
+}
+
+// all returns a copy of the keys, nil until coord sent them
+func (k *minerKeys) all() map[string][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.keys == nil {
+		return nil
+	}
+	keys := make(map[string][]byte, len(k.keys))
+	for minerID, key := range k.keys {
+		keys[minerID] = key
+	}
+	return keys
+}
+
+// count returns the number of known miners, -1 until coord sent the keys
+func (k *minerKeys) count() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.keys == nil {
+		return -1
+	}
+	return len(k.keys)
+}
@@ -0,0 +1,233 @@
+package blockvote
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/events"
+	"cs.ubc.ca/cpsc416/BlockVote/metrics"
+)
+
+// Coord times every txn from when it is first seen, gossiped by the miner that accepted it, to when a block
+// first puts it on the longest chain. Txns already on the longest chain when seen are not timed, and txns the
+// longest chain rejects stop being waited for. The percentiles of these inclusion latencies are part of GetChainStats,
+// and with InclusionSLA set coord warns while the 90th percentile or the oldest txn still waiting is above it:
+// blocks come too slowly for the ballots cast, so the difficulty should go down or miners be added
+
+// InclusionWindow is the number of latest inclusions the percentiles are computed over
+const InclusionWindow = 1000
+
+// InclusionCheckInterval is the time between two checks of the inclusion latencies against InclusionSLA
+const InclusionCheckInterval = 30 * time.Second
+
+// InclusionPendingTTL is how long a txn that is never included is waited for. it counts as expired after that
+const InclusionPendingTTL = time.Hour
+
+// InclusionBuckets are the upper bounds (in seconds) of the inclusion latency histogram
+var InclusionBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// InclusionStats is how long txns took to get into a block, see GetChainStats
+type InclusionStats struct {
+	Included      int           // txns included since coord started, out of those it saw first
+	Pending       int           // txns seen and not included yet
+	Expired       int           // txns not included InclusionPendingTTL after they were seen
+	Rejected      int           // txns seen and then made invalid by the longest chain, e.g. by another ballot
+	P50           time.Duration // percentiles of the latest InclusionWindow inclusions
+	P90           time.Duration
+	P99           time.Duration
+	Max           time.Duration
+	OldestPending time.Duration // time the oldest pending txn has been waiting
+	SLA           time.Duration // InclusionSLA. none if 0
+	Breaches      int           // inclusions slower than SLA
+}
+
+type inclusionTracker struct {
+	mu        sync.Mutex
+	sla       time.Duration
+	firstSeen map[string]pendingTxn // pending txns by ID
+	latencies []time.Duration       // ring of the latest InclusionWindow inclusions
+	next      int
+	nIncluded int
+	expired   int
+	rejected  int
+	breaches  int
+
+	histogram   *metrics.Histogram
+	breachCount *metrics.Counter
+}
+
+type pendingTxn struct {
+	txn *blockchain.Transaction
+	at  time.Time
+}
+
+func newInclusionTracker(reg *metrics.Registry) *inclusionTracker {
+	t := &inclusionTracker{firstSeen: make(map[string]pendingTxn)}
+	t.histogram = reg.NewHistogram("coord_txn_inclusion_seconds",
+		"Time from when coord first saw a txn to its first block on the longest chain.", InclusionBuckets)
+	t.breachCount = reg.NewCounter("coord_txn_inclusion_sla_breaches_total",
+		"Number of txns included later than the inclusion SLA.")
+	reg.NewGaugeFunc("coord_txn_inclusion_pending", "Number of txns seen by coord and not included yet.", func() float64 {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return float64(len(t.firstSeen))
+	})
+	return t
+}
+
+// seen notes when a txn was first seen
+func (t *inclusionTracker) seen(txn *blockchain.Transaction, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.firstSeen[string(txn.ID)]; !ok {
+		t.firstSeen[string(txn.ID)] = pendingTxn{txn: txn, at: at}
+	}
+}
+
+// included records the latency of a txn put on the longest chain at at, if it is pending. A txn coord did not
+// see first, or that is included again after a fork switch, is left out
+func (t *inclusionTracker) included(txid []byte, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending, ok := t.firstSeen[string(txid)]
+	if !ok {
+		return
+	}
+	delete(t.firstSeen, string(txid))
+	latency := at.Sub(pending.at)
+	if latency < 0 {
+		latency = 0
+	}
+	if len(t.latencies) < InclusionWindow {
+		t.latencies = append(t.latencies, latency)
+	} else {
+		t.latencies[t.next] = latency
+		t.next = (t.next + 1) % InclusionWindow
+	}
+	t.nIncluded++
+	t.histogram.Observe(latency.Seconds())
+	if t.sla > 0 && latency > t.sla {
+		t.breaches++
+		t.breachCount.Inc()
+	}
+}
+
+// expire drops the txns pending for longer than InclusionPendingTTL, and the ones valid rejects
+func (t *inclusionTracker) expire(now time.Time, valid func(txn *blockchain.Transaction) bool) {
+	t.mu.Lock()
+	pending := make([]pendingTxn, 0, len(t.firstSeen))
+	for txid, p := range t.firstSeen {
+		if now.Sub(p.at) > InclusionPendingTTL {
+			delete(t.firstSeen, txid)
+			t.expired++
+		} else {
+			pending = append(pending, p)
+		}
+	}
+	t.mu.Unlock()
+	// valid reads the chain, so it is not called under t.mu
+	for _, p := range pending {
+		if valid(p.txn) {
+			continue
+		}
+		t.mu.Lock()
+		if _, ok := t.firstSeen[string(p.txn.ID)]; ok {
+			delete(t.firstSeen, string(p.txn.ID))
+			t.rejected++
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *inclusionTracker) stats(now time.Time) InclusionStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := InclusionStats{
+		Included: t.nIncluded,
+		Expired:  t.expired,
+		Rejected: t.rejected,
+		SLA:      t.sla,
+		Breaches: t.breaches,
+	}
+	// txns expired since the last expire are counted as such already
+	for _, p := range t.firstSeen {
+		age := now.Sub(p.at)
+		if age > InclusionPendingTTL {
+			stats.Expired++
+			continue
+		}
+		stats.Pending++
+		if age > stats.OldestPending {
+			stats.OldestPending = age
+		}
+	}
+	if len(t.latencies) == 0 {
+		return stats
+	}
+	sorted := append([]time.Duration(nil), t.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	stats.P50, stats.P90, stats.P99 = percentile(50), percentile(90), percentile(99)
+	stats.Max = sorted[len(sorted)-1]
+	return stats
+}
+
+// watchInclusion feeds the tracker from the events of coord's chain and, with an SLA, checks it every
+// InclusionCheckInterval. It logs once when inclusion gets slower than the SLA and once when it is back within
+func (c *Coord) watchInclusion() {
+	c.inclusion.mu.Lock()
+	c.inclusion.sla = c.InclusionSLA
+	c.inclusion.mu.Unlock()
+	sub := c.Events.Subscribe(1000, events.NewTxn, events.NewBlock, events.ForkSwitch)
+	defer c.Events.Unsubscribe(sub)
+	ticker := time.NewTicker(InclusionCheckInterval)
+	defer ticker.Stop()
+	breached := false
+	for {
+		select {
+		case event := <-sub:
+			switch event.Topic {
+			case events.NewTxn:
+				// a txn gossiped late may be on the longest chain already, or never get there
+				if c.Blockchain.CheckTxn(event.Txn, nil).Err == nil {
+					c.inclusion.seen(event.Txn, event.Time)
+				}
+			case events.NewBlock:
+				if event.OnLongestChain {
+					for _, txn := range event.Block.Txns {
+						c.inclusion.included(txn.ID, event.Time)
+					}
+				}
+			case events.ForkSwitch:
+				for _, txn := range event.NewTxns {
+					c.inclusion.included(txn.ID, event.Time)
+				}
+			}
+		case now := <-ticker.C:
+			c.inclusion.expire(now, func(txn *blockchain.Transaction) bool {
+				// a txn on the chain is waiting for its NewBlock event
+				check := c.Blockchain.CheckTxn(txn, nil)
+				return check.Err == nil || check.Duplicate
+			})
+			if c.InclusionSLA <= 0 {
+				continue
+			}
+			stats := c.inclusion.stats(now)
+			slow := stats.P90 > stats.SLA || stats.OldestPending > stats.SLA
+			if slow && !breached {
+				log.Printf("[WARN] Txn inclusion is slower than the SLA of %v: p90 %v, oldest pending %v. "+
+					"Lower the difficulty or add miners\n", stats.SLA, stats.P90.Round(time.Second), stats.OldestPending.Round(time.Second))
+			} else if !slow && breached {
+				log.Printf("[INFO] Txn inclusion is back within the SLA of %v: p90 %v\n", stats.SLA, stats.P90.Round(time.Second))
+			}
+			breached = slow
+		case <-c.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package blockvote
+
+import (
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/metrics"
+)
+
+func TestInclusionTracker(t *testing.T) {
+	tracker := newInclusionTracker(metrics.NewRegistry())
+	start := time.Now()
+	txn := func(id string) *blockchain.Transaction {
+		return &blockchain.Transaction{ID: []byte(id)}
+	}
+	valid := func(txn *blockchain.Transaction) bool { return string(txn.ID) != "rejected" }
+
+	tracker.seen(txn("included"), start)
+	tracker.seen(txn("included"), start.Add(time.Minute)) // seen again, still timed from the first time
+	tracker.seen(txn("rejected"), start)
+	tracker.seen(txn("expired"), start.Add(-InclusionPendingTTL))
+	tracker.seen(txn("pending"), start.Add(time.Second))
+	tracker.included([]byte("included"), start.Add(2*time.Second))
+	tracker.included([]byte("included"), start.Add(3*time.Second)) // again after a fork switch
+	tracker.included([]byte("unseen"), start.Add(3*time.Second))
+
+	// expired before expire runs
+	now := start.Add(2 * time.Second)
+	stats := tracker.stats(now)
+	if stats.Included != 1 || stats.Expired != 1 || stats.Pending != 2 || stats.OldestPending != 2*time.Second {
+		t.Errorf("before expire: %+v", stats)
+	}
+	if stats.P50 != 2*time.Second || stats.Max != 2*time.Second {
+		t.Errorf("latency of the included txn: %+v", stats)
+	}
+
+	tracker.expire(now, valid)
+	stats = tracker.stats(now)
+	if stats.Expired != 1 || stats.Rejected != 1 || stats.Pending != 1 || stats.OldestPending != time.Second {
+		t.Errorf("after expire: %+v", stats)
+	}
+}
@@ -0,0 +1,44 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"encoding/json"
+	"fmt"
+)
+
+// checkIndexInvariants checks coord's indexes against the longest chain: every candidate has as many ballots in
+// the candidate index as the tally of the chain counts for it, and the stored tally of the tip is that tally
+func (c *Coord) checkIndexInvariants() error {
+	tip := c.Blockchain.GetLastHash()
+	votes, _ := c.Blockchain.VotingStatusAt(tip, 0)
+	for i, cand := range c.Blockchain.Candidates {
+		race, name := cand.CandidateData.Race, cand.CandidateData.CandidateName
+		txids, err := c.candTxns.Lookup(race, name)
+		if err != nil {
+			return fmt.Errorf("unable to look up candidate %q of race %q: %v", name, race, err)
+		}
+		if uint(len(txids)) != votes[i] {
+			return fmt.Errorf("candidate %q of race %q has %d ballots in the index but %d votes on the chain",
+				name, race, len(txids), votes[i])
+		}
+	}
+	stored, err := c.tallyAt(tip)
+	if err != nil {
+		return fmt.Errorf("unable to read the tally of the tip: %v", err)
+	}
+	for i := range votes {
+		if i >= len(stored) || stored[i] != votes[i] {
+			return fmt.Errorf("stored tally %v of the tip is not the counted tally %v", stored, votes)
+		}
+	}
+	return nil
+}
+
+// assertIndexInvariants panics with a dump of coord if its indexes disagree with the chain after block was
+// ingested, see checkIndexInvariants
+func (c *Coord) assertIndexInvariants(block *blockchain.Block) {
+	if err := c.checkIndexInvariants(); err != nil {
+		dump, _ := json.MarshalIndent(c.dump(), "", "  ")
+		panic(fmt.Sprintf("index invariant violated after block #%d (%x): %v\n%s", block.BlockNum, block.Hash, err, dump))
+	}
+}
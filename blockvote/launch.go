@@ -0,0 +1,96 @@
+package blockvote
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cs.ubc.ca/cpsc416/BlockVote/config"
+	"github.com/DistributedClocks/tracing"
+)
+
+// CoordLaunch is what starting coord from the command line takes besides its config. cmd/coord and
+// cmd/blockvote -role coord both start coord with LaunchCoord, so they behave the same
+type CoordLaunch struct {
+	StorageDir  string   // database directory. elections with an ElectionID get a directory each under it
+	Restart     bool     // keep the database of an earlier run. it is removed otherwise
+	RestoreFrom string   // backup file to restore the database from
+	Elections   []string // config files of more elections to host at the same addresses
+	Dev         bool     // dev mode: DevVoters in the configs are accepted
+	Tracer      *tracing.Tracer
+}
+
+// LaunchCoord checks cfg and the configs of the other elections, starts coord for each of them and, on
+// SIGINT or SIGTERM, writes the result certificate of every election and exits. It returns when coord stops
+func LaunchCoord(cfg *CoordConfig, launch CoordLaunch) error {
+	if err := cfg.Preflight(launch.StorageDir); err != nil {
+		return fmt.Errorf("coord cannot start with this config:\n%v", err)
+	}
+	// every other election needs an ID of its own
+	var electionCfgs []*CoordConfig
+	electionIDs := map[string]bool{cfg.ElectionID: true}
+	for _, path := range launch.Elections {
+		electionCfg := new(CoordConfig)
+		if err := config.Decode(path, electionCfg); err != nil {
+			return err
+		}
+		if electionIDs[electionCfg.ElectionID] {
+			return fmt.Errorf("%s: ElectionID %q is empty or already hosted", path, electionCfg.ElectionID)
+		}
+		if err := electionCfg.Preflight(launch.StorageDir); err != nil {
+			return fmt.Errorf("election %s cannot start with %s:\n%v", electionCfg.ElectionID, path, err)
+		}
+		electionIDs[electionCfg.ElectionID] = true
+		electionCfgs = append(electionCfgs, electionCfg)
+	}
+	if !launch.Restart && launch.RestoreFrom == "" && cfg.ReplicaOf == "" {
+		if _, err := os.Stat(launch.StorageDir); err == nil {
+			os.RemoveAll(launch.StorageDir)
+		}
+	}
+
+	coord := NewCoord()
+	coord.StorageDir = launch.StorageDir
+	coord.RestoreFrom = launch.RestoreFrom
+	coord.Dev = launch.Dev
+	var hosted []*Coord
+	for _, electionCfg := range electionCfgs {
+		electionCoord := NewCoord()
+		electionCoord.StorageDir = launch.StorageDir
+		electionCoord.Dev = launch.Dev
+		hosted = append(hosted, electionCoord)
+		go func(electionCfg *CoordConfig) {
+			if err := electionCoord.StartWithConfig(electionCfg, launch.Tracer); err != nil {
+				log.Printf("[ERROR] Election %s stopped: %v\n", electionCfg.ElectionID, err)
+			}
+		}(electionCfg)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if cfg.ReplicaOf != "" {
+			os.Exit(0)
+		}
+		coord.PrintChain()
+		writeCertificate(coord, "result_certificate.json")
+		for _, electionCoord := range hosted {
+			writeCertificate(electionCoord, "result_certificate-"+electionCoord.ElectionID+".json")
+		}
+		os.Exit(0)
+	}()
+	return coord.StartWithConfig(cfg, launch.Tracer)
+}
+
+func writeCertificate(coord *Coord, path string) {
+	cert, err := coord.ResultCertificate()
+	if err == nil {
+		err = cert.WriteFile(path)
+	}
+	if err != nil {
+		log.Println("[WARN] Unable to write result certificate:", err)
+	}
+}
@@ -0,0 +1,127 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/events"
+	"cs.ubc.ca/cpsc416/BlockVote/metrics"
+)
+
+type coordMetrics struct {
+	forkSwitches        *metrics.Counter
+	queryTxnLatency     *metrics.Histogram
+	queryTxnsLatency    *metrics.Histogram
+	queryResultsLatency *metrics.Histogram
+}
+
+type minerMetrics struct {
+	blocksMined   *metrics.Counter
+	forkSwitches  *metrics.Counter
+	txnsSubmitted *metrics.Counter
+}
+
+// initMetrics registers all coord metrics
+func (c *Coord) initMetrics() {
+	reg := c.Metrics
+	reg.NewGaugeFunc("coord_registered_miners", "Number of miners registered with coord.", func() float64 {
+		c.nlMu.Lock()
+		defer c.nlMu.Unlock()
+		return float64(len(c.NodeList))
+	})
+	reg.NewGaugeFunc("coord_active_miners", "Number of registered miners coord is connected to.", func() float64 {
+		c.nlMu.Lock()
+		defer c.nlMu.Unlock()
+		active := 0
+		for _, conn := range c.MinerConns {
+			if conn != nil {
+				active++
+			}
+		}
+		return float64(active)
+	})
+	reg.NewGaugeFunc("coord_chain_height", "Block number of the last block on the longest chain.", func() float64 {
+		if c.Blockchain == nil {
+			return 0
+		}
+		return float64(c.Blockchain.Get(c.Blockchain.GetLastHash()).BlockNum)
+	})
+	reg.NewCounterFunc("coord_block_cache_hits_total", "Number of blocks served from the block cache.", func() float64 {
+		if c.Blockchain == nil {
+			return 0
+		}
+		hits, _ := c.Blockchain.CacheStats()
+		return float64(hits)
+	})
+	reg.NewCounterFunc("coord_block_cache_misses_total", "Number of blocks decoded from the database.", func() float64 {
+		if c.Blockchain == nil {
+			return 0
+		}
+		_, misses := c.Blockchain.CacheStats()
+		return float64(misses)
+	})
+	registerSigCacheMetrics(reg, "coord")
+	c.metrics = coordMetrics{
+		forkSwitches: reg.NewCounter("coord_fork_switches_total",
+			"Number of times coord switched to a different fork."),
+		queryTxnLatency: reg.NewHistogram("coord_query_txn_duration_seconds",
+			"Latency of QueryTxn requests.", metrics.DefaultLatencyBuckets),
+		queryTxnsLatency: reg.NewHistogram("coord_query_txns_duration_seconds",
+			"Latency of QueryTxns requests.", metrics.DefaultLatencyBuckets),
+		queryResultsLatency: reg.NewHistogram("coord_query_results_duration_seconds",
+			"Latency of QueryResults requests.", metrics.DefaultLatencyBuckets),
+	}
+	go func() {
+		for range c.Events.Subscribe(50, events.ForkSwitch) {
+			c.metrics.forkSwitches.Inc()
+		}
+	}()
+}
+
+// initMetrics registers all miner metrics
+func (m *Miner) initMetrics() {
+	reg := m.Metrics
+	reg.NewGaugeFunc("miner_chain_height", "Block number of the last block on the longest chain.", func() float64 {
+		// the chain is set up under m.mu, after the metrics are served
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.Blockchain == nil {
+			return 0
+		}
+		return float64(m.Blockchain.Get(m.Blockchain.GetLastHash()).BlockNum)
+	})
+	reg.NewGaugeFunc("miner_pool_size", "Number of pending transactions in the memory pool.", func() float64 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return float64(len(m.MemoryPool.PendingTxns))
+	})
+	registerSigCacheMetrics(reg, "miner")
+	m.metrics = minerMetrics{
+		blocksMined: reg.NewCounter("miner_blocks_mined_total",
+			"Number of blocks mined by this miner."),
+		forkSwitches: reg.NewCounter("miner_fork_switches_total",
+			"Number of times the miner switched to a different fork."),
+		txnsSubmitted: reg.NewCounter("miner_txns_submitted_total",
+			"Number of transactions submitted by clients and taken into the pool."),
+	}
+	go func() {
+		for event := range m.Events.Subscribe(50, events.NewBlock, events.ForkSwitch) {
+			if event.Topic == events.ForkSwitch {
+				m.metrics.forkSwitches.Inc()
+			} else if event.Block.MinerID == m.Info.MinerId {
+				m.metrics.blocksMined.Inc()
+			}
+		}
+	}()
+}
+
+// registerSigCacheMetrics registers the hit and miss counters of the txn signature cache. The hit rate is
+// hits / (hits + misses)
+func registerSigCacheMetrics(reg *metrics.Registry, node string) {
+	reg.NewCounterFunc(node+"_sig_cache_hits_total", "Number of txn signatures found already verified.", func() float64 {
+		hits, _ := blockchain.SigCacheStats()
+		return float64(hits)
+	})
+	reg.NewCounterFunc(node+"_sig_cache_misses_total", "Number of txn signatures verified from scratch.", func() float64 {
+		_, misses := blockchain.SigCacheStats()
+		return float64(misses)
+	})
+}
@@ -4,29 +4,31 @@ import (
 	"bytes"
 	"cs.ubc.ca/cpsc416/BlockVote/Identity"
 	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
+	"cs.ubc.ca/cpsc416/BlockVote/events"
 	fchecker "cs.ubc.ca/cpsc416/BlockVote/fcheck"
 	"cs.ubc.ca/cpsc416/BlockVote/gossip"
+	"cs.ubc.ca/cpsc416/BlockVote/metrics"
 	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/DistributedClocks/tracing"
 	"log"
-	"math"
+	"net"
 	"net/rpc"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type MinerConfig struct {
-	MinerId           string
-	CoordAddr         string
-	MinerAddr         string
-	TracingServerAddr string
-	Difficulty        uint8
-	Secret            []byte
-	TracingIdentity   string
-	MaxTxn            uint8
-}
+type MinerConfig = config.Miner
+
+// MaxBlockBatch is the most blocks from peers that are validated together, see blockchain.PutBatch
+const MaxBlockBatch = 16
 
 type MinerInfo struct {
 	MinerId          string
@@ -35,6 +37,7 @@ type MinerInfo struct {
 	ClientListenAddr string
 	GossipAddr       string
 	AckAddr          string
+	Label            string // e.g. the region of the miner, for clients to filter GetMinerList by
 }
 
 // messages
@@ -42,9 +45,21 @@ type MinerInfo struct {
 type NotifyPeerListArgs struct {
 	PeerAddrList       []string
 	PeerGossipAddrList []string
+	MinerKeys          map[string][]byte // key of every miner that ever registered with coord
 }
 
 type NotifyPeerListReply struct {
+	RPCStatus
+}
+
+type GetLoadArgs struct {
+}
+
+type GetLoadReply struct {
+	RPCStatus
+	PoolSize int    // number of pending txns
+	Height   uint32 // block number of the tip of the miner's longest chain
+	Throttle MiningThrottle
 }
 
 type GetBlockArgs struct {
@@ -52,6 +67,7 @@ type GetBlockArgs struct {
 }
 
 type GetBlockReply struct {
+	RPCStatus
 	block blockchain.Block
 }
 
@@ -59,16 +75,62 @@ type GetTxnPoolArgs struct {
 }
 
 type GetTxnPoolReply struct {
+	RPCStatus
 	PeerTxnPool TxnPool
 }
 
 type SubmitTxnArgs struct {
-	Txn blockchain.Transaction
+	Txn      blockchain.Transaction
+	Token    tracing.TracingToken
+	ClientID uint // see ClientID in evlib. 0 if the client did not tell
 }
 
+// SubmitTxnReply tells whether the miner took the txn and if not, why. There is no voter roll: a voter is
+// eligible if the key is not a candidate's and still has ballots left in the race
 type SubmitTxnReply struct {
+	RPCStatus
+	Accepted       bool   // the txn was added to the pool and gossiped
+	ValidSignature bool   // signed by the key in the txn
+	VoterEligible  bool   // the key may cast this ballot
+	ValidBallot    bool   // for a race and candidates of the election
+	Duplicate      bool   // already pending or on the longest chain
+	OtherElection  bool   // signed for another election's chain
+	Reason         string // why the txn was not accepted. empty if Accepted
+	Height         uint32 // block number of the tip of the miner's longest chain
+}
+
+type GetBlockTemplateArgs struct {
+}
+
+type GetBlockTemplateReply struct {
+	RPCStatus
+	TemplateID uint64
+	Header     blockchain.BlockHeader // Nonce and Hash are unset
+	Prefix     []byte                 // see blockchain.BlockHeader.SplitAtNonce
+	Suffix     []byte
+	Target     []byte // big endian. a solution hashes below it, with the hash algorithm of Header.HashAlgo
+}
+
+type SubmitSolvedBlockArgs struct {
+	TemplateID uint64
+	Nonce      uint32
 }
 
+type SubmitSolvedBlockReply struct {
+	RPCStatus
+	Hash []byte
+}
+
+// ErrElectionClosed is returned by SubmitTxn after the election deadline
+var ErrElectionClosed = errors.New("election is closed")
+
+// ErrElectionOpen is returned by SubmitTxn for a txn releasing the sealing key before the election deadline
+var ErrElectionOpen = errors.New("election is still open")
+
+// PoolOrderFIFO fills blocks with pending txns in arrival order. By default the pool takes turns
+// between voters, so a burst of ballots from one voter can't hold back the others
+const PoolOrderFIFO = "fifo"
+
 type Miner struct {
 	// Miner state may go here
 	Storage    *util.Database
@@ -79,6 +141,11 @@ type Miner struct {
 	Candidates   []Identity.Wallets
 	MemoryPool   TxnPool
 	MaxTxn       uint8
+	PoolOrder    string // PoolOrderFIFO to fill blocks in arrival order. round-robin across voters if empty
+	MaxPoolSize  int    // pending txns above which SubmitTxn returns a BusyError. no limit if 0
+
+	MaxClientBallots int          // ballots accepted from each client ID, see takeBallotQuota. no limit if 0
+	clientBallots    map[uint]int // ballots accepted from each client ID. guarded by mu
 
 	queryChan  <-chan gossip.Update
 	updateChan chan<- gossip.Update
@@ -87,42 +154,267 @@ type Miner struct {
 	BlockRecvChan    chan *blockchain.Block
 	ChainUpdatedChan chan int
 
+	ForkRetention  time.Duration // how long abandoned fork blocks are kept. never pruned if 0
+	StorageKeyFile string        // node key file for encrypting the database at rest. not encrypted if empty
+	StorageDir     string        // database directory, resumed on restart. in-memory database if empty
+	BackupDir      string        // where scheduled backups are written to. no backup if empty
+	BackupInterval time.Duration // time between two scheduled backups
+	RestoreFrom    string        // backup file to restore the database from before starting
+	IdentityFile   string        // key identifying the miner to coord across restarts. a new identity every run if empty
+	Clock          util.Clock    // paces mining. a util.FakeClock makes mining deterministic in tests
+
+	MiningWorkers   int       // goroutines searching nonces in parallel. 1 if 0
+	MiningDutyCycle int       // percent of the time spent mining, see DutyCycleWindow. always mining if 0 or 100
+	dutyStart       time.Time // start of the current duty cycle window. used by MiningService only
+	miningPaused    int32     // 1 while idling for the rest of a duty cycle window, accessed atomically
+	OnDemand        bool      // only mine the blocks AllowBlocks allows, e.g. for deterministic replays. set before Start
+	allowedBlocks   int32     // blocks the miner may still mine in OnDemand mode, accessed atomically
+
+	identity    *MinerIdentity
+	knownMiners minerKeys // miners registered with coord. blocks of other miners are rejected
+
+	AdminListenAddr string // where admin API requests are served. not served if empty
+	AdminToken      string // token admin API callers must send, see LoadAdminToken
+	Peers           *PeerScores
+	templates       blockTemplates // block templates handed to external solvers. guarded by mu
+
+	MaxConcurrentRPCs int // RPC requests handled at once, the others wait. no limit if 0
+	rpcGuard          *util.RPCGuard
+
+	GenesisHash []byte                    // genesis block coord must have. any if nil
+	Genesis     *blockchain.GenesisConfig // coord's genesis block must derive from it and coord's candidates. not checked if nil
+	ElectionID  string                    // election the miner mines for. coord's services of the election are called, see Scoped
+	Dev         bool                      // mine on dev chains, see blockchain.Registration.DevVoters. refused otherwise
+
+	CoordWaitTimeout time.Duration // how long Start waits for coord and its chain. forever if 0
+
+	StrictInvariants bool // re-check the chain after every block and panic on a violation. for development
+	LegacyGenesis    bool // mine on a chain whose genesis block predates genesis configs, see blockchain.ErrLegacyGenesis
+
+	tracer *tracing.Tracer
+	trace  *tracing.Trace
+
+	Events *events.Bus
+
+	Metrics           *metrics.Registry
+	MetricsListenAddr string // where /metrics is served. not served if empty
+	metrics           minerMetrics
+
+	HealthListenAddr string // where /healthz and /readyz are served. not served if empty
+
+	gossip    *gossip.Client
+	fcheck    *fchecker.Checker
+	listeners []net.Listener
+	services  sync.WaitGroup
+	ready     chan struct{}
+	quit      chan struct{}
+	stopOnce  sync.Once
+
+	catchingUp int32       // 1 while catchUp downloads blocks, accessed atomically
+	orphans    *orphanPool // blocks from peers waiting for their parent. guarded by mu
+	backfill   chan uint32 // heights to catch up to from coord, requested when an orphan arrives
+
 	mu    sync.Mutex
 	cond  *sync.Cond
 	start bool
 }
 
 func NewMiner() *Miner {
-	return &Miner{
+	m := &Miner{
 		Storage:          &util.Database{},
 		ReceivedTxns:     make(map[string]bool),
 		TxnRecvChan:      make(chan *blockchain.Transaction, 500),
 		BlockRecvChan:    make(chan *blockchain.Block, 50),
 		ChainUpdatedChan: make(chan int, 50),
+		Events:           events.NewBus(),
+		Metrics:          metrics.NewRegistry(),
+		Peers:            NewPeerScores(),
+		Clock:            util.RealClock,
+		orphans:          newOrphanPool(),
+		backfill:         make(chan uint32, 1),
+		rpcGuard:         util.NewRPCGuard(0),
+		gossip:           gossip.NewClient(),
+		fcheck:           fchecker.New(),
+		ready:            make(chan struct{}),
+		quit:             make(chan struct{}),
 	}
+	m.initMetrics()
+	return m
+}
+
+// Ready is closed once the miner has joined and started mining
+func (m *Miner) Ready() <-chan struct{} {
+	return m.ready
+}
+
+// Stop stops all services of the miner and makes Start return. The miner cannot be started again.
+func (m *Miner) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.quit)
+		for _, listener := range m.listeners {
+			listener.Close()
+		}
+		m.gossip.Stop()
+		m.fcheck.Stop()
+	})
+}
+
+// SetPeerFilter restricts which gossip peers the miner talks to. nil allows all peers.
+func (m *Miner) SetPeerFilter(filter func(peer string) bool) {
+	m.gossip.SetPeerFilter(filter)
 }
 
 type TxnPool struct {
 	PendingTxns []blockchain.Transaction
 }
 
+// Has checks whether a txn with the given ID is pending
+func (p *TxnPool) Has(txid []byte) bool {
+	for _, txn := range p.PendingTxns {
+		if bytes.Compare(txn.ID, txid) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IDs returns the set of pending txn IDs, for checking many txns against the pool
+func (p *TxnPool) IDs() map[string]bool {
+	ids := make(map[string]bool, len(p.PendingTxns))
+	for _, txn := range p.PendingTxns {
+		ids[string(txn.ID)] = true
+	}
+	return ids
+}
+
+// Select returns up to n pending txns in the order they go into a block. PendingTxns is kept in arrival
+// order; unless order is PoolOrderFIFO, voters (by public key) take turns in the order of their oldest
+// txn, each voter's txns in arrival order
+func (p *TxnPool) Select(n int, order string) (selected []*blockchain.Transaction) {
+	if n > len(p.PendingTxns) {
+		n = len(p.PendingTxns)
+	}
+	if order == PoolOrderFIFO {
+		for i := 0; i < n; i++ {
+			txn := p.PendingTxns[i] // make a copy first. avoid pointing to the slot in slice.
+			selected = append(selected, &txn)
+		}
+		return
+	}
+	// queue of pool indices per voter, voters in order of first arrival
+	var voters []string
+	queues := make(map[string][]int)
+	for i, txn := range p.PendingTxns {
+		voter := string(txn.PublicKey)
+		if _, ok := queues[voter]; !ok {
+			voters = append(voters, voter)
+		}
+		queues[voter] = append(queues[voter], i)
+	}
+	for round := 0; len(selected) < n; round++ {
+		for _, voter := range voters {
+			if round < len(queues[voter]) && len(selected) < n {
+				txn := p.PendingTxns[queues[voter][round]]
+				selected = append(selected, &txn)
+			}
+		}
+	}
+	return
+}
+
+// StartWithConfig applies the optional settings in cfg and starts the miner
+func (m *Miner) StartWithConfig(cfg *MinerConfig, mtrace *tracing.Tracer) error {
+	m.ForkRetention = time.Duration(cfg.ForkRetention) * time.Second
+	m.StorageKeyFile = cfg.StorageKeyFile
+	m.MetricsListenAddr = cfg.MetricsListenAddr
+	m.HealthListenAddr = cfg.HealthListenAddr
+	m.StorageDir = cfg.StorageDir
+	m.BackupDir = cfg.BackupDir
+	m.BackupInterval = time.Duration(cfg.BackupInterval) * time.Second
+	m.PoolOrder = cfg.PoolOrder
+	m.MaxPoolSize = int(cfg.MaxPoolSize)
+	m.MaxClientBallots = int(cfg.MaxClientBallots)
+	m.MiningWorkers = int(cfg.MiningWorkers)
+	m.MiningDutyCycle = int(cfg.MiningDutyCycle)
+	m.Info.Label = cfg.Label
+	m.StrictInvariants = cfg.StrictInvariants
+	m.LegacyGenesis = cfg.LegacyGenesis
+	m.ElectionID = cfg.ElectionID
+	if m.ElectionID != "" {
+		if m.StorageDir != "" {
+			m.StorageDir = filepath.Join(m.StorageDir, m.ElectionID)
+		}
+		if m.BackupDir != "" {
+			m.BackupDir = filepath.Join(m.BackupDir, m.ElectionID)
+		}
+	}
+	m.IdentityFile = cfg.IdentityFile
+	m.AdminListenAddr = cfg.AdminListenAddr
+	if cfg.AdminTokenFile != "" {
+		token, err := LoadAdminToken(cfg.AdminTokenFile)
+		if err != nil {
+			return err
+		}
+		m.AdminToken = token
+	}
+	m.MaxConcurrentRPCs = int(cfg.MaxConcurrentRPCs)
+	m.rpcGuard = util.NewRPCGuard(m.MaxConcurrentRPCs)
+	genesisHash, err := hex.DecodeString(cfg.GenesisHash)
+	if err != nil {
+		return err
+	}
+	if len(genesisHash) > 0 {
+		m.GenesisHash = genesisHash
+	}
+	if cfg.VerifyGenesis {
+		genesisTime, err := cfg.GenesisTimestamp()
+		if err != nil {
+			return err
+		}
+		m.Genesis = &blockchain.GenesisConfig{
+			ElectionID: cfg.ElectionID,
+			Timestamp:  genesisTime,
+			Difficulty: cfg.GenesisDifficulty,
+			HashAlgo:   cfg.HashAlgo,
+		}
+	}
+	m.CoordWaitTimeout = time.Duration(cfg.CoordWaitTimeout) * time.Second
+	return m.Start(cfg.MinerId, cfg.CoordAddr, cfg.MinerAddr, cfg.Difficulty, cfg.MaxTxn, mtrace)
+}
+
 func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, difficulty uint8, maxTxn uint8, mtrace *tracing.Tracer) error {
 	m.MaxTxn = maxTxn
+	identity, err := LoadMinerIdentity(m.IdentityFile, minerId)
+	util.CheckErr(err, "error when loading miner identity")
+	if identity.MinerID != minerId {
+		log.Printf("[WARN] Keeping miner ID %s from %s instead of %s\n", identity.MinerID, m.IdentityFile, minerId)
+		minerId = identity.MinerID
+	}
+	m.identity = identity
 	m.Info.MinerId = minerId
-	err := m.Storage.New("", true)
-	if err != nil {
-		util.CheckErr(err, "error when creating database")
+	m.tracer = mtrace
+	m.trace = CreateTrace(mtrace)
+	if m.StorageKeyFile != "" {
+		key, err := util.LoadKeyFile(m.StorageKeyFile)
+		util.CheckErr(err, "error when loading storage key")
+		err = m.Storage.EnableEncryption(key)
+		util.CheckErr(err, "error when enabling storage encryption")
 	}
+	resume := m.initStorage()
 	defer m.Storage.Close()
+	go m.Storage.Maintain(StorageMaintenanceInterval)
+	if m.BackupDir != "" && m.BackupInterval > 0 {
+		go m.Storage.ScheduleBackup(m.BackupDir, m.BackupInterval)
+	}
 
 	m.cond = sync.NewCond(&m.mu)
 	m.mu.Lock()
 	// starting API services
-	minerIP := minerAddr[0:strings.Index(minerAddr, ":")]
+	minerIP := util.HostOf(minerAddr)
 	// << coord
 	minerAPICoord := new(MinerAPICoord)
 	minerAPICoord.m = m
-	coordListenAddr, err := util.NewRPCServerWithIp(minerAPICoord, minerIP)
+	coordListenAddr, err := m.listen(minerAPICoord, minerIP)
 	if err != nil {
 		return errors.New("cannot start API service for coord")
 	}
@@ -132,7 +424,7 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 	// << client
 	minerAPIClient := new(MinerAPIClient)
 	minerAPIClient.m = m
-	clientListenAddr, err := util.NewRPCServerWithIp(minerAPIClient, minerIP)
+	clientListenAddr, err := m.listen(minerAPIClient, minerIP)
 	if err != nil {
 		return errors.New("cannot start API service for client")
 	}
@@ -142,43 +434,65 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 	// << miner
 	minerAPIMiner := new(MinerAPIMiner)
 	minerAPIMiner.m = m
-	minerMinerAddr, err := util.NewRPCServerWithIp(minerAPIMiner, minerIP)
+	minerMinerAddr, err := m.listen(minerAPIMiner, minerIP)
 	if err != nil {
 		return errors.New("cannot start API service for miner")
 	}
 	m.Info.MinerMinerAddr = minerMinerAddr
 	log.Println("[INFO] Listen to miners' API requests at", m.Info.MinerMinerAddr)
 
+	// << admin
+	if m.AdminListenAddr != "" {
+		minerAPIAdmin := new(MinerAPIAdmin)
+		minerAPIAdmin.m = m
+		if m.AdminToken == "" {
+			return errors.New("the admin API needs an admin token")
+		}
+		listener, err := util.ListenRPCWithToken(minerAPIAdmin, m.AdminListenAddr, m.AdminToken)
+		if err != nil {
+			return errors.New("cannot start admin API service")
+		}
+		m.listeners = append(m.listeners, listener)
+		log.Println("[INFO] Listen to admin API requests at", listener.Addr())
+	}
+
+	// metrics
+	if m.MetricsListenAddr != "" {
+		err = m.Metrics.Serve(m.MetricsListenAddr)
+		if err != nil {
+			return errors.New("cannot start metrics service")
+		}
+		log.Println("[INFO] Serving metrics at", m.MetricsListenAddr)
+	}
+
+	// health probes. not ready until the miner has caught up with coord
+	if m.HealthListenAddr != "" {
+		listener, err := serveHealth(m.HealthListenAddr, m.health)
+		if err != nil {
+			return errors.New("cannot start health probes")
+		}
+		m.listeners = append(m.listeners, listener)
+		log.Println("[INFO] Serving health probes at", m.HealthListenAddr)
+	}
+
 	// fcheck
-	ackPort, _, err := fchecker.Start(fchecker.StartStruct{
+	ackPort, _, err := m.fcheck.Start(fchecker.StartStruct{
 		LocalIP: minerIP,
 	})
 	if err != nil {
 		return errors.New("cannot start fcheck")
 	}
-	m.Info.AckAddr = minerIP + ":" + ackPort
-	defer fchecker.Stop()
+	m.Info.AckAddr = net.JoinHostPort(minerIP, ackPort)
+	defer m.Stop()
 
 	// Miner join
 	log.Println("[INFO] Retrieving infomation from coord...")
-	coordClient, err := util.NewRPCClient(minerAddr, coordAddr)
-	for err != nil {
-		log.Println("[INFO] Reattempting to establish connection with coord...")
-		coordClient, err = util.NewRPCClient(minerAddr, coordAddr)
+	coordClient, downloadReply, err := m.waitForCoord(minerAddr, coordAddr)
+	if err != nil {
+		return err
 	}
-	// download blockchain from coord
-	downloadReply := DownloadReply{}
-	err = coordClient.Call("CoordAPIMiner.Download", DownloadArgs{}, &downloadReply)
-	for err != nil {
-		log.Println("[INFO] Reattempting to download data from coord...")
-		for {
-			// rpc connection is interrupted, need to reconnect
-			coordClient, err = util.NewRPCClient(minerAddr, coordAddr)
-			if err == nil {
-				break
-			}
-		}
-		err = coordClient.Call("CoordAPIMiner.Download", DownloadArgs{}, &downloadReply)
+	if err = m.checkGenesis(downloadReply); err != nil {
+		return err
 	}
 
 	// setup candidates
@@ -188,17 +502,81 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 		m.Candidates = append(m.Candidates, *wallets)
 	}
 
-	// setup blockchain
+	// setup blockchain, only downloading the blocks missed since the last run
 	log.Println("[INFO] Setting up blockchain...")
 	var candidates []*Identity.Wallets
 	for _, cand := range downloadReply.Candidates {
 		candidates = append(candidates, Identity.DecodeToWallets(cand))
 	}
 	m.Blockchain = blockchain.NewBlockChain(m.Storage, candidates)
-	err = m.Blockchain.ResumeFromEncodedData(downloadReply.BlockChain, downloadReply.LastHash)
+	m.Blockchain.Strict = m.StrictInvariants
+	m.Blockchain.LegacyGenesis = m.LegacyGenesis
+	m.knownMiners.set(downloadReply.MinerKeys)
+	m.Blockchain.MinerKey = m.knownMiners.get
+	// blocks are checked and mined at the difficulty of coord's chain
+	if err = m.Blockchain.SetDifficulty(downloadReply.Difficulty); err != nil {
+		return errors.New("cannot save difficulty")
+	}
+	if difficulty != 0 && difficulty != m.Blockchain.BlockDifficulty() {
+		return fmt.Errorf("miner config has Difficulty %d but coord's chain has %d", difficulty, m.Blockchain.BlockDifficulty())
+	}
+	var knownHashes [][]byte
+	if resume {
+		err = m.Blockchain.ResumeFromDB()
+		util.CheckErr(err, "error when reloading blockchain")
+		err = m.Blockchain.VerifyStored()
+		util.CheckErr(err, "stored blockchain is corrupted")
+		if genesis := m.Blockchain.GenesisHash(); !bytes.Equal(genesis, downloadReply.Genesis) {
+			return fmt.Errorf("stored chain has genesis block %x but coord has %x", genesis, downloadReply.Genesis)
+		}
+		m.Blockchain.IndexTallies()
+		if err = m.Blockchain.IndexHeights(); err != nil {
+			return err
+		}
+		if knownHashes, err = m.Blockchain.Hashes(); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Resuming with %d stored blocks\n", len(knownHashes))
+	}
+	encodedBlocks, err := DownloadChain(coordClient, m.ElectionID, downloadReply.Height, knownHashes)
+	for err != nil {
+		log.Println("[INFO] Reattempting to download blockchain from coord...")
+		for {
+			// rpc connection is interrupted, need to reconnect
+			coordClient, err = util.NewRPCClient(minerAddr, coordAddr)
+			if err == nil {
+				break
+			}
+		}
+		encodedBlocks, err = DownloadChain(coordClient, m.ElectionID, downloadReply.Height, knownHashes)
+	}
+	err = m.Blockchain.ResumeFromEncodedData(encodedBlocks, downloadReply.LastHash)
 	if err != nil {
 		return errors.New("cannot resume blockchain")
 	}
+	err = m.Blockchain.SetDeadline(downloadReply.ElectionEnd)
+	if err != nil {
+		return errors.New("cannot save election deadline")
+	}
+	err = m.Blockchain.SetFinalityDepth(downloadReply.FinalityDepth)
+	if err != nil {
+		return errors.New("cannot save finality depth")
+	}
+	err = m.Blockchain.SetSealingKey(downloadReply.SealingKey)
+	if err != nil {
+		return errors.New("cannot save sealing key")
+	}
+	err = m.Blockchain.SetRegistration(downloadReply.Registration)
+	if err != nil {
+		return errors.New("cannot save registration")
+	}
+	// ballots are checked against the candidates of coord, which must be the ones the chain started with
+	if err = m.Blockchain.CheckCandidates(m.ElectionID); err != nil {
+		return fmt.Errorf("coord's candidates are rejected: %v", err)
+	}
+	if m.ForkRetention > 0 {
+		go m.Blockchain.RunForkJanitor(m.ForkRetention)
+	}
 
 	// setup txn pool (download from any of its peers)
 	log.Println("[INFO] Setting up memory pool...")
@@ -207,13 +585,13 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 		for i < len(downloadReply.PeerAddrList) { // attempt to download txn pool from selected peer
 			// get txn pool from the peer
 			toPullMinerAddr := downloadReply.PeerAddrList[i]
-			minerClient, err := rpc.Dial("tcp", toPullMinerAddr)
+			minerClient, err := util.DialRPC(toPullMinerAddr)
 			if err != nil {
 				i++
 				continue
 			}
 			reply := GetTxnPoolReply{}
-			err = minerClient.Call("MinerAPIMiner.GetTxnPool", GetTxnPoolArgs{}, &reply)
+			err = Call(minerClient, "MinerAPIMiner.GetTxnPool", GetTxnPoolArgs{}, &reply)
 			if err != nil {
 				i++
 				continue
@@ -224,7 +602,7 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 		}
 		if i == len(downloadReply.PeerAddrList) {
 			// if all peers failed, contact coord again for updated peer address list
-			err = coordClient.Call("CoordAPIMiner.Download", DownloadArgs{}, &downloadReply)
+			err = Call(coordClient, Scoped(m.ElectionID, "CoordAPIMiner.Download"), DownloadArgs{}, &downloadReply)
 			for err != nil {
 				for {
 					// rpc connection is interrupted, need to reconnect
@@ -233,19 +611,25 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 						break
 					}
 				}
-				err = coordClient.Call("CoordAPIMiner.Download", DownloadArgs{}, &downloadReply)
+				err = Call(coordClient, Scoped(m.ElectionID, "CoordAPIMiner.Download"), DownloadArgs{}, &downloadReply)
 			}
 		} else {
 			break
 		}
 	}
 
+	// m.mu is still held from before the API services started, so no request sees the pool half restored
+	m.restorePool()
+
 	// setup gossip client
 	log.Println("[INFO] Setting up gossip client...")
 	var existingUpdates []gossip.Update
-	blockchainData, _ := m.Blockchain.Encode()
-	for _, data := range blockchainData { // existing block updates
-		existingUpdates = append(existingUpdates, gossip.NewUpdate(BlockIDPrefix, blockchain.DecodeToBlock(data).Hash, data))
+	_, err = m.Blockchain.Export(func(hash []byte, data []byte) error { // existing block updates
+		existingUpdates = append(existingUpdates, gossip.NewUpdate(BlockIDPrefix, hash, data))
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	for _, txn := range m.MemoryPool.PendingTxns { // existing txn update from pool
 		existingUpdates = append(existingUpdates, gossip.NewUpdate(TransactionIDPrefix, txn.ID, txn.Serialize()))
@@ -266,7 +650,7 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 			}
 		}
 	}
-	queryChan, updateChan, gossipAddr, err := gossip.Start(
+	queryChan, updateChan, gossipAddr, err := m.gossip.Start(
 		2,
 		"PushPull",
 		minerIP,
@@ -283,14 +667,29 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 
 	// starting internal services
 	log.Println("[INFO] Starting routines...")
-	go m.TxnService()
-	go m.BlockService()
-	go m.MiningService()
+	m.services.Add(3)
+	go func() { defer m.services.Done(); m.TxnService() }()
+	go func() { defer m.services.Done(); m.BlockService() }()
+	go func() { defer m.services.Done(); m.MiningService() }()
+	if m.StorageDir != "" {
+		m.services.Add(1)
+		go func() { defer m.services.Done(); m.prunePoolStore() }()
+	}
 
 	log.Println("[INFO] Registering...")
+	signature, err := m.identity.Sign(m.Info)
+	if err != nil {
+		return err
+	}
+	registerArgs := RegisterArgs{Info: m.Info, PubKey: m.identity.PublicKey(), Signature: signature,
+		Persistent: m.identity.Saved}
 	reply := RegisterReply{}
-	err = coordClient.Call("CoordAPIMiner.Register", RegisterArgs{m.Info}, &reply)
+	registerArgs.SentAt = time.Now()
+	err = Call(coordClient, Scoped(m.ElectionID, "CoordAPIMiner.Register"), registerArgs, &reply)
 	for err != nil {
+		if _, rejected := err.(*RPCError); rejected {
+			return err
+		}
 		for {
 			// rpc connection is interrupted, need to reconnect
 			coordClient, err = util.NewRPCClient(minerAddr, coordAddr)
@@ -298,43 +697,147 @@ func (m *Miner) Start(minerId string, coordAddr string, minerAddr string, diffic
 				break
 			}
 		}
-		err = coordClient.Call("CoordAPIMiner.Register", RegisterArgs{m.Info}, &reply)
+		registerArgs.SentAt = time.Now()
+		err = Call(coordClient, Scoped(m.ElectionID, "CoordAPIMiner.Register"), registerArgs, &reply)
 	}
-	gossip.SetPeers(reply.PeerGossipAddrList)
+	logClockSkew(reply, registerArgs.SentAt, time.Now())
+	m.gossip.SetPeers(reply.PeerGossipAddrList)
+	m.knownMiners.set(reply.MinerKeys)
 
-	log.Printf("[INFO] %s joined successfully\n", minerId)
+	if reply.Returning {
+		log.Printf("[INFO] %s rejoined successfully\n", minerId)
+	} else {
+		log.Printf("[INFO] %s joined successfully\n", minerId)
+	}
 	m.start = true
 	m.cond.Broadcast()
 	m.mu.Unlock()
+	close(m.ready)
+
+	// catch up with the blocks mined since Download
+	if !m.Blockchain.Exist(reply.LastHash) {
+		go m.catchUp(coordClient, reply.Height)
+	}
 
 	// receive update from peers and notify respective service
 	for {
 		select {
 		case update := <-queryChan:
 			if strings.Contains(update.ID, BlockIDPrefix) {
-				m.BlockRecvChan <- blockchain.DecodeToBlock(update.Data)
+				block, err := blockchain.DecodeBlock(update.Data)
+				if err != nil {
+					log.Printf("[WARN] Dropped malformed block from %s: %v\n", update.ID, err)
+					continue
+				}
+				m.BlockRecvChan <- block
 			} else if strings.Contains(update.ID, TransactionIDPrefix) {
-				txn := blockchain.DeserializeTransaction(update.Data)
+				txn, err := blockchain.DecodeTransaction(update.Data)
+				if err != nil {
+					log.Printf("[WARN] Dropped malformed txn from %s: %v\n", update.ID, err)
+					continue
+				}
+				if err := txn.CheckShape(); err != nil {
+					log.Printf("[WARN] Dropped gossiped txn %x: %v\n", txn.ID, err)
+					continue
+				}
 				m.TxnRecvChan <- &(txn)
 			}
+		case height := <-m.backfill:
+			if atomic.CompareAndSwapInt32(&m.catchingUp, 0, 1) {
+				go m.catchUp(coordClient, height)
+			}
+		case <-m.quit:
+			// wait for services to finish with the database before it is closed
+			m.services.Wait()
+			return nil
+		}
+	}
+}
+
+// catchUp fetches the blocks the miner does not have up to height from coord and handles them as if they
+// came from peers
+func (m *Miner) catchUp(coordClient *rpc.Client, height uint32) {
+	atomic.StoreInt32(&m.catchingUp, 1)
+	defer atomic.StoreInt32(&m.catchingUp, 0)
+	knownHashes, err := m.Blockchain.Hashes()
+	if err != nil {
+		log.Println("[ERROR] Unable to list the stored blocks:", err)
+		return
+	}
+	blocks, err := DownloadChain(coordClient, m.ElectionID, height, knownHashes)
+	if err != nil {
+		log.Println("[WARN] Unable to catch up with coord, waiting for gossip:", err)
+		return
+	}
+	for _, data := range blocks {
+		block, err := blockchain.DecodeBlock(data)
+		if err != nil {
+			log.Println("[WARN] Unable to decode a block from coord:", err)
+			return
+		}
+		select {
+		case m.BlockRecvChan <- block:
+		case <-m.quit:
+			return
 		}
 	}
-	return nil
+}
+
+// initStorage opens the database and returns whether it holds a blockchain from a previous run
+func (m *Miner) initStorage() (resume bool) {
+	if m.StorageDir == "" {
+		err := m.Storage.New("", true)
+		util.CheckErr(err, "error when creating database")
+		if m.RestoreFrom != "" {
+			err = m.Storage.RestoreFromFile(m.RestoreFrom)
+			util.CheckErr(err, "error when restoring database from %s", m.RestoreFrom)
+			return m.Storage.KeyExist(blockchain.LastHashKey)
+		}
+		return false
+	}
+	if m.RestoreFrom != "" {
+		// replace whatever is on disk with the backup
+		err := os.RemoveAll(m.StorageDir)
+		util.CheckErr(err, "error when removing old database")
+		err = m.Storage.New(m.StorageDir, false)
+		util.CheckErr(err, "error when creating database")
+		err = m.Storage.RestoreFromFile(m.RestoreFrom)
+		util.CheckErr(err, "error when restoring database from %s", m.RestoreFrom)
+		log.Println("[INFO] Restored database from", m.RestoreFrom)
+		return m.Storage.KeyExist(blockchain.LastHashKey)
+	}
+	if _, err := os.Stat(m.StorageDir); err == nil {
+		err = m.Storage.Load(m.StorageDir)
+		util.CheckErr(err, "error when reloading database")
+		return m.Storage.KeyExist(blockchain.LastHashKey)
+	} else if !os.IsNotExist(err) {
+		util.CheckErr(err, "OS error")
+	}
+	err := m.Storage.New(m.StorageDir, false)
+	util.CheckErr(err, "error when creating database")
+	return false
 }
 
 func (m *Miner) TxnService() {
 	for !m.start {
 	}
 	for {
-		txn := <-m.TxnRecvChan
+		var txn *blockchain.Transaction
+		select {
+		case txn = <-m.TxnRecvChan:
+		case <-m.quit:
+			return
+		}
 		m.mu.Lock()
 		sid := string(txn.ID)
-		// check if the txn is unseen
-		if !m.ReceivedTxns[sid] {
+		// check if the txn is unseen. resubmitted copies of a pending or mined txn are dropped
+		if !m.ReceivedTxns[sid] && !m.MemoryPool.Has(txn.ID) {
 			// add unseen txn to pool
 			m.ReceivedTxns[sid] = true
 			m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns, *txn)
+			m.persistTxn(txn)
 			log.Printf("[INFO] Pool size %d (receive txn)\n", len(m.MemoryPool.PendingTxns))
+			m.Events.Publish(events.Event{Topic: events.NewTxn, Txn: txn})
 		}
 		m.mu.Unlock()
 	}
@@ -344,74 +847,147 @@ func (m *Miner) BlockService() {
 	for !m.start {
 	}
 	for {
-		block := <-m.BlockRecvChan
-		// verify proof of work
-		pow := blockchain.NewProof(block)
-		if pow.Validate() {
-			m.mu.Lock()
-			prevLastHash := m.Blockchain.GetLastHash()
-			success, newTxns, oldTxns := m.Blockchain.Put(*block, false)
-			curLastHash := m.Blockchain.GetLastHash()
-			if success {
-				if newTxns == nil { // no fork switching
-					if bytes.Compare(prevLastHash, curLastHash) != 0 {
-						// new block is on the current chain
-						log.Printf("[INFO] New block (%x) from peers is added to the current chain\n", block.Hash[:5])
-						blockchain.PrintBlock(block)
-						// remove new block's txns from pool
-						for i := 0; i < len(m.MemoryPool.PendingTxns); {
-							rm := false
-							for j := 0; j < len(block.Txns); j++ {
-								if bytes.Compare(m.MemoryPool.PendingTxns[i].ID, block.Txns[j].ID) == 0 {
-									rm = true
-								}
-							}
-							if rm {
-								m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
-							} else {
-								i++
-							}
-						}
-						log.Printf("[INFO] Pool size %d (remove included txns)\n", len(m.MemoryPool.PendingTxns))
-						// notify mining service of new last hash
-						m.ChainUpdatedChan <- 1
-					} else {
-						// new block is not on the current chain, just ignore it
-						log.Printf("[INFO] New block (%x) from peers is added to an alternative fork\n", block.Hash[:5])
-						blockchain.PrintBlock(block)
+		var block *blockchain.Block
+		select {
+		case block = <-m.BlockRecvChan:
+		case <-m.quit:
+			return
+		}
+		// blocks queue up when syncing a chain segment from peers. validate them as a batch
+		batch := []blockchain.Block{*block}
+		for queued := true; queued && len(batch) < MaxBlockBatch; {
+			select {
+			case block = <-m.BlockRecvChan:
+				batch = append(batch, *block)
+			default:
+				queued = false
+			}
+		}
+		m.mu.Lock()
+		prevLastHash := m.Blockchain.GetLastHash()
+		for len(batch) > 0 {
+			// orphans whose parent was just put are put in the next round
+			var reconnected []blockchain.Block
+			for i, result := range m.Blockchain.PutBatch(batch) {
+				m.handleBlock(&batch[i], result, prevLastHash)
+				prevLastHash = result.LastHash
+				if result.Added() {
+					reconnected = append(reconnected, m.orphans.take(batch[i].Hash)...)
+				}
+			}
+			if len(reconnected) > 0 {
+				log.Printf("[INFO] Reconnecting %d orphan blocks, %d left\n", len(reconnected), m.orphans.len())
+			}
+			batch = reconnected
+		}
+		m.mu.Unlock()
+	}
+}
+
+// handleBlock updates the pool and notifies mining after a block from peers is put. Must hold m.mu
+func (m *Miner) handleBlock(block *blockchain.Block, result blockchain.PutResult, prevLastHash []byte) {
+	if !result.Added() {
+		if result.Status.Invalid() {
+			// the MinerID of a block that is not signed by that miner may be anyone's
+			if result.Attributed {
+				m.Peers.Penalize(block.MinerID, InvalidBlockPenalty, fmt.Sprintf("invalid block #%d (%x)", block.BlockNum, block.Hash[:5]))
+			}
+		} else if result.Status == blockchain.PutOrphan {
+			// the parent is stored if a block further down the fork is missing. wait for that one instead
+			missing := block.PrevHash
+			if err, ok := result.Err.(*blockchain.MissingBlockError); ok {
+				missing = err.Hash
+			}
+			if m.orphans.add(block, missing) {
+				// fetch the missing ancestors from coord. a backfill already requested or running covers this one
+				select {
+				case m.backfill <- block.BlockNum:
+				default:
+				}
+			}
+		}
+		return
+	}
+	curLastHash := result.LastHash
+	m.Events.Publish(events.Event{
+		Topic:          events.NewBlock,
+		Block:          block,
+		OnLongestChain: bytes.Compare(curLastHash, block.Hash) == 0,
+	})
+	if result.NewTxns == nil { // no fork switching
+		if bytes.Compare(prevLastHash, curLastHash) != 0 {
+			// new block is on the current chain
+			log.Printf("[INFO] New block (%x) from peers is added to the current chain\n", block.Hash[:5])
+			blockchain.PrintBlock(block)
+			m.markReceived(block.Txns)
+			// remove new block's txns from pool
+			for i := 0; i < len(m.MemoryPool.PendingTxns); {
+				rm := false
+				for j := 0; j < len(block.Txns); j++ {
+					if bytes.Compare(m.MemoryPool.PendingTxns[i].ID, block.Txns[j].ID) == 0 {
+						rm = true
 					}
+				}
+				if rm {
+					m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
 				} else {
-					// new longest chain!
-					log.Printf("[INFO] New block (%x) from peers is added to an alternative branch\n", block.Hash[:5])
-					blockchain.PrintBlock(block)
-					log.Println("[INFO] Switching to a new chain")
-					// first, prepend old txns that get kicked out b.c. it is not on the longest chain anymore
-					for i := len(oldTxns) - 1; i >= 0; i-- {
-						m.MemoryPool.PendingTxns = append([]blockchain.Transaction{*oldTxns[i]}, m.MemoryPool.PendingTxns...)
-					}
-					// then, remove new transactions in the new fork from pool
-					// this includes the txns that are in the new block
-					// NOTE: this must be done second as there may be overlap between the two sets of txns
-					for i := 0; i < len(m.MemoryPool.PendingTxns); {
-						rm := false
-						for j := 0; j < len(newTxns); j++ {
-							if bytes.Compare(m.MemoryPool.PendingTxns[i].ID, newTxns[j].ID) == 0 {
-								rm = true
-							}
-						}
-						if rm {
-							m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
-						} else {
-							i++
-						}
-					}
-					log.Printf("[INFO] Pool size %d (switch fork)\n", len(m.MemoryPool.PendingTxns))
-					// notify mining service of new last hash
-					m.ChainUpdatedChan <- 1
+					i++
+				}
+			}
+			log.Printf("[INFO] Pool size %d (remove included txns)\n", len(m.MemoryPool.PendingTxns))
+			// notify mining service of new last hash
+			m.ChainUpdatedChan <- 1
+		} else {
+			// new block is not on the current chain, just ignore it
+			log.Printf("[INFO] New block (%x) from peers is added to an alternative fork\n", block.Hash[:5])
+			blockchain.PrintBlock(block)
+		}
+	} else {
+		// new longest chain!
+		log.Printf("[INFO] New block (%x) from peers is added to an alternative branch\n", block.Hash[:5])
+		blockchain.PrintBlock(block)
+		log.Println("[INFO] Switching to a new chain")
+		m.Events.Publish(events.Event{
+			Topic:       events.ForkSwitch,
+			OldLastHash: prevLastHash,
+			NewLastHash: curLastHash,
+			NewTxns:     result.NewTxns,
+			OldTxns:     result.OldTxns,
+		})
+		RecordAction(m.trace, ForkSwitch{
+			Node:        m.Info.MinerId,
+			OldLastHash: prevLastHash,
+			NewLastHash: curLastHash,
+			NumNewTxns:  len(result.NewTxns),
+			NumOldTxns:  len(result.OldTxns),
+		})
+		m.markReceived(result.NewTxns)
+		// first, prepend old txns that get kicked out b.c. it is not on the longest chain anymore
+		for i := len(result.OldTxns) - 1; i >= 0; i-- {
+			if !m.MemoryPool.Has(result.OldTxns[i].ID) {
+				m.MemoryPool.PendingTxns = append([]blockchain.Transaction{*result.OldTxns[i]}, m.MemoryPool.PendingTxns...)
+				m.persistTxn(result.OldTxns[i])
+			}
+		}
+		// then, remove new transactions in the new fork from pool
+		// this includes the txns that are in the new block
+		// NOTE: this must be done second as there may be overlap between the two sets of txns
+		for i := 0; i < len(m.MemoryPool.PendingTxns); {
+			rm := false
+			for j := 0; j < len(result.NewTxns); j++ {
+				if bytes.Compare(m.MemoryPool.PendingTxns[i].ID, result.NewTxns[j].ID) == 0 {
+					rm = true
 				}
 			}
-			m.mu.Unlock()
+			if rm {
+				m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
+			} else {
+				i++
+			}
 		}
+		log.Printf("[INFO] Pool size %d (switch fork)\n", len(m.MemoryPool.PendingTxns))
+		// notify mining service of new last hash
+		m.ChainUpdatedChan <- 1
 	}
 }
 
@@ -427,88 +1003,46 @@ func (m *Miner) MiningService() {
 			{
 				newCycle = true
 			}
+		case <-m.quit:
+			return
 		default:
 			{
+				if !m.mayMine() {
+					m.Clock.Sleep(blockchain.MiningDelay)
+					continue
+				}
 				if newCycle {
 					// start a new mining cycle
 					m.mu.Lock() // lock to prevent new block put or new txn
-					cycleStartTime = time.Now()
+					cycleStartTime = m.Clock.Now()
 					newCycle = false
-					prevHash := m.Blockchain.GetLastHash()
-					// select txns from pool
-					selectedTxns := m.selectTxns()
-					// validate txns
-					valids := m.Blockchain.ValidateTxns(selectedTxns)
-					var validatedTxns []*blockchain.Transaction
-					var invalidTxid [][]byte
-					// only include valid txns
-					for idx, valid := range valids {
-						if valid {
-							validatedTxns = append(validatedTxns, selectedTxns[idx])
-						} else {
-							invalidTxid = append(invalidTxid, selectedTxns[idx].ID)
-						}
-					}
-					// remove invalid txns from pool
-					for i := 0; i < len(m.MemoryPool.PendingTxns) && len(invalidTxid) > 0; {
-						if bytes.Compare(invalidTxid[0], m.MemoryPool.PendingTxns[i].ID) == 0 {
-							invalidTxid = invalidTxid[1:]
-							m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
-						} else {
-							i++
-						}
-					}
-					log.Printf("[INFO] Pool size %d (remove invalid txns)\n", len(m.MemoryPool.PendingTxns))
-					// construct current block
-					height := m.Blockchain.Get(m.Blockchain.GetLastHash()).BlockNum + 1
-					block := blockchain.Block{
-						PrevHash: prevHash,
-						BlockNum: height,
-						Nonce:    0,
-						Txns:     validatedTxns,
-						MinerID:  m.Info.MinerId,
-						Hash:     []byte{},
-					}
+					block := m.nextBlock(cycleStartTime)
 					// create a proof of work instance
-					pow = *blockchain.NewProof(&block)
+					pow = *blockchain.NewProofWithDifficulty(&block, m.Blockchain.BlockDifficulty())
+					pow.Clock = m.Clock
 					m.mu.Unlock()
 				} else {
 					// continue mining
-					if pow.Next(true) { // new block mined
+					if m.mineRound(&pow) { // new block mined
 						m.mu.Lock() // lock to prevent concurrent chain update and other things
 						// if there is already a chain update, just discard the new block. Otherwise, safe to put
 						if len(m.ChainUpdatedChan) == 0 { // no chain update
 							block := *pow.Block
+							if err := block.SignMiner(m.identity.Key); err != nil {
+								log.Println("[WARN] Unable to sign the mined block:", err)
+							}
 
 							// try to put new block
-							success, newTxns, oldTxns := m.Blockchain.Put(block, true)
+							result := m.Blockchain.Put(block, true)
 							// if there is no chain update since the start of this mining cycle, then fork switch impossible
-							if newTxns != nil || oldTxns != nil { // sanity check
-								log.Println("[WARN] Local put causes unexpected fork switch")
+							if result.Status != blockchain.PutExtended && result.Added() { // sanity check
+								log.Printf("[WARN] Local put causes unexpected %v\n", result.Status)
 							}
-							if success {
-								elapsed := time.Since(cycleStartTime).Seconds()
+							if result.Added() {
+								m.minedBlock()
+								elapsed := m.Clock.Now().Sub(cycleStartTime).Seconds()
 								log.Printf("[INFO] New block (%x) mined in %v seconds\n", block.Hash[:5], elapsed)
-								blockchain.PrintBlock(&block)
-								// broadcast it first!
-								m.updateChan <- gossip.NewUpdate(BlockIDPrefix, block.Hash, block.Encode())
-
-								// remove included txns from pending pool
-								for i := 0; i < len(m.MemoryPool.PendingTxns); {
-									rm := false
-									for j := 0; j < len(block.Txns); j++ {
-										if bytes.Compare(m.MemoryPool.PendingTxns[i].ID, block.Txns[j].ID) == 0 {
-											rm = true
-											break
-										}
-									}
-									if rm {
-										m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
-									} else {
-										i++
-									}
-								}
-								log.Printf("[INFO] Pool size %d (remove included txns)\n", len(m.MemoryPool.PendingTxns))
+								m.publishMined(&block)
 							}
 						}
 						m.mu.Unlock()
@@ -520,36 +1054,149 @@ func (m *Miner) MiningService() {
 	}
 }
 
-func (m *Miner) selectTxns() (selectedTxn []*blockchain.Transaction) {
-	for i := 0; i < int(math.Min(float64(m.MaxTxn), float64(len(m.MemoryPool.PendingTxns)))); i++ {
-		txn := m.MemoryPool.PendingTxns[i] // make a copy first. avoid pointing to the slot in slice.
-		selectedTxn = append(selectedTxn, &txn)
+// nextBlock builds the block to mine on top of the longest chain from the pending txns. Must hold m.mu
+func (m *Miner) nextBlock(now time.Time) blockchain.Block {
+	prevHash := m.Blockchain.GetLastHash()
+	prevBlock := m.Blockchain.Get(prevHash)
+	timestamp := now.Unix()
+	if timestamp < prevBlock.Timestamp {
+		timestamp = prevBlock.Timestamp
+	}
+	closed := m.Blockchain.Closed(m.Blockchain.ChainTime(prevHash))
+	if closed && len(m.MemoryPool.PendingTxns) > 0 {
+		// ballots can no longer be included in any block, only the release of the sealing key
+		var unseals []blockchain.Transaction
+		for _, txn := range m.MemoryPool.PendingTxns {
+			if txn.Unseals() {
+				unseals = append(unseals, txn)
+			}
+		}
+		if len(unseals) < len(m.MemoryPool.PendingTxns) {
+			log.Println("[INFO] Election is closed, dropping pending txns")
+			m.MemoryPool.PendingTxns = unseals
+		}
+	}
+	// select txns from pool. the sealing key is only released after the deadline
+	var selectedTxns []*blockchain.Transaction
+	for _, txn := range m.selectTxns() {
+		if txn.Unseals() == closed {
+			selectedTxns = append(selectedTxns, txn)
+		}
+	}
+	// validate txns
+	valids := m.Blockchain.ValidateTxns(selectedTxns)
+	var validatedTxns []*blockchain.Transaction
+	invalidTxid := make(map[string]bool)
+	// only include valid txns
+	for idx, valid := range valids {
+		if valid {
+			validatedTxns = append(validatedTxns, selectedTxns[idx])
+		} else {
+			invalidTxid[string(selectedTxns[idx].ID)] = true
+		}
+	}
+	// remove invalid txns from pool. selected txns need not be at the front of the pool
+	for i := 0; i < len(m.MemoryPool.PendingTxns) && len(invalidTxid) > 0; {
+		if invalidTxid[string(m.MemoryPool.PendingTxns[i].ID)] {
+			m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
+		} else {
+			i++
+		}
+	}
+	log.Printf("[INFO] Pool size %d (remove invalid txns)\n", len(m.MemoryPool.PendingTxns))
+	// construct current block
+	return blockchain.Block{
+		PrevHash:  prevHash,
+		BlockNum:  prevBlock.BlockNum + 1,
+		Nonce:     0,
+		Timestamp: timestamp,
+		Txns:      validatedTxns,
+		MinerID:   m.Info.MinerId,
+		HashAlgo:  prevBlock.HashAlgo,
+		Hash:      []byte{},
 	}
-	return
+}
+
+// publishMined broadcasts a block mined by this miner that has been put and removes its txns from the
+// pool. Must hold m.mu
+func (m *Miner) publishMined(block *blockchain.Block) {
+	blockchain.PrintBlock(block)
+	m.Events.Publish(events.Event{Topic: events.NewBlock, Block: block, OnLongestChain: true})
+	RecordAction(m.trace, BlockMined{
+		Hash:     block.Hash,
+		BlockNum: block.BlockNum,
+		MinerID:  block.MinerID,
+		NumTxns:  len(block.Txns),
+	})
+	// broadcast it first!
+	m.updateChan <- gossip.NewUpdate(BlockIDPrefix, block.Hash, block.Encode())
+
+	// remove included txns from pending pool
+	for i := 0; i < len(m.MemoryPool.PendingTxns); {
+		rm := false
+		for j := 0; j < len(block.Txns); j++ {
+			if bytes.Compare(m.MemoryPool.PendingTxns[i].ID, block.Txns[j].ID) == 0 {
+				rm = true
+				break
+			}
+		}
+		if rm {
+			m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns[:i], m.MemoryPool.PendingTxns[i+1:]...)
+		} else {
+			i++
+		}
+	}
+	log.Printf("[INFO] Pool size %d (remove included txns)\n", len(m.MemoryPool.PendingTxns))
+}
+
+// markReceived marks txns of the longest chain as seen, so resubmitted copies are dropped. Txns of a block on a
+// side fork are not: a copy resubmitted while the fork is behind must reach the pool again. Must hold m.mu
+func (m *Miner) markReceived(txns []*blockchain.Transaction) {
+	for _, txn := range txns {
+		m.ReceivedTxns[string(txn.ID)] = true
+	}
+}
+
+// listen serves handler at any free port of ip and keeps the listener for Stop
+func (m *Miner) listen(handler interface{}, ip string) (string, error) {
+	listener, err := util.ListenRPC(handler, util.AnyPort(ip))
+	if err != nil {
+		return "", err
+	}
+	m.listeners = append(m.listeners, listener)
+	return util.ListenerAddr(ip, listener), nil
+}
+
+func (m *Miner) selectTxns() []*blockchain.Transaction {
+	return m.MemoryPool.Select(int(m.MaxTxn), m.PoolOrder)
 }
 
 func (m *Miner) updateBlockChainAndTxnPool(block blockchain.Block, own bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	success, newTxn, oldTxn := m.Blockchain.Put(block, own)
-	if success {
+	result := m.Blockchain.Put(block, own)
+	newTxn, oldTxn := result.NewTxns, result.OldTxns
+	if result.Added() {
 		// the block has been added to the blockchain
 		existID := make(map[string]bool)
-		for _, txn := range block.Txns {
-			existID[string(txn.ID)] = true
-			m.ReceivedTxns[string(txn.ID)] = true
+		if bytes.Equal(result.LastHash, block.Hash) {
+			for _, txn := range block.Txns {
+				existID[string(txn.ID)] = true
+			}
+			m.markReceived(block.Txns)
 		}
 		if newTxn != nil && oldTxn != nil {
 			// switched fork
 			for _, txn := range newTxn {
 				existID[string(txn.ID)] = true
-				m.ReceivedTxns[string(txn.ID)] = true
 			}
+			m.markReceived(newTxn)
 			// add uncommitted txns to pool
 			for _, txn := range oldTxn {
 				if !existID[string(txn.ID)] {
 					m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns, *txn)
 					m.ReceivedTxns[string(txn.ID)] = true
+					m.persistTxn(txn)
 				}
 			}
 		}
@@ -568,8 +1215,23 @@ type MinerAPICoord struct {
 	m *Miner
 }
 
-func (api *MinerAPICoord) NotifyPeerList(args NotifyPeerListArgs, reply *NotifyPeerListReply) error {
-	gossip.SetPeers(args.PeerGossipAddrList)
+func (api *MinerAPICoord) NotifyPeerList(args NotifyPeerListArgs, reply *NotifyPeerListReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPICoord.NotifyPeerList", &err)()
+	api.m.gossip.SetPeers(args.PeerGossipAddrList)
+	api.m.knownMiners.set(args.MinerKeys)
+	return nil
+}
+
+// GetLoad reports how busy the miner is, for coord to hint clients
+func (api *MinerAPICoord) GetLoad(args GetLoadArgs, reply *GetLoadReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPICoord.GetLoad", &err)()
+	api.m.mu.Lock()
+	defer api.m.mu.Unlock()
+	reply.PoolSize = len(api.m.MemoryPool.PendingTxns)
+	reply.Height = api.m.Blockchain.GetHeader(api.m.Blockchain.GetLastHash()).BlockNum
+	reply.Throttle = api.m.throttle()
 	return nil
 }
 
@@ -579,11 +1241,15 @@ type MinerAPIMiner struct {
 	m *Miner
 }
 
-func (api *MinerAPIMiner) GetBlock(args GetBlockArgs, reply *GetBlockReply) error {
+func (api *MinerAPIMiner) GetBlock(args GetBlockArgs, reply *GetBlockReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIMiner.GetBlock", &err)()
 	return nil
 }
 
-func (api *MinerAPIMiner) GetTxnPool(args GetTxnPoolArgs, reply *GetTxnPoolReply) error {
+func (api *MinerAPIMiner) GetTxnPool(args GetTxnPoolArgs, reply *GetTxnPoolReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIMiner.GetTxnPool", &err)()
 	reply.PeerTxnPool = api.m.MemoryPool
 	return nil
 }
@@ -594,8 +1260,59 @@ type MinerAPIClient struct {
 	m *Miner
 }
 
-// SubmitTxn is for client to submit a transaction. This function is non-blocking.
-func (api *MinerAPIClient) SubmitTxn(args SubmitTxnArgs, reply *SubmitTxnReply) error {
+// SubmitTxn is for client to submit a transaction. This function is non-blocking. Txns that fail validation
+// are not taken, see SubmitTxnReply
+func (api *MinerAPIClient) SubmitTxn(args SubmitTxnArgs, reply *SubmitTxnReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIClient.SubmitTxn", &err)()
+	if busy := api.m.syncing(); busy != nil {
+		return busy
+	}
+	if closed := api.m.Blockchain.Closed(api.m.Clock.Now().Unix()); closed != args.Txn.Unseals() {
+		if closed {
+			return ErrElectionClosed
+		}
+		return ErrElectionOpen
+	}
+	if err := args.Txn.CheckShape(); err != nil {
+		return err
+	}
+	api.m.mu.Lock()
+	if api.m.MaxPoolSize > 0 && len(api.m.MemoryPool.PendingTxns) >= api.m.MaxPoolSize {
+		api.m.mu.Unlock()
+		return &BusyError{Reason: "memory pool is full", RetryAfter: PoolRetryAfter}
+	}
+	pending := make([]*blockchain.Transaction, len(api.m.MemoryPool.PendingTxns))
+	for i := range api.m.MemoryPool.PendingTxns {
+		pending[i] = &api.m.MemoryPool.PendingTxns[i]
+	}
+	check := api.m.Blockchain.CheckTxn(&args.Txn, pending)
+	api.m.mu.Unlock()
+	*reply = SubmitTxnReply{
+		Accepted:       check.Err == nil,
+		ValidSignature: check.ValidSignature,
+		VoterEligible:  !check.Candidate && !check.Voted,
+		ValidBallot:    check.ValidBallot,
+		Duplicate:      check.Duplicate,
+		OtherElection:  check.OtherElection,
+		Height:         api.m.Blockchain.GetHeader(api.m.Blockchain.GetLastHash()).BlockNum,
+	}
+	if check.Err != nil {
+		reply.Reason = check.Err.Error()
+		return &RPCError{Code: rejectionCode(check), Message: reply.Reason}
+	}
+	// store the txn before replying, so that an accepted ballot survives a crash before it is mined
+	api.m.mu.Lock()
+	if err := api.m.takeBallotQuota(args.ClientID); err != nil {
+		api.m.mu.Unlock()
+		reply.Accepted, reply.Reason = false, err.Error()
+		return err
+	}
+	api.m.persistTxn(&args.Txn)
+	api.m.mu.Unlock()
+	api.m.metrics.txnsSubmitted.Inc()
+	trace := ReceiveToken(api.m.tracer, args.Token)
+	RecordAction(trace, TxnAcceptedByMiner{TxID: args.Txn.ID, MinerID: api.m.Info.MinerId, ClientID: args.ClientID})
 	// internal processing
 	api.m.TxnRecvChan <- &(args.Txn)
 	// broadcast
@@ -603,3 +1320,59 @@ func (api *MinerAPIClient) SubmitTxn(args SubmitTxnArgs, reply *SubmitTxnReply)
 
 	return nil
 }
+
+// GetHeaders returns the headers of the longest chain starting at FromHeight, for light clients
+func (api *MinerAPIClient) GetHeaders(args GetHeadersArgs, reply *GetHeadersReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIClient.GetHeaders", &err)()
+	*reply = GetHeadersReply{Headers: api.m.Blockchain.Headers(args.FromHeight)}
+	return nil
+}
+
+// GetBlockBody returns the transactions of a block, e.g. after syncing its header with GetHeaders
+func (api *MinerAPIClient) GetBlockBody(args GetBlockBodyArgs, reply *GetBlockBodyReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIClient.GetBlockBody", &err)()
+	*reply = blockBodyReply(api.m.Blockchain, args.Hash)
+	return nil
+}
+
+// GetTxnProof returns a transaction on the longest chain with the Merkle proof of its inclusion in its block
+func (api *MinerAPIClient) GetTxnProof(args GetTxnProofArgs, reply *GetTxnProofReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIClient.GetTxnProof", &err)()
+	*reply = txnProofReply(api.m.Blockchain, args.TxID)
+	return nil
+}
+
+// QueryResults tallies the miner's longest chain like CoordAPIClient.QueryResults, so clients can cross-check
+// coord's tally
+func (api *MinerAPIClient) QueryResults(args QueryResultsArgs, reply *QueryResultsReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIClient.QueryResults", &err)()
+	*reply, err = resultsReply(api.m.Blockchain, args.At, args.Strict)
+	return err
+}
+
+// ----- APIs for admin -----
+
+type MinerAPIAdmin struct {
+	m *Miner
+}
+
+// GetQuarantine returns the misbehavior scores of peers
+func (api *MinerAPIAdmin) GetQuarantine(args GetQuarantineArgs, reply *GetQuarantineReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.GetQuarantine", &err)()
+	reply.Peers = api.m.Peers.List()
+	return nil
+}
+
+// ClearQuarantine forgets the score of a peer
+func (api *MinerAPIAdmin) ClearQuarantine(args ClearQuarantineArgs, reply *ClearQuarantineReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.ClearQuarantine", &err)()
+	reply.Cleared = api.m.Peers.Clear(args.MinerID)
+	log.Printf("[INFO] Cleared the scores of %d peers\n", reply.Cleared)
+	return nil
+}
@@ -0,0 +1,113 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"log"
+)
+
+// MaxOrphanBlocks is the most blocks from peers kept while their parent is missing. the oldest is dropped beyond it
+const MaxOrphanBlocks = 256
+
+// MaxOrphansPerMiner is the most orphans of one MinerID kept. the oldest of the miner is dropped beyond it, so a
+// miner flooding orphans only pushes out its own. Put checks the proof of work and the miner's signature of an
+// orphan, so nobody fills the share of another miner
+const MaxOrphansPerMiner = 32
+
+// orphanPool keeps blocks whose parent, or a block of the fork they extend, is not known yet, so they can be put
+// once that block arrives instead of waiting for them to be gossiped again. Not safe for concurrent use, the
+// miner guards it with mu.
+type orphanPool struct {
+	blocks   map[string]*blockchain.Block // orphans by hash
+	missing  map[string]string            // hash of the block each orphan waits for, by the orphan's hash
+	children map[string][]string          // hashes of the orphans waiting for each missing block
+	order    []string                     // hashes of the orphans, oldest first
+	perMiner map[string]int               // number of orphans of each MinerID
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		blocks:   make(map[string]*blockchain.Block),
+		missing:  make(map[string]string),
+		children: make(map[string][]string),
+		perMiner: make(map[string]int),
+	}
+}
+
+// add keeps block until the block with hash missing is put: its parent, or the block of its fork that is not
+// stored. returns false if it is kept already
+func (p *orphanPool) add(block *blockchain.Block, missing []byte) bool {
+	hash := string(block.Hash)
+	if _, ok := p.blocks[hash]; ok {
+		return false
+	}
+	if p.perMiner[block.MinerID] >= MaxOrphansPerMiner {
+		for _, h := range p.order {
+			if oldest := p.blocks[h]; oldest.MinerID == block.MinerID {
+				log.Printf("[INFO] Orphan pool holds %d blocks of %s, dropped block #%d (%x)\n", MaxOrphansPerMiner,
+					block.MinerID, oldest.BlockNum, oldest.Hash[:5])
+				p.remove(oldest)
+				break
+			}
+		}
+	}
+	if len(p.order) >= MaxOrphanBlocks {
+		oldest := p.blocks[p.order[0]]
+		log.Printf("[INFO] Orphan pool is full, dropped block #%d (%x)\n", oldest.BlockNum, oldest.Hash[:5])
+		p.remove(oldest)
+	}
+	p.blocks[hash] = block
+	p.perMiner[block.MinerID]++
+	p.missing[hash] = string(missing)
+	p.children[string(missing)] = append(p.children[string(missing)], hash)
+	p.order = append(p.order, hash)
+	return true
+}
+
+// take removes and returns the orphans waiting for the block with hash putHash, in arrival order
+func (p *orphanPool) take(putHash []byte) []blockchain.Block {
+	hashes := p.children[string(putHash)]
+	if len(hashes) == 0 {
+		return nil
+	}
+	blocks := make([]blockchain.Block, 0, len(hashes))
+	for _, hash := range hashes {
+		blocks = append(blocks, *p.blocks[hash])
+	}
+	for i := range blocks {
+		p.remove(&blocks[i])
+	}
+	return blocks
+}
+
+// len returns the number of orphans kept
+func (p *orphanPool) len() int {
+	return len(p.order)
+}
+
+func (p *orphanPool) remove(block *blockchain.Block) {
+	hash := string(block.Hash)
+	parent := p.missing[hash]
+	delete(p.blocks, hash)
+	delete(p.missing, hash)
+	if p.perMiner[block.MinerID]--; p.perMiner[block.MinerID] <= 0 {
+		delete(p.perMiner, block.MinerID)
+	}
+	siblings := p.children[parent]
+	for i := range siblings {
+		if siblings[i] == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.children, parent)
+	} else {
+		p.children[parent] = siblings
+	}
+	for i := range p.order {
+		if p.order[i] == hash {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package blockvote
+
+import (
+	"fmt"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+func TestOrphansPerMiner(t *testing.T) {
+	pool := newOrphanPool()
+	orphan := func(minerID string, i int) *blockchain.Block {
+		return &blockchain.Block{Hash: []byte(fmt.Sprintf("%s-%03d", minerID, i)), PrevHash: []byte("missing"), MinerID: minerID, BlockNum: 2}
+	}
+	pool.add(orphan("honest", 0), []byte("missing"))
+	for i := 0; i < MaxOrphanBlocks; i++ {
+		pool.add(orphan("flooder", i), []byte("missing"))
+	}
+	if pool.len() != MaxOrphansPerMiner+1 {
+		t.Fatalf("%d orphans kept, want %d of the flooder and the honest one", pool.len(), MaxOrphansPerMiner)
+	}
+	taken := pool.take([]byte("missing"))
+	if len(taken) != MaxOrphansPerMiner+1 || taken[0].MinerID != "honest" {
+		t.Fatalf("took %d orphans, the first of %q, want the honest one first", len(taken), taken[0].MinerID)
+	}
+	// the flooder keeps its newest orphans
+	if last := string(taken[len(taken)-1].Hash); last != fmt.Sprintf("flooder-%03d", MaxOrphanBlocks-1) {
+		t.Fatalf("newest orphan of the flooder is %s", last)
+	}
+	if pool.len() != 0 || len(pool.perMiner) != 0 {
+		t.Fatalf("pool keeps %d orphans of %d miners after they were taken", pool.len(), len(pool.perMiner))
+	}
+}
@@ -0,0 +1,106 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"log"
+	"time"
+)
+
+// PoolKeyPrefix keys the pending txns a miner keeps in its database, so that accepted ballots survive a restart
+const PoolKeyPrefix = "pool-"
+
+// PoolPruneInterval is how often stored txns that left the pool are removed from the database
+const PoolPruneInterval = 10 * time.Second
+
+// persistTxn stores a pending txn. Nothing is stored without a StorageDir, as the database does not outlive
+// the miner. The caller must hold m.mu
+func (m *Miner) persistTxn(txn *blockchain.Transaction) {
+	if m.StorageDir == "" {
+		return
+	}
+	if err := m.Storage.Put(util.DBKeyWithPrefix(PoolKeyPrefix, txn.ID), txn.Serialize()); err != nil {
+		log.Printf("[WARN] Unable to persist txn %x: %v\n", txn.ID, err)
+	}
+}
+
+// restorePool re-validates the txns stored by a previous run and queues the valid ones that are not pending
+// yet. Txns in the pool are stored as well. The caller must hold m.mu
+func (m *Miner) restorePool() {
+	if m.StorageDir == "" {
+		return
+	}
+	pendingIDs := m.MemoryPool.IDs()
+	pending := make([]*blockchain.Transaction, len(m.MemoryPool.PendingTxns))
+	for i := range m.MemoryPool.PendingTxns {
+		pending[i] = &m.MemoryPool.PendingTxns[i]
+		m.persistTxn(pending[i])
+	}
+	var stale [][]byte
+	restored := 0
+	iter := m.Storage.NewIterator(PoolKeyPrefix)
+	for iter.Next() {
+		key := iter.Key()
+		data, err := iter.Value()
+		if err != nil {
+			log.Printf("[WARN] Unable to read stored txn %s: %v\n", key, err)
+			stale = append(stale, key)
+			continue
+		}
+		txn, err := blockchain.DecodeTransaction(data)
+		if err != nil {
+			log.Printf("[WARN] Dropped malformed stored txn %s: %v\n", key, err)
+			stale = append(stale, key)
+			continue
+		}
+		if pendingIDs[string(txn.ID)] {
+			continue
+		}
+		if check := m.Blockchain.CheckTxn(&txn, pending); check.Err != nil {
+			log.Printf("[INFO] Dropped stored txn %x: %v\n", txn.ID, check.Err)
+			stale = append(stale, key)
+			continue
+		}
+		m.MemoryPool.PendingTxns = append(m.MemoryPool.PendingTxns, txn)
+		pendingIDs[string(txn.ID)] = true
+		pending = append(pending, &txn)
+		m.ReceivedTxns[string(txn.ID)] = true
+		restored++
+	}
+	iter.Close()
+	if len(stale) > 0 {
+		if err := m.Storage.RemoveMulti(stale); err != nil {
+			log.Println("[WARN] Unable to remove stored txns:", err)
+		}
+	}
+	log.Printf("[INFO] Pool size %d (restore %d stored txns)\n", len(m.MemoryPool.PendingTxns), restored)
+}
+
+// prunePoolStore removes the stored txns that were mined or dropped from the pool, every PoolPruneInterval
+func (m *Miner) prunePoolStore() {
+	ticker := time.NewTicker(PoolPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+		m.mu.Lock()
+		pendingIDs := m.MemoryPool.IDs()
+		var stale [][]byte
+		iter := m.Storage.NewIterator(PoolKeyPrefix)
+		for iter.Next() {
+			if key := iter.Key(); !pendingIDs[string(key[len(PoolKeyPrefix):])] {
+				stale = append(stale, key)
+			}
+		}
+		iter.Close()
+		if len(stale) > 0 {
+			if err := m.Storage.RemoveMulti(stale); err != nil {
+				log.Println("[WARN] Unable to remove stored txns:", err)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
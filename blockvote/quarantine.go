@@ -0,0 +1,95 @@
+package blockvote
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	InvalidBlockPenalty = 1 // penalty for a signed block rejected for its content (timestamp or txns)
+	QuarantineScore     = 3 // penalty score at which a miner is quarantined
+)
+
+// PeerScore is the misbehavior record of a miner
+type PeerScore struct {
+	MinerID     string
+	Score       int
+	Quarantined bool
+	Since       time.Time // when the miner was quarantined
+	LastReason  string
+}
+
+// PeerScores tracks miners that send invalid blocks. Blocks are attributed to the MinerID in their header only
+// if their proof of work holds and the miner's signature verifies, see blockchain.PutResult.Attributed. Blocks
+// carrying malformed txns are invalid blocks.
+type PeerScores struct {
+	mu     sync.Mutex
+	scores map[string]*PeerScore
+}
+
+func NewPeerScores() *PeerScores {
+	return &PeerScores{scores: make(map[string]*PeerScore)}
+}
+
+// Penalize adds penalty to the score of minerID and returns whether the miner just got quarantined
+func (p *PeerScores) Penalize(minerID string, penalty int, reason string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	score, ok := p.scores[minerID]
+	if !ok {
+		score = &PeerScore{MinerID: minerID}
+		p.scores[minerID] = score
+	}
+	score.Score += penalty
+	score.LastReason = reason
+	if !score.Quarantined && score.Score >= QuarantineScore {
+		score.Quarantined = true
+		score.Since = time.Now()
+		log.Printf("[WARN] Quarantined miner %s (score %d): %s\n", minerID, score.Score, reason)
+		return true
+	}
+	return false
+}
+
+// Quarantined checks whether minerID is quarantined
+func (p *PeerScores) Quarantined(minerID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	score, ok := p.scores[minerID]
+	return ok && score.Quarantined
+}
+
+// List returns the scores of all penalized miners, highest first
+func (p *PeerScores) List() []PeerScore {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	list := make([]PeerScore, 0, len(p.scores))
+	for _, score := range p.scores {
+		list = append(list, *score)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Score != list[j].Score {
+			return list[i].Score > list[j].Score
+		}
+		return list[i].MinerID < list[j].MinerID
+	})
+	return list
+}
+
+// Clear forgets the score of minerID, or of every miner if minerID is empty, and returns how many were cleared
+func (p *PeerScores) Clear(minerID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if minerID == "" {
+		n := len(p.scores)
+		p.scores = make(map[string]*PeerScore)
+		return n
+	}
+	if _, ok := p.scores[minerID]; !ok {
+		return 0
+	}
+	delete(p.scores, minerID)
+	return 1
+}
@@ -0,0 +1,60 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// rpcCaller is embedded in the args of rate limited RPCs. The server sets the address the call came in on, see
+// util.CallerAware. It is unexported, so it is not sent over the wire.
+type rpcCaller struct {
+	addr string
+}
+
+func (c *rpcCaller) SetCallerAddr(addr string) {
+	c.addr = addr
+}
+
+// limitClient takes a token of the client that made a CoordAPIClient call, and one of its client ID if it told
+// one. The returned error tells the client how long to wait. Calls not made over RPC have no caller and are not
+// limited. A call through coord's bridge is limited by the host of the client, not of the bridge
+func (c *Coord) limitClient(caller rpcCaller, clientID uint) error {
+	if caller.addr == "" {
+		return nil
+	}
+	host := util.CallerHost(caller.addr)
+	if source, ok := c.bridgeSources.Source(caller.addr); ok {
+		host = util.HostOf(source)
+	}
+	if ok, wait := c.clientLimiter.Allow(host); !ok {
+		return &RPCError{Code: CodeBusy, Message: fmt.Sprintf("rate limit of client %s exceeded", host), RetryAfter: wait}
+	}
+	if clientID == 0 {
+		return nil
+	}
+	if ok, wait := c.clientIDLimiter.Allow(strconv.FormatUint(uint64(clientID), 10)); !ok {
+		return &RPCError{Code: CodeBusy, Message: fmt.Sprintf("rate limit of client ID %d exceeded", clientID), RetryAfter: wait}
+	}
+	return nil
+}
+
+// takeBallotQuota counts a ballot accepted from clientID toward MaxClientBallots. Ballots of clients that did
+// not tell their ID are not counted. Called with mu held
+func (m *Miner) takeBallotQuota(clientID uint) error {
+	if clientID == 0 || m.MaxClientBallots <= 0 {
+		return nil
+	}
+	if m.clientBallots == nil {
+		m.clientBallots = make(map[uint]int)
+	}
+	if m.clientBallots[clientID] >= m.MaxClientBallots {
+		return &RPCError{Code: CodeQuotaExceeded, Message: fmt.Sprintf("client ID %d cast its %d ballots", clientID, m.MaxClientBallots)}
+	}
+	m.clientBallots[clientID]++
+	if m.clientBallots[clientID] == m.MaxClientBallots {
+		log.Printf("[INFO] Client ID %d reached its quota of %d ballots\n", clientID, m.MaxClientBallots)
+	}
+	return nil
+}
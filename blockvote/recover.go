@@ -0,0 +1,285 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Coord can rebuild a lost database from its miners instead of a backup: every miner in RecoverFrom serves its
+// chain over its admin API, coord checks each chain from its own genesis config and adopts the longest chain a
+// majority of the miners agree on. The candidates, the registrations of the miners and the keys blocks are
+// signed with come from them as well.
+
+type (
+	GetRecoveryInfoArgs struct {
+	}
+
+	GetRecoveryInfoReply struct {
+		RPCStatus
+		Registration RegisterArgs // the miner's info signed by its identity, as when it registers
+		Candidates   [][]byte
+		MinerKeys    map[string][]byte // key of every miner the miner knows, see GetMinerListReply.MinerKeys
+		LastHash     []byte
+		Height       uint32 // block number of LastHash. blocks are fetched with GetBlocks up to this height
+	}
+)
+
+// GetRecoveryInfo describes the miner and its chain, for coord to rebuild its database from
+func (api *MinerAPIAdmin) GetRecoveryInfo(_ GetRecoveryInfoArgs, reply *GetRecoveryInfoReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.GetRecoveryInfo", &err)()
+	if busy := api.m.syncing(); busy != nil {
+		return busy
+	}
+	signature, err := api.m.identity.Sign(api.m.Info)
+	if err != nil {
+		return err
+	}
+	var candidates [][]byte
+	for i := range api.m.Candidates {
+		candidates = append(candidates, api.m.Candidates[i].Encode())
+	}
+	lastHash := api.m.Blockchain.GetLastHash()
+	*reply = GetRecoveryInfoReply{
+		Registration: RegisterArgs{Info: api.m.Info, PubKey: api.m.identity.PublicKey(), Signature: signature},
+		Candidates:   candidates,
+		MinerKeys:    api.m.knownMiners.all(),
+		LastHash:     lastHash,
+		Height:       api.m.Blockchain.GetHeader(lastHash).BlockNum,
+	}
+	return nil
+}
+
+// GetBlocks returns one chunk of the miner's chain, like CoordAPIMiner.GetBlocks
+func (api *MinerAPIAdmin) GetBlocks(args GetBlocksArgs, reply *GetBlocksReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.GetBlocks", &err)()
+	if busy := api.m.syncing(); busy != nil {
+		return busy
+	}
+	*reply, err = blocksReply(api.m.Blockchain, args)
+	return err
+}
+
+// recoveredChain is the chain of one miner, checked in a scratch database
+type recoveredChain struct {
+	addr       string
+	info       GetRecoveryInfoReply
+	candidates []*Identity.Wallets
+	chain      *blockchain.BlockChain
+}
+
+// recoverFromMiners fills the empty database of coord from the miners at c.RecoverFrom. A block is adopted if
+// it is on the longest chain of a majority of them, so a minority of missing, stale or lying miners cannot
+// change the chain.
+func (c *Coord) recoverFromMiners() error {
+	quorum := len(c.RecoverFrom)/2 + 1
+	var fetched []*recoveredChain
+	for _, addr := range c.RecoverFrom {
+		source, err := c.fetchRecoveryInfo(addr)
+		if err != nil {
+			log.Printf("[WARN] Unable to recover from miner at %s: %v\n", addr, err)
+			continue
+		}
+		fetched = append(fetched, source)
+	}
+	minerKeys := agreedMinerKeys(fetched, quorum)
+	var sources []*recoveredChain
+	for _, source := range fetched {
+		if err := c.checkMinerChain(source, minerKeys); err != nil {
+			log.Printf("[WARN] Unable to recover from miner at %s: %v\n", source.addr, err)
+			continue
+		}
+		defer source.chain.DB.Close()
+		sources = append(sources, source)
+	}
+	if len(sources) < quorum {
+		return fmt.Errorf("%d of %d miners served a valid chain, %d needed", len(sources), len(c.RecoverFrom), quorum)
+	}
+
+	// two blocks of the same height cannot both be on a majority of chains, so the highest block is unique
+	count := make(map[string]int)
+	var tip *blockchain.BlockHeader
+	var from *recoveredChain
+	for _, source := range sources {
+		iter := source.chain.NewIterator(source.chain.GetLastHash())
+		for header, end := iter.NextHeader(); ; header, end = iter.NextHeader() {
+			count[string(header.Hash)]++
+			if count[string(header.Hash)] >= quorum && (tip == nil || header.BlockNum > tip.BlockNum) {
+				tip, from = header, source
+			}
+			if end {
+				break
+			}
+		}
+	}
+	if tip == nil {
+		return errors.New("no genesis block is shared by a majority of the miners")
+	}
+
+	// candidates
+	keys := [][]byte{util.DBKeyWithPrefix(NCandidatesKey, []byte{})}
+	values := [][]byte{[]byte(strconv.Itoa(len(from.info.Candidates)))}
+	for i, cand := range from.info.Candidates {
+		keys = append(keys, util.DBKeyWithPrefix(CandidateKeyPrefix, []byte(strconv.Itoa(i))))
+		values = append(values, cand)
+	}
+	if err := c.Storage.PutMulti(keys, values); err != nil {
+		return err
+	}
+
+	// blocks of the adopted chain. forks are not adopted, miners gossip them again
+	var blocks [][]byte
+	iter := from.chain.NewIterator(tip.Hash)
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		blocks = append(blocks, block.Encode())
+		if end {
+			break
+		}
+	}
+	adopted := blockchain.NewBlockChain(c.Storage, from.candidates)
+	if err := adopted.SetDifficulty(c.blockDifficulty()); err != nil {
+		return err
+	}
+	if err := adopted.ResumeFromEncodedData(blocks, tip.Hash); err != nil {
+		return err
+	}
+
+	// keys of the miners, then their registrations for InitNodeList
+	for minerID, key := range minerKeys {
+		if err := c.Storage.Put(util.DBKeyWithPrefix(MinerKeyPrefix, []byte(minerID)), key); err != nil {
+			return err
+		}
+	}
+	c.nlMu.Lock()
+	defer c.nlMu.Unlock()
+	for _, source := range sources {
+		registration := source.info.Registration
+		if _, err := c.checkIdentity(registration); err != nil {
+			log.Printf("[WARN] Rejected registration of %s: %v\n", registration.Info.MinerId, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(NodeInfo{Property: registration.Info}); err != nil {
+			return err
+		}
+		if err := c.Storage.Put(util.DBKeyWithPrefix(NodeKeyPrefix, []byte(registration.Info.MinerId)), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	log.Printf("[INFO] Recovered %d blocks up to #%d (%x) agreed on by %d of %d miners\n",
+		len(blocks), tip.BlockNum, tip.Hash[:5], count[string(tip.Hash)], len(c.RecoverFrom))
+	return nil
+}
+
+// agreedMinerKeys returns the key of every miner ID that at least quorum of the sources know it by. A minority
+// of lying miners cannot make coord take blocks signed with a key of their own
+func agreedMinerKeys(sources []*recoveredChain, quorum int) map[string][]byte {
+	count := make(map[string]map[string]int) // by miner ID, then key
+	for _, source := range sources {
+		for minerID, key := range source.info.MinerKeys {
+			if count[minerID] == nil {
+				count[minerID] = make(map[string]int)
+			}
+			count[minerID][string(key)]++
+		}
+	}
+	keys := make(map[string][]byte)
+	for minerID, byKey := range count {
+		for key, n := range byKey {
+			if n >= quorum && len(key) > 0 {
+				keys[minerID] = []byte(key)
+			}
+		}
+	}
+	return keys
+}
+
+// fetchRecoveryInfo asks the miner with the admin API at addr about itself and its chain
+func (c *Coord) fetchRecoveryInfo(addr string) (*recoveredChain, error) {
+	client, err := util.DialRPCWithToken(addr, c.AdminToken)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	source := &recoveredChain{addr: addr}
+	if err = Call(client, "MinerAPIAdmin.GetRecoveryInfo", GetRecoveryInfoArgs{}, &source.info); err != nil {
+		return nil, err
+	}
+	for _, cand := range source.info.Candidates {
+		source.candidates = append(source.candidates, Identity.DecodeToWallets(cand))
+	}
+	return source, nil
+}
+
+// checkMinerChain downloads the chain of source and puts every block into a scratch chain started from coord's
+// genesis config, which checks them as if they came from peers, signatures by minerKeys included
+func (c *Coord) checkMinerChain(source *recoveredChain, minerKeys map[string][]byte) (err error) {
+	client, err := util.DialRPCWithToken(source.addr, c.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	storage := &util.Database{}
+	if err = storage.New("", true); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			storage.Close()
+		}
+	}()
+	source.chain = blockchain.NewBlockChain(storage, source.candidates)
+	source.chain.MinerKey = func(minerID string) ([]byte, bool) {
+		key, ok := minerKeys[minerID]
+		return key, ok
+	}
+	genesis := c.Genesis
+	genesis.CandidateHash = blockchain.CandidateSetHash(source.candidates)
+	if err = source.chain.Init(genesis); err != nil {
+		return err
+	}
+	if err = source.chain.SetDeadline(c.ElectionEnd); err != nil {
+		return err
+	}
+	if err = source.chain.SetSealingKey(genesis.SealingKey); err != nil {
+		return err
+	}
+	if err = source.chain.SetRegistration(genesis.Registration); err != nil {
+		return err
+	}
+	if err = source.chain.SetDifficulty(c.blockDifficulty()); err != nil {
+		return err
+	}
+	encoded, err := downloadChain(client, "MinerAPIAdmin.GetBlocks", source.info.Height, [][]byte{source.chain.GenesisHash()})
+	if err != nil {
+		return err
+	}
+	blocks := make([]blockchain.Block, len(encoded))
+	for i, data := range encoded {
+		var block *blockchain.Block
+		if block, err = blockchain.DecodeBlock(data); err != nil {
+			return err
+		}
+		if block.BlockNum == 0 {
+			return fmt.Errorf("genesis block %x does not match the genesis config", block.Hash)
+		}
+		blocks[i] = *block
+	}
+	for i, result := range source.chain.PutBatch(blocks) {
+		if !result.Added() {
+			return fmt.Errorf("invalid block #%d (%x) (%v): %v", blocks[i].BlockNum, blocks[i].Hash[:5], result.Status, result.Err)
+		}
+	}
+	if !source.chain.Exist(source.info.LastHash) {
+		return fmt.Errorf("last hash %x is not on the served chain", source.info.LastHash)
+	}
+	return nil
+}
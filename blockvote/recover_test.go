@@ -0,0 +1,23 @@
+package blockvote
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAgreedMinerKeys(t *testing.T) {
+	source := func(keys map[string][]byte) *recoveredChain {
+		return &recoveredChain{info: GetRecoveryInfoReply{MinerKeys: keys}}
+	}
+	sources := []*recoveredChain{
+		source(map[string][]byte{"miner1": []byte("key1"), "miner2": []byte("key2")}),
+		source(map[string][]byte{"miner1": []byte("key1"), "miner2": []byte("key2"), "miner3": []byte("key3")}),
+		// a lying miner claims other keys
+		source(map[string][]byte{"miner1": []byte("forged"), "miner2": []byte("key2"), "miner4": []byte("key4")}),
+		source(nil),
+	}
+	want := map[string][]byte{"miner1": []byte("key1"), "miner2": []byte("key2")}
+	if keys := agreedMinerKeys(sources, 2); !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys agreed on by 2 miners %q, want %q", keys, want)
+	}
+}
@@ -0,0 +1,163 @@
+package blockvote
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// In an election with a registrar (see blockchain.Registration), voters register with coord before voting.
+// RegisterVoter asks the RegistrationVerifier of coord whether the student ID may vote, binds the ID to the
+// voter's public key for good and returns the credential of the key, which the voter attaches to its ballots.
+// Coord keeps the bindings under the salt of the voter index, so they are not a plaintext voter roll either.
+
+type (
+	RegisterVoterArgs struct {
+		StudentID string
+		Proof     []byte // that the caller is the student, checked by the RegistrationVerifier of coord
+		PublicKey []byte // key the voter signs its ballots with
+	}
+
+	RegisterVoterReply struct {
+		RPCStatus
+		Credential []byte // see blockchain.IssueCredential
+	}
+)
+
+var (
+	// ErrNoRegistrar is returned by RegisterVoter in an election without a registrar, where anyone may vote
+	ErrNoRegistrar = errors.New("the election has no voter registration")
+	// ErrVoterRejected is returned by RegisterVoter when the RegistrationVerifier does not accept the voter
+	ErrVoterRejected = errors.New("voter is not eligible or the proof is wrong")
+	// ErrAlreadyRegistered is returned by RegisterVoter when the student ID is bound to another key
+	ErrAlreadyRegistered = errors.New("student ID is already registered with another key")
+)
+
+// RegistrationVerifier decides who may register to vote. VerifyVoter returns nil if the holder of proof is the
+// student with studentID and is eligible in the election, else an error wrapping ErrVoterRejected
+type RegistrationVerifier interface {
+	VerifyVoter(studentID string, proof []byte) error
+}
+
+// StubVerifier accepts the student IDs it maps to true, whatever the proof. For tests
+type StubVerifier map[string]bool
+
+func (v StubVerifier) VerifyVoter(studentID string, _ []byte) error {
+	if !v[studentID] {
+		return ErrVoterRejected
+	}
+	return nil
+}
+
+// RollVerifier accepts the student IDs of a voter roll with the registration code the roll gives them
+type RollVerifier struct {
+	codes map[string][]byte // student ID -> SHA-256 of its code
+}
+
+// LoadVoterRoll reads a voter roll: one "<student ID> <registration code>" per line. Blank lines and lines
+// starting with # are skipped
+func LoadVoterRoll(path string) (*RollVerifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	roll := &RollVerifier{codes: make(map[string][]byte)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a student ID and a registration code", path, line)
+		}
+		if _, ok := roll.codes[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d: student ID %s is listed twice", path, line, fields[0])
+		}
+		code := sha256.Sum256([]byte(fields[1]))
+		roll.codes[fields[0]] = code[:]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return roll, nil
+}
+
+func (v *RollVerifier) VerifyVoter(studentID string, proof []byte) error {
+	code, ok := v.codes[studentID]
+	proofHash := sha256.Sum256(proof)
+	if !ok || subtle.ConstantTimeCompare(code, proofHash[:]) != 1 {
+		return ErrVoterRejected
+	}
+	return nil
+}
+
+// registeredKey returns the database key of the public key a student ID registered
+func (c *Coord) registeredKey(studentID string) []byte {
+	mac := hmac.New(sha256.New, c.voters.salt)
+	mac.Write([]byte(studentID))
+	return []byte(RegisteredKeyPrefix + hex.EncodeToString(mac.Sum(nil)))
+}
+
+// registerVoter binds studentID to publicKey, unless it is bound to another key, and returns the credential
+// of publicKey
+func (c *Coord) registerVoter(args RegisterVoterArgs) ([]byte, error) {
+	if c.registrarKey == nil {
+		return nil, ErrNoRegistrar
+	}
+	if end := c.ElectionEnd; !end.IsZero() && time.Now().After(end) {
+		return nil, ErrElectionClosed
+	}
+	if len(args.PublicKey) == 0 || len(args.PublicKey) > blockchain.MaxPublicKeyLen {
+		return nil, fmt.Errorf("%w: public key of %d bytes", blockchain.ErrNonCanonicalTxn, len(args.PublicKey))
+	}
+	if err := c.Verifier.VerifyVoter(args.StudentID, args.Proof); err != nil {
+		return nil, err
+	}
+
+	c.registerMu.Lock()
+	defer c.registerMu.Unlock()
+	key := c.registeredKey(args.StudentID)
+	if c.Storage.KeyExist(key) {
+		registered, err := c.Storage.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(registered, args.PublicKey) {
+			return nil, ErrAlreadyRegistered
+		}
+	} else if err := c.Storage.Put(key, args.PublicKey); err != nil {
+		return nil, err
+	}
+	return blockchain.IssueCredential(c.registrarKey, c.Blockchain.GenesisHash(), args.PublicKey)
+}
+
+// RegisterVoter registers a voter for an election with a registrar and returns the credential of its key.
+// Registering again with the same key returns a new credential of it
+func (api *CoordAPIClient) RegisterVoter(args RegisterVoterArgs, reply *RegisterVoterReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Handle("CoordAPIClient.RegisterVoter", &err)()
+	if api.c.isDraining() {
+		return ErrDraining
+	}
+	if api.c.ReplicaOf != "" {
+		return ErrReadReplica
+	}
+	credential, err := api.c.registerVoter(args)
+	if err != nil {
+		return err
+	}
+	*reply = RegisterVoterReply{Credential: credential}
+	return nil
+}
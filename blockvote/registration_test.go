@@ -0,0 +1,93 @@
+package blockvote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+func TestRegisterVoter(t *testing.T) {
+	registrar, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoord()
+	if err = c.Storage.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Storage.Close()
+	c.Blockchain = blockchain.NewBlockChain(c.Storage, nil)
+	if err = c.Blockchain.Init(blockchain.GenesisConfig{ElectionID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.voters, err = openVoterIndex(c.Storage, c.Blockchain); err != nil {
+		t.Fatal(err)
+	}
+	api := &CoordAPIClient{c: c}
+	register := func(studentID string, publicKey []byte) ([]byte, error) {
+		var reply RegisterVoterReply
+		api.RegisterVoter(RegisterVoterArgs{StudentID: studentID, PublicKey: publicKey}, &reply)
+		return reply.Credential, reply.Err()
+	}
+	key := Identity.NewWallet().PublicKey
+	if _, err = register("12345678", key); CodeOf(err) != CodeInvalid {
+		t.Fatalf("without a registrar: %v, want CodeInvalid", err)
+	}
+
+	c.registrarKey = registrar
+	c.Verifier = StubVerifier{"12345678": true}
+	registrarKey := blockchain.RegistrarPublicKey(registrar)
+	genesis := c.Blockchain.GenesisHash()
+	credential, err := register("12345678", key)
+	if err != nil || !blockchain.VerifyCredential(registrarKey, genesis, key, credential) {
+		t.Fatalf("eligible voter: %v", err)
+	}
+	// again with the same key, e.g. from another device
+	if credential, err = register("12345678", key); err != nil || !blockchain.VerifyCredential(registrarKey, genesis, key, credential) {
+		t.Fatalf("eligible voter registering again: %v", err)
+	}
+	if _, err = register("12345678", Identity.NewWallet().PublicKey); CodeOf(err) != CodeDuplicate {
+		t.Fatalf("another key of a registered voter: %v, want CodeDuplicate", err)
+	}
+	if _, err = register("87654321", Identity.NewWallet().PublicKey); CodeOf(err) != CodeUnauthorized {
+		t.Fatalf("ineligible voter: %v, want CodeUnauthorized", err)
+	}
+}
+
+func TestVoterRoll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roll")
+	if err := ioutil.WriteFile(path, []byte("# student ID, code\n12345678 s3cret\n\n87654321 other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	roll, err := LoadVoterRoll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = roll.VerifyVoter("12345678", []byte("s3cret")); err != nil {
+		t.Errorf("listed voter with their code: %v", err)
+	}
+	for _, tc := range []struct{ studentID, code string }{
+		{"12345678", "other"},
+		{"12345678", ""},
+		{"11111111", "s3cret"},
+	} {
+		if err = roll.VerifyVoter(tc.studentID, []byte(tc.code)); !errors.Is(err, ErrVoterRejected) {
+			t.Errorf("%s with code %q: %v, want ErrVoterRejected", tc.studentID, tc.code, err)
+		}
+	}
+
+	if err = ioutil.WriteFile(path, []byte("12345678 a\n12345678 b\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadVoterRoll(path); err == nil || !strings.Contains(err.Error(), "twice") {
+		t.Errorf("roll listing a student ID twice: %v", err)
+	}
+}
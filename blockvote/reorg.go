@@ -0,0 +1,122 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/events"
+	"sync"
+	"time"
+)
+
+const (
+	ReorgWaitTimeout = 30 * time.Second // longest a WaitReorg call is held by coord
+	ReorgHistory     = 64               // reorgs kept for clients calling WaitReorg late
+)
+
+// reorg is a fork switch and the txns it dropped from the longest chain
+type reorg struct {
+	seq     uint64
+	dropped map[string]bool // hex of TxIDs on the old fork but not on the new one
+}
+
+// reorgLog keeps the recent reorgs of coord's longest chain for WaitReorg
+type reorgLog struct {
+	mu      sync.Mutex
+	seq     uint64 // seq of the last reorg. 1 before any, so that 0 is never a valid seq
+	reorgs  []reorg
+	changed chan struct{} // closed and replaced on every reorg
+}
+
+func newReorgLog() *reorgLog {
+	return &reorgLog{seq: 1, changed: make(chan struct{})}
+}
+
+// last returns the seq of the last reorg and the number of reorgs kept
+func (l *reorgLog) last() (seq uint64, kept int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, len(l.reorgs)
+}
+
+// add records a fork switch from the txns of the abandoned and the adopted fork
+func (l *reorgLog) add(oldTxns []*blockchain.Transaction, newTxns []*blockchain.Transaction) {
+	kept := make(map[string]bool)
+	for _, txn := range newTxns {
+		kept[string(txn.ID)] = true
+	}
+	dropped := make(map[string]bool)
+	for _, txn := range oldTxns {
+		if !kept[string(txn.ID)] {
+			dropped[string(txn.ID)] = true
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.reorgs = append(l.reorgs, reorg{seq: l.seq, dropped: dropped})
+	if len(l.reorgs) > ReorgHistory {
+		l.reorgs = l.reorgs[len(l.reorgs)-ReorgHistory:]
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// since returns which of txids were dropped by the reorgs after seq, the seq of the last reorg and
+// a channel closed on the next reorg. missed is set if reorgs after seq are no longer kept or seq is from
+// before coord restarted.
+func (l *reorgLog) since(seq uint64, txids [][]byte) (invalidated [][]byte, last uint64, missed bool, changed <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq > l.seq || len(l.reorgs) > 0 && seq+1 < l.reorgs[0].seq {
+		missed = true
+	}
+	for _, txid := range txids {
+		for _, r := range l.reorgs {
+			if r.seq > seq && r.dropped[string(txid)] {
+				invalidated = append(invalidated, txid)
+				break
+			}
+		}
+	}
+	return invalidated, l.seq, missed, l.changed
+}
+
+// watchReorgs records every fork switch of coord in c.reorgs
+func (c *Coord) watchReorgs() {
+	sub := c.Events.Subscribe(50, events.ForkSwitch)
+	go func() {
+		for event := range sub {
+			c.reorgs.add(event.OldTxns, event.NewTxns)
+		}
+	}()
+}
+
+// WaitReorg returns which of args.TxIDs were dropped from the longest chain by fork switches after
+// args.Since. If none were, it waits up to ReorgWaitTimeout for the next fork switch. A call with
+// Since 0 returns right away with the current sequence number to pass to the next call.
+func (api *CoordAPIClient) WaitReorg(args WaitReorgArgs, reply *WaitReorgReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.c.rpcGuard.Track("CoordAPIClient.WaitReorg", &err)()
+	c := api.c
+	if c.isDraining() {
+		return ErrDraining
+	}
+	timeout := time.NewTimer(ReorgWaitTimeout)
+	defer timeout.Stop()
+	for {
+		invalidated, last, missed, changed := c.reorgs.since(args.Since, args.TxIDs)
+		*reply = WaitReorgReply{Seq: last, Invalidated: invalidated, Missed: missed}
+		if args.Since == 0 || last > args.Since || len(invalidated) > 0 || missed {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return nil
+		case <-c.draining:
+			return ErrDraining
+		case <-c.quit:
+			return nil
+		}
+	}
+}
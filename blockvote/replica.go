@@ -0,0 +1,210 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"errors"
+	"fmt"
+	"github.com/DistributedClocks/tracing"
+	"log"
+	"net/rpc"
+	"time"
+)
+
+// A read replica is a coord that copies the chain of a primary coord and only serves clients' queries,
+// so that query traffic on election night does not slow down the primary. Miners never talk to it.
+
+// ErrReadReplica is returned by the client APIs a read replica does not serve
+var ErrReadReplica = errors.New("coord is a read replica, use the primary coord")
+
+// DefaultReplicaSyncInterval is how often a replica polls the primary for new blocks if not configured
+const DefaultReplicaSyncInterval = time.Second
+
+// StartReplica copies the chain of the primary coord at c.ReplicaOf (its miner API address), serves clients'
+// queries at clientAPIListenAddr and keeps polling the primary for new blocks until Stop is called
+func (c *Coord) StartReplica(clientAPIListenAddr string, ctrace *tracing.Tracer) error {
+	c.tracer = ctrace
+	c.trace = CreateTrace(ctrace)
+	if c.ReplicaSyncInterval <= 0 {
+		c.ReplicaSyncInterval = DefaultReplicaSyncInterval
+	}
+	resume := c.InitStorage()
+	defer c.Storage.Close()
+	go c.Storage.Maintain(StorageMaintenanceInterval)
+
+	primary, err := util.DialRPC(c.ReplicaOf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if primary != nil {
+			primary.Close()
+		}
+	}()
+	reply := DownloadReply{}
+	if err = Call(primary, Scoped(c.ElectionID, "CoordAPIMiner.Download"), DownloadArgs{}, &reply); err != nil {
+		return err
+	}
+	for _, cand := range reply.Candidates {
+		c.Candidates = append(c.Candidates, Identity.DecodeToWallets(cand))
+	}
+	c.ElectionEnd = reply.ElectionEnd
+	if err = c.syncCandidateList(primary); err != nil {
+		return err
+	}
+	c.Blockchain = blockchain.NewBlockChain(c.Storage, c.Candidates)
+	c.Blockchain.Strict = c.StrictInvariants
+	c.Blockchain.LegacyGenesis = c.LegacyGenesis
+	if err = c.Blockchain.SetDifficulty(reply.Difficulty); err != nil {
+		return err
+	}
+	// a replica with a StorageDir only downloads the blocks it does not have yet
+	var knownHashes [][]byte
+	if resume {
+		if err = c.Blockchain.ResumeFromDB(); err != nil {
+			return err
+		}
+		if genesis := c.Blockchain.GenesisHash(); !bytes.Equal(genesis, reply.Genesis) {
+			return fmt.Errorf("stored chain has genesis block %x but the primary has %x", genesis, reply.Genesis)
+		}
+		if knownHashes, err = c.Blockchain.Hashes(); err != nil {
+			return err
+		}
+	}
+	blocks, err := DownloadChain(primary, c.ElectionID, reply.Height, knownHashes)
+	if err != nil {
+		return err
+	}
+	if err = c.Blockchain.ResumeFromEncodedData(blocks, reply.LastHash); err != nil {
+		return err
+	}
+	if err = c.Blockchain.SetFinalityDepth(reply.FinalityDepth); err != nil {
+		return err
+	}
+	if err = c.Blockchain.SetSealingKey(reply.SealingKey); err != nil {
+		return err
+	}
+	if err = c.Blockchain.SetRegistration(reply.Registration); err != nil {
+		return err
+	}
+	if err = c.Blockchain.CheckCandidates(c.ElectionID); err != nil {
+		return fmt.Errorf("primary's candidates are rejected: %v", err)
+	}
+	if c.voters, err = openVoterIndex(c.Storage, c.Blockchain); err != nil {
+		return err
+	}
+	if c.candTxns, err = openCandidateIndex(c.Storage, c.Blockchain); err != nil {
+		return err
+	}
+	log.Printf("[INFO] Replicated %d blocks from primary coord %s\n", len(blocks), c.ReplicaOf)
+
+	coordAPIClient := new(CoordAPIClient)
+	coordAPIClient.c = c
+	c.ClientAPIAddr, err = c.listen("CoordAPIClient", coordAPIClient, clientAPIListenAddr)
+	if err != nil {
+		return errors.New("cannot start API service for client")
+	}
+	log.Println("[INFO] Listen to clients' API requests at", c.ClientAPIAddr)
+	if c.MetricsListenAddr != "" {
+		if err = c.Metrics.Serve(c.MetricsListenAddr); err != nil {
+			return errors.New("cannot start metrics service")
+		}
+		log.Println("[INFO] Serving metrics at", c.MetricsListenAddr)
+	}
+	if c.FeedListenAddr != "" {
+		if err = c.serveFeed(c.FeedListenAddr); err != nil {
+			return errors.New("cannot start live results feed")
+		}
+		log.Println("[INFO] Serving live results feed at", c.FeedListenAddr)
+	}
+	if c.HealthListenAddr != "" {
+		listener, err := serveHealth(c.HealthListenAddr, c.health)
+		if err != nil {
+			return errors.New("cannot start health probes")
+		}
+		c.listeners = append(c.listeners, listener)
+		log.Println("[INFO] Serving health probes at", c.HealthListenAddr)
+	}
+
+	close(c.ready)
+
+	ticker := time.NewTicker(c.ReplicaSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.quit:
+			return nil
+		}
+		if primary == nil {
+			if primary, err = util.DialRPC(c.ReplicaOf); err != nil {
+				primary = nil
+				continue
+			}
+		}
+		if err = c.syncFromPrimary(primary); err != nil {
+			log.Println("[WARN] Unable to sync from primary coord:", err)
+			primary.Close()
+			primary = nil
+		}
+	}
+}
+
+// syncCandidateList takes the candidate list and its version from the primary, so that clients see the same
+// versions from the primary and the replica, and statements the primary reloaded
+func (c *Coord) syncCandidateList(primary *rpc.Client) error {
+	reply := GetCandidatesReply{}
+	args := GetCandidatesArgs{KnownVersion: c.candidateListVersion()}
+	if err := Call(primary, Scoped(c.ElectionID, "CoordAPIClient.GetCandidates"), args, &reply); err != nil {
+		return err
+	}
+	if reply.Unchanged {
+		return nil
+	}
+	if len(reply.Info) != len(c.Candidates) {
+		return fmt.Errorf("primary describes %d candidates, the election has %d", len(reply.Info), len(c.Candidates))
+	}
+	var entries []CandidateEntry
+	for _, info := range reply.Info {
+		entries = append(entries, CandidateEntry{Name: info.Name, ID: info.ID, Race: info.Race, Address: info.Address, Statement: info.Statement})
+	}
+	c.candMu.Lock()
+	c.CandidateEntries = entries
+	c.candVersion = reply.Version
+	c.candMu.Unlock()
+	log.Println("[INFO] Candidate list is at version", reply.Version)
+	return nil
+}
+
+// syncFromPrimary fetches the blocks the replica is missing up to the primary's tip, and the candidate list
+// if the primary has a newer version
+func (c *Coord) syncFromPrimary(primary *rpc.Client) error {
+	reply := DownloadReply{}
+	if err := Call(primary, Scoped(c.ElectionID, "CoordAPIMiner.Download"), DownloadArgs{}, &reply); err != nil {
+		return err
+	}
+	if err := c.syncCandidateList(primary); err != nil {
+		return err
+	}
+	if c.Blockchain.Exist(reply.LastHash) {
+		return nil
+	}
+	knownHashes, err := c.Blockchain.Hashes()
+	if err != nil {
+		return err
+	}
+	blocks, err := DownloadChain(primary, c.ElectionID, reply.Height, knownHashes)
+	if err != nil {
+		return err
+	}
+	for _, data := range blocks {
+		block, err := blockchain.DecodeBlock(data)
+		if err != nil {
+			return err
+		}
+		c.ingestBlock(block)
+	}
+	return nil
+}
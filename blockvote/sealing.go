@@ -0,0 +1,62 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"log"
+	"time"
+)
+
+// SealReleaseInterval is how often coord submits the unseal txn to miners until it is on the longest chain
+const SealReleaseInterval = 10 * time.Second
+
+// releaseSealingKey waits for the election deadline, then submits the txn releasing the sealing key to the
+// miners until it is on the longest chain, so that the sealed ballots can be opened and counted
+func (c *Coord) releaseSealingKey() {
+	if wait := time.Until(c.ElectionEnd); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-c.quit:
+			return
+		}
+	}
+	txn := blockchain.NewUnsealTxn(c.sealingKey, c.Blockchain.GenesisHash())
+	for {
+		if found, _, _ := c.Blockchain.FindTxn(txn.ID); found != nil {
+			log.Printf("[INFO] Sealing key released in txn %x\n", txn.ID)
+			return
+		}
+		c.nlMu.Lock()
+		var minerAddrs []string
+		for _, info := range c.NodeList {
+			minerAddrs = append(minerAddrs, info.Property.ClientListenAddr)
+		}
+		c.nlMu.Unlock()
+		for _, addr := range minerAddrs {
+			if submitUnseal(addr, txn) {
+				break
+			}
+		}
+		select {
+		case <-time.After(SealReleaseInterval):
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// submitUnseal submits the unseal txn to the miner at addr and reports whether the miner took it
+func submitUnseal(addr string, txn blockchain.Transaction) bool {
+	client, err := util.DialRPC(addr)
+	if err != nil {
+		return false
+	}
+	defer client.Close()
+	var reply SubmitTxnReply
+	err = Call(client, "MinerAPIClient.SubmitTxn", SubmitTxnArgs{Txn: txn}, &reply)
+	if err != nil && CodeOf(err) != CodeDuplicate {
+		log.Printf("[WARN] Miner %s did not take the unseal txn: %v\n", addr, err)
+		return false
+	}
+	return true
+}
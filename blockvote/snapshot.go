@@ -0,0 +1,41 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"errors"
+)
+
+var (
+	// ErrUnknownBlock is returned for a tally or certificate pinned to a block the node does not have
+	ErrUnknownBlock = errors.New("block is not known")
+	// ErrNotOnLongestChain is returned for a certificate pinned to a block off the longest chain
+	ErrNotOnLongestChain = errors.New("block is not on the longest chain")
+)
+
+// onLongestChain checks whether the known block hash is on the longest chain of chain
+func onLongestChain(chain *blockchain.BlockChain, hash []byte) bool {
+	block := chain.GetByHeight(chain.GetHeader(hash).BlockNum)
+	return block != nil && bytes.Equal(block.Hash, hash)
+}
+
+// resultsReply tallies the chain ending at the block at, or at the tip of the longest chain if at is nil, for
+// QueryResults. Nodes asked for the same block count the same ballots, however far mining went since.
+func resultsReply(chain *blockchain.BlockChain, at []byte, strict bool) (QueryResultsReply, error) {
+	lastHash := at
+	if lastHash == nil {
+		lastHash = chain.GetLastHash()
+	} else if !chain.Exist(lastHash) {
+		return QueryResultsReply{}, ErrUnknownBlock
+	}
+	votes, races := tallyResults(chain, lastHash, strict)
+	return QueryResultsReply{
+		Votes:          votes,
+		Races:          races,
+		Height:         chain.GetHeader(lastHash).BlockNum,
+		LastHash:       lastHash,
+		Strict:         strict,
+		OnLongestChain: at == nil || onLongestChain(chain, lastHash),
+		TipHeight:      chain.GetHeader(chain.GetLastHash()).BlockNum,
+	}, nil
+}
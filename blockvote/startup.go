@@ -0,0 +1,83 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"time"
+)
+
+// a miner started before coord retries, waiting longer after every failure
+const (
+	CoordRetryMin = 200 * time.Millisecond
+	CoordRetryMax = 5 * time.Second
+)
+
+// ErrCoordUnreachable is returned by Start when coord or its chain is not available within CoordWaitTimeout
+var ErrCoordUnreachable = errors.New("coord is unreachable")
+
+// ErrDevChain is returned by Start when the chain has dev voters (see blockchain.Registration) and the node does
+// not run in dev mode
+var ErrDevChain = errors.New("chain has dev voters, which only dev mode (-dev) accepts")
+
+// waitForCoord connects to coord and downloads its chain information, retrying with backoff until coord
+// answers or CoordWaitTimeout passes
+func (m *Miner) waitForCoord(minerAddr string, coordAddr string) (*rpc.Client, DownloadReply, error) {
+	var deadline time.Time
+	if m.CoordWaitTimeout > 0 {
+		deadline = m.Clock.Now().Add(m.CoordWaitTimeout)
+	}
+	retry := CoordRetryMin
+	for {
+		client, err := util.NewRPCClient(minerAddr, coordAddr)
+		if err == nil {
+			var reply DownloadReply
+			if err = Call(client, Scoped(m.ElectionID, "CoordAPIMiner.Download"), DownloadArgs{}, &reply); err == nil {
+				return client, reply, nil
+			}
+			client.Close()
+		}
+		if !deadline.IsZero() && m.Clock.Now().Add(retry).After(deadline) {
+			return nil, DownloadReply{}, fmt.Errorf("%w after %v: %v", ErrCoordUnreachable, m.CoordWaitTimeout, err)
+		}
+		log.Printf("[INFO] Coord is not ready (%v), retrying in %v...\n", err, retry)
+		m.Clock.Sleep(retry)
+		if retry *= 2; retry > CoordRetryMax {
+			retry = CoordRetryMax
+		}
+	}
+}
+
+// checkGenesis checks the genesis block of coord against GenesisHash and Genesis, so that a miner never mines on
+// a chain of another election, nor on a dev chain unless it runs in dev mode
+func (m *Miner) checkGenesis(reply DownloadReply) error {
+	if reply.Registration.Dev() && !m.Dev {
+		return ErrDevChain
+	}
+	if m.GenesisHash != nil && !bytes.Equal(reply.Genesis, m.GenesisHash) {
+		return fmt.Errorf("coord has genesis block %x, expected %x", reply.Genesis, m.GenesisHash)
+	}
+	if m.Genesis == nil {
+		return nil
+	}
+	var candidates []*Identity.Wallets
+	for _, cand := range reply.Candidates {
+		candidates = append(candidates, Identity.DecodeToWallets(cand))
+	}
+	genesis := *m.Genesis
+	genesis.CandidateHash = blockchain.CandidateSetHash(candidates)
+	genesis.SealingKey = reply.SealingKey
+	genesis.Registration = reply.Registration
+	if reply.GenesisHeader == nil || !bytes.Equal(reply.GenesisHeader.Hash, reply.Genesis) {
+		return fmt.Errorf("coord did not send the header of its genesis block %x", reply.Genesis)
+	}
+	if err := genesis.CheckHeader(reply.GenesisHeader); err != nil {
+		return fmt.Errorf("coord has genesis block %x, not one of the genesis config: %v", reply.Genesis, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"errors"
+	"net/rpc"
+	"strings"
+	"time"
+)
+
+// ErrorCode classifies the error of an RPC, so that callers do not depend on error messages
+type ErrorCode uint8
+
+const (
+	CodeOK             ErrorCode = iota
+	CodeNotFound                 // the requested object does not exist, e.g. an expired block template
+	CodeDuplicate                // the txn is already pending or on the chain
+	CodeUnauthorized             // the key or signature of the caller is not accepted
+	CodeBusy                     // the node cannot take the request for now. try again after RetryAfter
+	CodeWrongElection            // the request is for another election or genesis block
+	CodeChainSyncing             // the node is starting or catching up with the chain. try again after RetryAfter
+	CodeElectionClosed           // the election deadline has passed
+	CodeUnavailable              // the node does not serve the request, e.g. a draining coord or a read replica
+	CodeInvalid                  // the request is malformed and no retry fixes it
+	CodeInternal                 // any other error of the node
+	CodeQuotaExceeded            // the client ID used up its quota, e.g. of ballots. no retry fixes it
+	CodeAlreadyVoted             // the voter has cast all the ballots the race allows. no retry fixes it
+)
+
+var codeNames = []string{"OK", "NotFound", "Duplicate", "Unauthorized", "Busy", "WrongElection", "ChainSyncing",
+	"ElectionClosed", "Unavailable", "Invalid", "Internal", "QuotaExceeded", "AlreadyVoted"}
+
+func (c ErrorCode) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Unknown"
+}
+
+// RPCStatus is the error envelope embedded in every reply. net/rpc drops the reply of a handler that returns
+// an error and only passes its message on, so handlers put the error in the status and return nil, see
+// setStatus. Callers get it back from Call.
+type RPCStatus struct {
+	Code       ErrorCode
+	Message    string
+	RetryAfter time.Duration // for CodeBusy and CodeChainSyncing
+}
+
+// Reply is any reply embedding RPCStatus
+type Reply interface {
+	status() *RPCStatus
+}
+
+func (s *RPCStatus) status() *RPCStatus {
+	return s
+}
+
+// Err returns the error in the status, nil for CodeOK
+func (s *RPCStatus) Err() error {
+	if s.Code == CodeOK {
+		return nil
+	}
+	return &RPCError{Code: s.Code, Message: s.Message, RetryAfter: s.RetryAfter}
+}
+
+// RPCError is the error of an RPC as set by the handler, or a net/rpc error of the remote server
+type RPCError struct {
+	Code       ErrorCode
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// CodeOf returns the code of an error returned by Call. CodeOK for nil, CodeInternal for errors that did
+// not come from the remote node, e.g. a broken connection
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return CodeOK
+	}
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr.Code
+	}
+	return CodeInternal
+}
+
+// errorCodes classifies the errors handlers return
+var errorCodes = []struct {
+	err  error
+	code ErrorCode
+}{
+	{ErrElectionClosed, CodeElectionClosed},
+	{ErrElectionOpen, CodeInvalid},
+	{ErrDraining, CodeUnavailable},
+	{ErrReadReplica, CodeUnavailable},
+	{ErrIdentityMismatch, CodeUnauthorized},
+	{ErrInvalidRegistration, CodeUnauthorized},
+	{ErrVoterRejected, CodeUnauthorized},
+	{ErrBadVoterSignature, CodeUnauthorized},
+	{ErrAlreadyRegistered, CodeDuplicate},
+	{ErrNoRegistrar, CodeInvalid},
+	{ErrUnknownTemplate, CodeNotFound},
+	{ErrUnknownCandidate, CodeNotFound},
+	{ErrUnknownBlock, CodeNotFound},
+	{ErrNotOnLongestChain, CodeInvalid},
+	{ErrStaleTemplate, CodeInvalid},
+	{ErrInvalidRange, CodeInvalid},
+	{ErrTooManyTxns, CodeInvalid},
+	{ErrInvalidSolution, CodeInvalid},
+	{blockchain.ErrTxnTooLarge, CodeInvalid},
+	{blockchain.ErrNonCanonicalTxn, CodeInvalid},
+	{util.ErrHandlerPanic, CodeInternal},
+}
+
+// statusOf puts the error of a handler into a status
+func statusOf(err error) RPCStatus {
+	var rpcErr *RPCError
+	var busy *BusyError
+	switch {
+	case err == nil:
+		return RPCStatus{}
+	case errors.As(err, &rpcErr):
+		return RPCStatus{Code: rpcErr.Code, Message: rpcErr.Message, RetryAfter: rpcErr.RetryAfter}
+	case errors.As(err, &busy):
+		code := CodeBusy
+		if busy.Syncing {
+			code = CodeChainSyncing
+		}
+		return RPCStatus{Code: code, Message: err.Error(), RetryAfter: busy.RetryAfter}
+	}
+	for _, known := range errorCodes {
+		if errors.Is(err, known.err) {
+			return RPCStatus{Code: known.code, Message: err.Error()}
+		}
+	}
+	return RPCStatus{Code: CodeInternal, Message: err.Error()}
+}
+
+// rejectionCode classifies why a submitted txn failed blockchain.CheckTxn
+func rejectionCode(check blockchain.TxnCheck) ErrorCode {
+	switch {
+	case check.Duplicate:
+		return CodeDuplicate
+	case check.OtherElection:
+		return CodeWrongElection
+	case !check.ValidSignature || check.Candidate || check.Unregistered:
+		return CodeUnauthorized
+	case check.Voted:
+		return CodeAlreadyVoted
+	}
+	return CodeInvalid
+}
+
+// setStatus moves the error of a handler into the status of its reply. Handlers defer it before
+// RPCGuard.Handle, so that it also gets the error of a panic
+func setStatus(status *RPCStatus, err *error) {
+	if *err != nil {
+		*status = statusOf(*err)
+		*err = nil
+	}
+}
+
+// Call calls method of the remote node and returns the error in the status of the reply as an *RPCError.
+// Errors of the remote net/rpc server become an *RPCError as well: CodeWrongElection if the service is not
+// registered (e.g. not scoped to the election of the node), CodeNotFound if the method is not (an older node),
+// CodeInternal otherwise. Connection errors are returned as they are.
+func Call(client *rpc.Client, method string, args interface{}, reply Reply) error {
+	*reply.status() = RPCStatus{}
+	if client == nil {
+		// not connected yet
+		return rpc.ErrShutdown
+	}
+	if err := client.Call(method, args, reply); err != nil {
+		serverErr, ok := err.(rpc.ServerError)
+		if !ok {
+			return err
+		}
+		code := CodeInternal
+		if strings.HasPrefix(string(serverErr), "rpc: can't find service") {
+			code = CodeWrongElection
+		} else if strings.HasPrefix(string(serverErr), "rpc: can't find method") {
+			code = CodeNotFound
+		}
+		return &RPCError{Code: code, Message: string(serverErr)}
+	}
+	return reply.status().Err()
+}
@@ -0,0 +1,56 @@
+package blockvote
+
+import (
+	"errors"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+func TestRejectionCode(t *testing.T) {
+	valid := blockchain.TxnCheck{ValidSignature: true, ValidBallot: true}
+	for _, tc := range []struct {
+		name  string
+		check func(*blockchain.TxnCheck)
+		code  ErrorCode
+	}{
+		{"duplicate", func(c *blockchain.TxnCheck) { c.Duplicate = true }, CodeDuplicate},
+		{"other election", func(c *blockchain.TxnCheck) { c.OtherElection = true }, CodeWrongElection},
+		{"bad signature", func(c *blockchain.TxnCheck) { c.ValidSignature = false }, CodeUnauthorized},
+		{"candidate", func(c *blockchain.TxnCheck) { c.Candidate = true }, CodeUnauthorized},
+		{"unregistered", func(c *blockchain.TxnCheck) { c.Unregistered = true }, CodeUnauthorized},
+		{"voted", func(c *blockchain.TxnCheck) { c.Voted = true }, CodeAlreadyVoted},
+		{"invalid ballot", func(c *blockchain.TxnCheck) { c.ValidBallot = false }, CodeInvalid},
+	} {
+		check := valid
+		tc.check(&check)
+		if code := rejectionCode(check); code != tc.code {
+			t.Errorf("%s: %v, want %v", tc.name, code, tc.code)
+		}
+	}
+}
+
+func TestStatusRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code ErrorCode
+	}{
+		{ErrElectionClosed, CodeElectionClosed},
+		{ErrReadReplica, CodeUnavailable},
+		{ErrVoterRejected, CodeUnauthorized},
+		{ErrUnknownTemplate, CodeNotFound},
+		{&BusyError{Syncing: true}, CodeChainSyncing},
+		{&RPCError{Code: CodeAlreadyVoted, Message: "voted"}, CodeAlreadyVoted},
+		{errors.New("disk full"), CodeInternal},
+	} {
+		var reply SubmitTxnReply
+		err := tc.err
+		setStatus(&reply.RPCStatus, &err)
+		if err != nil {
+			t.Errorf("%v: setStatus leaves the error to net/rpc", tc.err)
+		}
+		if code := CodeOf(reply.Err()); code != tc.code {
+			t.Errorf("%v: %v in the reply, want %v", tc.err, code, tc.code)
+		}
+	}
+}
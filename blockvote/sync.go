@@ -0,0 +1,182 @@
+package blockvote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"errors"
+	"fmt"
+	"log"
+	"net/rpc"
+	"sort"
+)
+
+const (
+	ChainChunkHeights = 16 // max number of block heights sent in one GetBlocks reply
+	ChunkRetries      = 3  // attempts per chunk before giving up on a checksum mismatch
+)
+
+var ErrChecksumMismatch = errors.New("chain chunk checksum mismatch")
+
+// ChunkChecksum hashes the encoded blocks of a chunk in order
+func ChunkChecksum(blocks [][]byte) []byte {
+	h := sha256.New()
+	for _, block := range blocks {
+		h.Write(block)
+	}
+	return h.Sum(nil)
+}
+
+// blocksReply returns one chunk of chain for GetBlocks, capped at ChainChunkHeights heights
+func blocksReply(chain *blockchain.BlockChain, args GetBlocksArgs) (GetBlocksReply, error) {
+	if args.ToHeight < args.FromHeight {
+		return GetBlocksReply{}, errors.New("invalid height range")
+	}
+	toHeight := args.ToHeight
+	if int(toHeight)-int(args.FromHeight)+1 > ChainChunkHeights {
+		toHeight = args.FromHeight + ChainChunkHeights - 1
+	}
+	blocks, err := chain.EncodeRange(args.FromHeight, toHeight, args.KnownHashes)
+	if err != nil {
+		return GetBlocksReply{}, err
+	}
+	return GetBlocksReply{
+		Blocks:   blocks,
+		Checksum: ChunkChecksum(blocks),
+		ToHeight: toHeight,
+	}, nil
+}
+
+// DownloadChain fetches the chain of an election from coord up to height, one chunk of heights at a time, skipping the
+// blocks in knownHashes. Blocks whose parent was not received (added to a fork between two chunks) are
+// dropped, gossip delivers them later.
+func DownloadChain(client *rpc.Client, electionID string, height uint32, knownHashes [][]byte) ([][]byte, error) {
+	return downloadChain(client, Scoped(electionID, "CoordAPIMiner.GetBlocks"), height, knownHashes)
+}
+
+// downloadChain is DownloadChain from any service serving GetBlocks, e.g. a miner's admin API
+func downloadChain(client *rpc.Client, method string, height uint32, knownHashes [][]byte) ([][]byte, error) {
+	var blocks [][]byte
+	received := make(map[string]bool)
+	for _, hash := range knownHashes {
+		received[string(hash)] = true
+	}
+	for from := 0; from <= int(height); {
+		args := GetBlocksArgs{FromHeight: uint32(from), ToHeight: height, KnownHashes: knownHashes}
+		reply := GetBlocksReply{}
+		err := ErrChecksumMismatch
+		for i := 0; i < ChunkRetries && err == ErrChecksumMismatch; i++ {
+			if err = Call(client, method, args, &reply); err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(ChunkChecksum(reply.Blocks), reply.Checksum) {
+				log.Printf("[WARN] Checksum mismatch in chunk from height %d, retrying\n", from)
+				err = ErrChecksumMismatch
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := make([]*blockchain.Block, len(reply.Blocks))
+		order := make([]int, len(reply.Blocks))
+		for i, data := range reply.Blocks {
+			if chunk[i], err = blockchain.DecodeBlock(data); err != nil {
+				return nil, fmt.Errorf("malformed block in chunk from height %d: %v", from, err)
+			}
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return chunk[order[i]].BlockNum < chunk[order[j]].BlockNum
+		})
+		for _, i := range order {
+			block := chunk[i]
+			if block.BlockNum > 0 && !received[string(block.PrevHash)] {
+				continue
+			}
+			received[string(block.Hash)] = true
+			blocks = append(blocks, reply.Blocks[i])
+		}
+		from = int(reply.ToHeight) + 1
+	}
+	return blocks, nil
+}
+
+// OpenChain opens the blockchain from a database directory, a backup file, or coord's given election (in this
+// order of preference). A database directory is opened read-only, so inspecting it never changes it
+func OpenChain(coordAddr string, electionID string, dbPath string, snapshot string, keyFile string) (*blockchain.BlockChain, error) {
+	storage := &util.Database{}
+	if keyFile != "" {
+		key, err := util.LoadKeyFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = storage.EnableEncryption(key); err != nil {
+			return nil, err
+		}
+	}
+
+	if dbPath != "" || snapshot != "" {
+		if dbPath != "" {
+			// the tools only read, and ResumeFromDB does not write
+			if err := storage.LoadReadOnly(dbPath); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := storage.New("", true); err != nil {
+				return nil, err
+			}
+			if err := storage.RestoreFromFile(snapshot); err != nil {
+				return nil, err
+			}
+		}
+		values, err := storage.GetAllWithPrefix(CandidateKeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		var candidates []*Identity.Wallets
+		for _, val := range values {
+			candidates = append(candidates, Identity.DecodeToWallets(val))
+		}
+		chain := blockchain.NewBlockChain(storage, candidates)
+		return chain, chain.ResumeFromDB()
+	}
+
+	client, err := util.DialRPC(coordAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	reply := DownloadReply{}
+	err = Call(client, Scoped(electionID, "CoordAPIMiner.Download"), DownloadArgs{}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := DownloadChain(client, electionID, reply.Height, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = storage.New("", true); err != nil {
+		return nil, err
+	}
+	var candidates []*Identity.Wallets
+	for _, cand := range reply.Candidates {
+		candidates = append(candidates, Identity.DecodeToWallets(cand))
+	}
+	chain := blockchain.NewBlockChain(storage, candidates)
+	if err = chain.SetFinalityDepth(reply.FinalityDepth); err != nil {
+		return nil, err
+	}
+	if err = chain.SetDifficulty(reply.Difficulty); err != nil {
+		return nil, err
+	}
+	if err = chain.SetSealingKey(reply.SealingKey); err != nil {
+		return nil, err
+	}
+	if err = chain.SetRegistration(reply.Registration); err != nil {
+		return nil, err
+	}
+	return chain, chain.ResumeFromEncodedData(blocks, reply.LastHash)
+}
@@ -0,0 +1,105 @@
+package blockvote
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// MaxBlockTemplates is the number of recent block templates kept for SubmitSolvedBlock
+const MaxBlockTemplates = 16
+
+var (
+	ErrUnknownTemplate = errors.New("unknown or expired block template")
+	ErrStaleTemplate   = errors.New("block template is not on top of the longest chain anymore")
+	ErrInvalidSolution = errors.New("nonce does not solve the block template")
+)
+
+// blockTemplates are the latest blocks handed to external solvers, oldest first
+type blockTemplates struct {
+	nextID uint64
+	ids    []uint64
+	blocks []blockchain.Block
+}
+
+// add keeps a new template. Its timestamp is moved past the one of the previous template on the same
+// block, so that a solver that tried every nonce of a template gets a fresh header from the next one
+func (t *blockTemplates) add(block *blockchain.Block) uint64 {
+	if n := len(t.blocks); n > 0 && bytes.Equal(t.blocks[n-1].PrevHash, block.PrevHash) &&
+		block.Timestamp <= t.blocks[n-1].Timestamp {
+		block.Timestamp = t.blocks[n-1].Timestamp + 1
+	}
+	t.nextID++
+	t.ids = append(t.ids, t.nextID)
+	t.blocks = append(t.blocks, *block)
+	if len(t.ids) > MaxBlockTemplates {
+		t.ids = t.ids[1:]
+		t.blocks = t.blocks[1:]
+	}
+	return t.nextID
+}
+
+func (t *blockTemplates) get(id uint64) (blockchain.Block, bool) {
+	for i := range t.ids {
+		if t.ids[i] == id {
+			return t.blocks[i], true
+		}
+	}
+	return blockchain.Block{}, false
+}
+
+// GetBlockTemplate returns the block the miner would mine next, for an external process to solve
+func (api *MinerAPIAdmin) GetBlockTemplate(args GetBlockTemplateArgs, reply *GetBlockTemplateReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.GetBlockTemplate", &err)()
+	m := api.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	block := m.nextBlock(m.Clock.Now())
+	templateID := m.templates.add(&block)
+	header := block.Header()
+	prefix, suffix := header.SplitAtNonce()
+	*reply = GetBlockTemplateReply{
+		TemplateID: templateID,
+		Header:     header,
+		Prefix:     prefix,
+		Suffix:     suffix,
+		Target:     blockchain.NewProofWithDifficulty(nil, m.Blockchain.BlockDifficulty()).Target.Bytes(),
+	}
+	return nil
+}
+
+// SubmitSolvedBlock puts and broadcasts a block template solved by an external process
+func (api *MinerAPIAdmin) SubmitSolvedBlock(args SubmitSolvedBlockArgs, reply *SubmitSolvedBlockReply) (err error) {
+	defer setStatus(&reply.RPCStatus, &err)
+	defer api.m.rpcGuard.Handle("MinerAPIAdmin.SubmitSolvedBlock", &err)()
+	m := api.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	block, ok := m.templates.get(args.TemplateID)
+	if !ok {
+		return ErrUnknownTemplate
+	}
+	if !bytes.Equal(block.PrevHash, m.Blockchain.GetLastHash()) {
+		return ErrStaleTemplate
+	}
+	block.Nonce = args.Nonce
+	pow := blockchain.NewProofWithDifficulty(&block, m.Blockchain.BlockDifficulty())
+	if !pow.Validate() {
+		return ErrInvalidSolution
+	}
+	pow.Next(false) // sets the hash of the solved block
+	if err := block.SignMiner(m.identity.Key); err != nil {
+		return err
+	}
+	if result := m.Blockchain.Put(block, true); !result.Added() {
+		return fmt.Errorf("solved block was not added to the chain (%v): %v", result.Status, result.Err)
+	}
+	log.Printf("[INFO] New block (%x) solved externally\n", block.Hash[:5])
+	m.publishMined(&block)
+	m.ChainUpdatedChan <- 1 // restart the internal mining cycle on top of the new block
+	reply.Hash = block.Hash
+	return nil
+}
@@ -0,0 +1,93 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"sync/atomic"
+	"time"
+)
+
+// DutyCycleWindow is the period a miner's duty cycle applies to: it mines for MiningDutyCycle percent of each
+// window and idles for the rest
+const DutyCycleWindow = time.Second
+
+// MiningThrottle is how much CPU a miner allows itself, see Miner.MiningWorkers and Miner.MiningDutyCycle
+type MiningThrottle struct {
+	Workers   int  // goroutines searching nonces
+	DutyCycle int  // percent of the time spent mining
+	Paused    bool // the miner is idling for the rest of the current duty cycle window
+}
+
+// throttle returns the current mining throttle
+func (m *Miner) throttle() MiningThrottle {
+	return MiningThrottle{
+		Workers:   m.miningWorkers(),
+		DutyCycle: m.miningDutyCycle(),
+		Paused:    atomic.LoadInt32(&m.miningPaused) == 1,
+	}
+}
+
+func (m *Miner) miningWorkers() int {
+	if m.MiningWorkers < 1 {
+		return 1
+	}
+	return m.MiningWorkers
+}
+
+func (m *Miner) miningDutyCycle() int {
+	if m.MiningDutyCycle <= 0 || m.MiningDutyCycle > 100 {
+		return 100
+	}
+	return m.MiningDutyCycle
+}
+
+// AllowBlocks lets a miner in OnDemand mode mine n more blocks
+func (m *Miner) AllowBlocks(n int) {
+	atomic.AddInt32(&m.allowedBlocks, int32(n))
+}
+
+// HasPending checks whether the txn with the given ID is in the miner's pool, e.g. before AllowBlocks lets it
+// mine a block that should include it
+func (m *Miner) HasPending(txid []byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.MemoryPool.Has(txid)
+}
+
+// mayMine checks whether the miner may mine: always, unless it is in OnDemand mode and mined all the blocks
+// AllowBlocks allowed
+func (m *Miner) mayMine() bool {
+	return !m.OnDemand || atomic.LoadInt32(&m.allowedBlocks) > 0
+}
+
+// minedBlock counts a block the miner mined against the blocks AllowBlocks allowed
+func (m *Miner) minedBlock() {
+	if m.OnDemand {
+		atomic.AddInt32(&m.allowedBlocks, -1)
+	}
+}
+
+// NoncesPerRound is how many nonces each mining worker tries in one round, so the workers are started once per
+// round rather than once per nonce
+const NoncesPerRound = 8
+
+// mineRound has each worker try NoncesPerRound nonces and returns whether one solves the block. Rounds are paced
+// by blockchain.MiningDelay per nonce of a worker, as if each nonce was tried on its own, and the miner idles once
+// it used the mining share of the duty cycle window.
+func (m *Miner) mineRound(pow *blockchain.ProofOfWork) bool {
+	workers := m.miningWorkers()
+	solved := pow.Search(workers, uint32(workers*NoncesPerRound))
+	m.Clock.Sleep(NoncesPerRound * blockchain.MiningDelay)
+	if duty := m.miningDutyCycle(); duty < 100 {
+		now := m.Clock.Now()
+		if m.dutyStart.IsZero() {
+			m.dutyStart = now
+		}
+		if elapsed := now.Sub(m.dutyStart); elapsed >= DutyCycleWindow*time.Duration(duty)/100 {
+			atomic.StoreInt32(&m.miningPaused, 1)
+			m.Clock.Sleep(DutyCycleWindow - elapsed)
+			atomic.StoreInt32(&m.miningPaused, 0)
+			m.dutyStart = m.Clock.Now()
+		}
+	}
+	return solved
+}
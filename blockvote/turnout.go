@@ -0,0 +1,42 @@
+package blockvote
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+// Turnout is how many voters cast a ballot that counts, see QueryTurnout
+type Turnout struct {
+	Ballots          int     // ballots on the chain. a voter casts one per race
+	Voters           int     // distinct voter keys among them
+	RegisteredVoters int     // see Coord.RegisteredVoters. 0 if unknown
+	Percent          float64 // Voters out of RegisteredVoters. 0 if RegisteredVoters is unknown
+}
+
+// turnoutReply counts the turnout at the tip of the longest chain, once with every ballot on it and once with the
+// final ballots only
+func turnoutReply(chain *blockchain.BlockChain, registered int) QueryTurnoutReply {
+	tip := chain.GetLastHash()
+	return QueryTurnoutReply{
+		Height:   chain.GetHeader(tip).BlockNum,
+		LastHash: tip,
+		Current:  countTurnout(chain.CountedTxnsAt(tip, 0), registered),
+		Final:    countTurnout(chain.CountedTxnsAt(tip, chain.RequiredConfirmations()), registered),
+	}
+}
+
+func countTurnout(txns []*blockchain.Transaction, registered int) Turnout {
+	turnout := Turnout{RegisteredVoters: registered}
+	voters := make(map[string]bool)
+	for _, txn := range txns {
+		if txn.Unseals() {
+			continue
+		}
+		turnout.Ballots++
+		voters[string(txn.PublicKey)] = true
+	}
+	turnout.Voters = len(voters)
+	if registered > 0 {
+		turnout.Percent = 100 * float64(turnout.Voters) / float64(registered)
+	}
+	return turnout
+}
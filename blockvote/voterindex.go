@@ -0,0 +1,283 @@
+package blockvote
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// voterIndexVersion is the format of the entries of the voter index. An index of another format is rebuilt
+const voterIndexVersion = "2" // with the public key hash of each ballot
+
+// VoterStatusMaxAge bounds how far the time a CheckVoterStatus request was signed at may be off coord's clock
+const VoterStatusMaxAge = 5 * time.Minute
+
+// ErrBadVoterSignature is returned by CheckVoterStatus when the request is not signed by the voter key it names,
+// or was signed too long ago
+var ErrBadVoterSignature = errors.New("voter status request is not signed by the voter key")
+
+// voterIndex maps student IDs to their ballots on the longest chain. It is stored in coord's database and
+// updated with every block and fork switch, so looking up a voter never scans the chain. Student IDs are
+// only stored as HMACs under a random salt kept in the database, so the index is not a plaintext voter roll.
+type voterIndex struct {
+	mu   sync.Mutex
+	db   *util.Database
+	salt []byte
+}
+
+// openVoterIndex loads the voter index of db, rebuilding it from chain if it does not match the chain's tip
+func openVoterIndex(db *util.Database, chain *blockchain.BlockChain) (*voterIndex, error) {
+	idx := &voterIndex{db: db}
+	var err error
+	if db.KeyExist([]byte(VoterSaltKey)) {
+		idx.salt, err = db.Get([]byte(VoterSaltKey))
+	} else {
+		idx.salt = make([]byte, 32)
+		if _, err = rand.Read(idx.salt); err == nil {
+			err = db.Put([]byte(VoterSaltKey), idx.salt)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tip []byte
+	if db.KeyExist([]byte(VoterIndexTipKey)) {
+		if tip, err = db.Get([]byte(VoterIndexTipKey)); err != nil {
+			return nil, err
+		}
+	}
+	var version []byte
+	if db.KeyExist([]byte(VoterIndexVersionKey)) {
+		if version, err = db.Get([]byte(VoterIndexVersionKey)); err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(tip, chain.GetLastHash()) || string(version) != voterIndexVersion {
+		return idx, idx.rebuild(chain)
+	}
+	return idx, nil
+}
+
+// VoterStatusDigest is what a voter signs to read its ballots with CheckVoterStatus
+func VoterStatusDigest(genesis []byte, studentID string, publicKey []byte, sentAt time.Time) []byte {
+	hash := sha256.New()
+	for _, field := range [][]byte{[]byte("voter-status"), genesis, []byte(studentID), publicKey} {
+		binary.Write(hash, binary.BigEndian, uint32(len(field)))
+		hash.Write(field)
+	}
+	binary.Write(hash, binary.BigEndian, sentAt.UnixNano())
+	return hash.Sum(nil)
+}
+
+// checkVoterStatusArgs checks that a CheckVoterStatus request on the chain with the given genesis block is
+// signed by the voter key it names, at most VoterStatusMaxAge from now
+func checkVoterStatusArgs(args CheckVoterStatusArgs, genesis []byte, now time.Time) error {
+	if age := now.Sub(args.SentAt); age > VoterStatusMaxAge || age < -VoterStatusMaxAge {
+		return fmt.Errorf("%w: signed %v off coord's clock", ErrBadVoterSignature, age.Round(time.Second))
+	}
+	digest := VoterStatusDigest(genesis, args.StudentID, args.PublicKey, args.SentAt)
+	if !verifyVoterSignature(args.PublicKey, digest, args.Signature) {
+		return ErrBadVoterSignature
+	}
+	return nil
+}
+
+// verifyVoterSignature checks a signature of digest by a voter key, both laid out as in txns (see
+// Identity.Wallet.Sign)
+func verifyVoterSignature(publicKey []byte, digest []byte, signature []byte) bool {
+	if len(publicKey) == 0 || len(publicKey) > blockchain.MaxPublicKeyLen || len(signature) == 0 {
+		return false
+	}
+	pub := ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(publicKey[:len(publicKey)/2]),
+		Y:     new(big.Int).SetBytes(publicKey[len(publicKey)/2:]),
+	}
+	if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:len(signature)/2])
+	s := new(big.Int).SetBytes(signature[len(signature)/2:])
+	return ecdsa.Verify(&pub, digest, r, s)
+}
+
+// key returns the database key of a student ID
+func (idx *voterIndex) key(studentID string) []byte {
+	mac := hmac.New(sha256.New, idx.salt)
+	mac.Write([]byte(studentID))
+	return []byte(VoterKeyPrefix + hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Lookup returns the ballots of a student ID on the longest chain
+func (idx *voterIndex) Lookup(studentID string) ([]VoterBallot, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.get(idx.key(studentID))
+}
+
+func (idx *voterIndex) get(key []byte) ([]VoterBallot, error) {
+	if !idx.db.KeyExist(key) {
+		return nil, nil
+	}
+	data, err := idx.db.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var ballots []VoterBallot
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&ballots)
+	return ballots, err
+}
+
+// apply removes the txns of an abandoned fork and adds the txns new on the longest chain, whose tip is now tip
+func (idx *voterIndex) apply(added []*blockchain.Transaction, removed []*blockchain.Transaction, tip []byte) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	changed := make(map[string][]VoterBallot)
+	load := func(txn *blockchain.Transaction) (string, error) {
+		key := string(idx.key(txn.Data.VoterStudentID))
+		if _, ok := changed[key]; !ok {
+			ballots, err := idx.get([]byte(key))
+			if err != nil {
+				return "", err
+			}
+			changed[key] = ballots
+		}
+		return key, nil
+	}
+	for _, txn := range removed {
+		key, err := load(txn)
+		if err != nil {
+			return err
+		}
+		ballots := changed[key][:0]
+		for _, ballot := range changed[key] {
+			if !bytes.Equal(ballot.TxID, txn.ID) {
+				ballots = append(ballots, ballot)
+			}
+		}
+		changed[key] = ballots
+	}
+	for _, txn := range added {
+		if txn.Unseals() {
+			continue // not a ballot
+		}
+		key, err := load(txn)
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, ballot := range changed[key] {
+			known = known || bytes.Equal(ballot.TxID, txn.ID)
+		}
+		if !known {
+			changed[key] = append(changed[key], VoterBallot{TxID: txn.ID, Race: txn.Data.Race, Type: txn.Data.Type,
+				Voter: Identity.PublicKeyHash(txn.PublicKey)})
+		}
+	}
+
+	var keys, values, emptied [][]byte
+	for key, ballots := range changed {
+		if len(ballots) == 0 {
+			emptied = append(emptied, []byte(key))
+			continue
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(ballots); err != nil {
+			return err
+		}
+		keys = append(keys, []byte(key))
+		values = append(values, buf.Bytes())
+	}
+	if len(emptied) > 0 {
+		if err := idx.db.RemoveMulti(emptied); err != nil {
+			return err
+		}
+	}
+	// the tip is written last. an index interrupted before it is rebuilt on restart
+	keys = append(keys, []byte(VoterIndexTipKey))
+	values = append(values, tip)
+	return idx.db.PutMulti(keys, values)
+}
+
+// rebuild drops the index and builds it again from the longest chain
+func (idx *voterIndex) rebuild(chain *blockchain.BlockChain) error {
+	idx.mu.Lock()
+	var stale [][]byte
+	iter := idx.db.NewIterator(VoterKeyPrefix)
+	for iter.Next() {
+		stale = append(stale, append([]byte{}, iter.Key()...))
+	}
+	iter.Close()
+	var err error
+	if len(stale) > 0 {
+		err = idx.db.RemoveMulti(stale)
+	}
+	idx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tip := chain.GetLastHash()
+	var txns []*blockchain.Transaction
+	blocks := chain.NewIterator(tip)
+	for block, end := blocks.Next(); !end; block, end = blocks.Next() {
+		txns = append(txns, block.Txns...)
+	}
+	if err = idx.apply(txns, nil, tip); err != nil {
+		return err
+	}
+	return idx.db.Put([]byte(VoterIndexVersionKey), []byte(voterIndexVersion))
+}
+
+// audit counts the voters and ballots of the index and returns the voters with more ballots in a race than
+// maxVotes allows for it (1 if missing or 0)
+func (idx *voterIndex) audit(maxVotes map[string]uint8) (voters int, ballots int, overLimit []string, err error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	iter := idx.db.NewIterator(VoterKeyPrefix)
+	defer iter.Close()
+	for iter.Next() {
+		data, err := iter.Value()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		var list []VoterBallot
+		if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&list); err != nil {
+			return 0, 0, nil, err
+		}
+		voters++
+		ballots += len(list)
+		perRace := make(map[string]int)
+		for _, ballot := range list {
+			perRace[ballot.Race]++
+		}
+		for race, count := range perRace {
+			limit := int(maxVotes[race])
+			if limit == 0 {
+				limit = 1
+			}
+			if count > limit {
+				overLimit = append(overLimit, strings.TrimPrefix(string(iter.Key()), VoterKeyPrefix))
+				break
+			}
+		}
+	}
+	return voters, ballots, overLimit, nil
+}
@@ -0,0 +1,44 @@
+package blockvote
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+)
+
+func TestVoterStatusSignature(t *testing.T) {
+	genesis := []byte("genesis")
+	voter := Identity.NewWallet()
+	now := time.Now()
+	signed := func(studentID string, sentAt time.Time, signer *Identity.Wallet) CheckVoterStatusArgs {
+		args := CheckVoterStatusArgs{StudentID: studentID, PublicKey: voter.PublicKey, SentAt: sentAt}
+		signature, err := signer.Sign(VoterStatusDigest(genesis, studentID, voter.PublicKey, sentAt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		args.Signature = signature
+		return args
+	}
+	if err := checkVoterStatusArgs(signed("12345678", now, voter), genesis, now); err != nil {
+		t.Fatalf("signed by the voter key: %v", err)
+	}
+
+	forged := signed("12345678", now, voter)
+	forged.StudentID = "87654321"
+	for name, args := range map[string]CheckVoterStatusArgs{
+		"unsigned":              {StudentID: "12345678", PublicKey: voter.PublicKey, SentAt: now},
+		"signed by another key": signed("12345678", now, Identity.NewWallet()),
+		"another student ID":    forged,
+		"signed too long ago":   signed("12345678", now.Add(-2*VoterStatusMaxAge), voter),
+		"signed in the future":  signed("12345678", now.Add(2*VoterStatusMaxAge), voter),
+	} {
+		if err := checkVoterStatusArgs(args, genesis, now); !errors.Is(err, ErrBadVoterSignature) {
+			t.Errorf("%s: %v, want ErrBadVoterSignature", name, err)
+		}
+	}
+	if err := checkVoterStatusArgs(signed("12345678", now, voter), []byte("another genesis"), now); !errors.Is(err, ErrBadVoterSignature) {
+		t.Errorf("request for another election: %v, want ErrBadVoterSignature", err)
+	}
+}
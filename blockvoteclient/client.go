@@ -0,0 +1,301 @@
+package blockvoteclient
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+)
+
+// Version is the version of the API of the package. Minor versions only add to it
+const Version = "1.2.0"
+
+// Ballot is a ballot to cast, see blockchain.Ballot for the ballot types
+type Ballot = blockchain.Ballot
+
+// Candidate is a candidate on the ballot
+type Candidate = blockvote.CandidateInfo
+
+// RaceTally is the number of votes of every candidate of a race
+type RaceTally = blockvote.RaceTally
+
+// VoterBallot is a ballot of a voter on the longest chain
+type VoterBallot = blockvote.VoterBallot
+
+var (
+	// ErrClosed is returned by the calls made after Close
+	ErrClosed = errors.New("client is closed")
+	// ErrInvalidBallot is returned by Vote for a ballot the election does not take, wrapping why
+	ErrInvalidBallot = evlib.ErrInvalidBallot
+	// ErrElectionClosed is returned by Vote after the election deadline
+	ErrElectionClosed = evlib.ErrElectionClosed
+	// ErrAlreadyVoted is returned by Vote when the voter already cast all the ballots the race allows
+	ErrAlreadyVoted = evlib.ErrAlreadyVoted
+	// ErrRejected is returned by Vote when a miner finds the ballot invalid
+	ErrRejected = evlib.ErrTxnRejected
+	// ErrQuotaExceeded is returned by Vote when miners take no more ballots from the ClientID of Config.Options
+	ErrQuotaExceeded = evlib.ErrQuotaExceeded
+	// ErrNotFound is returned when coord or a miner does not have the requested object
+	ErrNotFound = evlib.ErrNotFound
+	// ErrWrongElection is returned by Dial when coord does not run the election
+	ErrWrongElection = evlib.ErrWrongElection
+)
+
+// Config is what Dial needs to know. Only CoordAddr is required
+type Config struct {
+	CoordAddr  string                  // coord's client API address
+	ElectionID string                  // election of coord to vote in. the default election if empty
+	Logger     *log.Logger             // where evlib logs to. dropped if nil
+	WalletDir  string                  // directory of the voters' wallet files. blockvote/wallets under os.UserConfigDir if empty and Options has no KeystoreSocket
+	Options    *blockvote.ClientConfig // retry policy, discovery cache, intent log, ... defaults if nil. CoordAddr and ElectionID override its fields
+}
+
+// TxID identifies a cast ballot
+type TxID []byte
+
+func (id TxID) String() string {
+	return hex.EncodeToString(id)
+}
+
+// ParseTxID parses a TxID printed by String
+func ParseTxID(s string) (TxID, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid txn ID: %v", err)
+	}
+	return id, nil
+}
+
+// Status is how far a ballot is from being counted
+type Status struct {
+	NumConfirmed int  // blocks confirming the ballot. -1 if it is not on the longest chain
+	Final        bool // the ballot has enough confirmations to be counted
+}
+
+// Results is the tally of the election at a block of the longest chain
+type Results struct {
+	Races    []RaceTally
+	Height   uint32 // block number the votes were counted at
+	LastHash []byte // hash of that block
+	Strict   bool   // only final ballots were counted
+}
+
+// Client is a connection to an election. It is safe for concurrent use
+type Client struct {
+	ev        *evlib.EV
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Dial connects to coord and fetches the candidates. It returns when the client is ready, or ctx is done
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	var opts blockvote.ClientConfig
+	if cfg.Options != nil {
+		opts = *cfg.Options
+	} else {
+		opts.SetDefaults()
+	}
+	opts.CoordIPPort = cfg.CoordAddr
+	opts.ElectionID = cfg.ElectionID
+	if cfg.WalletDir != "" {
+		opts.WalletDir = cfg.WalletDir
+	} else if opts.WalletDir == "" && opts.KeystoreSocket == "" {
+		// not the working directory of the service
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("no WalletDir: %v", err)
+		}
+		opts.WalletDir = filepath.Join(dir, "blockvote", "wallets")
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	ev := evlib.NewEV()
+	ev.Logger = cfg.Logger
+	if ev.Logger == nil {
+		ev.Logger = log.New(ioutil.Discard, "", 0)
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- ev.StartWithConfig(nil, &opts)
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			return nil, err
+		}
+		return &Client{ev: ev, closed: make(chan struct{})}, nil
+	case <-ctx.Done():
+		go func() {
+			// evlib cannot be stopped while it is starting
+			if err := <-started; err == nil {
+				ev.Stop()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops the background work of the client. Unconfirmed ballots are no longer resubmitted
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.ev.Stop()
+	})
+	return nil
+}
+
+// do runs fn until it returns, ctx is done or the client is closed. fn keeps running in the background in the
+// latter cases, so what it sets must only be read when do returns nil
+func (c *Client) do(ctx context.Context, fn func() error) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed:
+		return ErrClosed
+	}
+}
+
+// Candidates lists the candidates of every race in ballot order
+func (c *Client) Candidates() []Candidate {
+	return c.ev.Candidates()
+}
+
+// Races lists the races of the election. A single empty name in an election with a single race
+func (c *Client) Races() []string {
+	return c.ev.Races()
+}
+
+// ElectionEnd is when the election closes. Zero if it never does
+func (c *Client) ElectionEnd() time.Time {
+	return c.ev.ElectionEnd
+}
+
+// Validate checks a ballot without casting it. The error wraps ErrInvalidBallot
+func (c *Client) Validate(ballot Ballot) error {
+	if err := c.ev.ValidateBallot(evlib.CanonicalBallot(ballot)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBallot, err)
+	}
+	return nil
+}
+
+// Vote signs and casts a ballot and returns its TxID once miners accepted it. The client resubmits the ballot
+// until it is on the longest chain, as long as it is not closed
+func (c *Client) Vote(ctx context.Context, ballot Ballot) (TxID, error) {
+	if err := c.Validate(ballot); err != nil {
+		return nil, err
+	}
+	var txid []byte
+	err := c.do(ctx, func() (err error) {
+		txid, err = c.ev.Vote(ballot)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txid, nil
+}
+
+// Status returns how far the ballot is from being counted
+func (c *Client) Status(ctx context.Context, txid TxID) (Status, error) {
+	var numConfirmed int
+	var final bool
+	err := c.do(ctx, func() (err error) {
+		numConfirmed, final, err = c.ev.GetBallotFinality(txid)
+		return
+	})
+	if err != nil {
+		return Status{NumConfirmed: -1}, err
+	}
+	return Status{NumConfirmed: numConfirmed, Final: final}, nil
+}
+
+// Statuses is Status of many ballots at once
+func (c *Client) Statuses(ctx context.Context, txids []TxID) ([]Status, error) {
+	ids := make([][]byte, len(txids))
+	for i, txid := range txids {
+		ids[i] = txid
+	}
+	var numConfirmed []int
+	var final []bool
+	err := c.do(ctx, func() (err error) {
+		numConfirmed, final, err = c.ev.GetBallotFinalities(ids)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(txids))
+	for i := range statuses {
+		statuses[i] = Status{NumConfirmed: numConfirmed[i], Final: final[i]}
+	}
+	return statuses, nil
+}
+
+// WaitFinal checks the status of the ballot every interval until it is final or ctx is done
+func (c *Client) WaitFinal(ctx context.Context, txid TxID, interval time.Duration) (Status, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := c.Status(ctx, txid)
+		if err != nil || status.Final {
+			return status, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-c.closed:
+			return status, ErrClosed
+		}
+	}
+}
+
+// VoterBallots lists the ballots a voter cast on the longest chain with its key on this client
+func (c *Client) VoterBallots(ctx context.Context, voterName string, studentID string) ([]VoterBallot, error) {
+	var ballots []VoterBallot
+	err := c.do(ctx, func() (err error) {
+		ballots, err = c.ev.CheckVoterStatus(voterName, studentID)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ballots, nil
+}
+
+// Results returns the tally of every race at the tip of the longest chain. Results are cached for the
+// ResultsTTL of Config.Options
+func (c *Client) Results(ctx context.Context) (*Results, error) {
+	var res *evlib.Results
+	err := c.do(ctx, func() (err error) {
+		res, err = c.ev.GetResults(false)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Results{Races: res.Races, Height: res.Height, LastHash: res.LastHash, Strict: res.Strict}, nil
+}
@@ -0,0 +1,103 @@
+package blockvoteclient_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvoteclient"
+	"cs.ubc.ca/cpsc416/BlockVote/testkit"
+)
+
+func TestParseTxID(t *testing.T) {
+	if _, err := blockvoteclient.ParseTxID("not hex"); err == nil {
+		t.Error("ParseTxID accepts a txn ID that is not hex")
+	}
+	txid, err := blockvoteclient.ParseTxID("00c0ffee")
+	if err != nil || txid.String() != "00c0ffee" {
+		t.Errorf("ParseTxID: %v, %v", txid, err)
+	}
+}
+
+func TestDialInvalidConfig(t *testing.T) {
+	for name, cfg := range map[string]blockvoteclient.Config{
+		"no coord":         {WalletDir: t.TempDir()},
+		"bad election ID":  {CoordAddr: "127.0.0.1:8001", ElectionID: "../up", WalletDir: t.TempDir()},
+		"unresolved coord": {CoordAddr: "coord", WalletDir: t.TempDir()},
+	} {
+		if _, err := blockvoteclient.Dial(context.Background(), cfg); err == nil {
+			t.Errorf("%s: Dial accepts the config", name)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	c, err := testkit.Start(testkit.Options{Miners: 1})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer c.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	client, err := blockvoteclient.Dial(ctx, blockvoteclient.Config{
+		CoordAddr: c.CoordClientAddr(),
+		WalletDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	cand := client.Candidates()[0]
+	ballot := blockvoteclient.Ballot{VoterName: "client-voter", VoterStudentID: "12345678",
+		VoterCandidate: cand.Name, Race: cand.Race}
+	invalid := ballot
+	invalid.VoterCandidate = "nobody"
+	if _, err = client.Vote(ctx, invalid); !errors.Is(err, blockvoteclient.ErrInvalidBallot) {
+		t.Fatalf("Vote for an unknown candidate: %v, want ErrInvalidBallot", err)
+	}
+	txid, err := client.Vote(ctx, ballot)
+	if err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	for {
+		status, err := client.Status(ctx, txid)
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		if status.NumConfirmed >= 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	ballots, err := client.VoterBallots(ctx, ballot.VoterName, ballot.VoterStudentID)
+	if err != nil || len(ballots) != 1 {
+		t.Fatalf("VoterBallots: %v, %v, want the ballot cast", ballots, err)
+	}
+	if _, err = client.Vote(ctx, ballot); !errors.Is(err, blockvoteclient.ErrAlreadyVoted) {
+		t.Fatalf("second Vote of the voter: %v, want ErrAlreadyVoted", err)
+	}
+	results, err := client.Results(ctx)
+	if err != nil {
+		t.Fatalf("Results: %v", err)
+	}
+	var votes uint
+	for _, race := range results.Races {
+		for _, n := range race.Votes {
+			votes += n
+		}
+	}
+	if votes != 1 {
+		t.Errorf("%d votes counted, want 1", votes)
+	}
+
+	client.Close()
+	if _, err = client.Vote(ctx, ballot); !errors.Is(err, blockvoteclient.ErrClosed) {
+		t.Errorf("Vote after Close: %v, want ErrClosed", err)
+	}
+}
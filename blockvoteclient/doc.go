@@ -0,0 +1,39 @@
+// Package blockvoteclient is the client of BlockVote for other Go services, e.g. a registration portal or a
+// results dashboard. It wraps evlib with an API that is kept stable across releases (see Version): calls take
+// a context, errors can be told apart with errors.Is, and nothing is read from stdin or printed. evlib's logs
+// go to Config.Logger and are dropped if it is nil.
+//
+// Casting a ballot and waiting until it is final:
+//
+//	client, err := blockvoteclient.Dial(ctx, blockvoteclient.Config{CoordAddr: "127.0.0.1:8001"})
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//	txid, err := client.Vote(ctx, blockvoteclient.Ballot{
+//		VoterName:      "Alice",
+//		VoterStudentID: "12345678",
+//		VoterCandidate: client.Candidates()[0].Name,
+//	})
+//	if errors.Is(err, blockvoteclient.ErrAlreadyVoted) {
+//		return nil
+//	} else if err != nil {
+//		return err
+//	}
+//	status, err := client.WaitFinal(ctx, txid, 5*time.Second)
+//
+// Showing the results of every race:
+//
+//	results, err := client.Results(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	for _, race := range results.Races {
+//		for i, cand := range race.Candidates {
+//			fmt.Printf("%s %s: %d\n", race.Race, cand, race.Votes[i])
+//		}
+//	}
+//
+// A context only bounds how long a call waits. evlib keeps retrying in the background, so a ballot whose Vote
+// was canceled may still be cast; look it up with VoterBallots before casting it again.
+package blockvoteclient
@@ -0,0 +1,85 @@
+package blockvoteclient_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvoteclient"
+)
+
+func ExampleDial() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	client, err := blockvoteclient.Dial(ctx, blockvoteclient.Config{
+		CoordAddr: "127.0.0.1:8001",
+		WalletDir: "/var/lib/portal/wallets",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	for _, cand := range client.Candidates() {
+		fmt.Println(cand.Race, cand.Name)
+	}
+}
+
+func ExampleClient_Vote() {
+	ctx := context.Background()
+	client, err := blockvoteclient.Dial(ctx, blockvoteclient.Config{CoordAddr: "127.0.0.1:8001"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	cand := client.Candidates()[0]
+	txid, err := client.Vote(ctx, blockvoteclient.Ballot{
+		VoterName:      "Alice",
+		VoterStudentID: "12345678",
+		VoterCandidate: cand.Name,
+		Race:           cand.Race,
+	})
+	switch {
+	case errors.Is(err, blockvoteclient.ErrAlreadyVoted):
+		fmt.Println("Alice has voted already")
+		return
+	case errors.Is(err, blockvoteclient.ErrInvalidBallot):
+		fmt.Println("not a ballot of the election:", err)
+		return
+	case err != nil:
+		log.Fatal(err)
+	}
+	status, err := client.WaitFinal(ctx, txid, 5*time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("ballot %v is final with %d confirmations\n", txid, status.NumConfirmed)
+}
+
+func ExampleClient_Results() {
+	ctx := context.Background()
+	client, err := blockvoteclient.Dial(ctx, blockvoteclient.Config{CoordAddr: "127.0.0.1:8001"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+	results, err := client.Results(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, race := range results.Races {
+		for i, cand := range race.Candidates {
+			fmt.Printf("%s %s: %d\n", race.Race, cand, race.Votes[i])
+		}
+	}
+}
+
+func ExampleParseTxID() {
+	txid, err := blockvoteclient.ParseTxID("00c0ffee")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(len(txid), txid)
+	// Output: 4 00c0ffee
+}
@@ -0,0 +1,286 @@
+// Package chaos soak-tests a running in-process cluster: miners are crashed and restarted, the network is
+// partitioned and blocks are delayed at random while clients keep voting. No accepted ballot may be
+// missing from the final chain.
+package chaos
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+	"cs.ubc.ca/cpsc416/BlockVote/testkit"
+)
+
+type Config struct {
+	Clients        int           // number of clients casting ballots
+	Duration       time.Duration // how long to inject faults for
+	FaultInterval  time.Duration // time between two faults
+	BallotInterval time.Duration // time between two ballots of a client
+	CheckInterval  time.Duration // time between two checks of accepted ballots against coord's chain
+	MaxBlockDelay  time.Duration // upper bound of block delays. no delays if 0 or without -tags faultinject
+	ConfirmTimeout time.Duration // how long to wait for the cluster to converge once faults stop
+	Seed           int64
+}
+
+type Report struct {
+	Duration  time.Duration
+	Faults    map[string]int // number of injected faults by kind
+	Accepted  int            // ballots a miner accepted
+	Rejected  int            // ballots no miner accepted. they are not checked
+	Finalized int            // accepted ballots that were final on coord's chain at some check
+	Reverted  int            // ballots that were final at one check and missing at a later one
+	Lost      int            // accepted ballots missing from the final chain
+	LostTxIDs []string
+}
+
+type ballotRecord struct {
+	txid      []byte
+	finalized bool
+	reverted  bool
+}
+
+type runner struct {
+	cfg     Config
+	cluster *testkit.Cluster
+	miners  int
+	rng     *rand.Rand
+	report  *Report
+	crashed map[int]bool
+
+	mu      sync.Mutex
+	records []*ballotRecord
+}
+
+// Run injects faults into a running cluster for cfg.Duration while clients vote, then heals the cluster
+// and checks that every accepted ballot is on the final chain. The miners of the cluster must all be running
+func Run(cluster *testkit.Cluster, cfg Config) (*Report, error) {
+	miners := cluster.NumMiners()
+	if miners < 2 || cfg.Clients <= 0 {
+		return nil, errors.New("need at least 2 miners and 1 client")
+	}
+	if cfg.FaultInterval == 0 {
+		cfg.FaultInterval = 10 * time.Second
+	}
+	if cfg.BallotInterval == 0 {
+		cfg.BallotInterval = time.Second
+	}
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = 30 * time.Second
+	}
+	if cfg.ConfirmTimeout == 0 {
+		cfg.ConfirmTimeout = 5 * time.Minute
+	}
+
+	r := &runner{
+		cfg:     cfg,
+		cluster: cluster,
+		miners:  miners,
+		rng:     rand.New(rand.NewSource(cfg.Seed)),
+		report:  &Report{Faults: make(map[string]int)},
+		crashed: make(map[int]bool),
+	}
+	clients := make([]*evlib.EV, cfg.Clients)
+	for i := range clients {
+		var err error
+		if clients[i], err = cluster.NewClient(); err != nil {
+			return nil, err
+		}
+		if len(clients[i].CandidateList) == 0 {
+			return nil, errors.New("coord has no candidates")
+		}
+	}
+
+	// voting phase: clients vote until the faults stop
+	start := time.Now()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *evlib.EV) {
+			defer wg.Done()
+			r.vote(i, client, stop)
+		}(i, client)
+	}
+
+	faultTicker := time.NewTicker(cfg.FaultInterval)
+	checkTicker := time.NewTicker(cfg.CheckInterval)
+	end := time.After(cfg.Duration)
+loop:
+	for {
+		select {
+		case <-faultTicker.C:
+			if err := r.injectFault(); err != nil {
+				log.Println("[WARN] Fault injection failed:", err)
+			}
+		case <-checkTicker.C:
+			r.check(false)
+		case <-end:
+			break loop
+		}
+	}
+	faultTicker.Stop()
+	checkTicker.Stop()
+	close(stop)
+	wg.Wait()
+	r.report.Duration = time.Since(start)
+
+	// recovery phase: undo all faults and wait for every node to agree
+	r.heal()
+	if _, err := cluster.WaitForConvergence(cfg.ConfirmTimeout); err != nil {
+		return r.report, err
+	}
+	r.check(true)
+	return r.report, nil
+}
+
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "duration:  %v\n", r.Duration.Round(time.Second))
+	fmt.Fprintf(&buf, "faults:    %v\n", r.Faults)
+	fmt.Fprintf(&buf, "accepted:  %d\n", r.Accepted)
+	fmt.Fprintf(&buf, "rejected:  %d\n", r.Rejected)
+	fmt.Fprintf(&buf, "finalized: %d\n", r.Finalized)
+	fmt.Fprintf(&buf, "reverted:  %d\n", r.Reverted)
+	fmt.Fprintf(&buf, "lost:      %d\n", r.Lost)
+	for _, txid := range r.LostTxIDs {
+		fmt.Fprintf(&buf, "  %s\n", txid)
+	}
+	return buf.String()
+}
+
+// ----- utility functions -----
+
+// vote casts a ballot every BallotInterval until stop is closed
+func (r *runner) vote(client int, ev *evlib.EV, stop <-chan struct{}) {
+	rng := rand.New(rand.NewSource(r.cfg.Seed + int64(client) + 1))
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		case <-time.After(r.cfg.BallotInterval):
+		}
+		cand := rng.Intn(len(ev.CandidateList))
+		txid, err := ev.Vote(blockchain.Ballot{
+			VoterName:      fmt.Sprintf("chaos-voter%d-%d", client, i),
+			VoterStudentID: fmt.Sprintf("%04d%04d", client, i),
+			VoterCandidate: ev.CandidateList[cand],
+			Race:           ev.CandidateRaces[cand],
+		})
+		r.mu.Lock()
+		if err != nil {
+			r.report.Rejected++
+		} else {
+			r.report.Accepted++
+			r.records = append(r.records, &ballotRecord{txid: txid})
+		}
+		r.mu.Unlock()
+	}
+}
+
+// injectFault crashes or restarts a miner, changes the partition or changes the block delay
+func (r *runner) injectFault() error {
+	var alive, down []int
+	for idx := 0; idx < r.miners; idx++ {
+		if r.crashed[idx] {
+			down = append(down, idx)
+		} else {
+			alive = append(alive, idx)
+		}
+	}
+	switch r.rng.Intn(5) {
+	case 0:
+		// keep one miner running so that the chain always grows somewhere
+		if len(alive) > 1 {
+			idx := alive[r.rng.Intn(len(alive))]
+			log.Printf("[INFO] Crashing miner%d\n", idx+1)
+			r.cluster.CrashMiner(idx)
+			r.crashed[idx] = true
+			r.report.Faults["crash"]++
+		}
+	case 1:
+		if len(down) > 0 {
+			idx := down[r.rng.Intn(len(down))]
+			log.Printf("[INFO] Restarting miner%d\n", idx+1)
+			if err := r.cluster.RestartMiner(idx); err != nil {
+				return err
+			}
+			delete(r.crashed, idx)
+			r.report.Faults["restart"]++
+		}
+	case 2:
+		groups := make([][]int, 2)
+		groups[0] = []int{testkit.CoordNode}
+		for idx := 0; idx < r.miners; idx++ {
+			g := r.rng.Intn(2)
+			groups[g] = append(groups[g], idx)
+		}
+		log.Println("[INFO] Partitioning the network into", groups)
+		r.cluster.Partition(groups...)
+		r.report.Faults["partition"]++
+	case 3:
+		log.Println("[INFO] Healing the partition")
+		r.cluster.Heal()
+		r.report.Faults["heal"]++
+	case 4:
+		if r.cfg.MaxBlockDelay > 0 {
+			delay := time.Duration(r.rng.Int63n(int64(r.cfg.MaxBlockDelay)))
+			if setBlockDelay(delay) {
+				log.Println("[INFO] Delaying blocks by up to", delay)
+				r.report.Faults["delay"]++
+			}
+		}
+	}
+	return nil
+}
+
+// heal restarts crashed miners, removes the partition and the block delay
+func (r *runner) heal() {
+	setBlockDelay(0)
+	r.cluster.Heal()
+	for idx := range r.crashed {
+		if err := r.cluster.RestartMiner(idx); err != nil {
+			log.Printf("[WARN] Failed to restart miner%d: %v\n", idx+1, err)
+			continue
+		}
+		delete(r.crashed, idx)
+	}
+}
+
+// check looks up accepted ballots on coord's chain. Final ballots must stay on the chain, and with
+// last set every accepted ballot must be there.
+func (r *runner) check(last bool) {
+	coord := r.cluster.RunningCoord()
+	if coord == nil {
+		return
+	}
+	depth := coord.FinalityDepth
+	if depth == 0 {
+		depth = blockchain.NumConfirmed
+	}
+	r.mu.Lock()
+	records := r.records
+	r.mu.Unlock()
+	for _, rec := range records {
+		_, _, numConfirmed := coord.Blockchain.FindTxn(rec.txid)
+		switch {
+		case numConfirmed >= depth && !rec.finalized:
+			rec.finalized = true
+			r.report.Finalized++
+		case numConfirmed < 0 && rec.finalized && !rec.reverted:
+			log.Println("[ERROR] Final ballot is missing from coord's chain:", hex.EncodeToString(rec.txid))
+			rec.reverted = true
+			r.report.Reverted++
+		}
+		if last && numConfirmed < 0 {
+			r.report.Lost++
+			r.report.LostTxIDs = append(r.report.LostTxIDs, hex.EncodeToString(rec.txid))
+		}
+	}
+}
@@ -0,0 +1,32 @@
+//go:build faultinject
+// +build faultinject
+
+package chaos
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+// setBlockDelay delays every gossip call by up to delay, which holds back the blocks they carry. 0 removes the delay.
+func setBlockDelay(delay time.Duration) bool {
+	if delay == 0 {
+		util.SetFaultPolicy(nil)
+		return true
+	}
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(int64(delay)))
+	util.SetFaultPolicy(func(call util.RPCCall) util.FaultAction {
+		if call.Server || !strings.HasPrefix(call.ServiceMethod, "RPCHandler.") {
+			return util.FaultAction{}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return util.FaultAction{Delay: time.Duration(rng.Int63n(int64(delay)))}
+	})
+	return true
+}
@@ -0,0 +1,11 @@
+//go:build !faultinject
+// +build !faultinject
+
+package chaos
+
+import "time"
+
+// setBlockDelay needs the fault injection hooks of util, which are only built with -tags faultinject
+func setBlockDelay(delay time.Duration) bool {
+	return false
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+const usage = `Usage: audit [flags]
+
+Exports the longest chain as JSON lines: one line per ballot from genesis to the tip with its block height,
+timestamp, candidate and voter commitment (public key hash), then a summary line with the tallies.
+
+Run "audit diff -h" to compare two copies of the chain instead.
+
+Flags:
+`
+
+const diffUsage = `Usage: audit diff [flags] A B
+
+Compares the longest chains of two copies of the election and reports the blocks after the last common block,
+the txns on one chain and not the other, and the candidates whose votes differ. A and B are each a database
+directory, a database backup file or coord's miner API address. Exits with status 1 if the chains differ.
+
+Flags:
+`
+
+func main() {
+	var config blockvote.CoordConfig
+	util.ReadJSONConfig("config/coord_config.json", &config)
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diff(config, os.Args[2:])
+		return
+	}
+
+	var coordAddr, electionID, dbPath, snapshot, keyFile, output string
+	var verify bool
+	flag.StringVar(&coordAddr, "coord", config.MinerAPIListenAddr, "coord's miner API address to download the chain from")
+	flag.StringVar(&electionID, "election", config.ElectionID, "election of coord to download the chain of")
+	flag.StringVar(&dbPath, "db", "", "read a database directory directly instead of contacting coord")
+	flag.StringVar(&snapshot, "snapshot", "", "read a database backup file instead of contacting coord")
+	flag.StringVar(&keyFile, "key", "", "storage key file if the database is encrypted")
+	flag.StringVar(&output, "o", "", "file to write the export to. standard output if empty")
+	flag.BoolVar(&verify, "verify", true, "verify every block and ballot before exporting")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	chain, err := blockvote.OpenChain(coordAddr, electionID, dbPath, snapshot, keyFile)
+	util.CheckErr(err, "Unable to open the blockchain: %v\n", err)
+	if verify {
+		err = chain.VerifyChain()
+		util.CheckErr(err, "The chain does not verify: %v\n", err)
+	}
+
+	out := os.Stdout
+	if output != "" {
+		out, err = os.Create(output)
+		util.CheckErr(err, "Unable to create %s: %v\n", output, err)
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	err = chain.WriteAudit(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	util.CheckErr(err, "Unable to write the export: %v\n", err)
+}
+
+// diff runs the diff subcommand
+func diff(config blockvote.CoordConfig, args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	var electionID, keyFile string
+	var verify bool
+	flags.StringVar(&electionID, "election", config.ElectionID, "election of coord to download a chain of")
+	flags.StringVar(&keyFile, "key", "", "storage key file if the databases are encrypted")
+	flags.BoolVar(&verify, "verify", true, "verify every block and ballot of both chains before comparing")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, diffUsage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var chains [2]*blockchain.BlockChain
+	for i, source := range flags.Args() {
+		chain, err := openSource(source, electionID, keyFile)
+		util.CheckErr(err, "Unable to open the blockchain at %s: %v\n", source, err)
+		chains[i] = chain
+	}
+	// a miner's database has no candidate list, its ballots are checked against the other one's
+	for i, chain := range chains {
+		if len(chain.Candidates) == 0 {
+			chain.Candidates = chains[1-i].Candidates
+		}
+	}
+	if verify {
+		for i, chain := range chains {
+			err := chain.VerifyChain()
+			util.CheckErr(err, "The chain at %s does not verify: %v\n", flags.Arg(i), err)
+		}
+	}
+
+	d := blockchain.DiffChains(chains[0], chains[1])
+	fmt.Printf("A: tip #%d %x\n", d.TipA.BlockNum, d.TipA.Hash)
+	fmt.Printf("B: tip #%d %x\n", d.TipB.BlockNum, d.TipB.Hash)
+	if d.Common == nil {
+		fmt.Println("The chains do not share a genesis block")
+	} else {
+		fmt.Printf("Last common block: #%d %x\n", d.Common.BlockNum, d.Common.Hash)
+	}
+	for _, side := range []struct {
+		name   string
+		blocks []blockchain.BlockHeader
+	}{{"A", d.OnlyA}, {"B", d.OnlyB}} {
+		for _, header := range side.blocks {
+			fmt.Printf("Only on %s: block #%d %x by %s\n", side.name, header.BlockNum, header.Hash, header.MinerID)
+		}
+	}
+	for _, txid := range d.MissingInB {
+		fmt.Printf("Missing from B: txn %x\n", txid)
+	}
+	for _, txid := range d.MissingInA {
+		fmt.Printf("Missing from A: txn %x\n", txid)
+	}
+	for _, tally := range d.TallyDiffs {
+		fmt.Printf("Tally differs: race %q candidate %q has %d votes on A, %d on B\n", tally.Race, tally.Candidate, tally.VotesA, tally.VotesB)
+	}
+	if !d.Same() {
+		os.Exit(1)
+	}
+	fmt.Println("The chains are the same")
+}
+
+// openSource opens a chain from a database directory, a database backup file or coord's address
+func openSource(source string, electionID string, keyFile string) (*blockchain.BlockChain, error) {
+	info, err := os.Stat(source)
+	switch {
+	case err != nil:
+		return blockvote.OpenChain(source, electionID, "", "", keyFile)
+	case info.IsDir():
+		return blockvote.OpenChain("", electionID, source, "", keyFile)
+	default:
+		return blockvote.OpenChain("", electionID, "", source, keyFile)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"github.com/DistributedClocks/tracing"
+)
+
+// blockvote runs a coord, a miner or an interactive client, so a deployment only ships one binary.
+// Every role reads the same kind of config file as its own binary, and flags override it the same way.
+
+var defaultConfigs = map[string]string{
+	"coord":  "config/coord_config.json",
+	"miner":  "config/miner_config.json",
+	"client": "config/client_config.json",
+}
+
+func main() {
+	var role, cfgPath string
+	var trace, restart, verbose, dev bool
+	var restore, storageDir, elections, recoverFrom string
+	var minerID, minerAddr, coordAddr, electionID string
+	flag.StringVar(&role, "role", "", "what to run: coord, miner or client")
+	flag.StringVar(&cfgPath, "config", "", "config file (BLOCKVOTE_CONFIG or the role's file under config/ if empty)")
+	flag.BoolVar(&trace, "trace", false, "send traces to the tracing server")
+	flag.BoolVar(&restart, "r", false, "coord: keep the database of an earlier run")
+	flag.StringVar(&restore, "restore", "", "coord, miner: backup file to restore the database from")
+	flag.StringVar(&storageDir, "storage", filepath.Join("storage", "coord"), "coord: database directory")
+	flag.StringVar(&elections, "elections", "", "coord: comma-separated config files of more elections to host at the same addresses")
+	flag.StringVar(&recoverFrom, "recover-from", "", "coord: comma-separated admin API addresses of miners to rebuild a lost database from")
+	flag.StringVar(&minerID, "id", "", "miner: miner ID")
+	flag.StringVar(&minerAddr, "addr", "", "miner: address to listen at")
+	flag.StringVar(&coordAddr, "coord", "", "miner, client: coord's address")
+	flag.StringVar(&electionID, "election", "", "miner, client: election to join (the default election if empty)")
+	flag.BoolVar(&verbose, "v", false, "client: print evlib logs")
+	flag.BoolVar(&dev, "dev", false, "coord, miner: dev mode, accept dev voters. never on a production chain")
+	flag.Parse()
+
+	if defaultConfigs[role] == "" {
+		fmt.Fprintln(os.Stderr, "-role must be one of coord, miner or client")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if cfgPath == "" {
+		cfgPath = config.Path(defaultConfigs[role])
+	}
+
+	switch role {
+	case "coord":
+		var cfg blockvote.CoordConfig
+		config.MustDecode(cfgPath, &cfg) // validated by the preflight check of LaunchCoord
+		if recoverFrom != "" {
+			cfg.RecoverFrom = strings.Split(recoverFrom, ",")
+		}
+		launch := blockvote.CoordLaunch{StorageDir: storageDir, Restart: restart, RestoreFrom: restore, Dev: dev}
+		if elections != "" {
+			launch.Elections = strings.Split(elections, ",")
+		}
+		runCoord(&cfg, launch, trace)
+	case "miner":
+		var cfg blockvote.MinerConfig
+		config.MustDecode(cfgPath, &cfg) // validated by the preflight check of runMiner
+		setIfGiven(&cfg.MinerId, minerID)
+		setIfGiven(&cfg.MinerAddr, minerAddr)
+		setIfGiven(&cfg.CoordAddr, coordAddr)
+		setIfGiven(&cfg.ElectionID, electionID)
+		runMiner(&cfg, restore, trace, dev)
+	case "client":
+		var cfg blockvote.ClientConfig
+		config.MustLoad(cfgPath, &cfg)
+		setIfGiven(&cfg.CoordIPPort, coordAddr)
+		setIfGiven(&cfg.ElectionID, electionID)
+		if !verbose {
+			log.SetOutput(ioutil.Discard)
+		}
+		runClient(&cfg, trace)
+	}
+}
+
+// setIfGiven overrides a config field with a flag that was set
+func setIfGiven(field *string, val string) {
+	if val != "" {
+		*field = val
+	}
+}
+
+func runCoord(cfg *blockvote.CoordConfig, launch blockvote.CoordLaunch, trace bool) {
+	if trace {
+		launch.Tracer = tracing.NewTracer(tracing.TracerConfig{
+			ServerAddress:  cfg.TracingServerAddr,
+			TracerIdentity: cfg.TracingIdentity,
+			Secret:         cfg.Secret,
+		})
+	}
+	if err := blockvote.LaunchCoord(cfg, launch); err != nil {
+		log.Fatalln("[ERROR] Coord stopped:", err)
+	}
+}
+
+func runMiner(cfg *blockvote.MinerConfig, restore string, trace bool, dev bool) {
+	if err := cfg.Preflight(); err != nil {
+		log.Fatalf("[ERROR] Miner cannot start with this config:\n%v\n", err)
+	}
+	var mtracer *tracing.Tracer
+	if trace {
+		mtracer = tracing.NewTracer(tracing.TracerConfig{
+			ServerAddress:  cfg.TracingServerAddr,
+			TracerIdentity: cfg.MinerId,
+			Secret:         cfg.Secret,
+		})
+	}
+	miner := blockvote.NewMiner()
+	miner.Dev = dev
+	miner.RestoreFrom = restore
+	if err := miner.StartWithConfig(cfg, mtracer); err != nil {
+		log.Fatalln("[ERROR] Miner stopped:", err)
+	}
+}
+
+const replHelp = `Commands:
+  candidates       list the candidates with their statements
+  vote             cast a ballot, prompting for it
+  status <txid>    number of blocks confirming a submitted ballot
+  results          votes of every candidate, by race
+  turnout          voters who cast a ballot, with all ballots and with final ballots only
+  help             print this
+  quit             stop the client`
+
+// runClient reads commands from stdin until quit or EOF. evlib keeps resubmitting unconfirmed ballots
+// in the background as long as the REPL runs
+func runClient(cfg *blockvote.ClientConfig, trace bool) {
+	var ctracer *tracing.Tracer
+	if trace {
+		ctracer = tracing.NewTracer(tracing.TracerConfig{
+			ServerAddress:  cfg.TracingServerAddr,
+			TracerIdentity: cfg.TracingIdentity,
+			Secret:         cfg.Secret,
+		})
+	}
+	client := evlib.NewEV()
+	err := client.StartWithConfig(ctracer, cfg)
+	util.CheckErr(err, "Unable to start evlib: %v\n", err)
+	defer client.Stop()
+
+	fmt.Println(replHelp)
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); scanner.Scan(); fmt.Print("> ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "candidates":
+			for _, cand := range client.Candidates() {
+				if cand.Race != "" {
+					fmt.Printf("%s #%d: ", cand.Race, cand.Position)
+				} else {
+					fmt.Printf("#%d: ", cand.Position)
+				}
+				fmt.Printf("%s (wallet %s)", cand.Name, cand.Address)
+				if cand.Statement != "" {
+					fmt.Printf(", statement at %s", cand.Statement)
+				}
+				fmt.Println()
+			}
+		case "vote":
+			ballot, err := client.CreateBallot()
+			if err != nil {
+				fmt.Println("Unable to create a ballot:", err)
+				continue
+			}
+			txid, err := client.Vote(ballot)
+			if err != nil {
+				fmt.Println("Unable to vote:", err)
+				continue
+			}
+			fmt.Printf("Ballot submitted. TxID: %x\n", txid)
+		case "status":
+			if len(fields) != 2 {
+				fmt.Println("Usage: status <txid>")
+				continue
+			}
+			txid, err := hex.DecodeString(fields[1])
+			if err != nil {
+				fmt.Println("Invalid txn ID:", err)
+				continue
+			}
+			numConfirmed, finalized, err := client.GetBallotFinality(txid)
+			if err != nil {
+				fmt.Println("Unable to query txn status:", err)
+			} else if numConfirmed < 0 {
+				fmt.Println("Txn is not on the longest chain yet")
+			} else if !finalized {
+				fmt.Printf("Txn is pending, %d/%d blocks confirm it\n", numConfirmed, client.FinalityDepth)
+			} else {
+				fmt.Printf("Txn is confirmed by %d blocks\n", numConfirmed)
+			}
+		case "results":
+			races, err := client.GetRaceResults()
+			if err != nil {
+				fmt.Println("Unable to get results:", err)
+				continue
+			}
+			for _, race := range races {
+				if race.Race != "" {
+					fmt.Println(race.Race + ":")
+				}
+				for i, cand := range race.Candidates {
+					fmt.Printf("  %s: %d\n", cand, race.Votes[i])
+				}
+			}
+		case "turnout":
+			turnout, err := client.QueryTurnout()
+			if err != nil {
+				fmt.Println("Unable to get the turnout:", err)
+				continue
+			}
+			fmt.Printf("At block #%d:\n", turnout.Height)
+			for _, t := range []struct {
+				name    string
+				turnout blockvote.Turnout
+			}{{"all ballots", turnout.Current}, {"final ballots", turnout.Final}} {
+				fmt.Printf("  %s: %d ballots from %d voters", t.name, t.turnout.Ballots, t.turnout.Voters)
+				if t.turnout.RegisteredVoters > 0 {
+					fmt.Printf(", %.1f%% of %d registered", t.turnout.Percent, t.turnout.RegisteredVoters)
+				}
+				fmt.Println()
+			}
+		case "help":
+			fmt.Println(replHelp)
+		case "quit", "exit":
+			return
+		default:
+			fmt.Printf("Unknown command %q, try help\n", fields[0])
+		}
+	}
+}
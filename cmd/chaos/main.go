@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/chaos"
+	"cs.ubc.ca/cpsc416/BlockVote/testkit"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func main() {
+	var cfg chaos.Config
+	var miners int
+	var difficulty uint
+	var asJSON, verbose bool
+	flag.IntVar(&miners, "miners", 5, "number of miners in the cluster")
+	flag.IntVar(&cfg.Clients, "clients", 3, "number of clients casting ballots")
+	flag.DurationVar(&cfg.Duration, "duration", time.Hour, "how long to inject faults for")
+	flag.DurationVar(&cfg.FaultInterval, "fault-interval", 10*time.Second, "time between two faults")
+	flag.DurationVar(&cfg.BallotInterval, "ballot-interval", time.Second, "time between two ballots of a client")
+	flag.DurationVar(&cfg.CheckInterval, "check-interval", 30*time.Second, "time between two checks of accepted ballots")
+	flag.DurationVar(&cfg.MaxBlockDelay, "max-delay", 5*time.Second, "upper bound of block delays (needs -tags faultinject)")
+	flag.DurationVar(&cfg.ConfirmTimeout, "timeout", 5*time.Minute, "how long to wait for the cluster to converge after the faults stop")
+	flag.UintVar(&difficulty, "difficulty", 4, "mining difficulty of the cluster")
+	flag.Int64Var(&cfg.Seed, "seed", time.Now().UnixNano(), "random seed for faults and ballots")
+	flag.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	flag.BoolVar(&verbose, "v", false, "print node logs")
+	flag.Parse()
+
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	fmt.Fprintln(os.Stderr, "seed:", cfg.Seed)
+
+	cluster, err := testkit.Start(testkit.Options{
+		Miners:     miners,
+		Difficulty: uint8(difficulty),
+		Seed:       cfg.Seed,
+	})
+	util.CheckErr(err, "Unable to start the cluster: %v\n", err)
+	report, err := chaos.Run(cluster, cfg)
+	cluster.Stop()
+	if report == nil {
+		util.CheckErr(err, "Chaos run failed: %v\n", err)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Print(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Cluster did not recover:", err)
+		os.Exit(1)
+	}
+	if report.Lost > 0 || report.Reverted > 0 {
+		os.Exit(1)
+	}
+}
@@ -4,16 +4,17 @@ import (
 	"bytes"
 	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
 	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
 	"cs.ubc.ca/cpsc416/BlockVote/evlib"
 	"cs.ubc.ca/cpsc416/BlockVote/util"
 	"flag"
 	"fmt"
+	"github.com/DistributedClocks/tracing"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
 	"time"
 )
@@ -25,28 +26,29 @@ type Record struct {
 }
 
 func main() {
-	var config blockvote.ClientConfig
-	err := util.ReadJSONConfig("config/client_config.json", &config)
-	util.CheckErr(err, "Error reading client config: %v\n", err)
+	var cfg blockvote.ClientConfig
+	config.MustLoad(config.Path("config/client_config.json"), &cfg)
 
 	// parse args
 	var thetis bool
 	var anvil bool
 	var remote bool
-	flag.UintVar(&config.ClientID, "id", config.ClientID, "client ID")
+	var trace bool
+	flag.UintVar(&cfg.ClientID, "id", cfg.ClientID, "client ID")
 	flag.BoolVar(&thetis, "thetis", false, "run client on thetis server")
 	flag.BoolVar(&anvil, "anvil", false, "run client on anvil server")
 	flag.BoolVar(&remote, "remote", false, "run client on remote server")
+	flag.BoolVar(&trace, "trace", false, "send traces to the tracing server")
 	flag.Parse()
-	config.TracingIdentity = "client" + strconv.Itoa(int(config.ClientID))
+	cfg.TracingIdentity = "client" + strconv.Itoa(int(cfg.ClientID))
 
 	if thetis || anvil || remote {
-		config.CoordIPPort = "thetis.students.cs.ubc.ca" + config.CoordIPPort[strings.Index(config.CoordIPPort, ":"):]
+		cfg.CoordIPPort = util.WithHost(cfg.CoordIPPort, "thetis.students.cs.ubc.ca")
 	}
 
 	// redirect output to file
 	if len(os.Args) > 1 {
-		f, err := os.Create("./logs/" + config.TracingIdentity + ".txt")
+		f, err := os.Create("./logs/" + cfg.TracingIdentity + ".txt")
 		if err != nil {
 			log.Fatalf("error opening file: %v", err)
 		}
@@ -54,14 +56,17 @@ func main() {
 		log.SetOutput(f)
 	}
 
-	//tracer := tracing.NewTracer(tracing.TracerConfig{
-	//	ServerAddress:  config.TracingServerAddr,
-	//	TracerIdentity: config.TracingIdentity,
-	//	Secret:         config.Secret,
-	//})
+	var tracer *tracing.Tracer
+	if trace {
+		tracer = tracing.NewTracer(tracing.TracerConfig{
+			ServerAddress:  cfg.TracingServerAddr,
+			TracerIdentity: cfg.TracingIdentity,
+			Secret:         cfg.Secret,
+		})
+	}
 
 	client := evlib.NewEV()
-	err = client.Start(nil, config.ClientID, config.CoordIPPort)
+	err := client.StartWithConfig(tracer, &cfg)
 	util.CheckErr(err, "Error reading client config: %v\n", err)
 
 	// Add client operations here
@@ -71,7 +76,7 @@ func main() {
 	voterRecords := make(map[string][]Record)
 	nVoters := 90
 	for i := 0; i < 100; i++ {
-		voterID := strconv.Itoa(nVoters*int(config.ClientID-1) + rand.New(rand.NewSource(time.Now().UnixNano())).Intn(nVoters))
+		voterID := strconv.Itoa(nVoters*int(cfg.ClientID-1) + rand.New(rand.NewSource(time.Now().UnixNano())).Intn(nVoters))
 		voterName := "voter" + voterID
 		var candidate string
 		valid := true
@@ -88,7 +93,8 @@ func main() {
 			VoterCandidate: candidate,
 		}
 		blockChain.PrintBallot(&ballot)
-		txid := client.Vote(ballot)
+		txid, err := client.Vote(ballot)
+		util.CheckErr(err, "Unable to vote: %v\n", err)
 		if valid {
 			dup := false
 			for _, r := range voterRecords[voterName] {
@@ -117,7 +123,8 @@ func main() {
 			candidate = client.CandidateList[rand.New(rand.NewSource(time.Now().UnixNano())).Intn(len(client.CandidateList))]
 			ballot.VoterCandidate = candidate
 			blockChain.PrintBallot(&ballot)
-			txid = client.Vote(ballot)
+			txid, err = client.Vote(ballot)
+			util.CheckErr(err, "Unable to vote: %v\n", err)
 			dup := false
 			for _, r := range voterRecords[voterName] {
 				if bytes.Compare(r.TxID, txid) == 0 {
@@ -146,21 +153,21 @@ func main() {
 			}
 		}
 	}
-	fv, err := os.Create("./client" + strconv.Itoa(int(config.ClientID)) + "valid.txt")
+	fv, err := os.Create("./client" + strconv.Itoa(int(cfg.ClientID)) + "valid.txt")
 	util.CheckErr(err, "Unable to create valid.txt")
 	defer fv.Close()
 	for _, record := range validRecords {
 		fv.WriteString(fmt.Sprintf("%x,%s,%s\n", record.TxID, record.Name, record.Candidate))
 	}
 	fv.Sync()
-	fi, err := os.Create("./client" + strconv.Itoa(int(config.ClientID)) + "invalid.txt")
+	fi, err := os.Create("./client" + strconv.Itoa(int(cfg.ClientID)) + "invalid.txt")
 	util.CheckErr(err, "Unable to create invalid.txt")
 	defer fi.Close()
 	for _, record := range invalidRecords {
 		fi.WriteString(fmt.Sprintf("%x,%s,%s\n", record.TxID, record.Name, record.Candidate))
 	}
 	fi.Sync()
-	fc, err := os.Create("./client" + strconv.Itoa(int(config.ClientID)) + "conflict.txt")
+	fc, err := os.Create("./client" + strconv.Itoa(int(cfg.ClientID)) + "conflict.txt")
 	util.CheckErr(err, "Unable to create invalid.txt")
 	defer fc.Close()
 	for _, record := range conflictRecords {
@@ -206,6 +213,9 @@ func main() {
 		log.Println("checking ", client.CandidateList[i], " : ", voters)
 	}
 
+	if stats := client.Metrics(); stats != nil {
+		stats.WriteText(log.Writer())
+	}
 	log.Println("All operations are completed. Sleeping...")
 
 	// Wait for interrupt signal to exit
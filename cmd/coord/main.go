@@ -2,47 +2,57 @@ package main
 
 import (
 	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
 	"cs.ubc.ca/cpsc416/BlockVote/util"
 	"flag"
-	"os"
-	"os/signal"
+	"github.com/DistributedClocks/tracing"
+	"log"
 	"strings"
-	"syscall"
 )
 
 func main() {
-	var config blockvote.CoordConfig
-	util.ReadJSONConfig("config/coord_config.json", &config)
-	//ctracer := tracing.NewTracer(tracing.TracerConfig{
-	//	ServerAddress:  config.TracingServerAddr,
-	//	TracerIdentity: config.TracingIdentity,
-	//	Secret:         config.Secret,
-	//})
+	var cfg blockvote.CoordConfig
+	config.MustDecode(config.Path("config/coord_config.json"), &cfg) // validated by the preflight check of LaunchCoord
 	var restart bool
 	var thetis bool
+	var restore string
+	var trace bool
+	var elections string
+	var recoverFrom string
+	var dev bool
 	flag.BoolVar(&restart, "r", false, "whether to restart coord")
 	flag.BoolVar(&thetis, "thetis", false, "run coord on thetis server")
+	flag.StringVar(&restore, "restore", "", "backup file to restore the database from")
+	flag.BoolVar(&trace, "trace", false, "send traces to the tracing server")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", cfg.BackupDir, "directory for scheduled backups")
+	flag.UintVar(&cfg.BackupInterval, "backup-interval", cfg.BackupInterval, "seconds between scheduled backups")
+	flag.StringVar(&cfg.ReplicaOf, "replica-of", cfg.ReplicaOf, "miner API address of a primary coord to run as a read replica of")
+	flag.StringVar(&cfg.ClientAPIListenAddr, "client-addr", cfg.ClientAPIListenAddr, "address to serve clients' API requests at")
+	flag.StringVar(&elections, "elections", "", "comma-separated config files of more elections to host at the same addresses")
+	flag.StringVar(&recoverFrom, "recover-from", "", "comma-separated admin API addresses of miners to rebuild a lost database from")
+	flag.BoolVar(&dev, "dev", false, "dev mode: accept DevVoters in the config. never on a production chain")
 	flag.Parse()
-	if !restart {
-		if _, err := os.Stat("./storage/coord"); err == nil {
-			os.RemoveAll("./storage/coord")
-		}
+	if recoverFrom != "" {
+		cfg.RecoverFrom = strings.Split(recoverFrom, ",")
 	}
 	if thetis {
-		config.MinerAPIListenAddr = "thetis.students.cs.ubc.ca" +
-			config.MinerAPIListenAddr[strings.Index(config.MinerAPIListenAddr, ":"):]
-		config.ClientAPIListenAddr = "thetis.students.cs.ubc.ca" +
-			config.ClientAPIListenAddr[strings.Index(config.ClientAPIListenAddr, ":"):]
+		cfg.MinerAPIListenAddr = util.WithHost(cfg.MinerAPIListenAddr, "thetis.students.cs.ubc.ca")
+		cfg.ClientAPIListenAddr = util.WithHost(cfg.ClientAPIListenAddr, "thetis.students.cs.ubc.ca")
 	}
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-
-	coord := blockvote.NewCoord()
-	go func() {
-		<-sigs
-		coord.PrintChain()
-		os.Exit(0)
-	}()
-	coord.Start(config.ClientAPIListenAddr, config.MinerAPIListenAddr, config.NCandidates, nil)
+	var ctracer *tracing.Tracer
+	if trace {
+		ctracer = tracing.NewTracer(tracing.TracerConfig{
+			ServerAddress:  cfg.TracingServerAddr,
+			TracerIdentity: cfg.TracingIdentity,
+			Secret:         cfg.Secret,
+		})
+	}
+	launch := blockvote.CoordLaunch{StorageDir: "./storage/coord", Restart: restart, RestoreFrom: restore, Tracer: ctracer, Dev: dev}
+	if elections != "" {
+		launch.Elections = strings.Split(elections, ",")
+	}
+	if err := blockvote.LaunchCoord(&cfg, launch); err != nil {
+		log.Fatalln("[ERROR] Coord stopped:", err)
+	}
 }
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+type TxnView struct {
+	ID             string
+	VoterName      string
+	VoterStudentID string
+	Candidate      string
+	Race           string   `json:",omitempty"`
+	Type           string   `json:",omitempty"`
+	Ranking        []string `json:",omitempty"`
+	PublicKey      string
+}
+
+type BlockView struct {
+	Hash      string
+	PrevHash  string
+	BlockNum  uint32
+	Nonce     uint32
+	Timestamp time.Time
+	MinerID   string
+	Txns      []TxnView
+}
+
+type TxnLookupView struct {
+	Txn          *TxnView
+	BlockHash    string
+	BlockNum     uint32
+	NumConfirmed int
+}
+
+type TallyView struct {
+	Candidate string
+	Race      string `json:",omitempty"`
+	Type      string `json:",omitempty"` // abstain or write-in for votes not for a listed candidate
+	Votes     uint
+}
+
+const usage = `Usage: explorer [flags] <command> [args]
+
+Commands:
+  tip                   show the last block of the longest chain
+  block <hash|height>   show a block by hash (hex) or by height on the longest chain
+  txn <id>              show a transaction (hex id) and its confirmations
+  list                  list all blocks on the longest chain from tip to genesis
+  tally                 show confirmed votes per candidate
+  stats                 show block, txn, block interval, fork and per-miner statistics of the chain
+  graph [dot|mermaid]   print all stored blocks, forks included, as a Graphviz (default) or Mermaid graph.
+                        coord only sends the longest chain: use -db or -snapshot to see forks
+  verify-chain          verify links, proof of work and transactions of the longest chain
+  verify-cert <file>    verify a result certificate against the chain
+
+Flags:
+`
+
+func main() {
+	var config blockvote.CoordConfig
+	util.ReadJSONConfig("config/coord_config.json", &config)
+
+	var coordAddr, electionID, dbPath, snapshot, keyFile, authority string
+	var asJSON bool
+	flag.StringVar(&coordAddr, "coord", config.MinerAPIListenAddr, "coord's miner API address to download the chain from")
+	flag.StringVar(&electionID, "election", config.ElectionID, "election of coord to download the chain of")
+	flag.StringVar(&dbPath, "db", "", "read a database directory directly (e.g. ./storage/coord) instead of contacting coord")
+	flag.StringVar(&snapshot, "snapshot", "", "read a database backup file instead of contacting coord")
+	flag.StringVar(&keyFile, "key", "", "storage key file if the database is encrypted")
+	flag.StringVar(&authority, "authority", "", "authority public key (hex) the certificate must be signed by, required for verify-cert")
+	flag.BoolVar(&asJSON, "json", false, "print JSON instead of human-readable output")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	chain, err := blockvote.OpenChain(coordAddr, electionID, dbPath, snapshot, keyFile)
+	util.CheckErr(err, "Unable to open the blockchain: %v\n", err)
+
+	args := flag.Args()
+	switch args[0] {
+	case "tip":
+		printBlock(blockView(chain.Get(chain.GetLastHash())), asJSON)
+	case "block":
+		requireArgs(args, 2)
+		block, err := findBlock(chain, args[1])
+		util.CheckErr(err, "Unable to find block: %v\n", err)
+		printBlock(blockView(block), asJSON)
+	case "txn":
+		requireArgs(args, 2)
+		txid, err := hex.DecodeString(args[1])
+		util.CheckErr(err, "Invalid txn id: %v\n", err)
+		printTxn(chain, txid, asJSON)
+	case "list":
+		printList(chain, asJSON)
+	case "tally":
+		printTally(chain, asJSON)
+	case "stats":
+		printStats(chain, asJSON)
+	case "graph":
+		format := blockchain.GraphDOT
+		if len(args) > 1 {
+			format = args[1]
+		}
+		err = chain.WriteGraph(os.Stdout, format)
+		util.CheckErr(err, "Unable to draw the chain: %v\n", err)
+	case "verify-chain":
+		err = chain.VerifyStored()
+		if err == nil {
+			err = chain.VerifyChain()
+		}
+		if asJSON {
+			printJSON(map[string]interface{}{"Valid": err == nil, "Error": errString(err)})
+		} else if err == nil {
+			fmt.Println("Chain is valid")
+		} else {
+			fmt.Println("Chain is INVALID:", err)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	case "verify-cert":
+		requireArgs(args, 2)
+		cert, err := blockvote.ReadResultCertificate(args[1])
+		util.CheckErr(err, "Unable to read certificate: %v\n", err)
+		err = cert.Verify(chain, authority)
+		if asJSON {
+			printJSON(map[string]interface{}{"Valid": err == nil, "Error": errString(err)})
+		} else if err == nil {
+			fmt.Printf("Certificate is valid (block #%d, authority %s)\n", cert.Height, cert.AuthorityKey)
+		} else {
+			fmt.Println("Certificate is INVALID:", err)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func findBlock(chain *blockchain.BlockChain, ref string) (*blockchain.Block, error) {
+	if height, err := strconv.ParseUint(ref, 10, 8); err == nil && len(ref) < 4 {
+		block := chain.GetByHeight(uint32(height))
+		if block == nil {
+			return nil, errors.New("no block at height " + ref)
+		}
+		return block, nil
+	}
+	hash, err := hex.DecodeString(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !chain.Exist(hash) {
+		return nil, errors.New("no block with hash " + ref)
+	}
+	return chain.Get(hash), nil
+}
+
+func printBlock(view BlockView, asJSON bool) {
+	if asJSON {
+		printJSON(view)
+		return
+	}
+	fmt.Printf("Block #%d (%s)\n", view.BlockNum, view.Hash)
+	fmt.Printf("\tPrevHash:\t %s\n", view.PrevHash)
+	fmt.Printf("\tNonce:\t\t %d\n", view.Nonce)
+	fmt.Printf("\tTime:\t\t %s\n", view.Timestamp.Format(time.RFC3339))
+	fmt.Printf("\tMinerID:\t %s\n", view.MinerID)
+	fmt.Printf("\tTxns:\t\t %d\n", len(view.Txns))
+	for _, txn := range view.Txns {
+		fmt.Printf("\t    %s  %s\t -> %s\n", txn.ID, txn.VoterName, txn.Candidate)
+	}
+}
+
+func printTxn(chain *blockchain.BlockChain, txid []byte, asJSON bool) {
+	txn, block, numConfirmed := chain.FindTxn(txid)
+	view := TxnLookupView{NumConfirmed: numConfirmed}
+	if txn != nil {
+		txnView := txnView(txn)
+		view.Txn = &txnView
+		view.BlockHash = hex.EncodeToString(block.Hash)
+		view.BlockNum = block.BlockNum
+	}
+	if asJSON {
+		printJSON(view)
+		return
+	}
+	if txn == nil {
+		fmt.Println("Txn not found on the longest chain")
+		return
+	}
+	fmt.Printf("Txn %s\n", view.Txn.ID)
+	fmt.Printf("\tVoter:\t\t %s (%s)\n", view.Txn.VoterName, view.Txn.VoterStudentID)
+	fmt.Printf("\tCandidate:\t %s\n", view.Txn.Candidate)
+	fmt.Printf("\tBlock:\t\t #%d (%s)\n", view.BlockNum, view.BlockHash)
+	fmt.Printf("\tConfirmations:\t %d\n", view.NumConfirmed)
+}
+
+func printList(chain *blockchain.BlockChain, asJSON bool) {
+	var views []BlockView
+	iter := chain.NewIterator(chain.GetLastHash())
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		views = append(views, blockView(block))
+		if end {
+			break
+		}
+	}
+	if asJSON {
+		printJSON(views)
+		return
+	}
+	for _, view := range views {
+		fmt.Printf("#%-4d %s  miner: %-10s txns: %d\n", view.BlockNum, view.Hash, view.MinerID, len(view.Txns))
+	}
+}
+
+func printTally(chain *blockchain.BlockChain, asJSON bool) {
+	votes, _ := chain.VotingStatus()
+	var views []TallyView
+	for idx, cand := range chain.Candidates {
+		views = append(views, TallyView{
+			Candidate: cand.CandidateData.CandidateName,
+			Race:      cand.CandidateData.Race,
+			Votes:     votes[idx],
+		})
+	}
+	extras := chain.ExtraVotesAt(chain.GetLastHash(), chain.RequiredConfirmations())
+	var races []string
+	for race := range extras {
+		races = append(races, race)
+	}
+	sort.Strings(races)
+	for _, race := range races {
+		if extras[race].Abstain > 0 {
+			views = append(views, TallyView{Race: race, Type: blockchain.BallotAbstain, Votes: extras[race].Abstain})
+		}
+		var names []string
+		for name := range extras[race].WriteIns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			views = append(views, TallyView{Candidate: name, Race: race, Type: blockchain.BallotWriteIn, Votes: extras[race].WriteIns[name]})
+		}
+	}
+	if asJSON {
+		printJSON(views)
+		return
+	}
+	for _, view := range views {
+		name := strings.TrimSpace(view.Candidate)
+		if view.Type != blockchain.BallotCandidate {
+			name = strings.TrimSpace("(" + view.Type + ") " + name)
+		}
+		if view.Race != "" {
+			name = view.Race + "/" + name
+		}
+		fmt.Printf("%-15s %d\n", name, view.Votes)
+	}
+	runoffs := chain.RunoffAt(chain.GetLastHash(), chain.RequiredConfirmations())
+	races = races[:0]
+	for race := range runoffs {
+		races = append(races, race)
+	}
+	sort.Strings(races)
+	for _, race := range races {
+		fmt.Printf("\nInstant-runoff %s: winner %s\n", race, runoffs[race].Winner)
+		for round, result := range runoffs[race].Rounds {
+			var counts []string
+			for name, count := range result.Counts {
+				counts = append(counts, fmt.Sprintf("%s=%d", name, count))
+			}
+			sort.Strings(counts)
+			fmt.Printf("  round %d: %s exhausted=%d", round+1, strings.Join(counts, " "), result.Exhausted)
+			if result.Eliminated != "" {
+				fmt.Printf(" eliminated=%s", result.Eliminated)
+			}
+			fmt.Println()
+		}
+	}
+}
+
+func printStats(chain *blockchain.BlockChain, asJSON bool) {
+	stats := chain.Stats()
+	if asJSON {
+		printJSON(stats)
+		return
+	}
+	fmt.Printf("Height:\t\t\t #%d\n", stats.Height)
+	fmt.Printf("Blocks:\t\t\t %d\n", stats.Blocks)
+	fmt.Printf("Txns:\t\t\t %d\n", stats.Txns)
+	fmt.Printf("Txns per block:\t\t %.2f\n", stats.AvgTxnsPerBlock)
+	fmt.Printf("Block interval:\t\t %v\n", stats.AvgBlockInterval)
+	fmt.Printf("Forks:\t\t\t %d (%d stale blocks)\n", stats.Forks, stats.StaleBlocks)
+	var miners []string
+	for miner := range stats.MinerBlocks {
+		miners = append(miners, miner)
+	}
+	sort.Strings(miners)
+	for _, miner := range miners {
+		fmt.Printf("  %-15s %d\n", miner, stats.MinerBlocks[miner])
+	}
+}
+
+// ----- utility functions -----
+
+func blockView(block *blockchain.Block) BlockView {
+	view := BlockView{
+		Hash:      hex.EncodeToString(block.Hash),
+		PrevHash:  hex.EncodeToString(block.PrevHash),
+		BlockNum:  block.BlockNum,
+		Nonce:     block.Nonce,
+		Timestamp: time.Unix(block.Timestamp, 0).UTC(),
+		MinerID:   block.MinerID,
+		Txns:      []TxnView{},
+	}
+	for _, txn := range block.Txns {
+		view.Txns = append(view.Txns, txnView(txn))
+	}
+	return view
+}
+
+func txnView(txn *blockchain.Transaction) TxnView {
+	return TxnView{
+		ID:             hex.EncodeToString(txn.ID),
+		VoterName:      txn.Data.VoterName,
+		VoterStudentID: txn.Data.VoterStudentID,
+		Candidate:      txn.Data.VoterCandidate,
+		Race:           txn.Data.Race,
+		Type:           txn.Data.Type,
+		Ranking:        txn.Data.Ranking,
+		PublicKey:      hex.EncodeToString(txn.PublicKey),
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	util.CheckErr(err, "Unable to encode JSON: %v\n", err)
+}
+
+func requireArgs(args []string, n int) {
+	if len(args) < n {
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
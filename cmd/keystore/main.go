@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cs.ubc.ca/cpsc416/BlockVote/keystore"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+const usage = `Usage: keystore [flags]
+
+Runs a keystore agent holding the voter keys of this machine. Clients with KeystoreSocket set in their config
+sign ballots through it instead of writing a wallet file per voter.
+
+Flags:
+`
+
+func main() {
+	var socket, file string
+	flag.StringVar(&socket, "socket", "./tmp/keystore.sock", "unix socket to serve at")
+	flag.StringVar(&file, "file", "", "file keeping the keys across restarts. keys are lost on exit if empty")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	agent, err := keystore.NewAgent(file)
+	util.CheckErr(err, "Unable to load keys: %v\n", err)
+	listener, err := keystore.Serve(agent, socket)
+	util.CheckErr(err, "Unable to serve at %s: %v\n", socket, err)
+	log.Println("[INFO] Keystore agent serving at", socket)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	<-sigs
+	listener.Close()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/loadgen"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func main() {
+	var coordConfig blockvote.CoordConfig
+	util.ReadJSONConfig("config/coord_config.json", &coordConfig)
+
+	var cfg loadgen.Config
+	var timeout uint
+	var asJSON, verbose bool
+	flag.StringVar(&cfg.CoordClientAddr, "coord", coordConfig.ClientAPIListenAddr, "coord's client API address")
+	flag.StringVar(&cfg.CoordMinerAddr, "coord-miner", coordConfig.MinerAPIListenAddr, "coord's miner API address, used to check for duplicated ballots (empty to skip)")
+	flag.StringVar(&cfg.ElectionID, "election", coordConfig.ElectionID, "election of coord to vote in")
+	flag.IntVar(&cfg.Clients, "clients", 10, "number of concurrent clients")
+	flag.IntVar(&cfg.Ballots, "ballots", 100, "total number of ballots to cast")
+	flag.UintVar(&timeout, "timeout", 300, "seconds to wait for ballots to be confirmed after submission")
+	flag.Int64Var(&cfg.Seed, "seed", time.Now().UnixNano(), "random seed for candidate choices")
+	flag.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	flag.BoolVar(&verbose, "v", false, "print evlib logs")
+	flag.Parse()
+	cfg.ConfirmTimeout = time.Duration(timeout) * time.Second
+
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	report, err := loadgen.Run(cfg)
+	if report == nil {
+		util.CheckErr(err, "Load test failed: %v\n", err)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Print(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Duplicate check failed:", err)
+		os.Exit(1)
+	}
+	if report.Lost > 0 || report.Duplicated > 0 {
+		os.Exit(1)
+	}
+}
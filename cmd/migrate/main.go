@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+const usage = `Usage: migrate -db DIR [flags]
+
+Upgrades the stored blocks of a stopped coord or miner database to the block format of this version, so
+that a node of this version reads it without a warning: blocks stored whole are split into headers and
+bodies, and values written before checksums get one. Run it after upgrading the binaries and before
+starting the node again. A database that is up to date is left alone.
+
+Flags:
+`
+
+func main() {
+	var dbPath, keyFile, backup string
+	var check bool
+	flag.StringVar(&dbPath, "db", "", "database directory of the node, e.g. ./storage/coord")
+	flag.StringVar(&keyFile, "key", "", "storage key file if the database is encrypted")
+	flag.StringVar(&backup, "backup", "", "back the database up to this file before migrating it. no backup if empty")
+	flag.BoolVar(&check, "check", false, "only print the format version of the database")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	storage := &util.Database{}
+	if keyFile != "" {
+		key, err := util.LoadKeyFile(keyFile)
+		util.CheckErr(err, "Unable to read the storage key: %v\n", err)
+		err = storage.EnableEncryption(key)
+		util.CheckErr(err, "Unable to use the storage key: %v\n", err)
+	}
+	err := storage.Load(dbPath)
+	util.CheckErr(err, "Unable to open the database: %v\n", err)
+	defer storage.Close()
+	chain := blockchain.NewBlockChain(storage, nil)
+
+	version, err := chain.StorageFormat()
+	util.CheckErr(err, "Unable to read the format version: %v\n", err)
+	fmt.Printf("database format: %d, current format: %d, value checksums: %v\n", version, blockchain.BlockFormatVersion, !storage.Legacy())
+	if check || (version == blockchain.BlockFormatVersion && !storage.Legacy()) {
+		return
+	}
+
+	if backup != "" {
+		err = storage.BackupToFile(backup)
+		util.CheckErr(err, "Unable to back the database up: %v\n", err)
+		fmt.Println("backed up to", backup)
+	}
+	from, migrated, err := chain.MigrateStorage()
+	util.CheckErr(err, "Migration failed after %d blocks: %v\n", migrated, err)
+	fmt.Printf("migrated %d blocks from format %d to %d\n", migrated, from, blockchain.BlockFormatVersion)
+
+	err = chain.VerifyStored()
+	util.CheckErr(err, "The migrated blocks do not verify: %v\n", err)
+}
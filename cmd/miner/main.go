@@ -2,27 +2,50 @@ package main
 
 import (
 	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
 	"cs.ubc.ca/cpsc416/BlockVote/util"
 	"flag"
+	"github.com/DistributedClocks/tracing"
 	"log"
 	"os"
 	"strings"
 )
 
 func main() {
-	var config blockvote.MinerConfig
-	util.ReadJSONConfig("config/miner_config.json", &config)
+	var cfg blockvote.MinerConfig
+	config.MustDecode(config.Path("config/miner_config.json"), &cfg) // validated by the preflight check below
 
 	// parse args
 	var thetis bool
 	var anvil bool
 	var remote bool
-	flag.StringVar(&config.MinerId, "id", config.MinerId, "miner[num]")
-	flag.StringVar(&config.MinerAddr, "addr", config.MinerAddr, "miner[num]")
+	var trace bool
+	var elections string
+	var restore string
+	var dev bool
+	flag.StringVar(&cfg.MinerId, "id", cfg.MinerId, "miner[num]")
+	flag.StringVar(&cfg.MinerAddr, "addr", cfg.MinerAddr, "miner[num]")
 	flag.BoolVar(&thetis, "thetis", false, "run miner on thetis server")
 	flag.BoolVar(&anvil, "anvil", false, "run miner on anvil server")
 	flag.BoolVar(&remote, "remote", false, "run miner on remote server")
+	flag.BoolVar(&trace, "trace", false, "send traces to the tracing server")
+	flag.StringVar(&restore, "restore", "", "backup file to restore the database from")
+	flag.StringVar(&cfg.BackupDir, "backup-dir", cfg.BackupDir, "directory for scheduled backups")
+	flag.UintVar(&cfg.BackupInterval, "backup-interval", cfg.BackupInterval, "seconds between scheduled backups")
+	flag.StringVar(&elections, "elections", "", "comma-separated config files of more elections to mine for in this process")
+	flag.BoolVar(&dev, "dev", false, "dev mode: mine on chains with dev voters. never on a production chain")
 	flag.Parse()
+	if err := cfg.Preflight(); err != nil {
+		log.Fatalf("[ERROR] Miner cannot start with this config:\n%v\n", err)
+	}
+	var electionCfgs []*blockvote.MinerConfig
+	for _, path := range strings.Split(elections, ",") {
+		if path != "" {
+			electionCfg := new(blockvote.MinerConfig)
+			config.MustLoad(path, electionCfg)
+			electionCfgs = append(electionCfgs, electionCfg)
+		}
+	}
 
 	var ip string
 	if thetis {
@@ -33,13 +56,13 @@ func main() {
 		ip = "remote.students.cs.ubc.ca"
 	}
 	if thetis || anvil || remote {
-		config.CoordAddr = "thetis.students.cs.ubc.ca" + config.CoordAddr[strings.Index(config.CoordAddr, ":"):]
-		config.MinerAddr = ip + config.MinerAddr[strings.Index(config.MinerAddr, ":"):]
+		cfg.CoordAddr = util.WithHost(cfg.CoordAddr, "thetis.students.cs.ubc.ca")
+		cfg.MinerAddr = util.WithHost(cfg.MinerAddr, ip)
 	}
 
 	// redirect output to file
 	if len(os.Args) > 1 {
-		f, err := os.Create("./logs/" + config.MinerId + ".txt")
+		f, err := os.Create("./logs/" + cfg.MinerId + ".txt")
 		if err != nil {
 			log.Fatalf("error opening file: %v", err)
 		}
@@ -47,11 +70,26 @@ func main() {
 		log.SetOutput(f)
 	}
 
-	//mtracer := tracing.NewTracer(tracing.TracerConfig{
-	//	ServerAddress:  config.TracingServerAddr,
-	//	TracerIdentity: config.TracingIdentity,
-	//	Secret:         config.Secret,
-	//})
+	var mtracer *tracing.Tracer
+	if trace {
+		mtracer = tracing.NewTracer(tracing.TracerConfig{
+			ServerAddress:  cfg.TracingServerAddr,
+			TracerIdentity: cfg.MinerId,
+			Secret:         cfg.Secret,
+		})
+	}
+	// every election is mined by a miner of its own, with its own MinerAddr
+	for _, electionCfg := range electionCfgs {
+		go func(electionCfg *blockvote.MinerConfig) {
+			electionMiner := blockvote.NewMiner()
+			electionMiner.Dev = dev
+			if err := electionMiner.StartWithConfig(electionCfg, mtracer); err != nil {
+				log.Printf("[ERROR] Miner for election %s stopped: %v\n", electionCfg.ElectionID, err)
+			}
+		}(electionCfg)
+	}
 	server := blockvote.NewMiner()
-	server.Start(config.MinerId, config.CoordAddr, config.MinerAddr, config.Difficulty, config.MaxTxn, nil)
+	server.Dev = dev
+	server.RestoreFrom = restore
+	server.StartWithConfig(&cfg, mtracer)
 }
@@ -2,18 +2,18 @@ package main
 
 import (
 	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
-	"cs.ubc.ca/cpsc416/BlockVote/util"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
 	"github.com/DistributedClocks/tracing"
 )
 
 func main() {
-	var config blockvote.MinerConfig
-	util.ReadJSONConfig("config/miner2_config.json", &config)
+	var cfg blockvote.MinerConfig
+	config.MustLoad(config.Path("config/miner2_config.json"), &cfg)
 	mtracer := tracing.NewTracer(tracing.TracerConfig{
-		ServerAddress:  config.TracingServerAddr,
-		TracerIdentity: config.TracingIdentity,
-		Secret:         config.Secret,
+		ServerAddress:  cfg.TracingServerAddr,
+		TracerIdentity: cfg.TracingIdentity,
+		Secret:         cfg.Secret,
 	})
 	server := blockvote.NewMiner()
-	server.Start(config.MinerId, config.CoordAddr, config.MinerAddr, config.Difficulty, config.MaxTxn, mtracer)
+	server.StartWithConfig(&cfg, mtracer)
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/minerbench"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func main() {
+	var minerConfig blockvote.MinerConfig
+	util.ReadJSONConfig("config/miner_config.json", &minerConfig)
+
+	var cfg minerbench.Config
+	var difficulty, txns uint
+	var asJSON, verbose bool
+	flag.UintVar(&difficulty, "difficulty", blockchain.NumZeros, "leading zero bits of a block hash")
+	flag.IntVar(&cfg.Blocks, "blocks", 10, "number of blocks to mine")
+	flag.UintVar(&txns, "txns", uint(minerConfig.MaxTxn), "signed ballots in each block")
+	flag.StringVar(&cfg.HashAlgo, "hash", "", "hash algorithm of the blocks: blake2b, or SHA-256 if empty")
+	flag.BoolVar(&cfg.Delayed, "delayed", false, "pause after every nonce attempt like miners do, to see real block intervals")
+	flag.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	flag.BoolVar(&verbose, "v", false, "print mining logs")
+	flag.Parse()
+	cfg.Difficulty = uint8(difficulty)
+	cfg.TxnsPerBlock = int(txns)
+
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	report, err := minerbench.Run(cfg)
+	util.CheckErr(err, "Benchmark failed: %v\n", err)
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Print(report)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/replay"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+const usage = `Usage: replay [flags] [log]
+
+Replays a recorded election against a fresh in-process cluster: the ballots of a tracing server's output file
+(trace_output.log) or of a coord audit export are cast again in log order, and the cluster mines a block with
+the ballots cast since the previous one at every recorded block. Exits with status 1 if the outcome of any ballot differs from the
+recorded one.
+
+Flags:
+`
+
+func main() {
+	var cfg replay.Config
+	var difficulty uint
+	var coordConfig string
+	var asJSON, verbose bool
+	flag.IntVar(&cfg.Miners, "miners", 0, "number of miners in the cluster. as many as mined a block in the log if 0")
+	flag.StringVar(&coordConfig, "config", "", "coord config to take the races of. those of the log if empty")
+	flag.DurationVar(&cfg.BlockTimeout, "block-timeout", time.Minute, "how long to wait for the cluster to mine a block")
+	flag.DurationVar(&cfg.ConfirmTimeout, "timeout", 5*time.Minute, "how long to wait for the cluster to converge after the last event")
+	flag.UintVar(&difficulty, "difficulty", 4, "mining difficulty of the cluster")
+	flag.Int64Var(&cfg.Seed, "seed", 1, "random seed of the clients")
+	flag.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	flag.BoolVar(&verbose, "v", false, "print node logs")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cfg.Difficulty = uint8(difficulty)
+	if coordConfig != "" {
+		var coord blockvote.CoordConfig
+		err := util.ReadJSONConfig(coordConfig, &coord)
+		util.CheckErr(err, "Unable to read %s: %v\n", coordConfig, err)
+		cfg.Races = coord.Races
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	util.CheckErr(err, "Unable to open the log: %v\n", err)
+	recorded, err := replay.ReadLog(file)
+	file.Close()
+	util.CheckErr(err, "Unable to read the log: %v\n", err)
+	fmt.Fprintf(os.Stderr, "replaying %d ballots and %d blocks\n", recorded.Ballots(), len(recorded.Events)-recorded.Ballots())
+
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	report, err := replay.Run(recorded, cfg)
+	if report == nil {
+		util.CheckErr(err, "Replay failed: %v\n", err)
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		fmt.Print(report)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Replay did not complete:", err)
+		os.Exit(1)
+	}
+	if len(report.Diverged) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+const usage = `Usage: report [flags]
+
+Writes an archival report of a finished election as a single HTML file with no external resources, ready to be
+printed to PDF from a browser: final tallies, turnout, a timeline of the blocks, miner participation, the forks
+seen, the result certificate and the signature check of every ballot on the longest chain.
+
+Flags:
+`
+
+// Report is what the report template renders
+type Report struct {
+	ElectionID     string
+	GeneratedAt    time.Time
+	Tip            string
+	Height         uint32
+	FinalityDepth  int
+	Genesis        time.Time
+	LastBlock      time.Time
+	Races          []RaceReport
+	Ballots        int // ballots on the longest chain, copies included
+	Counted        int // ballots counted in the tallies
+	Voters         int // distinct voter keys with a counted ballot
+	Eligible       int // eligible voters, 0 if unknown
+	Turnout        float64
+	Blocks         []BlockRow
+	Miners         []MinerRow
+	Forks          []ForkRow
+	StaleBlocks    int
+	Certificate    *blockvote.ResultCertificate
+	CertificateErr string
+	ChainErr       string // why the chain does not verify. empty if it does
+	BallotRows     []BallotRow
+	BadSignatures  int
+}
+
+// RaceReport is the final tally of a race
+type RaceReport struct {
+	Race     string
+	Rows     []TallyRow
+	Abstain  uint
+	WriteIns []TallyRow
+	Total    uint
+}
+
+// TallyRow is the votes of a candidate or written-in name
+type TallyRow struct {
+	Name  string
+	Votes uint
+	Share float64 // percent of the race's votes
+}
+
+// BlockRow is a block of the timeline
+type BlockRow struct {
+	Height   uint32
+	Hash     string
+	Time     time.Time
+	Interval time.Duration // since the previous block
+	MinerID  string
+	Txns     int
+}
+
+// MinerRow is the participation of a miner
+type MinerRow struct {
+	MinerID string
+	Blocks  int
+	Txns    int
+	Share   float64 // percent of the blocks on the longest chain
+	First   uint32
+	Last    uint32
+}
+
+// ForkRow is a fork that branched off the longest chain and lost
+type ForkRow struct {
+	BranchedAt uint32 // height of the last block it shares with the longest chain
+	Length     int
+	Tip        string
+	Miners     string
+}
+
+// BallotRow is a ballot on the longest chain and the check of its signature
+type BallotRow struct {
+	TxID    string
+	Height  uint32
+	Race    string
+	Type    string
+	Voter   string // hex public key hash
+	Counted bool
+	Valid   bool
+}
+
+func main() {
+	var config blockvote.CoordConfig
+	util.ReadJSONConfig("config/coord_config.json", &config)
+
+	var electionID, dbPath, snapshot, keyFile, certPath, authority, output string
+	var eligible int
+	flag.StringVar(&electionID, "election", config.ElectionID, "election the snapshot is of")
+	flag.StringVar(&snapshot, "snapshot", "", "database backup file of the finished election")
+	flag.StringVar(&dbPath, "db", "", "read a database directory instead of a backup file")
+	flag.StringVar(&keyFile, "key", "", "storage key file if the database is encrypted")
+	flag.StringVar(&certPath, "cert", "", "result certificate to verify against the chain and include")
+	flag.StringVar(&authority, "authority", "", "authority public key (hex) the certificate must be signed by, required with -cert")
+	flag.IntVar(&eligible, "eligible", 0, "number of eligible voters, to compute the turnout")
+	flag.StringVar(&output, "o", "report.html", "file to write the report to")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if snapshot == "" && dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	chain, err := blockvote.OpenChain("", electionID, dbPath, snapshot, keyFile)
+	util.CheckErr(err, "Unable to open the blockchain: %v\n", err)
+	report := buildReport(chain)
+	report.ElectionID = electionID
+	report.Eligible = eligible
+	if eligible > 0 {
+		report.Turnout = 100 * float64(report.Voters) / float64(eligible)
+	}
+	if certPath != "" {
+		report.Certificate, err = blockvote.ReadResultCertificate(certPath)
+		if err == nil {
+			err = report.Certificate.Verify(chain, authority)
+		}
+		if err != nil {
+			report.CertificateErr = err.Error()
+		}
+	}
+
+	out, err := os.Create(output)
+	util.CheckErr(err, "Unable to create %s: %v\n", output, err)
+	w := bufio.NewWriter(out)
+	err = reportTemplate.Execute(w, report)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	util.CheckErr(err, "Unable to write the report: %v\n", err)
+	fmt.Printf("Report of %d blocks and %d ballots written to %s\n", len(report.Blocks), report.Ballots, output)
+	if report.ChainErr != "" || report.CertificateErr != "" || report.BadSignatures > 0 {
+		fmt.Println("The report lists verification failures")
+		os.Exit(1)
+	}
+}
+
+// buildReport collects everything but the certificate from the longest chain
+func buildReport(chain *blockchain.BlockChain) *Report {
+	tip := chain.GetLastHash()
+	report := &Report{
+		GeneratedAt:   time.Now().UTC(),
+		Tip:           hex.EncodeToString(tip),
+		FinalityDepth: chain.RequiredConfirmations(),
+	}
+	if err := chain.VerifyChain(); err != nil {
+		report.ChainErr = err.Error()
+	}
+
+	// walk the longest chain from the tip, then put it in block order
+	var blocks []*blockchain.Block
+	canonical := make(map[string]bool)
+	iter := chain.NewIterator(tip)
+	for block, end := iter.Next(); ; block, end = iter.Next() {
+		canonical[string(block.Hash)] = true
+		blocks = append([]*blockchain.Block{block}, blocks...)
+		if end {
+			break
+		}
+	}
+	report.Height = blocks[len(blocks)-1].BlockNum
+	report.Genesis = time.Unix(blocks[0].Timestamp, 0).UTC()
+	report.LastBlock = time.Unix(blocks[len(blocks)-1].Timestamp, 0).UTC()
+
+	counted := make(map[string]bool)
+	voters := make(map[string]bool)
+	for _, txn := range chain.CountedTxnsAt(tip, report.FinalityDepth) {
+		// the release of the sealing key is not a ballot, as in QueryTurnout
+		if txn.Unseals() {
+			continue
+		}
+		counted[string(txn.ID)] = true
+		voters[string(txn.PublicKey)] = true
+	}
+	report.Counted, report.Voters = len(counted), len(voters)
+
+	miners := make(map[string]*MinerRow)
+	for i, block := range blocks[1:] {
+		row := BlockRow{
+			Height:   block.BlockNum,
+			Hash:     hex.EncodeToString(block.Hash),
+			Time:     time.Unix(block.Timestamp, 0).UTC(),
+			Interval: time.Duration(block.Timestamp-blocks[i].Timestamp) * time.Second,
+			MinerID:  block.MinerID,
+			Txns:     len(block.Txns),
+		}
+		report.Blocks = append(report.Blocks, row)
+		miner := miners[block.MinerID]
+		if miner == nil {
+			miner = &MinerRow{MinerID: block.MinerID, First: block.BlockNum}
+			miners[block.MinerID] = miner
+		}
+		miner.Blocks++
+		miner.Last = block.BlockNum
+
+		for _, txn := range block.Txns {
+			valid := txn.Verify()
+			if !valid {
+				report.BadSignatures++
+			}
+			// the release of the sealing key is not listed with the ballots
+			if txn.Unseals() {
+				continue
+			}
+			miner.Txns++
+			report.BallotRows = append(report.BallotRows, BallotRow{
+				TxID:    hex.EncodeToString(txn.ID),
+				Height:  block.BlockNum,
+				Race:    txn.Data.Race,
+				Type:    txn.Data.Type,
+				Voter:   hex.EncodeToString(Identity.PublicKeyHash(txn.PublicKey)),
+				Counted: counted[string(txn.ID)],
+				Valid:   valid,
+			})
+		}
+	}
+	report.Ballots = len(report.BallotRows)
+	for _, miner := range miners {
+		miner.Share = 100 * float64(miner.Blocks) / float64(len(report.Blocks))
+		report.Miners = append(report.Miners, *miner)
+	}
+	sort.Slice(report.Miners, func(i, j int) bool {
+		if report.Miners[i].Blocks != report.Miners[j].Blocks {
+			return report.Miners[i].Blocks > report.Miners[j].Blocks
+		}
+		return report.Miners[i].MinerID < report.Miners[j].MinerID
+	})
+
+	report.Races = raceReports(chain, tip, report.FinalityDepth)
+	report.Forks, report.StaleBlocks = forkRows(chain, canonical)
+	return report
+}
+
+// raceReports tallies every race like the result certificate, counting final ballots only
+func raceReports(chain *blockchain.BlockChain, tip []byte, depth int) []RaceReport {
+	votes, _ := chain.VotingStatusAt(tip, depth)
+	extras := chain.ExtraVotesAt(tip, depth)
+	var races []RaceReport
+	index := make(map[string]int)
+	for i, cand := range chain.Candidates {
+		race := cand.CandidateData.Race
+		if _, ok := index[race]; !ok {
+			index[race] = len(races)
+			races = append(races, RaceReport{Race: race})
+		}
+		r := &races[index[race]]
+		r.Rows = append(r.Rows, TallyRow{Name: cand.CandidateData.CandidateName, Votes: votes[i]})
+		r.Total += votes[i]
+	}
+	for i := range races {
+		r := &races[i]
+		if extra := extras[r.Race]; extra != nil {
+			r.Abstain = extra.Abstain
+			for name, n := range extra.WriteIns {
+				r.WriteIns = append(r.WriteIns, TallyRow{Name: name, Votes: n})
+				r.Total += n
+			}
+			sort.Slice(r.WriteIns, func(a, b int) bool { return r.WriteIns[a].Votes > r.WriteIns[b].Votes })
+		}
+		for _, rows := range [][]TallyRow{r.Rows, r.WriteIns} {
+			for j := range rows {
+				if r.Total > 0 {
+					rows[j].Share = 100 * float64(rows[j].Votes) / float64(r.Total)
+				}
+			}
+		}
+	}
+	return races
+}
+
+// forkRows describes the forks that lost to the longest chain, from their stored tips
+func forkRows(chain *blockchain.BlockChain, canonical map[string]bool) (forks []ForkRow, staleBlocks int) {
+	tips, err := chain.ForkTips()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read the fork tips:", err)
+		return nil, 0
+	}
+	counted := make(map[string]bool)
+	for _, tip := range tips {
+		if tip.OnLongestChain || canonical[string(tip.Hash)] {
+			continue
+		}
+		fork := ForkRow{Tip: hex.EncodeToString(tip.Hash)}
+		minerSet := make(map[string]bool)
+		var minerIDs []string
+		hash := tip.Hash
+		for chain.Exist(hash) && !canonical[string(hash)] {
+			header := chain.GetHeader(hash)
+			fork.Length++
+			if !counted[string(hash)] {
+				counted[string(hash)] = true
+				staleBlocks++
+			}
+			if !minerSet[header.MinerID] {
+				minerSet[header.MinerID] = true
+				minerIDs = append(minerIDs, header.MinerID)
+			}
+			fork.BranchedAt = header.BlockNum - 1
+			hash = header.PrevHash
+		}
+		sort.Strings(minerIDs)
+		for i, id := range minerIDs {
+			if i > 0 {
+				fork.Miners += ", "
+			}
+			fork.Miners += id
+		}
+		forks = append(forks, fork)
+	}
+	sort.Slice(forks, func(i, j int) bool { return forks[i].BranchedAt < forks[j].BranchedAt })
+	return forks, staleBlocks
+}
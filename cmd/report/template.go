@@ -0,0 +1,107 @@
+package main
+
+import (
+	"html/template"
+	"strconv"
+	"time"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05 MST") },
+	"pct":  func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) + "%" },
+	"short": func(s string) string {
+		if len(s) > 16 {
+			return s[:16]
+		}
+		return s
+	},
+}).Parse(reportHTML))
+
+// the report is a single page with its styles inline, so it can be archived and printed as is
+const reportHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Election report{{if .ElectionID}} {{.ElectionID}}{{end}}</title>
+<style>
+body { font-family: Georgia, serif; margin: 2em; color: #111; }
+h1 { margin-bottom: 0; }
+h2 { border-bottom: 1px solid #999; margin-top: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #bbb; padding: 0.2em 0.6em; text-align: left; }
+td.num { text-align: right; }
+code { font-size: 0.85em; }
+.ok { color: #070; }
+.bad { color: #b00; font-weight: bold; }
+@page { size: A4; margin: 15mm; }
+@media print { h2 { page-break-before: always; } h2.first { page-break-before: avoid; } tr { page-break-inside: avoid; } }
+</style>
+</head>
+<body>
+<h1>Election report{{if .ElectionID}}: {{.ElectionID}}{{end}}</h1>
+<p>Generated {{time .GeneratedAt}} from the longest chain ending at block #{{.Height}} <code>{{.Tip}}</code>.
+Ballots are final after {{.FinalityDepth}} confirmations; only final ballots are counted.</p>
+<p>Chain verification:
+{{if .ChainErr}}<span class="bad">FAILED: {{.ChainErr}}</span>{{else}}<span class="ok">every block and ballot verifies</span>{{end}}</p>
+
+<h2 class="first">Final tallies</h2>
+{{range .Races}}
+<h3>{{if .Race}}{{.Race}}{{else}}Election{{end}}</h3>
+<table>
+<tr><th>Candidate</th><th>Votes</th><th>Share</th></tr>
+{{range .Rows}}<tr><td>{{.Name}}</td><td class="num">{{.Votes}}</td><td class="num">{{pct .Share}}</td></tr>
+{{end}}{{range .WriteIns}}<tr><td>{{.Name}} (write-in)</td><td class="num">{{.Votes}}</td><td class="num">{{pct .Share}}</td></tr>
+{{end}}<tr><td>Abstentions</td><td class="num">{{.Abstain}}</td><td></td></tr>
+</table>
+{{end}}
+
+<h2>Turnout</h2>
+<table>
+<tr><td>Ballots on the chain</td><td class="num">{{.Ballots}}</td></tr>
+<tr><td>Ballots counted</td><td class="num">{{.Counted}}</td></tr>
+<tr><td>Voters with a counted ballot</td><td class="num">{{.Voters}}</td></tr>
+{{if .Eligible}}<tr><td>Eligible voters</td><td class="num">{{.Eligible}}</td></tr>
+<tr><td>Turnout</td><td class="num">{{pct .Turnout}}</td></tr>{{end}}
+<tr><td>Genesis</td><td>{{time .Genesis}}</td></tr>
+<tr><td>Last block</td><td>{{time .LastBlock}}</td></tr>
+</table>
+
+<h2>Result certificate</h2>
+{{with .Certificate}}
+<p>Issued {{time .IssuedAt}} for block #{{.Height}} <code>{{.TipHash}}</code> by authority <code>{{.AuthorityKey}}</code>.</p>
+{{if $.CertificateErr}}<p class="bad">INVALID: {{$.CertificateErr}}</p>{{else}}<p class="ok">The signature is valid and the tally matches the chain.</p>{{end}}
+{{else}}
+{{if .CertificateErr}}<p class="bad">Unable to read the certificate: {{.CertificateErr}}</p>{{else}}<p>No certificate was given.</p>{{end}}
+{{end}}
+
+<h2>Miner participation</h2>
+<table>
+<tr><th>Miner</th><th>Blocks</th><th>Share</th><th>Ballots</th><th>First block</th><th>Last block</th></tr>
+{{range .Miners}}<tr><td>{{.MinerID}}</td><td class="num">{{.Blocks}}</td><td class="num">{{pct .Share}}</td><td class="num">{{.Txns}}</td><td class="num">#{{.First}}</td><td class="num">#{{.Last}}</td></tr>
+{{end}}</table>
+
+<h2>Fork history</h2>
+{{if .Forks}}
+<p>{{len .Forks}} forks lost to the longest chain, {{.StaleBlocks}} blocks in all.</p>
+<table>
+<tr><th>Branched after</th><th>Blocks</th><th>Miners</th><th>Tip</th></tr>
+{{range .Forks}}<tr><td class="num">#{{.BranchedAt}}</td><td class="num">{{.Length}}</td><td>{{.Miners}}</td><td><code>{{short .Tip}}</code></td></tr>
+{{end}}</table>
+{{else}}<p>No fork blocks are stored.</p>{{end}}
+
+<h2>Block timeline</h2>
+<table>
+<tr><th>Block</th><th>Time</th><th>Interval</th><th>Miner</th><th>Ballots</th><th>Hash</th></tr>
+{{range .Blocks}}<tr><td class="num">#{{.Height}}</td><td>{{time .Time}}</td><td class="num">{{.Interval}}</td><td>{{.MinerID}}</td><td class="num">{{.Txns}}</td><td><code>{{short .Hash}}</code></td></tr>
+{{end}}</table>
+
+<h2>Ballot signatures</h2>
+<p>{{if .BadSignatures}}<span class="bad">{{.BadSignatures}} of {{.Ballots}} ballots have an invalid signature.</span>{{else}}<span class="ok">All {{.Ballots}} ballot signatures are valid.</span>{{end}}
+Voters are shown by the hash of their public key only.</p>
+<table>
+<tr><th>Txn</th><th>Block</th><th>Race</th><th>Type</th><th>Voter</th><th>Counted</th><th>Signature</th></tr>
+{{range .BallotRows}}<tr><td><code>{{.TxID}}</code></td><td class="num">#{{.Height}}</td><td>{{.Race}}</td><td>{{.Type}}</td><td><code>{{short .Voter}}</code></td><td>{{if .Counted}}yes{{else}}no{{end}}</td><td>{{if .Valid}}<span class="ok">valid</span>{{else}}<span class="bad">INVALID</span>{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+const usage = `Usage: verify-receipt [flags] <receipt file>...
+
+Checks that the ballot of each receipt (written by clients with ReceiptDir set) is on the longest chain,
+unchanged, and deep enough to be counted. Exits with status 1 if any receipt fails.
+
+Flags:
+`
+
+func main() {
+	var config blockvote.CoordConfig
+	util.ReadJSONConfig("config/coord_config.json", &config)
+
+	var coordAddr, electionID, dbPath, snapshot, keyFile string
+	var asJSON bool
+	flag.StringVar(&coordAddr, "coord", config.MinerAPIListenAddr, "coord's miner API address to download the chain from")
+	flag.StringVar(&electionID, "election", config.ElectionID, "election of coord to download the chain of")
+	flag.StringVar(&dbPath, "db", "", "read a database directory directly instead of contacting coord")
+	flag.StringVar(&snapshot, "snapshot", "", "read a database backup file instead of contacting coord")
+	flag.StringVar(&keyFile, "key", "", "storage key file if the database is encrypted")
+	flag.BoolVar(&asJSON, "json", false, "print JSON instead of human-readable output")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	chain, err := blockvote.OpenChain(coordAddr, electionID, dbPath, snapshot, keyFile)
+	util.CheckErr(err, "Unable to open the blockchain: %v\n", err)
+
+	failed := false
+	for _, path := range flag.Args() {
+		receipt, err := evlib.LoadReceipt(path)
+		if err == nil {
+			var status *evlib.ReceiptStatus
+			status, err = evlib.VerifyReceipt(chain, receipt)
+			if err == nil {
+				failed = failed || !status.Counted
+				printStatus(path, status, asJSON)
+				continue
+			}
+		}
+		failed = true
+		if asJSON {
+			data, _ := json.Marshal(map[string]string{"Receipt": path, "Error": err.Error()})
+			fmt.Println(string(data))
+		} else {
+			fmt.Printf("%s: FAILED: %v\n", path, err)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func printStatus(path string, status *evlib.ReceiptStatus, asJSON bool) {
+	if asJSON {
+		data, _ := json.Marshal(struct {
+			Receipt string
+			*evlib.ReceiptStatus
+		}{path, status})
+		fmt.Println(string(data))
+		return
+	}
+	if status.Counted {
+		fmt.Printf("%s: OK, counted in block #%d (%s) with %d confirmations\n", path, status.BlockNum, status.BlockHash, status.NumConfirmed)
+	} else {
+		fmt.Printf("%s: on the chain in block #%d (%s) but only %d confirmations, not counted yet\n", path, status.BlockNum, status.BlockHash, status.NumConfirmed)
+	}
+}
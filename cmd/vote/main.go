@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/config"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+func main() {
+	var cfg blockvote.ClientConfig
+	config.MustLoad(config.Path("config/client_config.json"), &cfg)
+
+	var name, id, candidate, race, status string
+	var writeIn, rank string
+	var wait, verbose, mine, abstain, list bool
+	flag.StringVar(&cfg.CoordIPPort, "coord", cfg.CoordIPPort, "coord's client API address")
+	flag.StringVar(&cfg.ElectionID, "election", cfg.ElectionID, "election of coord to vote in (the default election if empty)")
+	flag.StringVar(&name, "name", "", "voter name (prompted if not given)")
+	flag.StringVar(&id, "id", "", "voter studentID (prompted if not given)")
+	flag.StringVar(&candidate, "candidate", "", "candidate to vote for (prompted if not given)")
+	flag.StringVar(&race, "race", "", "race to vote in, if the election has several races")
+	flag.BoolVar(&abstain, "abstain", false, "abstain instead of voting for a candidate")
+	flag.StringVar(&writeIn, "write-in", "", "vote for an unlisted candidate, if the election allows write-ins")
+	flag.StringVar(&rank, "rank", "", "comma separated candidates, most preferred first, if the race is ranked-choice")
+	flag.StringVar(&status, "status", "", "check the number of confirmations of a previously submitted txn ID instead of voting")
+	flag.BoolVar(&mine, "mine", false, "list the ballots of the voter given by -name and -id instead of voting")
+	flag.BoolVar(&list, "candidates", false, "list the candidates with their statements instead of voting")
+	flag.BoolVar(&wait, "wait", false, "keep running (and resubmitting) until the ballot is on the longest chain")
+	flag.BoolVar(&verbose, "v", false, "print evlib logs")
+	flag.Parse()
+
+	if !verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	client := evlib.NewEV()
+	err := client.StartWithConfig(nil, &cfg)
+	util.CheckErr(err, "Unable to start evlib: %v\n", err)
+
+	if status != "" {
+		txid, err := hex.DecodeString(status)
+		util.CheckErr(err, "Invalid txn ID: %v\n", err)
+		estimate, err := client.EstimateConfirmationTime(txid)
+		if err != nil && err != evlib.ErrTooFewBlocks {
+			util.CheckErr(err, "Unable to query txn status: %v\n", err)
+		}
+		printStatus(estimate.NumConfirmed, client.FinalityDepth)
+		if !estimate.Done() && estimate.BlockInterval > 0 {
+			fmt.Printf("About %s until it is counted (%.0f%%, one block every %s)\n", estimate.Remaining.Round(time.Second),
+				100*estimate.Progress(), estimate.BlockInterval.Round(time.Second))
+		}
+		return
+	}
+
+	if list {
+		for _, cand := range client.Candidates() {
+			if cand.Race != "" {
+				fmt.Printf("%s #%d: ", cand.Race, cand.Position)
+			} else {
+				fmt.Printf("#%d: ", cand.Position)
+			}
+			fmt.Printf("%s (wallet %s)", cand.Name, cand.Address)
+			if cand.Statement != "" {
+				fmt.Printf(", statement at %s", cand.Statement)
+			}
+			fmt.Println()
+		}
+		return
+	}
+
+	if mine {
+		if name == "" || id == "" {
+			fmt.Fprintln(os.Stderr, "-mine requires -name and -id")
+			os.Exit(2)
+		}
+		ballots, err := client.MyBallots(name, id)
+		util.CheckErr(err, "Unable to look up ballots: %v\n", err)
+		if len(ballots) == 0 {
+			fmt.Println("No ballots on the longest chain")
+		}
+		for _, ballot := range ballots {
+			choice := ballot.Txn.Data.VoterCandidate
+			if ballot.Txn.Data.Type == blockChain.BallotRanked {
+				choice = strings.Join(ballot.Txn.Data.Ranking, " > ")
+			}
+			if ballot.Txn.Data.Type != blockChain.BallotCandidate {
+				choice = strings.TrimSpace(ballot.Txn.Data.Type + " " + choice)
+			}
+			fmt.Printf("TxID %x: %s in block #%d. ", ballot.Txn.ID, choice, ballot.BlockNum)
+			printStatus(ballot.NumConfirmed, client.FinalityDepth)
+		}
+		return
+	}
+
+	var ballot blockChain.Ballot
+	if abstain {
+		ballot.Type = blockChain.BallotAbstain
+	} else if writeIn != "" {
+		ballot.Type, candidate = blockChain.BallotWriteIn, writeIn
+	} else if rank != "" {
+		ballot.Type = blockChain.BallotRanked
+	}
+	if name != "" && id != "" && (candidate != "" || abstain || rank != "") {
+		ballot = blockChain.Ballot{
+			VoterName:      name,
+			VoterStudentID: id,
+			VoterCandidate: candidate,
+			Race:           race,
+			Type:           ballot.Type,
+			Ranking:        evlib.ParseRanking(rank),
+		}
+		if err := client.ValidateBallot(ballot); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid ballot:", err)
+			fmt.Fprintln(os.Stderr, "Candidates:", client.RaceCandidates(race))
+			os.Exit(1)
+		}
+	} else {
+		ballot, err = client.CreateBallot()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to create a ballot:", err)
+			os.Exit(1)
+		}
+	}
+
+	txid, err := client.Vote(ballot)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to vote:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Ballot submitted. TxID: %x\n", txid)
+	if !wait {
+		fmt.Printf("Check its status later with: vote -status %x\n", txid)
+		return
+	}
+
+	// evlib resubmits unconfirmed txns in the background as long as we are running
+	fmt.Println("Waiting for the ballot to be included in a block...")
+	for {
+		time.Sleep(5 * time.Second)
+		numConfirmed, err := client.GetBallotStatus(txid)
+		if err == nil && numConfirmed >= 0 {
+			printStatus(numConfirmed, client.FinalityDepth)
+			return
+		}
+	}
+}
+
+func printStatus(numConfirmed int, required int) {
+	if numConfirmed < 0 {
+		fmt.Println("Txn is not on the longest chain yet")
+	} else if numConfirmed < required {
+		fmt.Printf("Txn is pending, %d/%d blocks confirm it\n", numConfirmed, required)
+	} else {
+		fmt.Printf("Txn is confirmed by %d blocks\n", numConfirmed)
+	}
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// CandidateEntry is a candidate in a candidates file
+type CandidateEntry struct {
+	Name      string
+	ID        string // unique ID, matched against stored candidates on restart. Race/Name when empty
+	Race      string // one of the Races of the coord config. empty in an election with a single race
+	Address   string // wallet address of the candidate. a wallet is generated and saved when empty
+	Statement string // http(s) URL of the candidate's statement. none when empty
+}
+
+// CandidateList is a candidates file. Candidates files can only be JSON.
+type CandidateList struct {
+	Candidates []CandidateEntry
+}
+
+func (l *CandidateList) SetDefaults() {
+	for i := range l.Candidates {
+		if l.Candidates[i].ID == "" {
+			l.Candidates[i].ID = Identity.Candidate{CandidateName: l.Candidates[i].Name, Race: l.Candidates[i].Race}.FullName()
+		}
+	}
+}
+
+func (l *CandidateList) Validate() error {
+	if len(l.Candidates) == 0 {
+		return errors.New("no candidates")
+	}
+	if len(l.Candidates) > 255 {
+		return errors.New("at most 255 candidates are supported")
+	}
+	ids := make(map[string]bool)
+	names := make(map[string]bool)
+	addresses := make(map[string]bool)
+	for _, cand := range l.Candidates {
+		if cand.Name == "" {
+			return errors.New("candidate name cannot be empty")
+		}
+		fullName := Identity.Candidate{CandidateName: cand.Name, Race: cand.Race}.FullName()
+		if names[fullName] {
+			return fmt.Errorf("candidate %s is listed twice", fullName)
+		}
+		names[fullName] = true
+		if ids[cand.ID] {
+			return fmt.Errorf("candidate ID %s is used twice", cand.ID)
+		}
+		ids[cand.ID] = true
+		if cand.Address != "" {
+			if !Identity.ValidateAddress(cand.Address) {
+				return fmt.Errorf("candidate %s has an invalid wallet address %q", cand.ID, cand.Address)
+			}
+			if addresses[cand.Address] {
+				return fmt.Errorf("candidate %s shares its wallet address with another candidate", cand.ID)
+			}
+			addresses[cand.Address] = true
+		}
+		if cand.Statement != "" {
+			u, err := url.Parse(cand.Statement)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("candidate %s has an invalid statement URL %q", cand.ID, cand.Statement)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadCandidates reads CandidatesFile and checks that its candidates run in the configured races
+func (c *Coord) LoadCandidates() (*CandidateList, error) {
+	list := &CandidateList{}
+	if err := Load(c.CandidatesFile, list); err != nil {
+		return nil, err
+	}
+	races := make(map[string]int)
+	for _, race := range c.Races {
+		races[race.Name] = 0
+	}
+	for _, cand := range list.Candidates {
+		if len(c.Races) == 0 && cand.Race != "" {
+			return nil, fmt.Errorf("%s: candidate %s runs in race %s but no races are configured", c.CandidatesFile, cand.ID, cand.Race)
+		}
+		if _, ok := races[cand.Race]; len(c.Races) > 0 && !ok {
+			return nil, fmt.Errorf("%s: candidate %s runs in unknown race %q", c.CandidatesFile, cand.ID, cand.Race)
+		}
+		races[cand.Race]++
+	}
+	// the checks Validate does for races that list their candidates
+	for _, race := range c.Races {
+		if races[race.Name] == 0 {
+			return nil, fmt.Errorf("%s: race %s has no candidates", c.CandidatesFile, race.Name)
+		}
+		if !race.AllowWriteIns && int(race.MaxVotes) > races[race.Name] {
+			return nil, fmt.Errorf("%s: race %s allows more votes than it has candidates", c.CandidatesFile, race.Name)
+		}
+	}
+	return list, nil
+}
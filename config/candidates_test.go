@@ -0,0 +1,36 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCandidates(t *testing.T) {
+	dir := t.TempDir()
+	races := []Race{{Name: "president", MaxVotes: 2}, {Name: "senate", AllowWriteIns: true, MaxVotes: 3}}
+	for name, tc := range map[string]struct {
+		races []Race
+		file  string
+		want  string // in the error. no error if empty
+	}{
+		"single race":      {nil, `{"Candidates": [{"Name": "Alice"}, {"Name": "Bob"}]}`, ""},
+		"several races":    {races, `{"Candidates": [{"Name": "Alice", "Race": "president"}, {"Name": "Bob", "Race": "president"}, {"Name": "Carol", "Race": "senate"}]}`, ""},
+		"no candidates":    {nil, `{"Candidates": []}`, "no candidates"},
+		"empty race":       {races, `{"Candidates": [{"Name": "Alice", "Race": "president"}, {"Name": "Bob", "Race": "president"}]}`, "race senate has no candidates"},
+		"too many votes":   {races, `{"Candidates": [{"Name": "Alice", "Race": "president"}, {"Name": "Carol", "Race": "senate"}]}`, "race president allows more votes"},
+		"unknown race":     {races, `{"Candidates": [{"Name": "Alice", "Race": "mayor"}]}`, "unknown race"},
+		"race of no races": {nil, `{"Candidates": [{"Name": "Alice", "Race": "president"}]}`, "no races are configured"},
+	} {
+		path := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".json")
+		if err := ioutil.WriteFile(path, []byte(tc.file), 0600); err != nil {
+			t.Fatal(err)
+		}
+		c := &Coord{CandidatesFile: path, Races: tc.races}
+		_, err := c.LoadCandidates()
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: %v, want an error with %q", name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,716 @@
+// Package config loads node configuration for coord, miners and clients.
+//
+// A config file is either JSON or a flat YAML file of "Key: value" lines. After the
+// file is read, defaults are filled in, environment variables named
+// BLOCKVOTE_<FIELD> (e.g. BLOCKVOTE_DIFFICULTY) override single fields, and the
+// result is validated.
+package config
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EnvPrefix = "BLOCKVOTE_"
+
+// Config is implemented by all node configs
+type Config interface {
+	SetDefaults()
+	Validate() error
+}
+
+// Race is a contest of an election, e.g. president or a referendum. Races can only be set in JSON files.
+type Race struct {
+	Name          string
+	Candidates    []string
+	MaxVotes      uint8  // number of candidates each voter can vote for. 1 if 0
+	AllowWriteIns bool   // whether voters can write in unlisted candidates
+	Method        string // tally method: "" for plurality or "irv" for instant-runoff over ranked ballots
+}
+
+type Coord struct {
+	ClientAPIListenAddr string
+	MinerAPIListenAddr  string
+	TracingServerAddr   string
+	NCandidates         uint8  // number of generated candidates when Races and CandidatesFile are empty
+	CandidatesFile      string // JSON file of the candidates, see CandidateList. overrides NCandidates and the candidates of Races
+	Races               []Race // races of the election. a single race when empty
+	AllowWriteIns       bool   // whether voters can write in unlisted candidates in the single race
+	Method              string // tally method of the single race, see Race
+	Secret              []byte
+	TracingIdentity     string
+	LostMsgThresh       uint8    // missed heartbeats before a miner is considered failed
+	MaxClockSkew        uint     // seconds a miner's clock may be off coord's. miners beyond it cannot register
+	InclusionSLA        uint     // seconds a txn may take to get into a block before coord warns. not checked when 0
+	BackupDir           string   // scheduled backups are disabled when empty
+	BackupInterval      uint     // seconds between two scheduled backups
+	RecoverFrom         []string // admin API addresses of miners to rebuild the database from when it is missing
+	ForkRetention       uint     // seconds to keep abandoned fork blocks. never pruned when 0
+	StorageKeyFile      string   // node key for encrypting the database. not encrypted when empty
+	MetricsListenAddr   string   // address of the http /metrics endpoint. disabled when empty
+	FeedListenAddr      string   // address of the http /feed live results stream. disabled when empty
+	HealthListenAddr    string   // address of the http /healthz and /readyz probes. disabled when empty
+	AuthorityKeyFile    string   // PEM key signing result certificates, created if missing. a new key every run when empty
+	ElectionEnd         string   // RFC 3339 time after which no ballots are accepted. the election never closes when empty
+	AssignMode          string   // "round-robin" to have coord assign miners to clients in turn. clients pick randomly when empty
+	RegisteredVoters    uint     // voters registered to vote, the base of the turnout. turnout is not computed when 0
+	AdminListenAddr     string   // address of the admin API (quarantined miners). disabled when empty
+	AdminTokenFile      string   // file holding the token admin API callers must send. needed with AdminListenAddr or RecoverFrom
+	ReplicaOf           string   // miner API address of the primary coord. runs as its read-only replica when set
+	ReplicaSyncInterval uint     // seconds between two polls of the primary by a replica
+	MaxConcurrentRPCs   uint     // RPC requests handled at once, the others wait
+	ClientRateLimit     float64  // GetMinerList, QueryTxn(s) and QueryResults calls per second per client host. no limit when 0
+	ClientRateBurst     uint     // calls a client host can make at once before ClientRateLimit applies
+	ClientIDRateLimit   float64  // the same calls per second per client ID, see Client.ClientID. no limit when 0
+	ClientIDRateBurst   uint     // calls a client ID can make at once before ClientIDRateLimit applies
+	ElectionID          string   // name of the election, part of the genesis block. elections hosted by one coord differ in it
+	GenesisTime         string   // RFC 3339 timestamp of the genesis block. the Unix epoch when empty
+	GenesisDifficulty   uint8    // leading zero bits of the genesis block hash. 8 when 0
+	Difficulty          uint8    // leading zero bits of a block hash, fixed when the chain starts. see cmd/minerbench
+	FinalityDepth       uint8    // confirmations a ballot needs to be final and counted
+	HashAlgo            string   // "blake2b" to hash blocks with BLAKE2b-256, part of the genesis block. SHA-256 when empty
+	BridgeListenAddr    string   // address of the HTTP CONNECT bridge for clients behind firewalls. disabled when empty
+	SealingKeyFile      string   // PEM key ballots are sealed to until ElectionEnd, created if missing. not sealed when empty
+	RegistrarKeyFile    string   // PEM key signing voter credentials, created if missing. anyone may vote when empty
+	VoterRollFile       string   // "<student ID> <registration code>" per line, who may register. needed with RegistrarKeyFile
+	DevVoters           []string // student IDs that vote without registering, for tests and demos. coord only starts with them under -dev
+	StrictInvariants    bool     // re-check the chain and the indexes after every block and panic on a violation. slow, for development
+	LegacyGenesis       bool     // run a stored chain whose genesis block predates genesis configs, unchecked. refused otherwise
+}
+
+type Miner struct {
+	MinerId           string
+	CoordAddr         string
+	MinerAddr         string
+	TracingServerAddr string
+	Difficulty        uint8 // leading zero bits of a block hash, checked against coord's chain. coord's when 0
+	Secret            []byte
+	TracingIdentity   string
+	MaxTxn            uint8  // max number of txns in a block
+	ForkRetention     uint   // seconds to keep abandoned fork blocks. never pruned when 0
+	StorageKeyFile    string // node key for encrypting the database. not encrypted when empty
+	MetricsListenAddr string // address of the http /metrics endpoint. disabled when empty
+	HealthListenAddr  string // address of the http /healthz and /readyz probes. disabled when empty
+	StorageDir        string // database directory, kept across restarts. in-memory when empty
+	BackupDir         string // scheduled backups are disabled when empty
+	BackupInterval    uint   // seconds between two scheduled backups
+	AdminListenAddr   string // address of the admin API (quarantined peers, block templates). disabled when empty
+	AdminTokenFile    string // file holding the token admin API callers must send. needed with AdminListenAddr
+	IdentityFile      string // PEM key identifying the miner to coord across restarts, created if missing. a new key every run when empty
+	MaxConcurrentRPCs uint   // RPC requests handled at once, the others wait
+	GenesisHash       string // hex hash of the genesis block coord must have. any when empty
+	VerifyGenesis     bool   // check that coord's genesis block derives from ElectionID, GenesisTime, GenesisDifficulty and HashAlgo
+	GenesisTime       string // as in the coord config, for VerifyGenesis
+	GenesisDifficulty uint8  // as in the coord config, for VerifyGenesis
+	HashAlgo          string // as in the coord config, for VerifyGenesis
+	CoordWaitTimeout  uint   // seconds to wait at startup for coord and its chain, retrying with backoff. forever when 0
+	ElectionID        string // election of coord to mine for. the default election when empty
+	PoolOrder         string // "fifo" to fill blocks in arrival order. voters take turns when empty
+	MaxPoolSize       uint   // pending txns above which clients are told the miner is busy
+	MaxClientBallots  uint   // ballots the miner accepts from each client ID, see Client.ClientID. no limit when 0
+	Label             string // e.g. the region of the miner. clients can ask coord for miners with a label
+	MiningWorkers     uint   // goroutines searching nonces in parallel
+	MiningDutyCycle   uint   // percent of the time spent mining, e.g. 80 to leave CPUs to co-hosted services
+	StrictInvariants  bool   // re-check the chain after every block and panic on a violation. slow, for development
+	LegacyGenesis     bool   // mine on a chain whose genesis block predates genesis configs, unchecked. refused otherwise
+}
+
+type Client struct {
+	ClientID          uint
+	CoordIPPort       string
+	TracingServerAddr string
+	N_Receives        int
+	Secret            []byte
+	TracingIdentity   string
+	ResubmitAfter     uint    // seconds before an unconfirmed txn is resubmitted
+	RetryInterval     uint    // seconds between two retries of a failed coord call
+	ReconnectInterval uint    // seconds between two attempts to reconnect to coord
+	LightClient       bool    // verify ballot status locally with block headers and Merkle proofs
+	ReceiptDir        string  // directory where a receipt of every cast ballot is written. no receipts when empty
+	ResultsTTL        int     // seconds results from coord are reused before asking again. negative to always ask
+	ElectionID        string  // election of coord to vote in. the default election when empty
+	MinerLabel        string  // prefer miners with this label, e.g. a region. any miner when empty
+	KeystoreSocket    string  // unix socket of a keystore agent signing ballots. a wallet file per voter when empty
+	WalletDir         string  // directory of the voters' wallet files. ./tmp when empty
+	StrictResults     bool    // results only count finalized ballots, not every ballot on the longest chain
+	ProbeInterval     uint    // seconds between two rounds of round-trip time probes of the miners
+	ExploreRate       float64 // share of ballots sent to a random miner instead of the fastest one. 1 picks miners at random, negative never does
+	AckQuorum         uint    // miners of N_Receives that must accept a ballot before it is cast
+	QuorumTimeout     uint    // seconds a ballot waits for AckQuorum miners before Vote fails
+	BridgeAddr        string  // HTTP CONNECT bridge to reach coord and miners through, e.g. coord's. direct connections when empty
+	DiscoveryCache    string  // file caching the candidates and miners from coord, to start during a coord outage. no cache when empty
+	DiscoveryMaxAge   uint    // seconds a discovery cache stays usable
+	CandidateRefresh  uint    // seconds between two checks of coord for a new version of the candidate list
+	IntentLog         string  // file logging signed ballots until miners accept them, to cast them again after a crash. no log when empty
+	BreakerThreshold  uint    // failed calls in a row after which a miner is left alone for BreakerCooldown
+	BreakerCooldown   uint    // seconds a failing miner is left alone before it is tried again
+	StudentIDPattern  string  // regular expression every student ID must match. 8 digits when empty
+	CollectStats      bool    // record latency histograms and error counts of the client's RPCs, see evlib.EV.Metrics
+}
+
+// unsupportedFields are fields a config may ask for that nodes do not support. Nodes do not speak TLS, and
+// a config asking for it must not run in plain text
+var unsupportedFields = []string{"TLSCertFile", "TLSKeyFile", "TLSCAFile"}
+
+// Load reads the config file at path into cfg, then applies defaults, environment overrides and validation
+func Load(path string, cfg Config) error {
+	if err := Decode(path, cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Decode is Load without the validation, for a config that is changed (e.g. by flags) and validated after, like
+// Preflight does
+func Decode(path string, cfg Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// unknown fields, the unsupported ones included, are rejected
+		err = decodeYAML(data, cfg)
+	default:
+		err = decodeJSON(data, cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	cfg.SetDefaults()
+	return ApplyEnv(cfg)
+}
+
+// decodeJSON reads a JSON document, rejecting the unsupported fields
+func decodeJSON(data []byte, cfg interface{}) error {
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, name := range unsupportedFields {
+		if _, ok := fields[name]; ok {
+			return fmt.Errorf("%s is not supported, remove it", name)
+		}
+	}
+	return nil
+}
+
+// Path returns the config file named by BLOCKVOTE_CONFIG, or defaultPath if it is not set
+func Path(defaultPath string) string {
+	if path := os.Getenv(EnvPrefix + "CONFIG"); path != "" {
+		return path
+	}
+	return defaultPath
+}
+
+// MustLoad is Load that exits on error
+func MustLoad(path string, cfg Config) {
+	if err := Load(path, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// MustDecode is Decode that exits on error
+func MustDecode(path string, cfg Config) {
+	if err := Decode(path, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// ApplyEnv overrides fields of cfg with BLOCKVOTE_<FIELD> environment variables
+func ApplyEnv(cfg interface{}) error {
+	return eachField(reflect.ValueOf(cfg).Elem(), func(name string, field reflect.Value) error {
+		val, ok := os.LookupEnv(EnvPrefix + strings.ToUpper(name))
+		if !ok {
+			return nil
+		}
+		if err := setField(field, val); err != nil {
+			return fmt.Errorf("%s%s: %v", EnvPrefix, strings.ToUpper(name), err)
+		}
+		return nil
+	})
+}
+
+// ----- defaults and validation -----
+
+func (c *Coord) SetDefaults() {
+	if c.TracingIdentity == "" {
+		c.TracingIdentity = "coord"
+	}
+	if c.LostMsgThresh == 0 {
+		c.LostMsgThresh = 6
+	}
+	if c.MaxClockSkew == 0 {
+		c.MaxClockSkew = 120 // the clock drift allowed in block timestamps
+	}
+	if c.BackupDir != "" && c.BackupInterval == 0 {
+		c.BackupInterval = 3600
+	}
+	if c.ReplicaOf != "" && c.ReplicaSyncInterval == 0 {
+		c.ReplicaSyncInterval = 1
+	}
+	if c.MaxConcurrentRPCs == 0 {
+		c.MaxConcurrentRPCs = 256
+	}
+	if c.ClientRateLimit > 0 && c.ClientRateBurst == 0 {
+		c.ClientRateBurst = 20
+	}
+	if c.ClientIDRateLimit > 0 && c.ClientIDRateBurst == 0 {
+		c.ClientIDRateBurst = 20
+	}
+	if c.FinalityDepth == 0 {
+		c.FinalityDepth = 4
+	}
+	if c.Difficulty == 0 {
+		c.Difficulty = 8
+	}
+}
+
+func (c *Coord) Validate() error {
+	if err := validateAddr("ClientAPIListenAddr", c.ClientAPIListenAddr); err != nil {
+		return err
+	}
+	if err := validateAddr("MinerAPIListenAddr", c.MinerAPIListenAddr); err != nil {
+		return err
+	}
+	if c.ClientAPIListenAddr == c.MinerAPIListenAddr {
+		return errors.New("ClientAPIListenAddr and MinerAPIListenAddr must differ")
+	}
+	if len(c.Races) == 0 && c.NCandidates == 0 && c.CandidatesFile == "" {
+		return errors.New("NCandidates must be positive")
+	}
+	if err := validateMethod("", c.Method, 0, c.AllowWriteIns); err != nil {
+		return err
+	}
+	if _, err := c.ElectionEndTime(); err != nil {
+		return fmt.Errorf("ElectionEnd: %v", err)
+	}
+	if err := validateElectionID(c.ElectionID); err != nil {
+		return err
+	}
+	if _, err := c.GenesisTimestamp(); err != nil {
+		return fmt.Errorf("GenesisTime: %v", err)
+	}
+	if c.GenesisDifficulty != 0 && (c.GenesisDifficulty < 8 || c.GenesisDifficulty > 32) {
+		// below 8 the genesis block fails the proof of work check of light clients
+		return errors.New("GenesisDifficulty must be between 8 and 32")
+	}
+	if c.Difficulty > 32 {
+		return errors.New("Difficulty must be at most 32")
+	}
+	if end, _ := c.ElectionEndTime(); c.SealingKeyFile != "" && end.IsZero() {
+		return errors.New("SealingKeyFile needs an ElectionEnd to release the key")
+	}
+	if (c.RegistrarKeyFile == "") != (c.VoterRollFile == "") {
+		return errors.New("RegistrarKeyFile and VoterRollFile go together")
+	}
+	if len(c.DevVoters) > 0 && c.RegistrarKeyFile == "" {
+		return errors.New("DevVoters need a RegistrarKeyFile, anyone may vote without one")
+	}
+	if c.ClientRateLimit < 0 {
+		return errors.New("ClientRateLimit must not be negative")
+	}
+	if c.ClientIDRateLimit < 0 {
+		return errors.New("ClientIDRateLimit must not be negative")
+	}
+	if c.AssignMode != "" && c.AssignMode != "round-robin" {
+		return fmt.Errorf("unknown AssignMode %q", c.AssignMode)
+	}
+	if c.HashAlgo != "" && c.HashAlgo != "blake2b" {
+		return fmt.Errorf("unknown HashAlgo %q", c.HashAlgo)
+	}
+	if (c.AdminListenAddr != "" || len(c.RecoverFrom) > 0) && c.AdminTokenFile == "" {
+		return errors.New("AdminListenAddr and RecoverFrom need an AdminTokenFile")
+	}
+	if c.ReplicaOf != "" {
+		if err := validateAddr("ReplicaOf", c.ReplicaOf); err != nil {
+			return err
+		}
+		if c.ReplicaOf == c.MinerAPIListenAddr {
+			return errors.New("a replica cannot replicate itself")
+		}
+	}
+	races := make(map[string]bool)
+	nCandidates := 0
+	for _, race := range c.Races {
+		if race.Name == "" {
+			return errors.New("race name cannot be empty")
+		}
+		if races[race.Name] {
+			return fmt.Errorf("race %s is configured twice", race.Name)
+		}
+		races[race.Name] = true
+		if err := validateMethod(race.Name, race.Method, race.MaxVotes, race.AllowWriteIns); err != nil {
+			return err
+		}
+		if c.CandidatesFile != "" {
+			if len(race.Candidates) > 0 {
+				return fmt.Errorf("race %s lists candidates but they are set in CandidatesFile", race.Name)
+			}
+			continue
+		}
+		if len(race.Candidates) == 0 {
+			return fmt.Errorf("race %s has no candidates", race.Name)
+		}
+		if !race.AllowWriteIns && int(race.MaxVotes) > len(race.Candidates) {
+			return fmt.Errorf("race %s allows more votes than it has candidates", race.Name)
+		}
+		names := make(map[string]bool)
+		for _, name := range race.Candidates {
+			if name == "" || names[name] {
+				return fmt.Errorf("race %s has an empty or duplicated candidate", race.Name)
+			}
+			names[name] = true
+		}
+		nCandidates += len(race.Candidates)
+	}
+	if nCandidates > 255 {
+		return errors.New("at most 255 candidates are supported")
+	}
+	return nil
+}
+
+// ElectionEndTime parses ElectionEnd. It is the zero time if ElectionEnd is empty.
+func (c *Coord) ElectionEndTime() (time.Time, error) {
+	if c.ElectionEnd == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, c.ElectionEnd)
+}
+
+// GenesisTimestamp parses GenesisTime into unix seconds. It is 0 if GenesisTime is empty.
+func (c *Coord) GenesisTimestamp() (int64, error) {
+	return genesisTimestamp(c.GenesisTime)
+}
+
+// GenesisTimestamp is Coord.GenesisTimestamp for the miner's copy of GenesisTime
+func (m *Miner) GenesisTimestamp() (int64, error) {
+	return genesisTimestamp(m.GenesisTime)
+}
+
+func genesisTimestamp(genesisTime string) (int64, error) {
+	if genesisTime == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, genesisTime)
+	return t.Unix(), err
+}
+
+func (m *Miner) SetDefaults() {
+	if m.TracingIdentity == "" {
+		m.TracingIdentity = m.MinerId
+	}
+	if m.MaxTxn == 0 {
+		m.MaxTxn = 10
+	}
+	if m.MaxConcurrentRPCs == 0 {
+		m.MaxConcurrentRPCs = 256
+	}
+	if m.MaxPoolSize == 0 {
+		m.MaxPoolSize = 10000
+	}
+	if m.MiningWorkers == 0 {
+		m.MiningWorkers = 1
+	}
+	if m.MiningDutyCycle == 0 {
+		m.MiningDutyCycle = 100
+	}
+	if m.BackupDir != "" && m.BackupInterval == 0 {
+		m.BackupInterval = 3600
+	}
+}
+
+func (m *Miner) Validate() error {
+	if m.MinerId == "" {
+		return errors.New("MinerId cannot be empty")
+	}
+	if err := validateAddr("CoordAddr", m.CoordAddr); err != nil {
+		return err
+	}
+	if err := validateAddr("MinerAddr", m.MinerAddr); err != nil {
+		return err
+	}
+	if err := validateElectionID(m.ElectionID); err != nil {
+		return err
+	}
+	if _, err := hex.DecodeString(m.GenesisHash); err != nil {
+		return fmt.Errorf("GenesisHash: %v", err)
+	}
+	if _, err := m.GenesisTimestamp(); err != nil {
+		return fmt.Errorf("GenesisTime: %v", err)
+	}
+	if m.HashAlgo != "" && m.HashAlgo != "blake2b" {
+		return fmt.Errorf("unknown HashAlgo %q", m.HashAlgo)
+	}
+	if m.PoolOrder != "" && m.PoolOrder != "fifo" {
+		return fmt.Errorf("unknown PoolOrder %q", m.PoolOrder)
+	}
+	if m.MiningDutyCycle > 100 {
+		return errors.New("MiningDutyCycle must be a percentage up to 100")
+	}
+	if m.AdminListenAddr != "" && m.AdminTokenFile == "" {
+		return errors.New("AdminListenAddr needs an AdminTokenFile")
+	}
+	return nil
+}
+
+func (c *Client) SetDefaults() {
+	if c.TracingIdentity == "" {
+		c.TracingIdentity = "client" + strconv.Itoa(int(c.ClientID))
+	}
+	if c.N_Receives == 0 {
+		c.N_Receives = 1
+	}
+	if c.ResubmitAfter == 0 {
+		c.ResubmitAfter = 35
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 2
+	}
+	if c.ReconnectInterval == 0 {
+		c.ReconnectInterval = 3
+	}
+	if c.ResultsTTL == 0 {
+		c.ResultsTTL = 5
+	}
+	if c.ProbeInterval == 0 {
+		c.ProbeInterval = 30
+	}
+	if c.ExploreRate == 0 {
+		c.ExploreRate = 0.1
+	}
+	if c.AckQuorum == 0 {
+		c.AckQuorum = 1
+	}
+	if c.QuorumTimeout == 0 {
+		c.QuorumTimeout = 60
+	}
+	if c.DiscoveryMaxAge == 0 {
+		c.DiscoveryMaxAge = 3600
+	}
+	if c.CandidateRefresh == 0 {
+		c.CandidateRefresh = 30
+	}
+	if c.BreakerThreshold == 0 {
+		c.BreakerThreshold = 3
+	}
+	if c.BreakerCooldown == 0 {
+		c.BreakerCooldown = 30
+	}
+}
+
+func (c *Client) Validate() error {
+	if err := validateAddr("CoordIPPort", c.CoordIPPort); err != nil {
+		return err
+	}
+	if c.N_Receives < 0 {
+		return errors.New("N_Receives cannot be negative")
+	}
+	if c.ExploreRate > 1 {
+		return errors.New("ExploreRate cannot be above 1")
+	}
+	if int(c.AckQuorum) > c.N_Receives {
+		return errors.New("AckQuorum cannot be above N_Receives")
+	}
+	if _, err := regexp.Compile(c.StudentIDPattern); err != nil {
+		return fmt.Errorf("StudentIDPattern: %v", err)
+	}
+	if err := validateElectionID(c.ElectionID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ----- utility functions -----
+
+func validateAddr(name string, addr string) error {
+	if addr == "" {
+		return fmt.Errorf("%s cannot be empty", name)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s: %v (IPv6 addresses go in brackets, e.g. [::1]:8000)", name, err)
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("%s: invalid port %q", name, port)
+	}
+	if !validHost(host) {
+		return fmt.Errorf("%s: %q is neither an IP address nor a host name", name, host)
+	}
+	return nil
+}
+
+// validHost checks that host is empty (every local address), an IPv4 or IPv6 address, or a DNS name
+func validHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	// link-local IPv6 addresses carry the interface, e.g. fe80::1%eth0
+	if i := strings.LastIndexByte(host, '%'); i > 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	if len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateElectionID checks that an election ID can scope RPC service names and directory names
+func validateElectionID(id string) error {
+	if len(id) > 64 {
+		return errors.New("ElectionID cannot be longer than 64 characters")
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("ElectionID %q can only have letters, digits, '-' and '_'", id)
+		}
+	}
+	return nil
+}
+
+// validateMethod checks the tally method of a race. instant-runoff takes one ranked ballot per voter
+func validateMethod(race string, method string, maxVotes uint8, allowWriteIns bool) error {
+	switch method {
+	case "":
+		return nil
+	case "irv":
+		if maxVotes > 1 || allowWriteIns {
+			return fmt.Errorf("race %q: instant-runoff allows neither MaxVotes above 1 nor write-ins", race)
+		}
+		return nil
+	default:
+		return fmt.Errorf("race %q: unknown tally method %q", race, method)
+	}
+}
+
+// decodeYAML reads a flat YAML document of "Key: value" lines. Nested maps and lists are not supported.
+func decodeYAML(data []byte, cfg interface{}) error {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			return fmt.Errorf("line %d: expected \"Key: value\"", lineNum)
+		}
+		key := strings.TrimSpace(line[:idx])
+		val := strings.TrimSpace(line[idx+1:])
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
+		} else if i := strings.Index(val, " #"); i >= 0 {
+			val = strings.TrimSpace(val[:i])
+		}
+		values[key] = val
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	err := eachField(reflect.ValueOf(cfg).Elem(), func(name string, field reflect.Value) error {
+		val, ok := values[name]
+		if !ok {
+			return nil
+		}
+		delete(values, name)
+		if err := setField(field, val); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key := range values {
+		return fmt.Errorf("unknown field %q", key)
+	}
+	return nil
+}
+
+// eachField calls fn on every settable field of the struct v, descending into embedded structs
+func eachField(v reflect.Value, fn func(name string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			if err := eachField(v.Field(i), fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if err := fn(sf.Name, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, val string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %v", field.Type())
+		}
+		field.SetBytes([]byte(val))
+	default:
+		return fmt.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
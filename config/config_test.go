@@ -0,0 +1,63 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:8000", true},
+		{"[::1]:8000", true},
+		{"[fe80::1%eth0]:8000", true},
+		{"coord.example.com:8000", true},
+		{"coord:8000", true},
+		{":8000", true}, // every local address
+		{"127.0.0.1:0", true},
+		{"", false},
+		{"127.0.0.1", false},
+		{"::1:8000", false}, // IPv6 without brackets
+		{"127.0.0.1:65536", false},
+		{"127.0.0.1:port", false},
+		{"-coord:8000", false},
+		{"coord..example.com:8000", false},
+		{"coord_1:8000", false},
+	} {
+		if err := validateAddr("Addr", tc.addr); (err == nil) != tc.ok {
+			t.Errorf("validateAddr(%q): %v, want ok %v", tc.addr, err, tc.ok)
+		}
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"miner.json": `{"MinerId": "miner1", "TLSCertFile": "cert.pem"}`,
+		"miner.yaml": "MinerId: miner1\nTLSCAFile: ca.pem\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := Decode(path, &Miner{}); err == nil || !strings.Contains(err.Error(), "TLS") {
+			t.Errorf("%s: %v, want the TLS field rejected", name, err)
+		}
+	}
+
+	// Decode leaves the validation to the caller
+	path := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(path, []byte(`{"MinerId": "miner1", "CoordAddr": "coord"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Decode(path, &Miner{}); err != nil {
+		t.Fatalf("decode of an invalid config: %v", err)
+	}
+	if err := Load(path, &Miner{}); err == nil {
+		t.Fatal("load of an invalid config succeeded")
+	}
+}
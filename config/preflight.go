@@ -0,0 +1,203 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Problem is a config field that would make a node fail to start, with what to do about it
+type Problem struct {
+	Field string
+	Err   string
+	Hint  string
+}
+
+func (p *Problem) Error() string {
+	if p.Hint == "" {
+		return fmt.Sprintf("%s: %s", p.Field, p.Err)
+	}
+	return fmt.Sprintf("%s: %s. %s", p.Field, p.Err, p.Hint)
+}
+
+// Problems are all the problems found by a preflight check, one per line
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = "  " + p.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// listenAddr is an address a node listens at, named by its config field
+type listenAddr struct {
+	field string
+	addr  string
+}
+
+// Preflight is Validate followed by checks against the machine coord is about to start on: its listen
+// addresses must be free and distinct, and its database, backup and key paths writable. storageDir is
+// coord's database directory. All problems are reported at once.
+func (c *Coord) Preflight(storageDir string) error {
+	if err := c.Validate(); err != nil {
+		return Problems{{Field: "config", Err: err.Error()}}
+	}
+	var problems Problems
+	problems = append(problems, checkListenAddrs([]listenAddr{
+		{"ClientAPIListenAddr", c.ClientAPIListenAddr},
+		{"MinerAPIListenAddr", c.MinerAPIListenAddr},
+		{"MetricsListenAddr", c.MetricsListenAddr},
+		{"FeedListenAddr", c.FeedListenAddr},
+		{"HealthListenAddr", c.HealthListenAddr},
+		{"AdminListenAddr", c.AdminListenAddr},
+		{"BridgeListenAddr", c.BridgeListenAddr},
+	})...)
+	if c.TracingServerAddr != "" {
+		if err := validateAddr("TracingServerAddr", c.TracingServerAddr); err != nil {
+			problems = append(problems, &Problem{Field: "TracingServerAddr", Err: err.Error(), Hint: "Use host:port"})
+		}
+	}
+	for _, addr := range c.RecoverFrom {
+		if err := validateAddr("RecoverFrom", addr); err != nil {
+			problems = append(problems, &Problem{Field: "RecoverFrom", Err: err.Error(), Hint: "List the miners' AdminListenAddr"})
+		}
+	}
+	if c.CandidatesFile != "" {
+		if _, err := os.Stat(c.CandidatesFile); err != nil {
+			problems = append(problems, &Problem{Field: "CandidatesFile", Err: err.Error()})
+		}
+	}
+	if c.ReplicaOf == "" {
+		problems = append(problems, checkWritableDir("storage directory", storageDir)...)
+	}
+	problems = append(problems, checkWritableDir("BackupDir", c.BackupDir)...)
+	problems = append(problems, checkKeyFile("StorageKeyFile", c.StorageKeyFile, false)...)
+	problems = append(problems, checkKeyFile("AuthorityKeyFile", c.AuthorityKeyFile, true)...)
+	problems = append(problems, checkKeyFile("SealingKeyFile", c.SealingKeyFile, true)...)
+	problems = append(problems, checkKeyFile("RegistrarKeyFile", c.RegistrarKeyFile, true)...)
+	problems = append(problems, checkKeyFile("VoterRollFile", c.VoterRollFile, false)...)
+	problems = append(problems, checkKeyFile("AdminTokenFile", c.AdminTokenFile, false)...)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Preflight is Validate followed by checks against the machine the miner is about to start on: its listen
+// addresses must be free and distinct, and its database and key paths writable
+func (m *Miner) Preflight() error {
+	if err := m.Validate(); err != nil {
+		return Problems{{Field: "config", Err: err.Error()}}
+	}
+	var problems Problems
+	if m.MaxTxn == 0 {
+		problems = append(problems, &Problem{Field: "MaxTxn", Err: "blocks cannot hold any txn", Hint: "Use at least 1"})
+	}
+	problems = append(problems, checkListenAddrs([]listenAddr{
+		{"MinerAddr", m.MinerAddr},
+		{"MetricsListenAddr", m.MetricsListenAddr},
+		{"HealthListenAddr", m.HealthListenAddr},
+		{"AdminListenAddr", m.AdminListenAddr},
+	})...)
+	if m.CoordAddr == m.MinerAddr {
+		problems = append(problems, &Problem{Field: "CoordAddr", Err: "is the miner's own MinerAddr",
+			Hint: "Use coord's MinerAPIListenAddr"})
+	}
+	problems = append(problems, checkWritableDir("StorageDir", m.StorageDir)...)
+	problems = append(problems, checkWritableDir("BackupDir", m.BackupDir)...)
+	problems = append(problems, checkKeyFile("StorageKeyFile", m.StorageKeyFile, false)...)
+	problems = append(problems, checkKeyFile("IdentityFile", m.IdentityFile, true)...)
+	problems = append(problems, checkKeyFile("AdminTokenFile", m.AdminTokenFile, false)...)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Preflight checks N_Receives against the number of miners coord has, and that receipts can be written
+func (c *Client) Preflight(miners int) error {
+	var problems Problems
+	if c.N_Receives > miners {
+		problems = append(problems, &Problem{Field: "N_Receives", Err: fmt.Sprintf("%d is above the %d miners of coord", c.N_Receives, miners),
+			Hint: "Lower it or start more miners, or ballots wait for miners that do not exist"})
+	}
+	problems = append(problems, checkWritableDir("ReceiptDir", c.ReceiptDir)...)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// ----- utility functions -----
+
+// checkListenAddrs checks that the set addresses are well-formed, do not share a port and can be listened at now
+func checkListenAddrs(addrs []listenAddr) (problems Problems) {
+	ports := make(map[string]string)
+	for _, a := range addrs {
+		if a.addr == "" {
+			continue
+		}
+		if err := validateAddr(a.field, a.addr); err != nil {
+			problems = append(problems, &Problem{Field: a.field, Err: err.Error(), Hint: "Use host:port, e.g. 127.0.0.1:8080"})
+			continue
+		}
+		_, port, _ := net.SplitHostPort(a.addr)
+		if port == "0" {
+			continue // any free port
+		}
+		if other, ok := ports[port]; ok {
+			problems = append(problems, &Problem{Field: a.field, Err: fmt.Sprintf("port %s is also used by %s", port, other),
+				Hint: "Give every listener a port of its own"})
+			continue
+		}
+		ports[port] = a.field
+		listener, err := net.Listen("tcp", a.addr)
+		if err != nil {
+			problems = append(problems, &Problem{Field: a.field, Err: fmt.Sprintf("cannot listen at %s: %v", a.addr, err),
+				Hint: "Stop the process using the port or pick another one"})
+			continue
+		}
+		listener.Close()
+	}
+	return problems
+}
+
+// checkWritableDir checks that files can be created in dir, creating it if missing. Nothing to check if dir is empty
+func checkWritableDir(field string, dir string) Problems {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Problems{{Field: field, Err: err.Error(), Hint: "Use a directory this user can create"}}
+	}
+	f, err := ioutil.TempFile(dir, ".preflight-")
+	if err != nil {
+		return Problems{{Field: field, Err: fmt.Sprintf("%s is not writable: %v", dir, err), Hint: "Fix its permissions or use another directory"}}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// checkKeyFile checks that a key file exists, or that its directory is writable if it is created when missing
+func checkKeyFile(field string, path string, created bool) Problems {
+	if path == "" {
+		return nil
+	}
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		return nil
+	case !errors.Is(err, os.ErrNotExist):
+		return Problems{{Field: field, Err: err.Error()}}
+	case !created:
+		return Problems{{Field: field, Err: fmt.Sprintf("%s does not exist", path), Hint: "Create the key first or leave the field empty"}}
+	}
+	return checkWritableDir(field, filepath.Dir(path))
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// problemFields returns the fields of the problems in err, sorted
+func problemFields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("%v is not a list of problems", err)
+	}
+	var fields []string
+	for _, p := range problems {
+		fields = append(fields, p.Field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestCoordPreflight(t *testing.T) {
+	dir := t.TempDir()
+	coord := func() *Coord {
+		c := &Coord{ClientAPIListenAddr: "127.0.0.1:0", MinerAPIListenAddr: "localhost:0", NCandidates: 2}
+		c.SetDefaults()
+		return c
+	}
+	if err := coord().Preflight(filepath.Join(dir, "storage")); err != nil {
+		t.Fatalf("preflight of a good config: %v", err)
+	}
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	notDir := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := coord()
+	c.MetricsListenAddr = taken.Addr().String()
+	c.FeedListenAddr = "127.0.0.1:41234"
+	c.HealthListenAddr = "127.0.0.1:41234"
+	c.CandidatesFile = filepath.Join(dir, "candidates.json")
+	c.StorageKeyFile = filepath.Join(dir, "storage.key")
+	c.BackupDir = filepath.Join(notDir, "backups")
+	// every problem is reported at once
+	want := []string{"BackupDir", "CandidatesFile", "HealthListenAddr", "MetricsListenAddr", "StorageKeyFile"}
+	if fields := problemFields(t, c.Preflight(filepath.Join(dir, "storage"))); !reflect.DeepEqual(fields, want) {
+		t.Fatalf("problems with %v, want %v", fields, want)
+	}
+
+	c = coord()
+	c.NCandidates = 0
+	if fields := problemFields(t, c.Preflight(dir)); !reflect.DeepEqual(fields, []string{"config"}) {
+		t.Fatalf("problems of an invalid config with %v, want the config", fields)
+	}
+}
+
+func TestMinerPreflight(t *testing.T) {
+	miner := func() *Miner {
+		m := &Miner{MinerId: "miner1", CoordAddr: "127.0.0.1:22746", MinerAddr: "127.0.0.1:0", StorageDir: t.TempDir()}
+		m.SetDefaults()
+		return m
+	}
+	if err := miner().Preflight(); err != nil {
+		t.Fatalf("preflight of a good config: %v", err)
+	}
+	m := miner()
+	m.MaxTxn = 0
+	m.MinerAddr = m.CoordAddr
+	want := []string{"CoordAddr", "MaxTxn"}
+	if fields := problemFields(t, m.Preflight()); !reflect.DeepEqual(fields, want) {
+		t.Fatalf("problems with %v, want %v", fields, want)
+	}
+}
+
+func TestClientPreflight(t *testing.T) {
+	c := &Client{N_Receives: 3, ReceiptDir: t.TempDir()}
+	if err := c.Preflight(3); err != nil {
+		t.Fatalf("as many receives as miners: %v", err)
+	}
+	if fields := problemFields(t, c.Preflight(2)); !reflect.DeepEqual(fields, []string{"N_Receives"}) {
+		t.Fatalf("problems with %v, want N_Receives", fields)
+	}
+}
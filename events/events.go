@@ -0,0 +1,100 @@
+package events
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"log"
+	"sync"
+	"time"
+)
+
+type Topic string
+
+const (
+	NewBlock    Topic = "NewBlock"    // a block has been added to the local chain
+	NewTxn      Topic = "NewTxn"      // an unseen transaction has been received
+	ForkSwitch  Topic = "ForkSwitch"  // the longest chain switched to a different fork
+	MinerJoined Topic = "MinerJoined" // a miner registered with coord
+	MinerLost   Topic = "MinerLost"   // coord detected a miner failure
+)
+
+// Event carries the data of a published topic. Only the fields relevant to the topic are set:
+//
+//	NewBlock:    Block, OnLongestChain
+//	NewTxn:      Txn
+//	ForkSwitch:  OldLastHash, NewLastHash, NewTxns, OldTxns
+//	MinerJoined: MinerID, MinerAddr
+//	MinerLost:   MinerID, MinerAddr
+type Event struct {
+	Topic Topic
+	Time  time.Time
+
+	Block          *blockchain.Block
+	OnLongestChain bool
+
+	Txn *blockchain.Transaction
+
+	OldLastHash []byte
+	NewLastHash []byte
+	NewTxns     []*blockchain.Transaction
+	OldTxns     []*blockchain.Transaction
+
+	MinerID   string
+	MinerAddr string
+}
+
+// Bus is an in-process publish/subscribe event bus
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[Topic][]chan Event
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[Topic][]chan Event)}
+}
+
+// Subscribe returns a channel receiving all future events of the given topics.
+// Events are dropped for a subscriber whose buffer is full, so subscribers should keep up.
+func (b *Bus) Subscribe(bufSize int, topics ...Topic) <-chan Event {
+	ch := make(chan Event, bufSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, topic := range topics {
+		b.subs[topic] = append(b.subs[topic], ch)
+	}
+	return ch
+}
+
+// Unsubscribe stops delivering events to ch and closes it
+func (b *Bus) Unsubscribe(ch <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var found chan Event
+	for topic, subs := range b.subs {
+		for i, sub := range subs {
+			if sub == ch {
+				found = sub
+				b.subs[topic] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+	if found != nil {
+		close(found)
+	}
+}
+
+// Publish delivers an event to every subscriber of its topic without blocking
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ch := range b.subs[event.Topic] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("[WARN] Event bus subscriber is full, dropping %s event\n", event.Topic)
+		}
+	}
+}
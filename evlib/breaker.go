@@ -0,0 +1,77 @@
+package evlib
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"time"
+)
+
+// A miner that keeps failing is left alone for BreakerCooldown, so that Vote, GetBallotStatus and the background
+// probes do not all keep hitting the same dead miner. The breaker of a miner opens after BreakerThreshold calls
+// in a row failed, and once the cool-down is over the next call is a trial: one success closes the breaker,
+// one failure opens it again for another cool-down. Miners are tracked by address, so a breaker outlives the
+// miner leaving and coming back in the list from coord
+
+// endpointBreaker is the circuit breaker of one miner
+type endpointBreaker struct {
+	failures  int       // calls in a row that failed
+	openUntil time.Time // no calls go to the miner before then
+}
+
+// unreachable tells whether a call failed because of the miner rather than the request, e.g. a broken
+// connection. Errors with a code of their own are answers and keep the breaker closed
+func unreachable(err error) bool {
+	return err != nil && blockvote.CodeOf(err) == blockvote.CodeInternal
+}
+
+// callFailed counts a failed call to the miner at addr and opens its breaker after BreakerThreshold failures
+// in a row
+func (d *EV) callFailed(addr string) {
+	d.rw.Lock()
+	defer d.rw.Unlock()
+	if d.breakers == nil {
+		d.breakers = make(map[string]*endpointBreaker)
+	}
+	b := d.breakers[addr]
+	if b == nil {
+		b = new(endpointBreaker)
+		d.breakers[addr] = b
+	}
+	b.failures++
+	if d.BreakerThreshold > 0 && b.failures >= d.BreakerThreshold {
+		if b.failures == d.BreakerThreshold {
+			d.logger().Printf("[WARN] Miner %s failed %d times in a row, trying other miners for %v\n", addr, b.failures, d.BreakerCooldown)
+		}
+		b.openUntil = d.Clock.Now().Add(d.BreakerCooldown)
+	}
+}
+
+// callSucceeded closes the breaker of the miner at addr
+func (d *EV) callSucceeded(addr string) {
+	d.rw.Lock()
+	defer d.rw.Unlock()
+	if b := d.breakers[addr]; b != nil {
+		if b.failures >= d.BreakerThreshold && d.BreakerThreshold > 0 {
+			d.logger().Printf("[INFO] Miner %s answers again\n", addr)
+		}
+		delete(d.breakers, addr)
+	}
+}
+
+// recordCall counts the outcome of a call to the miner at addr in its breaker
+func (d *EV) recordCall(addr string, err error) {
+	if unreachable(err) {
+		d.callFailed(addr)
+	} else {
+		d.callSucceeded(addr)
+	}
+}
+
+// breakerOpen returns when the breaker of the miner at addr lets calls through again, and false if it does
+// now. Called with rw held
+func (d *EV) breakerOpen(addr string, now time.Time) (time.Time, bool) {
+	b := d.breakers[addr]
+	if b == nil || !b.openUntil.After(now) {
+		return time.Time{}, false
+	}
+	return b.openUntil, true
+}
@@ -0,0 +1,103 @@
+package evlib
+
+import (
+	"bytes"
+	wallet "cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+)
+
+// applyCandidates takes the candidate list, election deadline and chain parameters from a GetCandidates reply
+func (d *EV) applyCandidates(reply blockvote.GetCandidatesReply) {
+	names := make([]string, 0)
+	races := make([]string, 0)
+	rules := make(map[string]wallet.Candidate)
+	positions := make(map[string]int)
+	var candidates []blockvote.CandidateInfo
+	for _, cand := range reply.Candidates {
+		wallets := wallet.DecodeToWallets(cand)
+		names = append(names, wallets.CandidateData.CandidateName)
+		races = append(races, wallets.CandidateData.Race)
+		rules[wallets.CandidateData.Race] = wallets.CandidateData
+		positions[wallets.CandidateData.Race]++
+		candidates = append(candidates, blockvote.CandidateInfo{
+			Name:     wallets.CandidateData.CandidateName,
+			ID:       wallets.CandidateData.ID,
+			Race:     wallets.CandidateData.Race,
+			Address:  wallets.GetAddress(),
+			Position: positions[wallets.CandidateData.Race],
+		})
+	}
+	if len(reply.Info) == len(candidates) {
+		candidates = reply.Info // with statements
+	}
+	d.rw.Lock()
+	d.candidatesReply = reply
+	d.CandidateList = names
+	d.CandidateRaces = races
+	d.candidates = candidates
+	d.raceRules = rules
+	d.ElectionEnd = reply.ElectionEnd
+	d.genesis = reply.Genesis
+	d.FinalityDepth = reply.FinalityDepth
+	d.difficulty = reply.Difficulty
+	d.sealingKey = reply.SealingKey
+	d.registration = reply.Registration
+	d.rw.Unlock()
+}
+
+// CandidatesVersion returns the version of the candidate list in use, see blockvote.GetCandidatesReply. It is 0
+// for a coord that does not version its list.
+func (d *EV) CandidatesVersion() uint64 {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	return d.candidatesReply.Version
+}
+
+// CandidateWatcher asks coord every CandidateRefresh whether it hands out a newer candidate list, and switches
+// to it. OnCandidatesChanged is called with the new list.
+func (d *EV) CandidateWatcher() {
+	for {
+		select {
+		case <-d.quit:
+			return
+		default:
+			d.Clock.Sleep(d.CandidateRefresh)
+		}
+
+		known := d.CandidatesVersion()
+		var reply blockvote.GetCandidatesReply
+		d.connRw.RLock()
+		err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetCandidates"), blockvote.GetCandidatesArgs{
+			KnownVersion: known,
+		}, &reply)
+		d.connRw.RUnlock()
+		if err != nil {
+			// the other background calls complain about coord, the list is asked again next time
+			continue
+		}
+		if reply.Unchanged || reply.Version == known {
+			continue
+		}
+		d.rw.RLock()
+		sameElection := bytes.Equal(reply.Genesis, d.genesis)
+		d.rw.RUnlock()
+		if !sameElection {
+			d.logger().Printf("[WARN] Ignoring version %d of the candidate list, it is for another genesis block\n", reply.Version)
+			continue
+		}
+		d.logger().Printf("[INFO] Candidate list moved from version %d to %d\n", known, reply.Version)
+		d.applyCandidates(reply)
+		d.saveDiscoveryCache()
+		if d.OnCandidatesChanged != nil {
+			d.OnCandidatesChanged(reply.Version, d.Candidates())
+		}
+	}
+}
+
+// rules returns the rules of a race, and false if there is no such race
+func (d *EV) rules(race string) (wallet.Candidate, bool) {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	rules, ok := d.raceRules[race]
+	return rules, ok
+}
@@ -0,0 +1,142 @@
+package evlib
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNoMinerTally is returned by CrossCheckResults when no miner answered
+var ErrNoMinerTally = errors.New("no miner returned a tally")
+
+// MinerTally is the tally a miner counted on its own copy of the chain
+type MinerTally struct {
+	Miner     string // client API address of the miner
+	Height    uint32 // block number of LastHash, the block the tally was counted at
+	TipHeight uint32 // block number of the tip of the miner's longest chain
+	LastHash  []byte
+	Votes     []uint
+	Races     []blockvote.RaceTally // votes, abstentions, write-ins and runoff rounds of each race
+	Compared  bool                  // the miner's tip was close enough to coord's to compare tallies
+	Err       error                 // the miner could not be asked
+}
+
+// CrossCheck is the outcome of comparing coord's tally with those of miners
+type CrossCheck struct {
+	Coord    *Results
+	Miners   []MinerTally
+	Diverged bool     // coord's tally disagrees with a miner's, or coord is far behind a miner
+	Reasons  []string // one per disagreement
+}
+
+// CrossCheckResults API asks coord and k randomly chosen miners for the tally and compares them, to detect a
+// compromised or stale coord. Miners count at coord's tip when they have it, and tallies counted at the same
+// tip must match exactly, abstentions, write-ins and runoff rounds of every race included. A miner ahead of
+// coord by more than FinalityDepth blocks means coord is stale. Tallies at other tips are not compared, as nodes
+// legitimately lag each other by a few blocks.
+func (d *EV) CrossCheckResults(k int) (*CrossCheck, error) {
+	coordResults, err := d.GetResults(true)
+	if err != nil {
+		return nil, err
+	}
+	d.rw.RLock()
+	minerList := append([]string(nil), d.MinerAddrList...)
+	d.rw.RUnlock()
+	if k > len(minerList) {
+		k = len(minerList)
+	}
+
+	check := &CrossCheck{Coord: coordResults}
+	answered := 0
+	for _, idx := range d.Rand.Perm(len(minerList))[:k] {
+		tally := d.queryMinerTally(minerList[idx], coordResults.LastHash, d.StrictResults)
+		if tally.Err != nil {
+			d.logger().Printf("[WARN] Unable to get the tally of miner %s: %v\n", tally.Miner, tally.Err)
+			check.Miners = append(check.Miners, tally)
+			continue
+		}
+		answered++
+		if bytes.Equal(tally.LastHash, coordResults.LastHash) {
+			tally.Compared = true
+			if !reflect.DeepEqual(tally.Votes, coordResults.Votes) {
+				check.Reasons = append(check.Reasons, fmt.Sprintf("miner %s counts %v at block #%d (%x), coord counts %v",
+					tally.Miner, tally.Votes, tally.Height, tally.LastHash, coordResults.Votes))
+			}
+			for _, diff := range raceDivergence(tally.Races, coordResults.Races) {
+				check.Reasons = append(check.Reasons, fmt.Sprintf("miner %s at block #%d (%x): %s",
+					tally.Miner, tally.Height, tally.LastHash, diff))
+			}
+		}
+		if int(tally.TipHeight) > int(coordResults.Height)+d.FinalityDepth {
+			tally.Compared = true
+			check.Reasons = append(check.Reasons, fmt.Sprintf("coord is at block #%d, %d blocks behind miner %s",
+				coordResults.Height, int(tally.TipHeight)-int(coordResults.Height), tally.Miner))
+		}
+		check.Miners = append(check.Miners, tally)
+	}
+	if answered == 0 && k > 0 {
+		return check, ErrNoMinerTally
+	}
+	check.Diverged = len(check.Reasons) > 0
+	for _, reason := range check.Reasons {
+		d.logger().Println("[WARN] Tally divergence:", reason)
+	}
+	return check, nil
+}
+
+// queryMinerTally asks the miner at minerAddr for its tally at the block at, of finalized ballots only if
+// strict. A miner that does not have the block yet is asked for the tally at its own tip
+func (d *EV) queryMinerTally(minerAddr string, at []byte, strict bool) MinerTally {
+	tally := MinerTally{Miner: minerAddr}
+	conn, err := d.dial(minerAddr)
+	if err != nil {
+		d.callFailed(minerAddr)
+		tally.Err = err
+		return tally
+	}
+	defer conn.Close()
+	var reply blockvote.QueryResultsReply
+	tally.Err = d.call(conn, "MinerAPIClient.QueryResults", blockvote.QueryResultsArgs{Strict: strict, At: at}, &reply)
+	if blockvote.CodeOf(tally.Err) == blockvote.CodeNotFound {
+		reply = blockvote.QueryResultsReply{}
+		tally.Err = d.call(conn, "MinerAPIClient.QueryResults", blockvote.QueryResultsArgs{Strict: strict}, &reply)
+	}
+	d.recordCall(minerAddr, tally.Err)
+	if tally.Err == nil {
+		tally.Height = reply.Height
+		tally.TipHeight = reply.TipHeight
+		if tally.TipHeight == 0 {
+			// miners without pinned tallies only count at their tip
+			tally.TipHeight = reply.Height
+		}
+		tally.LastHash = reply.LastHash
+		tally.Votes = reply.Votes
+		tally.Races = reply.Races
+	}
+	return tally
+}
+
+// raceDivergence describes how the race tallies of a miner differ from coord's, beyond the votes of the
+// listed candidates: abstentions, write-ins and instant-runoff rounds
+func raceDivergence(miner, coord []blockvote.RaceTally) []string {
+	if len(miner) != len(coord) {
+		return []string{fmt.Sprintf("miner counts %d races, coord counts %d", len(miner), len(coord))}
+	}
+	var diffs []string
+	for i := range coord {
+		m, c := miner[i], coord[i]
+		switch {
+		case m.Race != c.Race:
+			diffs = append(diffs, fmt.Sprintf("race #%d is %q, coord has %q", i, m.Race, c.Race))
+		case m.Abstain != c.Abstain:
+			diffs = append(diffs, fmt.Sprintf("race %q has %d abstentions, coord counts %d", c.Race, m.Abstain, c.Abstain))
+		case len(m.WriteIns)+len(c.WriteIns) > 0 && !reflect.DeepEqual(m.WriteIns, c.WriteIns):
+			diffs = append(diffs, fmt.Sprintf("race %q has write-ins %v, coord counts %v", c.Race, m.WriteIns, c.WriteIns))
+		case !reflect.DeepEqual(m.Runoff, c.Runoff):
+			diffs = append(diffs, fmt.Sprintf("race %q has other runoff rounds than coord", c.Race))
+		}
+	}
+	return diffs
+}
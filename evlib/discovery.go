@@ -0,0 +1,83 @@
+package evlib
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// discoveryCache is what the client last learned from coord, see DiscoveryCache
+type discoveryCache struct {
+	CoordAddr     string
+	ElectionID    string
+	Candidates    blockvote.GetCandidatesReply
+	MinerAddrList []string
+	Assigned      string
+	SavedAt       time.Time
+}
+
+// loadDiscoveryCache reads the cache at DiscoveryCache. It is nil if there is none, or if it is for another
+// coord or election or older than DiscoveryMaxAge
+func (d *EV) loadDiscoveryCache() *discoveryCache {
+	if d.DiscoveryCache == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(d.DiscoveryCache)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			d.logger().Println("[WARN] Unable to read the discovery cache:", err)
+		}
+		return nil
+	}
+	var cache discoveryCache
+	if err = json.Unmarshal(data, &cache); err != nil {
+		d.logger().Println("[WARN] Ignoring malformed discovery cache:", err)
+		return nil
+	}
+	if cache.CoordAddr != d.coordIPPort || cache.ElectionID != d.ElectionID || len(cache.MinerAddrList) == 0 {
+		return nil
+	}
+	if age := d.Clock.Now().Sub(cache.SavedAt); age > d.DiscoveryMaxAge {
+		d.logger().Printf("[INFO] Discovery cache is %v old, not using it\n", age.Round(time.Second))
+		return nil
+	}
+	return &cache
+}
+
+// saveDiscoveryCache writes the candidates and the current miner list to DiscoveryCache. The file is
+// replaced at once, so a crash never leaves half of it
+func (d *EV) saveDiscoveryCache() {
+	if d.DiscoveryCache == "" {
+		return
+	}
+	d.rw.RLock()
+	cache := discoveryCache{
+		CoordAddr:     d.coordIPPort,
+		ElectionID:    d.ElectionID,
+		Candidates:    d.candidatesReply,
+		MinerAddrList: append([]string(nil), d.MinerAddrList...),
+		Assigned:      d.assignedMiner,
+		SavedAt:       d.Clock.Now(),
+	}
+	d.rw.RUnlock()
+	if len(cache.MinerAddrList) == 0 {
+		// an empty list would not help a later start
+		return
+	}
+	data, err := json.MarshalIndent(&cache, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(d.DiscoveryCache), 0700)
+	}
+	if err == nil {
+		tmp := d.DiscoveryCache + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, d.DiscoveryCache)
+		}
+	}
+	if err != nil {
+		d.logger().Println("[WARN] Unable to write the discovery cache:", err)
+	}
+}
@@ -0,0 +1,44 @@
+package evlib
+
+import (
+	"errors"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+)
+
+func TestReplyCodes(t *testing.T) {
+	for _, tc := range []struct {
+		code     blockvote.ErrorCode
+		typed    error // what typedError wraps. the error itself if nil
+		rejected bool  // whether SubmitTxn refused the txn for good
+		rejects  error // what sendTxn returns for a refused txn
+	}{
+		{code: blockvote.CodeNotFound, typed: ErrNotFound},
+		{code: blockvote.CodeDuplicate},
+		{code: blockvote.CodeUnauthorized, typed: ErrUnauthorized, rejected: true, rejects: ErrTxnRejected},
+		{code: blockvote.CodeBusy},
+		{code: blockvote.CodeWrongElection, typed: ErrWrongElection, rejected: true, rejects: ErrTxnRejected},
+		{code: blockvote.CodeChainSyncing},
+		{code: blockvote.CodeElectionClosed, typed: ErrElectionClosed},
+		{code: blockvote.CodeUnavailable},
+		{code: blockvote.CodeInvalid, rejected: true, rejects: ErrTxnRejected},
+		{code: blockvote.CodeInternal},
+		{code: blockvote.CodeQuotaExceeded, typed: ErrQuotaExceeded},
+		{code: blockvote.CodeAlreadyVoted, typed: ErrAlreadyVoted, rejected: true, rejects: ErrAlreadyVoted},
+	} {
+		err := &blockvote.RPCError{Code: tc.code, Message: "reply of " + tc.code.String()}
+		typed := typedError(err)
+		if tc.typed == nil && typed != error(err) {
+			t.Errorf("%v: typedError returns %v, want the error itself", tc.code, typed)
+		} else if tc.typed != nil && !errors.Is(typed, tc.typed) {
+			t.Errorf("%v: typedError returns %v, want %v", tc.code, typed, tc.typed)
+		}
+		if rejected(tc.code) != tc.rejected {
+			t.Errorf("%v: rejected is %v, want %v", tc.code, !tc.rejected, tc.rejected)
+		}
+		if tc.rejected && !errors.Is(rejection(err), tc.rejects) {
+			t.Errorf("%v: rejection returns %v, want %v", tc.code, rejection(err), tc.rejects)
+		}
+	}
+}
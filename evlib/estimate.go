@@ -0,0 +1,104 @@
+package evlib
+
+import (
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"errors"
+	"time"
+)
+
+const (
+	EstimateWindow    = 20  // most recent block intervals used to estimate the next ones
+	EstimateSmoothing = 0.3 // weight of the newest interval in the exponential moving average
+)
+
+// ErrTooFewBlocks is returned by EstimateConfirmationTime before the chain has two blocks to time
+var ErrTooFewBlocks = errors.New("too few blocks to estimate the block interval")
+
+// ConfirmationEstimate is the progress of a txn towards the finality depth of the chain
+type ConfirmationEstimate struct {
+	NumConfirmed  int           // -1 if the txn is not on the longest chain yet
+	Required      int           // confirmations for the txn to be counted
+	BlocksLeft    int           // blocks still to be mined, including the one containing the txn if needed
+	BlockInterval time.Duration // estimated time between two blocks
+	Remaining     time.Duration // estimated time until the txn is counted. 0 once it is
+}
+
+// Done checks whether the txn has enough confirmations to be counted
+func (e ConfirmationEstimate) Done() bool {
+	return e.BlocksLeft == 0
+}
+
+// Progress is the fraction of the blocks the txn needs that are already mined, between 0 and 1
+func (e ConfirmationEstimate) Progress() float64 {
+	total := e.Required + 1
+	return float64(total-e.BlocksLeft) / float64(total)
+}
+
+// EstimateConfirmationTime estimates how long until TxID has FinalityDepth confirmations,
+// from an exponential moving average of the recent block intervals of the longest chain. Blocks are
+// modelled as arriving independently, so the time since the last block does not change the estimate.
+func (d *EV) EstimateConfirmationTime(TxID []byte) (ConfirmationEstimate, error) {
+	numConfirmed, err := d.GetBallotStatus(TxID)
+	if err != nil {
+		return ConfirmationEstimate{}, err
+	}
+	estimate := ConfirmationEstimate{NumConfirmed: numConfirmed, Required: d.FinalityDepth}
+	if numConfirmed < 0 {
+		estimate.BlocksLeft = d.FinalityDepth + 1
+	} else if numConfirmed < d.FinalityDepth {
+		estimate.BlocksLeft = d.FinalityDepth - numConfirmed
+	}
+
+	headers, err := d.recentHeaders()
+	if err != nil {
+		return estimate, err
+	}
+	estimate.BlockInterval, err = estimateBlockInterval(headers)
+	if err != nil {
+		return estimate, err
+	}
+	estimate.Remaining = time.Duration(estimate.BlocksLeft) * estimate.BlockInterval
+	return estimate, nil
+}
+
+// recentHeaders returns the headers of the longest chain, from the light client's headers if it has them
+func (d *EV) recentHeaders() ([]blockChain.BlockHeader, error) {
+	if d.LightClient {
+		d.hdrMu.RLock()
+		headers := d.headers
+		d.hdrMu.RUnlock()
+		if len(headers) > 0 {
+			return headers, nil
+		}
+	}
+	var reply blockvote.GetHeadersReply
+	d.connRw.RLock()
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetHeaders"), blockvote.GetHeadersArgs{}, &reply)
+	d.connRw.RUnlock()
+	if err != nil {
+		d.ComplainCoordChan <- 1
+		return nil, err
+	}
+	return reply.Headers, nil
+}
+
+// estimateBlockInterval averages the last EstimateWindow intervals between headers, weighting recent
+// ones exponentially more
+func estimateBlockInterval(headers []blockChain.BlockHeader) (time.Duration, error) {
+	if len(headers) > 0 && headers[0].BlockNum == 0 {
+		headers = headers[1:] // genesis is timestamped by its config, not by mining
+	}
+	if len(headers) > EstimateWindow+1 {
+		headers = headers[len(headers)-EstimateWindow-1:]
+	}
+	if len(headers) < 2 {
+		return 0, ErrTooFewBlocks
+	}
+	avg := float64(headers[1].Timestamp - headers[0].Timestamp)
+	for i := 2; i < len(headers); i++ {
+		interval := float64(headers[i].Timestamp - headers[i-1].Timestamp)
+		avg = EstimateSmoothing*interval + (1-EstimateSmoothing)*avg
+	}
+	return time.Duration(avg * float64(time.Second)), nil
+}
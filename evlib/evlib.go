@@ -2,16 +2,21 @@ package evlib
 
 import (
 	"bufio"
+	"bytes"
 	wallet "cs.ubc.ca/cpsc416/BlockVote/Identity"
 	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
 	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/keystore"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
 	"errors"
 	"fmt"
 	"github.com/DistributedClocks/tracing"
+	"io"
 	"log"
 	"math/rand"
 	"net/rpc"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +26,9 @@ type TxnInfo struct {
 	txn        blockChain.Transaction
 	submitTime time.Time
 	confirmed  bool
+	rejected   bool     // the txn can never be mined. it is not resubmitted
+	rejectedBy []string // miners that found the txn invalid, see EV.resubmit
+	trace      *tracing.Trace
 }
 
 type EV struct {
@@ -31,25 +39,112 @@ type EV struct {
 	//voterWallet      wallet.Wallets
 	//voterWalletAddr  string
 	CandidateList    []string
+	CandidateRaces   []string // race of each candidate in CandidateList. empty strings with a single race
+	candidates       []blockvote.CandidateInfo
 	minerIpPort      string
 	coordIPPort      string
 	localMinerIPPort string
 	localCoordIPPort string
 	coordClient      *rpc.Client
+	tracer           *tracing.Tracer
 	//minerClient      *rpc.Client
 	//VoterTxnInfoMap map[string]TxnInfo
 	//VoterTxnMap     map[string]blockChain.Transaction
 	TxnInfos      []TxnInfo
 	MinerAddrList []string
+	assignedMiner string                      // miner coord assigned to this client, tried before the others. guarded by rw
+	busyUntil     map[string]time.Time        // miners that reported busy and when to try them again. guarded by rw
+	latency       map[string]time.Duration    // moving average of the round-trip time of each miner. guarded by rw
+	breakers      map[string]*endpointBreaker // failing miners, see breaker.go. guarded by rw
 
 	ComplainCoordChan chan int // for all operations to complain about coord unavailability
 	ComplainMinerChan chan int // for all operations to complain about no miner available
+
+	ResubmitAfter     time.Duration // time before an unconfirmed txn is resubmitted
+	RetryInterval     time.Duration // time between two retries of a failed coord call
+	ReconnectInterval time.Duration // time between two attempts to reconnect to coord
+
+	Clock  util.Clock  // source of time for retries and resubmission. a util.FakeClock makes tests deterministic
+	Logger *log.Logger // where the instance logs to. the standard logger if nil
+	Rand   *rand.Rand  // used to pick miners. must be safe for concurrent use, see util.NewLockedRand
+
+	CollectStats bool      // record the latency and errors of every RPC type, see Metrics. set before Start
+	stats        *rpcStats // nil unless CollectStats
+
+	NReceives     int           // miners a ballot is sent to at once
+	AckQuorum     int           // miners that must accept a ballot before Vote returns. at most NReceives
+	QuorumTimeout time.Duration // how long Vote waits for AckQuorum miners before it fails with ErrNoQuorum. forever if 0
+
+	ProbeInterval time.Duration // time between two rounds of round-trip time probes of the miners
+	ExploreRate   float64       // share of ballots sent to a random miner instead of the fastest one
+
+	BreakerThreshold int           // failed calls in a row after which a miner is left alone. never if 0
+	BreakerCooldown  time.Duration // how long a failing miner is left alone before it is tried again
+
+	LightClient bool // verify ballot status with headers and Merkle proofs instead of trusting coord's answer
+
+	ReceiptDir string // a receipt of every cast ballot is written there. no receipts if empty
+
+	ResultsTTL    time.Duration // how long results from coord are reused by GetCandVotes and GetRaceResults
+	StrictResults bool          // results only count finalized ballots. every ballot on the longest chain counts if false
+	resMu         sync.Mutex
+	results       *Results // last results from coord. guarded by resMu
+
+	ElectionEnd   time.Time               // no ballots are accepted after it. the election never closes if zero
+	genesis       []byte                  // hash of the genesis block of the election, signed into every txn
+	sealingKey    []byte                  // public key of coord ballots are sealed to until the deadline. not sealed if empty
+	registration  blockChain.Registration // who may vote, see Register. anyone if empty
+	difficulty    uint8                   // leading zero bits of a block hash, from coord. blockchain.NumZeros if 0
+	FinalityDepth int                     // confirmations a ballot needs to be final and counted, from coord
+	ElectionID    string                  // election of coord the instance votes in. the default election if empty
+	ClientID      uint                    // ID the instance started with, sent with every call for per-client quotas. 0 if anonymous
+	MinerLabel    string                  // miners with this label are used if there are any. any miner if empty
+
+	StudentIDPattern *regexp.Regexp // student IDs must match it. DefaultStudentIDPattern if nil
+
+	KeystoreSocket string           // voter keys are kept and used by the keystore agent there. a wallet file per voter if empty
+	WalletDir      string           // directory of the voters' wallet files. ./tmp if empty
+	keystore       *keystore.Client // connection to the agent at KeystoreSocket
+
+	BridgeAddr string // HTTP CONNECT bridge coord and miners are reached through. connected directly if empty
+
+	DiscoveryCache  string                       // candidates and miners from coord are cached there, to start during a coord outage. no cache if empty
+	DiscoveryMaxAge time.Duration                // how old a cache may be to start from it
+	candidatesReply blockvote.GetCandidatesReply // as coord or the discovery cache told last. guarded by rw
+
+	IntentLog string     // signed txns are logged there before they are sent and cast again on restart until miners settle them. no log if empty
+	intents   *intentLog // the log at IntentLog
+
+	CandidateRefresh    time.Duration                                              // time between two checks of coord for a new candidate list
+	OnCandidatesChanged func(version uint64, candidates []blockvote.CandidateInfo) // called when coord hands out a new candidate list. may be nil
+
+	voterInfo   []VoterNameID               // guarded by ifRw
+	credentials map[string][]byte           // hex of a voter's public key -> its credential, see Register. guarded by ifRw
+	raceRules   map[string]wallet.Candidate // rules of each race, taken from any of its candidates
+	hdrMu       sync.RWMutex
+	headers     []blockChain.BlockHeader // validated longest chain, indexed by block number. guarded by hdrMu
+	quit        chan bool
 }
 
 func NewEV() *EV {
 	return &EV{
 		ComplainCoordChan: make(chan int, 1000),
 		ComplainMinerChan: make(chan int, 1000),
+		ResubmitAfter:     35 * time.Second,
+		RetryInterval:     2 * time.Second,
+		ReconnectInterval: 3 * time.Second,
+		ResultsTTL:        5 * time.Second,
+		DiscoveryMaxAge:   time.Hour,
+		CandidateRefresh:  30 * time.Second,
+		NReceives:         1,
+		AckQuorum:         1,
+		QuorumTimeout:     time.Minute,
+		ProbeInterval:     30 * time.Second,
+		ExploreRate:       0.1,
+		BreakerThreshold:  3,
+		BreakerCooldown:   30 * time.Second,
+		Clock:             util.RealClock,
+		Rand:              util.NewLockedRand(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
@@ -61,157 +156,414 @@ type VoterNameID struct {
 	voterWalletAddr string
 }
 
-var quit chan bool
-var voterInfo []VoterNameID
-var thread = 35 * time.Second
+// ErrElectionClosed is returned by Vote after the election deadline
+var ErrElectionClosed = blockvote.ErrElectionClosed
+
+// ErrAlreadyVoted is returned by Vote when the student ID already cast all the ballots the race allows
+var ErrAlreadyVoted = errors.New("voter has already voted in this race")
+
+// ErrTxnRejected is returned by Vote when a miner finds the ballot invalid, which no retry fixes
+var ErrTxnRejected = errors.New("txn is rejected by the miner")
+
+// ErrQuotaExceeded is returned by Vote when a miner already took all the ballots it takes from the client ID
+var ErrQuotaExceeded = errors.New("ballot quota of the client is used up")
+
+// ErrNotFound is returned when coord or a miner does not have the requested object
+var ErrNotFound = errors.New("not found")
+
+// ErrUnauthorized is returned when coord or a miner does not accept the key or signature of a request
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrWrongElection is returned when coord does not run the election of the instance
+var ErrWrongElection = errors.New("wrong election")
+
+// ErrNoQuorum is returned by Vote when fewer than AckQuorum miners accepted the ballot within QuorumTimeout,
+// e.g. because fewer miners are up. Miners that did accept it may still mine it, and the instance keeps
+// resubmitting it
+var ErrNoQuorum = errors.New("too few miners accepted the ballot in time")
+
+// ErrNotRegistered is returned by Vote in an election with a registrar for a voter that did not Register
+var ErrNotRegistered = blockChain.ErrNotRegistered
+
+// typedError maps the code of an RPC error to the errors of evlib, keeping the message of the remote node
+func typedError(err error) error {
+	switch blockvote.CodeOf(err) {
+	case blockvote.CodeElectionClosed:
+		return ErrElectionClosed
+	case blockvote.CodeNotFound:
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case blockvote.CodeUnauthorized:
+		return fmt.Errorf("%w: %v", ErrUnauthorized, err)
+	case blockvote.CodeWrongElection:
+		return fmt.Errorf("%w: %v", ErrWrongElection, err)
+	case blockvote.CodeQuotaExceeded:
+		return fmt.Errorf("%w: %v", ErrQuotaExceeded, err)
+	case blockvote.CodeAlreadyVoted:
+		return fmt.Errorf("%w: %v", ErrAlreadyVoted, err)
+	}
+	return err
+}
+
+// logger returns where the instance logs to, see Logger
+func (d *EV) logger() *log.Logger {
+	if d.Logger == nil {
+		return log.Default()
+	}
+	return d.Logger
+}
+
+// dial connects to coord or a miner at addr, through the bridge if there is one
+func (d *EV) dial(addr string) (*rpc.Client, error) {
+	return util.DialRPCVia(d.BridgeAddr, addr)
+}
 
 func (d *EV) connectCoord() {
-	// setup conn to coord
-	client, err := rpc.Dial("tcp", d.coordIPPort)
+	d.coordClient = d.dialCoord(d.coordIPPort)
+}
+
+// dialCoord connects to coord at coordIPPort, retrying until it answers
+func (d *EV) dialCoord(coordIPPort string) *rpc.Client {
+	client, err := d.dial(coordIPPort)
 	for err != nil {
-		time.Sleep(3 * time.Second)
-		client, err = rpc.Dial("tcp", d.coordIPPort)
+		d.Clock.Sleep(d.ReconnectInterval)
+		client, err = d.dial(coordIPPort)
 	}
-	d.coordClient = client
+	return client
 }
 
-func (d *EV) connectMiner() (conn *rpc.Client) {
-	// setup conn to miner
+// followStandby moves to the standby coord if the current coord is draining for maintenance. Called with
+// connRw held.
+func (d *EV) followStandby() {
+	if d.coordClient == nil {
+		return
+	}
+	var reply blockvote.GetStandbyReply
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetStandby"), blockvote.GetStandbyArgs{}, &reply)
+	if err == nil && reply.Draining && reply.Standby != "" && reply.Standby != d.coordIPPort {
+		d.logger().Println("[INFO] Coord is draining, moving to standby coord at", reply.Standby)
+		d.coordClient.Close()
+		d.coordIPPort = reply.Standby
+	}
+}
+
+// coordFailed waits before a failed call to coord is retried. A rate limited client waits as long as coord
+// asked, otherwise coord is reported to CoordConnManager and the call is retried after RetryInterval
+func (d *EV) coordFailed(err error) {
+	var rpcErr *blockvote.RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == blockvote.CodeBusy && rpcErr.RetryAfter > 0 {
+		d.Clock.Sleep(rpcErr.RetryAfter)
+		return
+	}
+	d.ComplainCoordChan <- 1
+	d.Clock.Sleep(d.RetryInterval)
+}
+
+func (d *EV) connectMiner() (conn *rpc.Client, minerIpPort string, err error) {
+	conns, addrs := d.connectMiners(1, nil, d.quorumDeadline())
+	if len(conns) == 0 {
+		return nil, "", fmt.Errorf("no miner is available after %v", d.QuorumTimeout)
+	}
+	return conns[0], addrs[0], nil
+}
+
+// quorumDeadline is when a call waiting for miners started now gives up, see QuorumTimeout. zero for never
+func (d *EV) quorumDeadline() time.Time {
+	if d.QuorumTimeout <= 0 {
+		return time.Time{}
+	}
+	return d.Clock.Now().Add(d.QuorumTimeout)
+}
+
+// connectMiners connects to up to n ready miners that are not in skip, waiting until there is at least one.
+// It returns none once deadline passes, unless deadline is zero
+func (d *EV) connectMiners(n int, skip map[string]bool, deadline time.Time) (conns []*rpc.Client, addrs []string) {
 	for {
+		if !deadline.IsZero() && d.Clock.Now().After(deadline) {
+			return nil, nil
+		}
 		d.rw.RLock()
-		minerList := d.MinerAddrList[:] // make a copy
+		available, wait := d.availableMiners()
 		d.rw.RUnlock()
+		var minerList []string
+		for _, addr := range available {
+			if !skip[addr] {
+				minerList = append(minerList, addr)
+			}
+		}
+		if len(minerList) == 0 && wait > 0 {
+			// every miner is busy or failing, wait for the first one to take txns again
+			d.logger().Printf("[WARN] All miners are busy, retrying in %v\n", wait)
+			d.Clock.Sleep(wait)
+			continue
+		}
 		if len(minerList) > 0 {
-			// randomly select a miner
-			minerIpPort := minerList[rand.New(rand.NewSource(time.Now().UnixNano())).Intn(len(minerList))]
-			// connect to it
-			rpcClient, err := rpc.Dial("tcp", minerIpPort)
-			if err != nil {
-				// remove failed miner
-				d.rw.Lock()
-				d.MinerAddrList = sliceMinerList(minerIpPort, d.MinerAddrList)
-				d.rw.Unlock()
-			} else {
-				conn = rpcClient
+			// use the assigned miner if there is one, otherwise prefer the fastest miners
+			d.rw.RLock()
+			picked := d.pickMiners(minerList, n)
+			d.rw.RUnlock()
+			for _, minerIpPort := range picked {
+				rpcClient, err := d.dial(minerIpPort)
+				if err != nil {
+					// remove failed miner. its breaker keeps it away if coord still lists it
+					d.callFailed(minerIpPort)
+					d.rw.Lock()
+					d.MinerAddrList = sliceMinerList(minerIpPort, d.MinerAddrList)
+					delete(d.latency, minerIpPort)
+					d.rw.Unlock()
+				} else if busy := d.minerNotReady(rpcClient); busy != nil {
+					// still starting or catching up, its txns would be turned away
+					rpcClient.Close()
+					d.backOff(minerIpPort, busy)
+				} else {
+					conns = append(conns, rpcClient)
+					addrs = append(addrs, minerIpPort)
+				}
+			}
+			if len(conns) > 0 {
 				return
 			}
 		} else {
 			// no available miners, retrieve latest list from coord
-			log.Println("[WARN] No miner available. Please wait...")
+			d.logger().Println("[WARN] No miner available. Please wait...")
 			d.ComplainMinerChan <- 1
-			time.Sleep(time.Second)
+			d.Clock.Sleep(time.Second)
 		}
 	}
 }
 
-// Start Starts the instance of EV to use for connecting to the system with the given coord's IP:port.
-func (d *EV) Start(localTracer *tracing.Tracer, clientId uint, coordIPPort string) error {
-	voterInfo = make([]VoterNameID, 0)
-	d.coordIPPort = coordIPPort
+// minerNotReady asks a miner for its health and returns a BusyError if it is not ready to take txns
+func (d *EV) minerNotReady(conn *rpc.Client) *blockvote.BusyError {
+	var health blockvote.HealthReply
+	if err := d.call(conn, "MinerAPIClient.Health", blockvote.HealthArgs{}, &health); err != nil || health.Ready {
+		// a failing call is noticed when submitting
+		return nil
+	}
+	return &blockvote.BusyError{Reason: health.Reason, RetryAfter: blockvote.SyncRetryAfter}
+}
 
-	// setup conn to coord
-	d.connectCoord()
+// getMinerList asks coord for the miners with MinerLabel, or for all miners if none has it. Called with connRw
+// held, except in Start
+func (d *EV) getMinerList() (*blockvote.GetMinerListReply, error) {
+	var reply blockvote.GetMinerListReply
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetMinerList"), blockvote.GetMinerListArgs{ClientID: d.ClientID, Label: d.MinerLabel}, &reply)
+	if err == nil && d.MinerLabel != "" && len(reply.MinerAddrList) == 0 {
+		d.logger().Printf("[WARN] No miner labeled %s, using any miner\n", d.MinerLabel)
+		reply = blockvote.GetMinerListReply{}
+		err = d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetMinerList"), blockvote.GetMinerListArgs{ClientID: d.ClientID}, &reply)
+	}
+	return &reply, err
+}
 
-	// get candidates from Coord
-	log.Println("[INFO] Retrieving candidates from coord...")
-	var candidatesReply *blockvote.GetCandidatesReply
-	for {
-		err := d.coordClient.Call("CoordAPIClient.GetCandidates", blockvote.GetCandidatesArgs{}, &candidatesReply)
-		if err == nil {
-			break
-		} else {
-			d.connectCoord()
+// availableMiners returns the miners that did not report busy and whose breaker is closed, or how long until
+// the first of them takes txns again if there are none. Called with rw held
+func (d *EV) availableMiners() (minerList []string, wait time.Duration) {
+	now := d.Clock.Now()
+	for _, addr := range d.MinerAddrList {
+		until := d.busyUntil[addr]
+		if openUntil, open := d.breakerOpen(addr, now); open && openUntil.After(until) {
+			until = openUntil
+		}
+		if !until.After(now) {
+			minerList = append(minerList, addr)
+		} else if wait == 0 || until.Sub(now) < wait {
+			wait = until.Sub(now)
 		}
 	}
+	return
+}
+
+// backOff keeps txns away from a busy miner for the time it suggested
+func (d *EV) backOff(minerIpPort string, busy *blockvote.BusyError) {
+	d.logger().Printf("[WARN] Miner %s is busy (%s), trying other miners for %v\n", minerIpPort, busy.Reason, busy.RetryAfter)
+	d.rw.Lock()
+	if d.busyUntil == nil {
+		d.busyUntil = make(map[string]time.Time)
+	}
+	d.busyUntil[minerIpPort] = d.Clock.Now().Add(busy.RetryAfter)
+	d.rw.Unlock()
+}
 
-	log.Println("[INFO] Retrieving miner list from coord...")
-	// no need to retry when failed.
-	var minerListReply *blockvote.GetMinerListReply
-	err := d.coordClient.Call("CoordAPIClient.GetMinerList", blockvote.GetMinerListArgs{}, &minerListReply)
+// StartWithConfig applies the retry policy in cfg and starts the EV instance
+func (d *EV) StartWithConfig(localTracer *tracing.Tracer, cfg *blockvote.ClientConfig) error {
+	d.ResubmitAfter = time.Duration(cfg.ResubmitAfter) * time.Second
+	d.RetryInterval = time.Duration(cfg.RetryInterval) * time.Second
+	d.ReconnectInterval = time.Duration(cfg.ReconnectInterval) * time.Second
+	d.LightClient = cfg.LightClient
+	d.ReceiptDir = cfg.ReceiptDir
+	d.ResultsTTL = 0
+	if cfg.ResultsTTL > 0 {
+		d.ResultsTTL = time.Duration(cfg.ResultsTTL) * time.Second
+	}
+	d.MinerLabel = cfg.MinerLabel
+	d.StrictResults = cfg.StrictResults
+	d.NReceives = cfg.N_Receives
+	d.AckQuorum = int(cfg.AckQuorum)
+	d.QuorumTimeout = time.Duration(cfg.QuorumTimeout) * time.Second
+	d.ProbeInterval = time.Duration(cfg.ProbeInterval) * time.Second
+	d.ExploreRate = 0
+	if cfg.ExploreRate > 0 {
+		d.ExploreRate = cfg.ExploreRate
+	}
+	d.BreakerThreshold = int(cfg.BreakerThreshold)
+	d.BreakerCooldown = time.Duration(cfg.BreakerCooldown) * time.Second
+	d.KeystoreSocket = cfg.KeystoreSocket
+	d.WalletDir = cfg.WalletDir
+	d.BridgeAddr = cfg.BridgeAddr
+	d.DiscoveryCache = cfg.DiscoveryCache
+	d.DiscoveryMaxAge = time.Duration(cfg.DiscoveryMaxAge) * time.Second
+	d.CandidateRefresh = time.Duration(cfg.CandidateRefresh) * time.Second
+	d.IntentLog = cfg.IntentLog
+	d.CollectStats = cfg.CollectStats
+	if cfg.StudentIDPattern != "" {
+		pattern, err := regexp.Compile(cfg.StudentIDPattern)
+		if err != nil {
+			return fmt.Errorf("StudentIDPattern: %v", err)
+		}
+		d.StudentIDPattern = pattern
+	}
+	if err := d.Start(localTracer, cfg.ClientID, cfg.CoordIPPort, cfg.ElectionID); err != nil {
+		return err
+	}
+	d.connRw.RLock()
+	reply, err := d.getMinerList()
+	d.connRw.RUnlock()
 	if err == nil {
-		d.MinerAddrList = minerListReply.MinerAddrList
+		if err = cfg.Preflight(len(reply.MinerAddrList)); err != nil {
+			d.logger().Printf("[WARN] Client config does not fit the cluster:\n%v\n", err)
+		}
 	}
+	return nil
+}
 
-	// print all candidates Name
-	canadiateName := make([]string, 0)
-	for _, cand := range candidatesReply.Candidates {
-		wallets := wallet.DecodeToWallets(cand)
-		canadiateName = append(canadiateName, wallets.CandidateData.CandidateName)
+// Start Starts the instance of EV to use for connecting to the system with the given coord's IP:port. All
+// queries and ballots are scoped to the given election of coord, "" for its default election.
+func (d *EV) Start(localTracer *tracing.Tracer, clientId uint, coordIPPort string, electionID string) error {
+	d.ClientID = clientId
+	d.ElectionID = electionID
+	if d.CollectStats && d.stats == nil {
+		d.stats = newRPCStats()
+	}
+	d.voterInfo = make([]VoterNameID, 0)
+	d.coordIPPort = coordIPPort
+	d.tracer = localTracer
+	if d.KeystoreSocket != "" {
+		client, err := keystore.Dial(d.KeystoreSocket)
+		if err != nil {
+			return fmt.Errorf("cannot connect to keystore agent: %v", err)
+		}
+		d.keystore = client
+	}
+	if d.IntentLog != "" {
+		intents, err := openIntentLog(d.IntentLog, d.logger())
+		if err != nil {
+			return fmt.Errorf("cannot open intent log: %v", err)
+		}
+		d.intents = intents
 	}
-	d.CandidateList = canadiateName
-	log.Println("List of candidate:", canadiateName)
+
+	// setup conn to coord. if coord is down and what it told the last time is cached, start from the cache
+	// and connect in the background
+	var candidatesReply blockvote.GetCandidatesReply
+	cache := d.loadDiscoveryCache()
+	if client, err := d.dial(d.coordIPPort); err == nil {
+		d.coordClient = client
+	} else if cache == nil {
+		d.connectCoord()
+	}
+	if d.coordClient == nil {
+		d.logger().Printf("[WARN] Coord is unreachable, starting with the candidates and %d miners cached at %v\n",
+			len(cache.MinerAddrList), cache.SavedAt.Format(time.RFC3339))
+		candidatesReply = cache.Candidates
+		d.MinerAddrList = cache.MinerAddrList
+		d.assignedMiner = cache.Assigned
+	} else {
+		// get candidates from Coord
+		d.logger().Println("[INFO] Retrieving candidates from coord...")
+		for {
+			err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetCandidates"), blockvote.GetCandidatesArgs{}, &candidatesReply)
+			if err == nil {
+				break
+			} else if blockvote.CodeOf(err) == blockvote.CodeWrongElection {
+				return typedError(err)
+			} else {
+				d.followStandby()
+				d.connectCoord()
+			}
+		}
+
+		d.logger().Println("[INFO] Retrieving miner list from coord...")
+		// no need to retry when failed.
+		minerListReply, err := d.getMinerList()
+		if err == nil {
+			d.MinerAddrList = minerListReply.MinerAddrList
+			d.assignedMiner = minerListReply.Assigned
+		}
+	}
+	d.applyCandidates(candidatesReply)
 
 	// Start internal services
 	go d.CoordConnManager()
 	go d.MinerListManager()
+	if d.coordClient == nil {
+		d.ComplainCoordChan <- 1
+	} else {
+		d.saveDiscoveryCache()
+	}
 
-	quit = make(chan bool)
+	d.quit = make(chan bool)
+	go d.ReorgWatcher()
+	go d.LatencyProber()
+	go d.CandidateWatcher()
+	if d.intents != nil {
+		go d.resubmitIntents()
+	}
 	go func() {
 		// call coord for list of active miners with length N_Receives
 		for {
-			//var minerListReply *blockvote.GetMinerListReply
-			//for {
-			//	d.connRw.RLock()
-			//	err := d.coordClient.Call("CoordAPIClient.GetMinerList", blockvote.GetMinerListArgs{}, &minerListReply)
-			//	d.connRw.RUnlock()
-			//	if err == nil && len(minerListReply.MinerAddrList) > 0 {
-			//		break
-			//	} else if err != nil {
-			//		d.ComplainCoordChan <- 1
-			//		time.Sleep(2 * time.Second)
-			//	} else {
-			//		log.Println("[WARN] No available miner. Waiting...")
-			//		time.Sleep(5 * time.Second)
-			//	}
-			//}
-			//
-			//// random pick one miner addr
-			//index := 0
-			//d.MinerAddrList = minerListReply.MinerAddrList
-			//if len(d.MinerAddrList) > 1 {
-			//	index = rand.Intn(len(d.MinerAddrList) - 1)
-			//}
-			//d.minerIpPort = d.MinerAddrList[index]
-
 			d.rw.RLock()
 			allTxns := d.TxnInfos[:]
 			d.rw.RUnlock()
 
+			// query the status of every overdue txn at once
+			var due []int
+			var txids [][]byte
 			for idx, txnInfo := range allTxns {
-				if !txnInfo.confirmed && time.Now().Sub(txnInfo.submitTime) > thread {
-					// start query status
-					var queryTxnReply *blockvote.QueryTxnReply
-					d.connRw.RLock()
-					err = d.coordClient.Call("CoordAPIClient.QueryTxn", blockvote.QueryTxnArgs{
-						TxID: txnInfo.txn.ID,
-					}, &queryTxnReply)
-					d.connRw.RUnlock()
-					if err == nil {
-						if queryTxnReply.NumConfirmed > -1 {
-							d.rw.Lock()
-							d.TxnInfos[idx].confirmed = true // we can do this b.c. TxnInfos is append only
-							d.rw.Unlock()
-						} else {
-							//log.Printf("[INFO] Resubmitting %x", txnInfo.txn.ID)
-							d.submitTxn(txnInfo.txn)
-							d.rw.Lock()
-							d.TxnInfos[idx].submitTime = time.Now() // we can do this b.c. TxnInfos is append only
-							d.rw.Unlock()
-						}
-					} else {
-						// try again in the next cycle!
-						d.ComplainCoordChan <- 1
-					}
+				if !txnInfo.confirmed && !txnInfo.rejected && d.Clock.Now().Sub(txnInfo.submitTime) > d.ResubmitAfter {
+					due = append(due, idx)
+					txids = append(txids, txnInfo.txn.ID)
+				}
+			}
+			statuses, err := d.queryTxns(txids)
+			if err != nil {
+				// try again in the next cycle!
+				if blockvote.CodeOf(err) != blockvote.CodeBusy {
+					d.ComplainCoordChan <- 1
+				}
+				statuses = nil
+			}
+			for i, status := range statuses {
+				idx := due[i]
+				txnInfo := allTxns[idx]
+				if status.NumConfirmed > -1 {
+					blockvote.RecordAction(txnInfo.trace, blockvote.TxnConfirmed{
+						TxID:         txnInfo.txn.ID,
+						NumConfirmed: status.NumConfirmed,
+					})
+					d.rw.Lock()
+					d.TxnInfos[idx].confirmed = true // we can do this b.c. TxnInfos is append only
+					d.rw.Unlock()
+				} else {
+					d.resubmit(idx)
 				}
 			}
 
 			select {
-			case <-quit:
+			case <-d.quit:
 				// end
 				return
 			default:
 				// Do other stuff
-				time.Sleep(10 * time.Second)
+				d.Clock.Sleep(10 * time.Second)
 			}
 		}
 	}()
@@ -223,10 +575,20 @@ func (d *EV) CoordConnManager() {
 		select {
 		case <-d.ComplainCoordChan:
 			{
+				d.logger().Println("[INFO] Reconnecting to coord...")
 				d.connRw.Lock()
-				log.Println("[INFO] Reconnecting to coord...")
-				d.connectCoord()
+				d.followStandby()
+				coordIPPort := d.coordIPPort
 				d.connRw.Unlock()
+				// calls on the broken connection fail right away meanwhile, instead of waiting for coord
+				client := d.dialCoord(coordIPPort)
+				d.connRw.Lock()
+				old := d.coordClient
+				d.coordClient = client
+				d.connRw.Unlock()
+				if old != nil {
+					old.Close()
+				}
 				// digest remaining complains
 				for {
 					select {
@@ -238,8 +600,6 @@ func (d *EV) CoordConnManager() {
 					break
 				}
 			}
-		default:
-			continue
 		}
 	}
 }
@@ -249,22 +609,22 @@ func (d *EV) MinerListManager() {
 		select {
 		case <-d.ComplainMinerChan:
 			{
-				log.Println("[INFO] Retrieving miner list from coord...")
-				var minerListReply *blockvote.GetMinerListReply
+				d.logger().Println("[INFO] Retrieving miner list from coord...")
 				for {
 					// retrieve miner list
 					d.connRw.RLock()
-					err := d.coordClient.Call("CoordAPIClient.GetMinerList", blockvote.GetMinerListArgs{}, &minerListReply)
+					minerListReply, err := d.getMinerList()
 					d.connRw.RUnlock()
 					if err == nil {
 						d.rw.Lock()
 						d.MinerAddrList = minerListReply.MinerAddrList
+						d.assignedMiner = minerListReply.Assigned
 						d.rw.Unlock()
+						d.saveDiscoveryCache()
 						break
 					} else {
 						// coord failed, complain about it and wait
-						d.ComplainCoordChan <- 1
-						time.Sleep(2 * time.Second)
+						d.coordFailed(err)
 					}
 				}
 				// digest remaining complains
@@ -278,9 +638,113 @@ func (d *EV) MinerListManager() {
 					break
 				}
 			}
+		}
+	}
+}
+
+// ReorgWatcher resubmits txns as soon as coord reports them dropped from the longest chain by a fork
+// switch, instead of waiting for the status polling to notice
+func (d *EV) ReorgWatcher() {
+	// a connection of its own, WaitReorg calls are held by coord for a while
+	var client *rpc.Client
+	var seq uint64
+	for {
+		select {
+		case <-d.quit:
+			if client != nil {
+				client.Close()
+			}
+			return
 		default:
+		}
+		if client == nil {
+			var err error
+			d.connRw.RLock()
+			coordIPPort := d.coordIPPort
+			d.connRw.RUnlock()
+			client, err = d.dial(coordIPPort)
+			if err != nil {
+				client = nil
+				d.Clock.Sleep(d.ReconnectInterval)
+				continue
+			}
+		}
+
+		d.rw.RLock()
+		txids := make([][]byte, 0, len(d.TxnInfos))
+		for _, txnInfo := range d.TxnInfos {
+			txids = append(txids, txnInfo.txn.ID)
+		}
+		d.rw.RUnlock()
+
+		var reply blockvote.WaitReorgReply
+		err := blockvote.Call(client, blockvote.Scoped(d.ElectionID, "CoordAPIClient.WaitReorg"), blockvote.WaitReorgArgs{Since: seq, TxIDs: txids}, &reply)
+		if code := blockvote.CodeOf(err); code == blockvote.CodeUnavailable {
+			// CoordConnManager moves to the standby coord, reorgs are asked there from the start
+			client.Close()
+			client = nil
+			seq = 0
+			d.recheckAll()
+			d.ComplainCoordChan <- 1
+			d.Clock.Sleep(d.ReconnectInterval)
+			continue
+		} else if code == blockvote.CodeNotFound || code == blockvote.CodeWrongElection {
+			d.logger().Println("[WARN] Coord does not report reorgs, relying on status polling:", err)
+			client.Close()
+			return
+		} else if err != nil {
+			client.Close()
+			client = nil
+			d.Clock.Sleep(d.ReconnectInterval)
+			continue
+		}
+
+		d.rw.RLock()
+		grown := len(d.TxnInfos) > len(txids)
+		d.rw.RUnlock()
+		if grown && reply.Seq != seq {
+			// ask again about the same reorgs, including txns submitted during the call
+			continue
+		}
+		if seq != 0 && reply.Missed {
+			d.recheckAll()
+		}
+		d.resubmitDropped(reply.Invalidated)
+		seq = reply.Seq
+	}
+}
+
+// resubmitDropped resubmits the txns with the given ids right away
+func (d *EV) resubmitDropped(txids [][]byte) {
+	for _, txid := range txids {
+		d.rw.RLock()
+		idx := -1
+		for i, txnInfo := range d.TxnInfos {
+			if bytes.Equal(txnInfo.txn.ID, txid) {
+				idx = i
+				break
+			}
+		}
+		d.rw.RUnlock()
+		if idx < 0 {
 			continue
 		}
+		d.logger().Printf("[INFO] Txn %x was dropped by a fork switch, resubmitting\n", txid)
+		d.rw.Lock()
+		d.TxnInfos[idx].confirmed = false // we can do this b.c. TxnInfos is append only
+		d.rw.Unlock()
+		d.resubmit(idx)
+	}
+}
+
+// recheckAll makes the status polling query every txn again in its next cycle
+func (d *EV) recheckAll() {
+	d.logger().Println("[INFO] Missed fork switches on coord, rechecking all txns")
+	d.rw.Lock()
+	defer d.rw.Unlock()
+	for idx := range d.TxnInfos {
+		d.TxnInfos[idx].confirmed = false
+		d.TxnInfos[idx].submitTime = time.Time{}
 	}
 }
 
@@ -288,7 +752,7 @@ func (d *EV) MinerListManager() {
 func (d *EV) findVoterExist(from, to string) bool {
 	d.ifRw.RLock()
 	defer d.ifRw.RUnlock()
-	for _, v := range voterInfo {
+	for _, v := range d.voterInfo {
 		if v.Name == from && v.ID == to {
 			return true
 		}
@@ -307,167 +771,891 @@ func sliceMinerList(mAddr string, minerList []string) []string {
 	return minerList
 }
 
-// Vote API provides the functionality of voting
-func (d *EV) Vote(ballot blockChain.Ballot) []byte {
-	// create wallet for voter, only when such voter is not exist
-	if !d.findVoterExist(ballot.VoterName, ballot.VoterStudentID) {
-		d.ifRw.Lock()
-		voterWallet, addr := d.createVoterWallet(ballot)
-		voterInfo = append(voterInfo, VoterNameID{
-			Name:            ballot.VoterName,
-			ID:              ballot.VoterStudentID,
-			voterWallet:     *voterWallet,
-			voterWalletAddr: addr,
-		})
-		d.ifRw.Unlock()
-		//log.Println(voterInfo)
+func containsAddr(minerList []string, mAddr string) bool {
+	for _, v := range minerList {
+		if mAddr == v {
+			return true
+		}
 	}
+	return false
+}
 
-	// create transaction
-	txn, err := d.createTransaction(ballot)
+// Vote API provides the functionality of voting. It returns the txn ID of the ballot, or
+// ErrElectionClosed once the election is over.
+func (d *EV) Vote(ballot blockChain.Ballot) ([]byte, error) {
+	if d.Closed() {
+		return nil, ErrElectionClosed
+	}
+	ballot = CanonicalBallot(ballot)
+	if err := d.ValidateBallot(ballot); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBallot, err)
+	}
+	if d.hasVoted(ballot) {
+		return nil, ErrAlreadyVoted
+	}
+	trace := blockvote.CreateTrace(d.tracer)
+	txn, err := d.buildTxn(ballot, trace)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+	return d.castTxn(txn, trace)
+}
 
-	var submitTxnReply *blockvote.SubmitTxnReply
-	for {
-		// connect to miner
-		conn := d.connectMiner()
-		err := conn.Call("MinerAPIClient.SubmitTxn", blockvote.SubmitTxnArgs{Txn: txn}, &submitTxnReply)
-		conn.Close()
+// buildTxn creates the wallet of the voter if needed, seals the ballot if coord asks for it and signs it
+func (d *EV) buildTxn(ballot blockChain.Ballot, trace *tracing.Trace) (blockChain.Transaction, error) {
+	if err := d.ensureVoterWallet(ballot, trace); err != nil {
+		return blockChain.Transaction{}, err
+	}
+
+	// seal the choice, nobody can count it before coord releases the key
+	if len(d.sealingKey) > 0 {
+		sealed, err := blockChain.SealBallot(d.sealingKey, &ballot)
 		if err == nil {
-			d.rw.Lock()
-			d.TxnInfos = append(d.TxnInfos, TxnInfo{
-				txn:        txn,
-				submitTime: time.Now(),
-				confirmed:  false,
-			})
-			d.rw.Unlock()
-			break
+			// the sealed choice is longer than the plain one ValidateBallot checked
+			err = d.checkSealed(*sealed)
+		}
+		if errors.Is(err, blockChain.ErrTxnTooLarge) || errors.Is(err, blockChain.ErrNonCanonicalTxn) {
+			return blockChain.Transaction{}, fmt.Errorf("%w: sealed: %v", ErrInvalidBallot, err)
+		} else if err != nil {
+			return blockChain.Transaction{}, err
+		}
+		ballot = *sealed
+	}
+
+	// create transaction
+	return d.createTransaction(ballot, trace)
+}
+
+// castTxn sends a signed txn to miners, keeps track of it until it is confirmed and writes its receipt. With
+// an IntentLog, the txn is logged first, so that a crash before miners answer does not lose it. A txn short of
+// a quorum is kept track of and resubmitted too, and its ID is returned with ErrNoQuorum
+func (d *EV) castTxn(txn blockChain.Transaction, trace *tracing.Trace) ([]byte, error) {
+	if d.intents != nil {
+		if err := d.intents.submit(txn); err != nil {
+			return nil, fmt.Errorf("cannot log txn before sending it: %v", err)
+		}
+	}
+	acked, _, err := d.sendTxn(txn, trace, nil)
+	noQuorum := errors.Is(err, ErrNoQuorum)
+	if d.intents != nil && !noQuorum {
+		// sendTxn only gives up on a txn miners reject, which no resubmission fixes
+		d.intents.done(txn.ID)
+	}
+	if err != nil && !noQuorum {
+		return nil, err
+	}
+	submitTime := d.Clock.Now()
+	d.rw.Lock()
+	d.TxnInfos = append(d.TxnInfos, TxnInfo{
+		txn:        txn,
+		submitTime: submitTime,
+		confirmed:  false,
+		trace:      trace,
+	})
+	minerList := d.MinerAddrList[:]
+	d.rw.Unlock()
+	if d.ReceiptDir != "" {
+		path, err := NewReceipt(txn, submitTime, acked, minerList).Save(d.ReceiptDir)
+		if err != nil {
+			d.logger().Printf("[WARN] Unable to write the receipt of txn %x: %v\n", txn.ID, err)
 		} else {
-			log.Println("[WARN] Fail in SubmitTxn, retrying...")
+			d.logger().Println("[INFO] Receipt written to", path)
 		}
 	}
-	return txn.ID
+	return txn.ID, err
 }
 
-func (d *EV) submitTxn(txn blockChain.Transaction) {
+// Closed checks whether the election deadline has passed
+func (d *EV) Closed() bool {
+	d.rw.RLock()
+	end := d.ElectionEnd
+	d.rw.RUnlock()
+	return !end.IsZero() && d.Clock.Now().After(end)
+}
 
-	var submitTxnReply *blockvote.SubmitTxnReply
-	for {
-		// setup conn to miner
-		conn := d.connectMiner()
-		err := conn.Call("MinerAPIClient.SubmitTxn", blockvote.SubmitTxnArgs{Txn: txn}, &submitTxnReply)
-		conn.Close()
-		if err == nil {
-			break
-		} else {
-			log.Println("[WARN] Fail in SubmitTxn, retrying...")
+// rejected checks the code of SubmitTxn for a txn the miner refused, which no retry fixes. A duplicate is
+// already pending or mined, so it is as good as accepted
+func rejected(code blockvote.ErrorCode) bool {
+	return code == blockvote.CodeInvalid || code == blockvote.CodeUnauthorized || code == blockvote.CodeWrongElection ||
+		code == blockvote.CodeAlreadyVoted
+}
+
+// rejection is the error of a txn a miner refused with err: ErrAlreadyVoted if the voter cannot cast another
+// ballot in the race, ErrTxnRejected otherwise
+func rejection(err error) error {
+	if blockvote.CodeOf(err) == blockvote.CodeAlreadyVoted {
+		return fmt.Errorf("%w: %v", ErrAlreadyVoted, err)
+	}
+	return fmt.Errorf("%w: %v", ErrTxnRejected, err)
+}
+
+// resubmit sends the txn of TxnInfos[idx] again, skipping the miners that rejected it before. A miner that is
+// behind or on another fork may reject a valid txn, so it is given up only once most miners rejected it, or
+// when the election is closed or the miners take no more ballots from the client
+func (d *EV) resubmit(idx int) {
+	d.rw.RLock()
+	txnInfo := d.TxnInfos[idx]
+	d.rw.RUnlock()
+	_, refusedBy, err := d.sendTxn(txnInfo.txn, txnInfo.trace, txnInfo.rejectedBy)
+	d.rw.Lock()
+	defer d.rw.Unlock()
+	info := &d.TxnInfos[idx] // we can do this b.c. TxnInfos is append only
+	info.submitTime = d.Clock.Now()
+	if refusedBy != "" {
+		info.rejectedBy = append(info.rejectedBy, refusedBy)
+	} else if err != nil && !errors.Is(err, ErrNoQuorum) {
+		d.logger().Printf("[WARN] Txn %x is not resubmitted: %v\n", txnInfo.txn.ID, err)
+		info.rejected = true
+	}
+	if len(info.rejectedBy) > len(d.MinerAddrList)/2 {
+		d.logger().Printf("[WARN] Txn %x is rejected by %d miners, it is not resubmitted\n", txnInfo.txn.ID, len(info.rejectedBy))
+		info.rejected = true
+	}
+}
+
+// sendTxn submits txn to NReceives miners at once, and to others in later rounds, until AckQuorum of them
+// accepted it. Miners in skip are not asked. It returns the miners that accepted it, and fails without retrying
+// when a miner rejects the txn, returning that miner, or when the election is closed, and with ErrNoQuorum when
+// QuorumTimeout passes first
+func (d *EV) sendTxn(txn blockChain.Transaction, trace *tracing.Trace, skip []string) (acked []string, refusedBy string, err error) {
+	deadline := d.quorumDeadline()
+	quorum := d.AckQuorum
+	if quorum < 1 {
+		quorum = 1
+	}
+	fanOut := d.NReceives
+	if fanOut < quorum {
+		fanOut = quorum
+	}
+	skipped := make(map[string]bool)
+	for _, addr := range skip {
+		skipped[addr] = true
+	}
+	for len(acked) < quorum {
+		conns, minerAddrs := d.connectMiners(fanOut-len(acked), skipped, deadline)
+		if len(conns) == 0 {
+			d.logger().Printf("[WARN] Txn %x is accepted by %d of %d miners needed after %v\n", txn.ID, len(acked), quorum, d.QuorumTimeout)
+			return acked, "", fmt.Errorf("%w: %d of %d miners", ErrNoQuorum, len(acked), quorum)
+		}
+		replies := make([]blockvote.SubmitTxnReply, len(conns))
+		errs := make([]error, len(conns))
+		var wg sync.WaitGroup
+		for i := range conns {
+			blockvote.RecordAction(trace, blockvote.TxnSubmitted{TxID: txn.ID, MinerAddr: minerAddrs[i]})
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = d.call(conns[i], "MinerAPIClient.SubmitTxn", blockvote.SubmitTxnArgs{
+					Txn:      txn,
+					Token:    blockvote.GenerateToken(trace),
+					ClientID: d.ClientID,
+				}, &replies[i])
+				conns[i].Close()
+			}(i)
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			d.recordCall(minerAddrs[i], err)
+			code := blockvote.CodeOf(err)
+			if rejected(code) {
+				d.logger().Printf("[WARN] Txn %x is rejected by miner %s at block #%d: %v\n", txn.ID, minerAddrs[i], replies[i].Height, err)
+				return acked, minerAddrs[i], rejection(err)
+			} else if err == nil || code == blockvote.CodeDuplicate {
+				acked = append(acked, minerAddrs[i])
+				skipped[minerAddrs[i]] = true
+			} else if code == blockvote.CodeElectionClosed {
+				return acked, "", ErrElectionClosed
+			} else if code == blockvote.CodeQuotaExceeded {
+				d.logger().Printf("[WARN] Miner %s takes no more ballots from client ID %d\n", minerAddrs[i], d.ClientID)
+				return acked, "", typedError(err)
+			} else if busy, ok := blockvote.ParseBusyError(err); ok {
+				d.backOff(minerAddrs[i], busy)
+			} else {
+				d.logger().Printf("[WARN] Fail in SubmitTxn to miner %s, retrying...\n", minerAddrs[i])
+			}
+		}
+		if len(acked) < quorum {
+			d.logger().Printf("[INFO] Txn %x is accepted by %d of %d miners needed\n", txn.ID, len(acked), quorum)
 		}
 	}
+	return acked, "", nil
 }
 
 // GetBallotStatus API checks the status of a transaction and returns the number of blocks that confirm it
 func (d *EV) GetBallotStatus(TxID []byte) (int, error) {
+	numConfirmed, _, err := d.GetBallotFinality(TxID)
+	return numConfirmed, err
+}
+
+// GetBallotFinality API is GetBallotStatus that also tells whether the ballot has FinalityDepth confirmations
+// and is final
+func (d *EV) GetBallotFinality(TxID []byte) (numConfirmed int, finalized bool, err error) {
+	if d.LightClient {
+		numConfirmed, err = d.lightBallotStatus(TxID)
+		return numConfirmed, numConfirmed >= d.FinalityDepth, err
+	}
 	//retry := 0
-	var queryTxnReply *blockvote.QueryTxnReply
+	var queryTxnReply blockvote.QueryTxnReply
 	for {
 		d.connRw.RLock()
-		err := d.coordClient.Call("CoordAPIClient.QueryTxn", blockvote.QueryTxnArgs{
-			TxID: TxID,
+		err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryTxn"), blockvote.QueryTxnArgs{
+			ClientID: d.ClientID,
+			TxID:     TxID,
 		}, &queryTxnReply)
 		d.connRw.RUnlock()
 		if err == nil {
 			break
 		} else {
-			d.ComplainCoordChan <- 1
-			time.Sleep(2 * time.Second)
+			d.coordFailed(err)
 		}
 	}
-	return queryTxnReply.NumConfirmed, nil
+	return queryTxnReply.NumConfirmed, queryTxnReply.Finalized, nil
 }
 
-// GetCandVotes API retrieve the number of votes a candidate has.
-func (d *EV) GetCandVotes(candidate string) (uint, error) {
-	if len(d.CandidateList) == 0 {
-		return 0, errors.New("Empty Candidates.\n")
+// GetBallotFinalities API is GetBallotFinality for many transactions, asking coord about up to
+// blockvote.MaxQueryTxns of them in one call
+func (d *EV) GetBallotFinalities(txids [][]byte) (numConfirmed []int, finalized []bool, err error) {
+	if d.LightClient {
+		for _, txid := range txids {
+			n, final, err := d.GetBallotFinality(txid)
+			if err != nil {
+				return nil, nil, err
+			}
+			numConfirmed, finalized = append(numConfirmed, n), append(finalized, final)
+		}
+		return numConfirmed, finalized, nil
+	}
+	var statuses []blockvote.TxnStatus
+	for {
+		statuses, err = d.queryTxns(txids)
+		if err == nil {
+			break
+		}
+		d.coordFailed(err)
+	}
+	for _, status := range statuses {
+		numConfirmed = append(numConfirmed, status.NumConfirmed)
+		finalized = append(finalized, status.Finalized)
+	}
+	return numConfirmed, finalized, nil
+}
+
+// queryTxns asks coord for the status of txns, blockvote.MaxQueryTxns at a time. A coord without QueryTxns is
+// asked about each txn with QueryTxn
+func (d *EV) queryTxns(txids [][]byte) ([]blockvote.TxnStatus, error) {
+	statuses := make([]blockvote.TxnStatus, 0, len(txids))
+	for start := 0; start < len(txids); start += blockvote.MaxQueryTxns {
+		end := start + blockvote.MaxQueryTxns
+		if end > len(txids) {
+			end = len(txids)
+		}
+		var reply blockvote.QueryTxnsReply
+		d.connRw.RLock()
+		err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryTxns"), blockvote.QueryTxnsArgs{
+			ClientID: d.ClientID,
+			TxIDs:    txids[start:end],
+		}, &reply)
+		d.connRw.RUnlock()
+		if blockvote.CodeOf(err) == blockvote.CodeNotFound {
+			for _, txid := range txids[start:end] {
+				var single blockvote.QueryTxnReply
+				d.connRw.RLock()
+				err = d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryTxn"), blockvote.QueryTxnArgs{
+					ClientID: d.ClientID,
+					TxID:     txid,
+				}, &single)
+				d.connRw.RUnlock()
+				if err != nil {
+					return nil, err
+				}
+				reply.Statuses = append(reply.Statuses, blockvote.TxnStatus{NumConfirmed: single.NumConfirmed, Finalized: single.Finalized})
+			}
+		} else if err != nil {
+			return nil, err
+		} else if len(reply.Statuses) != end-start {
+			return nil, fmt.Errorf("coord returned %d statuses for %d txns", len(reply.Statuses), end-start)
+		}
+		statuses = append(statuses, reply.Statuses...)
+	}
+	return statuses, nil
+}
+
+// CheckVoterStatus API returns the ballots a voter cast with its key on the longest chain, from any client. Coord
+// only answers requests signed by the voter key, so there are none if this client has no key of the voter
+func (d *EV) CheckVoterStatus(voterName string, voterStudentID string) ([]blockvote.VoterBallot, error) {
+	args, ok, err := d.voterStatusArgs(blockChain.Ballot{VoterName: voterName, VoterStudentID: voterStudentID})
+	if err != nil || !ok {
+		return nil, err
+	}
+	var reply blockvote.CheckVoterStatusReply
+	d.connRw.RLock()
+	err = d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.CheckVoterStatus"), args, &reply)
+	d.connRw.RUnlock()
+	return reply.Ballots, typedError(err)
+}
+
+// voterStatusArgs returns a CheckVoterStatus request for the voter of ballot, signed by its key. ok is false if
+// this client has no key of the voter
+func (d *EV) voterStatusArgs(ballot blockChain.Ballot) (args blockvote.CheckVoterStatusArgs, ok bool, err error) {
+	args = blockvote.CheckVoterStatusArgs{StudentID: ballot.VoterStudentID, SentAt: time.Now()}
+	d.rw.RLock()
+	genesis := d.genesis
+	d.rw.RUnlock()
+	if d.keystore != nil {
+		key := keystore.KeyID{Election: d.ElectionID, VoterName: ballot.VoterName, VoterStudentID: ballot.VoterStudentID}
+		if args.PublicKey, err = d.keystore.PublicKey(key, false); err != nil || args.PublicKey == nil {
+			return args, false, err
+		}
+		digest := blockvote.VoterStatusDigest(genesis, args.StudentID, args.PublicKey, args.SentAt)
+		if args.Signature, err = d.keystore.Sign(key, digest); err != nil {
+			return args, false, fmt.Errorf("keystore: %v", err)
+		}
+		return args, true, nil
+	}
+	voterWallet, addr := d.findWalletAndAddr(ballot)
+	if addr == "" {
+		return args, false, nil
+	}
+	args.PublicKey = voterWallet.Wallets[addr].PublicKey
+	digest := blockvote.VoterStatusDigest(genesis, args.StudentID, args.PublicKey, args.SentAt)
+	if args.Signature, err = voterWallet.Wallets[addr].Sign(digest); err != nil {
+		return args, false, err
+	}
+	return args, true, nil
+}
+
+// QueryTxnsByCandidate API returns a page of the ballots counted toward a candidate on the longest chain and
+// the number of them, for recounts. race is empty in an election with a single race
+func (d *EV) QueryTxnsByCandidate(race string, candidate string, offset int, limit int) ([]blockvote.CandidateBallot, int, error) {
+	var reply blockvote.QueryTxnsByCandidateReply
+	d.connRw.RLock()
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryTxnsByCandidate"), blockvote.QueryTxnsByCandidateArgs{
+		Race:      race,
+		Candidate: candidate,
+		Offset:    offset,
+		Limit:     limit,
+	}, &reply)
+	d.connRw.RUnlock()
+	return reply.Ballots, reply.Total, typedError(err)
+}
+
+// hasVoted checks with coord's voter index whether the voter of ballot cannot cast another ballot in its race
+// with its key on this client. Miners have the final say, so the ballot is let through if coord cannot tell.
+func (d *EV) hasVoted(ballot blockChain.Ballot) bool {
+	cast, err := d.CheckVoterStatus(ballot.VoterName, ballot.VoterStudentID)
+	if err != nil {
+		d.logger().Println("[WARN] Unable to check voter status:", err)
+		return false
+	}
+	rules, _ := d.rules(ballot.Race)
+	maxVotes := int(rules.MaxVotes)
+	if maxVotes == 0 {
+		maxVotes = 1
+	}
+	count := 0
+	for _, past := range cast {
+		if past.Race != ballot.Race {
+			continue
+		}
+		if past.Type == blockChain.BallotAbstain || ballot.Type == blockChain.BallotAbstain {
+			return true
+		}
+		count++
+	}
+	return count >= maxVotes
+}
+
+// MyBallots API returns all ballots of a voter on the longest chain with their confirmations, newest first.
+// The voter's key is read from its wallet file or the keystore agent, so this works for ballots cast before
+// the client restarted.
+func (d *EV) MyBallots(voterName string, voterStudentID string) ([]blockvote.VoterTxn, error) {
+	var publicKeys [][]byte
+	if d.keystore != nil {
+		publicKey, err := d.keystore.PublicKey(keystore.KeyID{Election: d.ElectionID, VoterName: voterName, VoterStudentID: voterStudentID}, false)
+		if err != nil {
+			return nil, err
+		}
+		if publicKey == nil {
+			return nil, nil // never voted with this agent
+		}
+		publicKeys = append(publicKeys, publicKey)
+	} else {
+		voterWallet := wallet.Wallets{
+			UserType:  wallet.VoterType,
+			VoterData: wallet.Voter{VoterName: voterName, VoterId: voterStudentID},
+			Election:  d.ElectionID,
+		}
+		if err := voterWallet.LoadFile(); os.IsNotExist(err) {
+			return nil, nil // never voted with this client
+		} else if err != nil {
+			return nil, err
+		}
+		for _, w := range voterWallet.Wallets {
+			publicKeys = append(publicKeys, w.PublicKey)
+		}
 	}
-	var queryResultReply *blockvote.QueryResultsReply
+	var ballots []blockvote.VoterTxn
+	for _, publicKey := range publicKeys {
+		var queryTxnsReply blockvote.QueryTxnsByVoterReply
+		for {
+			d.connRw.RLock()
+			err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryTxnsByVoter"), blockvote.QueryTxnsByVoterArgs{
+				PubKeyHash: wallet.PublicKeyHash(publicKey),
+			}, &queryTxnsReply)
+			d.connRw.RUnlock()
+			if err == nil {
+				break
+			} else {
+				d.coordFailed(err)
+			}
+		}
+		ballots = append(ballots, queryTxnsReply.Txns...)
+	}
+	return ballots, nil
+}
+
+// Results is a tally from coord and the tip of the chain it was counted at
+type Results struct {
+	Votes          []uint // votes of each candidate, in the order of CandidateList
+	Races          []blockvote.RaceTally
+	Height         uint32 // block number of LastHash
+	LastHash       []byte // tip of the chain the votes were counted at, the longest chain unless pinned
+	Strict         bool   // only finalized ballots were counted
+	OnLongestChain bool   // LastHash is on coord's longest chain
+	FetchedAt      time.Time
+}
+
+// GetResults API returns the results from coord, reusing the last ones if they are younger than ResultsTTL.
+// forceRefresh always asks coord.
+func (d *EV) GetResults(forceRefresh bool) (*Results, error) {
+	d.resMu.Lock()
+	defer d.resMu.Unlock()
+	if !forceRefresh && d.results != nil && d.Clock.Now().Sub(d.results.FetchedAt) < d.ResultsTTL {
+		return d.results, nil
+	}
+	var queryResultReply blockvote.QueryResultsReply
 	for {
 		d.connRw.RLock()
-		err := d.coordClient.Call("CoordAPIClient.QueryResults", blockvote.QueryResultsArgs{}, &queryResultReply)
+		err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryResults"), blockvote.QueryResultsArgs{ClientID: d.ClientID, Strict: d.StrictResults}, &queryResultReply)
 		d.connRw.RUnlock()
 		if err == nil {
 			break
 		} else {
-			d.ComplainCoordChan <- 1
-			time.Sleep(2 * time.Second)
+			d.coordFailed(err)
 		}
 	}
+	d.results = d.newResults(&queryResultReply)
+	return d.results, nil
+}
+
+// QueryResultsAt API returns the tally from coord at the block blockHash rather than at the moving tip of the
+// longest chain, so that observers asking for the same block get the same tally to compare
+func (d *EV) QueryResultsAt(blockHash []byte) (*Results, error) {
+	var reply blockvote.QueryResultsReply
+	d.connRw.RLock()
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryResults"), blockvote.QueryResultsArgs{
+		ClientID: d.ClientID,
+		Strict:   d.StrictResults,
+		At:       blockHash,
+	}, &reply)
+	d.connRw.RUnlock()
+	if err != nil {
+		return nil, typedError(err)
+	}
+	return d.newResults(&reply), nil
+}
+
+// QueryTurnout API returns how many voters cast a ballot on the longest chain, counting every ballot on it and
+// the final ballots only, out of the voters registered with coord
+func (d *EV) QueryTurnout() (*blockvote.QueryTurnoutReply, error) {
+	var reply blockvote.QueryTurnoutReply
+	d.connRw.RLock()
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryTurnout"), blockvote.QueryTurnoutArgs{
+		ClientID: d.ClientID,
+	}, &reply)
+	d.connRw.RUnlock()
+	if err != nil {
+		return nil, typedError(err)
+	}
+	return &reply, nil
+}
+
+// ResultCertificateAt API asks coord for a signed certificate of the tally at the block blockHash of the
+// longest chain, the tip if nil
+func (d *EV) ResultCertificateAt(blockHash []byte) (*blockvote.ResultCertificate, error) {
+	var reply blockvote.GetResultCertificateReply
+	d.connRw.RLock()
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.GetResultCertificate"), blockvote.GetResultCertificateArgs{
+		At: blockHash,
+	}, &reply)
+	d.connRw.RUnlock()
+	if err != nil {
+		return nil, typedError(err)
+	}
+	return &reply.Certificate, nil
+}
+
+func (d *EV) newResults(reply *blockvote.QueryResultsReply) *Results {
+	return &Results{
+		Votes:          reply.Votes,
+		Races:          reply.Races,
+		Height:         reply.Height,
+		LastHash:       reply.LastHash,
+		Strict:         reply.Strict,
+		OnLongestChain: reply.OnLongestChain,
+		FetchedAt:      d.Clock.Now(),
+	}
+}
+
+// GetResultsHistory API returns the tally at every block of the longest chain between two heights, oldest
+// first. toHeight 0 is the tip. Votes are in the order of CandidateList
+func (d *EV) GetResultsHistory(fromHeight uint32, toHeight uint32) ([]blockvote.TallyPoint, error) {
+	var reply blockvote.QueryResultsHistoryReply
+	d.connRw.RLock()
+	err := d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.QueryResultsHistory"), blockvote.QueryResultsHistoryArgs{
+		FromHeight: fromHeight,
+		ToHeight:   toHeight,
+	}, &reply)
+	d.connRw.RUnlock()
+	return reply.Points, typedError(err)
+}
+
+// GetCandVotes API retrieve the number of votes a candidate has. See GetResults for how fresh it is.
+func (d *EV) GetCandVotes(candidate string) (uint, error) {
+	d.rw.RLock()
+	candidateList := d.CandidateList
+	d.rw.RUnlock()
+	if len(candidateList) == 0 {
+		return 0, errors.New("Empty Candidates.\n")
+	}
+	results, err := d.GetResults(false)
+	if err != nil {
+		return 0, err
+	}
 
 	idx := 0
-	//fmt.Println(queryResultReply)
-	for i, cand := range d.CandidateList {
+	for i, cand := range candidateList {
 		if cand == candidate {
 			idx = i
 		}
 	}
-	return queryResultReply.Votes[idx], nil
+	return results.Votes[idx], nil
+}
+
+// GetRaceResults API retrieves the number of votes of every candidate, grouped by race. See GetResults for
+// how fresh it is.
+func (d *EV) GetRaceResults() ([]blockvote.RaceTally, error) {
+	results, err := d.GetResults(false)
+	if err != nil {
+		return nil, err
+	}
+	return results.Races, nil
 }
 
 // Stop Stops the EV instance.
 // This call always succeeds.
 func (d *EV) Stop() {
-	quit <- true
-	d.coordClient.Close()
+	close(d.quit)
+	d.connRw.Lock()
+	if d.coordClient != nil {
+		// nil if the instance started from the discovery cache and never reached coord
+		d.coordClient.Close()
+	}
+	d.connRw.Unlock()
+	if d.keystore != nil {
+		d.keystore.Close()
+	}
+	if d.intents != nil {
+		d.intents.close()
+	}
 	//d.minerClient.Close()
 	return
 }
 
 // ----- evlib utility functions -----
 
-func createBallot() blockChain.Ballot {
-	// enter ballot
+// CreateBallot prompts for a ballot on stdin until a valid one is entered. Fails if stdin ends before that,
+// or if the election has no candidates to vote for
+func (d *EV) CreateBallot() (blockChain.Ballot, error) {
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Print("Enter your name: ")
-	voterName, _ := reader.ReadString('\n')
+	var ballot blockChain.Ballot
+	races := d.Races()
+	if len(races) == 0 {
+		return ballot, errors.New("election has no candidates")
+	}
+	var err error
+	for {
+		if ballot.VoterName, err = prompt(reader, "Enter your name: "); err != nil {
+			return ballot, err
+		}
+		if ballot.VoterName != "" {
+			break
+		}
+		fmt.Println("Name cannot be empty.")
+	}
+	for {
+		if ballot.VoterStudentID, err = prompt(reader, "Enter your studentID: "); err != nil {
+			return ballot, err
+		}
+		err = d.checkStudentID(ballot.VoterStudentID)
+		if err == nil {
+			break
+		}
+		fmt.Println(err)
+	}
+	if len(races) > 1 || races[0] != "" {
+		fmt.Println("Races:", strings.Join(races, ", "))
+		for {
+			if ballot.Race, err = prompt(reader, "Enter the race to vote in: "); err != nil {
+				return ballot, err
+			}
+			if len(d.RaceCandidates(ballot.Race)) > 0 {
+				break
+			}
+			fmt.Println("No such race.")
+		}
+	}
+	fmt.Println("Candidates:", strings.Join(d.RaceCandidates(ballot.Race), ", "))
+	rules, _ := d.rules(ballot.Race)
+	if rules.Method == blockChain.MethodIRV {
+		for {
+			answer, err := prompt(reader, "Rank the candidates, most preferred first and comma separated (or \"abstain\"): ")
+			if err != nil {
+				return ballot, err
+			}
+			if answer == blockChain.BallotAbstain {
+				ballot.Type, ballot.Ranking = blockChain.BallotAbstain, nil
+				return ballot, nil
+			}
+			ballot.Type, ballot.Ranking = blockChain.BallotRanked, ParseRanking(answer)
+			err = d.ValidateBallot(ballot)
+			if err == nil {
+				return ballot, nil
+			}
+			fmt.Println(err)
+		}
+	}
+	msg := "Vote your vote Candidate (or \"abstain\"): "
+	if rules.AllowWriteIns {
+		msg = "Vote your vote Candidate (or \"abstain\", or write in a name): "
+	}
+	for {
+		if ballot.VoterCandidate, err = prompt(reader, msg); err != nil {
+			return ballot, err
+		}
+		if d.isCandidate(ballot.Race, ballot.VoterCandidate) {
+			break
+		}
+		if ballot.VoterCandidate == blockChain.BallotAbstain {
+			ballot.Type, ballot.VoterCandidate = blockChain.BallotAbstain, ""
+			break
+		}
+		if ballot.VoterCandidate != "" && rules.AllowWriteIns {
+			answer, err := prompt(reader, "Write in "+ballot.VoterCandidate+"? (y/n): ")
+			if err != nil {
+				return ballot, err
+			}
+			if answer == "y" {
+				ballot.Type = blockChain.BallotWriteIn
+				break
+			}
+		}
+		fmt.Println("No such candidate.")
+	}
+	return ballot, nil
+}
 
-	reader = bufio.NewReader(os.Stdin)
-	fmt.Print("Enter your studentID: ")
-	voterId, _ := reader.ReadString('\n')
-	// ^[0-9]{8}
-	isIDValid := false
-	candidateName := ""
-	for !isIDValid {
-		reader = bufio.NewReader(os.Stdin)
-		fmt.Print("Vote your vote Candidate: ")
-		candidateName, _ = reader.ReadString('\n')
+// Candidates returns the candidates of the election with their race, wallet address, statement and place on the
+// ballot, in the order of CandidateList
+func (d *EV) Candidates() []blockvote.CandidateInfo {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	return append([]blockvote.CandidateInfo(nil), d.candidates...)
+}
+
+// Races returns the races of the election in the order of CandidateList
+func (d *EV) Races() []string {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	var races []string
+	seen := make(map[string]bool)
+	for _, race := range d.CandidateRaces {
+		if !seen[race] {
+			seen[race] = true
+			races = append(races, race)
+		}
 	}
+	return races
+}
 
-	ballot := blockChain.Ballot{
-		strings.TrimRight(voterName, "\r\n"),
-		strings.TrimRight(voterId, "\r\n"),
-		strings.TrimRight(candidateName, "\r\n"),
+// RaceCandidates returns the candidates of a race
+func (d *EV) RaceCandidates(race string) []string {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	var candidates []string
+	for idx, cand := range d.CandidateList {
+		if d.CandidateRaces[idx] == race {
+			candidates = append(candidates, cand)
+		}
 	}
-	return ballot
+	return candidates
 }
 
-func (d *EV) createVoterWallet(ballot blockChain.Ballot) (*wallet.Wallets, string) {
-	v, err := wallet.CreateVoter(ballot.VoterName, ballot.VoterStudentID)
+// ValidateBallot checks the ballot fields before it is signed and submitted
+func (d *EV) ValidateBallot(ballot blockChain.Ballot) error {
+	if ballot.VoterName == "" {
+		return errors.New("voter name cannot be empty")
+	}
+	if err := d.checkStudentID(ballot.VoterStudentID); err != nil {
+		return err
+	}
+	if err := ballot.CheckFields(); err != nil {
+		return err
+	}
+	if err := d.checkTxnSize(ballot); err != nil {
+		return err
+	}
+	rules, ok := d.rules(ballot.Race)
+	if !ok {
+		return fmt.Errorf("no such race: %s", ballot.Race)
+	}
+	if rules.Method == blockChain.MethodIRV && ballot.Type != blockChain.BallotRanked &&
+		ballot.Type != blockChain.BallotAbstain {
+		return errors.New("this race takes ranked ballots")
+	}
+	if ballot.Type != blockChain.BallotRanked && len(ballot.Ranking) > 0 {
+		return errors.New("only ranked ballots have a ranking")
+	}
+	switch ballot.Type {
+	case blockChain.BallotRanked:
+		if rules.Method != blockChain.MethodIRV {
+			return errors.New("this race does not take ranked ballots")
+		}
+		if ballot.VoterCandidate != "" || len(ballot.Ranking) == 0 {
+			return errors.New("ranking cannot be empty")
+		}
+		ranked := make(map[string]bool)
+		for _, name := range ballot.Ranking {
+			if !d.isCandidate(ballot.Race, name) {
+				return fmt.Errorf("no such candidate: %s", name)
+			}
+			if ranked[name] {
+				return fmt.Errorf("%s is ranked twice", name)
+			}
+			ranked[name] = true
+		}
+		return nil
+	case blockChain.BallotAbstain:
+		if ballot.VoterCandidate != "" {
+			return errors.New("an abstention cannot name a candidate")
+		}
+		return nil
+	case blockChain.BallotWriteIn:
+		if !rules.AllowWriteIns {
+			return errors.New("write-ins are not allowed in this election")
+		}
+		if ballot.VoterCandidate == "" {
+			return errors.New("write-in name cannot be empty")
+		}
+		for _, cand := range d.RaceCandidates(ballot.Race) {
+			if blockChain.WriteInKey(cand) == blockChain.WriteInKey(ballot.VoterCandidate) {
+				return fmt.Errorf("%s is a listed candidate, vote for them instead", cand)
+			}
+		}
+		return nil
+	case blockChain.BallotCandidate:
+	default:
+		return fmt.Errorf("unknown ballot type: %s", ballot.Type)
+	}
+	if !d.isCandidate(ballot.Race, ballot.VoterCandidate) {
+		if ballot.Race != "" {
+			return fmt.Errorf("no such candidate in race %s: %s", ballot.Race, ballot.VoterCandidate)
+		}
+		return fmt.Errorf("no such candidate: %s", ballot.VoterCandidate)
+	}
+	return nil
+}
+
+// ParseRanking splits a comma separated list of candidates, most preferred first
+func ParseRanking(list string) []string {
+	var ranking []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ranking = append(ranking, name)
+		}
+	}
+	return ranking
+}
+
+func (d *EV) isCandidate(race string, name string) bool {
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	for idx, cand := range d.CandidateList {
+		if cand == name && d.CandidateRaces[idx] == race {
+			return true
+		}
+	}
+	return false
+}
+
+// prompt prints msg and reads a line from reader. Fails once reader ends with nothing left to read
+func prompt(reader *bufio.Reader, msg string) (string, error) {
+	fmt.Print(msg)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		if err == io.EOF {
+			return "", errors.New("no more input on stdin")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// createVoterWallet loads the wallet of the voter from WalletDir, or creates and saves it there
+func (d *EV) createVoterWallet(ballot blockChain.Ballot, trace *tracing.Trace) (*wallet.Wallets, string, error) {
+	voterWallet, err := wallet.CreateVoterInDir(d.WalletDir, d.ElectionID, ballot.VoterName, ballot.VoterStudentID)
 	if err != nil {
-		log.Panic(err)
+		return nil, "", fmt.Errorf("voter wallet: %v", err)
 	}
-	voterWallet := v
 	addr := voterWallet.AddWallet()
-	//d.voterWalletAddr = addr
-	voterWallet.SaveFile()
-	return voterWallet, addr
+	if err = voterWallet.SaveFile(); err != nil {
+		return nil, "", fmt.Errorf("voter wallet: %v", err)
+	}
+	blockvote.RecordAction(trace, blockvote.WalletCreated{
+		VoterName: ballot.VoterName,
+		VoterID:   ballot.VoterStudentID,
+		Address:   addr,
+	})
+	return voterWallet, addr, nil
+}
+
+// ensureVoterWallet creates the wallet of the voter, only when such voter does not exist. the keystore agent
+// creates keys on its own
+func (d *EV) ensureVoterWallet(ballot blockChain.Ballot, trace *tracing.Trace) error {
+	if d.keystore != nil || d.findVoterExist(ballot.VoterName, ballot.VoterStudentID) {
+		return nil
+	}
+	d.ifRw.Lock()
+	defer d.ifRw.Unlock()
+	voterWallet, addr, err := d.createVoterWallet(ballot, trace)
+	if err != nil {
+		return err
+	}
+	d.voterInfo = append(d.voterInfo, VoterNameID{
+		Name:            ballot.VoterName,
+		ID:              ballot.VoterStudentID,
+		voterWallet:     *voterWallet,
+		voterWalletAddr: addr,
+	})
+	return nil
 }
+
 func (d *EV) findWalletAndAddr(ballot blockChain.Ballot) (wallet.Wallets, string) {
 	d.ifRw.RLock()
 	defer d.ifRw.RUnlock()
 
-	for _, val := range voterInfo {
+	for _, val := range d.voterInfo {
 		if val.ID == ballot.VoterStudentID && val.Name == ballot.VoterName {
 			return val.voterWallet, val.voterWalletAddr
 		}
@@ -475,7 +1663,10 @@ func (d *EV) findWalletAndAddr(ballot blockChain.Ballot) (wallet.Wallets, string
 	return wallet.Wallets{}, ""
 }
 
-func (d *EV) createTransaction(ballot blockChain.Ballot) (blockChain.Transaction, error) {
+func (d *EV) createTransaction(ballot blockChain.Ballot, trace *tracing.Trace) (blockChain.Transaction, error) {
+	if d.keystore != nil {
+		return d.createKeystoreTransaction(ballot, trace)
+	}
 	voterWallet, voterWalletAddr := d.findWalletAndAddr(ballot)
 	if voterWalletAddr == "" {
 		return blockChain.Transaction{}, errors.New("Not such a voter exists.\n")
@@ -486,10 +1677,55 @@ func (d *EV) createTransaction(ballot blockChain.Ballot) (blockChain.Transaction
 		ID:        nil,
 		Signature: nil,
 		PublicKey: voterWallet.Wallets[voterWalletAddr].PublicKey,
+		Genesis:   d.genesis,
+	}
+	var err error
+	if txn.Credential, err = d.credentialOf(ballot, txn.PublicKey); err != nil {
+		return blockChain.Transaction{}, err
 	}
-	txn.ID = txn.Hash()
+	txn.SetID()
 	// client sign with private key
 	txn.Sign(voterWallet.Wallets[voterWalletAddr].PrivateKey)
+	if err := txn.CheckShape(); err != nil {
+		return blockChain.Transaction{}, err
+	}
+	blockvote.RecordAction(trace, blockvote.TxnSigned{
+		TxID:      txn.ID,
+		VoterName: ballot.VoterName,
+		Candidate: ballot.VoterCandidate,
+		ClientID:  d.ClientID,
+	})
+	return txn, nil
+}
+
+// createKeystoreTransaction is createTransaction with the voter's key kept by the keystore agent
+func (d *EV) createKeystoreTransaction(ballot blockChain.Ballot, trace *tracing.Trace) (blockChain.Transaction, error) {
+	key := keystore.KeyID{Election: d.ElectionID, VoterName: ballot.VoterName, VoterStudentID: ballot.VoterStudentID}
+	publicKey, err := d.keystore.PublicKey(key, true)
+	if err != nil {
+		return blockChain.Transaction{}, fmt.Errorf("keystore: %v", err)
+	}
+	txn := blockChain.Transaction{
+		Data:      &ballot,
+		PublicKey: publicKey,
+		Genesis:   d.genesis,
+	}
+	if txn.Credential, err = d.credentialOf(ballot, publicKey); err != nil {
+		return blockChain.Transaction{}, err
+	}
+	txn.SetID()
+	if txn.Signature, err = d.keystore.Sign(key, txn.ID); err != nil {
+		return blockChain.Transaction{}, fmt.Errorf("keystore: %v", err)
+	}
+	if err := txn.CheckShape(); err != nil {
+		return blockChain.Transaction{}, err
+	}
+	blockvote.RecordAction(trace, blockvote.TxnSigned{
+		TxID:      txn.ID,
+		VoterName: ballot.VoterName,
+		Candidate: ballot.VoterCandidate,
+		ClientID:  d.ClientID,
+	})
 	return txn, nil
 }
 
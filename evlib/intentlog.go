@@ -0,0 +1,195 @@
+package evlib
+
+import (
+	"bufio"
+	"bytes"
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	intentSubmit = "submit" // a signed txn is about to be sent to miners
+	intentDone   = "done"   // miners accepted or rejected the txn, it is not sent again
+)
+
+// intentRecord is a line of the intent log
+type intentRecord struct {
+	Op   string
+	TxID string // hex
+	Txn  []byte `json:",omitempty"` // the signed txn of a submit record, see blockchain.Transaction.Serialize
+}
+
+// intentLog is a write-ahead log of the txns handed to miners, see EV.IntentLog. Every record is synced to
+// disk before the call returns
+type intentLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	pending map[string]blockChain.Transaction // txns with a submit record and no done record, by hex TxID
+	logger  *log.Logger
+}
+
+// openIntentLog reads the log at path, keeping the txns that were never settled, and compacts it to them
+func openIntentLog(path string, logger *log.Logger) (*intentLog, error) {
+	l := &intentLog{path: path, pending: make(map[string]blockChain.Transaction), logger: logger}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1<<20) // txns are at most a few KB
+		for scanner.Scan() {
+			var rec intentRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				// a crash in the middle of an append leaves a torn last line
+				logger.Println("[WARN] Ignoring malformed intent log record:", err)
+				continue
+			}
+			switch rec.Op {
+			case intentSubmit:
+				txn, err := blockChain.DecodeTransaction(rec.Txn)
+				if err != nil {
+					logger.Printf("[WARN] Ignoring malformed txn %s in the intent log: %v\n", rec.TxID, err)
+					continue
+				}
+				l.pending[rec.TxID] = txn
+			case intentDone:
+				delete(l.pending, rec.TxID)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := l.compact(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// compact replaces the log with the submit records of the pending txns. The file is replaced at once, so a
+// crash never leaves half of it
+func (l *intentLog) compact() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for txid, txn := range l.pending {
+		data, err := json.Marshal(intentRecord{Op: intentSubmit, TxID: txid, Txn: txn.Serialize()})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(buf.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+	if err != nil {
+		return err
+	}
+	l.file, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0600)
+	return err
+}
+
+// write appends a record and syncs it to disk
+func (l *intentLog) write(rec intentRecord) error {
+	if l.file == nil {
+		return errors.New("intent log is closed")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err = l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// submit logs txn before it is sent to miners. A txn that is already pending is not logged again
+func (l *intentLog) submit(txn blockChain.Transaction) error {
+	txid := hex.EncodeToString(txn.ID)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.pending[txid]; ok {
+		return nil
+	}
+	if err := l.write(intentRecord{Op: intentSubmit, TxID: txid, Txn: txn.Serialize()}); err != nil {
+		return err
+	}
+	l.pending[txid] = txn
+	return nil
+}
+
+// done logs that miners settled the txn, so it is not resubmitted on restart
+func (l *intentLog) done(txid []byte) {
+	key := hex.EncodeToString(txid)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.pending[key]; !ok {
+		return
+	}
+	if err := l.write(intentRecord{Op: intentDone, TxID: key}); err != nil {
+		// the txn is resubmitted on restart, which miners take as a duplicate
+		l.logger.Printf("[WARN] Unable to log txn %s as settled: %v\n", key, err)
+		return
+	}
+	delete(l.pending, key)
+}
+
+// unsettled returns the pending txns
+func (l *intentLog) unsettled() []blockChain.Transaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	txns := make([]blockChain.Transaction, 0, len(l.pending))
+	for _, txn := range l.pending {
+		txns = append(txns, txn)
+	}
+	return txns
+}
+
+func (l *intentLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// resubmitIntents casts again the txns the intent log holds from an earlier run that stopped before miners
+// settled them
+func (d *EV) resubmitIntents() {
+	txns := d.intents.unsettled()
+	if len(txns) == 0 {
+		return
+	}
+	d.logger().Printf("[INFO] Resubmitting %d ballots left unsettled by an earlier run\n", len(txns))
+	for _, txn := range txns {
+		if _, err := d.castTxn(txn, blockvote.CreateTrace(d.tracer)); err != nil {
+			d.logger().Printf("[WARN] Unsettled txn %x is not cast: %v\n", txn.ID, err)
+		} else {
+			d.logger().Printf("[INFO] Unsettled txn %x is cast\n", txn.ID)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package evlib
+
+import (
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"sort"
+	"time"
+)
+
+// latencySmoothing is the weight of a new round-trip time sample in the moving average of a miner
+const latencySmoothing = 0.3
+
+// LatencyProber measures the round-trip time of a Health call to every known miner, every ProbeInterval.
+// Miners that fail or are not ready lose their measurement until they answer again
+func (d *EV) LatencyProber() {
+	for {
+		d.rw.RLock()
+		minerList := append([]string(nil), d.MinerAddrList...)
+		d.rw.RUnlock()
+		for _, addr := range minerList {
+			d.rw.RLock()
+			_, open := d.breakerOpen(addr, d.Clock.Now())
+			d.rw.RUnlock()
+			if open {
+				// probed again once its cool-down is over
+				continue
+			}
+			rtt, ok := d.probeMiner(addr)
+			d.rw.Lock()
+			if d.latency == nil {
+				d.latency = make(map[string]time.Duration)
+			}
+			if !ok {
+				delete(d.latency, addr)
+			} else if last, known := d.latency[addr]; known {
+				d.latency[addr] = last + time.Duration(latencySmoothing*float64(rtt-last))
+			} else {
+				d.latency[addr] = rtt
+			}
+			d.rw.Unlock()
+		}
+
+		select {
+		case <-d.quit:
+			return
+		default:
+			d.Clock.Sleep(d.ProbeInterval)
+		}
+	}
+}
+
+// probeMiner returns the round-trip time of a Health call to the miner, and false if it failed or the miner is
+// not ready. Dialing is not timed. Failures count in the miner's breaker
+func (d *EV) probeMiner(addr string) (time.Duration, bool) {
+	conn, err := d.dial(addr)
+	if err != nil {
+		d.callFailed(addr)
+		return 0, false
+	}
+	defer conn.Close()
+	var health blockvote.HealthReply
+	start := time.Now()
+	err = d.call(conn, "MinerAPIClient.Health", blockvote.HealthArgs{}, &health)
+	d.recordCall(addr, err)
+	if err != nil || !health.Ready {
+		return 0, false
+	}
+	return time.Since(start), true
+}
+
+// pickMiner picks the miner with the lowest round-trip time. A random miner is picked instead with
+// probability ExploreRate, so that the others keep being tried, or when none was measured yet. Called with rw
+// held
+func (d *EV) pickMiner(minerList []string) string {
+	if d.Rand.Float64() >= d.ExploreRate {
+		best := ""
+		for _, addr := range minerList {
+			if rtt, ok := d.latency[addr]; ok && (best == "" || rtt < d.latency[best]) {
+				best = addr
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return minerList[d.Rand.Intn(len(minerList))]
+}
+
+// pickMiners picks up to n distinct miners of minerList: the assigned miner or pickMiner's choice first, then
+// the others by round-trip time, unmeasured ones last in random order. Called with rw held
+func (d *EV) pickMiners(minerList []string, n int) []string {
+	first := d.assignedMiner
+	if !containsAddr(minerList, first) {
+		first = d.pickMiner(minerList)
+	}
+	rest := make([]string, 0, len(minerList))
+	for _, addr := range minerList {
+		if addr != first {
+			rest = append(rest, addr)
+		}
+	}
+	d.Rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	sort.SliceStable(rest, func(i, j int) bool {
+		rttI, okI := d.latency[rest[i]]
+		rttJ, okJ := d.latency[rest[j]]
+		if okI != okJ {
+			return okI
+		}
+		return rttI < rttJ
+	})
+	picked := append([]string{first}, rest...)
+	if n < len(picked) {
+		picked = picked[:n]
+	}
+	return picked
+}
@@ -0,0 +1,140 @@
+package evlib
+
+import (
+	"bytes"
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"errors"
+	"net/rpc"
+)
+
+// Light client mode keeps a header-only copy of the longest chain. Headers are checked for proof of work
+// and linkage before they are adopted, and ballot status is computed from Merkle proofs against them,
+// so neither coord nor a single miner has to be trusted for the answer.
+
+// syncHeaders fetches headers from conn and adopts them if they form a valid chain longer than the local one.
+// service is the RPC service of the node conn connects to, i.e. CoordAPIClient or MinerAPIClient
+func (d *EV) syncHeaders(conn *rpc.Client, service string) error {
+	d.hdrMu.RLock()
+	local := d.headers
+	d.hdrMu.RUnlock()
+
+	from := uint32(0)
+	if len(local) > 0 {
+		from = local[len(local)-1].BlockNum // refetch the tip to detect a fork
+	}
+	var reply blockvote.GetHeadersReply
+	if err := d.call(conn, service+".GetHeaders", blockvote.GetHeadersArgs{FromHeight: from}, &reply); err != nil {
+		return err
+	}
+	fetched := reply.Headers
+	if len(fetched) > 0 && int(fetched[0].BlockNum) > len(local) {
+		return errors.New("headers do not start at the requested height")
+	}
+	if len(fetched) > 0 && fetched[0].BlockNum > 0 &&
+		!bytes.Equal(fetched[0].PrevHash, local[fetched[0].BlockNum-1].Hash) {
+		// the fork is deeper than our tip, fetch the whole chain
+		reply = blockvote.GetHeadersReply{}
+		if err := d.call(conn, service+".GetHeaders", blockvote.GetHeadersArgs{}, &reply); err != nil {
+			return err
+		}
+		fetched = reply.Headers
+	}
+	if len(fetched) == 0 {
+		return errors.New("no headers received")
+	}
+
+	chain := append(append([]blockChain.BlockHeader{}, local[:fetched[0].BlockNum]...), fetched...)
+	d.rw.RLock()
+	zeros := d.difficulty
+	d.rw.RUnlock()
+	if err := validateHeaders(chain, zeros); err != nil {
+		return err
+	}
+	if len(local) > 0 && !bytes.Equal(chain[0].Hash, local[0].Hash) {
+		return errors.New("headers have a different genesis block")
+	}
+
+	d.hdrMu.Lock()
+	defer d.hdrMu.Unlock()
+	if len(chain) > len(d.headers) {
+		d.headers = chain
+	}
+	return nil
+}
+
+// lightBallotStatus asks coord, then a miner, for a Merkle proof of the txn and checks it against local headers
+func (d *EV) lightBallotStatus(txid []byte) (int, error) {
+	d.connRw.RLock()
+	numConfirmed, err := d.proveTxn(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient"), txid)
+	d.connRw.RUnlock()
+	if err != nil {
+		d.logger().Println("[WARN] Unable to verify ballot status with coord:", err)
+		d.ComplainCoordChan <- 1
+	}
+	if numConfirmed > -1 {
+		return numConfirmed, nil
+	}
+
+	conn, minerIpPort, err := d.connectMiner()
+	if err != nil {
+		return numConfirmed, err
+	}
+	defer conn.Close()
+	numConfirmed, err = d.proveTxn(conn, "MinerAPIClient", txid)
+	d.recordCall(minerIpPort, err)
+	if err != nil {
+		d.logger().Printf("[WARN] Unable to verify ballot status with miner %s: %v\n", minerIpPort, err)
+	}
+	return numConfirmed, nil
+}
+
+// proveTxn returns the number of blocks confirming txid according to a verified Merkle proof from conn,
+// or -1 if conn does not prove the txn is on the longest chain
+func (d *EV) proveTxn(conn *rpc.Client, service string, txid []byte) (int, error) {
+	if err := d.syncHeaders(conn, service); err != nil {
+		return -1, err
+	}
+	var reply blockvote.GetTxnProofReply
+	if err := d.call(conn, service+".GetTxnProof", blockvote.GetTxnProofArgs{TxID: txid}, &reply); err != nil {
+		return -1, err
+	}
+	if !reply.Found {
+		return -1, nil
+	}
+	if reply.Txn.Data == nil || !bytes.Equal(reply.Txn.ID, txid) || !reply.Txn.Verify() {
+		return -1, errors.New("invalid txn in proof")
+	}
+
+	d.hdrMu.RLock()
+	defer d.hdrMu.RUnlock()
+	for _, header := range d.headers {
+		if bytes.Equal(header.Hash, reply.BlockHash) {
+			if !blockChain.VerifyMerkleProof(header.MerkleRoot, blockChain.TxnLeaf(&reply.Txn), reply.Proof) {
+				return -1, errors.New("invalid Merkle proof")
+			}
+			return int(d.headers[len(d.headers)-1].BlockNum - header.BlockNum), nil
+		}
+	}
+	return -1, errors.New("proof refers to a block not on the longest chain")
+}
+
+// validateHeaders checks that headers start at genesis, link to each other and carry valid proof of work with
+// zeros leading zero bits (blockchain.NumZeros if 0). The genesis block has its own difficulty, at least NumZeros
+func validateHeaders(headers []blockChain.BlockHeader, zeros uint8) error {
+	if zeros == 0 {
+		zeros = blockChain.NumZeros
+	}
+	for i := range headers {
+		if int(headers[i].BlockNum) != i {
+			return errors.New("headers are not contiguous")
+		}
+		if i == 0 && !headers[i].Validate() || i > 0 && !headers[i].ValidateWithDifficulty(zeros) {
+			return errors.New("header has invalid proof of work")
+		}
+		if i > 0 && !bytes.Equal(headers[i].PrevHash, headers[i-1].Hash) {
+			return errors.New("headers do not link")
+		}
+	}
+	return nil
+}
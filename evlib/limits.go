@@ -0,0 +1,89 @@
+package evlib
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+// Ballots are checked against the limits miners enforce (blockchain.Ballot.CheckFields) and brought to their
+// canonical form before they are signed, so a ballot is either refused with a reason right away or accepted
+// by every miner, and casting the same ballot again signs the same content and gets the same TxID
+
+// DefaultStudentIDPattern is what student IDs must match when StudentIDPattern is nil
+var DefaultStudentIDPattern = regexp.MustCompile(`^[0-9]{8}$`)
+
+// ErrInvalidBallot is returned by Vote for a ballot ValidateBallot refuses, wrapping why
+var ErrInvalidBallot = errors.New("invalid ballot")
+
+// CanonicalBallot returns the ballot with the spaces around its text fields and ranked names trimmed and an
+// empty ranking dropped, so that ballots differing only in them are the same ballot
+func CanonicalBallot(ballot blockChain.Ballot) blockChain.Ballot {
+	ballot.VoterName = strings.TrimSpace(ballot.VoterName)
+	ballot.VoterStudentID = strings.TrimSpace(ballot.VoterStudentID)
+	ballot.VoterCandidate = strings.TrimSpace(ballot.VoterCandidate)
+	ballot.Race = strings.TrimSpace(ballot.Race)
+	if len(ballot.Ranking) == 0 {
+		ballot.Ranking = nil
+		return ballot
+	}
+	ranking := make([]string, len(ballot.Ranking))
+	for i, name := range ballot.Ranking {
+		ranking[i] = strings.TrimSpace(name)
+	}
+	ballot.Ranking = ranking
+	return ballot
+}
+
+// studentIDs returns the pattern student IDs must match
+func (d *EV) studentIDs() *regexp.Regexp {
+	if d.StudentIDPattern != nil {
+		return d.StudentIDPattern
+	}
+	return DefaultStudentIDPattern
+}
+
+// checkStudentID checks a student ID against the pattern, with a message a voter understands
+func (d *EV) checkStudentID(id string) error {
+	if d.studentIDs().MatchString(id) {
+		return nil
+	}
+	if d.StudentIDPattern == nil {
+		return errors.New("studentID must be 8 digits")
+	}
+	return fmt.Errorf("studentID must match %s", d.StudentIDPattern)
+}
+
+// checkTxnSize checks that the ballot fits in a txn once signed, counting the largest key, signature and
+// credential
+func (d *EV) checkTxnSize(ballot blockChain.Ballot) error {
+	txn := blockChain.Transaction{
+		Data:      &ballot,
+		ID:        make([]byte, sha256.Size),
+		Signature: make([]byte, blockChain.MaxSignatureLen),
+		PublicKey: make([]byte, blockChain.MaxPublicKeyLen),
+		Genesis:   make([]byte, blockChain.GenesisHashLen),
+	}
+	d.rw.RLock()
+	if d.registration.Required() {
+		txn.Credential = make([]byte, blockChain.MaxCredentialLen)
+	}
+	d.rw.RUnlock()
+	if size := len(txn.CanonicalEncoding()); size > blockChain.MaxTxnSize {
+		return fmt.Errorf("%w: ballot takes %d bytes once signed, at most %d are allowed", blockChain.ErrTxnTooLarge,
+			size, blockChain.MaxTxnSize)
+	}
+	return nil
+}
+
+// checkSealed checks a sealed ballot against the limits miners enforce before it is signed
+func (d *EV) checkSealed(ballot blockChain.Ballot) error {
+	if err := ballot.CheckFields(); err != nil {
+		return err
+	}
+	return d.checkTxnSize(ballot)
+}
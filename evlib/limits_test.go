@@ -0,0 +1,62 @@
+package evlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+func TestSealedBallotLimits(t *testing.T) {
+	sealingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewEV()
+	d.WalletDir = t.TempDir()
+	d.sealingKey = blockChain.SealingPublicKey(sealingKey)
+	d.genesis = make([]byte, blockChain.GenesisHashLen)
+
+	// a ranking that fits in a txn in the clear but whose choice is too long to seal
+	ballot := blockChain.Ballot{VoterName: "voter", VoterStudentID: "12345678", Type: blockChain.BallotRanked}
+	for i := 0; len(ballot.Ranking) < 8; i++ {
+		ballot.Ranking = append(ballot.Ranking, strings.Repeat(string(rune('a'+i)), blockChain.MaxCandidateLen))
+	}
+	if err = d.checkTxnSize(ballot); err != nil {
+		t.Fatalf("ranking does not fit in the clear: %v", err)
+	}
+	if _, err = d.buildTxn(ballot, nil); !errors.Is(err, ErrInvalidBallot) {
+		t.Fatalf("buildTxn of a ballot too long to seal: %v, want ErrInvalidBallot", err)
+	}
+
+	// a ballot that fits once sealed is signed
+	ballot.Ranking = ballot.Ranking[:2]
+	txn, err := d.buildTxn(ballot, nil)
+	if err != nil {
+		t.Fatalf("buildTxn: %v", err)
+	}
+	if txn.Data.Type != blockChain.BallotSealed || len(txn.Data.Ranking) > 0 {
+		t.Fatalf("choice is not sealed: %+v", txn.Data)
+	}
+	if err = txn.CheckShape(); err != nil || !txn.Verify() {
+		t.Fatalf("signed sealed txn: %v", err)
+	}
+
+	// with a credential to carry, less fits in a txn
+	d.registration = blockChain.Registration{RegistrarKey: blockChain.RegistrarPublicKey(sealingKey)}
+	large := blockChain.Ballot{VoterName: "voter", VoterStudentID: "12345678", Type: blockChain.BallotRanked}
+	for i := 0; ; i++ {
+		large.Ranking = append(large.Ranking, strings.Repeat(string(rune('a'+i)), blockChain.MaxCandidateLen))
+		if err = d.checkTxnSize(large); err != nil {
+			break
+		}
+	}
+	d.registration = blockChain.Registration{}
+	if err = d.checkTxnSize(large); err != nil {
+		t.Errorf("ballot that only misses room for a credential: %v", err)
+	}
+}
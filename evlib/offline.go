@@ -0,0 +1,86 @@
+package evlib
+
+import (
+	"bytes"
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"errors"
+	"fmt"
+)
+
+// ErrBadSignedTxn is returned by SubmitSignedTransaction for bytes that are not a well-formed, signed txn
+var ErrBadSignedTxn = errors.New("not a signed txn")
+
+// ElectionParams is what an instance that never talks to coord needs to build txns, see BuildTransaction
+type ElectionParams struct {
+	ElectionID string
+	Genesis    []byte // hash of the genesis block, signed into every txn
+	SealingKey []byte // public key ballots are sealed to. not sealed if empty
+
+	Registration blockChain.Registration // who may vote. anyone if empty
+	Credentials  map[string][]byte       // hex of a voter's public key -> its credential, see Register
+}
+
+// ElectionParams API returns the parameters of the election of a started instance, to be copied to an
+// offline one, with the credentials of the voters it registered
+func (d *EV) ElectionParams() ElectionParams {
+	d.ifRw.RLock()
+	credentials := make(map[string][]byte, len(d.credentials))
+	for key, credential := range d.credentials {
+		credentials[key] = credential
+	}
+	d.ifRw.RUnlock()
+	d.rw.RLock()
+	defer d.rw.RUnlock()
+	return ElectionParams{ElectionID: d.ElectionID, Genesis: d.genesis, SealingKey: d.sealingKey,
+		Registration: d.registration, Credentials: credentials}
+}
+
+// SetElectionParams API sets the election of an instance that is not started, e.g. on an air-gapped machine
+func (d *EV) SetElectionParams(params ElectionParams) {
+	d.ElectionID = params.ElectionID
+	d.genesis = params.Genesis
+	d.sealingKey = params.SealingKey
+	d.registration = params.Registration
+	d.ifRw.Lock()
+	d.credentials = params.Credentials
+	d.ifRw.Unlock()
+}
+
+// BuildTransaction API creates the voter's wallet if needed, seals the ballot if the election asks for it and
+// signs it, without talking to coord or miners. The returned bytes are cast with SubmitSignedTransaction,
+// usually by an instance on another machine. An instance that is not started needs SetElectionParams first.
+func (d *EV) BuildTransaction(ballot blockChain.Ballot) ([]byte, error) {
+	if len(d.genesis) == 0 {
+		return nil, errors.New("election parameters are unknown, start the instance or call SetElectionParams")
+	}
+	txn, err := d.buildTxn(ballot, blockvote.CreateTrace(d.tracer))
+	if err != nil {
+		return nil, err
+	}
+	return txn.Serialize(), nil
+}
+
+// SubmitSignedTransaction API casts a txn made by BuildTransaction like Vote does, and returns its ID
+func (d *EV) SubmitSignedTransaction(txnBytes []byte) ([]byte, error) {
+	txn, err := blockChain.DecodeTransaction(txnBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignedTxn, err)
+	}
+	if err = txn.CheckShape(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignedTxn, err)
+	}
+	if !txn.Verify() {
+		return nil, fmt.Errorf("%w: invalid signature", ErrBadSignedTxn)
+	}
+	if !bytes.Equal(txn.Genesis, d.genesis) {
+		return nil, ErrWrongElection
+	}
+	if d.Closed() {
+		return nil, ErrElectionClosed
+	}
+	if d.hasVoted(*txn.Data) {
+		return nil, ErrAlreadyVoted
+	}
+	return d.castTxn(txn, blockvote.CreateTrace(d.tracer))
+}
@@ -0,0 +1,116 @@
+package evlib
+
+import (
+	"bytes"
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Receipt is what a voter keeps to prove later that a ballot was cast and counted
+type Receipt struct {
+	TxID        string // hex
+	Txn         []byte // the signed txn, see blockchain.Transaction.Serialize
+	Signature   string // hex
+	PublicKey   string // hex
+	SubmittedAt time.Time
+	MinerAddr   string   // first miner that accepted the ballot
+	AckedBy     []string // every miner that accepted the ballot, see EV.AckQuorum
+	MinerList   []string // miners known to the client at submission
+}
+
+// ReceiptStatus is the outcome of checking a receipt against a chain
+type ReceiptStatus struct {
+	BlockHash    string // hex
+	BlockNum     uint32
+	NumConfirmed int
+	Counted      bool // whether the ballot is deep enough in the longest chain to be tallied
+}
+
+// NewReceipt makes the receipt of a submitted txn
+func NewReceipt(txn blockChain.Transaction, submittedAt time.Time, ackedBy []string, minerList []string) *Receipt {
+	return &Receipt{
+		TxID:        hex.EncodeToString(txn.ID),
+		Txn:         txn.Serialize(),
+		Signature:   hex.EncodeToString(txn.Signature),
+		PublicKey:   hex.EncodeToString(txn.PublicKey),
+		SubmittedAt: submittedAt,
+		MinerAddr:   ackedBy[0],
+		AckedBy:     append([]string(nil), ackedBy...),
+		MinerList:   append([]string(nil), minerList...),
+	}
+}
+
+// Save writes the receipt to dir as receipt_<txid>.json and returns its path
+func (r *Receipt) Save(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "receipt_"+r.TxID+".json")
+	return path, ioutil.WriteFile(path, data, 0600)
+}
+
+// LoadReceipt reads a receipt written by Save
+func LoadReceipt(path string) (*Receipt, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Receipt{}
+	if err = json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return r, nil
+}
+
+// Transaction decodes the txn of the receipt and checks it against the other fields and its signature
+func (r *Receipt) Transaction() (txn blockChain.Transaction, err error) {
+	defer func() {
+		if recover() != nil {
+			err = errors.New("receipt holds a malformed txn")
+		}
+	}()
+	txn = blockChain.DeserializeTransaction(r.Txn)
+	if hex.EncodeToString(txn.ID) != r.TxID || hex.EncodeToString(txn.Signature) != r.Signature ||
+		hex.EncodeToString(txn.PublicKey) != r.PublicKey {
+		return txn, errors.New("receipt fields do not match its txn")
+	}
+	if txn.Data == nil || !txn.Verify() {
+		return txn, errors.New("receipt txn has an invalid signature")
+	}
+	if !bytes.Equal(txn.ID, txn.ComputeID()) {
+		return txn, errors.New("receipt txn ID does not match its ballot")
+	}
+	return txn, nil
+}
+
+// VerifyReceipt checks that the ballot of a receipt is on the longest chain of chain, unchanged
+func VerifyReceipt(chain *blockChain.BlockChain, r *Receipt) (*ReceiptStatus, error) {
+	txn, err := r.Transaction()
+	if err != nil {
+		return nil, err
+	}
+	found, block, numConfirmed := chain.FindTxn(txn.ID)
+	if found == nil {
+		return nil, errors.New("ballot is not on the longest chain")
+	}
+	if !bytes.Equal(found.CanonicalEncoding(), txn.CanonicalEncoding()) {
+		return nil, errors.New("ballot on the chain differs from the receipt")
+	}
+	return &ReceiptStatus{
+		BlockHash:    hex.EncodeToString(block.Hash),
+		BlockNum:     block.BlockNum,
+		NumConfirmed: numConfirmed,
+		Counted:      numConfirmed >= chain.RequiredConfirmations(),
+	}, nil
+}
@@ -0,0 +1,104 @@
+package evlib
+
+import (
+	blockChain "cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/keystore"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Register API registers a voter with coord in an election with a registrar, before the voter's first ballot.
+// proof is what the RegistrationVerifier of coord checks, e.g. the registration code of the voter. The
+// credential coord returns is attached to every ballot of the voter from then on. Nothing to do in an election
+// without a registrar
+func (d *EV) Register(voterName string, voterStudentID string, proof []byte) error {
+	d.rw.RLock()
+	required := d.registration.Required()
+	d.rw.RUnlock()
+	if !required {
+		return nil
+	}
+	ballot := blockChain.Ballot{VoterName: voterName, VoterStudentID: voterStudentID}
+	trace := blockvote.CreateTrace(d.tracer)
+	if err := d.ensureVoterWallet(ballot, trace); err != nil {
+		return err
+	}
+	publicKey, err := d.voterPublicKey(ballot)
+	if err != nil {
+		return err
+	}
+
+	var reply blockvote.RegisterVoterReply
+	d.connRw.RLock()
+	err = d.call(d.coordClient, blockvote.Scoped(d.ElectionID, "CoordAPIClient.RegisterVoter"), blockvote.RegisterVoterArgs{
+		StudentID: voterStudentID,
+		Proof:     proof,
+		PublicKey: publicKey,
+	}, &reply)
+	d.connRw.RUnlock()
+	if err != nil {
+		return typedError(err)
+	}
+	d.rw.RLock()
+	genesis := d.genesis
+	registrarKey := d.registration.RegistrarKey
+	d.rw.RUnlock()
+	if !blockChain.VerifyCredential(registrarKey, genesis, publicKey, reply.Credential) {
+		return errors.New("coord returned a credential of another key or election")
+	}
+
+	d.ifRw.Lock()
+	if d.credentials == nil {
+		d.credentials = make(map[string][]byte)
+	}
+	d.credentials[hex.EncodeToString(publicKey)] = reply.Credential
+	d.ifRw.Unlock()
+	return nil
+}
+
+// isDevVoter checks whether the student ID votes without a credential on a dev chain. d.rw must be held
+func (d *EV) isDevVoter(studentID string) bool {
+	for _, id := range d.registration.DevVoters {
+		if id == studentID {
+			return true
+		}
+	}
+	return false
+}
+
+// voterPublicKey returns the public key the voter signs its ballots with
+func (d *EV) voterPublicKey(ballot blockChain.Ballot) ([]byte, error) {
+	if d.keystore != nil {
+		key := keystore.KeyID{Election: d.ElectionID, VoterName: ballot.VoterName, VoterStudentID: ballot.VoterStudentID}
+		publicKey, err := d.keystore.PublicKey(key, true)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: %v", err)
+		}
+		return publicKey, nil
+	}
+	voterWallet, addr := d.findWalletAndAddr(ballot)
+	if addr == "" {
+		return nil, errors.New("Not such a voter exists.\n")
+	}
+	return voterWallet.Wallets[addr].PublicKey, nil
+}
+
+// credentialOf returns the credential of a voter's key to attach to its txns. None in an election without a
+// registrar or for a dev voter, ErrNotRegistered if the voter did not Register
+func (d *EV) credentialOf(ballot blockChain.Ballot, publicKey []byte) ([]byte, error) {
+	d.rw.RLock()
+	required := d.registration.Required() && !d.isDevVoter(ballot.VoterStudentID)
+	d.rw.RUnlock()
+	if !required {
+		return nil, nil
+	}
+	d.ifRw.RLock()
+	defer d.ifRw.RUnlock()
+	credential, ok := d.credentials[hex.EncodeToString(publicKey)]
+	if !ok {
+		return nil, ErrNotRegistered
+	}
+	return credential, nil
+}
@@ -0,0 +1,117 @@
+package evlib
+
+import (
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/metrics"
+)
+
+// With CollectStats, every RPC the instance makes to coord or a miner is timed into a latency histogram of its
+// type, and its errors are counted, e.g. client_coord_query_txn_duration_seconds and
+// client_miner_submit_txn_errors_total. Long polls (WaitReorg) are left out, their latency is the wait
+
+// statsRPCs are the RPC types whose metrics exist from the start, so that they are exported before the first call
+var statsRPCs = []string{"CoordAPIClient.GetMinerList", "CoordAPIClient.QueryTxn", "CoordAPIClient.QueryTxns",
+	"CoordAPIClient.QueryResults", "MinerAPIClient.SubmitTxn"}
+
+// rpcStats holds the metrics of every RPC type called so far
+type rpcStats struct {
+	reg   *metrics.Registry
+	mu    sync.Mutex
+	types map[string]*rpcTypeStats
+}
+
+type rpcTypeStats struct {
+	latency *metrics.Histogram
+	errors  *metrics.Counter
+}
+
+func newRPCStats() *rpcStats {
+	s := &rpcStats{reg: metrics.NewRegistry(), types: make(map[string]*rpcTypeStats)}
+	for _, method := range statsRPCs {
+		s.of(method)
+	}
+	return s
+}
+
+// of returns the metrics of the RPC type of method, registering them on its first call
+func (s *rpcStats) of(method string) *rpcTypeStats {
+	name := statsName(method)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.types[name]
+	if !ok {
+		t = &rpcTypeStats{
+			latency: s.reg.NewHistogram("client_"+name+"_duration_seconds",
+				"Latency of "+method+" calls made by the client.", metrics.DefaultLatencyBuckets),
+			errors: s.reg.NewCounter("client_"+name+"_errors_total",
+				"Number of "+method+" calls made by the client that failed."),
+		}
+		s.types[name] = t
+	}
+	return t
+}
+
+// statsName turns an RPC method, scoped to an election or not, into a metric name: the node it goes to and the
+// method in snake case, e.g. coord_query_txn for e1.CoordAPIClient.QueryTxn
+func statsName(method string) string {
+	parts := strings.Split(method, ".")
+	if len(parts) < 2 {
+		return snakeCase(method)
+	}
+	service, name := parts[len(parts)-2], parts[len(parts)-1]
+	switch {
+	case strings.HasPrefix(service, "Coord"):
+		service = "coord"
+	case strings.HasPrefix(service, "Miner"):
+		service = "miner"
+	default:
+		service = snakeCase(service)
+	}
+	return service + "_" + snakeCase(name)
+}
+
+func snakeCase(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// a new word starts at an upper case letter, unless it continues an acronym
+			if i > 0 && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// call is blockvote.Call, recording its latency and outcome when CollectStats is set
+func (d *EV) call(client *rpc.Client, method string, args interface{}, reply blockvote.Reply) error {
+	if d.stats == nil {
+		return blockvote.Call(client, method, args, reply)
+	}
+	start := time.Now()
+	err := blockvote.Call(client, method, args, reply)
+	t := d.stats.of(method)
+	t.latency.ObserveSince(start)
+	if err != nil {
+		t.errors.Inc()
+	}
+	return err
+}
+
+// Metrics returns the latency histograms and error counts of the RPCs made so far, nil unless CollectStats was
+// set before Start. Registry.WriteText exports them in the Prometheus text format, Registry.Serve serves them
+func (d *EV) Metrics() *metrics.Registry {
+	if d.stats == nil {
+		return nil
+	}
+	return d.stats.reg
+}
@@ -37,6 +37,7 @@ type HBeatMessage struct {
 type AckMessage struct {
 	HBEatEpochNonce uint64 // Copy of what was received in the heartbeat.
 	HBEatSeqNum     uint64 // Copy of what was received in the heartbeat.
+	Time            int64  // Responder's clock when it acked, in Unix nanoseconds. 0 from old responders.
 }
 
 // Notification of a failure, signal back to the client using this
@@ -56,26 +57,60 @@ type StartStruct struct {
 }
 
 ////////////////////////////////////////////////////// VARIABLE
-var stop chan int // signal go routines to stop
-var mu sync.Mutex
-var nRoutines int // indicate the number of routines fcheck lib currently runs
 
-// cache
-var localHBAddr *net.UDPAddr
-var epochNonce uint64
-var lostMsgThresh uint8
-var notify chan FailureDetected
+// Checker is one instance of the fcheck library. Several checkers can run in the same process.
+type Checker struct {
+	stop      chan struct{} // closed to signal go routines to stop
+	mu        sync.Mutex
+	nRoutines int // indicate the number of routines fcheck lib currently runs
+
+	// cache
+	localHBAddr   *net.UDPAddr
+	epochNonce    uint64
+	lostMsgThresh uint8
+	notify        chan FailureDetected
+	lastAck       map[string]time.Time     // when each monitored node last acked a heartbeat. guarded by mu
+	offset        map[string]time.Duration // estimated offset of each monitored node's clock from ours. guarded by mu
+}
+
+// the checker used by the package level functions
+var defaultChecker = New()
+
+func New() *Checker {
+	return &Checker{}
+}
 
 // Starts the fcheck library.
 
 func Start(arg StartStruct) (ackLocalPort string, notifyCh <-chan FailureDetected, err error) {
-	mu.Lock()
-	defer mu.Unlock()
-	if nRoutines > 0 {
+	return defaultChecker.Start(arg)
+}
+
+func NewRemote(remoteIpPort string) error {
+	return defaultChecker.NewRemote(remoteIpPort)
+}
+
+func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDetected) {
+	defaultChecker.Monitor(conn, remoteIpPort, notifyCh)
+}
+
+func Respond(conn *net.UDPConn) {
+	defaultChecker.Respond(conn)
+}
+
+// Tells the library to stop monitoring/responding acks.
+func Stop() {
+	defaultChecker.Stop()
+}
+
+func (c *Checker) Start(arg StartStruct) (ackLocalPort string, notifyCh <-chan FailureDetected, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nRoutines > 0 {
 		return "", nil, errors.New("fcheck library has already been started")
 	}
 	// resolve local ack address
-	localAckAddr, err := net.ResolveUDPAddr("udp", arg.LocalIP+":0")
+	localAckAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(arg.LocalIP, "0"))
 	if err != nil { // check inappropriate ip:port values
 		return "", nil, errors.New("invalid ip for local ack address: " + arg.LocalIP)
 	}
@@ -88,7 +123,7 @@ func Start(arg StartStruct) (ackLocalPort string, notifyCh <-chan FailureDetecte
 	ackLocalPort = strconv.Itoa(ackConn.LocalAddr().(*net.UDPAddr).Port)
 
 	// create signal channel for stopping
-	stop = make(chan int, len(arg.HBeatRemoteIPHBeatRemotePortList))
+	c.stop = make(chan struct{})
 
 	if arg.HBeatRemoteIPHBeatRemotePortList == nil {
 		// ONLY arg.AckLocalIP is set
@@ -96,19 +131,19 @@ func Start(arg StartStruct) (ackLocalPort string, notifyCh <-chan FailureDetecte
 		// Start fcheck without monitoring any node, but responding to heartbeats.
 		// TODO
 		// start responding go routine
-		go Respond(ackConn)
+		go c.Respond(ackConn)
 		return ackLocalPort, nil, nil
 	}
 	// Else: ALL fields in arg are set
 	// Start the fcheck library by monitoring a single node and
 	// also responding to heartbeats.
 
-	epochNonce = arg.EpochNonce
-	lostMsgThresh = arg.LostMsgThresh
+	c.epochNonce = arg.EpochNonce
+	c.lostMsgThresh = arg.LostMsgThresh
 
 	// TODO
 	// resolve addresses
-	localHBAddr, err = net.ResolveUDPAddr("udp", arg.LocalIP+":0")
+	c.localHBAddr, err = net.ResolveUDPAddr("udp", net.JoinHostPort(arg.LocalIP, "0"))
 	if err != nil {
 		ackConn.Close()
 		return "", nil, errors.New("invalid ip for local udp address: " + arg.LocalIP)
@@ -121,7 +156,7 @@ func Start(arg StartStruct) (ackLocalPort string, notifyCh <-chan FailureDetecte
 			continue
 		}
 		// dial udp
-		hbConn, err := net.DialUDP("udp", localHBAddr, remoteHBAddr)
+		hbConn, err := net.DialUDP("udp", c.localHBAddr, remoteHBAddr)
 		if err != nil {
 			log.Println("[WARN] fcheck is unable to dial", remoteIPPort)
 			continue
@@ -129,17 +164,17 @@ func Start(arg StartStruct) (ackLocalPort string, notifyCh <-chan FailureDetecte
 		hbConns = append(hbConns, hbConn)
 	}
 
-	notify = make(chan FailureDetected, 100) // must have capacity of at least 1
-	go Respond(ackConn)
+	c.notify = make(chan FailureDetected, 100) // must have capacity of at least 1
+	go c.Respond(ackConn)
 	for idx, hbConn := range hbConns {
-		go Monitor(hbConn, arg.HBeatRemoteIPHBeatRemotePortList[idx], notify)
+		go c.Monitor(hbConn, arg.HBeatRemoteIPHBeatRemotePortList[idx], c.notify)
 	}
 
-	return ackLocalPort, notify, nil
+	return ackLocalPort, c.notify, nil
 }
 
-func NewRemote(remoteIpPort string) error {
-	if nRoutines == 0 {
+func (c *Checker) NewRemote(remoteIpPort string) error {
+	if c.nRoutines == 0 {
 		return errors.New("fcheck is not started")
 	}
 	remoteHBAddr, err := net.ResolveUDPAddr("udp", remoteIpPort)
@@ -147,18 +182,18 @@ func NewRemote(remoteIpPort string) error {
 		return errors.New("fcheck is unable to resolve address " + remoteIpPort)
 	}
 	// dial udp
-	hbConn, err := net.DialUDP("udp", localHBAddr, remoteHBAddr)
+	hbConn, err := net.DialUDP("udp", c.localHBAddr, remoteHBAddr)
 	if err != nil {
 		return errors.New("fcheck is unable to dial " + remoteIpPort)
 	}
-	go Monitor(hbConn, remoteIpPort, notify)
+	go c.Monitor(hbConn, remoteIpPort, c.notify)
 	return nil
 }
 
-func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDetected) {
-	mu.Lock()
-	nRoutines++
-	mu.Unlock()
+func (c *Checker) Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDetected) {
+	c.mu.Lock()
+	c.nRoutines++
+	c.mu.Unlock()
 	defer conn.Close()
 	var lostCount uint8 = 0
 	var hbSeqNum uint64 = 0 // identifier that is an arbitrary number which uniquely identifies the heartbeat in an epoch.
@@ -167,14 +202,14 @@ func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDete
 	for {
 		// populate heartbeat message
 		hbMsg := HBeatMessage{
-			EpochNonce: epochNonce,
+			EpochNonce: c.epochNonce,
 			SeqNum:     hbSeqNum,
 		}
 		// send heartbeat
 		//for i := 0; i < rand.New(rand.NewSource(time.Now().UnixNano())).Intn(2); i++ {
 		//	conn.Write(encodeHBeatMessage(&hbMsg))
 		//	//for i := 0; i < rand.New(rand.NewSource(time.Now().UnixNano())).Intn(2); i++ {
-		//	//	conn.Write(encodeHBeatMessage(&HBeatMessage{epochNonce, 0}))
+		//	//	conn.Write(encodeHBeatMessage(&HBeatMessage{c.epochNonce, 0}))
 		//	//}
 		//}
 		conn.Write(encodeHBeatMessage(&hbMsg))
@@ -200,13 +235,22 @@ func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDete
 				break
 			}
 			ackMsg = decodeAckMessage(recBuf, length)
-			if ackMsg.HBEatEpochNonce == epochNonce { // must ignore all acks that it receives that do not reference this latest EpochNonce.
+			if ackMsg.HBEatEpochNonce == c.epochNonce { // must ignore all acks that it receives that do not reference this latest EpochNonce.
 				//log.Println("[fcheck] Received ack #", ackMsg.HBEatSeqNum)
 				// When an ack message is received (even after the RTT timeout), the count of lost msgs must be reset to 0.
 				lostCount = 0
 				acked = true
+				c.mu.Lock()
+				if c.lastAck == nil {
+					c.lastAck = make(map[string]time.Time)
+				}
+				c.lastAck[remoteIpPort] = time.Now()
+				c.mu.Unlock()
 				// update RTT estimator
 				if t, ok := sentTime[ackMsg.HBEatSeqNum]; ok {
+					if ackMsg.Time != 0 {
+						c.updateOffset(remoteIpPort, t, time.Now(), ackMsg.Time)
+					}
 					//rtt = (rtt + time.Now().Nanosecond()/int(time.Microsecond) - t) / 2
 					rtt = (rtt + time.Since(t).Microseconds()) / 2
 					delete(sentTime, ackMsg.HBEatSeqNum)
@@ -219,19 +263,19 @@ func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDete
 		}
 
 		select {
-		case <-stop: // received signal from Stop() function
+		case <-c.stop: // received signal from Stop() function
 			// After the call to Stop() has returned, no failure notifications must be generated.
 			conn.Close()
-			mu.Lock()
-			nRoutines--
-			mu.Unlock()
+			c.mu.Lock()
+			c.nRoutines--
+			c.mu.Unlock()
 			return
 		default:
 			if !acked {
 				// If an ack message is not received in the appropriate RTT timeout interval, then the count of lost msgs should be incremented by 1.
 				lostCount++
 				//log.Println("[fcheck] Message lost count:", lostCount)
-				if lostCount >= lostMsgThresh {
+				if lostCount >= c.lostMsgThresh {
 					//log.Println("[fcheck] Server at " + remoteIpPort + " failed.")
 					// If a node X was detected as failed, then
 					// (1) exactly one failure notification must be generated
@@ -242,9 +286,9 @@ func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDete
 					notifyCh <- failureDetected
 					// (2) the library must stop monitoring node X after generating the notification.
 					conn.Close()
-					mu.Lock()
-					nRoutines--
-					mu.Unlock()
+					c.mu.Lock()
+					c.nRoutines--
+					c.mu.Unlock()
 					return
 				}
 			}
@@ -252,10 +296,10 @@ func Monitor(conn *net.UDPConn, remoteIpPort string, notifyCh chan<- FailureDete
 	}
 }
 
-func Respond(conn *net.UDPConn) {
-	mu.Lock()
-	nRoutines++
-	mu.Unlock()
+func (c *Checker) Respond(conn *net.UDPConn) {
+	c.mu.Lock()
+	c.nRoutines++
+	c.mu.Unlock()
 	defer conn.Close()
 	for {
 		// read
@@ -265,12 +309,12 @@ func Respond(conn *net.UDPConn) {
 
 		// select channel
 		select {
-		case <-stop: // received signal from Stop() function
+		case <-c.stop: // received signal from Stop() function
 			// After the call to Stop() has returned, heartbeats to the library should not be acknowledged.
 			conn.Close()
-			mu.Lock()
-			nRoutines--
-			mu.Unlock()
+			c.mu.Lock()
+			c.nRoutines--
+			c.mu.Unlock()
 			return
 		default:
 			// process read result
@@ -281,6 +325,7 @@ func Respond(conn *net.UDPConn) {
 				ackMessage := AckMessage{
 					HBEatEpochNonce: hbMessage.EpochNonce,
 					HBEatSeqNum:     hbMessage.SeqNum,
+					Time:            time.Now().UnixNano(),
 				}
 				//log.Println("[fcheck] Received heartbeat message for Epoch #", hbMessage.EpochNonce,
 				//	"Sequence #", hbMessage.SeqNum)
@@ -291,19 +336,61 @@ func Respond(conn *net.UDPConn) {
 	}
 }
 
-// Tells the library to stop monitoring/responding acks.
-func Stop() {
-	if nRoutines == 0 {
+// LastAck returns when the node at remoteIpPort last acked a heartbeat. zero if it never did
+func (c *Checker) LastAck(remoteIpPort string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastAck[remoteIpPort]
+}
+
+// updateOffset folds the offset measured by a heartbeat sent at sent and acked at acked into the estimate of
+// the node's clock offset. the node is assumed to have acked halfway through the round trip
+func (c *Checker) updateOffset(remoteIpPort string, sent, acked time.Time, remoteTime int64) {
+	measured := time.Unix(0, remoteTime).Sub(sent.Add(acked.Sub(sent) / 2))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.offset == nil {
+		c.offset = make(map[string]time.Duration)
+	}
+	if old, ok := c.offset[remoteIpPort]; ok {
+		measured = (old + measured) / 2
+	}
+	c.offset[remoteIpPort] = measured
+}
+
+// ClockOffset returns how far the clock of the node at remoteIpPort is ahead of ours, negative if it is behind.
+// zero until the node acked a heartbeat with its time
+func (c *Checker) ClockOffset(remoteIpPort string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset[remoteIpPort]
+}
+
+// Tells the checker to stop monitoring/responding acks.
+// Closing the stop channel reaches every go routine, including one that started after the count was read or
+// one that already quit after detecting a failure, which counting out stop signals did not.
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	if c.nRoutines == 0 {
+		c.mu.Unlock()
 		return
 	}
-	for i := 0; i < nRoutines; i++ {
-		stop <- 1
+	select {
+	case <-c.stop: // stopped by another call
+	default:
+		close(c.stop)
 	}
+	c.mu.Unlock()
 	// wait for go routine to exit
-	for nRoutines != 0 {
+	for c.running() != 0 {
 		time.Sleep(10 * time.Millisecond)
 	}
-	close(stop)
+}
+
+func (c *Checker) running() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nRoutines
 }
 
 func encodeAckMessage(ackMsg *AckMessage) []byte {
@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/DistributedClocks/tracing v0.0.0-20220202233639-0154e31ea72b
 	github.com/dgraph-io/badger/v3 v3.2103.2
+	github.com/golang/snappy v0.0.3
 	github.com/mr-tron/base58 v1.2.0
 	golang.org/x/crypto v0.0.0-20220331220935-ae2d96664a29
 )
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
-	"net/rpc"
+	"net"
 	"sync"
 	"time"
 )
@@ -56,28 +56,39 @@ type PendingPush struct { // this struct ensures update to be the last of update
 	UpdateLog []string
 }
 
-var (
+// Client is a gossip client. Several clients can run in the same process.
+type Client struct {
 	verbose         bool
 	running         bool
 	mode            string // operating mode of gossip protocol. ["Push", "PushPull", "Pull"]
 	identity        string // gossip client identifier.
 	localListenAddr string
+	listener        net.Listener
 
-	QueryChan  chan<- Update // for gossip client to query updates
-	UpdateChan <-chan Update // for gossip client to put updates
+	queryChan  chan<- Update // for gossip client to query updates
+	updateChan <-chan Update // for gossip client to put updates
 
-	PendingPushQueue chan PendingPush // pending updates (from the client or peers) that need to be pushed
+	pendingPushQueue chan PendingPush // pending updates (from the client or peers) that need to be pushed
 
-	rw        sync.RWMutex
-	UpdateMap map[string]Update // stores every update
-	UpdateLog []string          // update id history
-	FanOut    uint8             // number of connections
-	PeerList  []string          // peer addresses
+	rw         sync.RWMutex
+	updateMap  map[string]Update      // stores every update
+	updateLog  []string               // update id history
+	fanOut     uint8                  // number of connections
+	peerList   []string               // peer addresses
+	peerFilter func(peer string) bool // peers for which it returns false are not contacted. all peers if nil
 
-	ExitSignal chan int
-)
+	exitSignal chan int
+}
 
 type RPCHandler struct {
+	g *Client
+}
+
+// the client used by the package level functions
+var defaultClient = NewClient()
+
+func NewClient() *Client {
+	return &Client{}
 }
 
 func Start(fanOut uint8, // number of connections
@@ -88,90 +99,136 @@ func Start(fanOut uint8, // number of connections
 	clientIdentity string, // gossip client identifier
 	logging bool, // whether to print
 ) (queryChan <-chan Update, updateChan chan<- Update, localAddr string, err error) {
-	if running {
+	return defaultClient.Start(fanOut, operatingMode, localIp, initialUpdates, clientIdentity, logging)
+}
+
+func SetPeers(peers []string) {
+	defaultClient.SetPeers(peers)
+}
+
+func AddPeer(peer string) {
+	defaultClient.AddPeer(peer)
+}
+
+func RemovePeer(peer string) {
+	defaultClient.RemovePeer(peer)
+}
+
+func (g *Client) Start(fanOut uint8, // number of connections
+	operatingMode string, // operating mode of gossip protocol. ["Push", "PushPull", "Pull"]
+	localIp string,
+	initialUpdates []Update, // all updates client has to date
+	clientIdentity string, // gossip client identifier
+	logging bool, // whether to print
+) (queryChan <-chan Update, updateChan chan<- Update, localAddr string, err error) {
+	if g.running {
 		return nil, nil, "", errors.New("[ERROR] gossip service already running")
 	}
-	running = true
-	mode = operatingMode
-	identity = clientIdentity
-	verbose = logging
+	if operatingMode != "Push" && operatingMode != "PushPull" && operatingMode != "Pull" {
+		return nil, nil, "", errors.New("[Error] unexpected gossip mode")
+	}
+	g.running = true
+	g.mode = operatingMode
+	g.identity = clientIdentity
+	g.verbose = logging
 
 	qCh := make(chan Update, 500)
 	uCh := make(chan Update, 500)
 
-	QueryChan = qCh
-	UpdateChan = uCh
-	PendingPushQueue = make(chan PendingPush, 100)
-	UpdateMap = make(map[string]Update)
-	UpdateLog = []string{}
-	FanOut = fanOut
-	ExitSignal = make(chan int, 2)
+	g.queryChan = qCh
+	g.updateChan = uCh
+	g.pendingPushQueue = make(chan PendingPush, 100)
+	g.updateMap = make(map[string]Update)
+	g.updateLog = []string{}
+	g.fanOut = fanOut
+	g.exitSignal = make(chan int)
 
 	// unpack initial updates
 	for _, update := range initialUpdates {
-		UpdateMap[update.ID] = update
-		UpdateLog = append(UpdateLog, update.ID)
+		g.updateMap[update.ID] = update
+		g.updateLog = append(g.updateLog, update.ID)
 	}
 
-	handler := new(RPCHandler)
-	localListenAddr, err = util.NewRPCServerWithIp(handler, localIp)
+	handler := &RPCHandler{g: g}
+	g.listener, err = util.ListenRPC(handler, util.AnyPort(localIp))
 	if err != nil {
 		return nil, nil, "", err
 	}
-	Verbose("listen to gossips at " + localListenAddr)
+	g.localListenAddr = util.ListenerAddr(localIp, g.listener)
+	g.Verbose("listen to gossips at " + g.localListenAddr)
 	//SetPeers(peers) // set peers should be called only after local address is assigned
 
-	go DigestLocalUpdateService()
+	go g.DigestLocalUpdateService()
 
-	if operatingMode == "Push" {
-		go PushService()
-	} else if operatingMode == "PushPull" {
-		go PushService()
-	} else if operatingMode == "Pull" {
-		go PullService()
+	if operatingMode == "Pull" {
+		go g.PullService()
 	} else {
-		return nil, nil, "", errors.New("[Error] unexpected gossip mode")
+		go g.PushService()
 	}
 
-	return qCh, uCh, localListenAddr, nil
+	return qCh, uCh, g.localListenAddr, nil
 }
 
-func SetPeers(peers []string) {
-	rw.Lock()
-	defer rw.Unlock()
+// Stop stops serving and pushing/pulling updates
+func (g *Client) Stop() {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	if !g.running {
+		return
+	}
+	g.running = false
+	g.listener.Close()
+	close(g.exitSignal)
+}
+
+func (g *Client) SetPeers(peers []string) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
 	// find self
 	i := 0
 	for ; i < len(peers); i++ {
-		if peers[i] == localListenAddr {
+		if peers[i] == g.localListenAddr {
 			break
 		}
 	}
 	// exclude self
 	if i < len(peers) {
-		PeerList = append(peers[:i], peers[i+1:]...)
+		g.peerList = append(peers[:i], peers[i+1:]...)
 	}
 }
 
-func AddPeer(peer string) {
-	rw.Lock()
-	defer rw.Unlock()
-	if peer != localListenAddr {
-		PeerList = append(PeerList, peer)
+func (g *Client) AddPeer(peer string) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	if peer != g.localListenAddr {
+		g.peerList = append(g.peerList, peer)
 	}
 }
 
-func RemovePeer(peer string) {
-	rw.Lock()
-	defer rw.Unlock()
-	for idx, addr := range PeerList { // coord can also be removed, as it will send its new addr when it re-start
+func (g *Client) RemovePeer(peer string) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	for idx, addr := range g.peerList { // coord can also be removed, as it will send its new addr when it re-start
 		if addr == peer {
-			PeerList = append(PeerList[:idx], PeerList[idx+1:]...)
-			Verbose("peer (" + peer + ") is detected as failed and is removed.")
+			g.peerList = append(g.peerList[:idx], g.peerList[idx+1:]...)
+			g.Verbose("peer (" + peer + ") is detected as failed and is removed.")
 			break
 		}
 	}
 }
 
+// SetPeerFilter restricts which peers are contacted, e.g. to simulate a network partition. nil allows all peers.
+func (g *Client) SetPeerFilter(filter func(peer string) bool) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+	g.peerFilter = filter
+}
+
+// Addr returns the address gossips are served at
+func (g *Client) Addr() string {
+	return g.localListenAddr
+}
+
 func NewUpdate(prefix string, hash []byte, data []byte) Update {
 	return Update{
 		ID:   prefix + fmt.Sprintf("%x", hash),
@@ -180,32 +237,33 @@ func NewUpdate(prefix string, hash []byte, data []byte) Update {
 }
 
 func (handler *RPCHandler) Push(args PushArgs, reply *PushReply) error {
+	g := handler.g
 	// check missing updates
 	var missing []string
-	rw.RLock()
+	g.rw.RLock()
 	for _, id := range args.UpdateLog {
-		if len(UpdateMap[id].ID) == 0 && id != args.Update.ID {
+		if len(g.updateMap[id].ID) == 0 && id != args.Update.ID {
 			// never see this update, and update is not the latest one
 			missing = append(missing, id)
 		}
 	}
-	rw.RUnlock()
+	g.rw.RUnlock()
 
 	// only accept the update if no earlier updates are missing
 	if len(missing) == 0 {
-		rw.Lock()
-		if len(UpdateMap[args.Update.ID].ID) == 0 {
-			UpdateMap[args.Update.ID] = args.Update
-			UpdateLog = append(UpdateLog, args.Update.ID)
-			Verbose("update #" + args.Update.ID + " merged")
-			QueryChan <- args.Update
+		g.rw.Lock()
+		if len(g.updateMap[args.Update.ID].ID) == 0 {
+			g.updateMap[args.Update.ID] = args.Update
+			g.updateLog = append(g.updateLog, args.Update.ID)
+			g.Verbose("update #" + args.Update.ID + " merged")
+			g.queryChan <- args.Update
 			// further, push the update to peers
-			PendingPushQueue <- PendingPush{
+			g.pendingPushQueue <- PendingPush{
 				Update:    args.Update,
-				UpdateLog: UpdateLog,
+				UpdateLog: g.updateLog,
 			}
 		}
-		rw.Unlock()
+		g.rw.Unlock()
 	} else {
 		missing = append(missing, args.Update.ID)
 	}
@@ -217,42 +275,43 @@ func (handler *RPCHandler) Push(args PushArgs, reply *PushReply) error {
 }
 
 func (handler *RPCHandler) PushPull(args PushPullArgs, reply *PushPullReply) error {
+	g := handler.g
 	// 1. Push
 	// check missing updates
 	var missing []string
-	rw.RLock()
+	g.rw.RLock()
 	for _, id := range args.UpdateLog {
-		if len(UpdateMap[id].ID) == 0 && id != args.Update.ID {
+		if len(g.updateMap[id].ID) == 0 && id != args.Update.ID {
 			// never see this update, and update is not the latest one
 			missing = append(missing, id)
 		}
 	}
-	rw.RUnlock()
+	g.rw.RUnlock()
 
 	// only accept the update if no earlier updates are missing
 	if len(missing) == 0 {
-		rw.Lock()
-		if len(UpdateMap[args.Update.ID].ID) == 0 {
-			UpdateMap[args.Update.ID] = args.Update
-			UpdateLog = append(UpdateLog, args.Update.ID)
-			Verbose("update #" + args.Update.ID + " merged")
-			QueryChan <- args.Update
+		g.rw.Lock()
+		if len(g.updateMap[args.Update.ID].ID) == 0 {
+			g.updateMap[args.Update.ID] = args.Update
+			g.updateLog = append(g.updateLog, args.Update.ID)
+			g.Verbose("update #" + args.Update.ID + " merged")
+			g.queryChan <- args.Update
 			// further, push the update to peers
-			PendingPushQueue <- PendingPush{
+			g.pendingPushQueue <- PendingPush{
 				Update:    args.Update,
-				UpdateLog: UpdateLog,
+				UpdateLog: g.updateLog,
 			}
 		}
-		rw.Unlock()
+		g.rw.Unlock()
 	} else {
 		missing = append(missing, args.Update.ID)
 	}
 
 	// 2. Pull
 	// check what updates peer is missing
-	rw.RLock()
-	localLog := UpdateLog[:]
-	rw.RUnlock()
+	g.rw.RLock()
+	localLog := g.updateLog[:]
+	g.rw.RUnlock()
 	peerMap := make(map[string]bool)
 	for _, id := range args.UpdateLog {
 		peerMap[id] = true
@@ -262,17 +321,18 @@ func (handler *RPCHandler) PushPull(args PushPullArgs, reply *PushPullReply) err
 	*reply = PushPullReply{MissingUpdates: missing}
 	for _, id := range localLog {
 		if !peerMap[id] {
-			reply.Updates = append(reply.Updates, UpdateMap[id])
+			reply.Updates = append(reply.Updates, g.updateMap[id])
 		}
 	}
 	return nil
 }
 
 func (handler *RPCHandler) Pull(args PullArgs, reply *PullReply) error {
+	g := handler.g
 	// check what updates peer is missing
-	rw.RLock()
-	localLog := UpdateLog[:]
-	rw.RUnlock()
+	g.rw.RLock()
+	localLog := g.updateLog[:]
+	g.rw.RUnlock()
 	peerMap := make(map[string]bool)
 	for _, id := range args.UpdateLog {
 		peerMap[id] = true
@@ -282,7 +342,7 @@ func (handler *RPCHandler) Pull(args PullArgs, reply *PullReply) error {
 	*reply = PullReply{}
 	for _, id := range localLog {
 		if !peerMap[id] {
-			reply.Updates = append(reply.Updates, UpdateMap[id])
+			reply.Updates = append(reply.Updates, g.updateMap[id])
 		}
 	}
 	return nil
@@ -290,66 +350,67 @@ func (handler *RPCHandler) Pull(args PullArgs, reply *PullReply) error {
 
 // Retransmit should follow a Push or PushPull.
 func (handler *RPCHandler) Retransmit(args RetransmitArgs, reply *RetransmitReply) error {
-	rw.Lock()
-	defer rw.Unlock()
+	g := handler.g
+	g.rw.Lock()
+	defer g.rw.Unlock()
 	for _, update := range args.Updates {
-		if len(UpdateMap[update.ID].ID) == 0 {
-			UpdateMap[update.ID] = update
-			UpdateLog = append(UpdateLog, update.ID)
-			Verbose("update #" + update.ID + " merged")
-			QueryChan <- update
+		if len(g.updateMap[update.ID].ID) == 0 {
+			g.updateMap[update.ID] = update
+			g.updateLog = append(g.updateLog, update.ID)
+			g.Verbose("update #" + update.ID + " merged")
+			g.queryChan <- update
 		}
 	}
 	return nil
 }
 
-func DigestLocalUpdateService() {
+func (g *Client) DigestLocalUpdateService() {
 	for {
 		select {
-		case <-ExitSignal:
+		case <-g.exitSignal:
 			return
-		case update := <-UpdateChan:
-			rw.Lock()
-			if len(UpdateMap[update.ID].ID) == 0 {
-				UpdateMap[update.ID] = update
-				UpdateLog = append(UpdateLog, update.ID)
-				Verbose("update #" + update.ID + " added")
-				if mode != "Pull" {
+		case update := <-g.updateChan:
+			g.rw.Lock()
+			if len(g.updateMap[update.ID].ID) == 0 {
+				g.updateMap[update.ID] = update
+				g.updateLog = append(g.updateLog, update.ID)
+				g.Verbose("update #" + update.ID + " added")
+				if g.mode != "Pull" {
 					// need to push the update to peers
-					PendingPushQueue <- PendingPush{
+					g.pendingPushQueue <- PendingPush{
 						Update:    update,
-						UpdateLog: UpdateLog,
+						UpdateLog: g.updateLog,
 					}
 				}
 			}
-			rw.Unlock()
+			g.rw.Unlock()
 		}
 	}
 }
 
-func PushService() {
+func (g *Client) PushService() {
 	for {
 		select {
-		case <-ExitSignal:
+		case <-g.exitSignal:
 			return
-		case pendingPush := <-PendingPushQueue:
-			Verbose("new push cycle (#" + pendingPush.Update.ID + ")")
+		case pendingPush := <-g.pendingPushQueue:
+			g.Verbose("new push cycle (#" + pendingPush.Update.ID + ")")
 			// randomly select peers
-			selectedPeers := SelectPeers()
+			selectedPeers := g.SelectPeers()
 
 			// push to peers
 			for _, peer := range selectedPeers {
 				go func(peerAddr string) {
-					conn, err := rpc.Dial("tcp", peerAddr)
+					conn, err := util.DialRPC(peerAddr)
 					if err != nil || conn == nil {
 						// peer failed. remove peer
-						RemovePeer(peerAddr)
+						g.RemovePeer(peerAddr)
 						return
 					}
-					Verbose("pushing... (#" + pendingPush.Update.ID + ", " + peerAddr + ")")
-					if mode == "Push" {
+					g.Verbose("pushing... (#" + pendingPush.Update.ID + ", " + peerAddr + ")")
+					if g.mode == "Push" {
 						args := PushArgs{
-							Identity:  identity,
+							Identity:  g.identity,
 							Update:    pendingPush.Update,
 							UpdateLog: pendingPush.UpdateLog,
 						}
@@ -357,24 +418,24 @@ func PushService() {
 						err = conn.Call("RPCHandler.Push", args, &reply)
 						if err != nil {
 							// peer failed. remove peer
-							RemovePeer(peerAddr)
+							g.RemovePeer(peerAddr)
 							return
 						}
 						// check if peer request retransmit
 						if len(reply.MissingUpdates) > 0 {
-							args := RetransmitArgs{Identity: identity}
-							rw.RLock()
+							args := RetransmitArgs{Identity: g.identity}
+							g.rw.RLock()
 							for _, id := range reply.MissingUpdates {
-								args.Updates = append(args.Updates, UpdateMap[id])
+								args.Updates = append(args.Updates, g.updateMap[id])
 							}
-							rw.RUnlock()
+							g.rw.RUnlock()
 							reply := RetransmitReply{}
 							_ = conn.Call("RPCHandler.Retransmit", args, &reply)
 						}
-					} else if mode == "PushPull" {
+					} else if g.mode == "PushPull" {
 						time.Sleep(time.Duration(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(2500)) * time.Millisecond)
 						args := PushPullArgs{
-							Identity:  identity,
+							Identity:  g.identity,
 							Update:    pendingPush.Update,
 							UpdateLog: pendingPush.UpdateLog,
 						}
@@ -382,28 +443,28 @@ func PushService() {
 						err = conn.Call("RPCHandler.PushPull", args, &reply)
 						if err != nil {
 							// peer failed. remove peer
-							RemovePeer(peerAddr)
+							g.RemovePeer(peerAddr)
 							return
 						}
 						// add pulled updates first
-						rw.Lock()
+						g.rw.Lock()
 						for _, update := range reply.Updates {
-							if len(UpdateMap[update.ID].ID) == 0 {
-								UpdateMap[update.ID] = update
-								UpdateLog = append(UpdateLog, update.ID)
-								Verbose("update #" + update.ID + " merged")
-								QueryChan <- update
+							if len(g.updateMap[update.ID].ID) == 0 {
+								g.updateMap[update.ID] = update
+								g.updateLog = append(g.updateLog, update.ID)
+								g.Verbose("update #" + update.ID + " merged")
+								g.queryChan <- update
 							}
 						}
-						rw.Unlock()
+						g.rw.Unlock()
 						// then retransmit if requested
 						if len(reply.MissingUpdates) > 0 {
-							args := RetransmitArgs{Identity: identity}
-							rw.RLock()
+							args := RetransmitArgs{Identity: g.identity}
+							g.rw.RLock()
 							for _, id := range reply.MissingUpdates {
-								args.Updates = append(args.Updates, UpdateMap[id])
+								args.Updates = append(args.Updates, g.updateMap[id])
 							}
-							rw.RUnlock()
+							g.rw.RUnlock()
 							reply := RetransmitReply{}
 							_ = conn.Call("RPCHandler.Retransmit", args, &reply)
 						}
@@ -414,39 +475,39 @@ func PushService() {
 	}
 }
 
-func PullService() {
-	replyChan := make(chan []Update, FanOut)
+func (g *Client) PullService() {
+	replyChan := make(chan []Update, g.fanOut)
 	for {
 		// timeout for next cycle
 		time.Sleep(time.Duration(5) * time.Second)
 		select {
-		case <-ExitSignal:
+		case <-g.exitSignal:
 			return
 		default:
-			Verbose("new pull cycle")
+			g.Verbose("new pull cycle")
 			// randomly select peers
-			selectedPeers := SelectPeers()
+			selectedPeers := g.SelectPeers()
 
 			// pull from peers
 			for _, peer := range selectedPeers {
 				go func(peerAddr string) {
-					conn, err := rpc.Dial("tcp", peerAddr)
+					conn, err := util.DialRPC(peerAddr)
 					if err != nil || conn == nil {
-						Verbose("pull failed (" + peerAddr + ")")
+						g.Verbose("pull failed (" + peerAddr + ")")
 						replyChan <- []Update{}
 						return
 					}
-					Verbose("pulling... (" + peerAddr + ")")
-					rw.RLock()
-					args := PullArgs{Identity: identity, UpdateLog: UpdateLog[:]}
-					rw.RUnlock()
+					g.Verbose("pulling... (" + peerAddr + ")")
+					g.rw.RLock()
+					args := PullArgs{Identity: g.identity, UpdateLog: g.updateLog[:]}
+					g.rw.RUnlock()
 					reply := PullReply{}
 					err = conn.Call("RPCHandler.Pull", args, &reply)
 					if err != nil {
-						Verbose("pull failed (" + peerAddr + ")")
+						g.Verbose("pull failed (" + peerAddr + ")")
 						replyChan <- []Update{}
 					} else {
-						Verbose("pull succeeded (" + peerAddr + ")")
+						g.Verbose("pull succeeded (" + peerAddr + ")")
 						replyChan <- reply.Updates
 					}
 				}(peer)
@@ -458,43 +519,48 @@ func PullService() {
 				if len(updates) == 0 {
 					continue
 				}
-				rw.Lock()
+				g.rw.Lock()
 				for _, update := range updates {
-					if len(UpdateMap[update.ID].ID) == 0 {
-						UpdateMap[update.ID] = update
-						UpdateLog = append(UpdateLog, update.ID)
-						Verbose("update #" + update.ID + " merged")
-						QueryChan <- update
+					if len(g.updateMap[update.ID].ID) == 0 {
+						g.updateMap[update.ID] = update
+						g.updateLog = append(g.updateLog, update.ID)
+						g.Verbose("update #" + update.ID + " merged")
+						g.queryChan <- update
 					}
 				}
-				rw.Unlock()
+				g.rw.Unlock()
 			}
-			Verbose("pull cycle ended")
+			g.Verbose("pull cycle ended")
 		}
 	}
 }
 
-func SelectPeers() []string {
-	rw.RLock()
-	peers := PeerList[:]
-	rw.RUnlock()
+func (g *Client) SelectPeers() []string {
+	g.rw.RLock()
+	var peers []string
+	for _, peer := range g.peerList {
+		if g.peerFilter == nil || g.peerFilter(peer) {
+			peers = append(peers, peer)
+		}
+	}
+	g.rw.RUnlock()
 	var selectedPeers []string
 	if len(peers) == 0 {
-		Verbose("no available peers")
-	} else if len(peers) <= int(FanOut) {
+		g.Verbose("no available peers")
+	} else if len(peers) <= int(g.fanOut) {
 		selectedPeers = peers
 	} else {
 		rand.Seed(time.Now().UnixNano())
 		rand.Shuffle(len(peers), func(i, j int) {
 			peers[i], peers[j] = peers[j], peers[i]
 		})
-		selectedPeers = peers[:FanOut]
+		selectedPeers = peers[:g.fanOut]
 	}
 	return selectedPeers
 }
 
-func Verbose(str string) {
-	if verbose {
+func (g *Client) Verbose(str string) {
+	if g.verbose {
 		log.Println("[INFO] gossip: " + str)
 	}
 }
@@ -0,0 +1,187 @@
+package keystore
+
+import (
+	"bytes"
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A keystore agent keeps the voter keys of a client machine in one place, so clients sign ballots through it
+// instead of writing a wallet file per voter. It serves net/rpc over a unix socket, which only local users
+// with access to the socket file can reach.
+
+// ErrUnknownKey is returned by Sign for a voter the agent has no key of
+var ErrUnknownKey = errors.New("no key for this voter")
+
+// KeyID names the key of a voter in an election
+type KeyID struct {
+	Election       string
+	VoterName      string
+	VoterStudentID string
+}
+
+func (id KeyID) String() string {
+	return fmt.Sprintf("%s/%s/%s", id.Election, id.VoterName, id.VoterStudentID)
+}
+
+type (
+	PublicKeyArgs struct {
+		Key    KeyID
+		Create bool // create the key if the voter has none
+	}
+
+	PublicKeyReply struct {
+		PublicKey []byte // nil if the voter has no key and Create is false
+	}
+
+	SignArgs struct {
+		Key    KeyID
+		Digest []byte
+	}
+
+	SignReply struct {
+		Signature []byte
+	}
+)
+
+// Agent holds the keys and answers the RPCs of Client
+type Agent struct {
+	File string // where keys are kept across restarts. kept in memory only if empty
+
+	mu   sync.Mutex
+	keys map[string]*Identity.Wallet
+}
+
+// NewAgent returns an agent with the keys saved in file, if it exists
+func NewAgent(file string) (*Agent, error) {
+	agent := &Agent{File: file, keys: make(map[string]*Identity.Wallet)}
+	if file == "" {
+		return agent, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return agent, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = gob.NewDecoder(bytes.NewReader(data)).Decode(&agent.keys); err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
+
+// PublicKey returns the public key of a voter, creating the key if asked
+func (a *Agent) PublicKey(args PublicKeyArgs, reply *PublicKeyReply) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	wallet, ok := a.keys[args.Key.String()]
+	if !ok && args.Create {
+		wallet = Identity.NewWallet()
+		a.keys[args.Key.String()] = wallet
+		if err := a.save(); err != nil {
+			delete(a.keys, args.Key.String())
+			return err
+		}
+		log.Println("[INFO] Created a key for", args.Key)
+	}
+	if wallet != nil {
+		reply.PublicKey = wallet.PublicKey
+	}
+	return nil
+}
+
+// Sign signs a digest, i.e. a txn ID, with the key of a voter
+func (a *Agent) Sign(args SignArgs, reply *SignReply) error {
+	a.mu.Lock()
+	wallet, ok := a.keys[args.Key.String()]
+	a.mu.Unlock()
+	if !ok {
+		return ErrUnknownKey
+	}
+	signature, err := wallet.Sign(args.Digest)
+	reply.Signature = signature
+	return err
+}
+
+// save writes all keys to File. Must hold mu
+func (a *Agent) save() error {
+	if a.File == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(a.keys); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.File), 0700); err != nil {
+		return err
+	}
+	tmp := a.File + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.File)
+}
+
+// Serve serves the agent at the unix socket path until the returned listener is closed. A socket file left
+// behind by a previous agent is replaced.
+func Serve(agent *Agent, socket string) (net.Listener, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Keystore", agent); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	go server.Accept(listener)
+	return listener, nil
+}
+
+// Client signs with the keys of an agent
+type Client struct {
+	conn *rpc.Client
+}
+
+// Dial connects to the agent at the unix socket path
+func Dial(socket string) (*Client, error) {
+	conn, err := rpc.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// PublicKey returns the public key of a voter, nil if the agent has none and create is false
+func (c *Client) PublicKey(key KeyID, create bool) ([]byte, error) {
+	var reply PublicKeyReply
+	err := c.conn.Call("Keystore.PublicKey", PublicKeyArgs{Key: key, Create: create}, &reply)
+	return reply.PublicKey, err
+}
+
+// Sign signs digest with the key of a voter
+func (c *Client) Sign(key KeyID, digest []byte) ([]byte, error) {
+	var reply SignReply
+	err := c.conn.Call("Keystore.Sign", SignArgs{Key: key, Digest: digest}, &reply)
+	return reply.Signature, err
+}
+
+// Close closes the connection to the agent
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
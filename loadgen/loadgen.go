@@ -0,0 +1,290 @@
+// Package loadgen simulates an election against a running cluster with many concurrent evlib clients.
+package loadgen
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+type Config struct {
+	CoordClientAddr string        // coord's client API
+	CoordMinerAddr  string        // coord's miner API, used to download the chain. no duplicate check if empty
+	ElectionID      string        // election of coord to vote in. the default election if empty
+	Clients         int           // number of concurrent evlib clients
+	Ballots         int           // total number of ballots, split evenly between clients
+	ConfirmTimeout  time.Duration // how long to wait for all ballots to be confirmed after submission
+	PollInterval    time.Duration // time between two status checks of pending ballots
+	Seed            int64
+}
+
+type Latency struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+type Report struct {
+	Clients        int
+	Submitted      int
+	Confirmed      int
+	Lost           int // submitted but not confirmed before the timeout
+	Duplicated     int // ballots that appear more than once on the longest chain
+	SubmitDuration time.Duration
+	Throughput     float64 // submitted ballots per second
+	SubmitLatency  Latency
+	ConfirmLatency Latency
+	LostTxIDs      []string
+}
+
+type ballotRecord struct {
+	ballot       blockchain.Ballot
+	txid         []byte
+	submitTime   time.Time
+	submitLat    time.Duration
+	confirmLat   time.Duration
+	numConfirmed int
+	finalized    bool
+}
+
+// Run casts cfg.Ballots ballots from cfg.Clients clients and waits for them to be confirmed
+func Run(cfg Config) (*Report, error) {
+	if cfg.Clients <= 0 || cfg.Ballots <= 0 {
+		return nil, errors.New("Clients and Ballots must be positive")
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = 2 * time.Second
+	}
+
+	// start all clients first so that the submission phase only measures voting
+	clients := make([]*evlib.EV, cfg.Clients)
+	for i := range clients {
+		clients[i] = evlib.NewEV()
+		if err := clients[i].Start(nil, uint(i+1), cfg.CoordClientAddr, cfg.ElectionID); err != nil {
+			return nil, err
+		}
+		if len(clients[i].CandidateList) == 0 {
+			return nil, errors.New("coord has no candidates")
+		}
+	}
+	defer func() {
+		for _, client := range clients {
+			go client.Stop()
+		}
+	}()
+
+	records := make([][]*ballotRecord, cfg.Clients)
+	for i := 0; i < cfg.Ballots; i++ {
+		client := i % cfg.Clients
+		r := rand.New(rand.NewSource(cfg.Seed + int64(i)))
+		candidates := clients[client].CandidateList
+		cand := r.Intn(len(candidates))
+		records[client] = append(records[client], &ballotRecord{
+			ballot: blockchain.Ballot{
+				VoterName:      fmt.Sprintf("loadgen-voter%d", i),
+				VoterStudentID: fmt.Sprintf("%08d", i),
+				VoterCandidate: candidates[cand],
+				Race:           clients[client].CandidateRaces[cand],
+			},
+			numConfirmed: -1,
+		})
+	}
+
+	// submission phase
+	var wg sync.WaitGroup
+	errs := make(chan error, len(clients))
+	start := time.Now()
+	for i, client := range clients {
+		wg.Add(1)
+		go func(client *evlib.EV, records []*ballotRecord) {
+			defer wg.Done()
+			for _, rec := range records {
+				var err error
+				rec.submitTime = time.Now()
+				rec.txid, err = client.Vote(rec.ballot)
+				rec.submitLat = time.Since(rec.submitTime)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(client, records[i])
+	}
+	wg.Wait()
+	select {
+	case err := <-errs:
+		return nil, err
+	default:
+	}
+	report := &Report{
+		Clients:        cfg.Clients,
+		Submitted:      cfg.Ballots,
+		SubmitDuration: time.Since(start),
+	}
+	report.Throughput = float64(report.Submitted) / report.SubmitDuration.Seconds()
+
+	// confirmation phase
+	deadline := time.Now().Add(cfg.ConfirmTimeout)
+	for i, client := range clients {
+		wg.Add(1)
+		go func(client *evlib.EV, records []*ballotRecord) {
+			defer wg.Done()
+			waitConfirmed(client, records, deadline, cfg.PollInterval)
+		}(client, records[i])
+	}
+	wg.Wait()
+
+	var submitLats, confirmLats []time.Duration
+	var all []*ballotRecord
+	for _, recs := range records {
+		for _, rec := range recs {
+			all = append(all, rec)
+			submitLats = append(submitLats, rec.submitLat)
+			if rec.finalized {
+				report.Confirmed++
+				confirmLats = append(confirmLats, rec.confirmLat)
+			} else {
+				report.Lost++
+				report.LostTxIDs = append(report.LostTxIDs, hex.EncodeToString(rec.txid))
+			}
+		}
+	}
+	report.SubmitLatency = percentiles(submitLats)
+	report.ConfirmLatency = percentiles(confirmLats)
+
+	if cfg.CoordMinerAddr != "" {
+		dup, err := countDuplicates(cfg.CoordMinerAddr, cfg.ElectionID, all)
+		if err != nil {
+			return report, err
+		}
+		report.Duplicated = dup
+	}
+	return report, nil
+}
+
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "clients:        %d\n", r.Clients)
+	fmt.Fprintf(&buf, "submitted:      %d in %v (%.2f ballots/s)\n", r.Submitted, r.SubmitDuration.Round(time.Millisecond), r.Throughput)
+	fmt.Fprintf(&buf, "confirmed:      %d\n", r.Confirmed)
+	fmt.Fprintf(&buf, "lost:           %d\n", r.Lost)
+	fmt.Fprintf(&buf, "duplicated:     %d\n", r.Duplicated)
+	fmt.Fprintf(&buf, "submit latency:  %v\n", r.SubmitLatency)
+	fmt.Fprintf(&buf, "confirm latency: %v\n", r.ConfirmLatency)
+	return buf.String()
+}
+
+func (l Latency) String() string {
+	return fmt.Sprintf("n=%d p50=%v p90=%v p99=%v max=%v", l.Count,
+		l.P50.Round(time.Millisecond), l.P90.Round(time.Millisecond),
+		l.P99.Round(time.Millisecond), l.Max.Round(time.Millisecond))
+}
+
+// ----- utility functions -----
+
+// waitConfirmed polls coord until every ballot is finalized or the deadline passes
+func waitConfirmed(client *evlib.EV, records []*ballotRecord, deadline time.Time, interval time.Duration) {
+	pending := records
+	for len(pending) > 0 && time.Now().Before(deadline) {
+		txids := make([][]byte, 0, len(pending))
+		for _, rec := range pending {
+			txids = append(txids, rec.txid)
+		}
+		numConfirmed, finalized, err := client.GetBallotFinalities(txids)
+		var next []*ballotRecord
+		for i, rec := range pending {
+			if err == nil {
+				rec.numConfirmed, rec.finalized = numConfirmed[i], finalized[i]
+			}
+			if rec.finalized {
+				rec.confirmLat = time.Since(rec.submitTime)
+			} else {
+				next = append(next, rec)
+			}
+		}
+		pending = next
+		if len(pending) > 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// countDuplicates downloads the chain from coord and counts ballots whose txn or voter is on the longest chain more than once
+func countDuplicates(coordMinerAddr string, electionID string, records []*ballotRecord) (int, error) {
+	client, err := util.DialRPC(coordMinerAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	reply := blockvote.DownloadReply{}
+	if err = blockvote.Call(client, blockvote.Scoped(electionID, "CoordAPIMiner.Download"), blockvote.DownloadArgs{}, &reply); err != nil {
+		return 0, err
+	}
+	blocks, err := blockvote.DownloadChain(client, electionID, reply.Height, nil)
+	if err != nil {
+		return 0, err
+	}
+	storage := &util.Database{}
+	if err = storage.New("", true); err != nil {
+		return 0, err
+	}
+	defer storage.Close()
+	var candidates []*Identity.Wallets
+	for _, cand := range reply.Candidates {
+		candidates = append(candidates, Identity.DecodeToWallets(cand))
+	}
+	chain := blockchain.NewBlockChain(storage, candidates)
+	if err = chain.SetDifficulty(reply.Difficulty); err != nil {
+		return 0, err
+	}
+	if err = chain.ResumeFromEncodedData(blocks, reply.LastHash); err != nil {
+		return 0, err
+	}
+
+	txnCount := make(map[string]int)
+	voterCount := make(map[string]int)
+	iter := chain.NewIterator(chain.GetLastHash())
+	for block, end := iter.Next(); !end; block, end = iter.Next() {
+		for _, txn := range block.Txns {
+			txnCount[string(txn.ID)]++
+			voterCount[txn.Data.VoterName+"/"+txn.Data.VoterStudentID]++
+		}
+	}
+	dup := 0
+	for _, rec := range records {
+		if txnCount[string(rec.txid)] > 1 || voterCount[rec.ballot.VoterName+"/"+rec.ballot.VoterStudentID] > 1 {
+			dup++
+		}
+	}
+	return dup, nil
+}
+
+func percentiles(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	at := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	return Latency{
+		Count: len(samples),
+		P50:   at(0.5),
+		P90:   at(0.9),
+		P99:   at(0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
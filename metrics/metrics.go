@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds (in seconds) used for RPC latency histograms
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+type entry struct {
+	name   string
+	help   string
+	kind   string
+	metric metric
+}
+
+// Registry holds a set of named metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]entry)}
+}
+
+// ----- Registry APIs -----
+
+func (r *Registry) NewCounter(name string, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+func (r *Registry) NewGauge(name string, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn every time metrics are collected
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	r.register(name, help, "gauge", gaugeFunc(fn))
+}
+
+// NewCounterFunc registers a counter whose value is computed by fn every time metrics are collected
+func (r *Registry) NewCounterFunc(name string, help string, fn func() float64) {
+	r.register(name, help, "counter", gaugeFunc(fn))
+}
+
+func (r *Registry) NewHistogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		buckets: append([]float64{}, buckets...),
+		counts:  make([]uint64, len(buckets)),
+	}
+	sort.Float64s(h.buckets)
+	r.register(name, help, "histogram", h)
+	return h
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format. Metrics can be registered meanwhile
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	entries := make([]entry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, e)
+	}
+	r.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "# HELP %s %s\n", e.name, e.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", e.name, e.kind)
+		e.metric.write(bw, e.name)
+	}
+	return bw.Flush()
+}
+
+// Handler returns an http handler that serves the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := r.WriteText(w); err != nil {
+			log.Println("[WARN] Unable to write metrics:", err)
+		}
+	})
+}
+
+// Serve exposes the metrics at http://listenAddr/metrics in the background
+func (r *Registry) Serve(listenAddr string) error {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return errors.New("cannot listen at " + listenAddr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	go http.Serve(listener, mux)
+	return nil
+}
+
+func (r *Registry) register(name string, help string, kind string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.entries[name]; ok {
+		log.Panicf("metric %s registered twice", name)
+	}
+	r.entries[name] = entry{name: name, help: help, kind: kind, metric: m}
+}
+
+// ----- Metric types -----
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+	c.mu.Lock()
+	c.value += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(c.Value()))
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *Gauge) Set(value float64) {
+	g.mu.Lock()
+	g.value = value
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+}
+
+type gaugeFunc func() float64
+
+func (fn gaugeFunc) write(w io.Writer, name string) {
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(fn()))
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// ObserveSince records the time elapsed since start in seconds
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Snapshot returns the bucket bounds, cumulative bucket counts, total count and sum of all observations
+func (h *Histogram) Snapshot() (buckets []float64, counts []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]float64{}, h.buckets...), append([]uint64{}, h.counts...), h.count, h.sum
+}
+
+func (h *Histogram) write(w io.Writer, name string) {
+	buckets, counts, count, sum := h.Snapshot()
+	for i, bound := range buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", value)
+}
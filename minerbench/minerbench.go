@@ -0,0 +1,212 @@
+// Package minerbench mines blocks locally, without coord or peers, to measure how fast this machine produces
+// and checks blocks at a given difficulty and txn load.
+package minerbench
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/Identity"
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+)
+
+type Config struct {
+	Difficulty   uint8  // leading zero bits of a block hash
+	Blocks       int    // blocks to mine
+	TxnsPerBlock int    // signed ballots in each block
+	Delayed      bool   // pause blockchain.MiningDelay after every nonce attempt, like miners do
+	HashAlgo     string // hash algorithm of the blocks, see blockchain.HasherFor
+}
+
+// Distribution summarizes a set of durations
+type Distribution struct {
+	Count int
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	Max   time.Duration
+}
+
+type Report struct {
+	Config
+	Hashes        uint64        // nonce attempts over all blocks
+	HashRate      float64       // nonce attempts per second, delays excluded
+	BlockInterval Distribution  // time to mine each block
+	MinerInterval time.Duration // expected time for a miner to mine a block, with its delay after every attempt
+	EncodeCost    Distribution  // time to encode each block for gossip
+	DecodeCost    Distribution  // time to decode each block
+	ValidateCost  Distribution  // time to check the proof of work, Merkle root and signatures of each block
+}
+
+// Run mines cfg.Blocks blocks on top of a made up genesis block
+func Run(cfg Config) (*Report, error) {
+	if cfg.Blocks <= 0 {
+		return nil, errors.New("no blocks to mine")
+	}
+	if cfg.Difficulty == 0 || cfg.Difficulty > 32 {
+		return nil, fmt.Errorf("difficulty %d is out of range [1, 32]", cfg.Difficulty)
+	}
+	if _, err := blockchain.HasherFor(cfg.HashAlgo); err != nil {
+		return nil, err
+	}
+	genesis := make([]byte, 32)
+	if _, err := rand.Read(genesis); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Config: cfg}
+	var intervals, encodes, decodes, validates []time.Duration
+	var hashing time.Duration
+	prevHash := genesis
+	for i := 0; i < cfg.Blocks; i++ {
+		block := &blockchain.Block{
+			PrevHash:  prevHash,
+			BlockNum:  uint32(i + 1),
+			Timestamp: time.Now().Unix(),
+			Txns:      makeTxns(cfg.TxnsPerBlock, genesis, i),
+			MinerID:   "minerbench",
+			HashAlgo:  cfg.HashAlgo,
+		}
+		pow := blockchain.NewProofWithDifficulty(block, cfg.Difficulty)
+		mined := time.Now()
+		start := mined
+		var hashes uint64
+		for {
+			hashes++
+			if pow.Next(false) {
+				break
+			}
+			if cfg.Delayed {
+				hashing += time.Since(start)
+				time.Sleep(blockchain.MiningDelay)
+				start = time.Now()
+			}
+		}
+		hashing += time.Since(start)
+		intervals = append(intervals, time.Since(mined))
+		report.Hashes += hashes
+
+		start = time.Now()
+		data := block.Encode()
+		encodes = append(encodes, time.Since(start))
+		start = time.Now()
+		decoded, err := blockchain.DecodeBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		decodes = append(decodes, time.Since(start))
+		start = time.Now()
+		if err = validate(decoded, cfg.Difficulty); err != nil {
+			return nil, fmt.Errorf("block #%d: %v", block.BlockNum, err)
+		}
+		validates = append(validates, time.Since(start))
+		prevHash = block.Hash
+	}
+
+	if hashing > 0 {
+		report.HashRate = float64(report.Hashes) / hashing.Seconds()
+	}
+	// a block takes 2^difficulty attempts on average
+	perHash := hashing / time.Duration(report.Hashes)
+	report.MinerInterval = time.Duration(math.Exp2(float64(cfg.Difficulty))) * (perHash + blockchain.MiningDelay)
+	report.BlockInterval = distribution(intervals)
+	report.EncodeCost = distribution(encodes)
+	report.DecodeCost = distribution(decodes)
+	report.ValidateCost = distribution(validates)
+	return report, nil
+}
+
+// validate checks a block the way miners check a block from a peer before putting it
+func validate(block *blockchain.Block, difficulty uint8) error {
+	pow := blockchain.NewProofWithDifficulty(block, difficulty)
+	if !pow.Validate() {
+		return errors.New("invalid proof of work")
+	}
+	if hash := pow.Hasher.Sum256(pow.BlockToBytes(block.Nonce)); !bytes.Equal(hash[:], block.Hash) {
+		return errors.New("hash does not match the header")
+	}
+	for _, txn := range block.Txns {
+		if err := txn.CheckShape(); err != nil {
+			return err
+		}
+		if !txn.Verify() {
+			return fmt.Errorf("txn %x has an invalid signature", txn.ID)
+		}
+	}
+	return nil
+}
+
+// makeTxns signs n ballots of distinct voters. Voters are new for every block, so that their signatures are
+// not in the signature cache when the block is validated
+func makeTxns(n int, genesis []byte, block int) []*blockchain.Transaction {
+	var txns []*blockchain.Transaction
+	for i := 0; i < n; i++ {
+		wallet := Identity.NewWallet()
+		txn := &blockchain.Transaction{
+			Data: &blockchain.Ballot{
+				VoterName:      "voter",
+				VoterStudentID: strconv.Itoa(block) + "-" + strconv.Itoa(i),
+				VoterCandidate: "candidate",
+				Type:           blockchain.BallotCandidate,
+			},
+			PublicKey: wallet.PublicKey,
+			Genesis:   genesis,
+		}
+		txn.Sign(wallet.PrivateKey)
+		txns = append(txns, txn)
+	}
+	return txns
+}
+
+func distribution(samples []time.Duration) Distribution {
+	if len(samples) == 0 {
+		return Distribution{}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+	at := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	return Distribution{
+		Count: len(samples),
+		Mean:  total / time.Duration(len(samples)),
+		P50:   at(0.5),
+		P90:   at(0.9),
+		Max:   samples[len(samples)-1],
+	}
+}
+
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	hasher, _ := blockchain.HasherFor(r.HashAlgo)
+	fmt.Fprintf(&buf, "difficulty:     %d bits of %s, %d txns per block\n", r.Difficulty, hasher.Name(), r.TxnsPerBlock)
+	fmt.Fprintf(&buf, "blocks:         %d\n", r.Blocks)
+	fmt.Fprintf(&buf, "hash rate:      %.0f hashes/s (%d hashes)\n", r.HashRate, r.Hashes)
+	fmt.Fprintf(&buf, "block interval: %v\n", r.BlockInterval)
+	fmt.Fprintf(&buf, "miner interval: %v expected with a %v delay after every attempt\n", r.MinerInterval.Round(time.Millisecond), blockchain.MiningDelay)
+	fmt.Fprintf(&buf, "encode:         %v\n", r.EncodeCost)
+	fmt.Fprintf(&buf, "decode:         %v\n", r.DecodeCost)
+	fmt.Fprintf(&buf, "validate:       %v\n", r.ValidateCost)
+	return buf.String()
+}
+
+func (d Distribution) String() string {
+	return fmt.Sprintf("n=%d mean=%v p50=%v p90=%v max=%v", d.Count, round(d.Mean), round(d.P50), round(d.P90), round(d.Max))
+}
+
+// round keeps durations readable: milliseconds, or microseconds below a millisecond
+func round(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
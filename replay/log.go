@@ -0,0 +1,180 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+)
+
+// Event is one step of a recorded election: a ballot cast or a block mined
+type Event struct {
+	Block     bool   // a block at Height. a ballot otherwise
+	Height    uint32 // of the block
+	MinerID   string // miner of the block. empty if the log does not tell
+	Voter     string // who cast the ballot: the voter name in a trace, the public key hash in an audit export
+	Race      string
+	Type      string // see blockchain.Ballot
+	Candidate string
+	Ranking   []string
+	TxID      string // hex ID of the ballot in the recorded election
+	Accepted  bool   // whether a miner accepted the ballot in the recorded election
+}
+
+// Log is a recorded election, as read by ReadLog
+type Log struct {
+	Events []Event
+	Races  []blockvote.RaceConfig // candidates of the election by race, as far as the log tells
+	Miners int                    // distinct miners that mined a block
+}
+
+// logLine is a line of either kind of log: a record of the tracing server (tracing.TraceRecord) or a line of
+// an audit export (blockchain.AuditBallot and blockchain.AuditSummary)
+type logLine struct {
+	Tag  string
+	Body json.RawMessage
+	Kind string
+}
+
+// ReadLog reads the events of a tracing server's output file or of a coord audit export, in log order.
+// A trace has ballots as clients signed them and blocks as miners mined them, but not their race, type or
+// ranking: ballots without a candidate are read as abstentions. An audit export has the ballots of the longest
+// chain only, each followed by the block it is in, without written-in names or the miners of the blocks
+func ReadLog(r io.Reader) (*Log, error) {
+	l := &Log{}
+	seen := make(map[string]bool)
+	accepted := make(map[string]bool)
+	miners := make(map[string]bool)
+	var height uint32  // of the last block event
+	var pending uint32 // height of the audit ballots read since the last block event
+	block := func(h uint32, minerID string) {
+		if minerID != "" {
+			miners[minerID] = true
+		}
+		// a competing block at a height already reached arrives at the same point of the replay
+		if h > height {
+			l.Events = append(l.Events, Event{Block: true, Height: h, MinerID: minerID})
+			height = h
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line logLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		var err error
+		switch {
+		case line.Tag == "TxnSigned":
+			var action blockvote.TxnSigned
+			if err = json.Unmarshal(line.Body, &action); err == nil && !seen[string(action.TxID)] {
+				seen[string(action.TxID)] = true
+				event := Event{Voter: action.VoterName, Candidate: action.Candidate, TxID: hex.EncodeToString(action.TxID)}
+				if event.Candidate == "" {
+					event.Type = blockchain.BallotAbstain
+				}
+				l.Events = append(l.Events, event)
+			}
+		case line.Tag == "TxnAcceptedByMiner":
+			var action blockvote.TxnAcceptedByMiner
+			if err = json.Unmarshal(line.Body, &action); err == nil {
+				accepted[hex.EncodeToString(action.TxID)] = true
+			}
+		case line.Tag == "BlockMined":
+			var action blockvote.BlockMined
+			if err = json.Unmarshal(line.Body, &action); err == nil {
+				block(action.BlockNum, action.MinerID)
+			}
+		case line.Kind == "ballot":
+			var ballot blockchain.AuditBallot
+			if err = json.Unmarshal(scanner.Bytes(), &ballot); err == nil && !seen[ballot.TxID] {
+				seen[ballot.TxID] = true
+				// the ballots of a block are cast before it is mined
+				if ballot.Height != pending {
+					block(pending, "")
+					pending = ballot.Height
+				}
+				l.Events = append(l.Events, Event{Voter: ballot.Voter, Race: ballot.Race, Type: ballot.Type,
+					Candidate: ballot.Candidate, Ranking: ballot.Ranking, TxID: ballot.TxID, Accepted: true})
+			}
+		case line.Kind == "summary":
+			var summary blockchain.AuditSummary
+			if err = json.Unmarshal(scanner.Bytes(), &summary); err == nil {
+				l.Races = auditRaces(summary.Tallies)
+				block(pending, "")
+				block(summary.Height, "")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range l.Events {
+		if !l.Events[i].Block && accepted[l.Events[i].TxID] {
+			l.Events[i].Accepted = true
+		}
+	}
+	if l.Races == nil {
+		l.Races = traceRaces(l.Events)
+	}
+	l.Miners = len(miners)
+	return l, nil
+}
+
+// Ballots returns the number of ballot events
+func (l *Log) Ballots() int {
+	n := 0
+	for _, event := range l.Events {
+		if !event.Block {
+			n++
+		}
+	}
+	return n
+}
+
+// ----- utility functions -----
+
+// auditRaces groups the candidates of an audit summary by race, in the order of the summary
+func auditRaces(tallies []blockchain.AuditTally) []blockvote.RaceConfig {
+	var races []blockvote.RaceConfig
+	index := make(map[string]int)
+	for _, tally := range tallies {
+		i, ok := index[tally.Race]
+		if !ok {
+			i = len(races)
+			index[tally.Race] = i
+			races = append(races, blockvote.RaceConfig{Name: tally.Race})
+		}
+		races[i].Candidates = append(races[i].Candidates, tally.Candidate)
+	}
+	return races
+}
+
+// traceRaces makes a single race of the candidates ballots were cast for, in the order they first appear
+func traceRaces(events []Event) []blockvote.RaceConfig {
+	race := blockvote.RaceConfig{}
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if !event.Block && event.Candidate != "" && !seen[event.Candidate] {
+			seen[event.Candidate] = true
+			race.Candidates = append(race.Candidates, event.Candidate)
+		}
+	}
+	if len(race.Candidates) == 0 {
+		return nil
+	}
+	return []blockvote.RaceConfig{race}
+}
@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+)
+
+// traceLog is the output of a tracing server: a duplicate signature, a competing block and an abstention
+const traceLog = `{"Tag":"TxnSigned","Body":{"TxID":"AQ==","VoterName":"alice","Candidate":"Ann"}}
+{"Tag":"TxnSigned","Body":{"TxID":"Ag==","VoterName":"bob","Candidate":"Ben"}}
+{"Tag":"TxnSigned","Body":{"TxID":"AQ==","VoterName":"alice","Candidate":"Ann"}}
+{"Tag":"TxnAcceptedByMiner","Body":{"TxID":"AQ==","MinerID":"miner1"}}
+
+{"Tag":"BlockMined","Body":{"BlockNum":1,"MinerID":"miner1","NumTxns":1}}
+{"Tag":"BlockMined","Body":{"BlockNum":1,"MinerID":"miner2","NumTxns":1}}
+{"Tag":"TxnSigned","Body":{"TxID":"Aw==","VoterName":"carol"}}
+{"Tag":"TxnAcceptedByMiner","Body":{"TxID":"Aw==","MinerID":"miner2"}}
+{"Tag":"BlockMined","Body":{"BlockNum":2,"MinerID":"miner2","NumTxns":1}}
+`
+
+// auditLog is a coord audit export: two ballots in block #1, one in block #2 and an empty block #3
+const auditLog = `{"Kind":"ballot","Height":1,"TxID":"aa","Race":"president","Type":"plurality","Candidate":"Ann","Voter":"k1","Counted":true}
+{"Kind":"ballot","Height":1,"TxID":"bb","Race":"senate","Type":"ranked","Candidate":"Sue","Ranking":["Sue","Sam"],"Voter":"k2","Counted":true}
+{"Kind":"ballot","Height":2,"TxID":"cc","Race":"president","Type":"abstain","Voter":"k3","Counted":true}
+{"Kind":"summary","Height":3,"Ballots":3,"Counted":3,"Tallies":[{"Race":"president","Candidate":"Ann","Votes":1},{"Race":"president","Candidate":"Ben","Votes":0},{"Race":"senate","Candidate":"Sue","Votes":1},{"Race":"senate","Candidate":"Sam","Votes":0}]}
+`
+
+func TestReadTrace(t *testing.T) {
+	l, err := ReadLog(strings.NewReader(traceLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{Voter: "alice", Candidate: "Ann", TxID: hex.EncodeToString([]byte{1}), Accepted: true},
+		{Voter: "bob", Candidate: "Ben", TxID: hex.EncodeToString([]byte{2})},
+		{Block: true, Height: 1, MinerID: "miner1"},
+		{Voter: "carol", Type: blockchain.BallotAbstain, TxID: hex.EncodeToString([]byte{3}), Accepted: true},
+		{Block: true, Height: 2, MinerID: "miner2"},
+	}
+	if !reflect.DeepEqual(l.Events, want) {
+		t.Errorf("events:\n got %+v\nwant %+v", l.Events, want)
+	}
+	if races := []blockvote.RaceConfig{{Candidates: []string{"Ann", "Ben"}}}; !reflect.DeepEqual(l.Races, races) {
+		t.Errorf("races: got %+v, want %+v", l.Races, races)
+	}
+	if l.Miners != 2 {
+		t.Errorf("miners: got %d, want 2", l.Miners)
+	}
+	if l.Ballots() != 3 {
+		t.Errorf("ballots: got %d, want 3", l.Ballots())
+	}
+}
+
+func TestReadAudit(t *testing.T) {
+	l, err := ReadLog(strings.NewReader(auditLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{Voter: "k1", Race: "president", Type: "plurality", Candidate: "Ann", TxID: "aa", Accepted: true},
+		{Voter: "k2", Race: "senate", Type: "ranked", Candidate: "Sue", Ranking: []string{"Sue", "Sam"}, TxID: "bb", Accepted: true},
+		{Block: true, Height: 1},
+		{Voter: "k3", Race: "president", Type: "abstain", TxID: "cc", Accepted: true},
+		{Block: true, Height: 2},
+		{Block: true, Height: 3},
+	}
+	if !reflect.DeepEqual(l.Events, want) {
+		t.Errorf("events:\n got %+v\nwant %+v", l.Events, want)
+	}
+	races := []blockvote.RaceConfig{
+		{Name: "president", Candidates: []string{"Ann", "Ben"}},
+		{Name: "senate", Candidates: []string{"Sue", "Sam"}},
+	}
+	if !reflect.DeepEqual(l.Races, races) {
+		t.Errorf("races: got %+v, want %+v", l.Races, races)
+	}
+	if l.Miners != 0 {
+		t.Errorf("miners: got %d, want 0", l.Miners)
+	}
+}
+
+func TestReadLogBadLine(t *testing.T) {
+	if _, err := ReadLog(strings.NewReader(traceLog + "not json\n")); err == nil || !strings.Contains(err.Error(), "line 11") {
+		t.Errorf("got %v, want an error at line 11", err)
+	}
+}
+
+func TestReplayDeterministic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	l, err := ReadLog(strings.NewReader(traceLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		report, err := Run(l, Config{})
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if report.Blocks != 2 || report.Height != 2 || report.Accepted != 3 {
+			t.Errorf("run %d: %+v, want 2 blocks of 3 ballots", i, report)
+		}
+	}
+}
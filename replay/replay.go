@@ -0,0 +1,240 @@
+// Package replay casts the ballots of a recorded election against a fresh in-process cluster, in the order of
+// the log, and has the cluster mine a block at every recorded block, so that a bug report from a real election
+// can be reproduced and kept as a regression test.
+//
+// Miners of the cluster only mine when the replay asks them to (see testkit.Options.OnDemand): at a block
+// event, once the ballots cast since the previous one reached it, one miner mines up to the recorded height.
+// Every replay of a log thus puts the same ballots in the same blocks, mined by the same miner: the miner of
+// the recorded block if the log tells, in the order miners first appear, else the first one. Ballots are signed
+// again by fresh wallets, one per recorded voter, so TxIDs, hashes and nonces differ from the recorded ones.
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/testkit"
+)
+
+type Config struct {
+	Miners         int                    // miners of the cluster. as many as mined a block in the log if 0
+	Races          []blockvote.RaceConfig // candidates of the election. those of the log if empty
+	BlockTimeout   time.Duration          // how long to wait for the cluster to mine a block
+	ConfirmTimeout time.Duration          // how long to wait for the cluster to converge after the last event
+	Difficulty     uint8
+	Seed           int64
+}
+
+type Report struct {
+	Ballots   int    // ballots cast
+	Accepted  int    // ballots a miner accepted
+	Blocks    int    // recorded blocks the cluster mined
+	Height    uint32 // of coord's chain at the end
+	Diverged  []Divergence
+	Duration  time.Duration
+	Unreached uint32 // height of the recorded block the cluster did not reach in time. 0 if it reached all
+}
+
+// Divergence is a ballot whose outcome differs between the recorded election and the replay
+type Divergence struct {
+	Event    int    // index of the ballot in Log.Events
+	TxID     string // recorded TxID
+	Recorded string // "accepted" or "rejected"
+	Replayed string // "accepted", "rejected: <why>" or "missing from the final chain"
+}
+
+type ballotRecord struct {
+	event int
+	txid  []byte // nil if rejected
+	err   error
+}
+
+// Run starts a cluster and replays the events of log against it, then checks every ballot against coord's
+// final chain. The report lists the ballots whose outcome differs from the recorded one
+func Run(log *Log, cfg Config) (*Report, error) {
+	if cfg.Races == nil {
+		cfg.Races = log.Races
+	}
+	if len(cfg.Races) == 0 {
+		return nil, errors.New("no candidates in the log or the config")
+	}
+	if cfg.Miners == 0 {
+		cfg.Miners = log.Miners
+	}
+	if cfg.Miners == 0 {
+		cfg.Miners = 1
+	}
+	if cfg.BlockTimeout == 0 {
+		cfg.BlockTimeout = time.Minute
+	}
+	if cfg.ConfirmTimeout == 0 {
+		cfg.ConfirmTimeout = 5 * time.Minute
+	}
+
+	cluster, err := testkit.Start(testkit.Options{
+		Miners:     cfg.Miners,
+		Races:      cfg.Races,
+		Difficulty: cfg.Difficulty,
+		Seed:       cfg.Seed,
+		OnDemand:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cluster.Stop()
+	// a single client casts the ballots one after the other, so they reach the miners in log order
+	client, err := cluster.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	report := &Report{}
+	races := raceOf(cfg.Races)
+	studentIDs := make(map[string]string)
+	miners := make(map[string]int) // recorded miner ID -> miner of the cluster
+	var records []ballotRecord
+	var pending [][]byte // accepted ballots cast since the last block
+	for i, event := range log.Events {
+		if event.Block {
+			idx, ok := miners[event.MinerID]
+			if !ok && event.MinerID != "" {
+				idx = len(miners) % cfg.Miners
+				miners[event.MinerID] = idx
+			}
+			if err = mineTo(cluster, idx, event.Height, pending, cfg.BlockTimeout); err != nil {
+				report.Unreached = event.Height
+				break
+			}
+			pending = nil
+			report.Blocks++
+			continue
+		}
+		id, ok := studentIDs[event.Voter]
+		if !ok {
+			id = fmt.Sprintf("%08d", len(studentIDs)+1)
+			studentIDs[event.Voter] = id
+		}
+		txid, err := client.Vote(ballotOf(event, id, races))
+		records = append(records, ballotRecord{event: i, txid: txid, err: err})
+		report.Ballots++
+		if err == nil {
+			report.Accepted++
+			pending = append(pending, txid)
+		}
+	}
+	// ballots cast after the last recorded block go into blocks of their own, as a live cluster would mine them
+	for report.Unreached == 0 && len(pending) > 0 {
+		if err = mineTo(cluster, 0, cluster.Height()+1, pending, cfg.BlockTimeout); err != nil {
+			report.Unreached = cluster.Height() + 1
+			break
+		}
+		pending = unconfirmed(cluster, pending)
+	}
+	report.Duration = time.Since(start)
+
+	tip, err := cluster.WaitForConvergence(cfg.ConfirmTimeout)
+	if err != nil {
+		return report, err
+	}
+	coord := cluster.RunningCoord()
+	report.Height = coord.Blockchain.GetHeader(tip).BlockNum
+	for _, rec := range records {
+		event := log.Events[rec.event]
+		recorded, replayed := "rejected", "accepted"
+		if event.Accepted {
+			recorded = "accepted"
+		}
+		if rec.err != nil {
+			replayed = "rejected: " + rec.err.Error()
+		} else if _, _, numConfirmed := coord.Blockchain.FindTxn(rec.txid); numConfirmed < 0 {
+			replayed = "missing from the final chain"
+		}
+		if event.Accepted != (replayed == "accepted") {
+			report.Diverged = append(report.Diverged, Divergence{Event: rec.event, TxID: event.TxID,
+				Recorded: recorded, Replayed: replayed})
+		}
+	}
+	if report.Unreached > 0 {
+		return report, fmt.Errorf("the cluster did not mine block #%d in %v", report.Unreached, cfg.BlockTimeout)
+	}
+	return report, nil
+}
+
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "duration: %v\n", r.Duration.Round(time.Second))
+	fmt.Fprintf(&buf, "ballots:  %d\n", r.Ballots)
+	fmt.Fprintf(&buf, "accepted: %d\n", r.Accepted)
+	fmt.Fprintf(&buf, "blocks:   %d\n", r.Blocks)
+	fmt.Fprintf(&buf, "height:   %d\n", r.Height)
+	fmt.Fprintf(&buf, "diverged: %d\n", len(r.Diverged))
+	for _, d := range r.Diverged {
+		fmt.Fprintf(&buf, "  #%d %s: %s, replay %s\n", d.Event, d.TxID, d.Recorded, d.Replayed)
+	}
+	return buf.String()
+}
+
+// ----- utility functions -----
+
+// raceOf maps every candidate to its race, to place the ballots of a trace, which only have the candidate
+func raceOf(races []blockvote.RaceConfig) map[string]string {
+	m := make(map[string]string)
+	for _, race := range races {
+		for _, cand := range race.Candidates {
+			if _, ok := m[cand]; !ok {
+				m[cand] = race.Name
+			}
+		}
+	}
+	return m
+}
+
+// ballotOf is the ballot of a ballot event, cast by the voter with the given student ID
+func ballotOf(event Event, studentID string, races map[string]string) blockchain.Ballot {
+	ballot := blockchain.Ballot{
+		VoterName:      event.Voter,
+		VoterStudentID: studentID,
+		Race:           event.Race,
+		Type:           event.Type,
+		Ranking:        event.Ranking,
+	}
+	switch event.Type {
+	case blockchain.BallotCandidate:
+		ballot.VoterCandidate = event.Candidate
+		if race, ok := races[event.Candidate]; ok && ballot.Race == "" {
+			ballot.Race = race
+		}
+	case blockchain.BallotWriteIn:
+		// audit exports leave written-in names out
+		ballot.VoterCandidate = "write-in"
+	}
+	return ballot
+}
+
+// mineTo has miner idx mine blocks until coord's chain reaches height, the first one once the txns in pending
+// reached the miner
+func mineTo(cluster *testkit.Cluster, idx int, height uint32, pending [][]byte, timeout time.Duration) error {
+	for cluster.Height() < height {
+		if err := cluster.MineBlock(idx, pending, timeout); err != nil {
+			return err
+		}
+		pending = nil
+	}
+	return nil
+}
+
+// unconfirmed returns the txns of txids that are not on coord's chain
+func unconfirmed(cluster *testkit.Cluster, txids [][]byte) [][]byte {
+	var left [][]byte
+	for _, txid := range txids {
+		if _, _, numConfirmed := cluster.RunningCoord().Blockchain.FindTxn(txid); numConfirmed < 0 {
+			left = append(left, txid)
+		}
+	}
+	return left
+}
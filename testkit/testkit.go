@@ -0,0 +1,388 @@
+// Package testkit runs coord, miners and clients in one process for integration tests.
+//
+// Nodes listen on ephemeral ports of 127.0.0.1 and miners use the in-memory database.
+// Coord also uses an in-memory database unless Options.CoordStorageDir is set, which is
+// needed to restart coord with its chain.
+package testkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockvote"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+	"cs.ubc.ca/cpsc416/BlockVote/util"
+)
+
+// CoordNode identifies coord in Partition
+const CoordNode = -1
+
+type Options struct {
+	Miners          int                    // number of miners started with the cluster
+	NCandidates     uint8                  // defaults to 3. ignored if Races is set
+	Races           []blockvote.RaceConfig // races and their candidates. a single race of generated candidates if empty
+	Difficulty      uint8                  // defaults to 4 so that blocks are mined quickly
+	MaxTxn          uint8                  // defaults to 10
+	CoordStorageDir string                 // coord's database directory. in-memory if empty
+	StartTimeout    time.Duration          // how long to wait for a node to start. defaults to 30s
+	Clock           util.Clock             // clock of miners and clients. defaults to util.RealClock
+	Seed            int64                  // seeds clients' random choices so that runs are repeatable
+	DevVoters       []string               // student IDs that vote without registering, on a dev chain with a registrar. anyone votes if empty
+	OnDemand        bool                   // miners only mine the blocks MineBlock asks for. they mine freely if false
+}
+
+// registrarKeyFile is the registrar key of clusters with DevVoters, kept with the wallets
+const registrarKeyFile = "./tmp/registrar.pem"
+
+type Cluster struct {
+	opts Options
+
+	mu        sync.Mutex
+	Coord     *blockvote.Coord   // nil while crashed
+	coordDone chan struct{}      // closed when coord's Start returns
+	Miners    []*blockvote.Miner // nil while crashed
+	clients   []*evlib.EV
+	groups    map[string]int // gossip addr -> partition group
+	nClients  uint
+
+	// fixed after coord's first start so that restarted nodes find it again
+	coordClientAddr string
+	coordMinerAddr  string
+}
+
+// Start launches coord and opts.Miners miners and waits for all of them to join
+func Start(opts Options) (*Cluster, error) {
+	if len(opts.Races) > 0 {
+		opts.NCandidates = 0
+		for _, race := range opts.Races {
+			opts.NCandidates += uint8(len(race.Candidates))
+		}
+	} else if opts.NCandidates == 0 {
+		opts.NCandidates = 3
+	}
+	if opts.Difficulty == 0 {
+		opts.Difficulty = 4
+	}
+	if opts.MaxTxn == 0 {
+		opts.MaxTxn = 10
+	}
+	if opts.StartTimeout == 0 {
+		opts.StartTimeout = 30 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = util.RealClock
+	}
+	// wallets are saved under ./tmp
+	if err := os.MkdirAll("./tmp", 0755); err != nil {
+		return nil, err
+	}
+
+	c := &Cluster{
+		opts:            opts,
+		coordClientAddr: "127.0.0.1:0",
+		coordMinerAddr:  "127.0.0.1:0",
+	}
+	if err := c.RestartCoord(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < opts.Miners; i++ {
+		if _, err := c.AddMiner(); err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// CoordClientAddr is the address of coord's client API
+func (c *Cluster) CoordClientAddr() string {
+	return c.coordClientAddr
+}
+
+// CoordMinerAddr is the address of coord's miner API
+func (c *Cluster) CoordMinerAddr() string {
+	return c.coordMinerAddr
+}
+
+// AddMiner starts a new miner and returns its index
+func (c *Cluster) AddMiner() (int, error) {
+	c.mu.Lock()
+	c.Miners = append(c.Miners, nil)
+	idx := len(c.Miners) - 1
+	c.mu.Unlock()
+	return idx, c.RestartMiner(idx)
+}
+
+// CrashMiner stops miner idx without any notice to other nodes
+func (c *Cluster) CrashMiner(idx int) {
+	c.mu.Lock()
+	m := c.Miners[idx]
+	c.Miners[idx] = nil
+	c.mu.Unlock()
+	if m != nil {
+		m.Stop()
+	}
+}
+
+// RestartMiner starts miner idx again with the same ID. It rejoins with an empty database like a real miner does.
+func (c *Cluster) RestartMiner(idx int) error {
+	c.CrashMiner(idx)
+	m := blockvote.NewMiner()
+	m.Clock = c.opts.Clock
+	m.Dev = len(c.opts.DevVoters) > 0
+	m.OnDemand = c.opts.OnDemand
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- m.Start(fmt.Sprintf("miner%d", idx+1), c.coordMinerAddr, "127.0.0.1:0", c.opts.Difficulty, c.opts.MaxTxn, nil)
+	}()
+	if err := c.waitReady(m.Ready(), errChan, m.Stop); err != nil {
+		return fmt.Errorf("miner%d: %v", idx+1, err)
+	}
+	c.mu.Lock()
+	c.Miners[idx] = m
+	c.applyPartition()
+	c.mu.Unlock()
+	return nil
+}
+
+// CrashCoord stops coord without any notice to other nodes
+func (c *Cluster) CrashCoord() {
+	c.mu.Lock()
+	coord, done := c.Coord, c.coordDone
+	c.Coord = nil
+	c.mu.Unlock()
+	if coord != nil {
+		coord.Stop()
+		// Start closes the database when it returns. wait for it so that a restart can open it again
+		<-done
+	}
+}
+
+// RestartCoord starts coord at its previous addresses. The chain is kept only if Options.CoordStorageDir is set.
+func (c *Cluster) RestartCoord() error {
+	c.CrashCoord()
+	coord := blockvote.NewCoord()
+	coord.StorageDir = c.opts.CoordStorageDir
+	coord.Difficulty = c.opts.Difficulty
+	coord.Races = c.opts.Races
+	if len(c.opts.DevVoters) > 0 {
+		coord.Dev = true
+		coord.RegistrarKeyFile = registrarKeyFile
+		coord.Verifier = blockvote.StubVerifier{}
+		coord.Genesis.Registration.DevVoters = c.opts.DevVoters
+	}
+	errChan := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		errChan <- coord.Start(c.coordClientAddr, c.coordMinerAddr, c.opts.NCandidates, nil)
+		close(done)
+	}()
+	if err := c.waitReady(coord.Ready(), errChan, coord.Stop); err != nil {
+		return fmt.Errorf("coord: %v", err)
+	}
+	c.mu.Lock()
+	c.Coord = coord
+	c.coordDone = done
+	c.coordClientAddr = coord.ClientAPIAddr
+	c.coordMinerAddr = coord.MinerAPIAddr
+	c.applyPartition()
+	c.mu.Unlock()
+	return nil
+}
+
+// Partition splits the network into groups of node indices (CoordNode for coord). Nodes only gossip
+// with nodes of their own group. Nodes not in any group can talk to everyone.
+func (c *Cluster) Partition(groups ...[]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups = make(map[string]int)
+	for gid, group := range groups {
+		for _, idx := range group {
+			if addr := c.gossipAddr(idx); addr != "" {
+				c.groups[addr] = gid
+			}
+		}
+	}
+	c.applyPartition()
+}
+
+// Heal removes all partitions
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups = nil
+	c.applyPartition()
+}
+
+// NumMiners returns the number of miners added to the cluster, crashed ones included
+func (c *Cluster) NumMiners() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.Miners)
+}
+
+// RunningCoord returns coord, nil while it is crashed
+func (c *Cluster) RunningCoord() *blockvote.Coord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Coord
+}
+
+// Height returns the height of coord's longest chain, 0 while coord is crashed
+func (c *Cluster) Height() uint32 {
+	coord := c.RunningCoord()
+	if coord == nil {
+		return 0
+	}
+	return coord.Blockchain.GetHeader(coord.Blockchain.GetLastHash()).BlockNum
+}
+
+// MineBlock has miner idx of an OnDemand cluster mine one block once the txns in txids are in its pool, and
+// waits until coord's chain grows by the block
+func (c *Cluster) MineBlock(idx int, txids [][]byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	c.mu.Lock()
+	m := c.Miners[idx]
+	c.mu.Unlock()
+	if m == nil {
+		return fmt.Errorf("miner%d is crashed", idx+1)
+	}
+	height := c.Height()
+	for _, txid := range txids {
+		for !m.HasPending(txid) {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("txn %x did not reach miner%d in %v", txid, idx+1, timeout)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	m.AllowBlocks(1)
+	for c.Height() <= height {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("miner%d did not mine block #%d in %v", idx+1, height+1, timeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// WaitForConvergence waits until coord and all running miners have the same last hash, and returns it
+func (c *Cluster) WaitForConvergence(timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if hash, ok := c.lastHash(); ok {
+			return hash, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("chains did not converge in " + timeout.String())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// NewClient starts an evlib client connected to coord
+func (c *Cluster) NewClient() (*evlib.EV, error) {
+	c.mu.Lock()
+	c.nClients++
+	id := c.nClients
+	c.mu.Unlock()
+	client := evlib.NewEV()
+	client.Clock = c.opts.Clock
+	client.Rand = util.NewLockedRand(rand.NewSource(c.opts.Seed + int64(id)))
+	if err := client.Start(nil, id, c.coordClientAddr, ""); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.clients = append(c.clients, client)
+	c.mu.Unlock()
+	return client, nil
+}
+
+// Stop stops all nodes and clients
+func (c *Cluster) Stop() {
+	c.mu.Lock()
+	clients := c.clients
+	c.clients = nil
+	c.mu.Unlock()
+	for _, client := range clients {
+		go client.Stop()
+	}
+	for idx := 0; idx < c.NumMiners(); idx++ {
+		c.CrashMiner(idx)
+	}
+	c.CrashCoord()
+}
+
+// ----- utility functions -----
+
+func (c *Cluster) waitReady(ready <-chan struct{}, errChan <-chan error, stop func()) error {
+	select {
+	case <-ready:
+		return nil
+	case err := <-errChan:
+		if err == nil {
+			err = errors.New("stopped before it was ready")
+		}
+		return err
+	case <-time.After(c.opts.StartTimeout):
+		stop()
+		return errors.New("timed out while starting")
+	}
+}
+
+// NOTE: mu should be held by the caller
+func (c *Cluster) gossipAddr(idx int) string {
+	if idx == CoordNode {
+		if c.Coord == nil {
+			return ""
+		}
+		return c.Coord.GossipAddr
+	}
+	if idx < 0 || idx >= len(c.Miners) || c.Miners[idx] == nil {
+		return ""
+	}
+	return c.Miners[idx].Info.GossipAddr
+}
+
+// NOTE: mu should be held by the caller
+func (c *Cluster) applyPartition() {
+	filterFor := func(self string) func(peer string) bool {
+		if c.groups == nil {
+			return nil
+		}
+		groups := c.groups
+		return func(peer string) bool {
+			selfGroup, ok1 := groups[self]
+			peerGroup, ok2 := groups[peer]
+			return !ok1 || !ok2 || selfGroup == peerGroup
+		}
+	}
+	if c.Coord != nil {
+		c.Coord.SetPeerFilter(filterFor(c.Coord.GossipAddr))
+	}
+	for _, m := range c.Miners {
+		if m != nil {
+			m.SetPeerFilter(filterFor(m.Info.GossipAddr))
+		}
+	}
+}
+
+func (c *Cluster) lastHash() ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Coord == nil {
+		return nil, false
+	}
+	hash := c.Coord.Blockchain.GetLastHash()
+	for _, m := range c.Miners {
+		if m != nil && bytes.Compare(m.Blockchain.GetLastHash(), hash) != 0 {
+			return nil, false
+		}
+	}
+	return hash, true
+}
@@ -0,0 +1,107 @@
+package testkit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cs.ubc.ca/cpsc416/BlockVote/blockchain"
+	"cs.ubc.ca/cpsc416/BlockVote/evlib"
+)
+
+// waitForTxn waits until txid is on coord's longest chain
+func waitForTxn(t *testing.T, c *Cluster, txid []byte, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		if coord := c.RunningCoord(); coord != nil {
+			if _, _, numConfirmed := coord.Blockchain.FindTxn(txid); numConfirmed >= 0 {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ballot %x is not on the chain after %v", txid, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestClusterSmoke(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	c, err := Start(Options{Miners: 3})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer c.Stop()
+
+	client, err := c.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if len(client.CandidateList) == 0 {
+		t.Fatal("coord has no candidates")
+	}
+	txid, err := client.Vote(blockchain.Ballot{
+		VoterName:      "smoke-voter",
+		VoterStudentID: "12345678",
+		VoterCandidate: client.CandidateList[0],
+		Race:           client.CandidateRaces[0],
+	})
+	if err != nil {
+		t.Fatalf("Vote: %v", err)
+	}
+	waitForTxn(t, c, txid, time.Minute)
+	if _, err = c.WaitForConvergence(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted miner comes back with an empty database and catches up
+	c.CrashMiner(1)
+	if err = c.RestartMiner(1); err != nil {
+		t.Fatalf("RestartMiner: %v", err)
+	}
+	if _, err = c.WaitForConvergence(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	m := c.Miners[1]
+	c.mu.Unlock()
+	if _, _, numConfirmed := m.Blockchain.FindTxn(txid); numConfirmed < 0 {
+		t.Fatal("restarted miner does not have the ballot")
+	}
+}
+
+func TestDevVoters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a cluster")
+	}
+	c, err := Start(Options{Miners: 1, DevVoters: []string{"12345678"}})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer c.Stop()
+	client, err := c.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ballot := func(studentID string) blockchain.Ballot {
+		return blockchain.Ballot{
+			VoterName:      "dev-voter",
+			VoterStudentID: studentID,
+			VoterCandidate: client.CandidateList[0],
+			Race:           client.CandidateRaces[0],
+		}
+	}
+	// a dev voter votes right away
+	txid, err := client.Vote(ballot("12345678"))
+	if err != nil {
+		t.Fatalf("Vote of a dev voter: %v", err)
+	}
+	waitForTxn(t, c, txid, time.Minute)
+	// any other voter has to register first
+	if _, err = client.Vote(ballot("87654321")); !errors.Is(err, evlib.ErrNotRegistered) {
+		t.Fatalf("Vote of an unregistered voter: %v, want ErrNotRegistered", err)
+	}
+}
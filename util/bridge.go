@@ -0,0 +1,180 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// RPC connections can be tunneled through an HTTP CONNECT bridge, for clients behind firewalls that only let
+// web traffic out. The bridge listens on a web port, opens a TCP connection to the requested node and copies
+// bytes both ways, so the RPC protocol (compression included) is the same as over a direct connection.
+
+// BridgeDialTimeout bounds connecting to the bridge and to the node behind it
+const BridgeDialTimeout = 10 * time.Second
+
+// ErrBridgeRefused is returned when the bridge does not tunnel to the requested address
+var ErrBridgeRefused = errors.New("bridge refused the connection")
+
+// DialRPCVia connects to the RPC server at remoteIpPort through the HTTP CONNECT bridge at bridgeAddr, or
+// directly if bridgeAddr is empty
+func DialRPCVia(bridgeAddr string, remoteIpPort string) (*rpc.Client, error) {
+	if bridgeAddr == "" {
+		return DialRPC(remoteIpPort)
+	}
+	dial := func() (net.Conn, error) {
+		return dialBridge(bridgeAddr, remoteIpPort)
+	}
+	conn, err := dialNegotiated(remoteIpPort, dial, dial)
+	if err != nil {
+		return nil, err
+	}
+	return newRPCClient(limitConn(conn), remoteIpPort), nil
+}
+
+// dialBridge opens a tunnel to remoteIpPort through the bridge at bridgeAddr
+func dialBridge(bridgeAddr string, remoteIpPort string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", bridgeAddr, BridgeDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodConnect, "http://"+remoteIpPort, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Host = remoteIpPort
+	conn.SetDeadline(time.Now().Add(BridgeDialTimeout))
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.New(ErrBridgeRefused.Error() + ": " + resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were read ahead into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// BridgeSources maps the local address of every open tunnel of a bridge to the address of the client it tunnels
+// for. A node behind the bridge sees the tunnel's address as the caller, and looks the client up to tell the
+// clients of the bridge apart, e.g. to rate limit them each
+type BridgeSources struct {
+	mu      sync.Mutex
+	sources map[string]string
+}
+
+// Source returns the address of the client that the tunnel calling from addr is for. false if addr is not a tunnel
+func (s *BridgeSources) Source(addr string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	source, ok := s.sources[addr]
+	return source, ok
+}
+
+func (s *BridgeSources) add(addr string, source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sources == nil {
+		s.sources = make(map[string]string)
+	}
+	s.sources[addr] = source
+}
+
+func (s *BridgeSources) remove(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sources, addr)
+}
+
+// BridgeHandler serves HTTP CONNECT requests, tunneling each to the address route returns for the requested
+// one. Requests for addresses route does not accept are refused. The tunnels are recorded in sources, if not nil
+func BridgeHandler(route func(target string) (string, bool), sources *BridgeSources) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		addr, ok := route(r.Host)
+		if !ok {
+			http.Error(w, "unknown node "+r.Host, http.StatusForbidden)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "tunneling unsupported", http.StatusInternalServerError)
+			return
+		}
+		upstream, err := net.DialTimeout("tcp", addr, BridgeDialTimeout)
+		if err != nil {
+			http.Error(w, "cannot reach "+r.Host, http.StatusBadGateway)
+			return
+		}
+		// recorded before any byte goes through, so the node knows the client from its first call
+		if sources != nil {
+			local := upstream.LocalAddr().String()
+			sources.add(local, r.RemoteAddr)
+			defer sources.remove(local)
+		}
+		client, rw, err := hijacker.Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		if _, err = io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			client.Close()
+			upstream.Close()
+			return
+		}
+		// bytes the client sent right after the request
+		if n := rw.Reader.Buffered(); n > 0 {
+			early, _ := rw.Reader.Peek(n)
+			if _, err = upstream.Write(early); err != nil {
+				client.Close()
+				upstream.Close()
+				return
+			}
+		}
+		go tunnel(upstream, client)
+		tunnel(client, upstream)
+	})
+}
+
+// tunnel copies from src to dst until either fails, then closes both
+func tunnel(dst net.Conn, src net.Conn) {
+	if _, err := io.Copy(dst, src); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Println("[WARN] Bridge tunnel closed:", err)
+	}
+	dst.Close()
+	src.Close()
+}
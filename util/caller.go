@@ -0,0 +1,72 @@
+package util
+
+import (
+	"bufio"
+	"encoding/gob"
+	"net"
+	"net/rpc"
+)
+
+// CallerAware is implemented by RPC args that need the address of the connection a call came in on, e.g. to
+// rate limit clients. The server sets it after decoding the args, so callers cannot choose it.
+type CallerAware interface {
+	SetCallerAddr(addr string)
+}
+
+// setCaller hands the remote address of conn to args implementing CallerAware
+func setCaller(body interface{}, conn net.Conn) {
+	if aware, ok := body.(CallerAware); ok {
+		aware.SetCallerAddr(conn.RemoteAddr().String())
+	}
+}
+
+// callerServerCodec is net/rpc's gob server codec, setting the caller of CallerAware args
+type callerServerCodec struct {
+	conn   net.Conn
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newCallerServerCodec(conn net.Conn) *callerServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &callerServerCodec{conn: conn, dec: gob.NewDecoder(conn), enc: gob.NewEncoder(buf), encBuf: buf}
+}
+
+func (c *callerServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *callerServerCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	setCaller(body, c.conn)
+	return nil
+}
+
+func (c *callerServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob could not encode the header, which should not happen. close the connection like net/rpc
+			c.Close()
+		}
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *callerServerCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
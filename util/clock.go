@@ -0,0 +1,115 @@
+package util
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for timers and sleeps, so that tests can replace it with a FakeClock
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// RealClock is the wall clock
+var RealClock Clock = realClock{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// FakeClock only moves forward when Advance is called. Sleep and After wait until the clock has been advanced far enough.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	until time.Time
+	ch    chan time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{until: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward by d and wakes up every sleeper whose time has come
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.until.After(c.now) {
+			remaining = append(remaining, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = remaining
+}
+
+// Waiters returns the number of pending sleeps, so that tests can tell when all goroutines are blocked on the clock
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// NewLockedRand returns a *rand.Rand over src that is safe for concurrent use
+func NewLockedRand(src rand.Source) *rand.Rand {
+	return rand.New(&lockedSource{src: src})
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if src64, ok := s.src.(rand.Source64); ok {
+		return src64.Uint64()
+	}
+	return uint64(s.src.Int63())>>31 | uint64(s.src.Int63())<<32
+}
@@ -0,0 +1,180 @@
+package util
+
+import (
+	"bufio"
+	"github.com/golang/snappy"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// RPC connections made through this package are compressed with snappy when both ends support it. The client
+// opens the connection with a handshake offering its codecs and the server answers with the one it picked.
+// The first byte of the handshake never starts a gob stream, so servers still serve clients without it, and a
+// client falls back to a plain connection when an older server hangs up on the handshake.
+
+// CompressRPC is whether clients offer compression. Servers always accept it
+var CompressRPC = true
+
+// HandshakeTimeout bounds the wait for the server's answer to the handshake
+const HandshakeTimeout = 5 * time.Second
+
+// PlainRetryAfter is how long a server that hung up on the handshake is dialed without it, so that it is
+// offered compression again once it is upgraded
+const PlainRetryAfter = 10 * time.Minute
+
+const (
+	handshakeMagic = 0x8c // gob streams start with a byte count below 0x80 or above 0xf7
+	codecPlain     = 0
+	codecSnappy    = 1 << 0
+)
+
+var (
+	plainMu      sync.Mutex
+	plainServers = make(map[string]time.Time) // servers that hung up on the handshake, and when
+)
+
+// codecConn is a connection using the codec agreed on in the handshake
+type codecConn struct {
+	net.Conn
+	r  io.Reader
+	mu sync.Mutex
+	w  *snappy.Writer // nil without compression
+}
+
+func newCodecConn(conn net.Conn, r io.Reader, codec byte) *codecConn {
+	c := &codecConn{Conn: conn, r: r}
+	if codec == codecSnappy {
+		c.r = snappy.NewReader(r)
+		c.w = snappy.NewBufferedWriter(conn)
+	}
+	return c
+}
+
+func (c *codecConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write sends p at once. net/rpc buffers each message and flushes it whole
+func (c *codecConn) Write(p []byte) (int, error) {
+	if c.w == nil {
+		return c.Conn.Write(p)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	return n, err
+}
+
+// dialNegotiated dials the RPC server at remoteIpPort and offers it compression. A server that hangs up on the
+// offer is dialed again with redial, without the offer, and not offered compression for PlainRetryAfter. The
+// closed connection may hold on to its local port for a while, so redial must not use the same one
+func dialNegotiated(remoteIpPort string, dial func() (net.Conn, error), redial func() (net.Conn, error)) (net.Conn, error) {
+	plainMu.Lock()
+	since, plain := plainServers[remoteIpPort]
+	if plain && time.Since(since) > PlainRetryAfter {
+		delete(plainServers, remoteIpPort)
+		plain = false
+	}
+	plainMu.Unlock()
+	conn, err := dial()
+	if err != nil || !CompressRPC || plain {
+		return conn, err
+	}
+	codec, err := offerCodecs(conn)
+	if err == nil {
+		return newCodecConn(conn, conn, codec), nil
+	}
+	conn.Close()
+	log.Printf("[INFO] RPC server at %s does not negotiate compression (%v), using plain connections\n", remoteIpPort, err)
+	plainMu.Lock()
+	plainServers[remoteIpPort] = time.Now()
+	plainMu.Unlock()
+	return redial()
+}
+
+// offerCodecs runs the client side of the handshake and returns the codec the server picked
+func offerCodecs(conn net.Conn) (byte, error) {
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return codecPlain, err
+	}
+	if _, err := conn.Write([]byte{handshakeMagic, codecSnappy}); err != nil {
+		return codecPlain, err
+	}
+	answer := make([]byte, 2)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return codecPlain, err
+	}
+	if answer[0] != handshakeMagic {
+		return codecPlain, io.ErrUnexpectedEOF
+	}
+	return answer[1], conn.SetDeadline(time.Time{})
+}
+
+// negotiatingListener accepts connections that run the server side of the handshake on their first read, so a
+// slow client does not hold up Accept
+type negotiatingListener struct {
+	net.Listener
+}
+
+func (l negotiatingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &serverConn{Conn: conn}, nil
+}
+
+type serverConn struct {
+	net.Conn
+	once  sync.Once
+	codec *codecConn
+	err   error
+}
+
+// negotiate answers the handshake if the client opened with one, and serves it plain otherwise
+func (c *serverConn) negotiate() {
+	r := bufio.NewReader(c.Conn)
+	first, err := r.Peek(1)
+	if err != nil {
+		c.err = err
+		return
+	}
+	if first[0] != handshakeMagic {
+		c.codec = newCodecConn(c.Conn, r, codecPlain)
+		return
+	}
+	offer := make([]byte, 2)
+	if _, c.err = io.ReadFull(r, offer); c.err != nil {
+		return
+	}
+	codec := byte(codecPlain)
+	if offer[1]&codecSnappy != 0 {
+		codec = codecSnappy
+	}
+	if _, c.err = c.Conn.Write([]byte{handshakeMagic, codec}); c.err != nil {
+		return
+	}
+	c.codec = newCodecConn(c.Conn, r, codec)
+}
+
+func (c *serverConn) Read(p []byte) (int, error) {
+	c.once.Do(c.negotiate)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.codec.Read(p)
+}
+
+func (c *serverConn) Write(p []byte) (int, error) {
+	c.once.Do(c.negotiate)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.codec.Write(p)
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialOldServer(t *testing.T) {
+	// a server from before compression does not answer the handshake, so the client hangs up first and its
+	// local port is not free again right away
+	listener, err := net.Listen("tcp", AnyPort("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	offered := make(chan bool, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			first := make([]byte, 2)
+			if _, err = conn.Read(first); err != nil || first[0] == handshakeMagic {
+				conn.Write([]byte{0, 0})
+				ioutil.ReadAll(conn)
+				conn.Close()
+				offered <- true
+				continue
+			}
+			offered <- false
+		}
+	}()
+	remote := listener.Addr().String()
+	defer func() {
+		plainMu.Lock()
+		delete(plainServers, remote)
+		plainMu.Unlock()
+	}()
+	// send sends a call from a fixed local port and returns whether the connection opened with the handshake
+	send := func() bool {
+		t.Helper()
+		free, err := net.Listen("tcp", AnyPort("127.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		local := free.Addr().String()
+		free.Close()
+		client, err := NewRPCClient(local, remote)
+		if err != nil {
+			t.Fatalf("dial of a server without compression: %v", err)
+		}
+		defer client.Close()
+		client.Go("API.Call", 0, nil, nil)
+		offer := <-offered
+		if offer {
+			// the connection that is used comes next
+			if <-offered {
+				t.Fatal("compression is offered again on the redial")
+			}
+		}
+		return offer
+	}
+
+	// the redial goes out from another local port than the fixed one of the first connection
+	if !send() {
+		t.Fatal("compression is not offered to a new server")
+	}
+	if send() {
+		t.Fatal("compression is offered again right after the server hung up on it")
+	}
+	plainMu.Lock()
+	plainServers[remote] = time.Now().Add(-PlainRetryAfter - time.Second)
+	plainMu.Unlock()
+	if !send() {
+		t.Fatal("compression is not offered again after PlainRetryAfter")
+	}
+}
@@ -2,20 +2,80 @@ package util
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/dgraph-io/badger/v3"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// every stored value is followed by a CRC32 checksum of the value
+const checksumLength = 4
+
+// ValueFormatKey records whether the values of a database carry a checksum: ValueFormatChecksummed, written by
+// New and Reseal, or ValueFormatLegacy for a database written before checksums, whose values without one are
+// read as they are until migrated. A database found without the key is marked legacy only if none of its values
+// has a checksum, see detectValueFormat
+const ValueFormatKey = "valueformat"
+
+const (
+	ValueFormatChecksummed = "crc32"
+	ValueFormatLegacy      = "none"
+)
+
+const encryptionKeyLength = 32 // AES-256
+
+var (
+	ErrChecksumMismatch = errors.New("stored value does not match its checksum")
+	ErrDecryptionFailed = errors.New("stored value cannot be decrypted")
 )
 
 type Database struct {
+	mu       sync.RWMutex // write-locked to open and close instance, read-locked while it is used
 	instance *badger.DB
+	inMemory bool
+	aead     cipher.AEAD // encrypts values at rest when not nil
+	legacy   bool        // values may lack a checksum, see ValueFormatKey
+}
+
+// Stats summarizes the content of the database
+type Stats struct {
+	Keys        int
+	KeyBytes    int64
+	ValueBytes  int64
+	LSMSize     int64 // on-disk size of the LSM tree
+	VLogSize    int64 // on-disk size of the value log
+	PrefixCount map[string]int
+}
+
+// Iterator streams key-value pairs with a common prefix one at a time
+type Iterator struct {
+	db      *Database
+	txn     *badger.Txn
+	it      *badger.Iterator
+	prefix  []byte
+	started bool
 }
 
 func (db *Database) Opened() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return db.instance != nil
 }
 
 func (db *Database) KeyExist(key []byte) (found bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	err := db.instance.View(func(txn *badger.Txn) error {
 		_, err := txn.Get(key)
 		return err
@@ -29,12 +89,14 @@ func (db *Database) KeyExist(key []byte) (found bool) {
 }
 
 func (db *Database) Put(key []byte, value []byte) error {
-	if !db.Opened() {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
 		return errors.New("no database instance has been created")
 	}
 
 	err := db.instance.Update(func(txn *badger.Txn) error {
-		err := txn.Set(key, value)
+		err := txn.Set(key, db.seal(key, value))
 		if err != nil {
 			return err
 		}
@@ -44,7 +106,9 @@ func (db *Database) Put(key []byte, value []byte) error {
 }
 
 func (db *Database) PutMulti(keys [][]byte, values [][]byte) error {
-	if !db.Opened() {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
 		return errors.New("no database instance has been created")
 	}
 	if len(keys) != len(values) {
@@ -53,7 +117,7 @@ func (db *Database) PutMulti(keys [][]byte, values [][]byte) error {
 
 	err := db.instance.Update(func(txn *badger.Txn) error {
 		for idx, _ := range keys {
-			err := txn.Set(keys[idx], values[idx])
+			err := txn.Set(keys[idx], db.seal(keys[idx], values[idx]))
 			if err != nil {
 				return err
 			}
@@ -64,6 +128,8 @@ func (db *Database) PutMulti(keys [][]byte, values [][]byte) error {
 }
 
 func (db *Database) Get(key []byte) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	var valCopy []byte
 	err := db.instance.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(key)
@@ -74,8 +140,8 @@ func (db *Database) Get(key []byte) ([]byte, error) {
 		err = item.Value(func(val []byte) error {
 			// This func with val would only be called if item.Value encounters no error.
 			// Copying or parsing val is valid.
-			valCopy = append([]byte{}, val...)
-			return nil
+			valCopy, err = db.unseal(key, val)
+			return err
 		})
 		if err != nil {
 			return err
@@ -87,6 +153,8 @@ func (db *Database) Get(key []byte) ([]byte, error) {
 }
 
 func (db *Database) GetMulti(keys [][]byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	var valCopy [][]byte
 	err := db.instance.View(func(txn *badger.Txn) error {
 		for _, key := range keys {
@@ -98,8 +166,9 @@ func (db *Database) GetMulti(keys [][]byte) ([][]byte, error) {
 			err = item.Value(func(val []byte) error {
 				// This func with val would only be called if item.Value encounters no error.
 				// Copying or parsing val is valid.
-				valCopy = append(valCopy, append([]byte{}, val...))
-				return nil
+				value, err := db.unseal(key, val)
+				valCopy = append(valCopy, value)
+				return err
 			})
 			if err != nil {
 				return err
@@ -112,24 +181,36 @@ func (db *Database) GetMulti(keys [][]byte) ([][]byte, error) {
 }
 
 func (db *Database) GetAllWithPrefix(prefix string) (values [][]byte, err error) {
-	err = db.instance.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
-		prefix := []byte(prefix)
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			_ = item.Value(func(v []byte) error {
-				values = append(values, append([]byte{}, v...))
-				return nil
-			})
+	iter := db.NewIterator(prefix)
+	defer iter.Close()
+	for iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			return nil, err
 		}
-		return nil
-	})
-	return values, err
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// NewIterator returns an iterator over all entries whose key starts with prefix.
+// The iterator reads from a consistent snapshot and must be closed after use.
+func (db *Database) NewIterator(prefix string) *Iterator {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	txn := db.instance.NewTransaction(false)
+	return &Iterator{
+		db:     db,
+		txn:    txn,
+		it:     txn.NewIterator(badger.DefaultIteratorOptions),
+		prefix: []byte(prefix),
+	}
 }
 
 func (db *Database) Remove(key []byte) error {
-	if !db.Opened() {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
 		return errors.New("no database instance has been created")
 	}
 
@@ -143,8 +224,29 @@ func (db *Database) Remove(key []byte) error {
 	return err
 }
 
+func (db *Database) RemoveMulti(keys [][]byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
+		return errors.New("no database instance has been created")
+	}
+
+	err := db.instance.Update(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			err := txn.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
 func (db *Database) New(dbPath string, inMemory bool) error {
-	if db.Opened() {
+	db.mu.Lock()
+	if db.instance != nil {
+		db.mu.Unlock()
 		return errors.New("database instance already created")
 	}
 
@@ -163,34 +265,462 @@ func (db *Database) New(dbPath string, inMemory bool) error {
 	// open database
 	instance, err := badger.Open(opt)
 	if err != nil {
+		db.mu.Unlock()
 		return err
 	}
 	db.instance = instance
-	return nil
+	db.inMemory = inMemory
+	db.mu.Unlock()
+	return db.MarkChecksummed()
 }
 
 func (db *Database) Load(dbPath string) error {
-	if db.Opened() {
+	return db.load(dbPath, false)
+}
+
+// LoadReadOnly is Load for tools that only inspect a database: every write fails. The database must have been
+// closed cleanly
+func (db *Database) LoadReadOnly(dbPath string) error {
+	return db.load(dbPath, true)
+}
+
+func (db *Database) load(dbPath string, readOnly bool) error {
+	db.mu.Lock()
+	if db.instance != nil {
+		db.mu.Unlock()
 		return errors.New("database instance already created")
 	}
 	// check database path
 	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		db.mu.Unlock()
 		return errors.New("database not found")
 	}
 	// open database
-	instance, err := badger.Open(badger.DefaultOptions(dbPath).WithLogger(nil))
+	instance, err := badger.Open(badger.DefaultOptions(dbPath).WithLogger(nil).WithReadOnly(readOnly))
 	if err != nil {
+		db.mu.Unlock()
 		return err
 	}
 	db.instance = instance
+	db.mu.Unlock()
+	if err = db.detectValueFormat(!readOnly); err != nil {
+		db.Close()
+		return err
+	}
 	return nil
 }
 
+// detectValueFormat sets legacy from ValueFormatKey. A database without it predates checksums only if none of
+// its values has one; it is marked ValueFormatLegacy then, if mark, and ValueFormatChecksummed otherwise, so that a
+// lost key never has values without a checksum read as they are
+func (db *Database) detectValueFormat(mark bool) error {
+	if db.KeyExist([]byte(ValueFormatKey)) {
+		// legacy until the key is read, as the key of a legacy database has no checksum either
+		db.legacy = true
+		format, err := db.Get([]byte(ValueFormatKey))
+		if err != nil {
+			return fmt.Errorf("reading the value format: %v", err)
+		}
+		switch string(format) {
+		case ValueFormatChecksummed:
+			db.legacy = false
+		case ValueFormatLegacy:
+		default:
+			return fmt.Errorf("unknown value format %q", format)
+		}
+		return nil
+	}
+	checksummed, err := db.hasChecksummedValue()
+	if err != nil {
+		return err
+	}
+	db.legacy = !checksummed
+	if !mark {
+		return nil
+	}
+	if checksummed {
+		log.Println("[WARN] Database has lost its value format, its values must all carry a checksum")
+		return db.MarkChecksummed()
+	}
+	return db.Put([]byte(ValueFormatKey), []byte(ValueFormatLegacy))
+}
+
+// hasChecksummedValue checks whether any stored value ends with a checksum of the rest
+func (db *Database) hasChecksummedValue() (found bool, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	err = db.instance.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid() && !found; it.Next() {
+			err := it.Item().Value(func(val []byte) error {
+				found = hasChecksum(val)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+// Reseal rewrites every value of a legacy database with a checksum and marks the database checksummed. It
+// returns the number of values rewritten
+func (db *Database) Reseal() (resealed int, err error) {
+	if !db.Opened() {
+		return 0, errors.New("no database instance has been created")
+	}
+	const batchSize = 256
+	var keys, values [][]byte
+	iter := db.NewIterator("")
+	defer iter.Close()
+	for iter.Next() {
+		value, err := iter.Value()
+		if err != nil {
+			return resealed, err
+		}
+		keys = append(keys, iter.Key())
+		values = append(values, value)
+		if len(keys) >= batchSize {
+			if err = db.PutMulti(keys, values); err != nil {
+				return resealed, err
+			}
+			resealed += len(keys)
+			keys, values = nil, nil
+		}
+	}
+	if err = db.PutMulti(keys, values); err != nil {
+		return resealed, err
+	}
+	resealed += len(keys)
+	return resealed, db.MarkChecksummed()
+}
+
+// Legacy reports whether the database was written before checksums and some of its values may lack one
+func (db *Database) Legacy() bool {
+	return db.legacy
+}
+
+// MarkChecksummed records that every value of the database carries a checksum, e.g. once all are rewritten
+func (db *Database) MarkChecksummed() error {
+	if err := db.Put([]byte(ValueFormatKey), []byte(ValueFormatChecksummed)); err != nil {
+		return err
+	}
+	db.legacy = false
+	return nil
+}
+
+// Close closes the database once the operations in progress, e.g. a Compact, are done
 func (db *Database) Close() {
-	db.instance.Close()
-	db.instance = nil
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.instance != nil {
+		db.instance.Close()
+		db.instance = nil
+	}
+}
+
+// EnableEncryption encrypts every value written from now on with AES-GCM. Should be called before New or Load,
+// and the same key must be used every time the database is opened.
+func (db *Database) EnableEncryption(key []byte) error {
+	if len(key) != encryptionKeyLength {
+		return fmt.Errorf("encryption key should be %d bytes, got %d", encryptionKeyLength, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	db.aead = aead
+	return nil
+}
+
+// LoadKeyFile reads a node key from path. A missing file is an error rather than a reason to generate a key:
+// a database encrypted with a new key cannot be read, and a lost key loses the data
+func LoadKeyFile(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("storage key %s does not exist, create it with e.g. `head -c %d /dev/urandom > %s`",
+			path, encryptionKeyLength, path)
+	}
+	return key, err
+}
+
+// Compact flattens the LSM tree and reclaims space in the value log. Close waits for it
+func (db *Database) Compact() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
+		return errors.New("no database instance has been created")
+	}
+	err := db.instance.Flatten(1)
+	if err != nil {
+		return err
+	}
+	if db.inMemory {
+		// there is no value log in disk-less mode
+		return nil
+	}
+	// keep collecting until there is nothing left to rewrite
+	for {
+		err = db.instance.RunValueLogGC(0.5)
+		if err == badger.ErrNoRewrite {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Maintain periodically compacts the database and logs its size until the database is closed
+func (db *Database) Maintain(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if !db.Opened() {
+			return
+		}
+		if err := db.Compact(); err != nil {
+			if !db.Opened() {
+				return
+			}
+			log.Println("[WARN] Unable to compact database:", err)
+		}
+		stats, err := db.Stats()
+		if err != nil {
+			log.Println("[WARN] Unable to collect database stats:", err)
+			continue
+		}
+		log.Printf("[INFO] Database: %d keys, %d key bytes, %d value bytes, %v\n",
+			stats.Keys, stats.KeyBytes, stats.ValueBytes, stats.PrefixCount)
+	}
+}
+
+// Backup writes a consistent snapshot of the database to w. Safe to call while the database is in use.
+func (db *Database) Backup(w io.Writer) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
+		return errors.New("no database instance has been created")
+	}
+	_, err := db.instance.Backup(w, 0)
+	return err
+}
+
+// Restore loads a snapshot produced by Backup into the database. Should be called on a fresh database.
+// A snapshot of a legacy database leaves the database legacy
+func (db *Database) Restore(r io.Reader) error {
+	if !db.Opened() {
+		return errors.New("no database instance has been created")
+	}
+	if err := db.Remove([]byte(ValueFormatKey)); err != nil {
+		return err
+	}
+	db.mu.RLock()
+	err := db.instance.Load(r, 256)
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return db.detectValueFormat(true)
+}
+
+// BackupToFile writes a snapshot to the given path through a temporary file, so a crash never leaves a torn backup
+func (db *Database) BackupToFile(path string) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = db.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// RestoreFromFile loads a snapshot file produced by BackupToFile
+func (db *Database) RestoreFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return db.Restore(f)
+}
+
+// ScheduleBackup periodically writes snapshots into dir until the database is closed
+func (db *Database) ScheduleBackup(dir string, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if !db.Opened() {
+			return
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Println("[WARN] Unable to create backup directory:", err)
+			continue
+		}
+		path := filepath.Join(dir, "backup-"+time.Now().Format("20060102-150405")+".bak")
+		if err := db.BackupToFile(path); err != nil {
+			log.Println("[WARN] Unable to back up database:", err)
+		} else {
+			log.Println("[INFO] Database backed up to", path)
+		}
+	}
+}
+
+// Stats counts keys and bytes in the database. Keys are grouped by the prefix before their first '-'.
+func (db *Database) Stats() (Stats, error) {
+	stats := Stats{PrefixCount: make(map[string]int)}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
+		return stats, errors.New("no database instance has been created")
+	}
+	stats.LSMSize, stats.VLogSize = db.instance.Size()
+	err := db.instance.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			stats.Keys++
+			stats.KeyBytes += int64(len(key))
+			stats.ValueBytes += item.ValueSize()
+			stats.PrefixCount[KeyPrefix(key)]++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// CheckIntegrity verifies the checksum of every stored value and returns the keys that failed. Values of a legacy
+// database without a checksum cannot be checked and do not fail
+func (db *Database) CheckIntegrity() (corrupted [][]byte, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.instance == nil {
+		return nil, errors.New("no database instance has been created")
+	}
+	err = db.instance.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			err := item.Value(func(val []byte) error {
+				_, err := db.unseal(item.Key(), val)
+				return err
+			})
+			if err == ErrChecksumMismatch || err == ErrDecryptionFailed {
+				corrupted = append(corrupted, item.KeyCopy(nil))
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return corrupted, err
 }
 
 func DBKeyWithPrefix(prefix string, key []byte) []byte {
 	return bytes.Join([][]byte{[]byte(prefix), key}, []byte{})
 }
+
+// ----- Iterator APIs -----
+
+// Next advances the iterator and reports whether there is an entry to read
+func (iter *Iterator) Next() bool {
+	if !iter.started {
+		iter.it.Seek(iter.prefix)
+		iter.started = true
+	} else {
+		iter.it.Next()
+	}
+	return iter.it.ValidForPrefix(iter.prefix)
+}
+
+// Key returns a copy of the current key
+func (iter *Iterator) Key() []byte {
+	return iter.it.Item().KeyCopy(nil)
+}
+
+// Value returns a copy of the current value
+func (iter *Iterator) Value() (value []byte, err error) {
+	err = iter.it.Item().Value(func(val []byte) error {
+		value, err = iter.db.unseal(iter.it.Item().Key(), val)
+		return err
+	})
+	return value, err
+}
+
+// Close releases the underlying snapshot
+func (iter *Iterator) Close() {
+	iter.it.Close()
+	iter.txn.Discard()
+}
+
+// KeyPrefix returns the part of the key up to and including its first '-'. Keys without one are their own prefix.
+func KeyPrefix(key []byte) string {
+	if idx := bytes.IndexByte(key, '-'); idx >= 0 {
+		return string(key[:idx+1])
+	}
+	return string(key)
+}
+
+// ----- Internal functions -----
+
+// seal encrypts the value (if enabled) and appends a checksum before it is written to disk
+func (db *Database) seal(key []byte, value []byte) []byte {
+	if db.aead != nil {
+		nonce := make([]byte, db.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			log.Panic(err)
+		}
+		// the key is authenticated so that a value cannot be moved under another key
+		value = db.aead.Seal(nonce, nonce, value, key)
+	}
+	sealed := make([]byte, len(value)+checksumLength)
+	copy(sealed, value)
+	binary.BigEndian.PutUint32(sealed[len(value):], crc32.ChecksumIEEE(value))
+	return sealed
+}
+
+// hasChecksum checks whether a stored value ends with the checksum of the rest
+func hasChecksum(stored []byte) bool {
+	return len(stored) >= checksumLength && binary.BigEndian.Uint32(stored[len(stored)-checksumLength:]) ==
+		crc32.ChecksumIEEE(stored[:len(stored)-checksumLength])
+}
+
+// unseal verifies the checksum of a stored value, decrypts it (if enabled) and returns a copy of the original value.
+// In a legacy database, a value whose checksum does not match is taken to have none
+func (db *Database) unseal(key []byte, stored []byte) ([]byte, error) {
+	value := stored
+	if hasChecksum(stored) {
+		value = stored[:len(stored)-checksumLength]
+	} else if !db.legacy {
+		return nil, ErrChecksumMismatch
+	}
+	if db.aead != nil {
+		nonceSize := db.aead.NonceSize()
+		if len(value) < nonceSize {
+			return nil, ErrDecryptionFailed
+		}
+		plain, err := db.aead.Open(nil, value[:nonceSize], value[nonceSize:], key)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		return plain, nil
+	}
+	return append([]byte{}, value...), nil
+}
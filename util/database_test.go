@@ -0,0 +1,110 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// putRaw stores a value as a version without checksums did
+func putRaw(t *testing.T, db *Database, key string, value string) {
+	t.Helper()
+	err := db.instance.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), []byte(value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLegacyValues(t *testing.T) {
+	db := &Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Legacy() {
+		t.Fatal("a new database is legacy")
+	}
+
+	// a database written before checksums has no format key
+	if err := db.Remove([]byte(ValueFormatKey)); err != nil {
+		t.Fatal(err)
+	}
+	putRaw(t, db, "LastHash", "hash")
+	putRaw(t, db, "block-1", "block")
+	if err := db.detectValueFormat(true); err != nil || !db.Legacy() {
+		t.Fatalf("database without checksums is not legacy: %v", err)
+	}
+	if format, err := db.Get([]byte(ValueFormatKey)); err != nil || string(format) != ValueFormatLegacy {
+		t.Fatalf("value format %q, %v", format, err)
+	}
+	if err := db.Put([]byte("header-2"), []byte("header")); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{"LastHash": "hash", "block-1": "block", "header-2": "header"} {
+		if value, err := db.Get([]byte(key)); err != nil || string(value) != want {
+			t.Fatalf("Get(%s) = %q, %v, want %q", key, value, err, want)
+		}
+	}
+	if corrupted, err := db.CheckIntegrity(); err != nil || len(corrupted) > 0 {
+		t.Fatalf("legacy values reported corrupted: %q, %v", corrupted, err)
+	}
+
+	resealed, err := db.Reseal()
+	if err != nil {
+		t.Fatalf("Reseal: %v", err)
+	}
+	// the three values and the format key
+	if resealed != 4 || db.Legacy() || !db.KeyExist([]byte(ValueFormatKey)) {
+		t.Fatalf("resealed %d values, legacy %v", resealed, db.Legacy())
+	}
+	if value, err := db.Get([]byte("LastHash")); err != nil || string(value) != "hash" {
+		t.Fatalf("Get(LastHash) after Reseal = %q, %v", value, err)
+	}
+
+	// once checksummed, a value without one is corrupted
+	putRaw(t, db, "LastHash", "hash")
+	if _, err = db.Get([]byte("LastHash")); err != ErrChecksumMismatch {
+		t.Fatalf("Get of a value without a checksum: %v, want %v", err, ErrChecksumMismatch)
+	}
+	corrupted, err := db.CheckIntegrity()
+	if err != nil || len(corrupted) != 1 || !bytes.Equal(corrupted[0], []byte("LastHash")) {
+		t.Fatalf("CheckIntegrity = %q, %v", corrupted, err)
+	}
+
+	// a checksummed database that lost its format key does not turn legacy
+	if err = db.Remove([]byte(ValueFormatKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.detectValueFormat(true); err != nil || db.Legacy() {
+		t.Fatalf("checksummed database without a format key is legacy: %v", err)
+	}
+	if _, err = db.Get([]byte("LastHash")); err != ErrChecksumMismatch {
+		t.Fatalf("Get of a value without a checksum: %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestCloseWaitsForUse(t *testing.T) {
+	db := &Database{}
+	if err := db.New("", true); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for db.Opened() {
+			// closed between the check and the call, it fails instead of using a closed instance
+			db.Put([]byte("key"), []byte("value"))
+			db.Compact()
+		}
+	}()
+	db.Close()
+	<-done
+	if err := db.Put([]byte("key"), []byte("value")); err == nil {
+		t.Fatal("Put on a closed database succeeded")
+	}
+	db.Close() // closing twice is harmless
+}
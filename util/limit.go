@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+)
+
+// MaxRPCMessageSize bounds a single gob message read by an RPC client or server, which is one request or reply
+// body. gob allocates what a message declares before reading it, so without a bound one crafted length prefix
+// makes a node allocate up to a gigabyte. The largest honest messages are chain chunks.
+var MaxRPCMessageSize = 64 << 20
+
+var ErrMessageTooLarge = errors.New("rpc: message is above MaxRPCMessageSize")
+
+// limitListener accepts connections whose gob messages are bounded by MaxRPCMessageSize
+type limitListener struct {
+	net.Listener
+}
+
+func (l limitListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return limitConn(conn), nil
+}
+
+// limitConn bounds the gob messages read from conn by MaxRPCMessageSize
+func limitConn(conn net.Conn) net.Conn {
+	return &frameLimitConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+// frameLimitConn follows the message framing of the gob stream it reads: every message starts with its byte
+// count, a byte below 0x80 or the negated length of a big-endian count of up to 8 bytes
+type frameLimitConn struct {
+	net.Conn
+	mu        sync.Mutex
+	r         *bufio.Reader
+	pending   []byte // count of the current message, passed on before its content
+	remaining int    // bytes of the current message not read yet
+}
+
+func (c *frameLimitConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 && c.remaining == 0 {
+		if err := c.readCount(); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if len(p) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= n
+	return n, err
+}
+
+// readCount reads the byte count of the next message and refuses it if it is above the limit
+func (c *frameLimitConn) readCount() error {
+	first, err := c.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	c.pending = append(c.pending[:0], first)
+	count := uint64(first)
+	if first >= 0x80 {
+		width := int(-int8(first))
+		if width < 1 || width > 8 {
+			return fmt.Errorf("rpc: malformed message count from %s", c.RemoteAddr())
+		}
+		count = 0
+		for i := 0; i < width; i++ {
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return err
+			}
+			c.pending = append(c.pending, b)
+			count = count<<8 | uint64(b)
+		}
+	}
+	if count > uint64(MaxRPCMessageSize) {
+		log.Printf("[WARN] Dropped RPC connection with %s: message of %d bytes\n", c.RemoteAddr(), count)
+		return ErrMessageTooLarge
+	}
+	c.remaining = int(count)
+	return nil
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readConn is a connection that reads a fixed stream
+type readConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c readConn) RemoteAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1} }
+
+func limitedStream(data []byte) net.Conn {
+	return limitConn(readConn{r: bytes.NewReader(data)})
+}
+
+func TestFrameLimitConn(t *testing.T) {
+	type message struct {
+		Name string
+		Data []byte
+	}
+	sent := []message{{"small", []byte{1}}, {"empty", nil}, {"large", bytes.Repeat([]byte{7}, 1000)}}
+	var stream bytes.Buffer
+	enc := gob.NewEncoder(&stream)
+	for _, m := range sent {
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// several messages in a row, whatever the size of the reads
+	dec := gob.NewDecoder(limitedStream(stream.Bytes()))
+	for _, want := range sent {
+		var got message
+		if err := dec.Decode(&got); err != nil || got.Name != want.Name || !bytes.Equal(got.Data, want.Data) {
+			t.Fatalf("decoded %+v, %v, want %+v", got, err, want)
+		}
+	}
+	var bytewise []byte
+	conn := limitedStream(stream.Bytes())
+	for buf := make([]byte, 1); ; {
+		n, err := conn.Read(buf)
+		bytewise = append(bytewise, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if !reflect.DeepEqual(bytewise, stream.Bytes()) {
+		t.Fatal("reading a byte at a time changes the stream")
+	}
+
+	// a message above the limit is refused before it is read
+	defer func(limit int) { MaxRPCMessageSize = limit }(MaxRPCMessageSize)
+	MaxRPCMessageSize = 100
+	dec = gob.NewDecoder(limitedStream(stream.Bytes()))
+	var got message
+	for i := 0; i < 2; i++ {
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("message %d below the limit: %v", i, err)
+		}
+	}
+	if err := dec.Decode(&got); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("message above the limit: %v, want ErrMessageTooLarge", err)
+	}
+	if _, err := ioutil.ReadAll(limitedStream([]byte{0xF8, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("count of 8 bytes above the limit: %v, want ErrMessageTooLarge", err)
+	}
+	MaxRPCMessageSize = 64 << 20
+
+	// truncated and malformed counts fail the message
+	for name, tc := range map[string]struct {
+		data []byte
+		want string // in the error. any error if empty
+	}{
+		"count cut short":   {[]byte{0xFE, 0x01}, ""},
+		"message cut short": {[]byte{0x05, 1, 2}, ""},
+		"count of 9 bytes":  {[]byte{0xF7, 0, 0, 0, 0, 0, 0, 0, 0, 1}, "malformed message count"},
+		"count of no bytes": {[]byte{0x80}, "malformed message count"},
+	} {
+		err := gob.NewDecoder(limitedStream(tc.data)).Decode(&got)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want an error with %q", name, err, tc.want)
+		}
+	}
+}
@@ -5,57 +5,180 @@ import (
 	"net"
 	"net/rpc"
 	"strconv"
+	"sync"
 )
 
+// the RPC servers of this process listening at a fixed address, shared by the services registered with ListenRPCAs
+var (
+	sharedMu      sync.Mutex
+	sharedServers = make(map[string]*sharedServer)
+)
+
+type sharedServer struct {
+	server   *rpc.Server
+	listener net.Listener
+	token    string // see ListenRPCWithToken
+	users    int
+}
+
+// sharedListener is the listener of a shared server as seen by one service. Closing it closes the server's
+// listener once every service closed its own.
+type sharedListener struct {
+	net.Listener
+	addr string
+	once sync.Once
+}
+
+// Addresses are host:port strings where the host is an IPv4 address, a bracketed IPv6 address (e.g. [::1]:8000)
+// or a DNS name. They are split and joined with the helpers below, never at the first colon
+
+// HostOf returns the host of a host:port address, without the brackets of an IPv6 address. addr itself if it
+// has no port
+func HostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// WithHost returns addr with its host replaced by host, e.g. to reach a local port under a public name
+func WithHost(addr string, host string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// AnyPort returns the address of any free port of host, to listen at
+func AnyPort(host string) string {
+	return net.JoinHostPort(host, "0")
+}
+
+// ListenerAddr returns the address of host at the port listener got
+func ListenerAddr(host string, listener net.Listener) string {
+	return net.JoinHostPort(host, strconv.Itoa(listener.Addr().(*net.TCPAddr).Port))
+}
+
+// NewRPCClient connects to the RPC server at remoteIpPort from localIpPort. A remote DNS name may resolve to
+// several addresses; only those of the family of the local address are tried
 func NewRPCClient(localIpPort string, remoteIpPort string) (*rpc.Client, error) {
 	laddr, err := net.ResolveTCPAddr("tcp", localIpPort)
 	if err != nil {
 		return nil, errors.New("cannot resolve local address: " + localIpPort)
 	}
-	raddr, err := net.ResolveTCPAddr("tcp", remoteIpPort)
+	dialer := net.Dialer{LocalAddr: laddr}
+	// the same host at any port, the port of the first connection is not free again right away
+	redialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: laddr.IP, Zone: laddr.Zone}}
+	conn, err := dialNegotiated(remoteIpPort, func() (net.Conn, error) {
+		return dialer.Dial("tcp", remoteIpPort)
+	}, func() (net.Conn, error) {
+		return redialer.Dial("tcp", remoteIpPort)
+	})
 	if err != nil {
-		return nil, errors.New("cannot resolve remote address: " + remoteIpPort)
+		return nil, err
 	}
-	conn, err := net.DialTCP("tcp", laddr, raddr)
+	return newRPCClient(limitConn(conn), remoteIpPort), nil
+}
+
+// DialRPC connects to the RPC server at remoteIpPort
+func DialRPC(remoteIpPort string) (*rpc.Client, error) {
+	dial := func() (net.Conn, error) {
+		return net.Dial("tcp", remoteIpPort)
+	}
+	conn, err := dialNegotiated(remoteIpPort, dial, dial)
 	if err != nil {
 		return nil, err
 	}
-	return rpc.NewClient(conn), nil
+	return newRPCClient(limitConn(conn), remoteIpPort), nil
 }
 
-func NewRPCServerWithIpPort(handler interface{}, listenIpPort string) error {
+// ListenRPC serves handler at listenIpPort (port 0 for any free port) until the returned listener is closed
+func ListenRPC(handler interface{}, listenIpPort string) (net.Listener, error) {
 	apiHandler := rpc.NewServer()
 	err := apiHandler.Register(handler)
 	if err != nil {
-		return errors.New("error registering API")
+		return nil, errors.New("error registering API")
 	}
 	lAddr, err := net.ResolveTCPAddr("tcp", listenIpPort)
 	if err != nil {
-		return errors.New("cannot resolve address " + listenIpPort)
+		return nil, errors.New("cannot resolve address " + listenIpPort)
 	}
 	listener, err := net.ListenTCP("tcp", lAddr)
 	if err != nil {
-		return errors.New("cannot listen for at " + listenIpPort)
+		return nil, errors.New("cannot listen at " + listenIpPort)
 	}
-	go apiHandler.Accept(listener)
-	return nil
+	go serveRPC(apiHandler, limitListener{negotiatingListener{listener}})
+	return listener, nil
 }
 
-func NewRPCServerWithIp(handler interface{}, listenIp string) (string, error) {
-	apiHandler := rpc.NewServer()
-	err := apiHandler.Register(handler)
+// ListenRPCAs is ListenRPC serving handler under the given service name. Services listening at the same fixed
+// address (not port 0) in a process share one listener, e.g. the services of several elections.
+func ListenRPCAs(name string, handler interface{}, listenIpPort string) (net.Listener, error) {
+	return ListenRPCAsWithToken(name, handler, listenIpPort, "")
+}
+
+// ListenRPCAsWithToken is ListenRPCAs serving only clients that send token, see ListenRPCWithToken. Services
+// sharing a listener must share the token too
+func ListenRPCAsWithToken(name string, handler interface{}, listenIpPort string, token string) (net.Listener, error) {
+	lAddr, err := net.ResolveTCPAddr("tcp", listenIpPort)
 	if err != nil {
-		return "", errors.New("error registering API")
+		return nil, errors.New("cannot resolve address " + listenIpPort)
 	}
-	listenAddr := listenIp + ":0"
-	lAddr, err := net.ResolveTCPAddr("tcp", listenAddr)
-	if err != nil {
-		return "", errors.New("cannot resolve address " + listenAddr)
+	key := lAddr.String()
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	shared := sharedServers[key]
+	if shared == nil || lAddr.Port == 0 {
+		listener, err := net.ListenTCP("tcp", lAddr)
+		if err != nil {
+			return nil, errors.New("cannot listen at " + listenIpPort)
+		}
+		shared = &sharedServer{server: rpc.NewServer(), listener: listener, token: token}
+		key = listener.Addr().String()
+		go serveRPC(shared.server, limitListener{negotiatingListener{guardListener(listener, token)}})
+		sharedServers[key] = shared
+	} else if shared.token != token {
+		return nil, errors.New("services at " + listenIpPort + " need the same RPC token")
 	}
-	listener, err := net.ListenTCP("tcp", lAddr)
+	if err := shared.server.RegisterName(name, handler); err != nil {
+		if shared.users == 0 {
+			shared.listener.Close()
+			delete(sharedServers, key)
+		}
+		return nil, errors.New("error registering API " + name + ": " + err.Error())
+	}
+	shared.users++
+	return &sharedListener{Listener: shared.listener, addr: key}, nil
+}
+
+func (l *sharedListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		sharedMu.Lock()
+		defer sharedMu.Unlock()
+		shared := sharedServers[l.addr]
+		if shared == nil {
+			return
+		}
+		if shared.users--; shared.users == 0 {
+			err = shared.listener.Close()
+			delete(sharedServers, l.addr)
+		}
+	})
+	return err
+}
+
+func NewRPCServerWithIpPort(handler interface{}, listenIpPort string) error {
+	_, err := ListenRPC(handler, listenIpPort)
+	return err
+}
+
+func NewRPCServerWithIp(handler interface{}, listenIp string) (string, error) {
+	listener, err := ListenRPC(handler, AnyPort(listenIp))
 	if err != nil {
-		return "", errors.New("cannot listen at " + listenAddr)
+		return "", err
 	}
-	go apiHandler.Accept(listener)
-	return listenIp + ":" + strconv.Itoa(listener.Addr().(*net.TCPAddr).Port), nil
+	return ListenerAddr(listenIp, listener), nil
 }
@@ -0,0 +1,55 @@
+package util
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestHostOf(t *testing.T) {
+	for _, tc := range []struct{ addr, host string }{
+		{"127.0.0.1:8000", "127.0.0.1"},
+		{"[::1]:8000", "::1"},
+		{"[fe80::1%eth0]:8000", "fe80::1%eth0"},
+		{"coord.example.com:8000", "coord.example.com"},
+		{":8000", ""},
+		{"127.0.0.1", "127.0.0.1"},
+		{"::1", "::1"},
+	} {
+		if host := HostOf(tc.addr); host != tc.host {
+			t.Errorf("HostOf(%q) = %q, want %q", tc.addr, host, tc.host)
+		}
+	}
+}
+
+func TestWithHost(t *testing.T) {
+	for _, tc := range []struct{ addr, host, want string }{
+		{"127.0.0.1:8000", "10.0.0.1", "10.0.0.1:8000"},
+		{"127.0.0.1:8000", "::1", "[::1]:8000"},
+		{"[::1]:8000", "127.0.0.1", "127.0.0.1:8000"},
+		{":8000", "coord.example.com", "coord.example.com:8000"},
+		{"127.0.0.1", "10.0.0.1", "127.0.0.1"}, // no port, left as it is
+	} {
+		if got := WithHost(tc.addr, tc.host); got != tc.want {
+			t.Errorf("WithHost(%q, %q) = %q, want %q", tc.addr, tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestListenerAddr(t *testing.T) {
+	listener, err := net.Listen("tcp", AnyPort("127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	for _, tc := range []struct{ host, want string }{
+		{"127.0.0.1", "127.0.0.1:" + strconv.Itoa(port)},
+		{"::1", "[::1]:" + strconv.Itoa(port)},
+		{"miner.example.com", "miner.example.com:" + strconv.Itoa(port)},
+	} {
+		if got := ListenerAddr(tc.host, listener); got != tc.want {
+			t.Errorf("ListenerAddr(%q) = %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per key, e.g. per client address. Each key may make burst requests at once and
+// rate more every second after that. Buckets that filled up again are dropped, so idle keys cost nothing.
+type RateLimiter struct {
+	rate  float64 // tokens added per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second per key with bursts of burst requests,
+// or nil for no limit if rate is 0. A nil limiter allows every request.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token of key and returns whether there was one. If not, it also returns how long until the
+// next token.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets that filled up again, at most once per time it takes to fill a bucket
+func (l *RateLimiter) sweep(now time.Time) {
+	fill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < fill {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= fill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// CallerHost returns the host part of a caller address, so that reconnecting from another port does not get a
+// client a new bucket
+func CallerHost(addr string) string {
+	return HostOf(addr)
+}
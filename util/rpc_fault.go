@@ -0,0 +1,219 @@
+//go:build faultinject
+// +build faultinject
+
+package util
+
+// Fault injection for RPCs, compiled in only with `-tags faultinject` (e.g. go test -tags faultinject ./...).
+// All RPC clients and servers created through this package consult the policy set by SetFaultPolicy.
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// RPCCall describes a call about to be sent by a client or replied to by a server
+type RPCCall struct {
+	ServiceMethod string
+	RemoteAddr    string
+	Server        bool // true when the call is being served rather than made
+}
+
+// FaultAction is what happens to a call. The zero value delivers the call normally.
+type FaultAction struct {
+	Drop      bool          // client: the call fails with ErrInjectedFault. server: the connection is closed without a reply
+	Delay     time.Duration // the call (client) or its reply (server) is sent later. calls made after it can overtake it
+	Duplicate int           // client only: the call is sent this many extra times. extra replies are discarded
+}
+
+type FaultPolicy func(call RPCCall) FaultAction
+
+var ErrInjectedFault = errors.New("rpc: call dropped by fault injection")
+
+var (
+	policyMu    sync.RWMutex
+	faultPolicy FaultPolicy
+)
+
+// SetFaultPolicy sets the policy for all calls from now on. nil disables fault injection.
+func SetFaultPolicy(policy FaultPolicy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	faultPolicy = policy
+}
+
+// RandomFaults drops, duplicates and delays (by up to maxDelay) calls at random
+func RandomFaults(rng *rand.Rand, dropRate float64, duplicateRate float64, maxDelay time.Duration) FaultPolicy {
+	var mu sync.Mutex
+	return func(call RPCCall) FaultAction {
+		mu.Lock()
+		defer mu.Unlock()
+		var action FaultAction
+		action.Drop = rng.Float64() < dropRate
+		if !call.Server && rng.Float64() < duplicateRate {
+			action.Duplicate = 1
+		}
+		if maxDelay > 0 {
+			action.Delay = time.Duration(rng.Int63n(int64(maxDelay)))
+		}
+		return action
+	}
+}
+
+func decide(call RPCCall) FaultAction {
+	policyMu.RLock()
+	policy := faultPolicy
+	policyMu.RUnlock()
+	if policy == nil {
+		return FaultAction{}
+	}
+	return policy(call)
+}
+
+func newRPCClient(conn net.Conn, remoteIpPort string) *rpc.Client {
+	buf := bufio.NewWriter(conn)
+	return rpc.NewClientWithCodec(&faultClientCodec{
+		remoteAddr: remoteIpPort,
+		conn:       conn,
+		dec:        gob.NewDecoder(conn),
+		enc:        gob.NewEncoder(buf),
+		encBuf:     buf,
+	})
+}
+
+func serveRPC(server *rpc.Server, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		buf := bufio.NewWriter(conn)
+		go server.ServeCodec(&faultServerCodec{
+			conn:   conn,
+			dec:    gob.NewDecoder(conn),
+			enc:    gob.NewEncoder(buf),
+			encBuf: buf,
+		})
+	}
+}
+
+// faultClientCodec is net/rpc's gob client codec with faults applied to outgoing requests
+type faultClientCodec struct {
+	remoteAddr string
+	conn       io.ReadWriteCloser
+	dec        *gob.Decoder
+	mu         sync.Mutex // guards enc and encBuf against delayed writes
+	enc        *gob.Encoder
+	encBuf     *bufio.Writer
+}
+
+func (c *faultClientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	action := decide(RPCCall{ServiceMethod: r.ServiceMethod, RemoteAddr: c.remoteAddr})
+	if action.Drop {
+		return ErrInjectedFault
+	}
+	req := *r
+	write := func() error {
+		for i := 0; i <= action.Duplicate; i++ {
+			if err := c.write(&req, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if action.Delay > 0 {
+		go func() {
+			time.Sleep(action.Delay)
+			if write() != nil {
+				c.conn.Close()
+			}
+		}()
+		return nil
+	}
+	return write()
+}
+
+func (c *faultClientCodec) write(r *rpc.Request, body interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(r); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *faultClientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *faultClientCodec) ReadResponseBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *faultClientCodec) Close() error {
+	return c.conn.Close()
+}
+
+// faultServerCodec is net/rpc's gob server codec with faults applied to replies
+type faultServerCodec struct {
+	conn   net.Conn
+	dec    *gob.Decoder
+	mu     sync.Mutex // guards enc and encBuf against delayed writes
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+}
+
+func (c *faultServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *faultServerCodec) ReadRequestBody(body interface{}) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	setCaller(body, c.conn)
+	return nil
+}
+
+func (c *faultServerCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	action := decide(RPCCall{ServiceMethod: r.ServiceMethod, RemoteAddr: c.conn.RemoteAddr().String(), Server: true})
+	if action.Drop {
+		return c.conn.Close()
+	}
+	if action.Delay > 0 {
+		resp := *r
+		go func() {
+			time.Sleep(action.Delay)
+			if c.write(&resp, body) != nil {
+				c.conn.Close()
+			}
+		}()
+		return nil
+	}
+	return c.write(r, body)
+}
+
+func (c *faultServerCodec) write(r *rpc.Response, body interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(r); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *faultServerCodec) Close() error {
+	return c.conn.Close()
+}
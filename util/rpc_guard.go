@@ -0,0 +1,92 @@
+package util
+
+import (
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrHandlerPanic is returned to the caller of an RPC whose handler panicked
+var ErrHandlerPanic = errors.New("rpc: internal error while handling the request")
+
+// RPCGuard keeps a node's RPC handlers from taking the node down: a panicking handler fails its call instead
+// of crashing the process, every failed request is logged with its duration, and at most a fixed number of
+// requests are handled at once (the others wait). net/rpc has no interceptors, so each handler starts with
+//
+//	defer guard.Handle("Service.Method", &err)()
+type RPCGuard struct {
+	slots chan struct{} // one taken per request being handled. nil for no limit
+
+	mu     sync.Mutex
+	active int // requests being handled, not counting those passed to Track
+}
+
+// NewRPCGuard returns a guard handling at most maxConcurrent requests at once, or any number if 0
+func NewRPCGuard(maxConcurrent int) *RPCGuard {
+	g := &RPCGuard{}
+	if maxConcurrent > 0 {
+		g.slots = make(chan struct{}, maxConcurrent)
+	}
+	return g
+}
+
+// Handle waits for a free slot and returns the function the handler of method defers. It recovers from a
+// panic by setting *err to ErrHandlerPanic, logs the request if it failed and frees the slot.
+func (g *RPCGuard) Handle(method string, err *error) func() {
+	return g.handle(method, err, true)
+}
+
+// Track is Handle without the limit, for requests that spend their time waiting, e.g. long polls
+func (g *RPCGuard) Track(method string, err *error) func() {
+	return g.handle(method, err, false)
+}
+
+// Idle waits until no request passed to Handle is being handled, or gives up after timeout. It returns whether
+// all requests finished.
+func (g *RPCGuard) Idle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		g.mu.Lock()
+		active := g.active
+		g.mu.Unlock()
+		if active == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (g *RPCGuard) handle(method string, err *error, counted bool) func() {
+	start := time.Now()
+	limited := counted && g.slots != nil
+	if limited {
+		g.slots <- struct{}{}
+	}
+	if counted {
+		g.mu.Lock()
+		g.active++
+		g.mu.Unlock()
+	}
+	return func() {
+		if limited {
+			<-g.slots
+		}
+		if r := recover(); r != nil {
+			log.Printf("[ERROR] RPC %s panicked: %v\n%s", method, r, debug.Stack())
+			*err = ErrHandlerPanic
+		}
+		if *err != nil {
+			log.Printf("[WARN] RPC %s failed in %v: %v\n", method, time.Since(start), *err)
+		}
+		if counted {
+			g.mu.Lock()
+			g.active--
+			g.mu.Unlock()
+		}
+	}
+}
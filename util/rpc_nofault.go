@@ -0,0 +1,23 @@
+//go:build !faultinject
+// +build !faultinject
+
+package util
+
+import (
+	"net"
+	"net/rpc"
+)
+
+func newRPCClient(conn net.Conn, remoteIpPort string) *rpc.Client {
+	return rpc.NewClient(conn)
+}
+
+func serveRPC(server *rpc.Server, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.ServeCodec(newCallerServerCodec(conn))
+	}
+}
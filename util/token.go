@@ -0,0 +1,139 @@
+package util
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// An RPC server can be guarded by a token, e.g. the admin APIs of coord and miners. A client of such a server
+// opens every connection with tokenMagic, the length of the token and the token, before the compression
+// handshake. The server closes connections that do not start with its token before serving any call. The token
+// is not encrypted, so it keeps out callers that do not know it, not ones that can read the traffic
+
+const tokenMagic = 0x9d // neither a gob byte count nor handshakeMagic
+
+// ErrBadToken is why a token-guarded server closed a connection
+var ErrBadToken = errors.New("RPC token mismatch")
+
+// DialRPCWithToken connects to the RPC server at remoteIpPort that is guarded by token
+func DialRPCWithToken(remoteIpPort string, token string) (*rpc.Client, error) {
+	dial := func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", remoteIpPort)
+		if err != nil {
+			return nil, err
+		}
+		if err = writeToken(conn, token); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	conn, err := dialNegotiated(remoteIpPort, dial, dial)
+	if err != nil {
+		return nil, err
+	}
+	return newRPCClient(limitConn(conn), remoteIpPort), nil
+}
+
+// ListenRPCWithToken is ListenRPC serving only clients that send token, see DialRPCWithToken. Any client is
+// served if token is empty
+func ListenRPCWithToken(handler interface{}, listenIpPort string, token string) (net.Listener, error) {
+	apiHandler := rpc.NewServer()
+	err := apiHandler.Register(handler)
+	if err != nil {
+		return nil, errors.New("error registering API")
+	}
+	lAddr, err := net.ResolveTCPAddr("tcp", listenIpPort)
+	if err != nil {
+		return nil, errors.New("cannot resolve address " + listenIpPort)
+	}
+	listener, err := net.ListenTCP("tcp", lAddr)
+	if err != nil {
+		return nil, errors.New("cannot listen at " + listenIpPort)
+	}
+	go serveRPC(apiHandler, limitListener{negotiatingListener{guardListener(listener, token)}})
+	return listener, nil
+}
+
+func writeToken(conn net.Conn, token string) error {
+	if len(token) > 255 {
+		return errors.New("RPC token is longer than 255 bytes")
+	}
+	_, err := conn.Write(append([]byte{tokenMagic, byte(len(token))}, token...))
+	return err
+}
+
+// guardListener returns listener checking the token of every connection. listener itself if token is empty
+func guardListener(listener net.Listener, token string) net.Listener {
+	if token == "" {
+		return listener
+	}
+	return tokenListener{Listener: listener, token: token}
+}
+
+type tokenListener struct {
+	net.Listener
+	token string
+}
+
+// Accept does not wait for the token, so a client that sends nothing cannot hold up the others
+func (l tokenListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenConn{Conn: conn, token: l.token}, nil
+}
+
+type tokenConn struct {
+	net.Conn
+	token string
+	once  sync.Once
+	err   error
+}
+
+// check reads the token the client opened with and closes the connection if it is not the server's
+func (c *tokenConn) check() {
+	c.Conn.SetReadDeadline(time.Now().Add(HandshakeTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	head := make([]byte, 2)
+	if _, c.err = io.ReadFull(c.Conn, head); c.err != nil {
+		c.Conn.Close()
+		return
+	}
+	if head[0] != tokenMagic {
+		c.err = ErrBadToken
+		c.Conn.Close()
+		return
+	}
+	token := make([]byte, head[1])
+	if _, c.err = io.ReadFull(c.Conn, token); c.err != nil {
+		c.Conn.Close()
+		return
+	}
+	if subtle.ConstantTimeCompare(token, []byte(c.token)) != 1 {
+		c.err = ErrBadToken
+		c.Conn.Close()
+	}
+}
+
+func (c *tokenConn) Read(p []byte) (int, error) {
+	c.once.Do(c.check)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *tokenConn) Write(p []byte) (int, error) {
+	c.once.Do(c.check)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(p)
+}
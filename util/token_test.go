@@ -0,0 +1,42 @@
+package util
+
+import (
+	"net/rpc"
+	"testing"
+)
+
+type EchoAPI struct{}
+
+func (EchoAPI) Echo(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func TestTokenGuard(t *testing.T) {
+	listener, err := ListenRPCWithToken(EchoAPI{}, "127.0.0.1:0", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	addr := listener.Addr().String()
+
+	for _, tc := range []struct {
+		name string
+		dial func() (*rpc.Client, error)
+		ok   bool
+	}{
+		{"right token", func() (*rpc.Client, error) { return DialRPCWithToken(addr, "secret") }, true},
+		{"wrong token", func() (*rpc.Client, error) { return DialRPCWithToken(addr, "guess") }, false},
+		{"no token", func() (*rpc.Client, error) { return DialRPC(addr) }, false},
+	} {
+		client, err := tc.dial()
+		if err == nil {
+			var reply string
+			err = client.Call("EchoAPI.Echo", "hi", &reply)
+			client.Close()
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: call error %v", tc.name, err)
+		}
+	}
+}